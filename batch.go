@@ -11,6 +11,7 @@ import (
 	"io"
 	"math"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -817,6 +818,12 @@ func (b *Batch) RangeKeyDeleteDeferred(startLen, endLen int) *DeferredBatchOp {
 // WAL, but not added to memtables or sstables. Log data is never indexed,
 // which makes it useful for testing WAL performance.
 //
+// Log data is written to the WAL in order with the other operations of the
+// batch, with the same durability, so it can be used to record application
+// markers, such as replication barriers, for consumers reading the WAL. Such
+// consumers see it as an entry of kind InternalKeyKindLogData when decoding
+// the WAL records with ReadBatch. It is ignored when the WAL is replayed.
+//
 // It is safe to modify the contents of the argument after LogData returns.
 func (b *Batch) LogData(data []byte, _ *WriteOptions) error {
 	origCount, origMemTableSize := b.count, b.memTableSize
@@ -1316,6 +1323,126 @@ func (r *BatchReader) Next() (kind InternalKeyKind, ukey []byte, value []byte, o
 	return kind, ukey, value, true
 }
 
+// BatchKindStats holds the number of entries of a kind in a batch, and the
+// total sizes of their keys and values.
+type BatchKindStats struct {
+	Count      uint64
+	KeyBytes   uint64
+	ValueBytes uint64
+}
+
+// BatchStats describes the entries of a batch, by kind (e.g.
+// Kinds[InternalKeyKindSet] describes the sets).
+type BatchStats struct {
+	Kinds [InternalKeyKindMax + 1]BatchKindStats
+}
+
+// Total returns the stats of the entries of all kinds.
+func (s *BatchStats) Total() BatchKindStats {
+	var t BatchKindStats
+	for _, k := range s.Kinds {
+		t.Count += k.Count
+		t.KeyBytes += k.KeyBytes
+		t.ValueBytes += k.ValueBytes
+	}
+	return t
+}
+
+// String implements fmt.Stringer, listing the kinds of entries in the batch.
+func (s BatchStats) String() string {
+	var buf strings.Builder
+	for kind, k := range s.Kinds {
+		if k.Count == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%s: %d (%s keys, %s values)", InternalKeyKind(kind), k.Count,
+			humanize.Uint64(k.KeyBytes), humanize.Uint64(k.ValueBytes))
+	}
+	return buf.String()
+}
+
+// Stats returns the stats of the entries remaining in the reader, without
+// advancing it. It returns ErrInvalidBatch if the batch is corrupt.
+func (r BatchReader) Stats() (BatchStats, error) {
+	var s BatchStats
+	for len(r) > 0 {
+		kind, ukey, value, ok := r.Next()
+		if !ok {
+			return BatchStats{}, ErrInvalidBatch
+		}
+		k := &s.Kinds[kind]
+		k.Count++
+		k.KeyBytes += uint64(len(ukey))
+		k.ValueBytes += uint64(len(value))
+	}
+	return s, nil
+}
+
+// Stats returns the stats of the entries of the batch, by kind. Unlike Count,
+// the stats include the LogData entries. It returns ErrInvalidBatch if the
+// batch is corrupt.
+func (b *Batch) Stats() (BatchStats, error) {
+	return b.Reader().Stats()
+}
+
+// AppendEntry adds an entry, as returned by BatchReader.Next, to the batch.
+// This allows building a batch by validating or transforming the entries of
+// another one, e.g. to add a prefix to all the keys, while preserving entries
+// whose values have an internal encoding, such as range keys. Entries of
+// kinds which cannot be added to a batch are rejected with an error.
+//
+// It is safe to modify the contents of the arguments after AppendEntry
+// returns.
+func (b *Batch) AppendEntry(kind InternalKeyKind, key, value []byte) error {
+	switch kind {
+	case InternalKeyKindSet:
+		return b.Set(key, value, nil)
+	case InternalKeyKindMerge:
+		return b.Merge(key, value, nil)
+	case InternalKeyKindDelete:
+		return b.Delete(key, nil)
+	case InternalKeyKindSingleDelete:
+		return b.SingleDelete(key, nil)
+	case InternalKeyKindRangeDelete:
+		return b.DeleteRange(key, value, nil)
+	case InternalKeyKindLogData:
+		return b.LogData(key, nil)
+	case InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete:
+		b.prepareDeferredKeyValueRecord(len(key), len(value), kind)
+		b.incrementRangeKeysCount()
+		deferredOp := &b.deferredOp
+		copy(deferredOp.Key, key)
+		copy(deferredOp.Value, value)
+		// Manually inline DeferredBatchOp.Finish().
+		if deferredOp.index != nil {
+			if err := deferredOp.index.Add(deferredOp.offset); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.Errorf("pebble: cannot append an entry of kind %s to a batch", kind)
+	}
+}
+
+// DecodeIngestSST decodes the key of an InternalKeyKindIngestSST entry returned
+// by BatchReader.Next. Such entries are written to the WAL by ingestions which
+// overlap the memtables, in batches containing only such entries, one per
+// ingested sstable. The entry only records the file number of the sstable,
+// which is linked into the data directory under the name of the table with
+// that file number (e.g. 000123.sst), so its key range can be read from the
+// sstable (e.g. with sstable.NewReader) until it is compacted away.
+func DecodeIngestSST(ukey []byte) (FileNum, error) {
+	fileNum, n := binary.Uvarint(ukey)
+	if n <= 0 || n != len(ukey) {
+		return 0, base.CorruptionErrorf("pebble: invalid ingested sstable file number")
+	}
+	return base.FileNum(fileNum), nil
+}
+
 // Note: batchIter mirrors the implementation of flushableBatchIter. Keep the
 // two in sync.
 type batchIter struct {
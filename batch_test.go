@@ -171,6 +171,26 @@ func TestBatchIngestSST(t *testing.T) {
 	require.Equal(t, int(b.Count()), 2)
 	require.Equal(t, int(b.memTableSize), 0)
 	require.Equal(t, b.ingestedSSTBatch, true)
+
+	// The file numbers can be decoded from the batch representation.
+	b.ingestSST(1 << 40)
+	var fileNums []FileNum
+	r, count := ReadBatch(b.Repr())
+	for len(r) > 0 {
+		kind, ukey, _, ok := r.Next()
+		require.True(t, ok)
+		require.Equal(t, InternalKeyKindIngestSST, kind)
+		fileNum, err := DecodeIngestSST(ukey)
+		require.NoError(t, err)
+		fileNums = append(fileNums, fileNum)
+	}
+	require.EqualValues(t, 3, count)
+	require.Equal(t, []FileNum{1, 2, 1 << 40}, fileNums)
+
+	for _, ukey := range [][]byte{nil, {0x80}, {1, 2}} {
+		_, err := DecodeIngestSST(ukey)
+		require.True(t, errors.Is(err, ErrCorruption))
+	}
 }
 
 func TestBatchLen(t *testing.T) {
@@ -922,6 +942,63 @@ func TestBatchRangeOps(t *testing.T) {
 	})
 }
 
+func TestBatchStatsAndAppendEntry(t *testing.T) {
+	b := newBatch(nil)
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.Set([]byte("bb"), []byte("22"), nil))
+	require.NoError(t, b.Merge([]byte("c"), []byte("3"), nil))
+	require.NoError(t, b.Delete([]byte("d"), nil))
+	require.NoError(t, b.SingleDelete([]byte("e"), nil))
+	require.NoError(t, b.DeleteRange([]byte("f"), []byte("g"), nil))
+	require.NoError(t, b.RangeKeySet([]byte("h"), []byte("i"), []byte("@1"), []byte("v"), nil))
+	require.NoError(t, b.RangeKeyUnset([]byte("j"), []byte("k"), []byte("@2"), nil))
+	require.NoError(t, b.RangeKeyDelete([]byte("l"), []byte("m"), nil))
+	require.NoError(t, b.LogData([]byte("data"), nil))
+
+	stats, err := b.Stats()
+	require.NoError(t, err)
+	require.Equal(t, BatchKindStats{Count: 2, KeyBytes: 3, ValueBytes: 3}, stats.Kinds[InternalKeyKindSet])
+	require.Equal(t, BatchKindStats{Count: 1, KeyBytes: 4}, stats.Kinds[InternalKeyKindLogData])
+	require.Equal(t, uint64(10), stats.Total().Count)
+	require.Equal(t, "DEL: 1 (1 B keys, 0 B values), SET: 2 (3 B keys, 3 B values), "+
+		"MERGE: 1 (1 B keys, 1 B values), LOGDATA: 1 (4 B keys, 0 B values), "+
+		"SINGLEDEL: 1 (1 B keys, 0 B values), RANGEDEL: 1 (1 B keys, 1 B values), "+
+		"RANGEKEYDEL: 1 (1 B keys, 1 B values), RANGEKEYUNSET: 1 (1 B keys, 5 B values), "+
+		"RANGEKEYSET: 1 (1 B keys, 7 B values)", stats.String())
+
+	// Copying the entries of the batch yields the same batch.
+	copied := newIndexedBatch(nil, DefaultComparer)
+	for r := b.Reader(); len(r) > 0; {
+		kind, key, value, ok := r.Next()
+		require.True(t, ok)
+		require.NoError(t, copied.AppendEntry(kind, key, value))
+	}
+	require.Equal(t, b.Repr(), copied.Repr())
+	require.Equal(t, b.memTableSize, copied.memTableSize)
+	require.Equal(t, b.countRangeDels, copied.countRangeDels)
+	require.Equal(t, b.countRangeKeys, copied.countRangeKeys)
+	var keys []string
+	iter := copied.newInternalIter(nil)
+	for k, _ := iter.First(); k != nil; k, _ = iter.Next() {
+		keys = append(keys, string(k.UserKey))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"a", "bb", "c", "d", "e"}, keys)
+	rangeKeyIter := copied.newRangeKeyIter(nil, math.MaxUint64)
+	var spans []string
+	for s := rangeKeyIter.First(); s != nil; s = rangeKeyIter.Next() {
+		spans = append(spans, fmt.Sprintf("%s-%s", s.Start, s.End))
+	}
+	require.NoError(t, rangeKeyIter.Close())
+	require.Equal(t, []string{"h-i", "j-k", "l-m"}, spans)
+
+	require.Error(t, copied.AppendEntry(InternalKeyKindIngestSST, []byte("1"), nil))
+
+	// A corrupt batch is reported.
+	_, err = BatchReader(b.Repr()[batchHeaderLen : len(b.Repr())-1]).Stats()
+	require.ErrorIs(t, err, ErrInvalidBatch)
+}
+
 func TestBatchTooLarge(t *testing.T) {
 	var b Batch
 	var result interface{}
@@ -0,0 +1,143 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// BufferAllocator allocates the buffers that iterators and compactions use to
+// hold copies of keys and values, which would otherwise be short-lived
+// allocations. See Options.Experimental.BufferAllocator.
+//
+// Implementations must be safe for concurrent use.
+type BufferAllocator interface {
+	// Alloc returns a buffer of length zero and a capacity of at least n
+	// bytes.
+	Alloc(n int) []byte
+	// Free releases a buffer which is no longer used. The buffer may have been
+	// returned by Alloc or, less commonly, allocated by the Go runtime, in
+	// which case the allocator may either reuse it or drop it. The buffer must
+	// not be used after it is freed.
+	Free(b []byte)
+}
+
+const (
+	// minPooledBufferShift and maxPooledBufferShift bound the sizes of the
+	// buffers kept in the default buffer pool to [256B, 1MB]. Smaller
+	// buffers are cheap to allocate, and larger ones are rare enough that
+	// keeping them around is not worth their memory.
+	minPooledBufferShift = 8
+	maxPooledBufferShift = 20
+	numPooledBufferSizes = maxPooledBufferShift - minPooledBufferShift + 1
+)
+
+// defaultBufferPool is the BufferAllocator used when
+// Options.Experimental.BufferAllocator is not set.
+var defaultBufferPool = &bufferPool{}
+
+// pooledBuffer holds a buffer while it is in a bufferPool. Buffers are pooled
+// through these holders, which are themselves pooled, to avoid the
+// allocation of boxing a slice in an interface when it is added to a
+// sync.Pool.
+type pooledBuffer struct {
+	b []byte
+}
+
+// bufferPool is a BufferAllocator which keeps freed buffers in sync.Pools,
+// one per power-of-two size class.
+type bufferPool struct {
+	classes [numPooledBufferSizes]sync.Pool
+	holders sync.Pool
+}
+
+var _ BufferAllocator = (*bufferPool)(nil)
+
+// sizeClass returns the index of the smallest size class holding buffers of
+// at least n bytes.
+func sizeClass(n int) int {
+	if n <= 1<<minPooledBufferShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minPooledBufferShift
+}
+
+// Alloc implements BufferAllocator.
+func (p *bufferPool) Alloc(n int) []byte {
+	c := sizeClass(n)
+	if c >= numPooledBufferSizes {
+		return make([]byte, 0, n)
+	}
+	if h, _ := p.classes[c].Get().(*pooledBuffer); h != nil {
+		b := h.b
+		h.b = nil
+		p.holders.Put(h)
+		return b[:0]
+	}
+	return make([]byte, 0, 1<<(c+minPooledBufferShift))
+}
+
+// Free implements BufferAllocator.
+func (p *bufferPool) Free(b []byte) {
+	n := cap(b)
+	if n < 1<<minPooledBufferShift || n > 1<<maxPooledBufferShift {
+		return
+	}
+	// Pool the buffer in the largest class it can serve, so that buffers that
+	// were not allocated by Alloc are usable.
+	c := bits.Len(uint(n)) - 1 - minPooledBufferShift
+	h, _ := p.holders.Get().(*pooledBuffer)
+	if h == nil {
+		h = &pooledBuffer{}
+	}
+	h.b = b[:0]
+	p.classes[c].Put(h)
+}
+
+// copyToBuffer copies b to buf, replacing buf by a larger buffer from the
+// allocator if it is too small, and returns the resulting buffer. b may alias
+// buf. A nil allocator means the default buffer pool.
+func copyToBuffer(a BufferAllocator, buf, b []byte) []byte {
+	if cap(buf) >= len(b) {
+		return append(buf[:0], b...)
+	}
+	if a == nil {
+		a = defaultBufferPool
+	}
+	newBuf := append(a.Alloc(len(b)), b...)
+	if buf != nil {
+		a.Free(buf)
+	}
+	return newBuf
+}
+
+// growBuffer returns buf truncated to length zero if its capacity is at least
+// n, and otherwise frees it and returns a buffer of capacity at least n from
+// the allocator. A nil allocator means the default buffer pool.
+func growBuffer(a BufferAllocator, buf []byte, n int) []byte {
+	if cap(buf) >= n {
+		return buf[:0]
+	}
+	if a == nil {
+		a = defaultBufferPool
+	}
+	if buf != nil {
+		a.Free(buf)
+	}
+	return a.Alloc(n)
+}
+
+// freeBuffer returns buf to the allocator, if non-nil. A nil allocator means
+// the default buffer pool.
+func freeBuffer(a BufferAllocator, buf []byte) {
+	if buf == nil {
+		return
+	}
+	if a == nil {
+		a = defaultBufferPool
+	}
+	a.Free(buf)
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferPoolSizeClass(t *testing.T) {
+	for _, tc := range []struct {
+		n     int
+		class int
+	}{
+		{0, 0},
+		{1, 0},
+		{256, 0},
+		{257, 1},
+		{512, 1},
+		{513, 2},
+		{1 << 20, numPooledBufferSizes - 1},
+		{1<<20 + 1, numPooledBufferSizes},
+	} {
+		require.Equal(t, tc.class, sizeClass(tc.n), "n=%d", tc.n)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	p := &bufferPool{}
+	for _, n := range []int{0, 1, 100, 256, 257, 1000, 1 << 20, 1<<20 + 1} {
+		b := p.Alloc(n)
+		require.Len(t, b, 0)
+		require.GreaterOrEqual(t, cap(b), n)
+		p.Free(b)
+	}
+
+	// A freed buffer can serve any request of a size up to the largest power of
+	// two not greater than its capacity.
+	p.Free(make([]byte, 10, 1000))
+	b := p.Alloc(512)
+	require.Len(t, b, 0)
+	require.GreaterOrEqual(t, cap(b), 512)
+
+	buf := copyToBuffer(p, nil, []byte("foo"))
+	require.Equal(t, []byte("foo"), buf)
+	long := bytes.Repeat([]byte("x"), 300)
+	buf = copyToBuffer(p, buf, long)
+	require.Equal(t, long, buf)
+	// The source of the copy may alias the destination.
+	buf = copyToBuffer(p, buf, buf[:10])
+	require.Equal(t, long[:10], buf)
+	buf = growBuffer(p, buf, 2000)
+	require.Len(t, buf, 0)
+	require.GreaterOrEqual(t, cap(buf), 2000)
+	freeBuffer(p, buf)
+	freeBuffer(p, nil)
+}
+
+// countingAllocator is a BufferAllocator that counts the buffers it allocates
+// and frees.
+type countingAllocator struct {
+	mu          sync.Mutex
+	allocs      int
+	frees       int
+	allocated   map[*byte]struct{}
+	freedOthers int
+}
+
+func (a *countingAllocator) Alloc(n int) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.allocs++
+	b := make([]byte, 0, n)
+	if n > 0 {
+		a.allocated[&b[:1][0]] = struct{}{}
+	}
+	return b
+}
+
+func (a *countingAllocator) Free(b []byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cap(b) == 0 {
+		return
+	}
+	if _, ok := a.allocated[&b[:1][0]]; !ok {
+		a.freedOthers++
+		return
+	}
+	delete(a.allocated, &b[:1][0])
+	a.frees++
+}
+
+func TestCustomBufferAllocator(t *testing.T) {
+	a := &countingAllocator{allocated: make(map[*byte]struct{})}
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.BufferAllocator = a
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	// Use keys and values large enough to need buffers larger than those
+	// cached with the iterators.
+	key := func(i int) []byte {
+		return append(bytes.Repeat([]byte("k"), maxKeyBufCacheSize), fmt.Sprintf("%04d", i)...)
+	}
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Merge(key(i), value, nil))
+		require.NoError(t, d.Merge(key(i), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Merge(key(i), value, nil))
+	}
+	require.NoError(t, d.Compact(key(0), key(100), false /* parallelize */))
+
+	iter := d.NewIter(nil)
+	var n int
+	for iter.First(); iter.Valid(); iter.Next() {
+		require.Len(t, iter.Value(), 3*len(value))
+		n++
+	}
+	require.Equal(t, 100, n)
+	for iter.Last(); iter.Valid(); iter.Prev() {
+		require.Len(t, iter.Value(), 3*len(value))
+	}
+	require.NoError(t, iter.Close())
+	require.NoError(t, d.Close())
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	require.Greater(t, a.allocs, 0)
+	require.Zero(t, a.freedOthers)
+	// All the buffers were returned to the allocator.
+	require.Equal(t, a.allocs, a.frees)
+	require.Empty(t, a.allocated)
+}
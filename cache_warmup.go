@@ -0,0 +1,252 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// cacheStateMagic is the contents of the first record of a cache state file.
+const cacheStateMagic = "pebble.cache-state.v1"
+
+// SaveCacheState records the blocks of the DB's sstables that are currently in
+// the block cache to the file at the given path on Options.FS, replacing any
+// existing file. The blocks can be read back into the cache by a later
+// instance of the DB, by setting Options.CacheWarmupFile to that path.
+//
+// The file is made of records in the format of the record package. The first
+// record holds a magic string; each of the following records holds the
+// uvarint-encoded file number of a table, its number of cached blocks, and the
+// delta-encoded offsets of the blocks. Tables with the most recently used
+// blocks come first.
+func (d *DB) SaveCacheState(path string) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+
+	// Only record the blocks of the tables of the current version.
+	live := make(map[FileNum]struct{})
+	rs := d.loadReadState()
+	for _, l := range rs.current.Levels {
+		iter := l.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			live[f.FileNum] = struct{}{}
+		}
+	}
+	rs.unref()
+
+	var fileNums []FileNum
+	blocks := make(map[FileNum][]uint64)
+	d.opts.Cache.ForEachBlock(d.cacheID, func(fileNum FileNum, offset uint64) {
+		if _, ok := live[fileNum]; !ok {
+			return
+		}
+		if _, ok := blocks[fileNum]; !ok {
+			fileNums = append(fileNums, fileNum)
+		}
+		blocks[fileNum] = append(blocks[fileNum], offset)
+	})
+
+	tmpPath := path + ".tmp"
+	f, err := d.opts.FS.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := record.NewWriter(f)
+	err = writeCacheStateRecord(w, []byte(cacheStateMagic))
+	var buf []byte
+	for _, fileNum := range fileNums {
+		if err != nil {
+			break
+		}
+		offsets := blocks[fileNum]
+		sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+		buf = binary.AppendUvarint(buf[:0], uint64(fileNum))
+		buf = binary.AppendUvarint(buf, uint64(len(offsets)))
+		var prev uint64
+		for _, off := range offsets {
+			buf = binary.AppendUvarint(buf, off-prev)
+			prev = off
+		}
+		err = writeCacheStateRecord(w, buf)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	err = firstError(err, f.Close())
+	if err == nil {
+		err = d.opts.FS.Rename(tmpPath, path)
+	}
+	if err != nil {
+		_ = d.opts.FS.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func writeCacheStateRecord(w *record.Writer, data []byte) error {
+	rw, err := w.Next()
+	if err != nil {
+		return err
+	}
+	_, err = rw.Write(data)
+	return err
+}
+
+// readCacheState reads a cache state file written by SaveCacheState, calling
+// fn with the file number and block offsets of each table it records. It stops
+// if fn returns false.
+func readCacheState(r io.Reader, fn func(fileNum FileNum, offsets []uint64) bool) error {
+	rr := record.NewReader(r, 0 /* logNum */)
+	for first := true; ; first = false {
+		rec, err := rr.Next()
+		if err == io.EOF {
+			if first {
+				return errors.New("pebble: empty cache state file")
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(rec)
+		if err != nil {
+			return err
+		}
+		if first {
+			if string(data) != cacheStateMagic {
+				return errors.New("pebble: invalid cache state file")
+			}
+			continue
+		}
+
+		fileNum, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("pebble: corrupt cache state file")
+		}
+		data = data[n:]
+		count, n := binary.Uvarint(data)
+		if n <= 0 || count > uint64(len(data)) {
+			return errors.New("pebble: corrupt cache state file")
+		}
+		data = data[n:]
+		offsets := make([]uint64, count)
+		var prev uint64
+		for i := range offsets {
+			delta, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errors.New("pebble: corrupt cache state file")
+			}
+			data = data[n:]
+			prev += delta
+			offsets[i] = prev
+		}
+		if !fn(FileNum(fileNum), offsets) {
+			return nil
+		}
+	}
+}
+
+// warmCache reads the blocks recorded in Options.CacheWarmupFile into the
+// block cache. It runs in the background after the DB is opened, and stops
+// early if the DB is closed or the block cache is full.
+func (d *DB) warmCache() {
+	defer func() {
+		d.mu.Lock()
+		d.mu.cacheWarmup.warming = false
+		d.mu.cacheWarmup.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+
+	path := d.opts.CacheWarmupFile
+	f, err := d.opts.FS.Open(path)
+	if oserror.IsNotExist(err) {
+		return
+	} else if err != nil {
+		d.opts.Logger.Infof("pebble: unable to open cache warm-up file %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+
+	files := make(map[FileNum]*fileMetadata)
+	rs := d.loadReadState()
+	defer rs.unref()
+	for _, l := range rs.current.Levels {
+		iter := l.Iter()
+		for m := iter.First(); m != nil; m = iter.Next() {
+			files[m.FileNum] = m
+		}
+	}
+
+	start := time.Now()
+	var numTables, numBlocks int
+	err = readCacheState(f, func(fileNum FileNum, offsets []uint64) bool {
+		if d.closed.Load() != nil || d.opts.Cache.Size() >= d.opts.Cache.MaxSize() {
+			return false
+		}
+		m, ok := files[fileNum]
+		if !ok {
+			return true
+		}
+		err := d.tableCache.withReader(m, func(r *sstable.Reader) error {
+			n, err := r.WarmBlocks(context.Background(), offsets)
+			numBlocks += n
+			return err
+		})
+		if err != nil {
+			d.opts.Logger.Infof("pebble: unable to warm up cache with table %s: %v", fileNum, err)
+		}
+		numTables++
+		return true
+	})
+	if err != nil {
+		d.opts.Logger.Infof("pebble: unable to read cache warm-up file %s: %v", path, err)
+	}
+	d.opts.Logger.Infof("pebble: cache warm-up read %d blocks of %d tables in %s",
+		numBlocks, numTables, time.Since(start).Round(time.Millisecond))
+}
+
+// prefetchRange reads the blocks of the sstables of the given read state which
+// may contain keys in the range [start, end) into the block cache (see
+// Iterator.PrefetchRange). It unrefs the read state once done. The
+// d.mu.cacheWarmup.prefetches counter must have been incremented by the
+// caller.
+func (d *DB) prefetchRange(rs *readState, start, end []byte) {
+	defer func() {
+		rs.unref()
+		d.mu.Lock()
+		d.mu.cacheWarmup.prefetches--
+		d.mu.cacheWarmup.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+
+	for level := range rs.current.Levels {
+		overlaps := rs.current.Overlaps(level, d.cmp, start, end, true /* exclusiveEnd */)
+		iter := overlaps.Iter()
+		for m := iter.First(); m != nil; m = iter.Next() {
+			if d.closed.Load() != nil {
+				return
+			}
+			err := d.tableCache.withReader(m, func(r *sstable.Reader) error {
+				_, err := r.PrefetchRange(context.Background(), start, end)
+				return err
+			})
+			if err != nil {
+				d.opts.Logger.Infof("pebble: unable to prefetch blocks of table %s: %v", m.FileNum, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheWarmup(t *testing.T) {
+	mem := vfs.NewMem()
+	open := func(warmupFile string) *DB {
+		c := NewCache(64 << 20)
+		defer c.Unref()
+		opts := &Options{
+			Cache:           c,
+			CacheWarmupFile: warmupFile,
+			FS:              mem,
+			Levels:          []LevelOptions{{BlockSize: 256}},
+		}
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		// Wait for the warm-up to complete.
+		d.mu.Lock()
+		for d.mu.cacheWarmup.warming {
+			d.mu.cacheWarmup.cond.Wait()
+		}
+		d.mu.Unlock()
+		return d
+	}
+	scan := func(d *DB) {
+		iter := d.NewIter(nil)
+		var n int
+		for iter.First(); iter.Valid(); iter.Next() {
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 1000, n)
+	}
+
+	// A missing warm-up file is ignored.
+	d := open("cache-state")
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%04d", i)), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	scan(d)
+	saved := d.Metrics().BlockCacheDB.Count
+	require.Greater(t, saved, int64(100))
+	require.NoError(t, d.SaveCacheState("cache-state"))
+	require.NoError(t, d.Close())
+
+	// Without a warm-up file, the cache starts empty.
+	d = open("")
+	require.EqualValues(t, 0, d.Metrics().BlockCacheDB.Count)
+	require.NoError(t, d.Close())
+
+	// With the warm-up file, the blocks are read back into the cache, and a
+	// scan of the DB doesn't miss in the cache.
+	d = open("cache-state")
+	m := d.Metrics().BlockCacheDB
+	require.Equal(t, saved, m.Count)
+	scan(d)
+	require.Equal(t, m.Misses, d.Metrics().BlockCacheDB.Misses)
+	require.NoError(t, d.Close())
+
+	// An invalid warm-up file doesn't prevent the DB from being opened.
+	f, err := mem.Create("cache-state")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("foo"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	d = open("cache-state")
+	scan(d)
+	require.NoError(t, d.Close())
+}
+
+func TestCacheStateRoundtrip(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.NoError(t, d.SaveCacheState("empty"))
+	f, err := d.opts.FS.Open("empty")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, readCacheState(f, func(FileNum, []uint64) bool {
+		t.Fatal("unexpected table in empty cache state")
+		return false
+	}))
+	_, err = d.opts.FS.Stat("empty.tmp")
+	require.Error(t, err)
+}
+
+func TestIteratorPrefetchRange(t *testing.T) {
+	mem := vfs.NewMem()
+	open := func() *DB {
+		c := NewCache(64 << 20)
+		defer c.Unref()
+		d, err := Open("", &Options{
+			Cache:  c,
+			FS:     mem,
+			Levels: []LevelOptions{{BlockSize: 256, IndexBlockSize: 256}},
+		})
+		require.NoError(t, err)
+		return d
+	}
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("key%04d", i))
+	}
+	// scan scans the keys in [start, end), and returns the number of block
+	// cache misses.
+	scan := func(d *DB, start, end int) int64 {
+		misses := d.Metrics().BlockCacheDB.Misses
+		iter := d.NewIter(&IterOptions{LowerBound: key(start), UpperBound: key(end)})
+		var n int
+		for iter.First(); iter.Valid(); iter.Next() {
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, end-start, n)
+		return d.Metrics().BlockCacheDB.Misses - misses
+	}
+
+	d := open()
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set(key(i), value, nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+
+	// Reopen the DB with an empty cache, and prefetch a range.
+	d = open()
+	iter := d.NewIter(&IterOptions{UpperBound: key(400)})
+	iter.PrefetchRange(key(100), key(500))
+	require.NoError(t, iter.Close())
+	d.mu.Lock()
+	for d.mu.cacheWarmup.prefetches > 0 {
+		d.mu.cacheWarmup.cond.Wait()
+	}
+	d.mu.Unlock()
+
+	// The scans of the prefetched range don't miss in the cache, while those
+	// beyond the bounds of the iterator do.
+	require.Zero(t, scan(d, 100, 400))
+	require.NotZero(t, scan(d, 400, 500))
+	require.NotZero(t, scan(d, 0, 100))
+	require.NoError(t, d.Close())
+}
@@ -188,6 +188,7 @@ func (d *DB) Checkpoint(
 	manifestFileNum := d.mu.versions.manifestFileNum
 	manifestSize := d.mu.versions.manifest.Size()
 	optionsFileNum := d.optionsFileNum
+	bytesPerSync := d.opts.BytesPerSync
 
 	// Release the manifest and DB.mu so we don't block other operations on
 	// the database.
@@ -198,7 +199,7 @@ func (d *DB) Checkpoint(
 	// vfs.NewSyncingFile.
 	fs := vfs.NewSyncingFS(d.opts.FS, vfs.SyncingFileOptions{
 		NoSyncOnClose: d.opts.NoSyncOnClose,
-		BytesPerSync:  d.opts.BytesPerSync,
+		BytesPerSync:  bytesPerSync,
 	})
 
 	// Create the dir and its parents (if necessary), and sync them.
@@ -8,9 +8,13 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors/oserror"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -131,3 +135,194 @@ func TestCleaner(t *testing.T) {
 		}
 	})
 }
+
+func TestObsoleteFileDeletionFilter(t *testing.T) {
+	mem := vfs.NewMem()
+	var veto atomic.Bool
+	veto.Store(true)
+	var mu sync.Mutex
+	var vetoed []FileNum
+	opts := &Options{FS: mem}
+	opts.Experimental.ObsoleteFileDeletionFilter = func(info ObsoleteFileInfo) bool {
+		if info.FileType != FileTypeTable || !veto.Load() {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		vetoed = append(vetoed, info.FileNum)
+		return false
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+
+	// The flushed tables are obsolete, but their deletion was vetoed.
+	d.TestOnlyWaitForCleaning()
+	mu.Lock()
+	obsolete := append([]FileNum(nil), vetoed...)
+	mu.Unlock()
+	require.Len(t, obsolete, 2)
+	for _, fileNum := range obsolete {
+		_, err := mem.Stat(base.MakeFilename(base.FileTypeTable, fileNum))
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(2), d.Metrics().Table.ObsoleteCount)
+
+	// The deletion is retried by the next file cleaning operation.
+	veto.Store(false)
+	require.NoError(t, d.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, d.Flush())
+	d.TestOnlyWaitForCleaning()
+	for _, fileNum := range obsolete {
+		_, err := mem.Stat(base.MakeFilename(base.FileTypeTable, fileNum))
+		require.True(t, oserror.IsNotExist(err))
+	}
+	require.Equal(t, int64(0), d.Metrics().Table.ObsoleteCount)
+}
+
+func TestObsoleteFileDeletionFilterWALRecycling(t *testing.T) {
+	mem := vfs.NewMem()
+	var veto atomic.Bool
+	veto.Store(true)
+	var mu sync.Mutex
+	vetoed := make(map[FileNum]struct{})
+	var recycled []FileNum
+	opts := &Options{FS: mem}
+	opts.Experimental.ObsoleteFileDeletionFilter = func(info ObsoleteFileInfo) bool {
+		if info.FileType != FileTypeLog || !veto.Load() {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		vetoed[info.FileNum] = struct{}{}
+		return false
+	}
+	opts.EventListener = &EventListener{
+		WALRecycled: func(info WALRecycleInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			recycled = append(recycled, info.FileNum)
+		},
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// The flushed WALs are neither deleted nor recycled while their deletion
+	// is vetoed, so their contents are not overwritten.
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	d.TestOnlyWaitForCleaning()
+	mu.Lock()
+	var obsolete []FileNum
+	for fileNum := range vetoed {
+		obsolete = append(obsolete, fileNum)
+	}
+	require.Empty(t, recycled)
+	mu.Unlock()
+	require.Len(t, obsolete, 3)
+	for _, fileNum := range obsolete {
+		_, err := mem.Stat(base.MakeFilename(fileTypeLog, fileNum))
+		require.NoError(t, err)
+	}
+
+	// Once the filter allows it, the vetoed WALs are deleted, and the WALs
+	// which become obsolete are recycled.
+	veto.Store(false)
+	require.NoError(t, d.Set([]byte("d"), []byte("d"), nil))
+	require.NoError(t, d.Flush())
+	d.TestOnlyWaitForCleaning()
+	for _, fileNum := range obsolete {
+		_, err := mem.Stat(base.MakeFilename(fileTypeLog, fileNum))
+		require.True(t, oserror.IsNotExist(err))
+	}
+	mu.Lock()
+	require.Len(t, recycled, 1)
+	require.NotContains(t, obsolete, recycled[0])
+	mu.Unlock()
+}
+
+func TestWALArchiveRetention(t *testing.T) {
+	archivedWALs := func(fs vfs.FS) []string {
+		ls, err := fs.List("archive")
+		require.NoError(t, err)
+		var wals []string
+		for _, filename := range ls {
+			if fileType, _, ok := base.ParseFilename(fs, filename); ok && fileType == fileTypeLog {
+				wals = append(wals, filename)
+			}
+		}
+		sort.Strings(wals)
+		return wals
+	}
+	open := func(retention WALArchiveRetention) (*DB, vfs.FS) {
+		mem := vfs.NewMem()
+		opts := &Options{FS: mem, Cleaner: ArchiveCleaner{}}
+		opts.Experimental.WALArchiveRetention = retention
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		return d, mem
+	}
+	// archive writes to the DB and flushes it n times, archiving n WALs.
+	archive := func(d *DB, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprint(i)), make([]byte, 1000), nil))
+			require.NoError(t, d.Flush())
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		d, fs := open(WALArchiveRetention{})
+		defer func() { require.NoError(t, d.Close()) }()
+		archive(d, 5)
+		require.Len(t, archivedWALs(fs), 5)
+	})
+
+	t.Run("max-size", func(t *testing.T) {
+		d, fs := open(WALArchiveRetention{MaxSize: 2500})
+		defer func() { require.NoError(t, d.Close()) }()
+		archive(d, 5)
+		wals := archivedWALs(fs)
+		require.Len(t, wals, 2)
+		archive(d, 1)
+		require.Equal(t, wals[1], archivedWALs(fs)[0])
+	})
+
+	t.Run("max-age", func(t *testing.T) {
+		d, fs := open(WALArchiveRetention{MaxAge: time.Hour})
+		defer func() { require.NoError(t, d.Close()) }()
+		archive(d, 3)
+		require.Len(t, archivedWALs(fs), 3)
+		d.timeNow = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		// Archiving a WAL prunes the WALs older than MaxAge, including the
+		// one just archived.
+		archive(d, 1)
+		require.Empty(t, archivedWALs(fs))
+	})
+
+	t.Run("since-last-backup", func(t *testing.T) {
+		d, fs := open(WALArchiveRetention{MaxSize: 1, SinceLastBackup: true})
+		defer func() { require.NoError(t, d.Close()) }()
+		archive(d, 3)
+		// No WAL is pruned until a backup is reported, regardless of MaxSize.
+		require.Len(t, archivedWALs(fs), 3)
+		backupTime := time.Now()
+		time.Sleep(time.Millisecond)
+		archive(d, 2)
+		require.Len(t, archivedWALs(fs), 5)
+		require.NoError(t, d.SetLastBackupTime(backupTime))
+		require.Len(t, archivedWALs(fs), 2)
+		// An earlier backup does not move the anchor back.
+		require.NoError(t, d.SetLastBackupTime(backupTime.Add(-time.Hour)))
+		require.NoError(t, d.SetLastBackupTime(time.Now()))
+		require.Empty(t, archivedWALs(fs))
+	})
+}
@@ -125,6 +125,9 @@ type commitEnv struct {
 	// The visible sequence number at which reads should be performed. Ratcheted
 	// upwards atomically as batches are applied to the memtable.
 	visibleSeqNum *uint64
+	// published, if not nil, is called with the new visible sequence number
+	// after it has been ratcheted upwards. Called concurrently.
+	published func(seqNum uint64)
 
 	// Apply the batch to the specified memtable. Called concurrently.
 	apply func(b *Batch, mem *memTable) error
@@ -238,6 +241,10 @@ type commitPipeline struct {
 	// logSyncQSem are used for this reservation.
 	commitQueueSem chan struct{}
 	logSyncQSem    chan struct{}
+	// unappliedBatches is the number of batches which were assigned a sequence
+	// number but not yet applied to the memtable. It is incremented while
+	// holding commitPipeline.mu.
+	unappliedBatches atomic.Int64
 	// The mutex to use for synchronizing access to logSeqNum and serializing
 	// calls to commitEnv.write().
 	mu sync.Mutex
@@ -314,7 +321,9 @@ func (p *commitPipeline) Commit(b *Batch, syncWAL bool, noSyncWait bool) error {
 	}
 
 	// Apply the batch to the memtable.
-	if err := p.env.apply(b, mem); err != nil {
+	err = p.env.apply(b, mem)
+	p.unappliedBatches.Add(-1)
+	if err != nil {
 		b.db = nil // prevent batch reuse on error
 		// NB: we are not doing <-p.commitQueueSem since the batch is still
 		// sitting in the pending queue. We should consider fixing this by also
@@ -388,13 +397,12 @@ func (p *commitPipeline) AllocateSeqNum(
 
 	// Wait for any outstanding writes to the memtable to complete. This is
 	// necessary for ingestion so that the check for memtable overlap can see any
-	// writes that were sequenced before the ingestion. The spin loop is
-	// unfortunate, but obviates the need for additional synchronization.
-	for {
-		visibleSeqNum := atomic.LoadUint64(p.env.visibleSeqNum)
-		if visibleSeqNum == logSeqNum {
-			break
-		}
+	// writes that were sequenced before the ingestion. Note that we do not wait
+	// for the sequence numbers of earlier calls to AllocateSeqNum to be
+	// published, so that concurrent ingestions are not serialized: they do not
+	// write to the memtable, and are ordered by DB.ingestApply. The spin loop
+	// is unfortunate, but obviates the need for additional synchronization.
+	for p.unappliedBatches.Load() != 0 {
 		runtime.Gosched()
 	}
 
@@ -446,6 +454,7 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 	// is lock-free, we want the order of batches to be the same as the sequence
 	// number order.
 	p.pending.enqueue(b)
+	p.unappliedBatches.Add(1)
 
 	// Assign the batch a sequence number. Note that we use atomic operations
 	// here to handle concurrent reads of logSeqNum. commitPipeline.mu provides
@@ -454,6 +463,10 @@ func (p *commitPipeline) prepare(b *Batch, syncWAL bool, noSyncWait bool) (*memT
 
 	// Write the data to the WAL.
 	mem, err := p.env.write(b, syncWG, syncErr)
+	if err != nil {
+		// The batch will not be applied to the memtable.
+		p.unappliedBatches.Add(-1)
+	}
 
 	p.mu.Unlock()
 
@@ -496,6 +509,9 @@ func (p *commitPipeline) publish(b *Batch) {
 			}
 			if atomic.CompareAndSwapUint64(p.env.visibleSeqNum, curSeqNum, newSeqNum) {
 				// We successfully published t's sequence number.
+				if p.env.published != nil {
+					p.env.published(newSeqNum)
+				}
 				break
 			}
 		}
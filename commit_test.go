@@ -220,6 +220,43 @@ func (f *syncDelayFile) Sync() error {
 	return nil
 }
 
+func TestSeqNumPublished(t *testing.T) {
+	var published uint64
+	d, err := Open("", &Options{
+		FS: vfs.NewMem(),
+		SeqNumPublished: func(seqNum uint64) {
+			for {
+				cur := atomic.LoadUint64(&published)
+				if seqNum <= cur || atomic.CompareAndSwapUint64(&published, cur, seqNum) {
+					return
+				}
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Commit batches concurrently. Each batch is visible when its commit
+	// returns, and its sequence number has been published.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b := d.NewBatch()
+				require.NoError(t, b.Set([]byte(fmt.Sprintf("%d-%d", i, j)), nil, nil))
+				require.NoError(t, b.Set([]byte(fmt.Sprintf("%d-%d-2", i, j)), nil, nil))
+				require.NoError(t, b.Commit(nil))
+				require.Less(t, b.SeqNum()+1, d.VisibleSeqNum())
+				require.Less(t, b.SeqNum()+1, atomic.LoadUint64(&published))
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, d.VisibleSeqNum(), atomic.LoadUint64(&published))
+}
+
 func TestCommitPipelineWALClose(t *testing.T) {
 	// This test stresses the edge case of N goroutines blocked in the
 	// commitPipeline waiting for the log to sync when we concurrently decide to
@@ -451,6 +451,7 @@ const (
 	compactionKindRead
 	compactionKindRewrite
 	compactionKindIngestedFlushable
+	compactionKindTombstoneDensity
 )
 
 func (k compactionKind) String() string {
@@ -471,6 +472,8 @@ func (k compactionKind) String() string {
 		return "rewrite"
 	case compactionKindIngestedFlushable:
 		return "ingested-flushable"
+	case compactionKindTombstoneDensity:
+		return "tombstone-density"
 	}
 	return "?"
 }
@@ -549,6 +552,9 @@ type compaction struct {
 	logger    Logger
 	version   *version
 	stats     base.InternalIteratorStats
+	// checkKeyOrder wraps each input iterator in a keyOrderCheckingIter (see
+	// Options.DebugCheckKeyOrder).
+	checkKeyOrder bool
 
 	score float64
 
@@ -1511,6 +1517,14 @@ func (c *compaction) newInputIter(
 		c.rangeDelIter.Init(c.cmp, rangeDelIters...)
 		iters = append(iters, &c.rangeDelIter)
 	}
+	if c.checkKeyOrder {
+		// Check the order of the keys of each input, as the merging iterator
+		// would hide inconsistencies by ordering the keys with the same
+		// Comparer.
+		for i := range iters {
+			iters[i] = newKeyOrderCheckingIter(iters[i], c.comparer)
+		}
+	}
 	pointKeyIter := newMergingIter(c.logger, &c.stats, c.cmp, nil, iters...)
 	if len(rangeKeyIters) > 0 {
 		mi := &keyspan.MergingIter{}
@@ -2644,13 +2658,24 @@ func (d *DB) runCompaction(
 	}()
 
 	snapshots := d.mu.snapshots.toSlice()
-	formatVers := d.mu.formatVers.vers
+	// The level options are read with d.mu held, since they may be changed by
+	// SetOptions.
+	writerOpts := d.makeWriterOptions(c.outputLevel.level, d.mu.formatVers.vers, d.mu.versions.dbID)
+	compressionDictSize := d.opts.Level(c.outputLevel.level).CompressionDictSize
+	pinned := makeSnapshotPinnedTracker(c.equal, snapshots)
+	defer func() {
+		// NB: This runs after d.mu is reacquired below.
+		if retErr == nil {
+			d.addSnapshotPinnedBytes(jobID, &pinned)
+		}
+	}()
 
 	// Release the d.mu lock while doing I/O.
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
 	defer d.mu.Lock()
 
+	c.checkKeyOrder = d.opts.DebugCheckKeyOrder
 	iiter, err := c.newInputIter(d.newIters, d.tableNewRangeKeyIter, snapshots)
 	if err != nil {
 		return nil, pendingOutputs, err
@@ -2659,6 +2684,14 @@ func (d *DB) runCompaction(
 	iter := newCompactionIter(c.cmp, c.equal, c.formatKey, d.merge, iiter, snapshots,
 		&c.rangeDelFrag, &c.rangeKeyFrag, c.allowedZeroSeqNum, c.elideTombstone,
 		c.elideRangeTombstone, d.FormatMajorVersion())
+	iter.bufAlloc = d.opts.Experimental.BufferAllocator
+	if isGarbageSuffix := d.opts.Experimental.IsGarbageSuffix; isGarbageSuffix != nil && d.opts.Comparer.Split != nil {
+		split := d.opts.Comparer.Split
+		iter.isGarbage = func(userKey []byte) bool {
+			n := split(userKey)
+			return n < len(userKey) && isGarbageSuffix(userKey[n:])
+		}
+	}
 
 	var (
 		createdFiles []base.FileNum
@@ -2704,25 +2737,7 @@ func (d *DB) runCompaction(
 		c.metrics[c.extraLevels[0].level] = &LevelMetrics{}
 	}
 
-	// The table is typically written at the maximum allowable format implied by
-	// the current format major version of the DB.
-	tableFormat := formatVers.MaxTableFormat()
-	if tableFormat > sstable.TableFormatPebblev3 {
-		// Since TableFormatPebblev3 does not currently subsume
-		// TableFormatPebblev2, this panic ensures that we have carefully thought
-		// through what we are doing before we introduce a format beyond
-		// TableFormatPebblev3.
-		panic("cannot handle table format beyond TableFormatPebblev3")
-	}
-	if tableFormat == sstable.TableFormatPebblev3 &&
-		(d.opts.Experimental.EnableValueBlocks == nil || !d.opts.Experimental.EnableValueBlocks()) {
-		tableFormat = sstable.TableFormatPebblev2
-	}
-	writerOpts := d.opts.MakeWriterOptions(c.outputLevel.level, tableFormat)
-	if formatVers < FormatBlockPropertyCollector {
-		// Cannot yet write block properties.
-		writerOpts.BlockPropertyCollectors = nil
-	}
+	tableFormat := writerOpts.TableFormat
 
 	// prevPointKey is a sstable.WriterOption that provides access to
 	// the last point key written to a writer's sstable. When a new
@@ -2734,6 +2749,25 @@ func (d *DB) runCompaction(
 	// points directly into the Writer's block buffer.
 	var prevPointKey sstable.PreviousPointKeyOpt
 	var cpuWorkHandle CPUWorkHandle
+
+	// dictSampler, if non-nil, samples the keys and values written to the
+	// outputs in order to build a compression dictionary for the output level.
+	var dictSampler *compressionDictSampler
+	if writerOpts.Compression == ZstdCompression && tableFormat >= sstable.TableFormatPebblev4 {
+		if compressionDictSize > 0 {
+			dictSampler = &compressionDictSampler{maxSize: compressionDictSize}
+		}
+	}
+	publishDict := func() {
+		if dictSampler == nil || dictSampler.published || len(dictSampler.buf) == 0 {
+			return
+		}
+		dictSampler.published = true
+		dict := dictSampler.train()
+		d.mu.Lock()
+		d.mu.compact.compressionDicts[c.outputLevel.level] = dict
+		d.mu.Unlock()
+	}
 	defer func() {
 		if cpuWorkHandle != nil {
 			d.opts.Experimental.CPUWorkPermissionGranter.CPUWorkDone(cpuWorkHandle)
@@ -2746,9 +2780,20 @@ func (d *DB) runCompaction(
 		fileNum := d.mu.versions.getNextFileNum()
 		fileMeta.FileNum = fileNum
 		pendingOutputs = append(pendingOutputs, fileMeta)
+		if dictSampler != nil {
+			writerOpts.CompressionDict = d.mu.compact.compressionDicts[c.outputLevel.level]
+		}
 		d.mu.Unlock()
 
-		writable, objMeta, err := d.objProvider.Create(context.TODO(), fileTypeTable, fileNum, objstorage.CreateOptions{} /* TODO */)
+		ioClass := vfs.IOClassCompaction
+		if c.kind == compactionKindFlush {
+			ioClass = vfs.IOClassFlush
+		}
+		ctx := vfs.ContextWithIOClass(context.TODO(), ioClass)
+		createOpts := objstorage.CreateOptions{
+			PreferSharedStorage: d.opts.Experimental.CreateOnShared && c.kind != compactionKindFlush,
+		}
+		writable, objMeta, err := d.objProvider.Create(ctx, fileTypeTable, fileNum, createOpts)
 		if err != nil {
 			return err
 		}
@@ -2798,6 +2843,11 @@ func (d *DB) runCompaction(
 	// should be flushed. Typically, this is the first key of the next
 	// sstable or an empty key if this output is the final sstable.
 	finishOutput := func(splitKey []byte) error {
+		// An error of the input iterator ends the input early. Report it
+		// rather than the output, which may be inconsistent.
+		if err := iiter.Error(); err != nil {
+			return err
+		}
 		// If we haven't output any point records to the sstable (tw == nil) then the
 		// sstable will only contain range tombstones and/or range keys. The smallest
 		// key in the sstable will be the start key of the first range tombstone or
@@ -3027,6 +3077,9 @@ func (d *DB) runCompaction(
 	if splitL0Outputs {
 		outputSplitters = append(outputSplitters, newLimitFuncSplitter(&iter.frontiers, c.findL0Limit))
 	}
+	if keyspaceEnd := d.opts.Experimental.KeyspaceEnd; keyspaceEnd != nil {
+		outputSplitters = append(outputSplitters, newLimitFuncSplitter(&iter.frontiers, keyspaceEnd))
+	}
 	splitter := &splitterGroup{cmp: c.cmp, splitters: outputSplitters}
 
 	// Each outer loop iteration produces one output file. An iteration that
@@ -3130,6 +3183,12 @@ func (d *DB) runCompaction(
 			if err := tw.Add(*key, val); err != nil {
 				return nil, pendingOutputs, err
 			}
+			pinned.add(key, val)
+			// Publish the dictionary as soon as it is complete, so that the
+			// remaining outputs of this compaction make use of it.
+			if dictSampler != nil && dictSampler.add(key.UserKey, val) {
+				publishDict()
+			}
 		}
 
 		// A splitter requested a split, and we're ready to finish the output.
@@ -3159,6 +3218,7 @@ func (d *DB) runCompaction(
 			return nil, pendingOutputs, err
 		}
 	}
+	publishDict()
 
 	for _, cl := range c.inputs {
 		iter := cl.files.Iter()
@@ -3183,6 +3243,52 @@ func (d *DB) runCompaction(
 	return ve, pendingOutputs, nil
 }
 
+// compressionDictSampleRatio is the size of the samples a
+// compressionDictSampler collects, relative to the size of the dictionary
+// trained from them.
+const compressionDictSampleRatio = 32
+
+// compressionDictSampler collects the keys and values written by a flush or
+// compaction, and trains a Zstandard compression dictionary of up to maxSize
+// bytes from them (see sstable.TrainCompressionDict), to be used to compress
+// subsequently written tables.
+type compressionDictSampler struct {
+	maxSize int
+	// samples holds one sample per record, the concatenation of its key and
+	// value, in buf.
+	samples [][]byte
+	buf     []byte
+	// published is set once a dictionary has been trained and handed off.
+	published bool
+}
+
+// add samples the given key and value. It returns true if enough samples
+// have been collected to train the dictionary.
+func (s *compressionDictSampler) add(key, value []byte) bool {
+	budget := s.maxSize * compressionDictSampleRatio
+	if s.published || len(s.buf) >= budget {
+		return false
+	}
+	if cap(s.buf) == 0 {
+		s.buf = make([]byte, 0, budget)
+	}
+	start := len(s.buf)
+	for _, b := range [2][]byte{key, value} {
+		n := budget - len(s.buf)
+		if len(b) > n {
+			b = b[:n]
+		}
+		s.buf = append(s.buf, b...)
+	}
+	s.samples = append(s.samples, s.buf[start:len(s.buf):len(s.buf)])
+	return len(s.buf) == budget
+}
+
+// train returns the dictionary trained from the samples collected so far.
+func (s *compressionDictSampler) train() []byte {
+	return sstable.TrainCompressionDict(s.samples, s.maxSize)
+}
+
 // validateVersionEdit validates that start and end keys across new and deleted
 // files in a versionEdit pass the given validation function.
 func validateVersionEdit(
@@ -3400,6 +3506,9 @@ type obsoleteFile struct {
 	fileNum  base.FileNum
 	fileType fileType
 	fileSize uint64
+	// filtered is set if Options.Experimental.ObsoleteFileDeletionFilter
+	// already allowed the deletion of the file.
+	filtered bool
 }
 
 type fileInfo struct {
@@ -3435,10 +3544,13 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	for _, table := range d.mu.versions.obsoleteTables {
 		obsoleteTables = append(obsoleteTables, fileInfo{
 			fileNum:  table.FileNum,
-			fileSize: table.Size,
+			fileSize: table.BackingSize(),
 		})
 	}
 	d.mu.versions.obsoleteTables = nil
+	if d.opts.ReadOnly {
+		d.mu.versions.updateObsoleteTableMetricsLocked()
+	}
 
 	// Sort the manifests cause we want to delete some contiguous prefix
 	// of the older manifests.
@@ -3460,6 +3572,24 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 	obsoleteOptions := d.mu.versions.obsoleteOptions
 	d.mu.versions.obsoleteOptions = nil
 
+	vetoed := d.mu.cleaner.vetoed
+	d.mu.cleaner.vetoed = nil
+
+	// MinDeletionRate and MinDeletionFileRate may be changed through
+	// SetOptions, so they must be read while d.mu is held.
+	var bytesLimiter, fileLimiter limiter
+	if d.opts.Experimental.MinDeletionRate > 0 {
+		bytesLimiter = d.deletionLimiter
+	}
+	if d.opts.Experimental.MinDeletionFileRate > 0 {
+		fileLimiter = d.deletionFileLimiter
+	}
+	pacer := (pacer)(nilPacer)
+	paceDeletions := bytesLimiter != nil || fileLimiter != nil
+	if paceDeletions {
+		pacer = newDeletionPacer(bytesLimiter, fileLimiter, d.getDeletionPacerInfo)
+	}
+
 	// Release d.mu while doing I/O
 	// Note the unusual order: Unlock and then Lock.
 	d.mu.Unlock()
@@ -3486,12 +3616,24 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 			dir := d.dirname
 			switch f.fileType {
 			case fileTypeLog:
-				if !noRecycle && d.logRecycler.add(fi) {
+				of := obsoleteFile{
+					dir:      d.walDirname,
+					fileNum:  fi.fileNum,
+					fileType: f.fileType,
+					fileSize: fi.fileSize,
+				}
+				if !noRecycle && d.recycleObsoleteLog(jobID, &of) {
 					continue
 				}
-				dir = d.walDirname
+				filesToDelete = append(filesToDelete, of)
+				continue
 			case fileTypeTable:
 				d.tableCache.evict(fi.fileNum)
+				if d.opts.ReadOnly {
+					// The sstables removed by refreshes of a DB opened with
+					// OpenRemoteReadOnly belong to the remote DB.
+					continue
+				}
 			}
 
 			filesToDelete = append(filesToDelete, obsoleteFile{
@@ -3502,29 +3644,32 @@ func (d *DB) doDeleteObsoleteFiles(jobID int) {
 			})
 		}
 	}
+	// Retry the deletion of the files whose deletion was vetoed.
+	filesToDelete = append(filesToDelete, vetoed...)
 	if len(filesToDelete) > 0 {
 		d.deleters.Add(1)
 		// Delete asynchronously if that could get held up in the pacer.
-		if d.opts.Experimental.MinDeletionRate > 0 {
-			go d.paceAndDeleteObsoleteFiles(jobID, filesToDelete)
+		if paceDeletions {
+			go d.paceAndDeleteObsoleteFiles(jobID, filesToDelete, pacer)
 		} else {
-			d.paceAndDeleteObsoleteFiles(jobID, filesToDelete)
+			d.paceAndDeleteObsoleteFiles(jobID, filesToDelete, pacer)
 		}
 	}
 }
 
 // Paces and eventually deletes the list of obsolete files passed in. db.mu
 // must NOT be held when calling this method.
-func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile) {
+func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile, pacer pacer) {
 	defer d.deleters.Done()
-	pacer := (pacer)(nilPacer)
-	if d.opts.Experimental.MinDeletionRate > 0 {
-		pacer = newDeletionPacer(d.deletionLimiter, d.getDeletionPacerInfo)
-	}
-
+	var cleanedLogs, cleanedTables bool
 	for _, of := range files {
 		path := base.MakeFilepath(d.opts.FS, of.dir, of.fileType, of.fileNum)
+		if d.vetoObsoleteFileDeletion(jobID, of, path) {
+			continue
+		}
+		cleanedLogs = cleanedLogs || of.fileType == fileTypeLog
 		if of.fileType == fileTypeTable {
+			cleanedTables = true
 			_ = pacer.maybeThrottle(of.fileSize)
 			d.mu.Lock()
 			d.mu.versions.metrics.Table.ObsoleteCount--
@@ -3535,6 +3680,69 @@ func (d *DB) paceAndDeleteObsoleteFiles(jobID int, files []obsoleteFile) {
 			d.deleteObsoleteFile(of.fileType, jobID, path, of.fileNum)
 		}
 	}
+	if cleanedTables {
+		// Remove the replicas of the deleted tables together.
+		d.objProvider.FlushReplicaRemovals()
+	}
+	if cleanedLogs {
+		if err := d.pruneWALArchive(); err != nil {
+			d.opts.EventListener.BackgroundError(err)
+		}
+	}
+}
+
+// vetoObsoleteFileDeletion returns true if the deletion of the obsolete file is
+// vetoed by Options.Experimental.ObsoleteFileDeletionFilter, in which case the
+// file is queued for the next file cleaning operation. db.mu must NOT be held
+// when calling this method.
+func (d *DB) vetoObsoleteFileDeletion(jobID int, of obsoleteFile, path string) bool {
+	filter := d.opts.Experimental.ObsoleteFileDeletionFilter
+	if filter == nil || of.filtered {
+		return false
+	}
+	if of.fileType == fileTypeTable {
+		path = ""
+		if meta, err := d.objProvider.Lookup(of.fileType, of.fileNum); err == nil {
+			path = d.objProvider.Path(meta)
+		}
+	}
+	if filter(ObsoleteFileInfo{
+		JobID:    jobID,
+		FileType: of.fileType,
+		FileNum:  of.fileNum,
+		Path:     path,
+	}) {
+		return false
+	}
+	d.mu.Lock()
+	d.mu.cleaner.vetoed = append(d.mu.cleaner.vetoed, of)
+	d.mu.Unlock()
+	return true
+}
+
+// recycleObsoleteLog attempts to keep an obsolete WAL to be reused for a new
+// WAL, which overwrites its contents. Recycling a WAL is thus subject to
+// Options.Experimental.ObsoleteFileDeletionFilter like its deletion: a WAL
+// whose deletion is vetoed is not recycled, and is deleted once the filter
+// allows it. It returns true if the WAL must not be deleted now, and sets
+// of.filtered once the filter allowed its deletion. db.mu must NOT be held
+// when calling this method.
+func (d *DB) recycleObsoleteLog(jobID int, of *obsoleteFile) bool {
+	path := base.MakeFilepath(d.opts.FS, of.dir, of.fileType, of.fileNum)
+	if d.vetoObsoleteFileDeletion(jobID, *of, path) {
+		return true
+	}
+	of.filtered = true
+	if !d.logRecycler.add(fileInfo{fileNum: of.fileNum, fileSize: of.fileSize}) {
+		return false
+	}
+	d.opts.EventListener.WALRecycled(WALRecycleInfo{
+		JobID:   jobID,
+		Path:    path,
+		FileNum: of.fileNum,
+		Size:    of.fileSize,
+	})
+	return true
 }
 
 func (d *DB) maybeScheduleObsoleteTableDeletion() {
@@ -174,6 +174,14 @@ type compactionIter struct {
 	// unsafe, i.iter-owned slice that could be altered when the iterator is
 	// advanced.
 	valueBuf []byte
+	// bufAlloc allocates keyBuf and valueBuf when they need to grow, and
+	// receives them when the iterator is closed. A nil bufAlloc means the
+	// default buffer pool.
+	bufAlloc BufferAllocator
+	// isGarbage, if set, returns true if the given user key is garbage and may
+	// be dropped where tombstones can be elided (see
+	// Options.Experimental.IsGarbageSuffix).
+	isGarbage func(userKey []byte) bool
 	// Is the current entry valid?
 	valid     bool
 	iterKey   *InternalKey
@@ -351,6 +359,14 @@ func (i *compactionIter) Next() (*InternalKey, []byte) {
 			continue
 		}
 
+		// Drop the entries of garbage keys if there is no data below the
+		// compaction that an entry of the key could shadow.
+		if i.isGarbage != nil && i.isGarbage(i.iterKey.UserKey) && i.elideTombstone(i.iterKey.UserKey) {
+			i.saveKey()
+			i.skipInStripe()
+			continue
+		}
+
 		switch i.iterKey.Kind() {
 		case InternalKeyKindDelete, InternalKeyKindSingleDelete:
 			// If we're at the last snapshot stripe and the tombstone can be elided
@@ -588,7 +604,7 @@ func (i *compactionIter) setNext() {
 	}
 
 	// We are iterating forward. Save the current value.
-	i.valueBuf = append(i.valueBuf[:0], i.iterValue...)
+	i.valueBuf = copyToBuffer(i.bufAlloc, i.valueBuf, i.iterValue)
 	i.value = i.valueBuf
 
 	// Else, we continue to loop through entries in the stripe looking for a
@@ -779,7 +795,7 @@ func (i *compactionIter) singleDeleteNext() bool {
 }
 
 func (i *compactionIter) saveKey() {
-	i.keyBuf = append(i.keyBuf[:0], i.iterKey.UserKey...)
+	i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, i.iterKey.UserKey)
 	i.key.UserKey = i.keyBuf
 	i.key.Trailer = i.iterKey.Trailer
 	i.keyTrailer = i.iterKey.Trailer
@@ -819,6 +835,9 @@ func (i *compactionIter) Close() error {
 		i.valueCloser = nil
 	}
 
+	freeBuffer(i.bufAlloc, i.keyBuf)
+	freeBuffer(i.bufAlloc, i.valueBuf)
+	i.keyBuf, i.valueBuf = nil, nil
 	return i.err
 }
 
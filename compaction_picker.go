@@ -1133,6 +1133,17 @@ func (p *compactionPickerByScore) pickFile(
 	return file, file.FileMetadata != nil
 }
 
+// l0BaseCompactionInProgress returns whether any of the given in-progress
+// compactions is an L0 -> Lbase compaction.
+func l0BaseCompactionInProgress(inProgress []compactionInfo) bool {
+	for _, info := range inProgress {
+		if len(info.inputs) > 0 && info.inputs[0].level == 0 && info.outputLevel > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // pickAuto picks the best compaction, if any.
 //
 // On each call, pickAuto computes per-level size adjustments based on
@@ -1151,18 +1162,46 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 	// debt as a second signal to prevent compaction concurrency from dropping
 	// significantly right after a base compaction finishes, and before those
 	// bytes have been compacted further down the LSM.
-	if n := len(env.inProgressCompactions); n > 0 {
+	//
+	// If a compaction slot is reserved for L0 -> Lbase compactions and none is
+	// running, an L0 -> Lbase compaction may run regardless of these signals,
+	// and other compactions may not take the last free slot.
+	n := len(env.inProgressCompactions)
+	reserveL0Base := p.opts.Experimental.ReserveL0BaseCompactionSlot &&
+		p.opts.MaxConcurrentCompactions() > 1 &&
+		!l0BaseCompactionInProgress(env.inProgressCompactions)
+	var throttled bool
+	if n > 0 {
 		l0ReadAmp := p.vers.L0Sublevels.MaxDepthAfterOngoingCompactions()
 		compactionDebt := int(p.estimatedCompactionDebt(0))
 		ccSignal1 := n * p.opts.Experimental.L0CompactionConcurrency
 		ccSignal2 := n * p.opts.Experimental.CompactionDebtConcurrency
 		if l0ReadAmp < ccSignal1 && compactionDebt < ccSignal2 {
-			return nil
+			if !reserveL0Base {
+				return nil
+			}
+			throttled = true
 		}
 	}
 
 	scores := p.calculateScores(env.inProgressCompactions)
 
+	if reserveL0Base {
+		for i := range scores {
+			if info := &scores[i]; info.level == 0 && info.score >= 1 {
+				pc = pickL0(env, p.opts, p.vers, p.baseLevel, p.diskAvailBytes)
+				if pc != nil && pc.outputLevel.level != 0 && !inputRangeAlreadyCompacting(env, pc) {
+					pc.score = info.score
+					return pc
+				}
+				pc = nil
+			}
+		}
+		if throttled || n+1 >= p.opts.MaxConcurrentCompactions() {
+			return nil
+		}
+	}
+
 	// TODO(peter): Either remove, or change this into an event sent to the
 	// EventListener.
 	logCompaction := func(pc *pickedCompaction) {
@@ -1269,6 +1308,13 @@ func (p *compactionPickerByScore) pickAuto(env compactionEnv) (pc *pickedCompact
 		return pc
 	}
 
+	// Check for files dense in point tombstones which overlap data in the
+	// level beneath. These compactions are low-priority for the same reasons,
+	// but speed up reads which have to skip over the tombstones.
+	if pc := p.pickTombstoneDensityCompaction(env); pc != nil {
+		return pc
+	}
+
 	if pc := p.pickReadTriggeredCompaction(env); pc != nil {
 		return pc
 	}
@@ -1456,6 +1502,104 @@ func (p *compactionPickerByScore) pickElisionOnlyCompaction(
 	return nil
 }
 
+// tombstoneDensityAnnotator implements the manifest.Annotator interface,
+// annotating B-Tree nodes with the *fileMetadata of the file with the highest
+// fraction of point tombstones among its entries within the subtree, among the
+// files with at least minCount point tombstones making up at least threshold
+// of their entries.
+type tombstoneDensityAnnotator struct {
+	threshold float64
+	minCount  uint64
+}
+
+var _ manifest.Annotator = tombstoneDensityAnnotator{}
+
+func (a tombstoneDensityAnnotator) Zero(interface{}) interface{} {
+	return nil
+}
+
+func (a tombstoneDensityAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (interface{}, bool) {
+	if f.IsCompacting() {
+		return dst, true
+	}
+	if !f.StatsValidLocked() {
+		return dst, false
+	}
+	if f.Stats.NumPointDeletions < a.minCount ||
+		tombstoneDensity(f) < a.threshold {
+		return dst, true
+	}
+	return a.Merge(f, dst), true
+}
+
+func (a tombstoneDensityAnnotator) Merge(v interface{}, accum interface{}) interface{} {
+	if v == nil {
+		return accum
+	}
+	if accum == nil {
+		return v
+	}
+	f, accumV := v.(*fileMetadata), accum.(*fileMetadata)
+	if tombstoneDensity(f) > tombstoneDensity(accumV) {
+		return f
+	}
+	return accumV
+}
+
+// tombstoneDensity returns the fraction of point tombstones among the entries
+// of the file. The file stats must be valid.
+func tombstoneDensity(f *fileMetadata) float64 {
+	if f.Stats.NumEntries == 0 {
+		return 0
+	}
+	return float64(f.Stats.NumPointDeletions) / float64(f.Stats.NumEntries)
+}
+
+// pickTombstoneDensityCompaction looks for a compaction of a file whose point
+// tombstones make up at least
+// Options.Experimental.TombstoneDenseCompactionThreshold of its entries, into
+// the level beneath, where its tombstones delete the keys they shadow. The
+// densest eligible file of the highest level is picked, provided it overlaps
+// files in the level beneath. L0 is not considered.
+func (p *compactionPickerByScore) pickTombstoneDensityCompaction(
+	env compactionEnv,
+) (pc *pickedCompaction) {
+	if p.opts.Experimental.TombstoneDenseCompactionThreshold <= 0 {
+		return nil
+	}
+	a := tombstoneDensityAnnotator{
+		threshold: p.opts.Experimental.TombstoneDenseCompactionThreshold,
+		minCount:  p.opts.Experimental.TombstoneDenseCompactionMinCount,
+	}
+	for level := p.baseLevel; level < numLevels-1; level++ {
+		v := p.vers.Levels[level].Annotation(a)
+		if v == nil {
+			continue
+		}
+		candidate := v.(*fileMetadata)
+		lf := p.vers.Levels[level].Find(p.opts.Comparer.Compare, candidate)
+		if lf == nil {
+			panic(fmt.Sprintf("file %s not found in level %d as expected", candidate.FileNum, level))
+		}
+		info := candidateLevelInfo{
+			level:       level,
+			outputLevel: level + 1,
+			file:        *lf,
+		}
+		pc := pickAutoLPositive(env, p.opts, p.vers, info, p.baseLevel, p.diskAvailBytes, p.levelMaxBytes)
+		// There is nothing for the tombstones to delete if the file does not
+		// overlap the level beneath.
+		if pc == nil || pc.outputLevel.files.Empty() || inputRangeAlreadyCompacting(env, pc) {
+			continue
+		}
+		pc.kind = compactionKindTombstoneDensity
+		return pc
+	}
+	return nil
+}
+
 // pickRewriteCompaction attempts to construct a compaction that
 // rewrites a file marked for compaction. pickRewriteCompaction will
 // pull in adjacent files in the file's atomic compaction unit if
@@ -1613,10 +1757,12 @@ func pickL0(
 	}
 
 	// Couldn't choose a base compaction. Try choosing an intra-L0
-	// compaction. Note that we pass in L0CompactionThreshold here as opposed to
-	// 1, since choosing a single sublevel intra-L0 compaction is
+	// compaction. Note that we pass in IntraL0CompactionMinDepth here as
+	// opposed to 1, since choosing a single sublevel intra-L0 compaction is
 	// counterproductive.
-	lcf, err = vers.L0Sublevels.PickIntraL0Compaction(env.earliestUnflushedSeqNum, minIntraL0Count)
+	lcf, err = vers.L0Sublevels.PickIntraL0Compaction(
+		env.earliestUnflushedSeqNum, opts.Experimental.IntraL0CompactionMinDepth,
+	)
 	if err != nil {
 		opts.Logger.Infof("error when picking intra-L0 compaction: %s", err)
 		return
@@ -1629,12 +1775,10 @@ func pickL0(
 		if pc.startLevel.files.Empty() {
 			opts.Logger.Fatalf("empty compaction chosen")
 		}
-		{
-			iter := pc.startLevel.files.Iter()
-			if iter.First() == nil || iter.Next() == nil {
-				// A single-file intra-L0 compaction is unproductive.
-				return nil
-			}
+		if pc.startLevel.files.Len() < opts.Experimental.IntraL0CompactionMinFiles {
+			// A single-file intra-L0 compaction is unproductive, and
+			// compactions of few files may be configured to be too.
+			return nil
 		}
 
 		pc.smallest, pc.largest = manifest.KeyRange(pc.cmp, pc.startLevel.files.Iter())
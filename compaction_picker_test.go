@@ -518,6 +518,16 @@ func TestCompactionPickerL0(t *testing.T) {
 					if err != nil {
 						return err.Error()
 					}
+				case "intra_l0_compaction_min_depth":
+					opts.Experimental.IntraL0CompactionMinDepth, err = strconv.Atoi(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
+				case "intra_l0_compaction_min_files":
+					opts.Experimental.IntraL0CompactionMinFiles, err = strconv.Atoi(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
 				}
 			}
 
@@ -760,6 +770,18 @@ func TestCompactionPickerConcurrency(t *testing.T) {
 					if err != nil {
 						return err.Error()
 					}
+				case "max_concurrent_compactions":
+					var n int
+					n, err = strconv.Atoi(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
+					opts.MaxConcurrentCompactions = func() int { return n }
+				case "reserve_l0_base_compaction_slot":
+					opts.Experimental.ReserveL0BaseCompactionSlot, err = strconv.ParseBool(arg.Vals[0])
+					if err != nil {
+						return err.Error()
+					}
 				}
 			}
 
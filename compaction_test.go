@@ -30,7 +30,9 @@ import (
 	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -1582,7 +1584,9 @@ func TestManualCompaction(t *testing.T) {
 		{
 			testData:   "testdata/manual_compaction_set_with_del",
 			minVersion: FormatSetWithDelete,
-			maxVersion: FormatNewest,
+			// Tables written at FormatDBID and above record the DB ID in their
+			// properties, which changes the file sizes printed by this test.
+			maxVersion: FormatDBID - 1,
 		},
 		{
 			testData:   "testdata/singledel_manual_compaction",
@@ -1603,7 +1607,8 @@ func TestManualCompaction(t *testing.T) {
 		{
 			testData:   "testdata/manual_compaction_file_boundaries",
 			minVersion: FormatMostCompatible,
-			maxVersion: FormatNewest,
+			// See the comment on manual_compaction_set_with_del above.
+			maxVersion: FormatDBID - 1,
 		},
 	}
 
@@ -3233,6 +3238,51 @@ func TestFlushInvariant(t *testing.T) {
 	}
 }
 
+func TestCompactLevel(t *testing.T) {
+	var d *DB
+	defer func() {
+		if d != nil {
+			require.NoError(t, d.Close())
+		}
+	}()
+
+	datadriven.RunTest(t, "testdata/compact_level", func(t *testing.T, td *datadriven.TestData) string {
+		switch td.Cmd {
+		case "define":
+			if d != nil {
+				require.NoError(t, d.Close())
+			}
+			opts := &Options{DisableAutomaticCompactions: true}
+			var err error
+			if d, err = runDBDefineCmd(td, opts); err != nil {
+				return err.Error()
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return d.mu.versions.currentVersion().String()
+
+		case "compact-level":
+			if len(td.CmdArgs) != 2 {
+				return "compact-level L<n> <start>-<end>"
+			}
+			level, err := strconv.Atoi(strings.TrimPrefix(td.CmdArgs[0].Key, "L"))
+			if err != nil {
+				return err.Error()
+			}
+			parts := strings.Split(td.CmdArgs[1].Key, "-")
+			if err := d.CompactLevel(level, []byte(parts[0]), []byte(parts[1])); err != nil {
+				return err.Error()
+			}
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return d.mu.versions.currentVersion().String()
+
+		default:
+			return fmt.Sprintf("unknown command: %s", td.Cmd)
+		}
+	})
+}
+
 func TestCompactFlushQueuedMemTableAndFlushMetrics(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("test is flaky on windows")
@@ -3403,6 +3453,61 @@ func TestFlushError(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestCompactionCompressionDict(t *testing.T) {
+	const dictSize = 1024
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		FormatMajorVersion:          FormatCompressionDictionaries,
+		DisableAutomaticCompactions: true,
+		Levels: []LevelOptions{{
+			Compression:         ZstdCompression,
+			CompressionDictSize: dictSize,
+		}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	dict := func(level int) []byte {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.compact.compressionDicts[level]
+	}
+	value := func(i int) []byte {
+		return []byte(fmt.Sprintf(`{"id":%d,"status":"active","region":"us-east-1"}`, i))
+	}
+	write := func(start, end int) {
+		for i := start; i < end; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("user/%08d", i)), value(i), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+
+	require.Empty(t, dict(0))
+	// The first flush has no dictionary to use, but trains one.
+	write(0, 1000)
+	first := dict(0)
+	require.Len(t, first, dictSize)
+	require.True(t, bytes.Contains(first, []byte(`,"status":"active","region":"us-east-1"}`)))
+
+	// The second flush uses the dictionary trained by the first one, and
+	// trains a new one.
+	write(1000, 2000)
+	second := dict(0)
+	require.Len(t, second, dictSize)
+
+	// Levels below L0 inherit the options of L0. Compacting into L6 trains a
+	// dictionary for L6, and leaves the dictionary of L0 alone.
+	require.NoError(t, d.Compact([]byte("user/"), []byte("user0"), false))
+	require.Equal(t, second, dict(0))
+	require.Len(t, dict(6), dictSize)
+	for i := 0; i < 2000; i++ {
+		v, closer, err := d.Get([]byte(fmt.Sprintf("user/%08d", i)))
+		require.NoError(t, err)
+		require.Equal(t, value(i), v)
+		require.NoError(t, closer.Close())
+	}
+}
+
 func TestAdjustGrandparentOverlapBytesForFlush(t *testing.T) {
 	// 500MB in Lbase
 	var lbaseFiles []*manifest.FileMetadata
@@ -3907,3 +4012,236 @@ func TestCompaction_LogAndApplyFails(t *testing.T) {
 		})
 	}
 }
+
+func TestCompactionCreateOnShared(t *testing.T) {
+	mem := vfs.NewMem()
+	store := shared.NewInMem()
+	opts := &Options{FS: mem}
+	opts.Experimental.SharedStorage = store
+	opts.Experimental.CreateOnShared = true
+	// Upload the compaction output in several parts.
+	opts.Experimental.SharedUploadPartSize = 64
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.SetCreatorID(1))
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+
+	// The flushed tables were written locally, but the output of the
+	// compaction was written directly to shared storage.
+	d.mu.Lock()
+	files := d.mu.versions.currentVersion().Levels[numLevels-1].Slice()
+	d.mu.Unlock()
+	require.Equal(t, 1, files.Len())
+	iter := files.Iter()
+	fileNum := iter.First().FileNum
+	meta, err := d.objProvider.Lookup(fileTypeTable, fileNum)
+	require.NoError(t, err)
+	require.True(t, meta.IsShared())
+	_, err = mem.Stat(base.MakeFilename(fileTypeTable, fileNum))
+	require.True(t, oserror.IsNotExist(err))
+	objs, err := store.List("", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(objs))
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, k, string(v))
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+}
+
+func TestAllTablesOnShared(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+	opts.Experimental.SharedStorage = shared.NewInMem()
+	opts.Experimental.CreateOnShared = true
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%05d", i)) }
+	localTables := func() int {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		var n int
+		for _, filename := range ls {
+			if fileType, _, ok := base.ParseFilename(mem, filename); ok && fileType == fileTypeTable {
+				n++
+			}
+		}
+		return n
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.SetCreatorID(1))
+	// The flushed tables overlap, so that they are rewritten to shared storage
+	// rather than moved.
+	for start := 0; start < 2; start++ {
+		for i := start; i < 2000; i += 2 {
+			require.NoError(t, d.Set(key(i), key(i), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact(key(0), key(2000), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	// Only the MANIFEST, the catalog and the other metadata files are local.
+	require.Zero(t, localTables())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	// The table stats are loaded from the shared table.
+	require.NoError(t, d.WaitForTableStats(context.Background()))
+	d.mu.Lock()
+	files := d.mu.versions.currentVersion().Levels[numLevels-1].Slice()
+	require.Equal(t, 1, files.Len())
+	iter := files.Iter()
+	f := iter.First()
+	require.True(t, f.StatsValidLocked())
+	require.Equal(t, uint64(2000), f.Stats.NumEntries)
+	d.mu.Unlock()
+
+	// Concurrent Gets read the shared table in parallel.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < 2000; i += 4 {
+				v, closer, err := d.Get(key(i))
+				if err != nil || !bytes.Equal(v, key(i)) {
+					t.Errorf("get %s: %q, %v", key(i), v, err)
+					return
+				}
+				closer.Close()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// A full scan reads the shared table sequentially.
+	it := d.NewIter(nil)
+	var n int
+	for valid := it.First(); valid; valid = it.Next() {
+		require.Equal(t, key(n), it.Key())
+		n++
+	}
+	require.NoError(t, it.Close())
+	require.Equal(t, 2000, n)
+
+	// Compacting the shared table with a local one writes a new shared table.
+	for i := 0; i < 2000; i += 2 {
+		require.NoError(t, d.Delete(key(i), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact(key(0), key(2000), false /* parallelize */))
+	require.Zero(t, localTables())
+	_, _, err = d.Get(key(1234))
+	require.ErrorIs(t, err, ErrNotFound)
+	v, closer, err := d.Get(key(1235))
+	require.NoError(t, err)
+	require.Equal(t, key(1235), v)
+	require.NoError(t, closer.Close())
+	require.NoError(t, d.CheckLevels(nil))
+}
+
+func TestSecondaryCache(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	opts.Experimental.SharedStorage = shared.NewInMem()
+	opts.Experimental.CreateOnShared = true
+	opts.Experimental.SecondaryCacheDir = "secondary-cache"
+	opts.Experimental.SecondaryCacheChunkSize = 256
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.SetCreatorID(1))
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	// After a restart the block cache is empty, but the blocks of the shared
+	// table are read from the secondary cache.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b"} {
+		v, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, k, string(v))
+		require.NoError(t, closer.Close())
+	}
+	// The table spans several chunks.
+	m := d.Metrics().SecondaryCache
+	require.Greater(t, m.Count, int64(1))
+	require.Greater(t, m.Hits, int64(0))
+	ls, err := mem.List("secondary-cache")
+	require.NoError(t, err)
+	require.Equal(t, int(m.Count), len(ls))
+	require.NoError(t, d.Close())
+}
+
+func TestCompactionGarbageSuffix(t *testing.T) {
+	// Versions with a timestamp below the threshold are garbage.
+	var threshold atomic.Int64
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		Comparer:                    testkeys.Comparer,
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.IsGarbageSuffix = func(suffix []byte) bool {
+		ts, err := testkeys.ParseSuffix(suffix)
+		require.NoError(t, err)
+		return int64(ts) < threshold.Load()
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	keys := func() string {
+		iter := d.NewIter(nil)
+		defer iter.Close()
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			fmt.Fprintf(&buf, "%s ", iter.Key())
+		}
+		return strings.TrimSpace(buf.String())
+	}
+
+	// set writes the given keys, and flushes them. Garbage is not dropped by
+	// flushes, as the flushed keys may shadow older versions in the LSM.
+	set := func(keys ...string) {
+		for _, k := range keys {
+			require.NoError(t, d.Set([]byte(k), nil, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	// compact compacts the LSM into L6, where the garbage can be dropped. Note
+	// that the compaction must rewrite the tables: the tables moved to L6 are
+	// left unmodified.
+	compact := func() {
+		require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+	}
+
+	threshold.Store(3)
+	set("a@1", "b@7", "c")
+	set("a@5", "b@2")
+	require.Equal(t, "a@5 a@1 b@7 b@2 c", keys())
+	compact()
+	require.Equal(t, "a@5 b@7 c", keys())
+
+	// Moving the threshold forward makes more versions garbage.
+	threshold.Store(6)
+	set("a@9", "c@4")
+	require.Equal(t, "a@9 a@5 b@7 c c@4", keys())
+	compact()
+	require.Equal(t, "a@9 b@7 c", keys())
+}
@@ -0,0 +1,252 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// checkConsistencyOptions hold the optional parameters of
+// DB.CheckConsistency.
+type checkConsistencyOptions struct {
+	validateChecksums bool
+	// checksumBytesPerSec limits the rate at which the sstables are read to
+	// validate their checksums. Zero means unlimited.
+	checksumBytesPerSec int64
+}
+
+// CheckConsistencyOption sets optional parameters used by
+// DB.CheckConsistency.
+type CheckConsistencyOption func(*checkConsistencyOptions)
+
+// WithBlockChecksums makes DB.CheckConsistency read every block of every
+// sstable to validate its checksum, reading at most bytesPerSec bytes per
+// second. A bytesPerSec of zero means no limit.
+func WithBlockChecksums(bytesPerSec int64) CheckConsistencyOption {
+	return func(opt *checkConsistencyOptions) {
+		opt.validateChecksums = true
+		opt.checksumBytesPerSec = bytesPerSec
+	}
+}
+
+// ConsistencyReport describes the problems found by DB.CheckConsistency.
+type ConsistencyReport struct {
+	// NumTables is the number of sstables that were checked.
+	NumTables int
+	// ChecksummedBytes is the number of bytes of sstables whose block
+	// checksums were validated.
+	ChecksummedBytes uint64
+	// Tables lists the sstables which are missing, have an unexpected size,
+	// or fail checksum validation.
+	Tables []TableConsistencyError
+	// OrderingErr is the violation of the ordering invariants of the files
+	// within the levels of the LSM, if any.
+	OrderingErr error
+	// LevelsErr is the violation of the level invariant or of the ordering
+	// of keys within sstables found by DB.CheckLevels, if any. The check is
+	// skipped if any sstable is missing or has an unexpected size.
+	LevelsErr error
+	// LevelsStats are the stats collected by DB.CheckLevels.
+	LevelsStats CheckLevelsStats
+}
+
+// TableConsistencyError describes a problem with an sstable.
+type TableConsistencyError struct {
+	Level   int
+	FileNum FileNum
+	Err     error
+}
+
+// OK returns true if no problem was found.
+func (r ConsistencyReport) OK() bool {
+	return len(r.Tables) == 0 && r.OrderingErr == nil && r.LevelsErr == nil
+}
+
+// String implements fmt.Stringer.
+func (r ConsistencyReport) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "checked %d tables", r.NumTables)
+	if r.ChecksummedBytes > 0 {
+		fmt.Fprintf(&buf, " (checksummed %d bytes)", r.ChecksummedBytes)
+	}
+	buf.WriteString("\n")
+	for _, t := range r.Tables {
+		fmt.Fprintf(&buf, "L%d: %s: %v\n", t.Level, t.FileNum, t.Err)
+	}
+	if r.OrderingErr != nil {
+		fmt.Fprintf(&buf, "ordering: %v\n", r.OrderingErr)
+	}
+	if r.LevelsErr != nil {
+		fmt.Fprintf(&buf, "levels: %v\n", r.LevelsErr)
+	}
+	return buf.String()
+}
+
+// CheckConsistency checks the current version of the LSM against the files
+// backing it, and returns the problems it finds in a ConsistencyReport. It
+// checks:
+//   - Every sstable exists with the size recorded in the MANIFEST.
+//   - The sstables of each level respect the ordering invariants of the
+//     level.
+//   - The invariants checked by DB.CheckLevels.
+//   - With WithBlockChecksums, the checksum of every block of every sstable.
+//
+// This is an expensive check since it involves iterating over all the entries
+// in the DB. The returned error is only set if the check could not be
+// performed.
+func (d *DB) CheckConsistency(opts ...CheckConsistencyOption) (ConsistencyReport, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	opt := &checkConsistencyOptions{}
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	// Grab and reference the current readState.
+	readState := d.loadReadState()
+	defer readState.unref()
+	current := readState.current
+
+	var report ConsistencyReport
+	report.OrderingErr = current.CheckOrdering(d.cmp, d.opts.Comparer.FormatKey)
+
+	var limiter *rate.Limiter
+	if opt.validateChecksums && opt.checksumBytesPerSec > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opt.checksumBytesPerSec), int(opt.checksumBytesPerSec))
+	}
+	var unreadable bool
+	for level := range current.Levels {
+		iter := current.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			report.NumTables++
+			err := checkTableObject(d.objProvider, f)
+			if err != nil {
+				unreadable = true
+			} else if opt.validateChecksums {
+				paceChecksums(limiter, f.BackingSize())
+				err = d.tableCache.withReader(f, func(r *sstable.Reader) error {
+					return r.ValidateBlockChecksums()
+				})
+				if err == nil {
+					report.ChecksummedBytes += f.BackingSize()
+				}
+			}
+			if err != nil {
+				report.Tables = append(report.Tables, TableConsistencyError{
+					Level:   level,
+					FileNum: f.FileNum,
+					Err:     err,
+				})
+			}
+		}
+	}
+
+	if !unreadable {
+		// Only check the levels if all the sstables can be read, since
+		// checkLevelsInternal stops at the first error.
+		seqNum := atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+		report.LevelsErr = checkLevelsInternal(&checkConfig{
+			logger:    d.opts.Logger,
+			cmp:       d.cmp,
+			readState: readState,
+			newIters:  d.newIters,
+			seqNum:    seqNum,
+			stats:     &report.LevelsStats,
+			merge:     d.merge,
+			formatKey: d.opts.Comparer.FormatKey,
+		})
+	}
+	return report, nil
+}
+
+// paceChecksums waits until the limiter permits reading n bytes, if it is
+// non-nil.
+func paceChecksums(limiter *rate.Limiter, n uint64) {
+	if limiter == nil {
+		return
+	}
+	burst := uint64(limiter.Burst())
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		time.Sleep(limiter.DelayN(time.Now(), int(chunk)))
+		n -= chunk
+	}
+}
+
+// MissingTablesError is returned by Open when sstables referenced by the
+// MANIFEST do not exist (see Options.Experimental.VerifyTablesOnOpen).
+type MissingTablesError struct {
+	Tables []MissingTableInfo
+}
+
+func (e *MissingTablesError) Error() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "pebble: %d sstables referenced by the MANIFEST are missing:", len(e.Tables))
+	for _, t := range e.Tables {
+		fmt.Fprintf(&buf, "\n  L%d: %s [%s-%s]", t.Level, t.FileNum, t.Smallest, t.Largest)
+	}
+	return buf.String()
+}
+
+// restoreMissingTables restores the local sstables of the version which are
+// missing but have a replica on shared storage, from their replica.
+func restoreMissingTables(
+	v *manifest.Version, objProvider *objstorage.Provider, logger Logger,
+) error {
+	replicas, err := objProvider.ListReplicas(fileTypeTable)
+	if err != nil {
+		return err
+	}
+	for _, files := range v.Levels {
+		iter := files.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if _, ok := replicas[f.FileNum]; !ok {
+				continue
+			}
+			if _, err := objProvider.Lookup(fileTypeTable, f.FileNum); !objstorage.IsNotExistError(err) {
+				continue
+			}
+			meta := objstorage.ObjectMetadata{FileType: fileTypeTable, FileNum: f.FileNum}
+			if err := objProvider.RestoreFromReplica(context.Background(), meta); err != nil {
+				return err
+			}
+			logger.Infof("restored missing table %s from its replica", f.FileNum)
+		}
+	}
+	return nil
+}
+
+// checkTableObject checks that the object backing the sstable exists, with
+// the size recorded in the MANIFEST.
+func checkTableObject(objProvider *objstorage.Provider, f *fileMetadata) error {
+	meta, err := objProvider.Lookup(base.FileTypeTable, f.FileNum)
+	if err != nil {
+		return err
+	}
+	size, err := objProvider.Size(meta)
+	if err != nil {
+		return err
+	}
+	if size != int64(f.BackingSize()) {
+		return errors.Errorf("object size mismatch (%s): %d (disk) != %d (MANIFEST)",
+			objProvider.Path(meta), errors.Safe(size), errors.Safe(f.BackingSize()))
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBCheckConsistency(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	for gen := 0; gen < 3; gen++ {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprint(gen)), nil))
+		}
+		require.NoError(t, d.Flush())
+		if gen == 0 {
+			require.NoError(t, d.Compact([]byte("0000"), []byte("0100"), false /* parallelize */))
+		}
+	}
+	require.NoError(t, d.DeleteRange([]byte("0010"), []byte("0020"), nil))
+
+	report, err := d.CheckConsistency()
+	require.NoError(t, err)
+	require.True(t, report.OK(), "%s", report)
+	require.Equal(t, 3, report.NumTables)
+	require.Zero(t, report.ChecksummedBytes)
+	require.EqualValues(t, 300, report.LevelsStats.NumPoints)
+	require.Equal(t, 1, report.LevelsStats.NumTombstones)
+
+	report, err = d.CheckConsistency(WithBlockChecksums(1 << 20))
+	require.NoError(t, err)
+	require.True(t, report.OK(), "%s", report)
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	var totalSize uint64
+	for _, level := range tables {
+		for _, table := range level {
+			totalSize += table.Size
+		}
+	}
+	require.Equal(t, totalSize, report.ChecksummedBytes)
+	require.NoError(t, d.Close())
+
+	// Corrupt a byte of the first data block of the L6 table, and truncate
+	// one of the L0 tables.
+	rewrite := func(fileNum FileNum, fn func(data []byte) []byte) {
+		name := base.MakeFilename(fileTypeTable, fileNum)
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, mem.Remove(name))
+		f, err = mem.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(fn(data))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	corrupted := tables[numLevels-1][0].FileNum
+	rewrite(corrupted, func(data []byte) []byte {
+		data[10] ^= 0xff
+		return data
+	})
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	report, err = d.CheckConsistency()
+	require.NoError(t, err)
+	require.False(t, report.OK())
+	require.Empty(t, report.Tables)
+	require.Error(t, report.LevelsErr)
+
+	report, err = d.CheckConsistency(WithBlockChecksums(0))
+	require.NoError(t, err)
+	require.Len(t, report.Tables, 1)
+	require.Equal(t, numLevels-1, report.Tables[0].Level)
+	require.Equal(t, corrupted, report.Tables[0].FileNum)
+	require.Contains(t, report.Tables[0].Err.Error(), "checksum")
+
+	// A table with an unexpected size is reported, and the level checks are
+	// skipped.
+	truncated := tables[0][0].FileNum
+	rewrite(truncated, func(data []byte) []byte { return data[:len(data)-1] })
+	report, err = d.CheckConsistency()
+	require.NoError(t, err)
+	require.Len(t, report.Tables, 1)
+	require.Equal(t, truncated, report.Tables[0].FileNum)
+	require.Contains(t, report.Tables[0].Err.Error(), "size mismatch")
+	require.NoError(t, report.LevelsErr)
+	require.Contains(t, report.String(), "size mismatch")
+	require.NoError(t, d.Close())
+}
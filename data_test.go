@@ -821,6 +821,18 @@ func runDBDefineCmd(td *datadriven.TestData, opts *Options) (*DB, error) {
 				return nil, errors.Errorf("%s: could not parse %q as float: %s", td.Cmd, arg.Vals[0], err)
 			}
 			opts.Experimental.PointTombstoneWeight = w
+		case "tombstone-dense-threshold":
+			v, err := strconv.ParseFloat(arg.Vals[0], 64)
+			if err != nil {
+				return nil, errors.Errorf("%s: could not parse %q as float: %s", td.Cmd, arg.Vals[0], err)
+			}
+			opts.Experimental.TombstoneDenseCompactionThreshold = v
+		case "tombstone-dense-min-count":
+			v, err := strconv.ParseUint(arg.Vals[0], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			opts.Experimental.TombstoneDenseCompactionMinCount = v
 		}
 	}
 	d, err := Open("", opts)
@@ -1172,7 +1184,7 @@ func runForceIngestCmd(td *datadriven.TestData, d *DB) error {
 			}
 		}
 	}
-	_, err := d.ingest(paths, func(
+	_, err := d.ingest(paths, nil /* bounds */, func(
 		tableNewIters,
 		keyspan.TableNewSpanIter,
 		IterOptions,
@@ -21,6 +21,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/internal/manual"
+	"github.com/cockroachdb/pebble/internal/rate"
 	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
@@ -264,13 +265,22 @@ type DB struct {
 	// The threshold for determining when a batch is "large" and will skip being
 	// inserted into a memtable.
 	largeBatchThreshold int
-	// The current OPTIONS file number.
+	// The current OPTIONS file number. Protected by DB.mu.
 	optionsFileNum FileNum
-	// The on-disk size of the current OPTIONS file.
+	// The on-disk size of the current OPTIONS file. Protected by DB.mu.
 	optionsFileSize uint64
+	// optionsWriteMu serializes the writes of OPTIONS files, which are
+	// performed without holding DB.mu. It must be acquired before DB.mu.
+	optionsWriteMu sync.Mutex
 
 	// objProvider is used to access and manage SSTs.
 	objProvider *objstorage.Provider
+	// manifestReplicator maintains the replica of the MANIFEST on shared
+	// storage; it is nil if Options.Experimental.ReplicateManifest is not set.
+	manifestReplicator *manifestReplicator
+	// replicaUploader uploads the replicas of the new local sstables; it is
+	// nil unless Options.Experimental.ReplicaUploadConcurrency is set.
+	replicaUploader *replicaUploader
 
 	fileLock io.Closer
 	dataDir  vfs.File
@@ -294,10 +304,19 @@ type DB struct {
 	// updates.
 	logRecycler logRecycler
 
+	// recoveryReport describes the data dropped by Open when recovering the
+	// DB with a RecoveryMode other than RecoveryStrict. It is immutable once
+	// the DB is open.
+	recoveryReport RecoveryReport
+
+	// txns tracks the optimistic transactions started with NewTxn.
+	txns txnOracle
+
 	closed   *atomic.Value
 	closedCh chan struct{}
 
-	deletionLimiter limiter
+	deletionLimiter     *rate.Limiter
+	deletionFileLimiter *rate.Limiter
 
 	// Async deletion jobs spawned by cleaners increment this WaitGroup, and
 	// call Done when completed. Once `d.mu.cleaning` is false, the db.Close()
@@ -306,6 +325,15 @@ type DB struct {
 	// could grab db.mu, it must *not* be held while deleters.Wait() is called.
 	deleters sync.WaitGroup
 
+	// walArchive serializes the pruning of the WAL archive (see
+	// Options.Experimental.WALArchiveRetention).
+	walArchive struct {
+		sync.Mutex
+		// lastBackup is the start time of the last successful backup, as
+		// reported by SetLastBackupTime.
+		lastBackup time.Time
+	}
+
 	// During an iterator close, we may asynchronously schedule read compactions.
 	// We want to wait for those goroutines to finish, before closing the DB.
 	// compactionShedulers.Wait() should not be called while the DB.mu is held.
@@ -340,6 +368,14 @@ type DB struct {
 			// to vers + 1. As a part of ratcheting the format major version,
 			// migrations may drop and re-acquire the mutex.
 			ratcheting bool
+			// target is the format major version that the database is being
+			// ratcheted to, while ratcheting is set.
+			target FormatMajorVersion
+			// tablesToRewrite and tablesRewritten track the progress of the
+			// most recent migration that rewrites the sstables marked for
+			// compaction.
+			tablesToRewrite int
+			tablesRewritten int
 		}
 
 		// The ID of the next job. Job IDs are passed to event listener
@@ -420,6 +456,12 @@ type DB struct {
 			// compactions which we might have to perform.
 			readCompactions readCompactionQueue
 
+			// compressionDicts holds, for each level, the most recent Zstandard
+			// compression dictionary trained by a flush or compaction into the
+			// level. See LevelOptions.CompressionDictSize. A published
+			// dictionary is never mutated.
+			compressionDicts [numLevels][]byte
+
 			// Flush throughput metric.
 			flushWriteThroughput ThroughputMetric
 			// The idle start time for the flush "loop", i.e., when the flushing
@@ -440,6 +482,10 @@ type DB struct {
 			// reference count to prohibit file cleaning. See
 			// DB.{disable,Enable}FileDeletions().
 			disabled int
+			// vetoed holds the obsolete files whose deletion was vetoed by
+			// Options.Experimental.ObsoleteFileDeletionFilter. Their deletion
+			// is retried by the next file cleaning operation.
+			vetoed []obsoleteFile
 		}
 
 		// The list of active snapshots.
@@ -461,6 +507,14 @@ type DB struct {
 			// active stat collection goroutine clears the list and processes
 			// them.
 			pending []manifest.NewFileEntry
+			// queue holds the files lacking stats found by the last scan of
+			// the current version, in the order in which their stats are
+			// loaded (see tableStatsQueue). It is only accessed by the active
+			// stat collection job.
+			queue []manifest.NewFileEntry
+			// paced is true while the next scan for files lacking stats is
+			// delayed by Options.Experimental.TableStatsLoadRate.
+			paced bool
 		}
 
 		tableValidation struct {
@@ -473,6 +527,86 @@ type DB struct {
 			// validating is set to true when validation is running.
 			validating bool
 		}
+
+		ingest struct {
+			// cond is a condition variable used to signal the completion of
+			// the application of a batch of ingestions, or the removal of an
+			// ingestion from the queue.
+			cond sync.Cond
+			// queue is the list of ingestions which were assigned a sequence
+			// number but not yet applied to the LSM, in sequence number order
+			// (see DB.ingestApply).
+			queue []*ingestApplyRequest
+			// applying is set to true while a batch of ingestions is being
+			// applied.
+			applying bool
+		}
+
+		cacheWarmup struct {
+			// cond is a condition variable used to signal the completion of
+			// the warm-up of the block cache.
+			cond sync.Cond
+			// warming is set to true while the block cache is being warmed up
+			// (see Options.CacheWarmupFile).
+			warming bool
+			// prefetches is the number of in-progress prefetches of the
+			// blocks of a key range (see Iterator.PrefetchRange).
+			prefetches int
+		}
+
+		scrub struct {
+			// cond is a condition variable used to signal the completion of a
+			// scrub, or the exit of the background scrubber.
+			cond sync.Cond
+			// scrubbing is set to true while a scrub is running (see
+			// DB.Scrub).
+			scrubbing bool
+			// background is set to true while the background scrubber is
+			// running (see Options.Experimental.ScrubInterval).
+			background bool
+		}
+
+		remote struct {
+			// cond is a condition variable used to signal the completion of a
+			// refresh of a DB opened with OpenRemoteReadOnly, or the exit of
+			// the background refresher.
+			cond sync.Cond
+			// refreshing is set to true while a refresh is running (see
+			// DB.RefreshRemote).
+			refreshing bool
+			// background is set to true while the background refresher is
+			// running (see Options.Experimental.RemoteMaxStaleness).
+			background bool
+			// lastRefresh is the start time of the last successful refresh,
+			// or the time the DB was opened.
+			lastRefresh time.Time
+			// refreshes is the number of successful refreshes.
+			refreshes int64
+		}
+
+		metricsReport struct {
+			// cond is a condition variable used to signal the exit of the
+			// metrics reporter.
+			cond sync.Cond
+			// running is set to true while the metrics reporter is running
+			// (see Options.MetricsInterval).
+			running bool
+		}
+	}
+
+	tenants struct {
+		// usage is the usage of the tenants, which is computed again with
+		// DB.mu held when the current version changes or table stats are
+		// loaded (see Options.Experimental.TenantQuotas).
+		usage atomic.Pointer[tenantUsage]
+		// exceeded holds the tenants which reached their quota, which are
+		// reported by the next write without DB.mu held.
+		// exceededPending is set while exceeded.pending is not empty.
+		exceeded struct {
+			sync.Mutex
+			pending []TenantQuotaInfo
+		}
+		exceededPending atomic.Bool
 	}
 
 	// Normally equal to time.Now() but may be overridden in tests.
@@ -570,6 +704,7 @@ func (d *DB) getInternal(key []byte, b *Batch, s *Snapshot) ([]byte, io.Closer,
 		comparer:     *d.opts.Comparer,
 		readState:    readState,
 		keyBuf:       buf.keyBuf,
+		bufAlloc:     d.opts.Experimental.BufferAllocator,
 	}
 
 	if !i.First() {
@@ -663,6 +798,9 @@ func (d *DB) Merge(key, value []byte, opts *WriteOptions) error {
 // WAL, but not added to memtables or sstables. Log data is never indexed,
 // which makes it useful for testing WAL performance.
 //
+// The data is written as a batch holding only it; see Batch.LogData for how
+// consumers reading the WAL see it.
+//
 // It is safe to modify the contents of the argument after LogData returns.
 func (d *DB) LogData(data []byte, opts *WriteOptions) error {
 	b := newBatch(d)
@@ -792,6 +930,12 @@ func (d *DB) applyInternal(batch *Batch, opts *WriteOptions, noSyncWait bool) er
 		// TODO(jackson): Assert that all range key operands are suffixless.
 	}
 
+	if d.opts.Experimental.TenantQuotas != nil {
+		if err := d.checkTenantQuotas(batch); err != nil {
+			return err
+		}
+	}
+
 	if batch.db == nil {
 		batch.refreshMemTableSize()
 	}
@@ -937,6 +1081,14 @@ var iterAllocPool = sync.Pool{
 // newIter constructs a new iterator, merging in batch iterators as an extra
 // level.
 func (d *DB) newIter(ctx context.Context, batch *Batch, s *Snapshot, o *IterOptions) *Iterator {
+	return d.newIterWithAlloc(ctx, batch, s, o, nil /* buf */)
+}
+
+// newIterWithAlloc is like newIter, but constructs the iterator in the given
+// allocation, or in one from iterAllocPool if buf is nil.
+func (d *DB) newIterWithAlloc(
+	ctx context.Context, batch *Batch, s *Snapshot, o *IterOptions, buf *iterAlloc,
+) *Iterator {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
@@ -974,7 +1126,9 @@ func (d *DB) newIter(ctx context.Context, batch *Batch, s *Snapshot, o *IterOpti
 
 	// Bundle various structures under a single umbrella in order to allocate
 	// them together.
-	buf := iterAllocPool.Get().(*iterAlloc)
+	if buf == nil {
+		buf = iterAllocPool.Get().(*iterAlloc)
+	}
 	dbi := &buf.dbi
 	*dbi = Iterator{
 		ctx:                 ctx,
@@ -985,6 +1139,7 @@ func (d *DB) newIter(ctx context.Context, batch *Batch, s *Snapshot, o *IterOpti
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
 		boundsBuf:           buf.boundsBuf,
+		bufAlloc:            d.opts.Experimental.BufferAllocator,
 		batch:               batch,
 		newIters:            d.newIters,
 		newIterRangeKey:     d.tableNewRangeKeyIter,
@@ -1332,6 +1487,9 @@ func (i *Iterator) constructPointIter(
 	buf.merging.combinedIterState = &i.lazyCombinedIter.combinedIterState
 	i.pointIter = &buf.merging
 	i.merging = &buf.merging
+	if i.readState != nil && i.readState.db.opts.DebugCheckKeyOrder {
+		i.pointIter = newKeyOrderCheckingIter(i.pointIter, i.readState.db.opts.Comparer)
+	}
 }
 
 // NewBatch returns a new empty write-only batch. Any reads on the batch will
@@ -1367,6 +1525,26 @@ func (d *DB) NewIterWithContext(ctx context.Context, o *IterOptions) *Iterator {
 	return d.newIter(ctx, nil /* batch */, nil /* snapshot */, o)
 }
 
+// NewIterReuse is like NewIter, but closes the given iterator and constructs
+// the new iterator in its memory, avoiding the allocation of a new iterator.
+// The given iterator, which may have been created by NewIter, NewIterReuse,
+// Snapshot.NewIter or Batch.NewIter, must not be used after the call. If
+// closing it returns an error, the error is returned and no iterator is
+// constructed.
+//
+// NewIterReuse is intended for callers creating many short-lived iterators,
+// which may keep a closed iterator around to construct the next one.
+func (d *DB) NewIterReuse(existing *Iterator, o *IterOptions) (*Iterator, error) {
+	alloc, err := existing.close()
+	if err != nil {
+		if alloc != nil {
+			iterAllocPool.Put(alloc)
+		}
+		return nil, err
+	}
+	return d.newIterWithAlloc(context.Background(), nil /* batch */, nil /* snapshot */, o, alloc), nil
+}
+
 // NewSnapshot returns a point-in-time view of the current DB state. Iterators
 // created with this handle will all observe a stable snapshot of the current
 // DB state. The caller must call Snapshot.Close() when the snapshot is no
@@ -1382,14 +1560,88 @@ func (d *DB) NewSnapshot() *Snapshot {
 
 	d.mu.Lock()
 	s := &Snapshot{
-		db:     d,
-		seqNum: atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum),
+		db:        d,
+		seqNum:    atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum),
+		createdAt: time.Now(),
 	}
 	d.mu.snapshots.pushBack(s)
 	d.mu.Unlock()
 	return s
 }
 
+// VisibleSeqNum returns the visible sequence number of the DB: the writes of
+// all of the batches whose sequence numbers are lower than it are visible to
+// new iterators and snapshots. A batch that was committed with sequence number
+// seqNum (see Batch.SeqNum) is visible once VisibleSeqNum returns a value
+// greater than seqNum. See also Options.SeqNumPublished.
+func (d *DB) VisibleSeqNum() uint64 {
+	return atomic.LoadUint64(&d.mu.versions.atomic.visibleSeqNum)
+}
+
+// DBIDObjectMetadataKey is the key of the object metadata holding the ID of the
+// DB (see DB.ID) which created an object on shared storage, for storages which
+// support object metadata (see shared.MetadataStorage).
+const DBIDObjectMetadataKey = "pebble-db-id"
+
+// ID returns the unique ID of the DB, which is generated when the DB is
+// ratcheted to FormatDBID (or created at that format major version or later)
+// and persisted in the manifest. The ID is stamped into the properties of the
+// sstables written by the DB, and the metadata of the objects it creates on
+// shared storage. The empty string is returned for DBs at previous format
+// major versions.
+func (d *DB) ID() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mu.versions.dbID
+}
+
+// SubscribeVersionEdits registers fn to be invoked with each version edit
+// applied to the LSM by flushes, compactions, ingestions, etc., which makes it
+// possible to track exactly which tables are live. Before
+// SubscribeVersionEdits returns, fn is invoked with the tables of the current
+// version as added tables. fn is invoked with DB.mu held: it must not call
+// back into the DB, and it should not block. The returned function cancels the
+// subscription.
+func (d *DB) SubscribeVersionEdits(fn func(VersionEditInfo)) (unsubscribe func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info := VersionEditInfo{}
+	current := d.mu.versions.currentVersion()
+	for level := range current.Levels {
+		iter := current.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			info.Added = append(info.Added, struct {
+				TableInfo
+				Level int
+			}{f.TableInfo(), level})
+		}
+	}
+	fn(info)
+
+	s := &versionEditSubscriber{fn: fn}
+	d.mu.versions.editSubscribers = append(d.mu.versions.editSubscribers, s)
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		subscribers := d.mu.versions.editSubscribers[:0]
+		for _, other := range d.mu.versions.editSubscribers {
+			if other != s {
+				subscribers = append(subscribers, other)
+			}
+		}
+		d.mu.versions.editSubscribers = subscribers
+	}
+}
+
+// setObjectMetadataLocked sets the metadata attached to the objects created
+// on shared storage to identify the DB, if it has an ID. d.mu must be held.
+func (d *DB) setObjectMetadataLocked() {
+	if id := d.mu.versions.dbID; id != "" {
+		d.objProvider.SetObjectMetadata(map[string]string{DBIDObjectMetadataKey: id})
+	}
+}
+
 // Close closes the DB.
 //
 // It is not safe to close a DB until all outstanding iterators are closed
@@ -1425,16 +1677,42 @@ func (d *DB) Close() error {
 	close(d.closedCh)
 
 	defer d.opts.Cache.Unref()
+	defer d.opts.Cache.ReleaseID(d.cacheID)
 
 	for d.mu.compact.compactingCount > 0 || d.mu.compact.flushing {
 		d.mu.compact.cond.Wait()
 	}
+	// Wake up the callers of WaitForTableStats.
+	d.mu.tableStats.cond.Broadcast()
 	for d.mu.tableStats.loading {
 		d.mu.tableStats.cond.Wait()
 	}
 	for d.mu.tableValidation.validating {
 		d.mu.tableValidation.cond.Wait()
 	}
+	for d.mu.cacheWarmup.warming || d.mu.cacheWarmup.prefetches > 0 {
+		d.mu.cacheWarmup.cond.Wait()
+	}
+	for d.mu.scrub.scrubbing || d.mu.scrub.background {
+		d.mu.scrub.cond.Wait()
+	}
+	for d.mu.remote.refreshing || d.mu.remote.background {
+		d.mu.remote.cond.Wait()
+	}
+	for d.mu.metricsReport.running {
+		d.mu.metricsReport.cond.Wait()
+	}
+	if d.manifestReplicator != nil {
+		// Upload the last changes to the MANIFEST.
+		d.mu.Unlock()
+		d.manifestReplicator.close()
+		d.mu.Lock()
+	}
+	if d.replicaUploader != nil {
+		d.mu.Unlock()
+		d.replicaUploader.close()
+		d.mu.Lock()
+	}
 
 	var err error
 	if n := len(d.mu.compact.inProgress); n > 0 {
@@ -1605,6 +1883,29 @@ func (d *DB) Compact(start, end []byte, parallelize bool) error {
 	return nil
 }
 
+// CompactLevel compacts the tables of the given level which overlap the range
+// [start, end] into the next level, or into Lbase for L0. Unlike Compact, the
+// other levels are not compacted and the memtables are not flushed, which
+// makes it possible to push the data of a single level down, e.g. to move it
+// to a level whose tables are created on shared storage. The bottommost level
+// cannot be compacted.
+func (d *DB) CompactLevel(level int, start, end []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if level < 0 || level >= numLevels-1 {
+		return errors.Errorf("pebble: cannot compact level %d", level)
+	}
+	if d.cmp(start, end) >= 0 {
+		return errors.Errorf("CompactLevel start %s is not less than end %s",
+			d.opts.Comparer.FormatKey(start), d.opts.Comparer.FormatKey(end))
+	}
+	return d.manualCompact(start, end, level, false /* parallelize */)
+}
+
 func (d *DB) manualCompact(start, end []byte, level int, parallelize bool) error {
 	d.mu.Lock()
 	curr := d.mu.versions.currentVersion()
@@ -1668,6 +1969,44 @@ func (d *DB) splitManualCompaction(
 	return splitCompactions
 }
 
+// MarkFiltersForRewrite marks the tables of the given level for compaction,
+// so that they are rewritten in place in the background with the filter
+// policy of the level (see LevelOptions.FilterPolicy). Tables are otherwise
+// only written with the filter policy of their level when compactions rewrite
+// them into it, which may not happen for a long time in the bottommost levels.
+//
+// This provides a migration path when the filter policy of a level changes,
+// for instance to increase the bits per key of the bloom filters of L6. The
+// filter policy of a level may be changed across reopens of the DB, as long as
+// the filter policies of tables which remain unrewritten are registered in
+// Options.Filters, or set for some level.
+func (d *DB) MarkFiltersForRewrite(level int) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if level < 0 || level >= numLevels {
+		return errors.Errorf("pebble: invalid level %d", level)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.markFilesLocked(func(v *version) (found bool, files [numLevels][]*fileMetadata, _ error) {
+		iter := v.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files[level] = append(files[level], f)
+			found = true
+		}
+		return found, files, nil
+	}); err != nil {
+		return err
+	}
+	d.maybeScheduleCompaction()
+	return nil
+}
+
 // Flush the memtable to stable storage.
 func (d *DB) Flush() error {
 	flushDone, err := d.AsyncFlush()
@@ -1702,6 +2041,62 @@ func (d *DB) AsyncFlush() (<-chan struct{}, error) {
 	return flushed, nil
 }
 
+// RewriteManifest rolls the MANIFEST over, writing a snapshot of the current
+// version to a new MANIFEST and retiring the previous one. The MANIFEST is
+// rolled over automatically as it grows (see Options.MaxManifestFileSize and
+// Options.MaxManifestEdits); RewriteManifest allows reclaiming the space used
+// by the edits of a large MANIFEST at a time of the caller's choosing.
+func (d *DB) RewriteManifest() error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// NB: logAndApply releases the manifest lock.
+	d.mu.versions.logLock()
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	return d.mu.versions.logAndApply(
+		jobID,
+		&versionEdit{},
+		map[int]*LevelMetrics{},
+		true, /* forceRotation */
+		func() []compactionInfo { return d.getInProgressCompactionInfoLocked(nil) })
+}
+
+// reportMetrics invokes EventListener.MetricsReported every
+// Options.MetricsInterval until the DB is closed.
+func (d *DB) reportMetrics() {
+	defer func() {
+		d.mu.Lock()
+		d.mu.metricsReport.running = false
+		d.mu.metricsReport.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+	prev, prevTime := &Metrics{}, d.timeNow()
+	timer := time.NewTimer(d.opts.MetricsInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-timer.C:
+		}
+		m, now := d.Metrics(), d.timeNow()
+		d.opts.EventListener.MetricsReported(MetricsReportInfo{
+			Metrics:  m,
+			Delta:    m.Delta(prev),
+			Interval: now.Sub(prevTime),
+		})
+		prev, prevTime = m, now
+		timer.Reset(d.opts.MetricsInterval)
+	}
+}
+
 // Metrics returns metrics about the database.
 func (d *DB) Metrics() *Metrics {
 	metrics := &Metrics{}
@@ -1755,6 +2150,10 @@ func (d *DB) Metrics() *Metrics {
 	for _, size := range d.mu.versions.zombieTables {
 		metrics.Table.ZombieSize += size
 	}
+	if d.opts.private.remoteFS != nil {
+		metrics.Remote.Lag = d.timeNow().Sub(d.mu.remote.lastRefresh)
+		metrics.Remote.Refreshes = d.mu.remote.refreshes
+	}
 	metrics.private.optionsFileSize = d.optionsFileSize
 
 	// TODO(jackson): Consider making these metrics optional.
@@ -1775,11 +2174,22 @@ func (d *DB) Metrics() *Metrics {
 	}
 	for i := 0; i < numLevels; i++ {
 		metrics.Levels[i].Additional.ValueBlocksSize = valueBlocksSizeForLevel(vers, i)
+		metrics.Levels[i].Additional.UncompressedSize, metrics.Levels[i].Additional.CompressedSize =
+			compressionSizesForLevel(vers, i)
 	}
 
 	d.mu.Unlock()
 
 	metrics.BlockCache = d.opts.Cache.Metrics()
+	metrics.BlockCacheDB = d.opts.Cache.IDMetrics(d.cacheID)
+	metrics.SecondaryCache = d.objProvider.SharedCacheMetrics()
+	metrics.OrphanedReplicas = int64(d.objProvider.OrphanedReplicas())
+	if d.manifestReplicator != nil {
+		d.manifestReplicator.metrics(metrics)
+	}
+	if d.replicaUploader != nil {
+		d.replicaUploader.metrics(metrics)
+	}
 	metrics.TableCache, metrics.Filter = d.tableCache.metrics()
 	metrics.TableIters = int64(d.tableCache.iterCount())
 	return metrics
@@ -1810,12 +2220,33 @@ type SSTableInfo struct {
 
 	// Properties is the sstable properties of this table.
 	Properties *sstable.Properties
+
+	// Virtual is set if the table is a virtual table, backed by a part of an
+	// sstable (see IngestFiles). The Properties of a virtual table are those
+	// of its sstable.
+	Virtual bool
+}
+
+// CompressionRatio returns the ratio of the size of the keys and values of the
+// table before their encoding and compression to the size of the table, or 0
+// if the table properties were not retrieved (see WithProperties).
+func (i *SSTableInfo) CompressionRatio() float64 {
+	if i.Properties == nil || i.Size == 0 {
+		return 0
+	}
+	return float64(uncompressedSize(i.Properties)) / float64(i.Size)
 }
 
 // SSTables retrieves the current sstables. The returned slice is indexed by
 // level and each level is indexed by the position of the sstable within the
 // level. Note that this information may be out of date due to concurrent
 // flushes and compactions.
+//
+// All of the sstables are retrieved from the same version of the LSM, so the
+// result is a consistent view of the per-level file numbers, key bounds, sizes
+// and sequence number ranges, suitable for placement and replication tooling.
+// The sstables of L1 and lower levels are ordered by key, and those of L0 by
+// sequence number, oldest first.
 func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 	opt := &sstablesOptions{}
 	for _, fn := range opts {
@@ -1842,7 +2273,7 @@ func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 		iter := srcLevels[i].Iter()
 		j := 0
 		for m := iter.First(); m != nil; m = iter.Next() {
-			destTables[j] = SSTableInfo{TableInfo: m.TableInfo()}
+			destTables[j] = SSTableInfo{TableInfo: m.TableInfo(), Virtual: m.Virtual != nil}
 			if opt.withProperties {
 				p, err := d.tableCache.getTableProperties(m)
 				if err != nil {
@@ -1869,12 +2300,62 @@ func (d *DB) SSTables(opts ...SSTablesOption) ([][]SSTableInfo, error) {
 //     meta-block space is counted for partially overlapped files.
 //   - There may also exist WAL entries for unflushed keys in this range. This
 //     estimation currently excludes space used for the range in the WAL.
+//
+// The estimate includes the space used by keys deleted by range deletions
+// which compactions have not reclaimed yet. EstimateDiskUsageMulti also
+// estimates that space (see DiskUsage.Shadowed).
 func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
+	usage, err := d.EstimateDiskUsageMulti([]DiskUsageSpan{{Start: start, End: end}})
+	if err != nil {
+		return 0, err
+	}
+	return usage[0].Total, nil
+}
+
+// DiskUsageSpan is a key range [Start, End] (inclusive on both ends) whose disk
+// usage is estimated by EstimateDiskUsageMulti.
+type DiskUsageSpan struct {
+	Start []byte
+	End   []byte
+}
+
+// DiskUsage is the estimated filesystem space used by a key range, as returned
+// by EstimateDiskUsageMulti.
+type DiskUsage struct {
+	// Total is the sum of the space used in all levels.
+	Total uint64
+	// Levels is the space used in each level.
+	Levels [numLevels]uint64
+	// Shadowed is the part of Total used by keys deleted by range deletions,
+	// which compactions have not reclaimed yet, e.g. shortly after the range
+	// is cleared by a DeleteRange. It is estimated from the table stats of
+	// the sstables holding the range deletions (see
+	// TableStats.RangeDeletionsBytesEstimate), so the range deletions of the
+	// sstables whose table stats are not loaded yet, and those still in the
+	// memtables, are not accounted for.
+	Shadowed uint64
+}
+
+// Net returns the estimated space used by the live keys of the range, i.e.
+// Total without the space used by the keys deleted by range deletions.
+func (u DiskUsage) Net() uint64 {
+	return u.Total - u.Shadowed
+}
+
+// EstimateDiskUsageMulti returns the estimated filesystem space used for
+// storing each of the given key ranges, broken down by level. The estimations
+// are computed as in EstimateDiskUsage, but against a single version of the
+// LSM, and the sstables partially contained in several ranges are only looked
+// up once per call. It is cheaper than calling EstimateDiskUsage for each range.
+func (d *DB) EstimateDiskUsageMulti(spans []DiskUsageSpan) ([]DiskUsage, error) {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
 	}
-	if d.opts.Comparer.Compare(start, end) > 0 {
-		return 0, errors.New("invalid key-range specified (start > end)")
+	cmp := d.opts.Comparer.Compare
+	for _, s := range spans {
+		if cmp(s.Start, s.End) > 0 {
+			return nil, errors.New("invalid key-range specified (start > end)")
+		}
 	}
 
 	// Grab and reference the current readState. This prevents the underlying
@@ -1883,42 +2364,160 @@ func (d *DB) EstimateDiskUsage(start, end []byte) (uint64, error) {
 	readState := d.loadReadState()
 	defer readState.unref()
 
-	var totalSize uint64
+	usage := make([]DiskUsage, len(spans))
+	// partialSpans maps the sstables of a level that are partially contained in
+	// some of the spans to the indexes of those spans, and partialFiles lists
+	// those sstables in the order they were found.
+	partialSpans := make(map[*fileMetadata][]int)
+	var partialFiles []*fileMetadata
 	for level, files := range readState.current.Levels {
-		iter := files.Iter()
-		if level > 0 {
-			// We can only use `Overlaps` to restrict `files` at L1+ since at L0 it
-			// expands the range iteratively until it has found a set of files that
-			// do not overlap any other L0 files outside that set.
-			overlaps := readState.current.Overlaps(level, d.opts.Comparer.Compare, start, end, false /* exclusiveEnd */)
-			iter = overlaps.Iter()
+		for _, file := range partialFiles {
+			delete(partialSpans, file)
 		}
-		for file := iter.First(); file != nil; file = iter.Next() {
-			if d.opts.Comparer.Compare(start, file.Smallest.UserKey) <= 0 &&
-				d.opts.Comparer.Compare(file.Largest.UserKey, end) <= 0 {
-				// The range fully contains the file, so skip looking it up in
-				// table cache/looking at its indexes, and add the full file size.
-				totalSize += file.Size
-			} else if d.opts.Comparer.Compare(file.Smallest.UserKey, end) <= 0 &&
-				d.opts.Comparer.Compare(start, file.Largest.UserKey) <= 0 {
-				var size uint64
-				err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
-					size, err = r.EstimateDiskUsage(start, end)
-					return err
-				})
-				if err != nil {
-					return 0, err
+		partialFiles = partialFiles[:0]
+		for i, s := range spans {
+			iter := files.Iter()
+			if level > 0 {
+				// We can only use `Overlaps` to restrict `files` at L1+ since at L0 it
+				// expands the range iteratively until it has found a set of files that
+				// do not overlap any other L0 files outside that set.
+				overlaps := readState.current.Overlaps(level, cmp, s.Start, s.End, false /* exclusiveEnd */)
+				iter = overlaps.Iter()
+			}
+			for file := iter.First(); file != nil; file = iter.Next() {
+				if cmp(s.Start, file.Smallest.UserKey) <= 0 &&
+					cmp(file.Largest.UserKey, s.End) <= 0 {
+					// The range fully contains the file, so skip looking it up in
+					// table cache/looking at its indexes, and add the full file size.
+					usage[i].Levels[level] += file.Size
+					// The range also contains the range deletions of the file.
+					if file.StatsValid() {
+						usage[i].Shadowed += file.Stats.RangeDeletionsBytesEstimate
+					}
+				} else if cmp(file.Smallest.UserKey, s.End) <= 0 &&
+					cmp(s.Start, file.Largest.UserKey) <= 0 {
+					if _, ok := partialSpans[file]; !ok {
+						partialFiles = append(partialFiles, file)
+					}
+					partialSpans[file] = append(partialSpans[file], i)
+				}
+			}
+		}
+		for _, file := range partialFiles {
+			err := d.tableCache.withReader(file, func(r *sstable.Reader) error {
+				for _, i := range partialSpans[file] {
+					size, err := estimateTableDiskUsage(cmp, r, file, spans[i].Start, spans[i].End)
+					if err != nil {
+						return err
+					}
+					usage[i].Levels[level] += size
+					if file.StatsValid() && file.Stats.RangeDeletionsBytesEstimate > 0 {
+						shadowed, err := d.estimateShadowedBytes(r, readState.current, level, file,
+							spans[i].Start, spans[i].End)
+						if err != nil {
+							return err
+						}
+						usage[i].Shadowed += shadowed
+					}
 				}
-				totalSize += size
+				return nil
+			})
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
-	return totalSize, nil
+	for i := range usage {
+		for _, size := range usage[i].Levels {
+			usage[i].Total += size
+		}
+		// The estimates of overlapping range deletions at different levels
+		// may count the same keys several times.
+		if usage[i].Shadowed > usage[i].Total {
+			usage[i].Shadowed = usage[i].Total
+		}
+	}
+	return usage, nil
+}
+
+// MakeReaderOptions returns the sstable.ReaderOptions the DB uses to read
+// tables, modified by the given overrides in order.
+func (d *DB) MakeReaderOptions(overrides ...func(*sstable.ReaderOptions)) sstable.ReaderOptions {
+	opts := d.opts.MakeReaderOptions()
+	for _, o := range overrides {
+		o(&opts)
+	}
+	return opts
+}
+
+// MakeWriterOptions returns the sstable.WriterOptions the DB uses to write
+// tables to the given level, modified by the given overrides in order. The
+// options include the table format the DB writes at its current format major
+// version, so that sstables written with them to be ingested are accepted by
+// the DB. Callers which override the table format must not exceed
+// FormatMajorVersion().MaxTableFormat().
+func (d *DB) MakeWriterOptions(
+	level int, overrides ...func(*sstable.WriterOptions),
+) sstable.WriterOptions {
+	// The level options may be changed by SetOptions, with d.mu held.
+	d.mu.Lock()
+	opts := d.makeWriterOptions(level, d.mu.formatVers.vers, d.mu.versions.dbID)
+	d.mu.Unlock()
+	for _, o := range overrides {
+		o(&opts)
+	}
+	return opts
+}
+
+// makeWriterOptions returns the sstable.WriterOptions used to write tables to
+// the given level at the given format major version, by the DB with the given
+// ID.
+func (d *DB) makeWriterOptions(
+	level int, formatVers FormatMajorVersion, dbID string,
+) sstable.WriterOptions {
+	// The table is typically written at the maximum allowable format implied by
+	// the current format major version of the DB.
+	tableFormat := formatVers.MaxTableFormat()
+	if tableFormat > sstable.TableFormatPebblev4 {
+		// Since TableFormatPebblev3 does not currently subsume
+		// TableFormatPebblev2, this panic ensures that we have carefully thought
+		// through what we are doing before we introduce a format beyond
+		// TableFormatPebblev4.
+		panic("cannot handle table format beyond TableFormatPebblev4")
+	}
+	valueBlocks := d.opts.Experimental.EnableValueBlocks != nil && d.opts.Experimental.EnableValueBlocks()
+	// TableFormatPebblev4 is only needed for compression dictionaries.
+	levelOpts := d.opts.Level(level)
+	if tableFormat == sstable.TableFormatPebblev4 &&
+		(levelOpts.Compression != ZstdCompression || levelOpts.CompressionDictSize <= 0) {
+		tableFormat = sstable.TableFormatPebblev3
+	}
+	if tableFormat == sstable.TableFormatPebblev3 && !valueBlocks {
+		tableFormat = sstable.TableFormatPebblev2
+	}
+	writerOpts := d.opts.MakeWriterOptions(level, tableFormat)
+	writerOpts.DBID = dbID
+	writerOpts.DisableValueBlocks = !valueBlocks
+	if formatVers < FormatBlockPropertyCollector {
+		// Cannot yet write block properties.
+		writerOpts.BlockPropertyCollectors = nil
+	}
+	if formatVers < FormatXXH3Checksums && writerOpts.Checksum == ChecksumTypeXXH3 {
+		// Cannot yet write XXH3 checksums.
+		writerOpts.Checksum = ChecksumTypeCRC32c
+	}
+	return writerOpts
 }
 
 func (d *DB) walPreallocateSize() int {
-	// Set the WAL preallocate size to 110% of the memtable size. Note that there
-	// is a bit of apples and oranges in units here as the memtabls size
+	switch {
+	case d.opts.WALPreallocateSize < 0:
+		return 0
+	case d.opts.WALPreallocateSize > 0:
+		return d.opts.WALPreallocateSize
+	}
+	// Default the WAL preallocate size to 110% of the memtable size. Note that
+	// there is a bit of apples and oranges in units here as the memtabls size
 	// corresponds to the memory usage of the memtable while the WAL size is the
 	// size of the batches (plus overhead) stored in the WAL.
 	//
@@ -1930,6 +2529,20 @@ func (d *DB) walPreallocateSize() int {
 	return size
 }
 
+// walRecycleLimit returns the maximum number of obsolete WAL files to keep for
+// recycling.
+func walRecycleLimit(opts *Options) int {
+	switch {
+	case opts.WALRecycleLimit < 0:
+		return 0
+	case opts.WALRecycleLimit > 0:
+		return opts.WALRecycleLimit
+	}
+	// By default, keep enough logs to be able to recycle a WAL for each of the
+	// memtables which may be queued before writes stop.
+	return opts.MemTableStopWritesThreshold + 1
+}
+
 func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushableEntry) {
 	size := d.mu.mem.nextSize
 	if d.mu.mem.nextSize < d.opts.MemTableSize {
@@ -1941,7 +2554,7 @@ func (d *DB) newMemTable(logNum FileNum, logSeqNum uint64) (*memTable, *flushabl
 
 	atomic.AddInt64(&d.atomic.memTableCount, 1)
 	atomic.AddInt64(&d.atomic.memTableReserved, int64(size))
-	releaseAccountingReservation := d.opts.Cache.Reserve(size)
+	releaseAccountingReservation := d.opts.Cache.ReserveForID(d.cacheID, size)
 
 	mem := newMemTable(memTableOptions{
 		Options:   d.opts,
@@ -2072,7 +2685,7 @@ func (d *DB) makeRoomForWrite(b *Batch) error {
 			entry := d.newFlushableEntry(b.flushable, imm.logNum, b.SeqNum())
 			// The large batch is by definition large. Reserve space from the cache
 			// for it until it is flushed.
-			entry.releaseMemAccounting = d.opts.Cache.Reserve(int(b.flushable.totalBytes()))
+			entry.releaseMemAccounting = d.opts.Cache.ReserveForID(d.cacheID, int(b.flushable.totalBytes()))
 			d.mu.mem.queue = append(d.mu.mem.queue, entry)
 		}
 
@@ -2310,5 +2923,12 @@ func (d *DB) SetCreatorID(creatorID uint64) error {
 	if d.opts.Experimental.SharedStorage == nil || d.opts.ReadOnly {
 		return nil
 	}
-	return d.objProvider.SetCreatorID(objstorage.CreatorID(creatorID))
+	if err := d.objProvider.SetCreatorID(objstorage.CreatorID(creatorID)); err != nil {
+		return err
+	}
+	if d.manifestReplicator != nil {
+		// The MANIFEST can be replicated now.
+		d.manifestReplicator.retry()
+	}
+	return nil
 }
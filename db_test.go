@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,9 +20,12 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/invariants"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
@@ -584,9 +588,42 @@ func TestLogData(t *testing.T) {
 	}()
 
 	require.NoError(t, d.LogData([]byte("foo"), Sync))
-	require.NoError(t, d.LogData([]byte("bar"), Sync))
-	// TODO(itsbilal): Confirm that we wrote some bytes to the WAL.
-	// For now, LogData proceeding ahead without a panic is good enough.
+	b := d.NewBatch()
+	require.NoError(t, b.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, b.LogData([]byte("bar"), nil))
+	require.NoError(t, b.Commit(Sync))
+
+	// The log data is written to the WAL in order with the other operations.
+	d.mu.Lock()
+	logNum := d.mu.log.queue[len(d.mu.log.queue)-1].fileNum
+	d.mu.Unlock()
+	f, err := d.opts.FS.Open(base.MakeFilepath(d.opts.FS, d.walDirname, fileTypeLog, logNum))
+	require.NoError(t, err)
+	defer f.Close()
+	var entries []string
+	rr := record.NewReader(f, logNum)
+	for {
+		r, err := rr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		repr, err := io.ReadAll(r)
+		require.NoError(t, err)
+		for br, _ := ReadBatch(repr); len(br) > 0; {
+			kind, ukey, _, ok := br.Next()
+			require.True(t, ok)
+			entries = append(entries, fmt.Sprintf("%s:%s", kind, ukey))
+		}
+	}
+	require.Equal(t, []string{"LOGDATA:foo", "SET:a", "LOGDATA:bar"}, entries)
+
+	// The log data is not added to the memtable.
+	iter := d.NewIter(nil)
+	require.True(t, iter.First())
+	require.Equal(t, []byte("a"), iter.Key())
+	require.False(t, iter.Next())
+	require.NoError(t, iter.Close())
 }
 
 func TestSingleDeleteGet(t *testing.T) {
@@ -787,6 +824,56 @@ func TestIterLeakSharedCache(t *testing.T) {
 	}
 }
 
+func TestSharedCacheQuota(t *testing.T) {
+	c := NewCache(64 << 20)
+	defer c.Unref()
+
+	open := func(quota int64) *DB {
+		d, err := Open("", &Options{
+			Cache:        c,
+			CacheQuota:   quota,
+			FS:           vfs.NewMem(),
+			MemTableSize: 256 << 10,
+		})
+		require.NoError(t, err)
+		value := bytes.Repeat([]byte("v"), 1024)
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%06d", i)), value, nil))
+		}
+		require.NoError(t, d.Flush())
+		for i := 0; i < 2000; i++ {
+			_, closer, err := d.Get([]byte(fmt.Sprintf("%06d", i)))
+			require.NoError(t, err)
+			require.NoError(t, closer.Close())
+		}
+		return d
+	}
+	const quota = 512 << 10
+	d1, d2 := open(0), open(quota)
+
+	m1, m2 := d1.Metrics(), d2.Metrics()
+	require.Equal(t, m1.BlockCache, m2.BlockCache)
+	require.Equal(t, m1.BlockCache.Size, m1.BlockCacheDB.Size+m2.BlockCacheDB.Size)
+	require.Equal(t, m1.BlockCache.Reserved, m1.BlockCacheDB.Reserved+m2.BlockCacheDB.Reserved)
+	require.Greater(t, m1.BlockCacheDB.Size, int64(2*quota))
+	require.Greater(t, m1.BlockCacheDB.Hits, int64(0))
+	require.GreaterOrEqual(t, m2.BlockCacheDB.Reserved, int64(256<<10))
+	require.Greater(t, m2.BlockCacheDB.Size, int64(0))
+	// The quota is rounded up for each shard of the cache.
+	require.LessOrEqual(t, m2.BlockCacheDB.Size+m2.BlockCacheDB.Reserved, int64(quota+runtime.GOMAXPROCS(0)*2))
+
+	// Lifting the quota at runtime allows d2 to cache all its blocks.
+	require.NoError(t, d2.SetOptions(map[string]string{"cache_quota": "0"}))
+	iter := d2.NewIter(nil)
+	for iter.First(); iter.Valid(); iter.Next() {
+	}
+	require.NoError(t, iter.Close())
+	require.Greater(t, d2.Metrics().BlockCacheDB.Size, int64(2*quota))
+
+	require.NoError(t, d1.Close())
+	require.NoError(t, d2.Close())
+}
+
 func TestMemTableReservation(t *testing.T) {
 	cache := NewCache(128 << 10 /* 128 KB */)
 	defer cache.Unref()
@@ -1097,6 +1184,7 @@ func TestDBClosed(t *testing.T) {
 	require.True(t, errors.Is(catch(func() { _ = d.Merge(nil, nil, nil) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.RatchetFormatMajorVersion(FormatNewest) }), ErrClosed))
 	require.True(t, errors.Is(catch(func() { _ = d.Set(nil, nil, nil) }), ErrClosed))
+	require.True(t, errors.Is(catch(func() { _ = d.SetOptions(nil) }), ErrClosed))
 
 	require.True(t, errors.Is(catch(func() { _ = d.NewSnapshot() }), ErrClosed))
 
@@ -1288,6 +1376,125 @@ func TestSSTables(t *testing.T) {
 			require.NotNil(t, info.Properties)
 		}
 	}
+
+	// The tables are listed with their bounds and sequence numbers, in L0
+	// oldest first.
+	check := func(level int, want ...string) {
+		t.Helper()
+		tableInfos, err := d.SSTables()
+		require.NoError(t, err)
+		var got []string
+		for _, info := range tableInfos[level] {
+			require.NotZero(t, info.Size)
+			got = append(got, fmt.Sprintf("%s-%s #%d-%d", info.Smallest.UserKey,
+				info.Largest.UserKey, info.SmallestSeqNum, info.LargestSeqNum))
+		}
+		require.Equal(t, want, got)
+	}
+	check(0, "hello-hello #1-1", "world-world #2-2")
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	check(0)
+	check(6, "hello-world #0-0")
+}
+
+func TestEstimateDiskUsageMulti(t *testing.T) {
+	d, err := Open("", &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+		Levels:                      []LevelOptions{{BlockSize: 256}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Populate L6 and L0 with keys spread over many blocks.
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), bytes.Repeat([]byte("v"), 64), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	write(0, 1000)
+	require.NoError(t, d.Compact([]byte("0000"), []byte("1000"), false /* parallelize */))
+	write(200, 400)
+	write(600, 800)
+
+	spans := []DiskUsageSpan{
+		{Start: []byte("0000"), End: []byte("9999")},
+		{Start: []byte("0100"), End: []byte("0300")},
+		{Start: []byte("0300"), End: []byte("0700")},
+		{Start: []byte("0900"), End: []byte("0950")},
+		{Start: []byte("a"), End: []byte("b")},
+	}
+	usage, err := d.EstimateDiskUsageMulti(spans)
+	require.NoError(t, err)
+	require.Len(t, usage, len(spans))
+	for i, s := range spans {
+		size, err := d.EstimateDiskUsage(s.Start, s.End)
+		require.NoError(t, err)
+		require.Equal(t, size, usage[i].Total)
+		var sum uint64
+		for _, levelSize := range usage[i].Levels {
+			sum += levelSize
+		}
+		require.Equal(t, usage[i].Total, sum)
+	}
+	m := d.Metrics()
+	for level := range m.Levels {
+		require.Equal(t, uint64(m.Levels[level].Size), usage[0].Levels[level])
+	}
+	require.NotZero(t, usage[1].Levels[0])
+	require.NotZero(t, usage[1].Levels[6])
+	require.Zero(t, usage[3].Levels[0])
+	require.NotZero(t, usage[3].Levels[6])
+	require.Less(t, usage[3].Total, usage[1].Total)
+	require.Equal(t, DiskUsage{}, usage[4])
+
+	_, err = d.EstimateDiskUsageMulti([]DiskUsageSpan{{Start: []byte("b"), End: []byte("a")}})
+	require.Error(t, err)
+}
+
+func TestEstimateDiskUsageShadowed(t *testing.T) {
+	d, err := Open("", &Options{
+		DisableAutomaticCompactions: true,
+		FS:                          vfs.NewMem(),
+		Levels:                      []LevelOptions{{BlockSize: 256}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), bytes.Repeat([]byte("v"), 64), nil))
+	}
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("0000"), []byte("1000"), false /* parallelize */))
+	require.NoError(t, d.DeleteRange([]byte("0200"), []byte("0600"), nil))
+	require.NoError(t, d.Flush())
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+
+	spans := []DiskUsageSpan{
+		{Start: []byte("0000"), End: []byte("9999")},
+		{Start: []byte("0100"), End: []byte("0300")},
+		{Start: []byte("0300"), End: []byte("0400")},
+		{Start: []byte("0700"), End: []byte("0900")},
+	}
+	usage, err := d.EstimateDiskUsageMulti(spans)
+	require.NoError(t, err)
+	for _, u := range usage {
+		require.Equal(t, u.Total-u.Shadowed, u.Net())
+	}
+	// The shadowed space is estimated at data block granularity, like the
+	// space used by the deleted keys in L6.
+	deleted, err := d.EstimateDiskUsageMulti([]DiskUsageSpan{{Start: []byte("0200"), End: []byte("0599")}})
+	require.NoError(t, err)
+	require.InDelta(t, float64(deleted[0].Levels[6]), float64(usage[0].Shadowed), 0.05*float64(deleted[0].Levels[6]))
+	// Half of the keys of [0100, 0300] are deleted, and all those of [0300,
+	// 0400].
+	require.InDelta(t, 0.5*float64(usage[1].Levels[6]), float64(usage[1].Shadowed), 0.1*float64(usage[1].Levels[6]))
+	require.InDelta(t, float64(usage[2].Levels[6]), float64(usage[2].Shadowed), 0.1*float64(usage[2].Levels[6]))
+	require.NotZero(t, usage[3].Total)
+	require.Zero(t, usage[3].Shadowed)
 }
 
 type testTracer struct {
@@ -1462,6 +1669,85 @@ func BenchmarkNewIterReadAmp(b *testing.B) {
 	}
 }
 
+func TestNewIterReuse(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+
+	iter := d.NewIter(nil)
+	require.True(t, iter.First())
+	require.Equal(t, "a", string(iter.Key()))
+
+	// The new iterator observes the writes following the creation of the
+	// reused iterator, and its options.
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	iter, err = d.NewIterReuse(iter, &IterOptions{LowerBound: []byte("b")})
+	require.NoError(t, err)
+	var keys []string
+	for valid := iter.First(); valid; valid = iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.Equal(t, []string{"b", "c"}, keys)
+	require.NoError(t, iter.Close())
+
+	// Iterators created from snapshots can be reused too.
+	snap := d.NewSnapshot()
+	defer snap.Close()
+	require.NoError(t, d.Delete([]byte("a"), nil))
+	iter, err = d.NewIterReuse(snap.NewIter(nil), nil)
+	require.NoError(t, err)
+	require.True(t, iter.First())
+	require.Equal(t, "b", string(iter.Key()))
+	require.NoError(t, iter.Close())
+
+	// The allocations are reused.
+	key := []byte("b")
+	iter = d.NewIter(nil)
+	allocs := testing.AllocsPerRun(100, func() {
+		iter, err = d.NewIterReuse(iter, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iter.SeekGE(key)
+	})
+	require.NoError(t, iter.Close())
+	require.Zero(t, allocs)
+}
+
+func BenchmarkNewIterReuse(b *testing.B) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(b, err)
+	defer func() { require.NoError(b, d.Close()) }()
+	for _, k := range []string{"a", "b", "c"} {
+		require.NoError(b, d.Set([]byte(k), nil, nil))
+		require.NoError(b, d.Flush())
+	}
+
+	key := []byte("b")
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			iter := d.NewIter(nil)
+			iter.SeekGE(key)
+			require.NoError(b, iter.Close())
+		}
+	})
+	b.Run("reuse", func(b *testing.B) {
+		b.ReportAllocs()
+		iter := d.NewIter(nil)
+		for i := 0; i < b.N; i++ {
+			iter, err = d.NewIterReuse(iter, nil)
+			require.NoError(b, err)
+			iter.SeekGE(key)
+		}
+		require.NoError(b, iter.Close())
+	})
+}
+
 func verifyGet(t *testing.T, r Reader, key, expected []byte) {
 	val, closer, err := r.Get(key)
 	require.NoError(t, err)
@@ -1477,3 +1763,123 @@ func verifyGetNotFound(t *testing.T, r Reader, key []byte) {
 		t.Fatalf("expected nil, but got %s", val)
 	}
 }
+
+func TestMarkFiltersForRewrite(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("e"), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	filterPolicies := func() []string {
+		tables, err := d.SSTables(WithProperties())
+		require.NoError(t, err)
+		var names []string
+		for level, files := range tables {
+			for _, f := range files {
+				names = append(names, fmt.Sprintf("L%d:%q", level, f.Properties.FilterPolicyName))
+			}
+		}
+		return names
+	}
+
+	opts := &Options{FS: mem}
+	opts.Levels = make([]LevelOptions, numLevels)
+	opts.Levels[numLevels-1].FilterPolicy = bloom.FilterPolicy(20)
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.Equal(t, []string{`L6:""`}, filterPolicies())
+
+	require.Error(t, d.MarkFiltersForRewrite(numLevels))
+	require.NoError(t, d.MarkFiltersForRewrite(numLevels-1))
+	d.mu.Lock()
+	for d.mu.versions.currentVersion().Stats.MarkedForCompaction > 0 || d.mu.compact.compactingCount > 0 {
+		d.mu.compact.cond.Wait()
+	}
+	d.mu.Unlock()
+	require.Equal(t, []string{`L6:"rocksdb.BuiltinBloomFilter"`}, filterPolicies())
+}
+
+func TestDBIDStamping(t *testing.T) {
+	store := shared.NewInMem()
+	opts := &Options{FS: vfs.NewMem(), FormatMajorVersion: FormatDBID}
+	opts.Experimental.SharedStorage = store
+	opts.Experimental.CreateOnShared = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+	id := d.ID()
+	require.NotEmpty(t, id)
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	// The output of the compaction is written to shared storage.
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+
+	// The table records the DB ID in its properties.
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Len(t, tables[numLevels-1], 1)
+	require.Equal(t, id, tables[numLevels-1][0].Properties.DBID)
+
+	// The shared object is tagged with the DB ID.
+	objs, err := store.List("", "")
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+	md, err := store.(shared.MetadataStorage).ObjectMetadata(objs[0])
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{DBIDObjectMetadataKey: id}, md)
+}
+
+func TestSubscribeVersionEdits(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, d.Flush())
+
+	// Track the live tables through the version edits.
+	live := make(map[FileNum]int)
+	var infos []string
+	unsubscribe := d.SubscribeVersionEdits(func(info VersionEditInfo) {
+		for _, t := range info.Deleted {
+			delete(live, t.FileNum)
+		}
+		for _, t := range info.Added {
+			live[t.FileNum] = t.Level
+		}
+		infos = append(infos, info.String())
+	})
+	require.Equal(t, []string{"[JOB 0] version edit applied: +L0:000005"}, infos)
+
+	for _, k := range []string{"b", "c"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("d"), false /* parallelize */))
+
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	expected := make(map[FileNum]int)
+	for level := range tables {
+		for _, t := range tables[level] {
+			expected[t.FileNum] = level
+		}
+	}
+	require.Equal(t, expected, live)
+
+	// No version edits are delivered after unsubscribing.
+	unsubscribe()
+	n := len(infos)
+	require.NoError(t, d.Set([]byte("d"), []byte("d"), nil))
+	require.NoError(t, d.Flush())
+	require.Equal(t, n, len(infos))
+}
@@ -230,6 +230,34 @@ func (i ManifestCreateInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] MANIFEST created %s", redact.Safe(i.JobID), redact.Safe(i.FileNum))
 }
 
+// ManifestReplicaInfo contains the info for the upload of the replica of the
+// MANIFEST to shared storage (see Options.Experimental.ReplicateManifest).
+type ManifestReplicaInfo struct {
+	// FileNum is the file number of the MANIFEST.
+	FileNum FileNum
+	// Size is the size of the part of the MANIFEST uploaded.
+	Size int64
+	// Duration is the duration of the upload.
+	Duration time.Duration
+	// Err is set if the upload failed. The upload is retried, and the
+	// replica lags the MANIFEST until it succeeds.
+	Err error
+}
+
+func (i ManifestReplicaInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i ManifestReplicaInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	if i.Err != nil {
+		w.Printf("MANIFEST %s replica upload error: %s", redact.Safe(i.FileNum), i.Err)
+		return
+	}
+	w.Printf("MANIFEST %s replica uploaded (%s) in %.1fs", redact.Safe(i.FileNum),
+		humanize.Int64(i.Size), redact.Safe(i.Duration.Seconds()))
+}
+
 // ManifestDeleteInfo contains the info for a Manifest deletion event.
 type ManifestDeleteInfo struct {
 	// JobID is the ID of the job the caused the Manifest to be deleted.
@@ -252,6 +280,16 @@ func (i ManifestDeleteInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] MANIFEST deleted %s", redact.Safe(i.JobID), redact.Safe(i.FileNum))
 }
 
+// ObsoleteFileInfo contains the info for an obsolete file about to be deleted
+// (see Options.Experimental.ObsoleteFileDeletionFilter).
+type ObsoleteFileInfo struct {
+	// JobID is the ID of the job deleting the file.
+	JobID    int
+	FileType FileType
+	FileNum  FileNum
+	Path     string
+}
+
 // TableCreateInfo contains the info for a table creation event.
 type TableCreateInfo struct {
 	JobID int
@@ -297,10 +335,14 @@ func (i TableDeleteInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 // TableIngestInfo contains the info for a table ingestion event.
 type TableIngestInfo struct {
 	// JobID is the ID of the job the caused the table to be ingested.
-	JobID  int
+	JobID int
+	// Tables holds the ingested tables and the levels they were ingested
+	// into. Copied is set for the tables which were copied into the DB
+	// directory rather than hard linked.
 	Tables []struct {
 		TableInfo
-		Level int
+		Level  int
+		Copied bool
 	}
 	// GlobalSeqNum is the sequence number that was assigned to all entries in
 	// the ingested table.
@@ -374,6 +416,127 @@ func (i TableValidatedInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] validated table: %s", redact.Safe(i.JobID), i.Meta)
 }
 
+// TenantQuotaInfo contains the info for a tenant quota event, which reports
+// that the estimated disk space used by the keys of a tenant reached its
+// quota (see Options.Experimental.TenantQuotas).
+type TenantQuotaInfo struct {
+	// Tenant is the ID of the tenant.
+	Tenant string
+	// Usage is the estimated disk space used by the keys of the tenant.
+	Usage uint64
+	// Quota is the quota of the tenant.
+	Quota uint64
+	// Rejected is true if the writes of the tenant are rejected, rather than
+	// only flagged (see TenantQuotas.FlagOnly).
+	Rejected bool
+}
+
+func (i TenantQuotaInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i TenantQuotaInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("tenant %q reached its quota: %s used, quota %s", i.Tenant,
+		redact.Safe(humanize.Uint64(i.Usage)), redact.Safe(humanize.Uint64(i.Quota)))
+	if i.Rejected {
+		w.Printf("; rejecting writes")
+	}
+}
+
+// ScrubAction describes what the scrubber did about a corrupt sstable or
+// replica (see DB.Scrub).
+type ScrubAction int
+
+const (
+	// ScrubReplicaUploaded indicates that the replica of a local sstable was
+	// corrupt and was uploaded again from the healthy local sstable.
+	ScrubReplicaUploaded ScrubAction = iota
+	// ScrubLocalRestored indicates that a local sstable was corrupt and was
+	// restored from its healthy replica.
+	ScrubLocalRestored
+	// ScrubUnrecoverable indicates that an sstable was corrupt and could not
+	// be repaired, because it has no healthy replica.
+	ScrubUnrecoverable
+)
+
+// String implements fmt.Stringer.
+func (a ScrubAction) String() string {
+	switch a {
+	case ScrubReplicaUploaded:
+		return "replica uploaded"
+	case ScrubLocalRestored:
+		return "local table restored"
+	case ScrubUnrecoverable:
+		return "unrecoverable"
+	default:
+		return fmt.Sprintf("ScrubAction(%d)", int(a))
+	}
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (a ScrubAction) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Print(redact.SafeString(a.String()))
+}
+
+// TableRepairInfo contains the info for a table repair event, which reports
+// that data of a local sstable which was missing or corrupt was read from the
+// replica of the sstable on shared storage instead (see
+// Options.Experimental.ReplicateLocalTables).
+type TableRepairInfo struct {
+	FileNum FileNum
+	// Err is the error encountered with the local sstable: the sstable was not
+	// found, or a block read from it failed checksum validation.
+	Err error
+}
+
+func (i TableRepairInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i TableRepairInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("read table %s from its replica: %s", redact.Safe(i.FileNum), i.Err)
+}
+
+// TableScrubbedInfo contains the info for a scrub event, which reports an
+// sstable or replica found to be corrupt by the scrubber.
+type TableScrubbedInfo struct {
+	// JobID is the ID of the scrub.
+	JobID   int
+	Level   int
+	FileNum FileNum
+	Action  ScrubAction
+	// LocalErr is the error encountered when validating the sstable, if any.
+	// For a shared sstable, it is the error encountered when validating the
+	// object on shared storage.
+	LocalErr error
+	// ReplicaErr is the error encountered when validating the replica of the
+	// sstable, if any.
+	ReplicaErr error
+	// Err is the error that prevented the action from completing, if any.
+	Err error
+}
+
+func (i TableScrubbedInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i TableScrubbedInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("[JOB %d] scrubbed table L%d:%s: %s",
+		redact.Safe(i.JobID), redact.Safe(i.Level), redact.Safe(i.FileNum), i.Action)
+	if i.LocalErr != nil {
+		w.Printf("; table error: %s", i.LocalErr)
+	}
+	if i.ReplicaErr != nil {
+		w.Printf("; replica error: %s", i.ReplicaErr)
+	}
+	if i.Err != nil {
+		w.Printf("; error: %s", i.Err)
+	}
+}
+
 // WALCreateInfo contains info about a WAL creation event.
 type WALCreateInfo struct {
 	// JobID is the ID of the job the caused the WAL to be created.
@@ -429,6 +592,119 @@ func (i WALDeleteInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("[JOB %d] WAL deleted %s", redact.Safe(i.JobID), redact.Safe(i.FileNum))
 }
 
+// WALRecycleInfo contains the info for a WAL recycling event.
+type WALRecycleInfo struct {
+	// JobID is the ID of the job the caused the WAL to become obsolete.
+	JobID   int
+	Path    string
+	FileNum FileNum
+	// Size is the size of the WAL, which is overwritten when it is reused.
+	Size uint64
+}
+
+func (i WALRecycleInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i WALRecycleInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("[JOB %d] WAL %s kept for recycling (%s)",
+		redact.Safe(i.JobID), redact.Safe(i.FileNum), redact.Safe(humanize.Uint64(i.Size)))
+}
+
+// WALReplayInfo contains the info for a WAL replay progress event.
+type WALReplayInfo struct {
+	// JobID is the ID of the job replaying the WALs while opening the DB.
+	JobID int
+	// FileNum is the file number of the WAL being replayed.
+	FileNum FileNum
+	// Done is true if the replay of the WAL is complete.
+	Done bool
+	// RecordsReplayed is the number of records replayed so far, across all
+	// the WALs.
+	RecordsReplayed int64
+	// BytesReplayed is the number of bytes of the WALs read so far.
+	BytesReplayed int64
+	// TotalBytes is the total size of the WALs to replay. BytesReplayed may
+	// not reach it, since WALs may be followed by preallocated space.
+	TotalBytes int64
+}
+
+func (i WALReplayInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i WALReplayInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	verb := redact.SafeString("replaying")
+	if i.Done {
+		verb = "replayed"
+	}
+	w.Printf("[JOB %d] WAL %s %s: %d records, %s of %s",
+		redact.Safe(i.JobID), redact.Safe(i.FileNum), verb, redact.Safe(i.RecordsReplayed),
+		redact.Safe(humanize.IEC.Int64(i.BytesReplayed)), redact.Safe(humanize.IEC.Int64(i.TotalBytes)))
+}
+
+// VersionEditInfo contains the info for a version edit applied to the LSM
+// (see DB.SubscribeVersionEdits).
+type VersionEditInfo struct {
+	// JobID is the ID of the job which applied the version edit, or 0 for the
+	// tables of the current version delivered to a new subscriber.
+	JobID int
+	// Added holds the tables added to the LSM and their levels. A table moved
+	// to another level is both deleted from its previous level and added to its
+	// new one.
+	Added []struct {
+		TableInfo
+		Level int
+	}
+	// Deleted holds the tables removed from the LSM and their levels.
+	Deleted []struct {
+		TableInfo
+		Level int
+	}
+}
+
+func (i VersionEditInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i VersionEditInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("[JOB %d] version edit applied:", redact.Safe(i.JobID))
+	for j := range i.Added {
+		t := &i.Added[j]
+		w.Printf(" +L%d:%s", redact.Safe(t.Level), redact.Safe(t.FileNum))
+	}
+	for j := range i.Deleted {
+		t := &i.Deleted[j]
+		w.Printf(" -L%d:%s", redact.Safe(t.Level), redact.Safe(t.FileNum))
+	}
+}
+
+// MetricsReportInfo contains the info for a periodic report of the metrics of
+// the DB (see Options.MetricsInterval).
+type MetricsReportInfo struct {
+	// Metrics holds the current metrics of the DB.
+	Metrics *Metrics
+	// Delta holds the changes of the metrics since the previous report, or
+	// since the DB was opened for the first report (see Metrics.Delta).
+	Delta *Metrics
+	// Interval is the time elapsed since the previous report, or since the DB
+	// was opened for the first report.
+	Interval time.Duration
+}
+
+func (i MetricsReportInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i MetricsReportInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("metrics over the last %.1fs:\n%s",
+		redact.Safe(i.Interval.Seconds()), i.Delta)
+}
+
 // WriteStallBeginInfo contains the info for a write stall begin event.
 type WriteStallBeginInfo struct {
 	Reason string
@@ -443,6 +719,30 @@ func (i WriteStallBeginInfo) SafeFormat(w redact.SafePrinter, _ rune) {
 	w.Printf("write stall beginning: %s", redact.Safe(i.Reason))
 }
 
+// SnapshotPinnedInfo contains the info for a snapshot pinned budget event,
+// which reports an open snapshot pinning more bytes than
+// Options.SnapshotPinnedBytesBudget.
+type SnapshotPinnedInfo struct {
+	// JobID is the ID of the flush or compaction whose retained keys made the
+	// snapshot exceed the budget.
+	JobID    int
+	Snapshot SnapshotInfo
+	// Budget is Options.SnapshotPinnedBytesBudget.
+	Budget uint64
+}
+
+func (i SnapshotPinnedInfo) String() string {
+	return redact.StringWithoutMarkers(i)
+}
+
+// SafeFormat implements redact.SafeFormatter.
+func (i SnapshotPinnedInfo) SafeFormat(w redact.SafePrinter, _ rune) {
+	w.Printf("[JOB %d] snapshot #%d pins %s, exceeding the budget of %s",
+		redact.Safe(i.JobID), redact.Safe(i.Snapshot.SeqNum),
+		redact.Safe(humanize.Uint64(i.Snapshot.PinnedBytes)),
+		redact.Safe(humanize.Uint64(i.Budget)))
+}
+
 // EventListener contains a set of functions that will be invoked when various
 // significant DB events occur. Note that the functions should not run for an
 // excessive amount of time as they are invoked synchronously by the DB and may
@@ -489,6 +789,21 @@ type EventListener struct {
 	// ManifestDeleted is invoked after a manifest has been deleted.
 	ManifestDeleted func(ManifestDeleteInfo)
 
+	// ManifestReplicated is invoked after each attempt to upload the replica
+	// of the MANIFEST (see Options.Experimental.ReplicateManifest). A failed
+	// upload does not fail the operations which changed the MANIFEST: it is
+	// retried, and only reported here and in Metrics.ManifestReplica.
+	ManifestReplicated func(ManifestReplicaInfo)
+
+	// MetricsReported is invoked every Options.MetricsInterval with the
+	// metrics of the DB.
+	MetricsReported func(MetricsReportInfo)
+
+	// SnapshotPinnedBudgetExceeded is invoked when an open snapshot starts
+	// pinning more bytes than Options.SnapshotPinnedBytesBudget, at most once
+	// per snapshot. It is invoked with the DB mutex held.
+	SnapshotPinnedBudgetExceeded func(SnapshotPinnedInfo)
+
 	// TableCreated is invoked when a table has been created.
 	TableCreated func(TableCreateInfo)
 
@@ -503,15 +818,41 @@ type EventListener struct {
 	// collector has loaded statistics for all tables that existed at Open.
 	TableStatsLoaded func(TableStatsInfo)
 
+	// TableRepaired is invoked when data of a local sstable which is missing
+	// or corrupt is read from the replica of the sstable instead of failing
+	// the read. The local sstable remains missing or corrupt until it is
+	// restored, e.g. by DB.Scrub.
+	TableRepaired func(TableRepairInfo)
+
+	// TableScrubbed is invoked when the scrubber finds a corrupt sstable or
+	// replica (see DB.Scrub).
+	TableScrubbed func(TableScrubbedInfo)
+
 	// TableValidated is invoked after validation runs on an sstable.
 	TableValidated func(TableValidatedInfo)
 
+	// TenantQuotaExceeded is invoked when the estimated disk space used by
+	// the keys of a tenant reaches its quota (see
+	// Options.Experimental.TenantQuotas). It is invoked again if the usage
+	// of the tenant falls under its quota, e.g. after deletions are
+	// compacted, and then reaches it again.
+	TenantQuotaExceeded func(TenantQuotaInfo)
+
 	// WALCreated is invoked after a WAL has been created.
 	WALCreated func(WALCreateInfo)
 
 	// WALDeleted is invoked after a WAL has been deleted.
 	WALDeleted func(WALDeleteInfo)
 
+	// WALRecycled is invoked when an obsolete WAL is kept to be reused for a
+	// new WAL instead of being deleted. The contents of the WAL are
+	// overwritten once it is reused (see WALCreateInfo.RecycledFileNum).
+	WALRecycled func(WALRecycleInfo)
+
+	// WALReplayProgress is invoked periodically while the WALs are replayed
+	// when the DB is opened, and after the replay of each WAL.
+	WALReplayProgress func(WALReplayInfo)
+
 	// WriteStallBegin is invoked when writes are intentionally delayed.
 	WriteStallBegin func(WriteStallBeginInfo)
 
@@ -557,6 +898,15 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.ManifestDeleted == nil {
 		l.ManifestDeleted = func(info ManifestDeleteInfo) {}
 	}
+	if l.ManifestReplicated == nil {
+		l.ManifestReplicated = func(info ManifestReplicaInfo) {}
+	}
+	if l.MetricsReported == nil {
+		l.MetricsReported = func(info MetricsReportInfo) {}
+	}
+	if l.SnapshotPinnedBudgetExceeded == nil {
+		l.SnapshotPinnedBudgetExceeded = func(info SnapshotPinnedInfo) {}
+	}
 	if l.TableCreated == nil {
 		l.TableCreated = func(info TableCreateInfo) {}
 	}
@@ -569,15 +919,30 @@ func (l *EventListener) EnsureDefaults(logger Logger) {
 	if l.TableStatsLoaded == nil {
 		l.TableStatsLoaded = func(info TableStatsInfo) {}
 	}
+	if l.TableRepaired == nil {
+		l.TableRepaired = func(info TableRepairInfo) {}
+	}
+	if l.TableScrubbed == nil {
+		l.TableScrubbed = func(info TableScrubbedInfo) {}
+	}
 	if l.TableValidated == nil {
 		l.TableValidated = func(validated TableValidatedInfo) {}
 	}
+	if l.TenantQuotaExceeded == nil {
+		l.TenantQuotaExceeded = func(info TenantQuotaInfo) {}
+	}
 	if l.WALCreated == nil {
 		l.WALCreated = func(info WALCreateInfo) {}
 	}
 	if l.WALDeleted == nil {
 		l.WALDeleted = func(info WALDeleteInfo) {}
 	}
+	if l.WALRecycled == nil {
+		l.WALRecycled = func(info WALRecycleInfo) {}
+	}
+	if l.WALReplayProgress == nil {
+		l.WALReplayProgress = func(info WALReplayInfo) {}
+	}
 	if l.WriteStallBegin == nil {
 		l.WriteStallBegin = func(info WriteStallBeginInfo) {}
 	}
@@ -621,6 +986,15 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		ManifestDeleted: func(info ManifestDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		ManifestReplicated: func(info ManifestReplicaInfo) {
+			logger.Infof("%s", info)
+		},
+		MetricsReported: func(info MetricsReportInfo) {
+			logger.Infof("%s", info)
+		},
+		SnapshotPinnedBudgetExceeded: func(info SnapshotPinnedInfo) {
+			logger.Infof("%s", info)
+		},
 		TableCreated: func(info TableCreateInfo) {
 			logger.Infof("%s", info)
 		},
@@ -633,15 +1007,30 @@ func MakeLoggingEventListener(logger Logger) EventListener {
 		TableStatsLoaded: func(info TableStatsInfo) {
 			logger.Infof("%s", info)
 		},
+		TableRepaired: func(info TableRepairInfo) {
+			logger.Infof("%s", info)
+		},
+		TableScrubbed: func(info TableScrubbedInfo) {
+			logger.Infof("%s", info)
+		},
 		TableValidated: func(info TableValidatedInfo) {
 			logger.Infof("%s", info)
 		},
+		TenantQuotaExceeded: func(info TenantQuotaInfo) {
+			logger.Infof("%s", info)
+		},
 		WALCreated: func(info WALCreateInfo) {
 			logger.Infof("%s", info)
 		},
 		WALDeleted: func(info WALDeleteInfo) {
 			logger.Infof("%s", info)
 		},
+		WALRecycled: func(info WALRecycleInfo) {
+			logger.Infof("%s", info)
+		},
+		WALReplayProgress: func(info WALReplayInfo) {
+			logger.Infof("%s", info)
+		},
 		WriteStallBegin: func(info WriteStallBeginInfo) {
 			logger.Infof("%s", info)
 		},
@@ -692,6 +1081,18 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.ManifestDeleted(info)
 			b.ManifestDeleted(info)
 		},
+		ManifestReplicated: func(info ManifestReplicaInfo) {
+			a.ManifestReplicated(info)
+			b.ManifestReplicated(info)
+		},
+		MetricsReported: func(info MetricsReportInfo) {
+			a.MetricsReported(info)
+			b.MetricsReported(info)
+		},
+		SnapshotPinnedBudgetExceeded: func(info SnapshotPinnedInfo) {
+			a.SnapshotPinnedBudgetExceeded(info)
+			b.SnapshotPinnedBudgetExceeded(info)
+		},
 		TableCreated: func(info TableCreateInfo) {
 			a.TableCreated(info)
 			b.TableCreated(info)
@@ -708,10 +1109,22 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.TableStatsLoaded(info)
 			b.TableStatsLoaded(info)
 		},
+		TableRepaired: func(info TableRepairInfo) {
+			a.TableRepaired(info)
+			b.TableRepaired(info)
+		},
+		TableScrubbed: func(info TableScrubbedInfo) {
+			a.TableScrubbed(info)
+			b.TableScrubbed(info)
+		},
 		TableValidated: func(info TableValidatedInfo) {
 			a.TableValidated(info)
 			b.TableValidated(info)
 		},
+		TenantQuotaExceeded: func(info TenantQuotaInfo) {
+			a.TenantQuotaExceeded(info)
+			b.TenantQuotaExceeded(info)
+		},
 		WALCreated: func(info WALCreateInfo) {
 			a.WALCreated(info)
 			b.WALCreated(info)
@@ -720,6 +1133,14 @@ func TeeEventListener(a, b EventListener) EventListener {
 			a.WALDeleted(info)
 			b.WALDeleted(info)
 		},
+		WALRecycled: func(info WALRecycleInfo) {
+			a.WALRecycled(info)
+			b.WALRecycled(info)
+		},
+		WALReplayProgress: func(info WALReplayInfo) {
+			a.WALReplayProgress(info)
+			b.WALReplayProgress(info)
+		},
 		WriteStallBegin: func(info WriteStallBeginInfo) {
 			a.WriteStallBegin(info)
 			b.WriteStallBegin(info)
@@ -0,0 +1,333 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ExportDescriptorFilename is the name of the file describing an export,
+// written by Snapshot.Export in the export directory.
+const ExportDescriptorFilename = "EXPORT"
+
+// defaultExportTableSize is the default target size of the sstables written by
+// Snapshot.Export.
+const defaultExportTableSize = 64 << 20 // 64 MB
+
+// ExportSpan is a key range [Start, End) (inclusive on Start, exclusive on
+// End) exported by Snapshot.Export.
+type ExportSpan struct {
+	Start []byte
+	End   []byte
+}
+
+// exportOptions hold the optional parameters of Snapshot.Export.
+type exportOptions struct {
+	tableSize int64
+}
+
+// ExportOption sets optional parameters used by Snapshot.Export.
+type ExportOption func(*exportOptions)
+
+// WithExportTableSize sets the target size of the exported sstables. The
+// default is 64MB.
+func WithExportTableSize(bytes int64) ExportOption {
+	return func(opt *exportOptions) {
+		opt.tableSize = bytes
+	}
+}
+
+// ExportDescriptor describes the sstables written by Snapshot.Export. It is
+// stored as JSON in the ExportDescriptorFilename file of the export
+// directory.
+type ExportDescriptor struct {
+	// Comparer is the name of the Comparer of the exporting DB, which the
+	// importing DB must use.
+	Comparer string
+	// TableFormat is the format of the sstables, which the format major
+	// version of the importing DB must support.
+	TableFormat string
+	// SeqNum is the sequence number of the exported snapshot.
+	SeqNum uint64
+	// Spans are the exported spans, sorted.
+	Spans []ExportSpan
+	// Tables are the exported sstables, sorted by key.
+	Tables []ExportedTable
+}
+
+// ExportedTable describes an sstable written by Snapshot.Export.
+type ExportedTable struct {
+	// Filename is the name of the sstable in the export directory.
+	Filename string
+	// Smallest and Largest are the smallest and largest user keys of the
+	// sstable; Largest is exclusive if it is the end of a range key.
+	Smallest, Largest []byte
+	// Size is the size of the sstable in bytes.
+	Size uint64
+	// PointKeys and RangeKeys are the number of point keys and range keys in
+	// the sstable.
+	PointKeys, RangeKeys uint64
+}
+
+// Paths returns the paths of the exported sstables, for use with DB.Ingest.
+func (e *ExportDescriptor) Paths(fs vfs.FS, dir string) []string {
+	paths := make([]string, len(e.Tables))
+	for i := range e.Tables {
+		paths[i] = fs.PathJoin(dir, e.Tables[i].Filename)
+	}
+	return paths
+}
+
+// ReadExportDescriptor reads the descriptor of the export in the given
+// directory.
+func ReadExportDescriptor(fs vfs.FS, dir string) (*ExportDescriptor, error) {
+	f, err := fs.Open(fs.PathJoin(dir, ExportDescriptorFilename))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	e := &ExportDescriptor{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return nil, errors.Wrapf(err, "pebble: invalid export descriptor in %s", dir)
+	}
+	return e, nil
+}
+
+// Export writes the data of the snapshot in the given spans to new sstables in
+// dir, which must not exist, along with a descriptor of the sstables (see
+// ExportDescriptorFilename). It is the counterpart of DB.Ingest: the sstables
+// can be ingested as is into another DB with the same Comparer (see
+// ExportDescriptor.Paths), e.g. to move a range of keys between nodes.
+//
+// Only the data visible in the snapshot is exported: the point keys, with
+// their merge operands merged, and the range keys. Deletions are not
+// exported, and all the keys are written with a sequence number of zero, so
+// that ingesting the sstables adds the exported data to the destination but
+// does not delete anything in the spans. The spans must not overlap.
+//
+// If an error is returned, dir is removed.
+func (s *Snapshot) Export(
+	fs vfs.FS, dir string, spans []ExportSpan, opts ...ExportOption,
+) (_ *ExportDescriptor, err error) {
+	if s.db == nil {
+		panic(ErrClosed)
+	}
+	d := s.db
+	opt := &exportOptions{tableSize: defaultExportTableSize}
+	for _, fn := range opts {
+		fn(opt)
+	}
+
+	cmp := d.cmp
+	spans = append([]ExportSpan(nil), spans...)
+	sort.Slice(spans, func(i, j int) bool {
+		return cmp(spans[i].Start, spans[j].Start) < 0
+	})
+	for i := range spans {
+		if cmp(spans[i].Start, spans[i].End) >= 0 {
+			return nil, errors.Errorf("pebble: invalid export span [%s, %s)",
+				d.opts.Comparer.FormatKey(spans[i].Start), d.opts.Comparer.FormatKey(spans[i].End))
+		}
+		if i > 0 && cmp(spans[i-1].End, spans[i].Start) > 0 {
+			return nil, errors.Errorf("pebble: overlapping export spans [%s, %s) and [%s, %s)",
+				d.opts.Comparer.FormatKey(spans[i-1].Start), d.opts.Comparer.FormatKey(spans[i-1].End),
+				d.opts.Comparer.FormatKey(spans[i].Start), d.opts.Comparer.FormatKey(spans[i].End))
+		}
+	}
+
+	if _, err := fs.Stat(dir); !oserror.IsNotExist(err) {
+		if err == nil {
+			return nil, &os.PathError{Op: "export", Path: dir, Err: oserror.ErrExist}
+		}
+		return nil, err
+	}
+	dirFile, err := mkdirAllAndSyncParents(fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = dirFile.Close()
+		if err != nil {
+			paths, _ := fs.List(dir)
+			for _, path := range paths {
+				_ = fs.Remove(fs.PathJoin(dir, path))
+			}
+			_ = fs.Remove(dir)
+		}
+	}()
+
+	e := &exporter{
+		fs:         fs,
+		dir:        dir,
+		writerOpts: d.MakeWriterOptions(numLevels - 1),
+		tableSize:  opt.tableSize,
+	}
+	e.desc.Comparer = d.opts.Comparer.Name
+	e.desc.TableFormat = e.writerOpts.TableFormat.String()
+	e.desc.SeqNum = s.seqNum
+	e.desc.Spans = spans
+	for _, span := range spans {
+		if err := e.exportSpan(s, span); err != nil {
+			_ = e.abort()
+			return nil, err
+		}
+	}
+	if err := e.finishTable(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(&e.desc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.Create(fs.PathJoin(dir, ExportDescriptorFilename))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := dirFile.Sync(); err != nil {
+		return nil, err
+	}
+	return &e.desc, nil
+}
+
+// exporter writes the sstables of Snapshot.Export.
+type exporter struct {
+	fs         vfs.FS
+	dir        string
+	writerOpts sstable.WriterOptions
+	tableSize  int64
+	desc       ExportDescriptor
+
+	// w is the sstable being written, if any.
+	w        *sstable.Writer
+	filename string
+}
+
+func (e *exporter) exportSpan(s *Snapshot, span ExportSpan) error {
+	iter := s.NewIter(&IterOptions{
+		KeyTypes:   IterKeyTypePointsAndRanges,
+		LowerBound: span.Start,
+		UpperBound: span.End,
+	})
+	for valid := iter.First(); valid; valid = iter.Next() {
+		hasPoint, hasRange := iter.HasPointAndRange()
+		// A new sstable is only started outside of range keys, so that the
+		// sstables do not overlap.
+		if e.w != nil && !hasRange && int64(e.w.EstimatedSize()) >= e.tableSize {
+			if err := e.finishTable(); err != nil {
+				_ = iter.Close()
+				return err
+			}
+		}
+		if e.w == nil {
+			if err := e.newTable(); err != nil {
+				_ = iter.Close()
+				return err
+			}
+		}
+		if iter.RangeKeyChanged() && hasRange {
+			start, end := iter.RangeBounds()
+			for _, rk := range iter.RangeKeys() {
+				if err := e.w.RangeKeySet(start, end, rk.Suffix, rk.Value); err != nil {
+					_ = iter.Close()
+					return err
+				}
+			}
+		}
+		if hasPoint {
+			value, err := iter.ValueAndErr()
+			if err != nil {
+				_ = iter.Close()
+				return err
+			}
+			if err := e.w.Set(iter.Key(), value); err != nil {
+				_ = iter.Close()
+				return err
+			}
+		}
+	}
+	return iter.Close()
+}
+
+func (e *exporter) newTable() error {
+	e.filename = fmt.Sprintf("%06d.sst", len(e.desc.Tables))
+	f, err := e.fs.Create(e.fs.PathJoin(e.dir, e.filename))
+	if err != nil {
+		return err
+	}
+	e.w = sstable.NewWriter(objstorage.NewFileWritable(f), e.writerOpts)
+	return nil
+}
+
+// finishTable closes the sstable being written, if any, and adds it to the
+// descriptor.
+func (e *exporter) finishTable() error {
+	if e.w == nil {
+		return nil
+	}
+	w := e.w
+	e.w = nil
+	if err := w.Close(); err != nil {
+		return err
+	}
+	meta, err := w.Metadata()
+	if err != nil {
+		return err
+	}
+	t := ExportedTable{
+		Filename: e.filename,
+		Size:     meta.Size,
+	}
+	if meta.HasPointKeys {
+		t.Smallest, t.Largest = meta.SmallestPoint.UserKey, meta.LargestPoint.UserKey
+		t.PointKeys = meta.Properties.NumEntries
+	}
+	if meta.HasRangeKeys {
+		if t.Smallest == nil || e.writerOpts.Comparer.Compare(meta.SmallestRangeKey.UserKey, t.Smallest) < 0 {
+			t.Smallest = meta.SmallestRangeKey.UserKey
+		}
+		if t.Largest == nil || e.writerOpts.Comparer.Compare(meta.LargestRangeKey.UserKey, t.Largest) > 0 {
+			t.Largest = meta.LargestRangeKey.UserKey
+		}
+		t.RangeKeys = meta.Properties.NumRangeKeys()
+	}
+	e.desc.Tables = append(e.desc.Tables, t)
+	return nil
+}
+
+// abort closes the sstable being written, if any.
+func (e *exporter) abort() error {
+	if e.w == nil {
+		return nil
+	}
+	err := e.w.Close()
+	e.w = nil
+	return err
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotExport(t *testing.T) {
+	fs := vfs.NewMem()
+	open := func(dir string) *DB {
+		d, err := Open(dir, &Options{
+			FS:                 fs,
+			Comparer:           testkeys.Comparer,
+			FormatMajorVersion: FormatNewest,
+		})
+		require.NoError(t, err)
+		return d
+	}
+	// dump returns the visible keys of the reader in the span [a, z).
+	dump := func(r Reader) string {
+		iter := r.NewIter(&IterOptions{
+			KeyTypes:   IterKeyTypePointsAndRanges,
+			LowerBound: []byte("a"),
+			UpperBound: []byte("z"),
+		})
+		var b strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			hasPoint, hasRange := iter.HasPointAndRange()
+			if hasRange && iter.RangeKeyChanged() {
+				start, end := iter.RangeBounds()
+				fmt.Fprintf(&b, "[%s-%s)", start, end)
+				for _, rk := range iter.RangeKeys() {
+					fmt.Fprintf(&b, " %s=%s", rk.Suffix, rk.Value)
+				}
+				b.WriteString("\n")
+			}
+			if hasPoint {
+				fmt.Fprintf(&b, "%s=%s\n", iter.Key(), iter.Value())
+			}
+		}
+		require.NoError(t, iter.Close())
+		return b.String()
+	}
+
+	src := open("src")
+	for i := 0; i < 100; i++ {
+		require.NoError(t, src.Set([]byte(fmt.Sprintf("b%03d", i)), []byte(strings.Repeat("v", 100)), nil))
+	}
+	require.NoError(t, src.Flush())
+	require.NoError(t, src.Delete([]byte("b050"), nil))
+	require.NoError(t, src.Merge([]byte("b051"), []byte("x"), nil))
+	require.NoError(t, src.RangeKeySet([]byte("c"), []byte("e"), []byte("@1"), []byte("rk"), nil))
+	require.NoError(t, src.Set([]byte("d"), []byte("d"), nil))
+	require.NoError(t, src.Set([]byte("y"), []byte("outside"), nil))
+	snap := src.NewSnapshot()
+	// Writes after the snapshot are not exported.
+	require.NoError(t, src.Set([]byte("b000"), []byte("later"), nil))
+
+	spans := []ExportSpan{
+		{Start: []byte("c"), End: []byte("x")},
+		{Start: []byte("a"), End: []byte("c")},
+	}
+	desc, err := snap.Export(fs, "export", spans, WithExportTableSize(1<<10))
+	require.NoError(t, err)
+	require.Greater(t, len(desc.Tables), 1)
+	require.Equal(t, src.opts.Comparer.Name, desc.Comparer)
+	require.Equal(t, []byte("a"), desc.Spans[0].Start)
+
+	// The descriptor can be read back.
+	read, err := ReadExportDescriptor(fs, "export")
+	require.NoError(t, err)
+	require.Equal(t, desc, read)
+
+	dst := open("dst")
+	require.NoError(t, dst.Ingest(read.Paths(fs, "export")))
+	expected := dump(snap)
+	require.NotContains(t, expected, "later")
+	expected = strings.Replace(expected, "y=outside\n", "", 1)
+	require.Equal(t, expected, dump(dst))
+
+	// The export directory must not exist, and the spans must not overlap.
+	_, err = snap.Export(fs, "export", spans)
+	require.Error(t, err)
+	_, err = snap.Export(fs, "export2", []ExportSpan{
+		{Start: []byte("a"), End: []byte("c")},
+		{Start: []byte("b"), End: []byte("d")},
+	})
+	require.Error(t, err)
+
+	require.NoError(t, snap.Close())
+	require.NoError(t, src.Close())
+	require.NoError(t, dst.Close())
+}
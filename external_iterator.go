@@ -140,6 +140,7 @@ func NewExternalIterWithContext(
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
 		boundsBuf:           buf.boundsBuf,
+		bufAlloc:            o.Experimental.BufferAllocator,
 		batch:               nil,
 		// Add the readers to the Iterator so that Close closes them, and
 		// SetOptions can re-construct iterators from them.
@@ -13,6 +13,21 @@ import (
 
 type fileType = base.FileType
 
+// FileType enumerates the types of files found in a DB.
+type FileType = base.FileType
+
+// The FileType enumeration.
+const (
+	FileTypeLog      = base.FileTypeLog
+	FileTypeLock     = base.FileTypeLock
+	FileTypeTable    = base.FileTypeTable
+	FileTypeManifest = base.FileTypeManifest
+	FileTypeCurrent  = base.FileTypeCurrent
+	FileTypeOptions  = base.FileTypeOptions
+	FileTypeOldTemp  = base.FileTypeOldTemp
+	FileTypeTemp     = base.FileTypeTemp
+)
+
 // FileNum is an identifier for a file within a database.
 type FileNum = base.FileNum
 
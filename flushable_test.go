@@ -58,7 +58,7 @@ func TestIngestedSSTFlushableAPI(t *testing.T) {
 		// We can reuse the ingestLoad function for this test even if we're
 		// not actually ingesting a file.
 		meta, paths, err := ingestLoad(
-			d.opts, d.FormatMajorVersion(), paths, d.cacheID, pendingOutputs,
+			d.opts, d.FormatMajorVersion(), paths, nil /* bounds */, d.cacheID, pendingOutputs,
 		)
 		if err != nil {
 			panic(err)
@@ -78,7 +78,7 @@ func TestIngestedSSTFlushableAPI(t *testing.T) {
 		// (e.g. because the files reside on a different filesystem), ingestLink will
 		// fall back to copying, and if that fails we undo our work and return an
 		// error.
-		if err := ingestLink(jobID, d.opts, d.objProvider, paths, meta); err != nil {
+		if _, err := ingestLink(jobID, d.opts, d.objProvider, paths, meta); err != nil {
 			panic("couldn't hard link sstables")
 		}
 
@@ -5,6 +5,7 @@
 package pebble
 
 import (
+	"crypto/rand"
 	"fmt"
 	"strconv"
 
@@ -151,6 +152,32 @@ const (
 	// compactions for files marked for compaction are complete.
 	FormatPrePebblev1MarkedCompacted
 
+	// FormatCompressionDictionaries is a format major version that adds
+	// support for sstables whose blocks are compressed with a Zstandard
+	// compression dictionary stored in the sstable, using
+	// sstable.TableFormatPebblev4 (see LevelOptions.CompressionDictSize).
+	// Reading these sstables requires a cgo build.
+	FormatCompressionDictionaries
+
+	// FormatVirtualSSTables is a format major version that adds support for
+	// virtual sstables, which are backed by a part of an sstable (see
+	// DB.IngestFiles). Virtual sstables are recorded in the manifest with a
+	// field that previous versions don't understand.
+	FormatVirtualSSTables
+
+	// FormatDBID is a format major version that assigns a unique ID to the DB,
+	// which is generated when the DB is ratcheted to this version and recorded
+	// in the manifest (see DB.ID). The ID is recorded with a tag that previous
+	// versions don't understand.
+	FormatDBID
+
+	// FormatXXH3Checksums is a format major version that allows the blocks of
+	// sstables to be checksummed with XXH3 (see Options.Experimental.Checksum),
+	// which previous versions cannot verify. Below this version, the DB writes
+	// CRC32c checksums instead, and rejects the ingestion of sstables written
+	// with XXH3 checksums.
+	FormatXXH3Checksums
+
 	// FormatNewest always contains the most recent format major version.
 	FormatNewest FormatMajorVersion = iota - 1
 )
@@ -171,6 +198,9 @@ func (v FormatMajorVersion) MaxTableFormat() sstable.TableFormat {
 	case FormatSSTableValueBlocks, FormatFlushableIngest,
 		FormatPrePebblev1MarkedCompacted:
 		return sstable.TableFormatPebblev3
+	case FormatCompressionDictionaries, FormatVirtualSSTables, FormatDBID,
+		FormatXXH3Checksums:
+		return sstable.TableFormatPebblev4
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
 	}
@@ -187,7 +217,9 @@ func (v FormatMajorVersion) MinTableFormat() sstable.TableFormat {
 		return sstable.TableFormatLevelDB
 	case FormatMinTableFormatPebblev1, FormatPrePebblev1Marked,
 		FormatUnusedPrePebblev1MarkedCompacted, FormatSSTableValueBlocks,
-		FormatFlushableIngest, FormatPrePebblev1MarkedCompacted:
+		FormatFlushableIngest, FormatPrePebblev1MarkedCompacted,
+		FormatCompressionDictionaries, FormatVirtualSSTables, FormatDBID,
+		FormatXXH3Checksums:
 		return sstable.TableFormatPebblev1
 	default:
 		panic(fmt.Sprintf("pebble: unsupported format major version: %s", v))
@@ -320,6 +352,47 @@ var formatMajorVersionMigrations = map[FormatMajorVersion]func(*DB) error{
 		}
 		return d.finalizeFormatVersUpgrade(FormatPrePebblev1MarkedCompacted)
 	},
+	FormatCompressionDictionaries: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatCompressionDictionaries)
+	},
+	FormatVirtualSSTables: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatVirtualSSTables)
+	},
+	FormatDBID: func(d *DB) error {
+		// Record a new ID in the manifest before finalizing the format major
+		// version, unless a previous attempt at the migration recorded one.
+		if d.mu.versions.dbID == "" {
+			jobID := d.mu.nextJobID
+			d.mu.nextJobID++
+			d.mu.versions.logLock()
+			if err := d.mu.versions.logAndApply(
+				jobID,
+				&manifest.VersionEdit{DBID: makeDBID()},
+				map[int]*LevelMetrics{},
+				false, /* forceRotation */
+				func() []compactionInfo { return d.getInProgressCompactionInfoLocked(nil) },
+			); err != nil {
+				return err
+			}
+			d.setObjectMetadataLocked()
+		}
+		return d.finalizeFormatVersUpgrade(FormatDBID)
+	},
+	FormatXXH3Checksums: func(d *DB) error {
+		return d.finalizeFormatVersUpgrade(FormatXXH3Checksums)
+	},
+}
+
+// makeDBID returns a new unique DB ID, formatted as a random (version 4)
+// UUID.
+func makeDBID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
 }
 
 const formatVersionMarkerName = `format-version`
@@ -358,6 +431,51 @@ func (d *DB) FormatMajorVersion() FormatMajorVersion {
 	return d.mu.formatVers.vers
 }
 
+// FormatMigrationProgress describes the progress of the upgrade of a
+// database's format major version. See DB.FormatMigrationProgress.
+type FormatMigrationProgress struct {
+	// Version is the database's current format major version.
+	Version FormatMajorVersion
+	// InProgress is true if the format major version is being ratcheted.
+	InProgress bool
+	// TargetVersion is the format major version being ratcheted to, if
+	// InProgress is true.
+	TargetVersion FormatMajorVersion
+	// TablesToRewrite is the number of sstables that the most recent migration
+	// which rewrites sstables (e.g. to upgrade their table format) found
+	// marked for compaction when it started.
+	TablesToRewrite int
+	// TablesRewritten is the number of those sstables that have been
+	// rewritten since.
+	TablesRewritten int
+}
+
+// String implements fmt.Stringer.
+func (p FormatMigrationProgress) String() string {
+	if !p.InProgress {
+		return fmt.Sprintf("format major version %s", p.Version)
+	}
+	return fmt.Sprintf("format major version %s, upgrading to %s: rewritten %d/%d tables",
+		p.Version, p.TargetVersion, p.TablesRewritten, p.TablesToRewrite)
+}
+
+// FormatMigrationProgress returns the progress of the upgrade of the
+// database's format major version, by RatchetFormatMajorVersion or Open.
+func (d *DB) FormatMigrationProgress() FormatMigrationProgress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := FormatMigrationProgress{
+		Version:         d.mu.formatVers.vers,
+		InProgress:      d.mu.formatVers.ratcheting,
+		TablesToRewrite: d.mu.formatVers.tablesToRewrite,
+		TablesRewritten: d.mu.formatVers.tablesRewritten,
+	}
+	if p.InProgress {
+		p.TargetVersion = d.mu.formatVers.target
+	}
+	return p
+}
+
 // RatchetFormatMajorVersion ratchets the opened database's format major
 // version to the provided version. It errors if the provided format
 // major version is below the database's current version. Once a
@@ -371,7 +489,18 @@ func (d *DB) RatchetFormatMajorVersion(fmv FormatMajorVersion) error {
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	return d.ratchetFormatMajorVersionLocked(fmv)
+	prevVers := d.mu.formatVers.vers
+	err := d.ratchetFormatMajorVersionLocked(fmv)
+	if d.mu.formatVers.vers != prevVers {
+		// Record the new format major version in the OPTIONS file.
+		if werr := d.writeOptionsFileLocked(); werr != nil && err == nil {
+			err = errors.Wrap(werr, "pebble: format major version was ratcheted but could not be persisted")
+		}
+		jobID := d.mu.nextJobID
+		d.mu.nextJobID++
+		d.deleteObsoleteFiles(jobID, false /* waitForOngoing */)
+	}
+	return err
 }
 
 func (d *DB) ratchetFormatMajorVersionLocked(formatVers FormatMajorVersion) error {
@@ -390,6 +519,7 @@ func (d *DB) ratchetFormatMajorVersionLocked(formatVers FormatMajorVersion) erro
 		return errors.Newf("pebble: database format major version upgrade is in-progress")
 	}
 	d.mu.formatVers.ratcheting = true
+	d.mu.formatVers.target = formatVers
 	defer func() { d.mu.formatVers.ratcheting = false }()
 
 	for nextVers := d.mu.formatVers.vers + 1; nextVers <= formatVers; nextVers++ {
@@ -437,12 +567,32 @@ func (d *DB) finalizeFormatVersUpgrade(formatVers FormatMajorVersion) error {
 // waiting for compactions to complete (or for slots to free up).
 func (d *DB) compactMarkedFilesLocked() error {
 	curr := d.mu.versions.currentVersion()
+	d.mu.formatVers.tablesToRewrite = curr.Stats.MarkedForCompaction
+	d.mu.formatVers.tablesRewritten = 0
+	updateProgress := func() {
+		d.mu.formatVers.tablesRewritten = d.mu.formatVers.tablesToRewrite - curr.Stats.MarkedForCompaction
+		if d.mu.formatVers.tablesRewritten < 0 {
+			d.mu.formatVers.tablesRewritten = 0
+		}
+	}
+	defer updateProgress()
 	for curr.Stats.MarkedForCompaction > 0 {
+		updateProgress()
 		// Attempt to schedule a compaction to rewrite a file marked for
-		// compaction.
-		d.maybeScheduleCompactionPicker(func(picker compactionPicker, env compactionEnv) *pickedCompaction {
-			return picker.pickRewriteCompaction(env)
-		})
+		// compaction. With LowPriorityFormatMigrations, only schedule a single
+		// one, and only if no other compaction is running.
+		lowPriority := d.opts.Experimental.LowPriorityFormatMigrations
+		if !lowPriority || d.mu.compact.compactingCount == 0 {
+			var picked bool
+			d.maybeScheduleCompactionPicker(func(picker compactionPicker, env compactionEnv) *pickedCompaction {
+				if lowPriority && picked {
+					return nil
+				}
+				pc := picker.pickRewriteCompaction(env)
+				picked = pc != nil
+				return pc
+			})
+		}
 
 		// The above attempt might succeed and schedule a rewrite compaction. Or
 		// there might not be available compaction concurrency to schedule the
@@ -62,6 +62,17 @@ func TestRatchetFormat(t *testing.T) {
 	require.Equal(t, FormatFlushableIngest, d.FormatMajorVersion())
 	require.NoError(t, d.RatchetFormatMajorVersion(FormatPrePebblev1MarkedCompacted))
 	require.Equal(t, FormatPrePebblev1MarkedCompacted, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatCompressionDictionaries))
+	require.Equal(t, FormatCompressionDictionaries, d.FormatMajorVersion())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatVirtualSSTables))
+	require.Equal(t, FormatVirtualSSTables, d.FormatMajorVersion())
+	require.Equal(t, "", d.ID())
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatDBID))
+	require.Equal(t, FormatDBID, d.FormatMajorVersion())
+	id := d.ID()
+	require.Len(t, id, 36)
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatXXH3Checksums))
+	require.Equal(t, FormatXXH3Checksums, d.FormatMajorVersion())
 
 	require.NoError(t, d.Close())
 
@@ -70,6 +81,8 @@ func TestRatchetFormat(t *testing.T) {
 	d, err = Open("", (&Options{FS: fs}).WithFSDefaults())
 	require.NoError(t, err)
 	require.Equal(t, FormatNewest, d.FormatMajorVersion())
+	// The ID of the DB persists across reopens.
+	require.Equal(t, id, d.ID())
 	require.NoError(t, d.Close())
 
 	// Move the marker to a version that does not exist.
@@ -225,6 +238,10 @@ func TestFormatMajorVersions_TableFormat(t *testing.T) {
 		FormatSSTableValueBlocks:               {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
 		FormatFlushableIngest:                  {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
 		FormatPrePebblev1MarkedCompacted:       {sstable.TableFormatPebblev1, sstable.TableFormatPebblev3},
+		FormatCompressionDictionaries:          {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		FormatVirtualSSTables:                  {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		FormatDBID:                             {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
+		FormatXXH3Checksums:                    {sstable.TableFormatPebblev1, sstable.TableFormatPebblev4},
 	}
 
 	// Valid versions.
@@ -571,3 +588,41 @@ func TestPebblev1MigrationConcurrencyRace(t *testing.T) {
 	require.NoError(t, d.RatchetFormatMajorVersion(FormatUnusedPrePebblev1MarkedCompacted))
 	require.NoError(t, d.Close())
 }
+
+func TestFormatMigrationProgress(t *testing.T) {
+	opts := (&Options{
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: FormatSetWithDelete,
+		Levels:             []LevelOptions{{TargetFileSize: 1}},
+	}).WithFSDefaults()
+	opts.Experimental.LowPriorityFormatMigrations = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	p := d.FormatMigrationProgress()
+	require.Equal(t, FormatMigrationProgress{Version: FormatSetWithDelete}, p)
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("a"), []byte("e"), false /* parallelize */))
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[numLevels-1], 4)
+
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatPrePebblev1MarkedCompacted))
+	p = d.FormatMigrationProgress()
+	require.Equal(t, FormatMigrationProgress{
+		Version:         FormatPrePebblev1MarkedCompacted,
+		TablesToRewrite: 4,
+		TablesRewritten: 4,
+	}, p)
+	require.Equal(t, "format major version "+FormatPrePebblev1MarkedCompacted.String(), p.String())
+
+	p.InProgress = true
+	p.TargetVersion = FormatNewest
+	require.Equal(t, fmt.Sprintf("format major version %s, upgrading to %s: rewritten 4/4 tables",
+		FormatPrePebblev1MarkedCompacted, FormatNewest), p.String())
+}
@@ -149,7 +149,14 @@ func (g *getIter) Next() (*InternalKey, base.LazyValue) {
 			g.iter = m.newIter(nil)
 			g.rangeDelIter = m.newRangeDelIter(nil)
 			g.mem = g.mem[:n-1]
-			g.iterKey, g.iterValue = g.iter.SeekGE(g.key, base.SeekGEFlagsNone)
+			if mem, ok := m.flushable.(*memTable); ok && mem.buckets != nil {
+				// A hashed memtable can only seek efficiently within the
+				// prefix of the key.
+				prefix := g.key[:mem.buckets.split(g.key)]
+				g.iterKey, g.iterValue = g.iter.SeekPrefixGE(prefix, g.key, base.SeekGEFlagsNone)
+			} else {
+				g.iterKey, g.iterValue = g.iter.SeekGE(g.key, base.SeekGEFlagsNone)
+			}
 			continue
 		}
 
@@ -7,6 +7,7 @@ package pebble
 import (
 	"context"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -46,8 +47,24 @@ func ingestValidateKey(opts *Options, key *InternalKey) error {
 	return nil
 }
 
+// ingestBounds are the bounds [start, end) of the keys of an sstable to ingest.
+// An sstable with bounds is ingested as a virtual table. The zero value means
+// that the whole sstable is ingested.
+type ingestBounds struct {
+	start, end []byte
+}
+
+func (b ingestBounds) isSet() bool {
+	return b.start != nil || b.end != nil
+}
+
 func ingestLoad1(
-	opts *Options, fmv FormatMajorVersion, path string, cacheID uint64, fileNum FileNum,
+	opts *Options,
+	fmv FormatMajorVersion,
+	path string,
+	bounds ingestBounds,
+	cacheID uint64,
+	fileNum FileNum,
 ) (*fileMetadata, error) {
 	f, err := opts.FS.Open(path)
 	if err != nil {
@@ -77,11 +94,43 @@ func ingestLoad1(
 			tf, fmv, fmv.MinTableFormat(), fmv.MaxTableFormat(),
 		)
 	}
+	if r.ChecksumType() == sstable.ChecksumTypeXXH3 && fmv < FormatXXH3Checksums {
+		return nil, errors.Newf(
+			"pebble: XXH3 checksums are not supported at DB format major version %d", fmv,
+		)
+	}
+	if err := ingestValidate(opts, path, &r.Properties); err != nil {
+		return nil, err
+	}
 
 	meta := &fileMetadata{}
 	meta.FileNum = fileNum
 	meta.Size = uint64(readable.Size())
 	meta.CreationTime = time.Now().Unix()
+	if bounds.isSet() {
+		if bounds.start == nil || bounds.end == nil ||
+			opts.Comparer.Compare(bounds.start, bounds.end) >= 0 {
+			return nil, errors.Newf("pebble: invalid bounds [%s, %s) for external sstable %s",
+				opts.Comparer.FormatKey(bounds.start), opts.Comparer.FormatKey(bounds.end), path)
+		}
+		if fmv < FormatVirtualSSTables {
+			return nil, errors.Newf(
+				"pebble: ingesting a part of an sstable requires at least format major version %d (current: %d)",
+				FormatVirtualSSTables, fmv,
+			)
+		}
+		size, err := r.EstimateDiskUsage(bounds.start, bounds.end)
+		if err != nil {
+			return nil, err
+		}
+		meta.Virtual = &manifest.VirtualTable{
+			UpperBound:  append([]byte(nil), bounds.end...),
+			BackingSize: meta.Size,
+		}
+		if size < meta.Size {
+			meta.Size = size
+		}
+	}
 
 	// Avoid loading into the table cache for collecting stats if we
 	// don't need to. If there are no range deletions, we have all the
@@ -92,16 +141,26 @@ func ingestLoad1(
 	// disallowing removal of an open file. Under MemFS, if we don't populate
 	// meta.Stats here, the file will be loaded into the table cache for
 	// calculating stats before we can remove the original link.
-	maybeSetStatsFromProperties(meta, &r.Properties)
+	maybeSetStatsFromProperties(meta, tableProperties(meta, &r.Properties))
 
 	{
-		iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+		iter, err := r.NewIter(bounds.start, bounds.end)
 		if err != nil {
 			return nil, err
 		}
 		defer iter.Close()
+		first, last := iter.First, iter.Last
+		if bounds.isSet() {
+			// Iterators with bounds must be positioned with seeks.
+			first = func() (*InternalKey, base.LazyValue) {
+				return iter.SeekGE(bounds.start, base.SeekGEFlagsNone)
+			}
+			last = func() (*InternalKey, base.LazyValue) {
+				return iter.SeekLT(bounds.end, base.SeekLTFlagsNone)
+			}
+		}
 		var smallest InternalKey
-		if key, _ := iter.First(); key != nil {
+		if key, _ := first(); key != nil {
 			if err := ingestValidateKey(opts, key); err != nil {
 				return nil, err
 			}
@@ -110,7 +169,7 @@ func ingestLoad1(
 		if err := iter.Error(); err != nil {
 			return nil, err
 		}
-		if key, _ := iter.Last(); key != nil {
+		if key, _ := last(); key != nil {
 			if err := ingestValidateKey(opts, key); err != nil {
 				return nil, err
 			}
@@ -125,6 +184,9 @@ func ingestLoad1(
 	if err != nil {
 		return nil, err
 	}
+	if iter != nil && bounds.isSet() {
+		iter = keyspan.Truncate(opts.Comparer.Compare, iter, bounds.start, bounds.end, nil, nil)
+	}
 	if iter != nil {
 		defer iter.Close()
 		var smallest InternalKey
@@ -154,6 +216,9 @@ func ingestLoad1(
 		if err != nil {
 			return nil, err
 		}
+		if iter != nil && bounds.isSet() {
+			iter = keyspan.Truncate(opts.Comparer.Compare, iter, bounds.start, bounds.end, nil, nil)
+		}
 		if iter != nil {
 			defer iter.Close()
 			var smallest InternalKey
@@ -195,24 +260,87 @@ func ingestLoad1(
 	return meta, nil
 }
 
+// ingestLoadConcurrency is the maximum number of sstables ingestLoad reads
+// concurrently. Loading an sstable is dominated by the latency of opening it
+// and reading its index and bounds, so many small sstables load much faster
+// when their latencies overlap.
+const ingestLoadConcurrency = 16
+
+// ingestLoad loads the metadata of the sstables at the given paths,
+// concurrently. The returned metadata and paths are in the order of the input
+// paths, skipping the sstables that are empty. If loading several sstables
+// fails, the error of the first of them in input order is returned.
 func ingestLoad(
-	opts *Options, fmv FormatMajorVersion, paths []string, cacheID uint64, pending []FileNum,
+	opts *Options,
+	fmv FormatMajorVersion,
+	paths []string,
+	bounds []ingestBounds,
+	cacheID uint64,
+	pending []FileNum,
 ) ([]*fileMetadata, []string, error) {
+	loaded, errs := ingestLoadEach(opts, fmv, paths, bounds, cacheID, pending)
 	meta := make([]*fileMetadata, 0, len(paths))
 	newPaths := make([]string, 0, len(paths))
 	for i := range paths {
-		m, err := ingestLoad1(opts, fmv, paths[i], cacheID, pending[i])
-		if err != nil {
-			return nil, nil, err
+		if errs[i] != nil {
+			return nil, nil, errs[i]
 		}
-		if m != nil {
-			meta = append(meta, m)
+		if loaded[i] != nil {
+			meta = append(meta, loaded[i])
 			newPaths = append(newPaths, paths[i])
 		}
 	}
 	return meta, newPaths, nil
 }
 
+// ingestLoadEach loads the metadata of each of the sstables at the given
+// paths, concurrently, returning the metadata and the error of each sstable.
+// The metadata of an empty sstable is nil. The bounds of the sstables are
+// optional: if bounds is nil, the sstables are loaded in their entirety.
+func ingestLoadEach(
+	opts *Options,
+	fmv FormatMajorVersion,
+	paths []string,
+	bounds []ingestBounds,
+	cacheID uint64,
+	pending []FileNum,
+) ([]*fileMetadata, []error) {
+	loaded := make([]*fileMetadata, len(paths))
+	errs := make([]error, len(paths))
+	load := func(i int) {
+		var b ingestBounds
+		if bounds != nil {
+			b = bounds[i]
+		}
+		loaded[i], errs[i] = ingestLoad1(opts, fmv, paths[i], b, cacheID, pending[i])
+	}
+	if len(paths) == 1 {
+		load(0)
+	} else {
+		workers := ingestLoadConcurrency
+		if workers > len(paths) {
+			workers = len(paths)
+		}
+		var next atomic.Int64
+		var wg sync.WaitGroup
+		wg.Add(workers)
+		for w := 0; w < workers; w++ {
+			go func() {
+				defer wg.Done()
+				for {
+					i := int(next.Add(1) - 1)
+					if i >= len(paths) {
+						return
+					}
+					load(i)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	return loaded, errs
+}
+
 // Struct for sorting metadatas by smallest user keys, while ensuring the
 // matching path also gets swapped to the same index. For use in
 // ingestSortAndVerify.
@@ -265,17 +393,30 @@ func ingestCleanup(objProvider *objstorage.Provider, meta []*fileMetadata) error
 }
 
 // ingestLink creates new objects which are backed by either hardlinks to or
-// copies of the ingested files.
+// copies of the ingested files. The files are always copied if
+// Options.Experimental.IngestCopy is set, and are copied to shared storage if
+// Options.Experimental.CreateOnShared is set. It returns the file numbers of
+// the objects which were copied.
 func ingestLink(
 	jobID int, opts *Options, objProvider *objstorage.Provider, paths []string, meta []*fileMetadata,
-) error {
+) (copied map[base.FileNum]bool, _ error) {
+	copied = make(map[base.FileNum]bool, len(paths))
 	for i := range paths {
-		objMeta, err := objProvider.LinkOrCopyFromLocal(opts.FS, paths[i], fileTypeTable, meta[i].FileNum)
+		objMeta, linked, err := objProvider.LinkOrCopyFromLocal(
+			context.TODO(), opts.FS, paths[i], fileTypeTable, meta[i].FileNum,
+			objstorage.LinkOrCopyOptions{
+				ForceCopy:           opts.Experimental.IngestCopy,
+				PreferSharedStorage: opts.Experimental.CreateOnShared,
+			},
+		)
 		if err != nil {
 			if err2 := ingestCleanup(objProvider, meta[:i]); err2 != nil {
 				opts.Logger.Infof("ingest cleanup failed: %v", err2)
 			}
-			return err
+			return nil, err
+		}
+		if !linked {
+			copied[meta[i].FileNum] = true
 		}
 		if opts.EventListener.TableCreated != nil {
 			opts.EventListener.TableCreated(TableCreateInfo{
@@ -287,7 +428,7 @@ func ingestLink(
 		}
 	}
 
-	return nil
+	return copied, nil
 }
 
 func ingestMemtableOverlaps(cmp Compare, mem flushable, meta []*fileMetadata) bool {
@@ -624,22 +765,26 @@ func ingestTargetLevel(
 //  2. Load the metadata for all sstables being ingest.
 //  3. Sort the sstables by smallest key, verifying non overlap.
 //  4. Hard link (or copy) the sstables into the DB directory.
-//  5. Allocate a sequence number to use for all of the entries in the
+//  5. Flush the most recent memtable that overlaps (if any), and wait for
+//     the flush to complete.
+//  6. Allocate a sequence number to use for all of the entries in the
 //     sstables. This is the step where overlap with memtables is
 //     determined. If there is overlap, we remember the most recent memtable
 //     that overlaps.
-//  6. Update the sequence number in the ingested sstables.
-//  7. Wait for the most recent memtable that overlaps to flush (if any).
-//  8. Add the ingested sstables to the version (DB.ingestApply).
-//  9. Publish the ingestion sequence number.
+//  7. Update the sequence number in the ingested sstables.
+//  8. Wait for the most recent memtable that overlaps to flush (if any).
+//  9. Add the ingested sstables to the version (DB.ingestApply).
+//  10. Publish the ingestion sequence number.
 //
 // Note that if the mutable memtable overlaps with ingestion, a flush of the
-// memtable is forced equivalent to DB.Flush. Additionally, subsequent
-// mutations that get sequence numbers larger than the ingestion sequence
-// number get queued up behind the ingestion waiting for it to complete. This
-// can produce a noticeable hiccup in performance. See
-// https://github.com/cockroachdb/pebble/issues/25 for an idea for how to fix
-// this hiccup.
+// memtable is forced equivalent to DB.Flush. The flush occurs before the
+// ingestion is assigned a sequence number (step 5), so that subsequent
+// mutations are not queued up behind the ingestion while it waits for the
+// flush. Only if mutations committed during that flush overlap the ingestion
+// again does the ingestion wait for a flush while holding its sequence number
+// (step 8), which produces a hiccup in performance. When the format major
+// version is at least FormatFlushableIngest, the ingestion is instead added to
+// the queue of memtables without waiting for a flush.
 func (d *DB) Ingest(paths []string) error {
 	if err := d.closed.Load(); err != nil {
 		panic(err)
@@ -647,7 +792,7 @@ func (d *DB) Ingest(paths []string) error {
 	if d.opts.ReadOnly {
 		return ErrReadOnly
 	}
-	_, err := d.ingest(paths, ingestTargetLevel)
+	_, err := d.ingest(paths, nil /* bounds */, ingestTargetLevel)
 	return err
 }
 
@@ -673,7 +818,129 @@ func (d *DB) IngestWithStats(paths []string) (IngestOperationStats, error) {
 	if d.opts.ReadOnly {
 		return IngestOperationStats{}, ErrReadOnly
 	}
-	return d.ingest(paths, ingestTargetLevel)
+	return d.ingest(paths, nil /* bounds */, ingestTargetLevel)
+}
+
+// IngestFile describes an sstable to ingest with DB.IngestFiles.
+type IngestFile struct {
+	// Path is the path of the sstable.
+	Path string
+	// Start and End are the optional bounds [Start, End) of the user keys of
+	// the sstable to ingest. Either both or neither must be set. If they are
+	// set, only the keys of the sstable within the bounds are ingested, as a
+	// virtual table backed by the sstable: range deletions and range keys are
+	// truncated to the bounds, and the keys outside of the bounds are never
+	// visible. The sstable is nevertheless linked or copied into the DB in its
+	// entirety, and is deleted when the virtual table is.
+	Start, End []byte
+}
+
+// IngestFiles does the same as IngestWithStats, and additionally allows to
+// ingest only the keys within bounds of some of the sstables. Ingesting
+// sstables with bounds requires a format major version of at least
+// FormatVirtualSSTables.
+func (d *DB) IngestFiles(files []IngestFile) (IngestOperationStats, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return IngestOperationStats{}, ErrReadOnly
+	}
+	paths := make([]string, len(files))
+	var bounds []ingestBounds
+	for i, f := range files {
+		paths[i] = f.Path
+		if f.Start == nil && f.End == nil {
+			continue
+		}
+		if bounds == nil {
+			bounds = make([]ingestBounds, len(files))
+		}
+		bounds[i] = ingestBounds{start: f.Start, end: f.End}
+	}
+	return d.ingest(paths, bounds, ingestTargetLevel)
+}
+
+// IngestInputReport is the result of validating one sstable with
+// DB.ValidateIngestInputs.
+type IngestInputReport struct {
+	// Path is the path of the sstable.
+	Path string
+	// Empty is set if the sstable contains no point keys, range deletions or
+	// range keys. Ingest skips empty sstables.
+	Empty bool
+	// Smallest and Largest are the bounds of the sstable. They are only set if
+	// the sstable could be loaded and is not empty.
+	Smallest, Largest InternalKey
+	// Err is the reason the sstable cannot be ingested, or nil if it can.
+	Err error
+}
+
+// ValidateIngestInputs runs the checks that Ingest performs on the given
+// sstables before ingesting them, and reports the result for each sstable in
+// the order of paths. An sstable fails validation if it cannot be read, if
+// its table format is not supported at the DB's format major version, if it is
+// rejected by Options.Experimental.IngestValidators, if it contains keys with
+// non-zero sequence numbers, if its bounds are inconsistent, or if it
+// overlaps another of the sstables. ValidateIngestInputs
+// does not modify the DB or the sstables.
+//
+// Passing validation does not guarantee that a subsequent Ingest of the
+// sstables succeeds, for example if the DB's format major version is changed
+// in the meantime.
+func (d *DB) ValidateIngestInputs(paths []string) []IngestInputReport {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	// Blocks are cached by cache ID and file number. Use a cache ID of its own
+	// and distinct file numbers, so that the blocks of the sstables cannot be
+	// confused with one another or with the blocks of the DB's tables.
+	fileNums := make([]FileNum, len(paths))
+	for i := range fileNums {
+		fileNums[i] = FileNum(i + 1)
+	}
+	loaded, errs := ingestLoadEach(
+		d.opts, d.FormatMajorVersion(), paths, nil /* bounds */, d.opts.Cache.NewID(), fileNums,
+	)
+	reports := make([]IngestInputReport, len(paths))
+	var valid []int
+	for i := range paths {
+		r := &reports[i]
+		r.Path = paths[i]
+		r.Err = errs[i]
+		switch {
+		case r.Err != nil:
+		case loaded[i] == nil:
+			r.Empty = true
+		default:
+			r.Smallest = loaded[i].Smallest
+			r.Largest = loaded[i].Largest
+			valid = append(valid, i)
+		}
+	}
+
+	// Check the sstables for overlaps by sorting them by smallest key, and
+	// comparing each with the sstable with the largest bound among the
+	// sstables before it.
+	sort.SliceStable(valid, func(i, j int) bool {
+		return d.cmp(reports[valid[i]].Smallest.UserKey, reports[valid[j]].Smallest.UserKey) < 0
+	})
+	overlaps := func(i, j int) {
+		if reports[i].Err == nil {
+			reports[i].Err = errors.Newf("pebble: external sstable overlaps %s", errors.Safe(paths[j]))
+		}
+	}
+	last := -1
+	for _, i := range valid {
+		if last >= 0 && sstableKeyCompare(d.cmp, reports[last].Largest, reports[i].Smallest) >= 0 {
+			overlaps(last, i)
+			overlaps(i, last)
+		}
+		if last < 0 || sstableKeyCompare(d.cmp, reports[i].Largest, reports[last].Largest) > 0 {
+			last = i
+		}
+	}
+	return reports
 }
 
 // Both DB.mu and commitPipeline.mu must be held while this is called.
@@ -784,7 +1051,7 @@ func (d *DB) handleIngestAsFlushable(meta []*fileMetadata, seqNum uint64) error
 }
 
 func (d *DB) ingest(
-	paths []string, targetLevelFunc ingestTargetLevelFunc,
+	paths []string, bounds []ingestBounds, targetLevelFunc ingestTargetLevelFunc,
 ) (IngestOperationStats, error) {
 	// Allocate file numbers for all of the files being ingested and mark them as
 	// pending in order to prevent them from being deleted. Note that this causes
@@ -802,7 +1069,9 @@ func (d *DB) ingest(
 
 	// Load the metadata for all of the files being ingested. This step detects
 	// and elides empty sstables.
-	meta, paths, err := ingestLoad(d.opts, d.FormatMajorVersion(), paths, d.cacheID, pendingOutputs)
+	meta, paths, err := ingestLoad(
+		d.opts, d.FormatMajorVersion(), paths, bounds, d.cacheID, pendingOutputs,
+	)
 	if err != nil {
 		return IngestOperationStats{}, err
 	}
@@ -818,10 +1087,11 @@ func (d *DB) ingest(
 
 	// Hard link the sstables into the DB directory. Since the sstables aren't
 	// referenced by a version, they won't be used. If the hard linking fails
-	// (e.g. because the files reside on a different filesystem), ingestLink will
-	// fall back to copying, and if that fails we undo our work and return an
-	// error.
-	if err := ingestLink(jobID, d.opts, d.objProvider, paths, meta); err != nil {
+	// (e.g. because the files reside on a different filesystem), or if copies
+	// were requested, ingestLink will copy the sstables instead, and if that
+	// fails we undo our work and return an error.
+	copied, err := ingestLink(jobID, d.opts, d.objProvider, paths, meta)
+	if err != nil {
 		return IngestOperationStats{}, err
 	}
 	// Make the new tables durable. We need to do this at some point before we
@@ -831,14 +1101,38 @@ func (d *DB) ingest(
 		return IngestOperationStats{}, err
 	}
 
+	// Virtual tables cannot be ingested as flushables, as the WAL entries of
+	// flushable ingests only record the file numbers of the sstables and not
+	// the bounds of the tables.
+	var hasVirtual bool
+	for _, m := range meta {
+		hasVirtual = hasVirtual || m.Virtual != nil
+	}
+
+	// Flush the memtables which overlap the ingestion before allocating its
+	// sequence number, so that the commit pipeline is not blocked while the
+	// flush occurs.
+	if err := d.ingestFlushOverlappingMemtables(meta, hasVirtual); err != nil {
+		if err2 := ingestCleanup(d.objProvider, meta); err2 != nil {
+			d.opts.Logger.Infof("ingest cleanup failed: %v", err2)
+		}
+		return IngestOperationStats{}, err
+	}
+
 	var mem *flushableEntry
 	// asFlushable indicates whether the sstable was ingested as a flushable.
 	var asFlushable bool
+	var req *ingestApplyRequest
 	prepare := func(seqNum uint64) {
 		// Note that d.commit.mu is held by commitPipeline when calling prepare.
 
 		d.mu.Lock()
 		defer d.mu.Unlock()
+		defer func() {
+			if err == nil && !asFlushable {
+				req = d.queueIngestLocked(jobID, seqNum, meta, targetLevelFunc)
+			}
+		}()
 
 		// Check to see if any files overlap with any of the memtables. The queue
 		// is ordered from oldest to newest with the mutable memtable being the
@@ -847,9 +1141,13 @@ func (d *DB) ingest(
 		for i := len(d.mu.mem.queue) - 1; i >= 0; i-- {
 			m := d.mu.mem.queue[i]
 			if ingestMemtableOverlaps(d.cmp, m, meta) {
+				// An ingestion as a flushable is only placed into the LSM when
+				// flushed, and must not be overtaken by a queued ingestion with a
+				// lower sequence number.
 				if (len(d.mu.mem.queue) > d.opts.MemTableStopWritesThreshold-1) ||
 					d.mu.formatVers.vers < FormatFlushableIngest ||
-					d.opts.Experimental.DisableIngestAsFlushable() {
+					d.opts.Experimental.DisableIngestAsFlushable() || hasVirtual ||
+					len(d.mu.ingest.queue) > 0 {
 					mem = m
 					if mem.flushable == d.mu.mem.mutable {
 						err = d.makeRoomForWrite(nil)
@@ -883,6 +1181,9 @@ func (d *DB) ingest(
 		if err = ingestUpdateSeqNum(
 			d.cmp, d.opts.Comparer.FormatKey, seqNum, meta,
 		); err != nil {
+			d.mu.Lock()
+			d.removeIngestLocked(req)
+			d.mu.Unlock()
 			return
 		}
 
@@ -894,7 +1195,7 @@ func (d *DB) ingest(
 
 		// Assign the sstables to the correct level in the LSM and apply the
 		// version edit.
-		ve, err = d.ingestApply(jobID, meta, targetLevelFunc)
+		ve, err = d.ingestApply(req)
 	}
 
 	d.commit.AllocateSeqNum(len(meta), prepare, apply)
@@ -923,12 +1224,14 @@ func (d *DB) ingest(
 	if ve != nil {
 		info.Tables = make([]struct {
 			TableInfo
-			Level int
+			Level  int
+			Copied bool
 		}, len(ve.NewFiles))
 		for i := range ve.NewFiles {
 			e := &ve.NewFiles[i]
 			info.Tables[i].Level = e.Level
 			info.Tables[i].TableInfo = e.Meta.TableInfo()
+			info.Tables[i].Copied = copied[e.Meta.FileNum]
 			stats.Bytes += e.Meta.Size
 			if e.Level == 0 {
 				stats.ApproxIngestedIntoL0Bytes += e.Meta.Size
@@ -937,11 +1240,13 @@ func (d *DB) ingest(
 	} else if asFlushable {
 		info.Tables = make([]struct {
 			TableInfo
-			Level int
+			Level  int
+			Copied bool
 		}, len(meta))
 		for i, f := range meta {
 			info.Tables[i].Level = -1
 			info.Tables[i].TableInfo = f.TableInfo()
+			info.Tables[i].Copied = copied[f.FileNum]
 		}
 	}
 	d.opts.EventListener.TableIngested(info)
@@ -949,6 +1254,56 @@ func (d *DB) ingest(
 	return stats, err
 }
 
+// ingestFlushOverlappingMemtables forces the flush of the most recent memtable
+// which overlaps the sstables of an ingestion, if any, and waits for the flush
+// to complete. It is called before the ingestion is assigned a sequence number:
+// if the ingestion overlapped a memtable once it holds a sequence number, the
+// commit pipeline would be blocked for the duration of the flush, as the
+// writes with higher sequence numbers cannot be published before the
+// ingestion. Writes committed while the flush occurs may overlap the ingestion
+// again, in which case the flush is forced by the prepare callback of the
+// ingestion as before, but the common case of an ingestion overlapping older
+// writes no longer stalls the writes.
+//
+// Nothing is flushed if the ingestion would be ingested as a flushable, as
+// that does not block the commit pipeline.
+func (d *DB) ingestFlushOverlappingMemtables(meta []*fileMetadata, hasVirtual bool) error {
+	d.commit.mu.Lock()
+	d.mu.Lock()
+	if d.mu.formatVers.vers >= FormatFlushableIngest &&
+		!d.opts.Experimental.DisableIngestAsFlushable() && !hasVirtual &&
+		len(d.mu.mem.queue) <= d.opts.MemTableStopWritesThreshold-1 &&
+		len(d.mu.ingest.queue) == 0 {
+		d.mu.Unlock()
+		d.commit.mu.Unlock()
+		return nil
+	}
+	var mem *flushableEntry
+	for i := len(d.mu.mem.queue) - 1; i >= 0 && mem == nil; i-- {
+		if ingestMemtableOverlaps(d.cmp, d.mu.mem.queue[i], meta) {
+			mem = d.mu.mem.queue[i]
+		}
+	}
+	var err error
+	if mem != nil {
+		if mem.flushable == d.mu.mem.mutable {
+			err = d.makeRoomForWrite(nil)
+		}
+		mem.flushForced = true
+		d.maybeScheduleFlush()
+	}
+	d.mu.Unlock()
+	d.commit.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if mem != nil {
+		<-mem.flushed
+	}
+	return nil
+}
+
 type ingestTargetLevelFunc func(
 	newIters tableNewIters,
 	newRangeKeyIter keyspan.TableNewSpanIter,
@@ -960,53 +1315,182 @@ type ingestTargetLevelFunc func(
 	meta *fileMetadata,
 ) (int, error)
 
-func (d *DB) ingestApply(
-	jobID int, meta []*fileMetadata, findTargetLevel ingestTargetLevelFunc,
-) (*versionEdit, error) {
+// ingestApplyRequest is a request to add the sstables of an ingestion to the
+// LSM (see DB.ingestApply).
+type ingestApplyRequest struct {
+	jobID           int
+	seqNum          uint64
+	meta            []*fileMetadata
+	findTargetLevel ingestTargetLevelFunc
+	// smallest and largest are the user key bounds of the ingested sstables.
+	// They are copied from meta when the request is queued, as the bounds in
+	// meta are updated by ingestUpdateSeqNum concurrently with the application
+	// of other requests.
+	smallest, largest []byte
+
+	// ready is set once the sequence number of the sstables has been updated
+	// and the request can be applied.
+	ready bool
+	// ve and err hold the result of the request once done is set.
+	ve   *versionEdit
+	err  error
+	done bool
+}
+
+// queueIngestLocked queues a request to add the sstables of the ingestion with
+// the given sequence number to the LSM. The request must be applied with
+// DB.ingestApply, or removed with DB.removeIngestLocked. Requests are queued in
+// sequence number order, as they are queued by the prepare callback of the
+// ingestion. d.mu must be held when calling this method.
+func (d *DB) queueIngestLocked(
+	jobID int, seqNum uint64, meta []*fileMetadata, findTargetLevel ingestTargetLevelFunc,
+) *ingestApplyRequest {
+	req := &ingestApplyRequest{
+		jobID:           jobID,
+		seqNum:          seqNum,
+		meta:            meta,
+		findTargetLevel: findTargetLevel,
+		smallest:        meta[0].Smallest.UserKey,
+		largest:         meta[0].Largest.UserKey,
+	}
+	for _, m := range meta[1:] {
+		if d.cmp(m.Largest.UserKey, req.largest) > 0 {
+			req.largest = m.Largest.UserKey
+		}
+	}
+	d.mu.ingest.queue = append(d.mu.ingest.queue, req)
+	return req
+}
+
+// removeIngestLocked removes the given requests from the queue of ingestions.
+// d.mu must be held when calling this method.
+func (d *DB) removeIngestLocked(reqs ...*ingestApplyRequest) {
+	queue := d.mu.ingest.queue[:0]
+	for _, req := range d.mu.ingest.queue {
+		removed := false
+		for _, r := range reqs {
+			removed = removed || r == req
+		}
+		if !removed {
+			queue = append(queue, req)
+		}
+	}
+	for i := len(queue); i < len(d.mu.ingest.queue); i++ {
+		d.mu.ingest.queue[i] = nil
+	}
+	d.mu.ingest.queue = queue
+	// Requests waiting for one of the removed requests may now be applied.
+	d.mu.ingest.cond.Broadcast()
+}
+
+// ingestApply adds the sstables of a queued ingestion to the LSM. Concurrent
+// ingestions are applied in batches with a single version edit, amortizing the
+// cost of the manifest write: the ingestion which finds no batch being applied
+// applies all of the ready requests, while the others wait for it.
+//
+// Since the ingestions are not serialized by the commit pipeline, an ingestion
+// must not determine its target levels before the ingestions with lower
+// sequence numbers whose sstables it overlaps are applied. Ingestions which do
+// not overlap may be applied in any order.
+func (d *DB) ingestApply(req *ingestApplyRequest) (*versionEdit, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	ve := &versionEdit{
-		NewFiles: make([]newFileEntry, len(meta)),
+	req.ready = true
+	for !req.done {
+		if !d.mu.ingest.applying && d.applyReadyIngestsLocked() {
+			continue
+		}
+		d.mu.ingest.cond.Wait()
 	}
-	metrics := make(map[int]*LevelMetrics)
+	return req.ve, req.err
+}
+
+// applyReadyIngestsLocked applies the queued ingestions which are ready and do
+// not overlap an ingestion with a lower sequence number with a single version
+// edit, and returns false if there are no such ingestions. d.mu must be held
+// when calling this method, but it is released while the manifest is written.
+func (d *DB) applyReadyIngestsLocked() bool {
+	var batch []*ingestApplyRequest
+	for i, req := range d.mu.ingest.queue {
+		if req.ready && !ingestOverlapsRequests(d.cmp, d.mu.ingest.queue[:i], req) {
+			batch = append(batch, req)
+		}
+	}
+	if len(batch) == 0 {
+		return false
+	}
+
+	d.mu.ingest.applying = true
+	defer func() {
+		for _, req := range batch {
+			req.done = true
+		}
+		d.removeIngestLocked(batch...)
+		d.mu.ingest.applying = false
+	}()
 
 	// Lock the manifest for writing before we use the current version to
-	// determine the target level. This prevents two concurrent ingestion jobs
-	// from using the same version to determine the target level, and also
-	// provides serialization with concurrent compaction and flush jobs.
-	// logAndApply unconditionally releases the manifest lock, but any earlier
-	// returns must unlock the manifest.
+	// determine the target level. This prevents concurrent ingestion jobs from
+	// using the same version to determine the target level, and also provides
+	// serialization with concurrent compaction and flush jobs. logAndApply
+	// unconditionally releases the manifest lock, but any earlier returns must
+	// unlock the manifest.
 	d.mu.versions.logLock()
+
+	ve := &versionEdit{}
+	metrics := make(map[int]*LevelMetrics)
+	jobID := 0
 	current := d.mu.versions.currentVersion()
 	baseLevel := d.mu.versions.picker.getBaseLevel()
 	iterOps := IterOptions{logger: d.opts.Logger}
-	for i := range meta {
-		// Determine the lowest level in the LSM for which the sstable doesn't
-		// overlap any existing files in the level.
-		m := meta[i]
-		f := &ve.NewFiles[i]
-		var err error
-		f.Level, err = findTargetLevel(d.newIters, d.tableNewRangeKeyIter, iterOps, d.cmp, current, baseLevel, d.mu.compact.inProgress, m)
-		if err != nil {
-			d.mu.versions.logUnlock()
-			return nil, err
+	var applied []*ingestApplyRequest
+	for _, req := range batch {
+		req.ve = &versionEdit{
+			NewFiles: make([]newFileEntry, len(req.meta)),
+		}
+		for i, m := range req.meta {
+			// Determine the lowest level in the LSM for which the sstable
+			// doesn't overlap any existing files in the level.
+			f := &req.ve.NewFiles[i]
+			f.Level, req.err = req.findTargetLevel(d.newIters, d.tableNewRangeKeyIter, iterOps, d.cmp, current, baseLevel, d.mu.compact.inProgress, m)
+			if req.err != nil {
+				req.ve = nil
+				break
+			}
+			f.Meta = m
 		}
-		f.Meta = m
-		levelMetrics := metrics[f.Level]
-		if levelMetrics == nil {
-			levelMetrics = &LevelMetrics{}
-			metrics[f.Level] = levelMetrics
+		if req.err != nil {
+			continue
+		}
+		if jobID == 0 {
+			jobID = req.jobID
+		}
+		for _, f := range req.ve.NewFiles {
+			levelMetrics := metrics[f.Level]
+			if levelMetrics == nil {
+				levelMetrics = &LevelMetrics{}
+				metrics[f.Level] = levelMetrics
+			}
+			levelMetrics.NumFiles++
+			levelMetrics.Size += int64(f.Meta.Size)
+			levelMetrics.BytesIngested += f.Meta.Size
+			levelMetrics.TablesIngested++
 		}
-		levelMetrics.NumFiles++
-		levelMetrics.Size += int64(m.Size)
-		levelMetrics.BytesIngested += m.Size
-		levelMetrics.TablesIngested++
+		ve.NewFiles = append(ve.NewFiles, req.ve.NewFiles...)
+		applied = append(applied, req)
+	}
+	if len(applied) == 0 {
+		d.mu.versions.logUnlock()
+		return true
 	}
 	if err := d.mu.versions.logAndApply(jobID, ve, metrics, false /* forceRotation */, func() []compactionInfo {
 		return d.getInProgressCompactionInfoLocked(nil)
 	}); err != nil {
-		return nil, err
+		for _, req := range applied {
+			req.ve, req.err = nil, err
+		}
+		return true
 	}
 	d.updateReadStateLocked(d.opts.DebugCheck)
 	d.updateTableStatsLocked(ve.NewFiles)
@@ -1015,7 +1499,20 @@ func (d *DB) ingestApply(
 	// so check to see if one is necessary and schedule it.
 	d.maybeScheduleCompaction()
 	d.maybeValidateSSTablesLocked(ve.NewFiles)
-	return ve, nil
+	return true
+}
+
+// ingestOverlapsRequests returns true if the bounds of the sstables of req
+// overlap those of any of reqs.
+func ingestOverlapsRequests(
+	cmp Compare, reqs []*ingestApplyRequest, req *ingestApplyRequest,
+) bool {
+	for _, r := range reqs {
+		if cmp(r.smallest, req.largest) <= 0 && cmp(req.smallest, r.largest) <= 0 {
+			return true
+		}
+	}
+	return false
 }
 
 // maybeValidateSSTablesLocked adds the slice of newFileEntrys to the pending
@@ -14,18 +14,22 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/internal/testkeys"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/kr/pretty"
@@ -119,7 +123,7 @@ func TestIngestLoad(t *testing.T) {
 				Comparer: DefaultComparer,
 				FS:       mem,
 			}).WithFSDefaults()
-			meta, _, err := ingestLoad(opts, dbVersion, []string{"ext"}, 0, []FileNum{1})
+			meta, _, err := ingestLoad(opts, dbVersion, []string{"ext"}, nil /* bounds */, 0, []FileNum{1})
 			if err != nil {
 				return err.Error()
 			}
@@ -182,7 +186,7 @@ func TestIngestLoadRand(t *testing.T) {
 			w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{
 				TableFormat: version.MaxTableFormat(),
 			})
-			var count uint64
+			var count, size uint64
 			for i := range keys {
 				if i > 0 && base.InternalCompare(cmp, keys[i-1], keys[i]) == 0 {
 					// Duplicate key, ignore.
@@ -190,8 +194,10 @@ func TestIngestLoadRand(t *testing.T) {
 				}
 				w.Add(keys[i], nil)
 				count++
+				size += uint64(keys[i].Size())
 			}
 			expected[i].Stats.NumEntries = count
+			expected[i].Stats.UncompressedSize = size
 			require.NoError(t, w.Close())
 
 			meta, err := w.Metadata()
@@ -205,7 +211,7 @@ func TestIngestLoadRand(t *testing.T) {
 		Comparer: DefaultComparer,
 		FS:       mem,
 	}).WithFSDefaults()
-	meta, _, err := ingestLoad(opts, version, paths, 0, pending)
+	meta, _, err := ingestLoad(opts, version, paths, nil /* bounds */, 0, pending)
 	require.NoError(t, err)
 
 	for _, m := range meta {
@@ -226,11 +232,104 @@ func TestIngestLoadInvalid(t *testing.T) {
 		Comparer: DefaultComparer,
 		FS:       mem,
 	}).WithFSDefaults()
-	if _, _, err := ingestLoad(opts, FormatNewest, []string{"invalid"}, 0, []FileNum{1}); err == nil {
+	if _, _, err := ingestLoad(opts, FormatNewest, []string{"invalid"}, nil /* bounds */, 0, []FileNum{1}); err == nil {
 		t.Fatalf("expected error, but found success")
 	}
 }
 
+func TestIngestLoadOrder(t *testing.T) {
+	mem := vfs.NewMem()
+	paths := make([]string, 3*ingestLoadConcurrency)
+	pending := make([]FileNum, len(paths))
+	for i := range paths {
+		paths[i] = fmt.Sprintf("ext%02d", i)
+		pending[i] = FileNum(100 + i)
+		f, err := mem.Create(paths[i])
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{
+			TableFormat: FormatNewest.MaxTableFormat(),
+		})
+		// Leave one of the sstables empty; it is skipped.
+		if i != 5 {
+			require.NoError(t, w.Set([]byte(paths[i]), nil))
+		}
+		require.NoError(t, w.Close())
+	}
+
+	opts := (&Options{
+		Comparer: DefaultComparer,
+		FS:       mem,
+	}).WithFSDefaults()
+	meta, newPaths, err := ingestLoad(opts, FormatNewest, paths, nil /* bounds */, 0, pending)
+	require.NoError(t, err)
+	require.Len(t, meta, len(paths)-1)
+	require.Equal(t, append(paths[:5:5], paths[6:]...), newPaths)
+	for i, m := range meta {
+		require.Equal(t, newPaths[i], string(m.Smallest.UserKey))
+	}
+
+	// The error of the first failing sstable in input order is returned.
+	require.NoError(t, mem.Remove(paths[40]))
+	require.NoError(t, mem.Remove(paths[10]))
+	_, _, err = ingestLoad(opts, FormatNewest, paths, nil /* bounds */, 0, pending)
+	require.True(t, oserror.IsNotExist(err))
+	require.Contains(t, err.Error(), paths[10])
+}
+
+func TestValidateIngestInputs(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	writeSST := func(path string, seqNum uint64, keys ...string) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{
+			TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+		})
+		for _, k := range keys {
+			require.NoError(t, w.Add(base.MakeInternalKey([]byte(k), seqNum, InternalKeyKindSet), nil))
+		}
+		require.NoError(t, w.Close())
+	}
+	writeSST("ab", 0, "a", "b")
+	writeSST("df", 0, "d", "f")
+	writeSST("eg", 0, "e", "g")
+	writeSST("empty", 0)
+	writeSST("seqnum", 5, "x")
+
+	paths := []string{"df", "ab", "missing", "eg", "empty", "seqnum"}
+	reports := d.ValidateIngestInputs(paths)
+	require.Len(t, reports, len(paths))
+	for i, r := range reports {
+		require.Equal(t, paths[i], r.Path)
+	}
+	require.NoError(t, reports[1].Err)
+	require.Equal(t, "a", string(reports[1].Smallest.UserKey))
+	require.Equal(t, "b", string(reports[1].Largest.UserKey))
+	require.Error(t, reports[0].Err)
+	require.Contains(t, reports[0].Err.Error(), "overlaps eg")
+	require.Error(t, reports[3].Err)
+	require.Contains(t, reports[3].Err.Error(), "overlaps df")
+	require.True(t, oserror.IsNotExist(reports[2].Err))
+	require.NoError(t, reports[4].Err)
+	require.True(t, reports[4].Empty)
+	require.Error(t, reports[5].Err)
+
+	// Validation leaves the DB and the sstables untouched.
+	for _, path := range []string{"ab", "df", "eg", "empty", "seqnum"} {
+		_, err := mem.Stat(path)
+		require.NoError(t, err)
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	for _, level := range tables {
+		require.Empty(t, level)
+	}
+	require.NoError(t, d.Ingest([]string{"ab"}))
+}
+
 func TestIngestSortAndVerify(t *testing.T) {
 	comparers := map[string]Compare{
 		"default": DefaultComparer.Compare,
@@ -319,7 +418,7 @@ func TestIngestLink(t *testing.T) {
 				opts.FS.Remove(paths[i])
 			}
 
-			err = ingestLink(0 /* jobID */, opts, objProvider, paths, meta)
+			_, err = ingestLink(0 /* jobID */, opts, objProvider, paths, meta)
 			if i < count {
 				if err == nil {
 					t.Fatalf("expected error, but found success")
@@ -385,8 +484,9 @@ func TestIngestLinkFallback(t *testing.T) {
 	defer objProvider.Close()
 
 	meta := []*fileMetadata{{FileNum: 1}}
-	err = ingestLink(0, opts, objProvider, []string{"source"}, meta)
+	copied, err := ingestLink(0, opts, objProvider, []string{"source"}, meta)
 	require.NoError(t, err)
+	require.Equal(t, map[base.FileNum]bool{1: true}, copied)
 
 	dest, err := mem.Open("000001.sst")
 	require.NoError(t, err)
@@ -1003,6 +1103,164 @@ func TestIngestIdempotence(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestIngestCopy(t *testing.T) {
+	for _, ingestCopy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("copy=%t", ingestCopy), func(t *testing.T) {
+			mem := vfs.NewMem()
+			var info TableIngestInfo
+			opts := &Options{
+				FS: mem,
+				EventListener: &EventListener{
+					TableIngested: func(i TableIngestInfo) { info = i },
+				},
+			}
+			opts.Experimental.IngestCopy = ingestCopy
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			f, err := mem.Create("sst")
+			require.NoError(t, err)
+			w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{})
+			require.NoError(t, w.Set([]byte("a"), []byte("b")))
+			require.NoError(t, w.Close())
+			// Keep the external file open, to observe whether the ingested
+			// table shares its data.
+			f, err = mem.Open("sst")
+			require.NoError(t, err)
+			data, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			src, err := mem.Create("ext")
+			require.NoError(t, err)
+			defer src.Close()
+			_, err = src.Write(data)
+			require.NoError(t, err)
+
+			require.NoError(t, d.Ingest([]string{"ext"}))
+			require.NoError(t, info.Err)
+			require.Len(t, info.Tables, 1)
+			require.Equal(t, ingestCopy, info.Tables[0].Copied)
+
+			_, err = src.Write([]byte("x"))
+			require.NoError(t, err)
+			dst, err := mem.Stat(base.MakeFilepath(mem, "", fileTypeTable, info.Tables[0].FileNum))
+			require.NoError(t, err)
+			require.Equal(t, ingestCopy, dst.Size() == int64(len(data)))
+		})
+	}
+}
+
+func TestIngestFilesVirtual(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatNewest}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("d0"), nil, nil))
+	require.NoError(t, d.Set([]byte("x"), nil, nil))
+	require.NoError(t, d.Flush())
+
+	writeSST := func(path string) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{
+			TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+		})
+		for c := 'a'; c <= 'j'; c++ {
+			require.NoError(t, w.Set([]byte{byte(c)}, []byte{byte(c)}))
+		}
+		require.NoError(t, w.DeleteRange([]byte("a"), []byte("z")))
+		require.NoError(t, w.RangeKeySet([]byte("a"), []byte("z"), nil, []byte("v")))
+		require.NoError(t, w.Close())
+	}
+	scan := func() string {
+		iter := d.NewIter(&IterOptions{KeyTypes: IterKeyTypePointsAndRanges})
+		var buf strings.Builder
+		for valid := iter.First(); valid; valid = iter.Next() {
+			hasPoint, hasRange := iter.HasPointAndRange()
+			if hasRange && iter.RangeKeyChanged() {
+				start, end := iter.RangeBounds()
+				fmt.Fprintf(&buf, "[%s-%s) ", start, end)
+			}
+			if hasPoint {
+				fmt.Fprintf(&buf, "%s ", iter.Key())
+			}
+		}
+		require.NoError(t, iter.Close())
+		return strings.TrimSpace(buf.String())
+	}
+
+	writeSST("ext")
+	_, err = d.IngestFiles([]IngestFile{{Path: "ext", Start: []byte("c")}})
+	require.Error(t, err)
+	_, err = d.IngestFiles([]IngestFile{{Path: "ext", Start: []byte("f"), End: []byte("c")}})
+	require.Error(t, err)
+
+	_, err = d.IngestFiles([]IngestFile{{Path: "ext", Start: []byte("c"), End: []byte("f")}})
+	require.NoError(t, err)
+	// The range deletion only deletes d0 within the bounds, and the range key
+	// is truncated to the bounds.
+	const want = "[c-f) c d e x"
+	require.Equal(t, want, scan())
+
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	var virtual int
+	for _, level := range tables {
+		for _, table := range level {
+			if table.Virtual {
+				virtual++
+				require.Equal(t, "c", string(table.Smallest.UserKey))
+				require.Equal(t, "f", string(table.Largest.UserKey))
+			}
+		}
+	}
+	require.Equal(t, 1, virtual)
+
+	v, closer, err := d.Get([]byte("e"))
+	require.NoError(t, err)
+	require.Equal(t, "e", string(v))
+	require.NoError(t, closer.Close())
+	_, _, err = d.Get([]byte("f"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	iter := d.NewIter(&IterOptions{LowerBound: []byte("a"), UpperBound: []byte("e")})
+	require.True(t, iter.Last())
+	require.Equal(t, "d", string(iter.Key()))
+	require.True(t, iter.SeekGE([]byte("a")))
+	require.Equal(t, "c", string(iter.Key()))
+	require.NoError(t, iter.Close())
+
+	// The bounds of the virtual table survive reopening the DB.
+	require.NoError(t, d.Close())
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.Equal(t, want, scan())
+
+	// Compactions only output the keys of the virtual table, after which the
+	// backing sstable is deleted.
+	require.NoError(t, d.Compact([]byte("a"), []byte("z"), false /* parallelize */))
+	require.Equal(t, want, scan())
+	tables, err = d.SSTables()
+	require.NoError(t, err)
+	for _, level := range tables {
+		for _, table := range level {
+			require.False(t, table.Virtual)
+		}
+	}
+
+	// Ingesting sstables with bounds requires FormatVirtualSSTables.
+	require.NoError(t, d.Close())
+	opts.FormatMajorVersion = FormatCompressionDictionaries
+	d, err = Open("ingest-fmv", opts)
+	require.NoError(t, err)
+	writeSST("ext")
+	_, err = d.IngestFiles([]IngestFile{{Path: "ext", Start: []byte("c"), End: []byte("f")}})
+	require.Error(t, err)
+}
+
 func TestIngestCompact(t *testing.T) {
 	mem := vfs.NewMem()
 	lel := MakeLoggingEventListener(&base.InMemLogger{})
@@ -1093,6 +1351,93 @@ func TestConcurrentIngest(t *testing.T) {
 	require.NoError(t, d.Close())
 }
 
+func TestConcurrentIngestBatching(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+
+	ingestConcurrently := func(keys ...string) {
+		for i, k := range keys {
+			f, err := mem.Create(fmt.Sprintf("ext%d", i))
+			require.NoError(t, err)
+			w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{})
+			require.NoError(t, w.Set([]byte(k), nil))
+			require.NoError(t, w.Close())
+		}
+
+		// Pretend a batch of ingestions is being applied, so that the
+		// ingestions queue up. Note that this also checks that the ingestions
+		// are not serialized by the commit pipeline.
+		d.mu.Lock()
+		d.mu.ingest.applying = true
+		d.mu.Unlock()
+		errCh := make(chan error, len(keys))
+		for i := range keys {
+			go func(i int) {
+				errCh <- d.Ingest([]string{fmt.Sprintf("ext%d", i)})
+			}(i)
+		}
+		require.Eventually(t, func() bool {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			for _, req := range d.mu.ingest.queue {
+				if !req.ready {
+					return false
+				}
+			}
+			return len(d.mu.ingest.queue) == len(keys)
+		}, 10*time.Second, time.Millisecond)
+		d.mu.Lock()
+		d.mu.ingest.applying = false
+		d.mu.ingest.cond.Broadcast()
+		d.mu.Unlock()
+		for range keys {
+			require.NoError(t, <-errCh)
+		}
+	}
+
+	// countIngestEdits returns the number of version edits in the manifest
+	// which add tables.
+	countIngestEdits := func() int {
+		d.mu.Lock()
+		manifestFileNum := d.mu.versions.manifestFileNum
+		d.mu.Unlock()
+		f, err := mem.Open(base.MakeFilename(fileTypeManifest, manifestFileNum))
+		require.NoError(t, err)
+		defer f.Close()
+		rr := record.NewReader(f, 0 /* logNum */)
+		var count int
+		for {
+			r, err := rr.Next()
+			if err == io.EOF {
+				return count
+			}
+			require.NoError(t, err)
+			var ve versionEdit
+			require.NoError(t, ve.Decode(r))
+			if len(ve.NewFiles) > 0 {
+				count++
+			}
+		}
+	}
+
+	// Ingestions which do not overlap are applied with a single version edit.
+	ingestConcurrently("a", "b", "c", "d")
+	require.Equal(t, 1, countIngestEdits())
+
+	// Ingestions which overlap are applied one after the other, so that the
+	// most recent one is placed above the others.
+	ingestConcurrently("e", "e")
+	require.Equal(t, 3, countIngestEdits())
+	d.mu.Lock()
+	v := d.mu.versions.currentVersion()
+	require.Equal(t, 1, v.Levels[0].Len())
+	require.Equal(t, 5, v.Levels[numLevels-1].Len())
+	d.mu.Unlock()
+
+	require.NoError(t, d.Close())
+}
+
 func TestConcurrentIngestCompact(t *testing.T) {
 	for i := 0; i < 2; i++ {
 		t.Run("", func(t *testing.T) {
@@ -2020,3 +2365,191 @@ func runBenchmarkManySSTablesInUseKeyRanges(b *testing.B, d *DB, count int) {
 		_ = calculateInuseKeyRanges(v, d.cmp, 0, numLevels-1, smallest, largest)
 	}
 }
+
+func TestIngestWithDBWriterOptions(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatNewest}
+	opts.Levels = make([]LevelOptions, numLevels)
+	opts.Levels[numLevels-1].BlockSize = 64
+	opts.Levels[numLevels-1].FilterPolicy = bloom.FilterPolicy(10)
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	readerOpts := d.MakeReaderOptions(func(o *sstable.ReaderOptions) { o.Cache = nil })
+	require.Equal(t, d.opts.Comparer, readerOpts.Comparer)
+	require.Nil(t, readerOpts.Cache)
+
+	writerOpts := d.MakeWriterOptions(numLevels-1, func(o *sstable.WriterOptions) {
+		o.BlockSize = 128
+	})
+	require.Equal(t, d.opts.Comparer, writerOpts.Comparer)
+	require.Equal(t, 128, writerOpts.BlockSize)
+	require.Equal(t, opts.Levels[numLevels-1].FilterPolicy, writerOpts.FilterPolicy)
+	require.LessOrEqual(t, writerOpts.TableFormat, d.FormatMajorVersion().MaxTableFormat())
+	require.Equal(t, d.makeWriterOptions(numLevels-1, d.FormatMajorVersion(), d.ID()).TableFormat, writerOpts.TableFormat)
+
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorage.NewFileWritable(f), writerOpts)
+	require.NoError(t, w.Set([]byte("a"), []byte("a")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Len(t, tables[numLevels-1], 1)
+	require.Equal(t, "rocksdb.BuiltinBloomFilter", tables[numLevels-1][0].Properties.FilterPolicyName)
+}
+
+// TestIngestFlushDoesNotStallWrites verifies that writes are not blocked while
+// an ingestion waits for the flush of a memtable it overlaps.
+func TestIngestFlushDoesNotStallWrites(t *testing.T) {
+	mem := vfs.NewMem()
+	flushBegan := make(chan struct{}, 1)
+	unblockFlush := make(chan struct{})
+	var blockFlush atomic.Bool
+	d, err := Open("", &Options{
+		FS: mem,
+		// Ingestions as flushables never wait for a flush.
+		FormatMajorVersion: FormatFlushableIngest - 1,
+		EventListener: &EventListener{
+			// TableCreated is invoked by the flush without holding DB.mu.
+			TableCreated: func(info TableCreateInfo) {
+				if info.Reason == "flushing" && blockFlush.Load() {
+					flushBegan <- struct{}{}
+					<-unblockFlush
+				}
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.Set([]byte("a"), []byte("mem"), nil))
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: d.FormatMajorVersion().MaxTableFormat(),
+	})
+	require.NoError(t, w.Set([]byte("a"), []byte("ingested")))
+	require.NoError(t, w.Close())
+
+	blockFlush.Store(true)
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Ingest([]string{"ext"}) }()
+	select {
+	case <-flushBegan:
+	case err := <-errCh:
+		t.Fatalf("ingest: %v", err)
+	}
+	blockFlush.Store(false)
+
+	// The ingestion is waiting for the flush of the memtable containing "a".
+	// A write to another key must not wait for the ingestion.
+	setCh := make(chan error, 1)
+	go func() { setCh <- d.Set([]byte("z"), nil, nil) }()
+	select {
+	case err := <-setCh:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		close(unblockFlush)
+		t.Fatal("write blocked by ingestion")
+	}
+
+	close(unblockFlush)
+	require.NoError(t, <-errCh)
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "ingested", string(v))
+	require.NoError(t, closer.Close())
+}
+
+func TestIngestValidators(t *testing.T) {
+	mem := vfs.NewMem()
+	writeTable := func(path string, n int, withProps bool) {
+		f, err := mem.Create(path)
+		require.NoError(t, err)
+		opts := sstable.WriterOptions{TableFormat: sstable.TableFormatPebblev2}
+		if withProps {
+			opts.TablePropertyCollectors = []func() TablePropertyCollector{
+				func() TablePropertyCollector { return &minSeqNumPropertyCollector{} },
+			}
+		}
+		w := sstable.NewWriter(objstorage.NewFileWritable(f), opts)
+		for i := 0; i < n; i++ {
+			require.NoError(t, w.Set([]byte(fmt.Sprintf("%s%03d", path, i)), nil))
+		}
+		require.NoError(t, w.Close())
+	}
+
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatNewest})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	d.opts.Experimental.IngestValidators = []IngestValidator{
+		RequireComparer(DefaultComparer.Name),
+		RequireUserProperties("test.min-seq-num"),
+		MaxIngestEntries(10),
+	}
+
+	// A rejected sstable prevents the ingestion of all the sstables.
+	writeTable("a", 5, true)
+	writeTable("b", 5, false)
+	err = d.Ingest([]string{"a", "b"})
+	var rejected *IngestRejectedError
+	require.True(t, errors.As(err, &rejected))
+	require.Equal(t, "b", rejected.Path)
+	require.Contains(t, err.Error(), `missing user property "test.min-seq-num"`)
+	_, closer, err := d.Get([]byte("a000"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Nil(t, closer)
+
+	writeTable("c", 20, true)
+	reports := d.ValidateIngestInputs([]string{"a", "c"})
+	require.NoError(t, reports[0].Err)
+	require.Error(t, reports[1].Err)
+	require.Contains(t, reports[1].Err.Error(), "20 entries exceed the maximum of 10")
+
+	require.NoError(t, d.Ingest([]string{"a"}))
+	v, closer, err := d.Get([]byte("a000"))
+	require.NoError(t, err)
+	require.Empty(t, v)
+	require.NoError(t, closer.Close())
+}
+
+func TestIngestCreateOnShared(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem}
+	opts.Experimental.SharedStorage = shared.NewLocalFS(mem, "shared")
+	opts.Experimental.CreateOnShared = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.SetCreatorID(1))
+
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorage.NewFileWritable(f), d.MakeWriterOptions(numLevels-1))
+	require.NoError(t, w.Set([]byte("a"), []byte("a")))
+	require.NoError(t, w.Close())
+	require.NoError(t, d.Ingest([]string{"ext"}))
+
+	// The ingested sstable was copied to shared storage rather than linked.
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[numLevels-1], 1)
+	fileNum := tables[numLevels-1][0].FileNum
+	meta, err := d.objProvider.Lookup(fileTypeTable, fileNum)
+	require.NoError(t, err)
+	require.True(t, meta.IsShared())
+	_, err = mem.Stat(base.MakeFilename(fileTypeTable, fileNum))
+	require.True(t, oserror.IsNotExist(err))
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(v))
+	require.NoError(t, closer.Close())
+	require.NoError(t, d.Close())
+}
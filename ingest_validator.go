@@ -0,0 +1,84 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// IngestValidator inspects the properties of an sstable passed to DB.Ingest
+// (or one of its variants), returning an error if the sstable must not be
+// ingested. Validators are run when the sstables are loaded, before any of
+// them is linked into the DB: if one sstable is rejected, none is ingested.
+// See Options.Experimental.IngestValidators.
+type IngestValidator func(props *sstable.Properties) error
+
+// IngestRejectedError is returned by DB.Ingest when an sstable is rejected by
+// one of the Options.Experimental.IngestValidators.
+type IngestRejectedError struct {
+	// Path is the path of the rejected sstable.
+	Path string
+	// Err is the error returned by the validator.
+	Err error
+}
+
+func (e *IngestRejectedError) Error() string {
+	return fmt.Sprintf("pebble: sstable %s rejected by ingest validation: %s", e.Path, e.Err)
+}
+
+// Unwrap returns the error returned by the validator.
+func (e *IngestRejectedError) Unwrap() error {
+	return e.Err
+}
+
+// ingestValidate runs the validators on the properties of the sstable.
+func ingestValidate(opts *Options, path string, props *sstable.Properties) error {
+	for _, v := range opts.Experimental.IngestValidators {
+		if err := v(props); err != nil {
+			return &IngestRejectedError{Path: path, Err: err}
+		}
+	}
+	return nil
+}
+
+// RequireUserProperties returns an IngestValidator rejecting the sstables
+// which lack any of the given user properties (see
+// sstable.Properties.UserProperties), e.g. those set by a
+// TablePropertyCollector of the producer.
+func RequireUserProperties(keys ...string) IngestValidator {
+	return func(props *sstable.Properties) error {
+		for _, key := range keys {
+			if _, ok := props.UserProperties[key]; !ok {
+				return errors.Errorf("missing user property %q", key)
+			}
+		}
+		return nil
+	}
+}
+
+// MaxIngestEntries returns an IngestValidator rejecting the sstables with more
+// than n entries (point keys, range deletions and range keys).
+func MaxIngestEntries(n uint64) IngestValidator {
+	return func(props *sstable.Properties) error {
+		if entries := props.NumEntries + props.NumRangeKeys(); entries > n {
+			return errors.Errorf("%d entries exceed the maximum of %d", entries, n)
+		}
+		return nil
+	}
+}
+
+// RequireComparer returns an IngestValidator rejecting the sstables which
+// were not written with the Comparer with the given name.
+func RequireComparer(name string) IngestValidator {
+	return func(props *sstable.Properties) error {
+		if props.ComparerName != name {
+			return errors.Errorf("comparer %q does not match %q", props.ComparerName, name)
+		}
+		return nil
+	}
+}
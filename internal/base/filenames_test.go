@@ -86,6 +86,30 @@ func TestFilenameRoundTrip(t *testing.T) {
 	}
 }
 
+// TestVFSFileType checks that the classification of files by the FS wrappers
+// of the vfs package agrees with ParseFilename.
+func TestVFSFileType(t *testing.T) {
+	testCases := map[FileType]vfs.FileType{
+		FileTypeLog:      vfs.FileTypeWAL,
+		FileTypeLock:     vfs.FileTypeOther,
+		FileTypeTable:    vfs.FileTypeTable,
+		FileTypeManifest: vfs.FileTypeManifest,
+		FileTypeCurrent:  vfs.FileTypeOther,
+		FileTypeOptions:  vfs.FileTypeOptions,
+		FileTypeOldTemp:  vfs.FileTypeTemp,
+		FileTypeTemp:     vfs.FileTypeTemp,
+	}
+	for fileType, want := range testCases {
+		fs := vfs.WithMetrics(vfs.NewMem())
+		filename := MakeFilepath(fs, "", fileType, 1)
+		f, err := fs.Create(filename)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		m := fs.Metrics()
+		require.EqualValues(t, 1, m.Ops[want][vfs.MetricsOpCreate].Count, "%s", filename)
+	}
+}
+
 type bufferFataler struct {
 	buf bytes.Buffer
 }
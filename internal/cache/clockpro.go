@@ -76,12 +76,35 @@ func (h Handle) Release() {
 	}
 }
 
+// idState holds the accounting state of an ID within a shard.
+type idState struct {
+	// hits and misses are updated atomically.
+	hits   int64
+	misses int64
+
+	// The remaining fields are protected by shard.mu. size and count only
+	// account for the entries that hold a value (i.e. hot and cold entries).
+	size     int64
+	count    int64
+	reserved int64
+	// quota bounds size+reserved. Zero means no quota.
+	quota int64
+}
+
+// overQuota returns true if adding n bytes to the ID would exceed its quota.
+func (s *idState) overQuota(n int64) bool {
+	return s != nil && s.quota > 0 && s.size+s.reserved+n > s.quota
+}
+
 type shard struct {
 	hits   int64
 	misses int64
 
 	mu sync.RWMutex
 
+	// ids holds the accounting state of the IDs allocated by Cache.NewID.
+	ids map[uint64]*idState
+
 	reservedSize int64
 	maxSize      int64
 	coldTarget   int64
@@ -122,12 +145,19 @@ func (c *shard) Get(id uint64, fileNum base.FileNum, offset uint64) Handle {
 			atomic.StoreInt32(&e.referenced, 1)
 		}
 	}
+	s := c.ids[id]
 	c.mu.RUnlock()
 	if value == nil {
 		atomic.AddInt64(&c.misses, 1)
+		if s != nil {
+			atomic.AddInt64(&s.misses, 1)
+		}
 		return Handle{}
 	}
 	atomic.AddInt64(&c.hits, 1)
+	if s != nil {
+		atomic.AddInt64(&s.hits, 1)
+	}
 	return Handle{value: value}
 }
 
@@ -141,16 +171,18 @@ func (c *shard) Set(id uint64, fileNum base.FileNum, offset uint64, value *Value
 
 	k := key{fileKey{id, fileNum}, offset}
 	e := c.blocks.Get(k)
+	s := c.ids[id]
 
 	switch {
 	case e == nil:
 		// no cache entry? add it
 		e = newEntry(c, k, int64(len(value.buf)))
 		e.setValue(value)
-		if c.metaAdd(k, e) {
+		if !s.overQuota(e.size) && c.metaAdd(k, e) {
 			value.ref.trace("add-cold")
 			c.sizeCold += e.size
 			c.countCold++
+			c.account(k, e.size, 1)
 		} else {
 			value.ref.trace("skip-cold")
 			e.free()
@@ -163,6 +195,7 @@ func (c *shard) Set(id uint64, fileNum base.FileNum, offset uint64, value *Value
 		atomic.StoreInt32(&e.referenced, 1)
 		delta := int64(len(value.buf)) - e.size
 		e.size = int64(len(value.buf))
+		c.account(k, delta, 0)
 		if e.ptype == etHot {
 			value.ref.trace("add-hot")
 			c.sizeHot += delta
@@ -180,6 +213,13 @@ func (c *shard) Set(id uint64, fileNum base.FileNum, offset uint64, value *Value
 		c.metaCheck(e)
 
 		e.size = int64(len(value.buf))
+		if s.overQuota(e.size) {
+			// The block is not added to the cache, so the test page hit must
+			// not grow the cold target.
+			value.ref.trace("skip-hot")
+			e.free()
+			break
+		}
 		c.coldTarget += e.size
 		if c.coldTarget > c.targetSize() {
 			c.coldTarget = c.targetSize()
@@ -192,6 +232,7 @@ func (c *shard) Set(id uint64, fileNum base.FileNum, offset uint64, value *Value
 			value.ref.trace("add-hot")
 			c.sizeHot += e.size
 			c.countHot++
+			c.account(k, e.size, 1)
 		} else {
 			value.ref.trace("skip-hot")
 			e.free()
@@ -282,10 +323,13 @@ func (c *shard) Free() {
 	c.files.free()
 }
 
-func (c *shard) Reserve(n int) {
+func (c *shard) Reserve(id uint64, n int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.reservedSize += int64(n)
+	if s := c.ids[id]; s != nil {
+		s.reserved += int64(n)
+	}
 
 	// Changing c.reservedSize will either increase or decrease
 	// the targetSize. But we want coldTarget to be in the range
@@ -300,6 +344,32 @@ func (c *shard) Reserve(n int) {
 	c.checkConsistency()
 }
 
+// SetMaxSize changes the max size of the shard, evicting entries if the shard
+// is now over capacity.
+func (c *shard) SetMaxSize(size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = size
+
+	// See the comment in Reserve about keeping coldTarget in the range
+	// [0, targetSize].
+	targetSize := c.targetSize()
+	if c.coldTarget > targetSize {
+		c.coldTarget = targetSize
+	}
+
+	c.evict()
+	c.checkConsistency()
+}
+
+// account adjusts the size and count of the ID of the specified key.
+func (c *shard) account(k key, size, count int64) {
+	if s := c.ids[k.id]; s != nil {
+		s.size += size
+		s.count += count
+	}
+}
+
 // Size returns the current space used by the cache.
 func (c *shard) Size() int64 {
 	c.mu.RLock()
@@ -460,9 +530,11 @@ func (c *shard) metaEvict(e *entry) {
 	case etHot:
 		c.sizeHot -= e.size
 		c.countHot--
+		c.account(e.key, -e.size, -1)
 	case etCold:
 		c.sizeCold -= e.size
 		c.countCold--
+		c.account(e.key, -e.size, -1)
 	case etTest:
 		c.sizeTest -= e.size
 		c.countTest--
@@ -503,6 +575,7 @@ func (c *shard) runHandCold(countColdDebug, sizeColdDebug int64) {
 			e.ptype = etTest
 			c.sizeCold -= e.size
 			c.countCold--
+			c.account(e.key, -e.size, -1)
 			c.sizeTest += e.size
 			c.countTest++
 			for c.targetSize() < c.sizeTest && c.handTest != nil {
@@ -582,6 +655,8 @@ type Metrics struct {
 	Hits int64
 	// The number of cache misses.
 	Misses int64
+	// The number of bytes reserved in the cache (see Cache.Reserve).
+	Reserved int64
 }
 
 // Cache implements Pebble's sharded block cache. The Clock-PRO algorithm is
@@ -767,7 +842,18 @@ func (c *Cache) EvictFile(id uint64, fileNum base.FileNum) {
 
 // MaxSize returns the max size of the cache.
 func (c *Cache) MaxSize() int64 {
-	return c.maxSize
+	return atomic.LoadInt64(&c.maxSize)
+}
+
+// SetMaxSize changes the max size of the cache. If the cache is shrunk, blocks
+// are evicted until the cache fits within the new size. The cache may be
+// shared by multiple DBs, all of which observe the new size.
+func (c *Cache) SetMaxSize(size int64) {
+	atomic.StoreInt64(&c.maxSize, size)
+	shardSize := size / int64(len(c.shards))
+	for i := range c.shards {
+		c.shards[i].SetMaxSize(shardSize)
+	}
 }
 
 // Size returns the current space used by the cache.
@@ -802,18 +888,31 @@ func (c *Cache) Free(v *Value) {
 // by N bytes, without actually consuming any memory. The returned closure
 // should be invoked to release the reservation.
 func (c *Cache) Reserve(n int) func() {
+	return c.reserve(0 /* id */, n)
+}
+
+// ReserveForID is like Reserve, but the reservation is also accounted to the
+// specified ID, counting against its quota (see SetQuota).
+func (c *Cache) ReserveForID(id uint64, n int) func() {
+	if id == 0 {
+		panic("pebble: 0 cache ID is invalid")
+	}
+	return c.reserve(id, n)
+}
+
+func (c *Cache) reserve(id uint64, n int) func() {
 	// Round-up the per-shard reservation. Most reservations should be large, so
 	// this probably doesn't matter in practice.
 	shardN := (n + len(c.shards) - 1) / len(c.shards)
 	for i := range c.shards {
-		c.shards[i].Reserve(shardN)
+		c.shards[i].Reserve(id, shardN)
 	}
 	return func() {
 		if shardN == -1 {
 			panic("pebble: cache reservation already released")
 		}
 		for i := range c.shards {
-			c.shards[i].Reserve(-shardN)
+			c.shards[i].Reserve(id, -shardN)
 		}
 		shardN = -1
 	}
@@ -827,6 +926,7 @@ func (c *Cache) Metrics() Metrics {
 		s.mu.RLock()
 		m.Count += int64(s.blocks.Count())
 		m.Size += s.sizeHot + s.sizeCold
+		m.Reserved += s.reservedSize
 		s.mu.RUnlock()
 		m.Hits += atomic.LoadInt64(&s.hits)
 		m.Misses += atomic.LoadInt64(&s.misses)
@@ -834,8 +934,100 @@ func (c *Cache) Metrics() Metrics {
 	return m
 }
 
+// IDMetrics returns the metrics for the blocks and reservations of the
+// specified ID, which must have been returned by NewID and not released. Unlike
+// the Count returned by Metrics, Count only includes the blocks that hold a
+// value.
+func (c *Cache) IDMetrics(id uint64) Metrics {
+	var m Metrics
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.RLock()
+		if s := sh.ids[id]; s != nil {
+			m.Count += s.count
+			m.Size += s.size
+			m.Reserved += s.reserved
+			m.Hits += atomic.LoadInt64(&s.hits)
+			m.Misses += atomic.LoadInt64(&s.misses)
+		}
+		sh.mu.RUnlock()
+	}
+	return m
+}
+
+// ForEachBlock calls fn with the file number and offset of each block cached
+// for the specified ID, hot blocks first. The blocks are collected from each
+// shard before fn is called, so fn may use the cache.
+func (c *Cache) ForEachBlock(id uint64, fn func(fileNum base.FileNum, offset uint64)) {
+	var hot, cold []key
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.RLock()
+		for e, first := sh.handHot, sh.handHot; e != nil; {
+			if e.key.id == id && e.peekValue() != nil {
+				if e.ptype == etHot {
+					hot = append(hot, e.key)
+				} else {
+					cold = append(cold, e.key)
+				}
+			}
+			if e = e.next(); e == first {
+				break
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	for _, k := range append(hot, cold...) {
+		fn(k.fileNum, k.offset)
+	}
+}
+
 // NewID returns a new ID to be used as a namespace for cached file
-// blocks.
+// blocks. The cache keeps track of the usage of each ID (see IDMetrics), which
+// allows a cache shared between multiple Pebble instances to report the usage
+// of each of them. The ID should be released with ReleaseID once it is no
+// longer used.
 func (c *Cache) NewID() uint64 {
-	return atomic.AddUint64(&c.idAlloc, 1)
+	id := atomic.AddUint64(&c.idAlloc, 1)
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		if sh.ids == nil {
+			sh.ids = make(map[uint64]*idState)
+		}
+		sh.ids[id] = &idState{}
+		sh.mu.Unlock()
+	}
+	return id
+}
+
+// ReleaseID stops tracking the usage of the specified ID. Blocks cached for the
+// ID remain in the cache until they are evicted.
+func (c *Cache) ReleaseID(id uint64) {
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		delete(sh.ids, id)
+		sh.mu.Unlock()
+	}
+}
+
+// SetQuota limits the space that the specified ID may use in the cache, for
+// both its blocks and its reservations (see ReserveForID). Blocks of an ID
+// which is over its quota are not added to the cache, so that a Pebble
+// instance sharing the cache with others cannot evict their blocks beyond its
+// quota; its existing blocks remain cached until they are evicted. Like the
+// size of the cache, the quota is split evenly between the cache's shards. A
+// quota of zero removes the limit.
+func (c *Cache) SetQuota(id uint64, quota int64) {
+	// Round-up the per-shard quota, like reservations.
+	shardQuota := (quota + int64(len(c.shards)) - 1) / int64(len(c.shards))
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		if s := sh.ids[id]; s != nil {
+			s.quota = shardQuota
+		}
+		sh.mu.Unlock()
+	}
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"sync"
 	"testing"
@@ -185,6 +186,147 @@ func TestReserve(t *testing.T) {
 	require.EqualValues(t, 4, cache.Size())
 }
 
+func TestSetMaxSize(t *testing.T) {
+	cache := newShards(100, 2)
+	defer cache.Unref()
+
+	for i := uint64(1); i <= 4; i++ {
+		cache.Set(i, 0, 0, testValue(cache, "a", 1)).Release()
+	}
+	require.EqualValues(t, 4, cache.Size())
+	cache.SetMaxSize(2)
+	require.EqualValues(t, 2, cache.MaxSize())
+	require.EqualValues(t, 0, cache.Size())
+	cache.SetMaxSize(100)
+	require.EqualValues(t, 100, cache.MaxSize())
+	for i := uint64(1); i <= 4; i++ {
+		cache.Set(i, 0, 0, testValue(cache, "a", 1)).Release()
+	}
+	require.EqualValues(t, 4, cache.Size())
+}
+
+func TestIDMetrics(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+
+	id1, id2 := cache.NewID(), cache.NewID()
+	for i := uint64(0); i < 3; i++ {
+		cache.Set(id1, 1, i, testValue(cache, "a", 10)).Release()
+	}
+	cache.Set(id2, 1, 0, testValue(cache, "a", 5)).Release()
+	cache.Get(id1, 1, 0).Release()
+	cache.Get(id1, 1, 3).Release()
+	cache.Get(id2, 1, 1).Release()
+	release := cache.ReserveForID(id2, 7)
+
+	require.Equal(t, Metrics{Size: 30, Count: 3, Hits: 1, Misses: 1}, cache.IDMetrics(id1))
+	require.Equal(t, Metrics{Size: 5, Count: 1, Misses: 1, Reserved: 7}, cache.IDMetrics(id2))
+	m := cache.Metrics()
+	require.EqualValues(t, 35, m.Size)
+	require.EqualValues(t, 7, m.Reserved)
+
+	release()
+	cache.EvictFile(id1, 1)
+	require.Equal(t, Metrics{Hits: 1, Misses: 1}, cache.IDMetrics(id1))
+	require.Equal(t, Metrics{Size: 5, Count: 1, Misses: 1}, cache.IDMetrics(id2))
+
+	// Blocks cached under a released ID are no longer accounted.
+	cache.ReleaseID(id2)
+	require.Equal(t, Metrics{}, cache.IDMetrics(id2))
+	cache.Delete(id2, 1, 0)
+	require.EqualValues(t, 0, cache.Size())
+}
+
+func TestForEachBlock(t *testing.T) {
+	cache := newShards(100, 4)
+	defer cache.Unref()
+
+	id1, id2 := cache.NewID(), cache.NewID()
+	for i := uint64(0); i < 3; i++ {
+		cache.Set(id1, 1, i, testValue(cache, "a", 1)).Release()
+	}
+	cache.Set(id1, 2, 5, testValue(cache, "a", 1)).Release()
+	cache.Set(id2, 1, 7, testValue(cache, "a", 1)).Release()
+
+	blocks := func(id uint64) []string {
+		var res []string
+		cache.ForEachBlock(id, func(fileNum base.FileNum, offset uint64) {
+			res = append(res, fmt.Sprintf("%s/%d", fileNum, offset))
+		})
+		sort.Strings(res)
+		return res
+	}
+	require.Equal(t, []string{"000001/0", "000001/1", "000001/2", "000002/5"}, blocks(id1))
+	require.Equal(t, []string{"000001/7"}, blocks(id2))
+
+	cache.EvictFile(id1, 1)
+	require.Equal(t, []string{"000002/5"}, blocks(id1))
+}
+
+func TestQuota(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+
+	id1, id2 := cache.NewID(), cache.NewID()
+	cache.SetQuota(id1, 20)
+	for i := uint64(0); i < 5; i++ {
+		cache.Set(id1, 1, i, testValue(cache, "a", 10)).Release()
+		cache.Set(id2, 1, i, testValue(cache, "a", 10)).Release()
+	}
+	require.EqualValues(t, 20, cache.IDMetrics(id1).Size)
+	require.EqualValues(t, 50, cache.IDMetrics(id2).Size)
+	h := cache.Get(id1, 1, 1)
+	require.NotNil(t, h.Get())
+	h.Release()
+	require.Nil(t, cache.Get(id1, 1, 2).Get())
+
+	// Reservations count against the quota.
+	cache.Delete(id1, 1, 0)
+	release := cache.ReserveForID(id1, 10)
+	cache.Set(id1, 1, 2, testValue(cache, "a", 10)).Release()
+	require.EqualValues(t, 10, cache.IDMetrics(id1).Size)
+	release()
+	cache.Set(id1, 1, 2, testValue(cache, "a", 10)).Release()
+	require.EqualValues(t, 20, cache.IDMetrics(id1).Size)
+
+	// Removing the quota.
+	cache.SetQuota(id1, 0)
+	cache.Set(id1, 1, 3, testValue(cache, "a", 10)).Release()
+	require.EqualValues(t, 30, cache.IDMetrics(id1).Size)
+}
+
+func TestQuotaTestPage(t *testing.T) {
+	cache := newShards(100, 1)
+	defer cache.Unref()
+	sh := &cache.shards[0]
+
+	// Evict a block of id1 from the cold pages, leaving a test page behind:
+	// once the cache is full, the cold hand points to the last added block.
+	id1, id2 := cache.NewID(), cache.NewID()
+	for i := uint64(0); i < 10; i++ {
+		cache.Set(id2, 1, i, testValue(cache, "a", 10)).Release()
+	}
+	cache.Set(id1, 1, 0, testValue(cache, "a", 10)).Release()
+	cache.Set(id2, 1, 10, testValue(cache, "a", 10)).Release()
+	k := key{fileKey{id1, 1}, 0}
+	sh.mu.RLock()
+	e := sh.blocks.Get(k)
+	isTest := e != nil && e.ptype == etTest
+	coldTarget := sh.coldTarget
+	sh.mu.RUnlock()
+	require.True(t, isTest)
+
+	// A block rejected because of the quota of its ID does not grow the cold
+	// target, even though its test page was hit.
+	cache.SetQuota(id1, 5)
+	cache.Set(id1, 1, 0, testValue(cache, "a", 10)).Release()
+	require.Nil(t, cache.Get(id1, 1, 0).Get())
+	sh.mu.RLock()
+	newColdTarget := sh.coldTarget
+	sh.mu.RUnlock()
+	require.Equal(t, coldTarget, newColdTarget)
+}
+
 func TestReserveDoubleRelease(t *testing.T) {
 	cache := newShards(100, 1)
 	defer cache.Unref()
@@ -228,26 +370,40 @@ func TestCacheStressSetExisting(t *testing.T) {
 func BenchmarkCacheGet(b *testing.B) {
 	const size = 100000
 
-	cache := newShards(size, 1)
-	defer cache.Unref()
+	// The usage of an ID returned by NewID is tracked, which adds a lookup of
+	// its state and updates of its hit and miss counters to each Get.
+	for _, tracked := range []bool{false, true} {
+		b.Run(fmt.Sprintf("tracked=%t", tracked), func(b *testing.B) {
+			cache := newShards(size, 1)
+			defer cache.Unref()
+
+			id := uint64(1)
+			if tracked {
+				id = cache.NewID()
+				// Other IDs sharing the cache populate the map of tracked IDs.
+				for i := 0; i < 10; i++ {
+					cache.NewID()
+				}
+			}
+			for i := 0; i < size; i++ {
+				v := testValue(cache, "a", 1)
+				cache.Set(id, 0, uint64(i), v).Release()
+			}
 
-	for i := 0; i < size; i++ {
-		v := testValue(cache, "a", 1)
-		cache.Set(1, 0, uint64(i), v).Release()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+
+				for pb.Next() {
+					h := cache.Get(id, 0, uint64(rng.Intn(size)))
+					if h.Get() == nil {
+						b.Fatal("failed to lookup value")
+					}
+					h.Release()
+				}
+			})
+		})
 	}
-
-	b.ResetTimer()
-	b.RunParallel(func(pb *testing.PB) {
-		rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
-
-		for pb.Next() {
-			h := cache.Get(1, 0, uint64(rng.Intn(size)))
-			if h.Get() == nil {
-				b.Fatal("failed to lookup value")
-			}
-			h.Release()
-		}
-	})
 }
 
 func TestReserveColdTarget(t *testing.T) {
@@ -48,6 +48,8 @@ type TableStats struct {
 	NumEntries uint64
 	// The number of point and range deletion entries in the table.
 	NumDeletions uint64
+	// The number of point deletion entries in the table.
+	NumPointDeletions uint64
 	// NumRangeKeySets is the total number of range key sets in the table.
 	NumRangeKeySets uint64
 	// Estimate of the total disk space that may be dropped by this table's
@@ -66,6 +68,13 @@ type TableStats struct {
 	RangeDeletionsBytesEstimate uint64
 	// Total size of value blocks and value index block.
 	ValueBlocksSize uint64
+	// UncompressedSize is the size of the keys and values of the table
+	// before their encoding and compression in blocks, from the raw key and
+	// value size properties.
+	UncompressedSize uint64
+	// TenantBytes is the estimated size of the data of each tenant in the
+	// table, keyed by tenant ID, when tenant quotas are configured.
+	TenantBytes map[string]uint64
 }
 
 // boundType represents the type of key (point or range) present as the smallest
@@ -179,6 +188,9 @@ type FileMetadata struct {
 	Largest  InternalKey
 	// Stats describe table statistics. Protected by DB.mu.
 	Stats TableStats
+	// Virtual is set for a virtual table, and nil for a physical table. See
+	// VirtualTable.
+	Virtual *VirtualTable
 
 	SubLevel         int
 	L0Index          int
@@ -228,6 +240,32 @@ type FileMetadata struct {
 	boundTypeSmallest, boundTypeLargest boundType
 }
 
+// VirtualTable holds the state specific to a virtual table. A virtual table
+// contains only the keys of its sstable (the sstable with the same file number)
+// which are within the table bounds: the user keys in [Smallest.UserKey,
+// UpperBound). The keys of the sstable outside of the bounds are ignored, and
+// range deletions and range keys are truncated to the bounds. The Size of a
+// virtual table is an estimate of the size of the part of the sstable within
+// the bounds.
+//
+// Virtual tables are created by ingesting a part of an sstable, so that the
+// rest of the sstable does not need to be rewritten.
+type VirtualTable struct {
+	// UpperBound is the exclusive upper bound on the user keys of the table.
+	UpperBound []byte
+	// BackingSize is the size of the sstable backing the table, in bytes.
+	BackingSize uint64
+}
+
+// BackingSize returns the size of the sstable backing the table, which is the
+// size of the table, unless the table is virtual.
+func (m *FileMetadata) BackingSize() uint64 {
+	if m.Virtual != nil {
+		return m.Virtual.BackingSize
+	}
+	return m.Size
+}
+
 // SetCompactionState transitions this file's compaction state to the given
 // state. Protected by DB.mu.
 func (m *FileMetadata) SetCompactionState(to CompactionState) {
@@ -584,6 +622,23 @@ func (m *FileMetadata) Validate(cmp Compare, formatKey base.FormatKey) error {
 		}
 	}
 
+	// Virtual table validation.
+
+	if m.Virtual != nil {
+		if c := cmp(m.Largest.UserKey, m.Virtual.UpperBound); c > 0 ||
+			(c == 0 && !m.Largest.IsExclusiveSentinel()) {
+			return base.CorruptionErrorf(
+				"file %s has bounds exceeding its virtual upper bound: %s vs %s",
+				errors.Safe(m.FileNum), m.Largest.Pretty(formatKey),
+				formatKey(m.Virtual.UpperBound),
+			)
+		}
+		if m.Size > m.Virtual.BackingSize {
+			return base.CorruptionErrorf("file %s is larger than its backing sstable: %d vs %d",
+				errors.Safe(m.FileNum), m.Size, m.Virtual.BackingSize)
+		}
+	}
+
 	return nil
 }
 
@@ -47,6 +47,9 @@ const (
 	tagColumnFamilyAdd  = 201
 	tagColumnFamilyDrop = 202
 	tagMaxColumnFamily  = 203
+	// tagDBID is the tag of RocksDB's kDbId, which is a forward compatible
+	// tag that RocksDB readers ignore if they don't understand it.
+	tagDBID = 1<<13 + 1
 
 	// Pebble tags.
 	tagNewFile5 = 104 // Range keys.
@@ -56,6 +59,7 @@ const (
 	customTagNeedsCompaction   = 2
 	customTagCreationTime      = 6
 	customTagPathID            = 65
+	customTagVirtual           = 66
 	customTagNonSafeIgnoreMask = 1 << 6
 )
 
@@ -82,6 +86,13 @@ type VersionEdit struct {
 	// specified at Open matches the comparer that was previously used.
 	ComparerName string
 
+	// DBID is the unique ID of the DB, generated when it is created. It is set
+	// in the first VersionEdit in a manifest, and in the VersionEdit which
+	// records the ID of a DB which had none.
+	//
+	// This is an optional field, and the empty string represents it is not set.
+	DBID string
+
 	// MinUnflushedLogNum is the smallest WAL log file number corresponding to
 	// mutations that have not been flushed to an sstable.
 	//
@@ -133,6 +144,13 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			v.ComparerName = string(s)
 
+		case tagDBID:
+			s, err := d.readBytes()
+			if err != nil {
+				return err
+			}
+			v.DBID = string(s)
+
 		case tagLogNumber:
 			n, err := d.readFileNum()
 			if err != nil {
@@ -268,6 +286,7 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 			}
 			var markedForCompaction bool
 			var creationTime uint64
+			var virtual *VirtualTable
 			if tag == tagNewFile4 || tag == tagNewFile5 {
 				for {
 					customTag, err := d.readUvarint()
@@ -298,6 +317,16 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 					case customTagPathID:
 						return base.CorruptionErrorf("new-file4: path-id field not supported")
 
+					case customTagVirtual:
+						backingSize, n := binary.Uvarint(field)
+						if n <= 0 {
+							return base.CorruptionErrorf("new-file4: invalid virtual table field")
+						}
+						virtual = &VirtualTable{
+							UpperBound:  field[n:],
+							BackingSize: backingSize,
+						}
+
 					default:
 						if (customTag & customTagNonSafeIgnoreMask) != 0 {
 							return base.CorruptionErrorf("new-file4: custom field not supported: %d", customTag)
@@ -312,6 +341,7 @@ func (v *VersionEdit) Decode(r io.Reader) error {
 				SmallestSeqNum:      smallestSeqNum,
 				LargestSeqNum:       largestSeqNum,
 				MarkedForCompaction: markedForCompaction,
+				Virtual:             virtual,
 			}
 			if tag != tagNewFile5 { // no range keys present
 				m.SmallestPointKey = base.DecodeInternalKey(smallestPointKey)
@@ -373,6 +403,10 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(tagComparator)
 		e.writeString(v.ComparerName)
 	}
+	if v.DBID != "" {
+		e.writeUvarint(tagDBID)
+		e.writeString(v.DBID)
+	}
 	if v.MinUnflushedLogNum != 0 {
 		e.writeUvarint(tagLogNumber)
 		e.writeUvarint(uint64(v.MinUnflushedLogNum))
@@ -398,7 +432,7 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 		e.writeUvarint(uint64(x.FileNum))
 	}
 	for _, x := range v.NewFiles {
-		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0
+		customFields := x.Meta.MarkedForCompaction || x.Meta.CreationTime != 0 || x.Meta.Virtual != nil
 		var tag uint64
 		switch {
 		case x.Meta.HasRangeKeys:
@@ -451,6 +485,14 @@ func (v *VersionEdit) Encode(w io.Writer) error {
 				e.writeUvarint(customTagNeedsCompaction)
 				e.writeBytes([]byte{1})
 			}
+			if x.Meta.Virtual != nil {
+				// The virtual table field must not be ignored by versions which
+				// don't support virtual tables, as they would read the keys of the
+				// sstable outside of the table bounds.
+				e.writeUvarint(customTagVirtual)
+				buf := binary.AppendUvarint(nil, x.Meta.Virtual.BackingSize)
+				e.writeBytes(append(buf, x.Meta.Virtual.UpperBound...))
+			}
 			e.writeUvarint(customTagTerminate)
 		}
 	}
@@ -753,7 +795,7 @@ func (b *BulkVersionEdit) Apply(
 		// level.
 
 		for _, f := range deletedFilesMap {
-			addZombie(f.FileNum, f.Size)
+			addZombie(f.FileNum, f.BackingSize())
 			if obsolete := v.Levels[level].tree.Delete(f); obsolete {
 				// Deleting a file from the B-Tree may decrement its
 				// reference count. However, because we cloned the
@@ -89,12 +89,29 @@ func TestVersionEditRoundTrip(t *testing.T) {
 		base.MakeExclusiveSentinelKey(base.InternalKeyKindRangeKeySet, []byte("z")),
 	)
 
+	m5 := (&FileMetadata{
+		FileNum:        810,
+		Size:           4000,
+		CreationTime:   810070,
+		SmallestSeqNum: 12,
+		LargestSeqNum:  12,
+		Virtual: &VirtualTable{
+			UpperBound:  []byte("f"),
+			BackingSize: 8100,
+		},
+	}).ExtendPointKeyBounds(
+		cmp,
+		base.MakeInternalKey([]byte("c"), 12, base.InternalKeyKindSet),
+		base.MakeInternalKey([]byte("e"), 12, base.InternalKeyKindSet),
+	)
+
 	testCases := []VersionEdit{
 		// An empty version edit.
 		{},
 		// A complete version edit.
 		{
 			ComparerName:       "11",
+			DBID:               "0f8f8d3a-1b3c-4a4e-9c1f-5d2e7b6a9c01",
 			MinUnflushedLogNum: 22,
 			ObsoletePrevLogNum: 33,
 			NextFileNum:        44,
@@ -126,6 +143,10 @@ func TestVersionEditRoundTrip(t *testing.T) {
 					Level: 6,
 					Meta:  m4,
 				},
+				{
+					Level: 6,
+					Meta:  m5,
+				},
 			},
 		},
 	}
@@ -1161,7 +1161,7 @@ func (o *dbRatchetFormatMajorVersionOp) run(t *test, h historyRecorder) {
 }
 
 func (o *dbRatchetFormatMajorVersionOp) String() string {
-	return fmt.Sprintf("db.RatchetFormatMajorVersion(%s)", o.vers)
+	return fmt.Sprintf("db.RatchetFormatMajorVersion(%d)", o.vers)
 }
 func (o *dbRatchetFormatMajorVersionOp) receiver() objID      { return dbObjID }
 func (o *dbRatchetFormatMajorVersionOp) syncObjs() objIDSlice { return nil }
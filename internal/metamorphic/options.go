@@ -18,6 +18,7 @@ import (
 	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/ribbon"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"golang.org/x/exp/rand"
@@ -368,14 +369,16 @@ func randomOptions(rng *rand.Rand) *testOptions {
 	lopts.BlockSizeThreshold = 50 + rng.Intn(50)   // 50 - 100
 	lopts.IndexBlockSize = 1 << uint(rng.Intn(24)) // 1 - 16MB
 	lopts.TargetFileSize = 1 << uint(rng.Intn(28)) // 1 - 256MB
-	// We either use no bloom filter, the default filter, or a filter with
-	// randomized bits-per-key setting.
-	switch rng.Intn(3) {
+	// We either use no filter, the default bloom filter, a bloom filter with
+	// randomized bits-per-key setting or a ribbon filter.
+	switch rng.Intn(4) {
 	case 0:
 	case 1:
 		lopts.FilterPolicy = bloom.FilterPolicy(10)
-	default:
+	case 2:
 		lopts.FilterPolicy = newTestingFilterPolicy(1 << rng.Intn(5))
+	default:
+		lopts.FilterPolicy = ribbon.FilterPolicy(7)
 	}
 	opts.Levels = []pebble.LevelOptions{lopts}
 	opts.Experimental.PointTombstoneWeight = 1 + 10*rng.Float64() // 1 - 10
@@ -496,6 +499,8 @@ func filterPolicyFromName(name string) (pebble.FilterPolicy, error) {
 		return nil, nil
 	case "rocksdb.BuiltinBloomFilter":
 		return bloom.FilterPolicy(10), nil
+	case "pebble.RibbonFilter":
+		return ribbon.FilterPolicy(7), nil
 	}
 	var bitsPerKey int
 	if _, err := fmt.Sscanf(name, testingFilterPolicyFmt, &bitsPerKey); err != nil {
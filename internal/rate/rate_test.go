@@ -132,6 +132,25 @@ func TestLimiterJumpBackwards(t *testing.T) {
 	})
 }
 
+func TestSetBurst(t *testing.T) {
+	lim := NewLimiter(10, 1)
+	run(t, lim, []allow{
+		{t0, 1, true},
+		{t0, 1, false},
+		{t1, 2, false}, // burst size is 1, so n=2 always fails
+	})
+	lim.SetBurstAt(t1, 3)
+	if b := lim.Burst(); b != 3 {
+		t.Fatalf("Burst() = %d want 3", b)
+	}
+	run(t, lim, []allow{
+		{t1, 1, true},
+		{t1, 1, false},
+		{t9, 3, true}, // tokens accumulated up to the new burst size
+		{t9, 1, false},
+	})
+}
+
 func TestSimultaneousRequests(t *testing.T) {
 	const (
 		limit       = 1
@@ -210,6 +210,11 @@ type Iterator struct {
 	// For use in LazyValue.Value.
 	lazyValueBuf []byte
 	valueCloser  io.Closer
+	// bufAlloc allocates keyBuf, valueBuf, lazyValueBuf, boundsBuf and
+	// prefixOrFullSeekKey when they need to grow, and receives them when the
+	// Iterator is closed unless they are kept for reuse in the iterAlloc. A nil
+	// bufAlloc means the default buffer pool.
+	bufAlloc BufferAllocator
 	// boundsBuf holds two buffers used to store the lower and upper bounds.
 	// Whenever the Iterator's bounds change, the new bounds are copied into
 	// boundsBuf[boundsBufIdx]. The two bounds share a slice to reduce
@@ -552,7 +557,7 @@ func (i *Iterator) findNextEntry(limit []byte) {
 		switch key.Kind() {
 		case InternalKeyKindRangeKeySet:
 			// Save the current key.
-			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+			i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, key.UserKey)
 			i.key = i.keyBuf
 			i.value = LazyValue{}
 			// There may also be a live point key at this userkey that we have
@@ -574,7 +579,7 @@ func (i *Iterator) findNextEntry(limit []byte) {
 			continue
 
 		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
-			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+			i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, key.UserKey)
 			i.key = i.keyBuf
 			i.value = i.iterValue
 			i.iterValidityState = IterValid
@@ -702,7 +707,7 @@ func (i *Iterator) nextUserKey() {
 	trailer := i.iterKey.Trailer
 	done := i.iterKey.Trailer <= base.InternalKeyZeroSeqnumMaxTrailer
 	if i.iterValidityState != IterValid {
-		i.keyBuf = append(i.keyBuf[:0], i.iterKey.UserKey...)
+		i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, i.iterKey.UserKey)
 		i.key = i.keyBuf
 	}
 	for {
@@ -773,7 +778,11 @@ func (i *Iterator) maybeSampleRead() {
 func (i *Iterator) sampleRead() {
 	var topFile *manifest.FileMetadata
 	topLevel, numOverlappingLevels := numLevels, 0
-	if mi, ok := i.iter.(*mergingIter); ok {
+	iter := i.iter
+	if c, ok := iter.(*keyOrderCheckingIter); ok {
+		iter = c.iter
+	}
+	if mi, ok := iter.(*mergingIter); ok {
 		if len(mi.levels) > 1 {
 			mi.ForEachLevelIter(func(li *levelIter) bool {
 				l := manifest.LevelToInt(li.level)
@@ -909,7 +918,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			// must've already iterated over it.
 			// This is the final entry at this user key, so we may return
 			i.rangeKey.rangeKeyOnly = i.iterValidityState != IterValid
-			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+			i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, key.UserKey)
 			i.key = i.keyBuf
 			i.iterValidityState = IterValid
 			i.saveRangeKey()
@@ -953,13 +962,14 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 			continue
 
 		case InternalKeyKindSet, InternalKeyKindSetWithDelete:
-			i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+			i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, key.UserKey)
 			i.key = i.keyBuf
 			// iterValue is owned by i.iter and could change after the Prev()
 			// call, so use valueBuf instead. Note that valueBuf is only used
 			// in this one instance; everywhere else (eg. in findNextEntry),
 			// we just point i.value to the unsafe i.iter-owned value buffer.
-			i.value, i.valueBuf = i.iterValue.Clone(i.valueBuf[:0], &i.fetcher)
+			i.valueBuf = growBuffer(i.bufAlloc, i.valueBuf, len(i.iterValue.ValueOrHandle))
+			i.value, i.valueBuf = i.iterValue.Clone(i.valueBuf, &i.fetcher)
 			i.saveRangeKey()
 			i.iterValidityState = IterValid
 			i.iterKey, i.iterValue = i.iter.Prev()
@@ -969,7 +979,7 @@ func (i *Iterator) findPrevEntry(limit []byte) {
 
 		case InternalKeyKindMerge:
 			if i.iterValidityState == IterExhausted {
-				i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+				i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, key.UserKey)
 				i.key = i.keyBuf
 				i.saveRangeKey()
 				var iterValue []byte
@@ -1058,7 +1068,7 @@ func (i *Iterator) prevUserKey() {
 	if i.iterValidityState != IterValid {
 		// If we're going to compare against the prev key, we need to save the
 		// current key.
-		i.keyBuf = append(i.keyBuf[:0], i.iterKey.UserKey...)
+		i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, i.iterKey.UserKey)
 		i.key = i.keyBuf
 	}
 	for {
@@ -1075,7 +1085,7 @@ func (i *Iterator) prevUserKey() {
 
 func (i *Iterator) mergeNext(key InternalKey, valueMerger ValueMerger) {
 	// Save the current key.
-	i.keyBuf = append(i.keyBuf[:0], key.UserKey...)
+	i.keyBuf = copyToBuffer(i.bufAlloc, i.keyBuf, key.UserKey)
 	i.key = i.keyBuf
 
 	// Loop looking for older values for this key and merging them.
@@ -1261,7 +1271,7 @@ func (i *Iterator) SeekGEWithLimit(key []byte, limit []byte) IterValidityState {
 	i.maybeSampleRead()
 	if i.Error() == nil {
 		// Prepare state for a future noop optimization.
-		i.prefixOrFullSeekKey = append(i.prefixOrFullSeekKey[:0], key...)
+		i.prefixOrFullSeekKey = copyToBuffer(i.bufAlloc, i.prefixOrFullSeekKey, key)
 		i.lastPositioningOp = seekGELastPositioningOp
 	}
 	return i.iterValidityState
@@ -1386,11 +1396,7 @@ func (i *Iterator) SeekPrefixGE(key []byte) bool {
 	}
 	// Make a copy of the prefix so that modifications to the key after
 	// SeekPrefixGE returns does not affect the stored prefix.
-	if cap(i.prefixOrFullSeekKey) < prefixLen {
-		i.prefixOrFullSeekKey = make([]byte, prefixLen)
-	} else {
-		i.prefixOrFullSeekKey = i.prefixOrFullSeekKey[:prefixLen]
-	}
+	i.prefixOrFullSeekKey = growBuffer(i.bufAlloc, i.prefixOrFullSeekKey, prefixLen)[:prefixLen]
 	i.hasPrefix = true
 	copy(i.prefixOrFullSeekKey, keyPrefix)
 
@@ -1515,7 +1521,7 @@ func (i *Iterator) SeekLTWithLimit(key []byte, limit []byte) IterValidityState {
 	i.maybeSampleRead()
 	if i.Error() == nil && i.batch == nil {
 		// Prepare state for a future noop optimization.
-		i.prefixOrFullSeekKey = append(i.prefixOrFullSeekKey[:0], key...)
+		i.prefixOrFullSeekKey = copyToBuffer(i.bufAlloc, i.prefixOrFullSeekKey, key)
 		i.lastPositioningOp = seekLTLastPositioningOp
 	}
 	return i.iterValidityState
@@ -2121,6 +2127,7 @@ func (i *Iterator) Value() []byte {
 // ValueAndErr returns the value, and any error encountered in extracting the value.
 // REQUIRES: i.Error()==nil and HasPointAndRange() returns true for hasPoint.
 func (i *Iterator) ValueAndErr() ([]byte, error) {
+	i.maybeGrowLazyValueBuf()
 	val, callerOwned, err := i.value.Value(i.lazyValueBuf)
 	if err != nil {
 		i.err = err
@@ -2131,6 +2138,18 @@ func (i *Iterator) ValueAndErr() ([]byte, error) {
 	return val, err
 }
 
+// maybeGrowLazyValueBuf ensures that lazyValueBuf can hold the current value
+// if it needs to be fetched, so that the fetched value does not need to be
+// allocated.
+func (i *Iterator) maybeGrowLazyValueBuf() {
+	if i.value.Fetcher == nil {
+		return
+	}
+	if n := i.value.Len(); cap(i.lazyValueBuf) < n {
+		i.lazyValueBuf = growBuffer(i.bufAlloc, i.lazyValueBuf, n)
+	}
+}
+
 // LazyValue returns the LazyValue. Only for advanced use cases.
 // REQUIRES: i.Error()==nil and HasPointAndRange() returns true for hasPoint.
 func (i *Iterator) LazyValue() LazyValue {
@@ -2168,6 +2187,16 @@ const maxKeyBufCacheSize = 4 << 10 // 4 KB
 // It is not valid to call any method, including Close, after the iterator
 // has been closed.
 func (i *Iterator) Close() error {
+	alloc, err := i.close()
+	if alloc != nil {
+		iterAllocPool.Put(alloc)
+	}
+	return err
+}
+
+// close closes the iterator, and returns its allocation, if any, reset for
+// reuse by another iterator (see DB.NewIterReuse).
+func (i *Iterator) close() (*iterAlloc, error) {
 	// Close the child iterator before releasing the readState because when the
 	// readState is released sstables referenced by the readState may be deleted
 	// which will fail on Windows if the sstables are still open by the child
@@ -2236,34 +2265,49 @@ func (i *Iterator) Close() error {
 		iterRangeKeyStateAllocPool.Put(i.rangeKey)
 		i.rangeKey = nil
 	}
+	// The value buffers are not cached with the iterator allocation, but
+	// returned to the buffer allocator.
+	freeBuffer(i.bufAlloc, i.valueBuf)
+	freeBuffer(i.bufAlloc, i.lazyValueBuf)
 	if alloc := i.alloc; alloc != nil {
 		// Avoid caching the key buf if it is overly large. The constant is fairly
 		// arbitrary.
 		if cap(i.keyBuf) >= maxKeyBufCacheSize {
+			freeBuffer(i.bufAlloc, i.keyBuf)
 			alloc.keyBuf = nil
 		} else {
 			alloc.keyBuf = i.keyBuf
 		}
 		if cap(i.prefixOrFullSeekKey) >= maxKeyBufCacheSize {
+			freeBuffer(i.bufAlloc, i.prefixOrFullSeekKey)
 			alloc.prefixOrFullSeekKey = nil
 		} else {
 			alloc.prefixOrFullSeekKey = i.prefixOrFullSeekKey
 		}
 		for j := range i.boundsBuf {
 			if cap(i.boundsBuf[j]) >= maxKeyBufCacheSize {
+				freeBuffer(i.bufAlloc, i.boundsBuf[j])
 				alloc.boundsBuf[j] = nil
 			} else {
 				alloc.boundsBuf[j] = i.boundsBuf[j]
 			}
 		}
+		// Retain the merging iterator's heap, which is otherwise allocated
+		// anew by every iterator.
+		heapItems := alloc.merging.heap.items
+		for j := range heapItems {
+			heapItems[j] = nil
+		}
 		*alloc = iterAlloc{
 			keyBuf:              alloc.keyBuf,
 			boundsBuf:           alloc.boundsBuf,
 			prefixOrFullSeekKey: alloc.prefixOrFullSeekKey,
 		}
-		iterAllocPool.Put(alloc)
+		alloc.merging.heap.items = heapItems[:0]
+		return alloc, err
 	} else if alloc := i.getIterAlloc; alloc != nil {
 		if cap(i.keyBuf) >= maxKeyBufCacheSize {
+			freeBuffer(i.bufAlloc, i.keyBuf)
 			alloc.keyBuf = nil
 		} else {
 			alloc.keyBuf = i.keyBuf
@@ -2273,7 +2317,7 @@ func (i *Iterator) Close() error {
 		}
 		getIterAllocPool.Put(alloc)
 	}
-	return err
+	return nil, err
 }
 
 // SetBounds sets the lower and upper bounds for the iterator. Once SetBounds
@@ -2321,6 +2365,44 @@ func (i *Iterator) SetBounds(lower, upper []byte) {
 	i.invalidate()
 }
 
+// PrefetchRange schedules the background read of the blocks of the sstables
+// which may contain keys in the range [start, end) into the block cache, in
+// order to hide the latency of upcoming accesses to the range (e.g. of reads
+// from shared storage). The range is clipped to the bounds of the iterator; a
+// nil start or end leaves the range unbounded on that side, but the clipped
+// range must have an end. Only the sstables of the iterator's view of the LSM
+// are read. The call does not block, and does not alter the position of the
+// iterator.
+//
+// PrefetchRange is a hint: it is a no-op for iterators which do not read
+// sstables of a DB, and the errors of the background reads are only logged.
+func (i *Iterator) PrefetchRange(start, end []byte) {
+	if i.readState == nil || i.readState.db == nil {
+		return
+	}
+	if i.opts.LowerBound != nil && (start == nil || i.cmp(start, i.opts.LowerBound) < 0) {
+		start = i.opts.LowerBound
+	}
+	if i.opts.UpperBound != nil && (end == nil || i.cmp(end, i.opts.UpperBound) > 0) {
+		end = i.opts.UpperBound
+	}
+	if end == nil || (start != nil && i.cmp(start, end) >= 0) {
+		return
+	}
+
+	d := i.readState.db
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed.Load() != nil {
+		return
+	}
+	d.mu.cacheWarmup.prefetches++
+	i.readState.ref()
+	// The range is copied, as the background reads outlive the call.
+	start, end = append([]byte(nil), start...), append([]byte(nil), end...)
+	go d.prefetchRange(i.readState, start, end)
+}
+
 // Initialization and changing of the bounds must call processBounds.
 // processBounds saves the bounds and computes derived state from those
 // bounds.
@@ -2329,7 +2411,7 @@ func (i *Iterator) processBounds(lower, upper []byte) {
 	// overwrite the current bounds, because some internal iterators compare old
 	// and new bounds for optimizations.
 
-	buf := i.boundsBuf[i.boundsBufIdx][:0]
+	buf := growBuffer(i.bufAlloc, i.boundsBuf[i.boundsBufIdx], len(lower)+len(upper))
 	if lower != nil {
 		buf = append(buf, lower...)
 		i.opts.LowerBound = buf
@@ -2407,6 +2489,7 @@ func (i *Iterator) SetOptions(o *IterOptions) {
 	// If either options specify block property filters for an iterator stack,
 	// reconstruct it.
 	if i.pointIter != nil && (closeBoth || len(o.PointKeyFilters) > 0 || len(i.opts.PointKeyFilters) > 0 ||
+		o.TimeRange.isSet() || i.opts.TimeRange.isSet() ||
 		o.RangeKeyMasking.Filter != nil || i.opts.RangeKeyMasking.Filter != nil) {
 		i.err = firstError(i.err, i.pointIter.Close())
 		i.pointIter = nil
@@ -2665,6 +2748,7 @@ func (i *Iterator) CloneWithContext(ctx context.Context, opts CloneOptions) (*It
 		keyBuf:              buf.keyBuf,
 		prefixOrFullSeekKey: buf.prefixOrFullSeekKey,
 		boundsBuf:           buf.boundsBuf,
+		bufAlloc:            i.bufAlloc,
 		batch:               i.batch,
 		batchSeqNum:         i.batchSeqNum,
 		newIters:            i.newIters,
@@ -5,8 +5,10 @@
 package pebble_test
 
 import (
+	"bytes"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/vfs"
@@ -122,3 +124,99 @@ func ExampleIterator_SeekGE() {
 	// hello world
 	// world
 }
+
+func ExampleIterator_NextWithLimit() {
+	db, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem()})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys := []string{"apple", "banana", "cherry", "grape", "kiwi", "lemon", "plum"}
+	for _, key := range keys {
+		if err := db.Set([]byte(key), nil, pebble.Sync); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Scan adjacent shards with a single iterator, using the start of the next
+	// shard as the limit instead of resetting the iterator bounds. Limits are
+	// best-effort, so keys at or beyond the limit are also checked for.
+	shards := []string{"a", "c", "l", "z"}
+	iter := db.NewIter(nil)
+	for i := 0; i+1 < len(shards); i++ {
+		limit := []byte(shards[i+1])
+		var shardKeys []string
+		state := iter.SeekGEWithLimit([]byte(shards[i]), limit)
+		for ; state == pebble.IterValid && bytes.Compare(iter.Key(), limit) < 0; state = iter.NextWithLimit(limit) {
+			shardKeys = append(shardKeys, string(iter.Key()))
+		}
+		fmt.Printf("[%s,%s): %s\n", shards[i], limit, strings.Join(shardKeys, " "))
+	}
+	if err := iter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// [a,c): apple banana
+	// [c,l): cherry grape kiwi
+	// [l,z): lemon plum
+}
+
+func ExampleIterator_rangeKeys() {
+	// Range keys require a Comparer with a Split function. Here keys have no
+	// suffix, and the suffixes of range keys
+	// are ordered bytewise by Comparer.Compare.
+	comparer := *pebble.DefaultComparer
+	comparer.Split = func(a []byte) int { return len(a) }
+	db, err := pebble.Open("", &pebble.Options{
+		Comparer:           &comparer,
+		FS:                 vfs.NewMem(),
+		FormatMajorVersion: pebble.FormatNewest,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.Set([]byte("b"), []byte("point"), pebble.Sync); err != nil {
+		log.Fatal(err)
+	}
+	b := db.NewBatch()
+	_ = b.RangeKeySet([]byte("a"), []byte("e"), []byte("@5"), []byte("v5"), nil)
+	_ = b.RangeKeySet([]byte("c"), []byte("f"), []byte("@7"), []byte("v7"), nil)
+	if err := b.Commit(pebble.Sync); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.RangeKeyUnset([]byte("d"), []byte("e"), []byte("@5"), pebble.Sync); err != nil {
+		log.Fatal(err)
+	}
+
+	iter := db.NewIter(&pebble.IterOptions{KeyTypes: pebble.IterKeyTypePointsAndRanges})
+	for iter.First(); iter.Valid(); iter.Next() {
+		hasPoint, hasRange := iter.HasPointAndRange()
+		fmt.Printf("%s:", iter.Key())
+		if hasPoint {
+			fmt.Printf(" point=%s", iter.Value())
+		}
+		if hasRange && iter.RangeKeyChanged() {
+			start, end := iter.RangeBounds()
+			fmt.Printf(" range=[%s,%s)", start, end)
+			for _, rk := range iter.RangeKeys() {
+				fmt.Printf(" %s=%s", rk.Suffix, rk.Value)
+			}
+		}
+		fmt.Println()
+	}
+	if err := iter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// a: range=[a,c) @5=v5
+	// b: point=point
+	// c: range=[c,d) @5=v5 @7=v7
+	// d: range=[d,f) @7=v7
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// keyOrderCheckingIter wraps an internal iterator, checking that the keys it
+// returns are consistently ordered by the Comparer: each key must follow the
+// previous one in the direction of iteration, the comparison of two keys must
+// be antisymmetric, and the keys returned by seeks must be on the correct side
+// of the seek key. A violation, which reveals a bug in the Comparer or keys
+// written with another Comparer, makes the iterator fail with an error
+// reporting the offending keys. See Options.DebugCheckKeyOrder.
+type keyOrderCheckingIter struct {
+	iter     internalIterator
+	comparer *Comparer
+	// prev is a copy of the key at which the iterator is positioned, if
+	// positioned is true.
+	prev       InternalKey
+	prevBuf    []byte
+	positioned bool
+	err        error
+}
+
+var _ internalIterator = (*keyOrderCheckingIter)(nil)
+
+func newKeyOrderCheckingIter(iter internalIterator, comparer *Comparer) *keyOrderCheckingIter {
+	return &keyOrderCheckingIter{iter: iter, comparer: comparer}
+}
+
+func (i *keyOrderCheckingIter) errorf(format string, args ...interface{}) {
+	args = append([]interface{}{errors.Safe(i.comparer.Name)}, args...)
+	i.err = errors.Errorf("pebble: keys inconsistently ordered by comparer %q: "+format, args...)
+}
+
+// checkSeek checks the key returned by a seek: its user key must be greater
+// than or equal to the seek key if dir is +1, or less than the seek key if dir
+// is -1.
+func (i *keyOrderCheckingIter) checkSeek(
+	op string, seekKey []byte, dir int, key *InternalKey, value base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	i.positioned = false
+	if i.err != nil || key == nil {
+		return nil, base.LazyValue{}
+	}
+	c := i.comparer.Compare(key.UserKey, seekKey)
+	if (dir > 0 && c < 0) || (dir < 0 && c >= 0) {
+		i.errorf("%s(%s) returned %s", op, i.comparer.FormatKey(seekKey), key.Pretty(i.comparer.FormatKey))
+		return nil, base.LazyValue{}
+	}
+	return i.check(op, 0, key, value)
+}
+
+// check checks the key returned by a positioning operation, where dir is +1
+// (resp. -1) if the key must follow (resp. precede) the previous key, or 0 if
+// the operation does not step from the previous key.
+func (i *keyOrderCheckingIter) check(
+	op string, dir int, key *InternalKey, value base.LazyValue,
+) (*InternalKey, base.LazyValue) {
+	if i.err != nil || key == nil {
+		i.positioned = false
+		return nil, base.LazyValue{}
+	}
+	if i.positioned && dir != 0 {
+		cmp := i.comparer.Compare
+		c := base.InternalCompare(cmp, i.prev, *key)
+		if (dir > 0 && c >= 0) || (dir < 0 && c <= 0) {
+			i.errorf("%s returned %s after %s", op,
+				key.Pretty(i.comparer.FormatKey), i.prev.Pretty(i.comparer.FormatKey))
+			i.positioned = false
+			return nil, base.LazyValue{}
+		}
+		if c1, c2 := cmp(i.prev.UserKey, key.UserKey), cmp(key.UserKey, i.prev.UserKey); (c1 < 0) != (c2 > 0) || (c1 == 0) != (c2 == 0) {
+			i.errorf("comparing %s and %s returned %d and %d in reverse", i.comparer.FormatKey(i.prev.UserKey),
+				i.comparer.FormatKey(key.UserKey), errors.Safe(c1), errors.Safe(c2))
+			i.positioned = false
+			return nil, base.LazyValue{}
+		}
+	}
+	i.prevBuf = append(i.prevBuf[:0], key.UserKey...)
+	i.prev = InternalKey{UserKey: i.prevBuf, Trailer: key.Trailer}
+	i.positioned = true
+	return key, value
+}
+
+func (i *keyOrderCheckingIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	k, v := i.iter.SeekGE(key, flags)
+	return i.checkSeek("SeekGE", key, +1, k, v)
+}
+
+func (i *keyOrderCheckingIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	k, v := i.iter.SeekPrefixGE(prefix, key, flags)
+	return i.checkSeek("SeekPrefixGE", key, +1, k, v)
+}
+
+func (i *keyOrderCheckingIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*InternalKey, base.LazyValue) {
+	k, v := i.iter.SeekLT(key, flags)
+	return i.checkSeek("SeekLT", key, -1, k, v)
+}
+
+func (i *keyOrderCheckingIter) First() (*InternalKey, base.LazyValue) {
+	k, v := i.iter.First()
+	return i.check("First", 0, k, v)
+}
+
+func (i *keyOrderCheckingIter) Last() (*InternalKey, base.LazyValue) {
+	k, v := i.iter.Last()
+	return i.check("Last", 0, k, v)
+}
+
+func (i *keyOrderCheckingIter) Next() (*InternalKey, base.LazyValue) {
+	k, v := i.iter.Next()
+	return i.check("Next", +1, k, v)
+}
+
+func (i *keyOrderCheckingIter) NextPrefix(succKey []byte) (*InternalKey, base.LazyValue) {
+	k, v := i.iter.NextPrefix(succKey)
+	return i.check("NextPrefix", +1, k, v)
+}
+
+func (i *keyOrderCheckingIter) Prev() (*InternalKey, base.LazyValue) {
+	k, v := i.iter.Prev()
+	return i.check("Prev", -1, k, v)
+}
+
+func (i *keyOrderCheckingIter) Error() error {
+	return firstError(i.err, i.iter.Error())
+}
+
+func (i *keyOrderCheckingIter) Close() error {
+	return firstError(i.err, i.iter.Close())
+}
+
+func (i *keyOrderCheckingIter) SetBounds(lower, upper []byte) {
+	i.positioned = false
+	i.iter.SetBounds(lower, upper)
+}
+
+func (i *keyOrderCheckingIter) String() string {
+	return i.iter.String()
+}
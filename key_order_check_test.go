@@ -0,0 +1,65 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugCheckKeyOrder(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	for _, keys := range [][]string{{"a", "b", "c"}, {"a", "c"}} {
+		for _, k := range keys {
+			require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Close())
+
+	// Reopen the DB with a buggy comparer which has the same name as the
+	// comparer used to write the keys, but orders "b" after all the other
+	// keys.
+	buggy := *DefaultComparer
+	buggy.Compare = func(a, b []byte) int {
+		if bytes.Equal(a, b) {
+			return 0
+		}
+		if string(a) == "b" {
+			return +1
+		}
+		if string(b) == "b" {
+			return -1
+		}
+		return bytes.Compare(a, b)
+	}
+	d, err = Open("", &Options{
+		FS:                          mem,
+		Comparer:                    &buggy,
+		DisableAutomaticCompactions: true,
+		DebugCheckKeyOrder:          true,
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	iter := d.NewIter(nil)
+	require.True(t, iter.First())
+	for iter.Next() {
+	}
+	err = iter.Close()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "keys inconsistently ordered by comparer")
+	require.Contains(t, err.Error(), "returned c#")
+
+	// Compactions fail rather than writing out of order sstables.
+	err = d.Compact([]byte("a"), []byte("d"), false /* parallelize */)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "keys inconsistently ordered by comparer")
+}
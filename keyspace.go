@@ -0,0 +1,305 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/errors"
+)
+
+// FixedPrefixKeyspaces returns a function for Options.Experimental.KeyspaceEnd
+// which makes each prefix of n bytes of the user keys a keyspace, e.g. for
+// keys prefixed with a fixed-length tenant ID. A key shorter than n bytes is a
+// keyspace on its own. It requires a Comparer which orders keys bytewise, like
+// DefaultComparer.
+func FixedPrefixKeyspaces(n int) func(userKey []byte) []byte {
+	return func(userKey []byte) []byte {
+		if len(userKey) < n {
+			return append(append(make([]byte, 0, len(userKey)+1), userKey...), 0)
+		}
+		return prefixEnd(userKey[:n])
+	}
+}
+
+// prefixEnd returns the smallest key greater than all the keys with the given
+// prefix in bytewise order, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// DropKeyspace deletes all the keys of the keyspace starting at the given key,
+// e.g. the key prefix of the keyspace (see Options.Experimental.KeyspaceEnd).
+// The keys are deleted with a range deletion which is flushed right away.
+// Then the sstables of the keyspace, which hold no keys of other keyspaces, are
+// removed from the LSM by a version edit, without being read or rewritten.
+// Sstables which cannot be removed right away, e.g. because an open snapshot
+// still reads them, are left to the delete-only compactions triggered by the
+// range deletion in the background.
+func (d *DB) DropKeyspace(start []byte) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	keyspaceEnd := d.opts.Experimental.KeyspaceEnd
+	if keyspaceEnd == nil {
+		return errors.New("pebble: keyspaces are not configured")
+	}
+	end := keyspaceEnd(start)
+	if end == nil {
+		return errors.Errorf("pebble: cannot drop the unbounded keyspace starting at %s",
+			d.opts.Comparer.FormatKey(start))
+	}
+	return d.deleteRangeAndDropTables(start, end, Sync)
+}
+
+// deleteRangeAndDropTables deletes the keys in [start, end) with a range
+// deletion, which is flushed right away, and then removes the sstables
+// contained within [start, end) from the LSM with a delete-only compaction.
+func (d *DB) deleteRangeAndDropTables(start, end []byte, opts *WriteOptions) error {
+	b := d.NewBatch()
+	defer b.Close()
+	if err := b.DeleteRange(start, end, nil); err != nil {
+		return err
+	}
+	if err := d.Apply(b, opts); err != nil {
+		return err
+	}
+	// The memtables hold keys outside of [start, end), so the range deletion
+	// is flushed in order to delete the keys the memtables hold.
+	if err := d.Flush(); err != nil {
+		return err
+	}
+
+	c := d.pickDropKeyspaceCompaction(start, end, b.SeqNum())
+	if c == nil {
+		return nil
+	}
+	errChannel := make(chan error, 1)
+	d.compact(c, errChannel)
+	return <-errChannel
+}
+
+// DeletePrefixStrategy is the way DB.DeletePrefix deletes the keys.
+type DeletePrefixStrategy int8
+
+const (
+	// DeletePrefixAuto picks the cheapest strategy given the keys with the
+	// prefix: point deletions if there are few keys, a range deletion if the
+	// keys are spread over sstables holding other keys, and dropping the
+	// sstables otherwise.
+	DeletePrefixAuto DeletePrefixStrategy = iota
+	// DeletePrefixPointDeletes deletes each key with a point deletion, which
+	// avoids writing a range deletion that all the reads and compactions of
+	// the keyspace around it must then process.
+	DeletePrefixPointDeletes
+	// DeletePrefixRangeDelete deletes the keys with a range deletion. The
+	// space is reclaimed as compactions process the range deletion.
+	DeletePrefixRangeDelete
+	// DeletePrefixDropTables deletes the keys with a range deletion, which is
+	// flushed right away, and then removes the sstables holding only keys
+	// with the prefix from the LSM, reclaiming their space without reading
+	// or rewriting them (as DropKeyspace does).
+	DeletePrefixDropTables
+)
+
+func (s DeletePrefixStrategy) String() string {
+	switch s {
+	case DeletePrefixAuto:
+		return "auto"
+	case DeletePrefixPointDeletes:
+		return "point-deletes"
+	case DeletePrefixRangeDelete:
+		return "range-delete"
+	case DeletePrefixDropTables:
+		return "drop-tables"
+	default:
+		return fmt.Sprintf("DeletePrefixStrategy(%d)", int8(s))
+	}
+}
+
+// DeletePrefixOptions configures DB.DeletePrefix. The zero value is valid.
+type DeletePrefixOptions struct {
+	// Strategy forces the strategy used to delete the keys. By default, the
+	// strategy is picked by DeletePrefix.
+	Strategy DeletePrefixStrategy
+	// MaxPointDeletes is the maximum number of keys which DeletePrefixAuto
+	// deletes with point deletions. The default value is 16.
+	MaxPointDeletes int
+	// WriteOptions are used for the deletions. Defaults to Sync.
+	WriteOptions *WriteOptions
+}
+
+// DeletePrefixResult describes the deletion performed by DB.DeletePrefix.
+type DeletePrefixResult struct {
+	// Strategy is the strategy used to delete the keys.
+	Strategy DeletePrefixStrategy
+	// PointDeletes is the number of keys deleted with point deletions.
+	PointDeletes int
+	// EstimatedReclaimedBytes is the estimated space used by the keys on
+	// disk before the deletion (see EstimateDiskUsage), which is reclaimed
+	// once the deletion has been compacted.
+	EstimatedReclaimedBytes uint64
+	// DroppedTablesBytes is the size of the sstables which were dropped
+	// right away by DeletePrefixDropTables.
+	DroppedTablesBytes uint64
+}
+
+// DeletePrefix deletes all the keys with the given prefix, picking the
+// cheapest way to delete them (see DeletePrefixStrategy), unless one is forced
+// by the options. It requires a Comparer which orders keys bytewise, like
+// DefaultComparer.
+func (d *DB) DeletePrefix(prefix []byte, opts *DeletePrefixOptions) (DeletePrefixResult, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return DeletePrefixResult{}, ErrReadOnly
+	}
+	var o DeletePrefixOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxPointDeletes <= 0 {
+		o.MaxPointDeletes = 16
+	}
+	if o.WriteOptions == nil {
+		o.WriteOptions = Sync
+	}
+	end := prefixEnd(prefix)
+	if end == nil {
+		return DeletePrefixResult{}, errors.Errorf("pebble: cannot delete the unbounded prefix %s",
+			d.opts.Comparer.FormatKey(prefix))
+	}
+
+	var res DeletePrefixResult
+	var err error
+	if res.EstimatedReclaimedBytes, err = d.EstimateDiskUsage(prefix, end); err != nil {
+		return DeletePrefixResult{}, err
+	}
+	res.Strategy = o.Strategy
+	var keys [][]byte
+	if res.Strategy == DeletePrefixAuto || res.Strategy == DeletePrefixPointDeletes {
+		limit := -1
+		if res.Strategy == DeletePrefixAuto {
+			limit = o.MaxPointDeletes + 1
+		}
+		if keys, err = d.collectKeys(prefix, end, limit); err != nil {
+			return DeletePrefixResult{}, err
+		}
+	}
+	if res.Strategy == DeletePrefixAuto {
+		switch {
+		case len(keys) <= o.MaxPointDeletes:
+			res.Strategy = DeletePrefixPointDeletes
+		case d.containedTablesSize(prefix, end) > 0:
+			res.Strategy = DeletePrefixDropTables
+		default:
+			res.Strategy = DeletePrefixRangeDelete
+		}
+	}
+
+	switch res.Strategy {
+	case DeletePrefixPointDeletes:
+		b := d.NewBatch()
+		defer b.Close()
+		for _, k := range keys {
+			if err := b.Delete(k, nil); err != nil {
+				return DeletePrefixResult{}, err
+			}
+		}
+		res.PointDeletes = len(keys)
+		err = d.Apply(b, o.WriteOptions)
+	case DeletePrefixRangeDelete:
+		err = d.DeleteRange(prefix, end, o.WriteOptions)
+	case DeletePrefixDropTables:
+		before := d.containedTablesSize(prefix, end)
+		err = d.deleteRangeAndDropTables(prefix, end, o.WriteOptions)
+		if after := d.containedTablesSize(prefix, end); after < before {
+			res.DroppedTablesBytes = before - after
+		}
+	default:
+		err = errors.Errorf("pebble: unknown DeletePrefixStrategy %d", int8(res.Strategy))
+	}
+	if err != nil {
+		return DeletePrefixResult{}, err
+	}
+	return res, nil
+}
+
+// collectKeys returns copies of the keys in [start, end), stopping after
+// limit keys if limit is non-negative.
+func (d *DB) collectKeys(start, end []byte, limit int) ([][]byte, error) {
+	iter := d.NewIter(&IterOptions{LowerBound: start, UpperBound: end})
+	var keys [][]byte
+	for valid := iter.First(); valid && (limit < 0 || len(keys) < limit); valid = iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// containedTablesSize returns the total size of the sstables contained
+// within [start, end).
+func (d *DB) containedTablesSize(start, end []byte) uint64 {
+	readState := d.loadReadState()
+	defer readState.unref()
+	var size uint64
+	for level := range readState.current.Levels {
+		overlaps := readState.current.Overlaps(level, d.cmp, start, end, true /* exclusiveEnd */)
+		iter := overlaps.Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if d.cmp(start, f.Smallest.UserKey) <= 0 &&
+				(d.cmp(f.Largest.UserKey, end) < 0 ||
+					(d.cmp(f.Largest.UserKey, end) == 0 && f.Largest.IsExclusiveSentinel())) {
+				size += f.Size
+			}
+		}
+	}
+	return size
+}
+
+// pickDropKeyspaceCompaction returns a delete-only compaction of the sstables
+// contained within [start, end) whose keys are all older than the range
+// deletion at the given sequence number, or nil if there are none.
+func (d *DB) pickDropKeyspaceCompaction(start, end []byte, seqNum uint64) *compaction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mu.versions.logLock()
+	defer d.mu.versions.logUnlock()
+
+	// A hint for a tombstone above L0, with no lower bound on the sequence
+	// numbers of the sstables it covers, only resolves if no snapshot needs
+	// the sstables.
+	hint := deleteCompactionHint{
+		hintType:                deleteCompactionHintTypePointKeyOnly,
+		start:                   start,
+		end:                     end,
+		tombstoneLevel:          -1,
+		tombstoneSmallestSeqNum: seqNum,
+		tombstoneLargestSeqNum:  seqNum,
+	}
+	v := d.mu.versions.currentVersion()
+	inputs, _ := checkDeleteCompactionHints(
+		d.cmp, v, []deleteCompactionHint{hint}, d.mu.snapshots.toSlice())
+	if len(inputs) == 0 {
+		return nil
+	}
+	c := newDeleteOnlyCompaction(d.opts, v, inputs)
+	d.mu.compact.compactingCount++
+	d.addInProgressCompaction(c)
+	return c
+}
@@ -0,0 +1,159 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedPrefixKeyspaces(t *testing.T) {
+	end := FixedPrefixKeyspaces(2)
+	require.Equal(t, []byte("ab"), end([]byte("aa")))
+	require.Equal(t, []byte("ab"), end([]byte("aa123")))
+	require.Equal(t, []byte("b"), end([]byte("a\xff1")))
+	require.Equal(t, []byte("a\x00"), end([]byte("a")))
+	require.Nil(t, end([]byte("\xff\xff")))
+}
+
+func TestDropKeyspace(t *testing.T) {
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.Experimental.KeyspaceEnd = FixedPrefixKeyspaces(2)
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	for gen := 0; gen < 3; gen++ {
+		for i := 0; i < 100; i++ {
+			for _, ks := range []string{"aa", "bb", "cc"} {
+				require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%04d", ks, i)), []byte(fmt.Sprint(gen)), nil))
+			}
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.DeleteRange([]byte("aa0090"), []byte("bb0010"), nil))
+	require.NoError(t, d.Flush())
+
+	// No sstable holds the keys of several keyspaces.
+	checkTables := func() map[FileNum]string {
+		tables, err := d.SSTables()
+		require.NoError(t, err)
+		keyspaces := make(map[FileNum]string)
+		for _, level := range tables {
+			for _, f := range level {
+				// A range deletion truncated at the end of the keyspace
+				// makes it the exclusive upper bound of the sstable.
+				end := opts.Experimental.KeyspaceEnd(f.Smallest.UserKey)
+				c := DefaultComparer.Compare(f.Largest.UserKey, end)
+				require.True(t, c < 0 || (c == 0 && f.Largest.IsExclusiveSentinel()),
+					"%s: [%s, %s]", f.FileNum, f.Smallest, f.Largest)
+				keyspaces[f.FileNum] = string(f.Smallest.UserKey[:2])
+			}
+		}
+		return keyspaces
+	}
+	checkTables()
+	require.NoError(t, d.Compact([]byte("aa"), []byte("cd"), false /* parallelize */))
+	before := checkTables()
+	require.Len(t, before, 3)
+
+	// An open snapshot which reads the sstables of the keyspace prevents
+	// their deletion.
+	snap := d.NewSnapshot()
+	require.NoError(t, d.DropKeyspace([]byte("cc")))
+	after := checkTables()
+	for fileNum, ks := range before {
+		require.Equal(t, ks, after[fileNum])
+	}
+	require.NoError(t, snap.Close())
+
+	require.NoError(t, d.DropKeyspace([]byte("bb")))
+	// The sstables of the keyspace are deleted right away; only the sstable
+	// holding the range deletion remains. The sstables of the other keyspaces
+	// were not rewritten.
+	require.Equal(t, int64(1), d.Metrics().Compact.DeleteOnlyCount)
+	after = checkTables()
+	for fileNum, ks := range before {
+		if ks == "bb" {
+			require.NotContains(t, after, fileNum)
+		} else {
+			require.Equal(t, ks, after[fileNum])
+		}
+	}
+
+	iter := d.NewIter(nil)
+	counts := make(map[string]int)
+	for iter.First(); iter.Valid(); iter.Next() {
+		counts[string(iter.Key()[:2])]++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, map[string]int{"aa": 90}, counts)
+
+	require.Error(t, d.DropKeyspace([]byte("\xff\xff")))
+}
+
+func TestDropKeyspaceNotConfigured(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	require.Error(t, d.DropKeyspace([]byte("aa")))
+	require.NoError(t, d.Close())
+}
+
+func TestDeletePrefix(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	write := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%04d", prefix, i)), []byte("v"), nil))
+		}
+	}
+	count := func(prefix string) int {
+		keys, err := d.collectKeys([]byte(prefix), prefixEnd([]byte(prefix)), -1)
+		require.NoError(t, err)
+		return len(keys)
+	}
+
+	// "aa" and "cc" share sstables, while "bb" has its own sstable.
+	write("aa", 100)
+	write("cc", 100)
+	write("dd", 5)
+	require.NoError(t, d.Flush())
+	write("bb", 100)
+	require.NoError(t, d.Flush())
+
+	res, err := d.DeletePrefix([]byte("dd"), nil)
+	require.NoError(t, err)
+	require.Equal(t, DeletePrefixPointDeletes, res.Strategy)
+	require.Equal(t, 5, res.PointDeletes)
+	require.Zero(t, count("dd"))
+
+	res, err = d.DeletePrefix([]byte("aa"), nil)
+	require.NoError(t, err)
+	require.Equal(t, DeletePrefixRangeDelete, res.Strategy)
+	require.NotZero(t, res.EstimatedReclaimedBytes)
+	require.Zero(t, count("aa"))
+
+	res, err = d.DeletePrefix([]byte("bb"), nil)
+	require.NoError(t, err)
+	require.Equal(t, DeletePrefixDropTables, res.Strategy)
+	require.NotZero(t, res.DroppedTablesBytes)
+	require.Zero(t, count("bb"))
+	require.Equal(t, int64(1), d.Metrics().Compact.DeleteOnlyCount)
+
+	// A strategy can be forced.
+	res, err = d.DeletePrefix([]byte("cc"), &DeletePrefixOptions{Strategy: DeletePrefixPointDeletes})
+	require.NoError(t, err)
+	require.Equal(t, DeletePrefixPointDeletes, res.Strategy)
+	require.Equal(t, 100, res.PointDeletes)
+	require.Zero(t, count("cc"))
+
+	_, err = d.DeletePrefix([]byte("\xff"), nil)
+	require.Error(t, err)
+}
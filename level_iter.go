@@ -94,6 +94,10 @@ type levelIter struct {
 	// tableOpts.{Lower,Upper}Bound are nil, the corresponding iteration boundary
 	// does not lie within the table bounds.
 	tableOpts IterOptions
+	// timeRangeFilter and filtersBuf back tableOpts.PointKeyFilters when
+	// IterOptions.TimeRange is set.
+	timeRangeFilter sstable.BlockIntervalFilter
+	filtersBuf      []BlockPropertyFilter
 	// The LSM level this levelIter is initialized for.
 	level manifest.Level
 	// The keys to return when iterating past an sstable boundary and that
@@ -267,6 +271,11 @@ func (l *levelIter) init(
 	l.upper = opts.UpperBound
 	l.tableOpts.TableFilter = opts.TableFilter
 	l.tableOpts.PointKeyFilters = opts.PointKeyFilters
+	if opts.TimeRange.isSet() {
+		l.timeRangeFilter.Init(timestampsPropertyName, opts.TimeRange.Start, opts.TimeRange.End)
+		l.filtersBuf = append(append(l.filtersBuf[:0], opts.PointKeyFilters...), &l.timeRangeFilter)
+		l.tableOpts.PointKeyFilters = l.filtersBuf
+	}
 	l.tableOpts.UseL6Filters = opts.UseL6Filters
 	l.tableOpts.level = l.level
 	l.cmp = cmp
@@ -7,6 +7,8 @@ package pebble
 import (
 	"testing"
 
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 )
@@ -132,3 +134,44 @@ func TestRecycleLogs(t *testing.T) {
 	}
 	require.NoError(t, d.Close())
 }
+
+func TestRecycleLogsOptions(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		FS:                 mem,
+		WALPreallocateSize: -1,
+		WALRecycleLimit:    -1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, d.walPreallocateSize())
+
+	// Obsolete WALs are deleted rather than recycled.
+	d.mu.Lock()
+	logNum := d.mu.log.queue[len(d.mu.log.queue)-1].fileNum
+	d.mu.Unlock()
+	require.NoError(t, d.Flush())
+	require.EqualValues(t, []FileNum(nil), d.logRecycler.logNums())
+	_, err = mem.Stat(base.MakeFilepath(mem, "", fileTypeLog, logNum))
+	require.True(t, oserror.IsNotExist(err))
+	require.NoError(t, d.Close())
+
+	for _, tc := range []struct {
+		preallocateSize, recycleLimit int
+		wantPreallocateSize           int
+		wantRecycleLimit              int
+	}{
+		{0, 0, 4<<20 + 4<<20/10, 3},
+		{64 << 10, 1, 64 << 10, 1},
+	} {
+		d, err = Open("", &Options{
+			FS:                 mem,
+			MemTableSize:       4 << 20,
+			WALPreallocateSize: tc.preallocateSize,
+			WALRecycleLimit:    tc.recycleLimit,
+		})
+		require.NoError(t, err)
+		require.Equal(t, tc.wantPreallocateSize, d.walPreallocateSize())
+		require.Equal(t, tc.wantRecycleLimit, d.logRecycler.limit)
+		require.NoError(t, d.Close())
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage"
+)
+
+// errNoCreatorID is returned when the replica of the MANIFEST cannot be
+// uploaded because the shared creator ID is not set.
+var errNoCreatorID = errors.New("pebble: shared creator ID not set")
+
+// manifestReplicaRetryDelay is the minimum delay before the upload of the
+// replica of the MANIFEST is attempted again after a failure.
+const manifestReplicaRetryDelay = time.Second
+
+// manifestReplicator maintains the replica of the current MANIFEST on shared
+// storage (see Options.Experimental.ReplicateManifest). The changes to the
+// MANIFEST are coalesced: the synced part of the MANIFEST is uploaded at most
+// once every Options.Experimental.ManifestReplicaInterval, and immediately
+// when the MANIFEST is rotated or the DB is closed.
+type manifestReplicator struct {
+	provider *objstorage.Provider
+	listener *EventListener
+	interval time.Duration
+	// done is closed when the uploader exits.
+	done chan struct{}
+
+	mu struct {
+		sync.Mutex
+		cond sync.Cond
+		// fileNum and size identify the synced part of the current MANIFEST.
+		fileNum FileNum
+		size    int64
+		// uploadedFileNum and uploadedSize identify the part of a MANIFEST
+		// last uploaded.
+		uploadedFileNum FileNum
+		uploadedSize    int64
+		// nextUpload is the earliest time of the next upload, unless the
+		// MANIFEST was rotated since the last upload.
+		nextUpload time.Time
+		// failed is set if the last upload failed, which delays the next one
+		// even if the MANIFEST was rotated.
+		failed bool
+		// dirtySince is the time of the first change to the MANIFEST which
+		// was not uploaded yet, or zero if the replica is up to date.
+		dirtySince time.Time
+		timerSet   bool
+		closing    bool
+		uploads    int64
+		failures   int64
+	}
+}
+
+// startManifestReplicatorLocked starts the replication of the MANIFEST. d.mu
+// must be held.
+func (d *DB) startManifestReplicatorLocked() {
+	r := &manifestReplicator{
+		provider: d.objProvider,
+		listener: d.opts.EventListener,
+		interval: d.opts.Experimental.ManifestReplicaInterval,
+		done:     make(chan struct{}),
+	}
+	r.mu.cond.L = &r.mu.Mutex
+	r.setManifest(d.mu.versions.manifestFileNum, d.mu.versions.manifest.Size())
+	// The subscribers are invoked once the MANIFEST is synced.
+	d.mu.versions.editSubscribers = append(d.mu.versions.editSubscribers, &versionEditSubscriber{
+		fn: func(VersionEditInfo) {
+			r.setManifest(d.mu.versions.manifestFileNum, d.mu.versions.manifest.Size())
+		},
+	})
+	d.manifestReplicator = r
+	go r.run()
+}
+
+// setManifest records the synced part of the current MANIFEST.
+func (r *manifestReplicator) setManifest(fileNum FileNum, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mu.fileNum = fileNum
+	r.mu.size = size
+	if r.mu.dirtySince.IsZero() && (fileNum != r.mu.uploadedFileNum || size != r.mu.uploadedSize) {
+		r.mu.dirtySince = time.Now()
+	}
+	r.mu.cond.Signal()
+}
+
+// close uploads the changes to the MANIFEST which were not uploaded yet, and
+// stops the uploader.
+func (r *manifestReplicator) close() {
+	r.mu.Lock()
+	r.mu.closing = true
+	r.mu.cond.Signal()
+	r.mu.Unlock()
+	<-r.done
+}
+
+// retry makes the uploader retry a failed upload without delay.
+func (r *manifestReplicator) retry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mu.failed = false
+	r.mu.nextUpload = time.Time{}
+	r.mu.cond.Signal()
+}
+
+// metrics fills the metrics of the replication of the MANIFEST.
+func (r *manifestReplicator) metrics(m *Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m.ManifestReplica.Uploads = r.mu.uploads
+	m.ManifestReplica.Failures = r.mu.failures
+	if !r.mu.dirtySince.IsZero() {
+		m.ManifestReplica.Lag = time.Since(r.mu.dirtySince)
+	}
+}
+
+func (r *manifestReplicator) run() {
+	defer close(r.done)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		rotated := r.mu.fileNum != r.mu.uploadedFileNum
+		if !rotated && r.mu.size == r.mu.uploadedSize {
+			if r.mu.closing {
+				return
+			}
+			r.mu.cond.Wait()
+			continue
+		}
+		if wait := time.Until(r.mu.nextUpload); wait > 0 && (!rotated || r.mu.failed) && !r.mu.closing {
+			if !r.mu.timerSet {
+				r.mu.timerSet = true
+				time.AfterFunc(wait, func() {
+					r.mu.Lock()
+					defer r.mu.Unlock()
+					r.mu.timerSet = false
+					r.mu.cond.Signal()
+				})
+			}
+			r.mu.cond.Wait()
+			continue
+		}
+
+		fileNum, size, prevFileNum := r.mu.fileNum, r.mu.size, r.mu.uploadedFileNum
+		start := time.Now()
+		r.mu.Unlock()
+		err := r.upload(fileNum, size, prevFileNum)
+		r.mu.Lock()
+		if err != nil {
+			if err != errNoCreatorID {
+				r.mu.failures++
+			}
+			if r.mu.closing {
+				return
+			}
+			r.mu.failed = true
+			delay := r.interval
+			if delay < manifestReplicaRetryDelay {
+				delay = manifestReplicaRetryDelay
+			}
+			r.mu.nextUpload = time.Now().Add(delay)
+			continue
+		}
+		r.mu.failed = false
+		r.mu.uploadedFileNum, r.mu.uploadedSize = fileNum, size
+		r.mu.nextUpload = time.Now().Add(r.interval)
+		r.mu.uploads++
+		r.mu.dirtySince = time.Time{}
+		if fileNum != r.mu.fileNum || size != r.mu.size {
+			// The MANIFEST changed during the upload.
+			r.mu.dirtySince = start
+		}
+	}
+}
+
+// upload uploads the given part of a MANIFEST, and removes the replica of the
+// previous MANIFEST if it was rotated.
+func (r *manifestReplicator) upload(fileNum FileNum, size int64, prevFileNum FileNum) error {
+	if !r.provider.CanReplicate() {
+		return errNoCreatorID
+	}
+	meta := objstorage.ObjectMetadata{FileType: fileTypeManifest, FileNum: fileNum}
+	start := time.Now()
+	err := r.provider.UploadReplicaPrefix(context.Background(), meta, size)
+	r.listener.ManifestReplicated(ManifestReplicaInfo{
+		FileNum:  fileNum,
+		Size:     size,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+	if err != nil {
+		return err
+	}
+	if prevFileNum != 0 && prevFileNum != fileNum {
+		r.provider.RemoveReplica(objstorage.ObjectMetadata{FileType: fileTypeManifest, FileNum: prevFileNum})
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicateManifest(t *testing.T) {
+	for _, interval := range []time.Duration{0, time.Hour} {
+		t.Run(fmt.Sprintf("interval=%s", interval), func(t *testing.T) {
+			mem := vfs.NewMem()
+			storage := shared.NewInMem()
+			opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+			opts.Experimental.SharedStorage = storage
+			opts.Experimental.ReplicateManifest = true
+			opts.Experimental.ManifestReplicaInterval = interval
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			require.NoError(t, d.SetCreatorID(1))
+
+			manifestNum := func() FileNum {
+				d.mu.Lock()
+				defer d.mu.Unlock()
+				return d.mu.versions.manifestFileNum
+			}
+			replicaName := func(fileNum FileNum) string {
+				return objstorage.CreatorID(1).String() + "/manifest/" + base.MakeFilename(fileTypeManifest, fileNum)
+			}
+			readFile := func(fs vfs.FS, name string) []byte {
+				f, err := fs.Open(name)
+				require.NoError(t, err)
+				defer f.Close()
+				data, err := io.ReadAll(f)
+				require.NoError(t, err)
+				return data
+			}
+			readReplica := func(fileNum FileNum) []byte {
+				rc, _, err := storage.ReadObjectAt(replicaName(fileNum), 0)
+				require.NoError(t, err)
+				defer rc.Close()
+				data, err := io.ReadAll(rc)
+				require.NoError(t, err)
+				return data
+			}
+			waitUploaded := func(fileNum FileNum) {
+				local := readFile(mem, base.MakeFilename(fileTypeManifest, fileNum))
+				require.Eventually(t, func() bool {
+					rc, _, err := storage.ReadObjectAt(replicaName(fileNum), 0)
+					if err != nil {
+						return false
+					}
+					defer rc.Close()
+					data, err := io.ReadAll(rc)
+					require.NoError(t, err)
+					return string(data) == string(local)
+				}, 10*time.Second, time.Millisecond)
+			}
+
+			// The current MANIFEST is uploaded once the creator ID is set.
+			first := manifestNum()
+			waitUploaded(first)
+			uploads := d.Metrics().ManifestReplica.Uploads
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, d.Set([]byte(fmt.Sprint(i)), nil, nil))
+				require.NoError(t, d.Flush())
+			}
+			if interval == 0 {
+				waitUploaded(first)
+				require.Greater(t, d.Metrics().ManifestReplica.Uploads, uploads)
+			} else {
+				// The changes are coalesced until the next upload.
+				require.Equal(t, uploads, d.Metrics().ManifestReplica.Uploads)
+			}
+
+			// A rotated MANIFEST is uploaded immediately, and replaces the
+			// replica of the previous one.
+			noCompactions := func() []compactionInfo { return nil }
+			d.mu.Lock()
+			d.mu.versions.logLock()
+			err = d.mu.versions.logAndApply(
+				0 /* jobID */, &versionEdit{}, nil /* metrics */, true /* forceRotation */, noCompactions)
+			d.mu.Unlock()
+			require.NoError(t, err)
+			second := manifestNum()
+			require.NotEqual(t, first, second)
+			waitUploaded(second)
+			_, err = storage.Size(replicaName(first))
+			require.Error(t, err)
+
+			// The last changes are uploaded when the DB is closed.
+			require.NoError(t, d.Set([]byte("a"), nil, nil))
+			require.NoError(t, d.Flush())
+			require.NoError(t, d.Close())
+			require.Equal(t, readFile(mem, base.MakeFilename(fileTypeManifest, second)), readReplica(second))
+		})
+	}
+}
+
+// failingStorage is a shared.Storage whose object creations fail while fail is
+// set.
+type failingStorage struct {
+	shared.Storage
+	fail atomic.Bool
+}
+
+func (s *failingStorage) CreateObject(objName string) (io.WriteCloser, error) {
+	if s.fail.Load() {
+		return nil, errors.New("injected error")
+	}
+	return s.Storage.CreateObject(objName)
+}
+
+func TestReplicateManifestFailure(t *testing.T) {
+	storage := &failingStorage{Storage: shared.NewInMem()}
+	storage.fail.Store(true)
+	var mu sync.Mutex
+	var failures int
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.EventListener = &EventListener{
+		ManifestReplicated: func(info ManifestReplicaInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Err != nil {
+				failures++
+			}
+		},
+	}
+	opts.Experimental.SharedStorage = storage
+	opts.Experimental.ReplicateManifest = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	// The failures of the uploads do not fail the changes to the MANIFEST.
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Eventually(t, func() bool {
+		return d.Metrics().ManifestReplica.Failures > 0
+	}, 10*time.Second, time.Millisecond)
+	m := d.Metrics()
+	require.Zero(t, m.ManifestReplica.Uploads)
+	require.Greater(t, m.ManifestReplica.Lag, time.Duration(0))
+	mu.Lock()
+	require.Greater(t, failures, 0)
+	mu.Unlock()
+
+	// The upload is retried.
+	storage.fail.Store(false)
+	require.Eventually(t, func() bool {
+		m := d.Metrics()
+		return m.ManifestReplica.Uploads > 0 && m.ManifestReplica.Lag == 0
+	}, 10*time.Second, time.Millisecond)
+}
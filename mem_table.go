@@ -70,6 +70,9 @@ type memTable struct {
 	skl         arenaskl.Skiplist
 	rangeDelSkl arenaskl.Skiplist
 	rangeKeySkl arenaskl.Skiplist
+	// buckets, if non-nil, holds the point keys instead of skl (see
+	// Options.MemTableHashBuckets).
+	buckets *memTableBuckets
 	// reserved tracks the amount of space used by the memtable, both by actual
 	// data stored in the memtable as well as inflight batch commit
 	// operations. This value is incremented pessimistically by prepare() in
@@ -143,6 +146,9 @@ func newMemTable(opts memTableOptions) *memTable {
 	m.skl.Reset(arena, m.cmp)
 	m.rangeDelSkl.Reset(arena, m.cmp)
 	m.rangeKeySkl.Reset(arena, m.cmp)
+	if opts.MemTableHashBuckets > 0 {
+		m.buckets = newMemTableBuckets(m.cmp, opts.Comparer.Split, arena, opts.MemTableHashBuckets)
+	}
 	return m
 }
 
@@ -174,10 +180,14 @@ func (m *memTable) readyForFlush() bool {
 // writerUnref() after the batch has been applied.
 func (m *memTable) prepare(batch *Batch) error {
 	avail := m.availBytes()
-	if batch.memTableSize > uint64(avail) {
+	size := batch.memTableSize
+	if m.buckets != nil {
+		size += m.buckets.newBucketsSize(batch)
+	}
+	if size > uint64(avail) {
 		return arenaskl.ErrArenaFull
 	}
-	m.reserved += uint32(batch.memTableSize)
+	m.reserved += uint32(size)
 
 	m.writerRef()
 	return nil
@@ -190,6 +200,7 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 	}
 
 	var ins arenaskl.Inserter
+	var bucketsIns memTableBucketsInserter
 	var tombstoneCount, rangeKeyCount uint32
 	startSeqNum := seqNum
 	for r := batch.Reader(); ; seqNum++ {
@@ -213,7 +224,11 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 		case InternalKeyKindIngestSST:
 			panic("pebble: cannot apply ingested sstable key kind to memtable")
 		default:
-			err = ins.Add(&m.skl, ikey, value)
+			if m.buckets != nil {
+				err = bucketsIns.add(m.buckets, ikey, value)
+			} else {
+				err = ins.Add(&m.skl, ikey, value)
+			}
 		}
 		if err != nil {
 			return err
@@ -236,10 +251,16 @@ func (m *memTable) apply(batch *Batch, seqNum uint64) error {
 // return false). The iterator can be positioned via a call to SeekGE,
 // SeekLT, First or Last.
 func (m *memTable) newIter(o *IterOptions) internalIterator {
+	if m.buckets != nil {
+		return m.buckets.newIter(o)
+	}
 	return m.skl.NewIter(o.GetLowerBound(), o.GetUpperBound())
 }
 
 func (m *memTable) newFlushIter(o *IterOptions, bytesFlushed *uint64) internalIterator {
+	if m.buckets != nil {
+		return m.buckets.newFlushIter(bytesFlushed)
+	}
 	return m.skl.NewFlushIter(bytesFlushed)
 }
 
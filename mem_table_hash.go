@@ -0,0 +1,300 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"hash/maphash"
+	"sync/atomic"
+
+	"github.com/cockroachdb/pebble/internal/arenaskl"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// memTableBucketSize is the amount of space allocated in the arena by the
+// creation of a bucket of a hashed memtable.
+var memTableBucketSize = func() uint32 {
+	arena := arenaskl.NewArena(make([]byte, 16<<10 /* 16 KB */))
+	var skl arenaskl.Skiplist
+	skl.Reset(arena, bytes.Compare)
+	before := arena.Size()
+	skl.Reset(arena, bytes.Compare)
+	return arena.Size() - before
+}()
+
+// memTableBuckets holds the point keys of a memtable created with
+// Options.MemTableHashBuckets: the keys are hashed by prefix (see
+// Comparer.Split) into buckets, each of which is a skiplist allocated from the
+// memtable's arena. Writes and prefix seeks only touch the skiplist of the
+// prefix, which is much smaller than a skiplist of the whole memtable, while
+// iterating in total order requires merging the skiplists of all the buckets.
+//
+// The buckets are created lazily, by the first write of a key which hashes to
+// them. The space they require is reserved by memTable.prepare, so that their
+// creation cannot fail.
+type memTableBuckets struct {
+	cmp   Compare
+	split Split
+	seed  maphash.Seed
+	arena *arenaskl.Arena
+	lists []atomic.Pointer[arenaskl.Skiplist]
+}
+
+func newMemTableBuckets(
+	cmp Compare, split Split, arena *arenaskl.Arena, n int,
+) *memTableBuckets {
+	return &memTableBuckets{
+		cmp:   cmp,
+		split: split,
+		seed:  maphash.MakeSeed(),
+		arena: arena,
+		lists: make([]atomic.Pointer[arenaskl.Skiplist], n),
+	}
+}
+
+// bucket returns the index of the bucket of the given prefix.
+func (b *memTableBuckets) bucket(prefix []byte) int {
+	return int(maphash.Bytes(b.seed, prefix) % uint64(len(b.lists)))
+}
+
+// list returns the skiplist of the bucket, creating it if necessary.
+func (b *memTableBuckets) list(i int) *arenaskl.Skiplist {
+	if l := b.lists[i].Load(); l != nil {
+		return l
+	}
+	// Concurrent writers may create the bucket at the same time, in which case
+	// the space allocated by the losers is wasted. This space was reserved by
+	// the prepare of each of their batches.
+	l := arenaskl.NewSkiplist(b.arena, b.cmp)
+	if b.lists[i].CompareAndSwap(nil, l) {
+		return l
+	}
+	return b.lists[i].Load()
+}
+
+// newBucketsSize returns the space required by the buckets which the batch
+// would create if it were applied.
+func (b *memTableBuckets) newBucketsSize(batch *Batch) uint64 {
+	var size uint64
+	last := -1
+	var counted map[int]struct{}
+	for r := batch.Reader(); ; {
+		kind, ukey, _, ok := r.Next()
+		if !ok {
+			break
+		}
+		if !isMemTablePointKind(kind) {
+			continue
+		}
+		i := b.bucket(ukey[:b.split(ukey)])
+		if i == last || b.lists[i].Load() != nil {
+			continue
+		}
+		last = i
+		if _, ok := counted[i]; ok {
+			continue
+		}
+		if counted == nil {
+			counted = make(map[int]struct{})
+		}
+		counted[i] = struct{}{}
+		size += uint64(memTableBucketSize)
+	}
+	return size
+}
+
+// isMemTablePointKind returns true if keys of the kind are point keys stored
+// in the memtable.
+func isMemTablePointKind(kind InternalKeyKind) bool {
+	switch kind {
+	case InternalKeyKindRangeDelete, InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset,
+		InternalKeyKindRangeKeyDelete, InternalKeyKindLogData, InternalKeyKindIngestSST:
+		return false
+	}
+	return true
+}
+
+// memTableBucketsInserter adds keys to the buckets, reusing the splices of
+// the previous insertion while the keys belong to the same bucket.
+type memTableBucketsInserter struct {
+	ins    arenaskl.Inserter
+	bucket int
+	list   *arenaskl.Skiplist
+}
+
+func (ins *memTableBucketsInserter) add(
+	b *memTableBuckets, key base.InternalKey, value []byte,
+) error {
+	i := b.bucket(key.UserKey[:b.split(key.UserKey)])
+	if ins.list == nil || i != ins.bucket {
+		ins.ins = arenaskl.Inserter{}
+		ins.bucket = i
+		ins.list = b.list(i)
+	}
+	return ins.ins.Add(ins.list, key, value)
+}
+
+// nonEmpty returns the skiplists of the buckets created so far.
+func (b *memTableBuckets) nonEmpty() []*arenaskl.Skiplist {
+	var lists []*arenaskl.Skiplist
+	for i := range b.lists {
+		if l := b.lists[i].Load(); l != nil {
+			lists = append(lists, l)
+		}
+	}
+	return lists
+}
+
+func (b *memTableBuckets) newFlushIter(bytesFlushed *uint64) internalIterator {
+	lists := b.nonEmpty()
+	iters := make([]internalIterator, len(lists))
+	for i, l := range lists {
+		iters[i] = l.NewFlushIter(bytesFlushed)
+	}
+	var stats base.InternalIteratorStats
+	return newMergingIter(nil /* logger */, &stats, b.cmp, nil /* split */, iters...)
+}
+
+// memTableBucketsIter iterates over the point keys of a hashed memtable. A
+// SeekPrefixGE only positions the iterator of the bucket of the prefix, while
+// the other positioning methods use an iterator merging all the buckets,
+// which is constructed on first use.
+type memTableBucketsIter struct {
+	b            *memTableBuckets
+	lower, upper []byte
+	// iter is the iterator which was last positioned, if any.
+	iter internalIterator
+	// prefixIter iterates over the bucket prefixBucket.
+	prefixIter   *arenaskl.Iterator
+	prefixBucket int
+	merging      *mergingIter
+	stats        base.InternalIteratorStats
+}
+
+var _ internalIterator = (*memTableBucketsIter)(nil)
+
+func (b *memTableBuckets) newIter(o *IterOptions) *memTableBucketsIter {
+	return &memTableBucketsIter{b: b, lower: o.GetLowerBound(), upper: o.GetUpperBound()}
+}
+
+func (it *memTableBucketsIter) String() string {
+	return "memtable"
+}
+
+// totalOrder makes the merging iterator, constructed if necessary, the
+// current iterator.
+func (it *memTableBucketsIter) totalOrder() {
+	if it.merging == nil {
+		lists := it.b.nonEmpty()
+		iters := make([]internalIterator, len(lists))
+		for i, l := range lists {
+			iters[i] = l.NewIter(it.lower, it.upper)
+		}
+		it.merging = newMergingIter(nil /* logger */, &it.stats, it.b.cmp, it.b.split, iters...)
+	}
+	it.iter = it.merging
+}
+
+func (it *memTableBucketsIter) SeekGE(
+	key []byte, flags base.SeekGEFlags,
+) (*base.InternalKey, base.LazyValue) {
+	if it.iter != it.merging || it.merging == nil {
+		flags = flags.DisableTrySeekUsingNext()
+	}
+	it.totalOrder()
+	return it.iter.SeekGE(key, flags)
+}
+
+func (it *memTableBucketsIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*base.InternalKey, base.LazyValue) {
+	i := it.b.bucket(prefix)
+	l := it.b.lists[i].Load()
+	if l == nil {
+		// No key with the prefix has been written.
+		it.iter = nil
+		return nil, base.LazyValue{}
+	}
+	if it.prefixIter == nil || it.prefixBucket != i || it.iter != it.prefixIter {
+		flags = flags.DisableTrySeekUsingNext()
+	}
+	if it.prefixIter == nil || it.prefixBucket != i {
+		if it.prefixIter != nil {
+			_ = it.prefixIter.Close()
+		}
+		it.prefixIter = l.NewIter(it.lower, it.upper)
+		it.prefixBucket = i
+	}
+	it.iter = it.prefixIter
+	return it.iter.SeekPrefixGE(prefix, key, flags)
+}
+
+func (it *memTableBucketsIter) SeekLT(
+	key []byte, flags base.SeekLTFlags,
+) (*base.InternalKey, base.LazyValue) {
+	it.totalOrder()
+	return it.iter.SeekLT(key, flags)
+}
+
+func (it *memTableBucketsIter) First() (*base.InternalKey, base.LazyValue) {
+	it.totalOrder()
+	return it.iter.First()
+}
+
+func (it *memTableBucketsIter) Last() (*base.InternalKey, base.LazyValue) {
+	it.totalOrder()
+	return it.iter.Last()
+}
+
+func (it *memTableBucketsIter) Next() (*base.InternalKey, base.LazyValue) {
+	if it.iter == nil {
+		return nil, base.LazyValue{}
+	}
+	return it.iter.Next()
+}
+
+func (it *memTableBucketsIter) NextPrefix(succKey []byte) (*base.InternalKey, base.LazyValue) {
+	if it.iter == nil {
+		return nil, base.LazyValue{}
+	}
+	return it.iter.NextPrefix(succKey)
+}
+
+func (it *memTableBucketsIter) Prev() (*base.InternalKey, base.LazyValue) {
+	if it.iter == nil {
+		return nil, base.LazyValue{}
+	}
+	return it.iter.Prev()
+}
+
+func (it *memTableBucketsIter) Error() error {
+	if it.merging != nil {
+		return it.merging.Error()
+	}
+	return nil
+}
+
+func (it *memTableBucketsIter) Close() error {
+	var err error
+	if it.merging != nil {
+		err = it.merging.Close()
+	}
+	if it.prefixIter != nil {
+		err = firstError(err, it.prefixIter.Close())
+	}
+	*it = memTableBucketsIter{}
+	return err
+}
+
+func (it *memTableBucketsIter) SetBounds(lower, upper []byte) {
+	it.lower, it.upper = lower, upper
+	if it.merging != nil {
+		it.merging.SetBounds(lower, upper)
+	}
+	if it.prefixIter != nil {
+		it.prefixIter.SetBounds(lower, upper)
+	}
+	it.iter = nil
+}
@@ -19,6 +19,8 @@ import (
 	"github.com/cockroachdb/pebble/internal/arenaskl"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/rangekey"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/rand"
 	"golang.org/x/sync/errgroup"
@@ -404,6 +406,128 @@ func buildMemTable(b *testing.B) (*memTable, [][]byte) {
 	return m, keys
 }
 
+func TestMemTableHashBuckets(t *testing.T) {
+	// Apply the same writes to a DB with hashed memtables and to a DB with
+	// the default memtables, and check that they read the same.
+	var dbs [2]*DB
+	for i := range dbs {
+		opts := &Options{
+			FS:                          vfs.NewMem(),
+			Comparer:                    testkeys.Comparer,
+			DisableAutomaticCompactions: true,
+		}
+		if i == 0 {
+			opts.MemTableHashBuckets = 16
+		}
+		var err error
+		dbs[i], err = Open("", opts)
+		require.NoError(t, err)
+		defer func(d *DB) { require.NoError(t, d.Close()) }(dbs[i])
+	}
+	require.NotNil(t, dbs[0].mu.mem.mutable.buckets)
+	require.Nil(t, dbs[1].mu.mem.mutable.buckets)
+
+	rng := rand.New(rand.NewSource(uint64(time.Now().UnixNano())))
+	key := func() []byte {
+		return []byte(fmt.Sprintf("%03d@%d", rng.Intn(100), rng.Intn(10)+1))
+	}
+	write := func() {
+		var keys [10][]byte
+		var deletes [10]bool
+		for j := range keys {
+			keys[j], deletes[j] = key(), rng.Intn(5) == 0
+		}
+		for _, d := range dbs {
+			b := d.NewBatch()
+			for j, k := range keys {
+				if deletes[j] {
+					require.NoError(t, b.Delete(k, nil))
+				} else {
+					require.NoError(t, b.Set(k, k, nil))
+				}
+			}
+			require.NoError(t, b.Commit(nil))
+		}
+	}
+	read := func(d *DB, ops []string) string {
+		var buf strings.Builder
+		iter := d.NewIter(nil)
+		defer func() { require.NoError(t, iter.Close()) }()
+		for _, op := range ops {
+			var valid bool
+			switch f := strings.Fields(op); f[0] {
+			case "first":
+				valid = iter.First()
+			case "last":
+				valid = iter.Last()
+			case "next":
+				valid = iter.Next()
+			case "prev":
+				valid = iter.Prev()
+			case "seek-ge":
+				valid = iter.SeekGE([]byte(f[1]))
+			case "seek-lt":
+				valid = iter.SeekLT([]byte(f[1]))
+			case "seek-prefix-ge":
+				valid = iter.SeekPrefixGE([]byte(f[1]))
+			case "get":
+				v, closer, err := d.Get([]byte(f[1]))
+				if err == nil {
+					fmt.Fprintf(&buf, "%s\n", v)
+					require.NoError(t, closer.Close())
+				} else {
+					fmt.Fprintf(&buf, "%s\n", err)
+				}
+				continue
+			}
+			if valid {
+				fmt.Fprintf(&buf, "%s\n", iter.Key())
+			} else {
+				fmt.Fprintf(&buf, ".\n")
+			}
+		}
+		return buf.String()
+	}
+	check := func() {
+		var ops []string
+		for i := 0; i < 200; i++ {
+			switch rng.Intn(8) {
+			case 0:
+				ops = append(ops, "first")
+			case 1:
+				ops = append(ops, "last")
+			case 2, 3:
+				ops = append(ops, "next")
+			case 4:
+				ops = append(ops, "seek-ge "+string(key()))
+			case 5:
+				ops = append(ops, "seek-lt "+string(key()))
+			case 6:
+				ops = append(ops, "seek-prefix-ge "+string(key()), "next")
+			case 7:
+				ops = append(ops, "get "+string(key()))
+			}
+		}
+		require.Equal(t, read(dbs[1], ops), read(dbs[0], ops))
+	}
+
+	for i := 0; i < 50; i++ {
+		write()
+	}
+	check()
+	for _, d := range dbs {
+		require.NoError(t, d.Flush())
+	}
+	for i := 0; i < 50; i++ {
+		write()
+	}
+	check()
+	for _, d := range dbs {
+		require.NoError(t, d.Flush())
+	}
+	check()
+}
+
 func BenchmarkMemTableIterSeekGE(b *testing.B) {
 	m, keys := buildMemTable(b)
 	iter := m.newIter(nil)
@@ -0,0 +1,296 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+// Package merger implements commonly needed merge operators: an int64
+// counter, a set union and a list append with a size cap.
+//
+// Each merge operator defines the encoding of its operands, which are also
+// the encoding of its results, and provides functions to encode and decode
+// them. The values written with DB.Set and Batch.Set for a key which is also
+// merged must use the same encoding. All of the merge operators are
+// associative and ignore whether the merge includes the oldest operand of a
+// key, so that compactions fold the operands of a key into a single value
+// even when they only see some of them.
+package merger // import "github.com/cockroachdb/pebble/merger"
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// Int64Counter is a merge operator which adds int64 values, wrapping around
+// on overflow. Operands are encoded with EncodeInt64. A key which has only
+// been merged reads as the sum of its operands, and a key which was set reads
+// as the set value plus the sum of the newer operands.
+var Int64Counter = &base.Merger{
+	Merge: func(key, value []byte) (base.ValueMerger, error) {
+		m := &int64Merger{}
+		if err := m.add(value); err != nil {
+			return nil, err
+		}
+		return m, nil
+	},
+	Name: "pebble.int64_counter",
+}
+
+// EncodeInt64 encodes an operand of Int64Counter.
+func EncodeInt64(v int64) []byte {
+	return binary.LittleEndian.AppendUint64(make([]byte, 0, 8), uint64(v))
+}
+
+// DecodeInt64 decodes an operand or the result of Int64Counter.
+func DecodeInt64(value []byte) (int64, error) {
+	if len(value) != 8 {
+		return 0, errors.Errorf("pebble: invalid int64 counter value of %d bytes", len(value))
+	}
+	return int64(binary.LittleEndian.Uint64(value)), nil
+}
+
+type int64Merger struct {
+	sum int64
+}
+
+func (m *int64Merger) add(value []byte) error {
+	v, err := DecodeInt64(value)
+	if err != nil {
+		return err
+	}
+	m.sum += v
+	return nil
+}
+
+// MergeNewer implements base.ValueMerger.
+func (m *int64Merger) MergeNewer(value []byte) error {
+	return m.add(value)
+}
+
+// MergeOlder implements base.ValueMerger.
+func (m *int64Merger) MergeOlder(value []byte) error {
+	return m.add(value)
+}
+
+// Finish implements base.ValueMerger.
+func (m *int64Merger) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	return EncodeInt64(m.sum), nil, nil
+}
+
+// SetUnion is a merge operator which computes the union of sets of uint64
+// values. Operands are encoded with EncodeSet, as sorted lists of delta
+// encoded varints, which are compact for dense sets.
+var SetUnion = &base.Merger{
+	Merge: func(key, value []byte) (base.ValueMerger, error) {
+		m := &setMerger{}
+		if err := m.add(value); err != nil {
+			return nil, err
+		}
+		return m, nil
+	},
+	Name: "pebble.uint64_set_union",
+}
+
+// EncodeSet encodes an operand of SetUnion holding the given values, which
+// may be in any order and contain duplicates.
+func EncodeSet(values ...uint64) []byte {
+	sorted := append([]uint64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return encodeSortedSet(sorted)
+}
+
+func encodeSortedSet(sorted []uint64) []byte {
+	buf := make([]byte, 0, len(sorted))
+	var prev uint64
+	for i, v := range sorted {
+		if i > 0 && v == prev {
+			continue
+		}
+		buf = binary.AppendUvarint(buf, v-prev)
+		prev = v
+	}
+	return buf
+}
+
+// DecodeSet decodes an operand or the result of SetUnion, returning its values
+// in increasing order.
+func DecodeSet(value []byte) ([]uint64, error) {
+	var values []uint64
+	var prev uint64
+	for len(value) > 0 {
+		delta, n := binary.Uvarint(value)
+		if n <= 0 || (len(values) > 0 && delta == 0) {
+			return nil, errors.New("pebble: invalid set value")
+		}
+		prev += delta
+		values = append(values, prev)
+		value = value[n:]
+	}
+	return values, nil
+}
+
+type setMerger struct {
+	values []uint64
+	buf    []uint64
+}
+
+func (m *setMerger) add(value []byte) error {
+	values, err := DecodeSet(value)
+	if err != nil {
+		return err
+	}
+	if len(m.values) == 0 {
+		m.values = values
+		return nil
+	}
+	// Merge the sorted lists of values.
+	merged := m.buf[:0]
+	i, j := 0, 0
+	for i < len(m.values) || j < len(values) {
+		switch {
+		case j == len(values) || (i < len(m.values) && m.values[i] < values[j]):
+			merged = append(merged, m.values[i])
+			i++
+		case i == len(m.values) || values[j] < m.values[i]:
+			merged = append(merged, values[j])
+			j++
+		default:
+			merged = append(merged, m.values[i])
+			i++
+			j++
+		}
+	}
+	m.values, m.buf = merged, m.values
+	return nil
+}
+
+// MergeNewer implements base.ValueMerger.
+func (m *setMerger) MergeNewer(value []byte) error {
+	return m.add(value)
+}
+
+// MergeOlder implements base.ValueMerger.
+func (m *setMerger) MergeOlder(value []byte) error {
+	return m.add(value)
+}
+
+// Finish implements base.ValueMerger.
+func (m *setMerger) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	return encodeSortedSet(m.values), nil, nil
+}
+
+// Append returns a merge operator which appends lists of elements, keeping
+// only the maxElements most recent elements. A maxElements of zero means no
+// cap. Operands are encoded with EncodeList.
+//
+// The cap is not part of the name of the merge operator, so that it can be
+// changed when a DB is reopened. Lists which were merged with a larger cap
+// keep their elements until they are merged again.
+func Append(maxElements int) *base.Merger {
+	return &base.Merger{
+		Merge: func(key, value []byte) (base.ValueMerger, error) {
+			m := &appendMerger{maxElements: maxElements}
+			if err := m.MergeNewer(value); err != nil {
+				return nil, err
+			}
+			return m, nil
+		},
+		Name: "pebble.capped_append",
+	}
+}
+
+// EncodeList encodes an operand of Append holding the given elements, from
+// oldest to newest.
+func EncodeList(elements ...[]byte) []byte {
+	var n int
+	for _, e := range elements {
+		n += binary.MaxVarintLen32 + len(e)
+	}
+	buf := make([]byte, 0, n)
+	for _, e := range elements {
+		buf = binary.AppendUvarint(buf, uint64(len(e)))
+		buf = append(buf, e...)
+	}
+	return buf
+}
+
+// DecodeList decodes an operand or the result of Append, returning its
+// elements from oldest to newest. The elements alias value.
+func DecodeList(value []byte) ([][]byte, error) {
+	var elements [][]byte
+	for len(value) > 0 {
+		n, l := binary.Uvarint(value)
+		if l <= 0 || n > uint64(len(value)-l) {
+			return nil, errors.New("pebble: invalid list value")
+		}
+		elements = append(elements, value[l:l+int(n)])
+		value = value[l+int(n):]
+	}
+	return elements, nil
+}
+
+type appendMerger struct {
+	maxElements int
+	// newer holds the operands received by MergeNewer, from oldest to newest,
+	// and older the operands received by MergeOlder, from newest to oldest.
+	newer, older [][]byte
+}
+
+func (m *appendMerger) add(operands *[][]byte, value []byte) error {
+	// Validate the operand; it is only decoded again when the merge finishes.
+	if _, err := DecodeList(value); err != nil {
+		return err
+	}
+	*operands = append(*operands, append([]byte(nil), value...))
+	return nil
+}
+
+// MergeNewer implements base.ValueMerger.
+func (m *appendMerger) MergeNewer(value []byte) error {
+	return m.add(&m.newer, value)
+}
+
+// MergeOlder implements base.ValueMerger.
+func (m *appendMerger) MergeOlder(value []byte) error {
+	return m.add(&m.older, value)
+}
+
+// Finish implements base.ValueMerger. Only the most recent elements are kept,
+// which is associative: capping a list before appending newer elements to it
+// drops elements that capping the result would drop anyway.
+func (m *appendMerger) Finish(includesBase bool) ([]byte, io.Closer, error) {
+	operands := make([][]byte, 0, len(m.older)+len(m.newer))
+	for i := len(m.older) - 1; i >= 0; i-- {
+		operands = append(operands, m.older[i])
+	}
+	operands = append(operands, m.newer...)
+	if m.maxElements <= 0 {
+		var n int
+		for _, op := range operands {
+			n += len(op)
+		}
+		buf := make([]byte, 0, n)
+		for _, op := range operands {
+			buf = append(buf, op...)
+		}
+		return buf, nil, nil
+	}
+
+	// Collect the most recent elements, from newest to oldest.
+	var elements [][]byte
+	for i := len(operands) - 1; i >= 0 && len(elements) < m.maxElements; i-- {
+		opElements, err := DecodeList(operands[i])
+		if err != nil {
+			return nil, nil, err
+		}
+		for j := len(opElements) - 1; j >= 0 && len(elements) < m.maxElements; j-- {
+			elements = append(elements, opElements[j])
+		}
+	}
+	for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+		elements[i], elements[j] = elements[j], elements[i]
+	}
+	return EncodeList(elements...), nil, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package merger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/rand"
+)
+
+// merge merges the operands (from oldest to newest) in the order used by
+// iterators, or in the order used by compactions if older is true.
+func merge(t *testing.T, m *base.Merger, older bool, operands ...[]byte) []byte {
+	t.Helper()
+	var vm base.ValueMerger
+	var err error
+	if older {
+		vm, err = m.Merge(nil, operands[len(operands)-1])
+		require.NoError(t, err)
+		for i := len(operands) - 2; i >= 0; i-- {
+			require.NoError(t, vm.MergeOlder(operands[i]))
+		}
+	} else {
+		vm, err = m.Merge(nil, operands[0])
+		require.NoError(t, err)
+		for _, op := range operands[1:] {
+			require.NoError(t, vm.MergeNewer(op))
+		}
+	}
+	value, closer, err := vm.Finish(true /* includesBase */)
+	require.NoError(t, err)
+	require.Nil(t, closer)
+	return value
+}
+
+// checkAssociative checks that merging the operands in any grouping and
+// direction produces the same result.
+func checkAssociative(t *testing.T, m *base.Merger, operands [][]byte) []byte {
+	t.Helper()
+	want := merge(t, m, false, operands...)
+	require.Equal(t, want, merge(t, m, true, operands...))
+	for split := 1; split < len(operands); split++ {
+		left := merge(t, m, true, operands[:split]...)
+		right := merge(t, m, false, operands[split:]...)
+		require.Equal(t, want, merge(t, m, false, left, right), "split at %d", split)
+	}
+	return want
+}
+
+func TestInt64Counter(t *testing.T) {
+	var operands [][]byte
+	for _, v := range []int64{1, -3, 10, 1 << 40} {
+		operands = append(operands, EncodeInt64(v))
+	}
+	v, err := DecodeInt64(checkAssociative(t, Int64Counter, operands))
+	require.NoError(t, err)
+	require.Equal(t, int64(8+1<<40), v)
+
+	_, err = Int64Counter.Merge(nil, []byte("foo"))
+	require.Error(t, err)
+}
+
+func TestSetUnion(t *testing.T) {
+	require.Equal(t, []byte{}, EncodeSet())
+	values, err := DecodeSet(EncodeSet(5, 0, 3, 5, 1<<60))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 3, 5, 1 << 60}, values)
+
+	operands := [][]byte{EncodeSet(1, 5), EncodeSet(3), EncodeSet(), EncodeSet(5, 7, 1)}
+	values, err = DecodeSet(checkAssociative(t, SetUnion, operands))
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 3, 5, 7}, values)
+
+	_, err = SetUnion.Merge(nil, []byte{1, 0})
+	require.Error(t, err)
+}
+
+func TestAppend(t *testing.T) {
+	elements, err := DecodeList(EncodeList([]byte("a"), nil, []byte("bc")))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("a"), {}, []byte("bc")}, elements)
+	_, err = DecodeList([]byte{5, 'a'})
+	require.Error(t, err)
+
+	operands := [][]byte{
+		EncodeList([]byte("a"), []byte("b")),
+		EncodeList([]byte("c")),
+		EncodeList(),
+		EncodeList([]byte("d"), []byte("e"), []byte("f")),
+	}
+	for _, tc := range []struct {
+		maxElements int
+		want        string
+	}{
+		{0, "abcdef"},
+		{10, "abcdef"},
+		{4, "cdef"},
+		{2, "ef"},
+		{1, "f"},
+	} {
+		t.Run(fmt.Sprint(tc.maxElements), func(t *testing.T) {
+			elements, err := DecodeList(checkAssociative(t, Append(tc.maxElements), operands))
+			require.NoError(t, err)
+			var got string
+			for _, e := range elements {
+				got += string(e)
+			}
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+// TestCompaction checks that the merge operators return the same values before
+// and after their operands are folded by compactions.
+func TestCompaction(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, tc := range []struct {
+		merger  *base.Merger
+		operand func(i int) []byte
+		check   func(t *testing.T, ops int, value []byte)
+	}{
+		{
+			merger:  Int64Counter,
+			operand: func(i int) []byte { return EncodeInt64(1) },
+			check: func(t *testing.T, ops int, value []byte) {
+				v, err := DecodeInt64(value)
+				require.NoError(t, err)
+				require.Equal(t, int64(ops), v)
+			},
+		},
+		{
+			merger:  SetUnion,
+			operand: func(i int) []byte { return EncodeSet(uint64(i % 7)) },
+			check: func(t *testing.T, ops int, value []byte) {
+				values, err := DecodeSet(value)
+				require.NoError(t, err)
+				if ops > 7 {
+					ops = 7
+				}
+				require.Len(t, values, ops)
+			},
+		},
+		{
+			merger:  Append(5),
+			operand: func(i int) []byte { return EncodeList([]byte(fmt.Sprint(i))) },
+			check: func(t *testing.T, ops int, value []byte) {
+				elements, err := DecodeList(value)
+				require.NoError(t, err)
+				var want [][]byte
+				for i := ops - 5; i < ops; i++ {
+					if i >= 0 {
+						want = append(want, []byte(fmt.Sprint(i)))
+					}
+				}
+				require.Equal(t, want, elements)
+			},
+		},
+	} {
+		t.Run(tc.merger.Name, func(t *testing.T) {
+			d, err := pebble.Open("", &pebble.Options{FS: vfs.NewMem(), Merger: tc.merger})
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			// Merge each key a random number of times, flushing in between so
+			// that the operands are spread over several sstables.
+			ops := make(map[string]int)
+			for flush := 0; flush < 5; flush++ {
+				for i := 0; i < 20; i++ {
+					key := fmt.Sprintf("k%02d", rng.Intn(10))
+					require.NoError(t, d.Merge([]byte(key), tc.operand(ops[key]), nil))
+					ops[key]++
+				}
+				require.NoError(t, d.Flush())
+			}
+			check := func() {
+				iter := d.NewIter(nil)
+				n := 0
+				for iter.First(); iter.Valid(); iter.Next() {
+					tc.check(t, ops[string(iter.Key())], iter.Value())
+					n++
+				}
+				require.NoError(t, iter.Close())
+				require.Equal(t, len(ops), n)
+			}
+			check()
+
+			require.NoError(t, d.Compact([]byte("k"), []byte("l"), false /* parallelize */))
+			check()
+			tables, err := d.SSTables(pebble.WithProperties())
+			require.NoError(t, err)
+			var mergeOperands uint64
+			for _, level := range tables {
+				for _, table := range level {
+					mergeOperands += table.Properties.NumMergeOperands
+				}
+			}
+			require.LessOrEqual(t, mergeOperands, uint64(len(ops)))
+		})
+	}
+}
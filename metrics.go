@@ -12,6 +12,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/cache"
 	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/objstorage"
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/redact"
@@ -21,6 +22,17 @@ import (
 // CacheMetrics holds metrics for the block and table cache.
 type CacheMetrics = cache.Metrics
 
+// TableCacheMetrics holds metrics for the table cache of a DB.
+type TableCacheMetrics struct {
+	CacheMetrics
+	// Evictions is the number of tables of the DB that were closed to make
+	// room for other tables.
+	Evictions int64
+	// Capacity is the maximum number of open tables in the table cache, which
+	// may be shared with other DBs.
+	Capacity int64
+}
+
 // FilterMetrics holds metrics for the filter policy
 type FilterMetrics = sstable.FilterMetrics
 
@@ -90,6 +102,13 @@ type LevelMetrics struct {
 		// LevelMetrics.format, but are available to sophisticated clients.
 		BytesWrittenDataBlocks  uint64
 		BytesWrittenValueBlocks uint64
+		// The sum of TableStats.UncompressedSize (the size of the keys and
+		// values before their encoding and compression) and the sum of the
+		// sizes of the sstables in this level whose table stats are loaded.
+		// Their ratio is the compression ratio of the level (see
+		// LevelMetrics.CompressionRatio). Not printed by LevelMetrics.format.
+		UncompressedSize uint64
+		CompressedSize   uint64
 	}
 }
 
@@ -110,6 +129,30 @@ func (m *LevelMetrics) Add(u *LevelMetrics) {
 	m.Additional.BytesWrittenDataBlocks += u.Additional.BytesWrittenDataBlocks
 	m.Additional.BytesWrittenValueBlocks += u.Additional.BytesWrittenValueBlocks
 	m.Additional.ValueBlocksSize += u.Additional.ValueBlocksSize
+	m.Additional.UncompressedSize += u.Additional.UncompressedSize
+	m.Additional.CompressedSize += u.Additional.CompressedSize
+}
+
+// Subtract subtracts the metrics of u from those of the level. It is the
+// inverse of Add.
+func (m *LevelMetrics) Subtract(u *LevelMetrics) {
+	m.NumFiles -= u.NumFiles
+	m.Size -= u.Size
+	m.BytesIn -= u.BytesIn
+	m.BytesIngested -= u.BytesIngested
+	m.BytesMoved -= u.BytesMoved
+	m.BytesRead -= u.BytesRead
+	m.BytesCompacted -= u.BytesCompacted
+	m.BytesFlushed -= u.BytesFlushed
+	m.TablesCompacted -= u.TablesCompacted
+	m.TablesFlushed -= u.TablesFlushed
+	m.TablesIngested -= u.TablesIngested
+	m.TablesMoved -= u.TablesMoved
+	m.Additional.BytesWrittenDataBlocks -= u.Additional.BytesWrittenDataBlocks
+	m.Additional.BytesWrittenValueBlocks -= u.Additional.BytesWrittenValueBlocks
+	m.Additional.ValueBlocksSize -= u.Additional.ValueBlocksSize
+	m.Additional.UncompressedSize -= u.Additional.UncompressedSize
+	m.Additional.CompressedSize -= u.Additional.CompressedSize
 }
 
 // WriteAmp computes the write amplification for compactions at this
@@ -121,6 +164,17 @@ func (m *LevelMetrics) WriteAmp() float64 {
 	return float64(m.BytesFlushed+m.BytesCompacted) / float64(m.BytesIn)
 }
 
+// CompressionRatio returns the ratio of the size of the keys and values of the
+// sstables of the level before their encoding and compression to their size
+// on disk, for the sstables whose table stats are loaded, or 0 if there are
+// none.
+func (m *LevelMetrics) CompressionRatio() float64 {
+	if m.Additional.CompressedSize == 0 {
+		return 0
+	}
+	return float64(m.Additional.UncompressedSize) / float64(m.Additional.CompressedSize)
+}
+
 // format generates a string of the receiver's metrics, formatting it into the
 // supplied buffer.
 func (m *LevelMetrics) format(
@@ -155,6 +209,11 @@ func (m *LevelMetrics) format(
 // metrics reflect those operations.
 type Metrics struct {
 	BlockCache CacheMetrics
+	// BlockCacheDB holds the metrics of the block cache restricted to the
+	// blocks and memtable reservations of the DB, which differ from BlockCache
+	// when the cache is shared with other DBs. Unlike BlockCache.Count, its
+	// Count only includes blocks that hold a value.
+	BlockCacheDB CacheMetrics
 
 	Compact struct {
 		// The total number of compactions, and per-compaction type counts.
@@ -165,7 +224,11 @@ type Metrics struct {
 		MoveCount        int64
 		ReadCount        int64
 		RewriteCount     int64
-		MultiLevelCount  int64
+		// TombstoneDensityCount is the number of compactions of tables dense
+		// in point tombstones (see
+		// Options.Experimental.TombstoneDenseCompactionThreshold).
+		TombstoneDensityCount int64
+		MultiLevelCount       int64
 		// An estimate of the number of bytes that need to be compacted for the LSM
 		// to reach a stable state.
 		EstimatedDebt uint64
@@ -244,7 +307,16 @@ type Metrics struct {
 		ZombieCount int64
 	}
 
-	TableCache CacheMetrics
+	// TableCache holds the metrics of the table cache for the tables of this
+	// DB. Its type used to be CacheMetrics, which is embedded in
+	// TableCacheMetrics: existing accesses to its fields are unaffected, but
+	// code which assigns TableCache to a CacheMetrics must now use
+	// TableCache.CacheMetrics.
+	TableCache TableCacheMetrics
+
+	// SecondaryCache holds the metrics of the persistent cache of sstables on
+	// shared storage, if configured (see Options.Experimental.SecondaryCacheDir).
+	SecondaryCache objstorage.SharedCacheMetrics
 
 	// Count of the number of open sstable iterators.
 	TableIters int64
@@ -268,6 +340,54 @@ type Metrics struct {
 		BytesWritten uint64
 	}
 
+	// Remote holds the metrics of a DB opened with OpenRemoteReadOnly, and is
+	// zero for other DBs.
+	Remote struct {
+		// Lag is the replication lag: the time elapsed since the start of the
+		// last successful refresh of the view of the remote DB (see
+		// DB.RefreshRemote), or since the DB was opened. The writes made to
+		// the remote DB since then may not be visible.
+		Lag time.Duration
+		// Refreshes is the number of successful refreshes.
+		Refreshes int64
+	}
+
+	// ManifestReplica holds the metrics of the replication of the MANIFEST to
+	// shared storage (see Options.Experimental.ReplicateManifest).
+	ManifestReplica struct {
+		// Uploads is the number of successful uploads of the replica.
+		Uploads int64
+		// Failures is the number of failed uploads of the replica, which are
+		// retried.
+		Failures int64
+		// Lag is the time elapsed since the first change to the MANIFEST which
+		// is not in the replica yet, or zero if the replica is up to date.
+		Lag time.Duration
+	}
+
+	// ReplicaUploads holds the metrics of the background uploads of the
+	// replicas of the new local sstables (see
+	// Options.Experimental.ReplicaUploadConcurrency).
+	ReplicaUploads struct {
+		// Pending is the number of sstables whose replica is queued or being
+		// uploaded.
+		Pending int64
+		// Uploads is the number of replicas uploaded.
+		Uploads int64
+		// Failures is the number of replicas whose upload failed despite
+		// retries; they are uploaded by the next scrub.
+		Failures int64
+		// Dropped is the number of sstables which were not queued because
+		// too many uploads were pending; they are replicated by the next
+		// scrub.
+		Dropped int64
+	}
+
+	// OrphanedReplicas is the number of replicas of removed objects which
+	// could not be deleted from shared storage yet. Their deletion is retried
+	// by DB.Scrub.
+	OrphanedReplicas int64
+
 	LogWriter struct {
 		FsyncLatency prometheus.Histogram
 		record.LogWriterMetrics
@@ -324,6 +444,96 @@ func (m *Metrics) ReadAmp() int {
 	return int(ramp)
 }
 
+// Delta returns a copy of the metrics in which the cumulative counters (e.g.
+// the number of flushes, or the bytes written to the WAL) are replaced by their
+// increase since prev, an earlier snapshot of the metrics of the same DB. The
+// gauges (e.g. the number of files of a level, or the size of the memtables)
+// keep their current values. A counter lower than in prev was reset, e.g.
+// because the DB was reopened, and its delta is its current value.
+func (m *Metrics) Delta(prev *Metrics) *Metrics {
+	d := *m
+	deltaCache := func(c *CacheMetrics, prev *CacheMetrics) {
+		c.Hits = deltaInt64(c.Hits, prev.Hits)
+		c.Misses = deltaInt64(c.Misses, prev.Misses)
+	}
+	deltaCache(&d.BlockCache, &prev.BlockCache)
+	deltaCache(&d.BlockCacheDB, &prev.BlockCacheDB)
+	deltaCache(&d.TableCache.CacheMetrics, &prev.TableCache.CacheMetrics)
+	d.TableCache.Evictions = deltaInt64(d.TableCache.Evictions, prev.TableCache.Evictions)
+	d.SecondaryCache.Hits = deltaInt64(d.SecondaryCache.Hits, prev.SecondaryCache.Hits)
+	d.SecondaryCache.Misses = deltaInt64(d.SecondaryCache.Misses, prev.SecondaryCache.Misses)
+	d.ManifestReplica.Uploads = deltaInt64(d.ManifestReplica.Uploads, prev.ManifestReplica.Uploads)
+	d.ManifestReplica.Failures = deltaInt64(d.ManifestReplica.Failures, prev.ManifestReplica.Failures)
+	d.ReplicaUploads.Uploads = deltaInt64(d.ReplicaUploads.Uploads, prev.ReplicaUploads.Uploads)
+	d.ReplicaUploads.Failures = deltaInt64(d.ReplicaUploads.Failures, prev.ReplicaUploads.Failures)
+	d.ReplicaUploads.Dropped = deltaInt64(d.ReplicaUploads.Dropped, prev.ReplicaUploads.Dropped)
+	d.Filter.Hits = deltaInt64(d.Filter.Hits, prev.Filter.Hits)
+	d.Filter.Misses = deltaInt64(d.Filter.Misses, prev.Filter.Misses)
+
+	d.Compact.Count = deltaInt64(d.Compact.Count, prev.Compact.Count)
+	d.Compact.DefaultCount = deltaInt64(d.Compact.DefaultCount, prev.Compact.DefaultCount)
+	d.Compact.DeleteOnlyCount = deltaInt64(d.Compact.DeleteOnlyCount, prev.Compact.DeleteOnlyCount)
+	d.Compact.ElisionOnlyCount = deltaInt64(d.Compact.ElisionOnlyCount, prev.Compact.ElisionOnlyCount)
+	d.Compact.MoveCount = deltaInt64(d.Compact.MoveCount, prev.Compact.MoveCount)
+	d.Compact.ReadCount = deltaInt64(d.Compact.ReadCount, prev.Compact.ReadCount)
+	d.Compact.RewriteCount = deltaInt64(d.Compact.RewriteCount, prev.Compact.RewriteCount)
+	d.Compact.TombstoneDensityCount = deltaInt64(d.Compact.TombstoneDensityCount, prev.Compact.TombstoneDensityCount)
+	d.Compact.MultiLevelCount = deltaInt64(d.Compact.MultiLevelCount, prev.Compact.MultiLevelCount)
+
+	d.Flush.Count = deltaInt64(d.Flush.Count, prev.Flush.Count)
+	if d.Flush.WriteThroughput.Bytes >= prev.Flush.WriteThroughput.Bytes {
+		d.Flush.WriteThroughput.Subtract(prev.Flush.WriteThroughput)
+	}
+	d.Flush.AsIngestCount = deltaUint64(d.Flush.AsIngestCount, prev.Flush.AsIngestCount)
+	d.Flush.AsIngestTableCount = deltaUint64(d.Flush.AsIngestTableCount, prev.Flush.AsIngestTableCount)
+	d.Flush.AsIngestBytes = deltaUint64(d.Flush.AsIngestBytes, prev.Flush.AsIngestBytes)
+
+	for i := range d.Levels {
+		l, p := &d.Levels[i], &prev.Levels[i]
+		l.BytesIn = deltaUint64(l.BytesIn, p.BytesIn)
+		l.BytesIngested = deltaUint64(l.BytesIngested, p.BytesIngested)
+		l.BytesMoved = deltaUint64(l.BytesMoved, p.BytesMoved)
+		l.BytesRead = deltaUint64(l.BytesRead, p.BytesRead)
+		l.BytesCompacted = deltaUint64(l.BytesCompacted, p.BytesCompacted)
+		l.BytesFlushed = deltaUint64(l.BytesFlushed, p.BytesFlushed)
+		l.TablesCompacted = deltaUint64(l.TablesCompacted, p.TablesCompacted)
+		l.TablesFlushed = deltaUint64(l.TablesFlushed, p.TablesFlushed)
+		l.TablesIngested = deltaUint64(l.TablesIngested, p.TablesIngested)
+		l.TablesMoved = deltaUint64(l.TablesMoved, p.TablesMoved)
+		l.Additional.BytesWrittenDataBlocks = deltaUint64(
+			l.Additional.BytesWrittenDataBlocks, p.Additional.BytesWrittenDataBlocks)
+		l.Additional.BytesWrittenValueBlocks = deltaUint64(
+			l.Additional.BytesWrittenValueBlocks, p.Additional.BytesWrittenValueBlocks)
+	}
+
+	d.WAL.BytesIn = deltaUint64(d.WAL.BytesIn, prev.WAL.BytesIn)
+	d.WAL.BytesWritten = deltaUint64(d.WAL.BytesWritten, prev.WAL.BytesWritten)
+	if d.LogWriter.WriteThroughput.Bytes >= prev.LogWriter.WriteThroughput.Bytes {
+		d.LogWriter.WriteThroughput.Subtract(prev.LogWriter.WriteThroughput)
+		d.LogWriter.PendingBufferLen.Subtract(prev.LogWriter.PendingBufferLen)
+		d.LogWriter.SyncQueueLen.Subtract(prev.LogWriter.SyncQueueLen)
+	}
+	return &d
+}
+
+// deltaInt64 returns the increase of a counter from prev to cur, or cur if the
+// counter was reset.
+func deltaInt64(cur, prev int64) int64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// deltaUint64 returns the increase of a counter from prev to cur, or cur if the
+// counter was reset.
+func deltaUint64(cur, prev uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
 // Total returns the sum of the per-level metrics and WAL metrics.
 func (m *Metrics) Total() LevelMetrics {
 	var total LevelMetrics
@@ -379,7 +589,7 @@ func (m *Metrics) formatWAL(w redact.SafePrinter) {
 //	  total         1   770 B       -    56 B     0 B       0     0 B       0   826 B       1     0 B       1    14.8
 //	  flush         1                             0 B       0       0  (ingest = ingested-as-flushable, move = tables-ingested)
 //	compact         0     0 B     0 B       0                          (size == estimated-debt, score = in-progress-bytes, in = num-in-progress)
-//	  ctype         0       0       0       0       0       0       0  (default, delete, elision, move, read, rewrite, multi-level)
+//	  ctype         0       0       0       0       0       0       0       0  (default, delete, elision, move, read, rewrite, tombstone, multi-level)
 //	 memtbl         1   256 K
 //	zmemtbl         1   256 K
 //	   ztbl         0     0 B
@@ -462,15 +672,16 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(m.Compact.NumInProgress),
 		redact.SafeString(strings.Repeat(" ", 24)),
 		redact.SafeString(`(size == estimated-debt, score = in-progress-bytes, in = num-in-progress)`))
-	w.Printf("  ctype %9d %7d %7d %7d %7d %7d %7d  %s\n",
+	w.Printf("  ctype %9d %7d %7d %7d %7d %7d %7d %7d  %s\n",
 		redact.Safe(m.Compact.DefaultCount),
 		redact.Safe(m.Compact.DeleteOnlyCount),
 		redact.Safe(m.Compact.ElisionOnlyCount),
 		redact.Safe(m.Compact.MoveCount),
 		redact.Safe(m.Compact.ReadCount),
 		redact.Safe(m.Compact.RewriteCount),
+		redact.Safe(m.Compact.TombstoneDensityCount),
 		redact.Safe(m.Compact.MultiLevelCount),
-		redact.SafeString(`(default, delete, elision, move, read, rewrite, multi-level)`))
+		redact.SafeString(`(default, delete, elision, move, read, rewrite, tombstone, multi-level)`))
 	w.Printf(" memtbl %9d %7s\n",
 		redact.Safe(m.MemTable.Count),
 		humanize.IEC.Uint64(m.MemTable.Size))
@@ -481,7 +692,7 @@ func (m *Metrics) SafeFormat(w redact.SafePrinter, _ rune) {
 		redact.Safe(m.Table.ZombieCount),
 		humanize.IEC.Uint64(m.Table.ZombieSize))
 	formatCacheMetrics(w, &m.BlockCache, "bcache")
-	formatCacheMetrics(w, &m.TableCache, "tcache")
+	formatCacheMetrics(w, &m.TableCache.CacheMetrics, "tcache")
 	w.Printf("  snaps %9d %7s %7d  (score == earliest seq num)\n",
 		redact.Safe(m.Snapshots.Count),
 		notApplicable,
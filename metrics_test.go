@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/pebble/internal/humanize"
@@ -32,6 +34,7 @@ func TestMetricsFormat(t *testing.T) {
 	m.Compact.ReadCount = 31
 	m.Compact.RewriteCount = 32
 	m.Compact.MultiLevelCount = 33
+	m.Compact.TombstoneDensityCount = 37
 	m.Compact.EstimatedDebt = 6
 	m.Compact.InProgressBytes = 7
 	m.Compact.NumInProgress = 2
@@ -92,7 +95,7 @@ __level_____count____size___score______in__ingest(sz_cnt)____move(sz_cnt)___writ
   total      2807   2.7 K       -   2.8 K   2.8 K   2.9 K   2.8 K   2.9 K   8.4 K   5.7 K   2.8 K      28     3.0
   flush         8                            34 B      35      36  (ingest = tables-ingested, move = ingested-as-flushable)
 compact         5     6 B     7 B       2                          (size == estimated-debt, score = in-progress-bytes, in = num-in-progress)
-  ctype        27      28      29      30      31      32      33  (default, delete, elision, move, read, rewrite, multi-level)
+  ctype        27      28      29      30      31      32      37      33  (default, delete, elision, move, read, rewrite, tombstone, multi-level)
  memtbl        12    11 B
 zmemtbl        14    13 B
    ztbl        16    15 B
@@ -287,7 +290,7 @@ __level_____count____size___score______in__ingest(sz_cnt)____move(sz_cnt)___writ
   total         0     0 B       -     0 B     0 B       0     0 B       0     0 B       0     0 B       0     0.0
   flush         0                             0 B       0       0  (ingest = tables-ingested, move = ingested-as-flushable)
 compact         0     0 B     0 B       0                          (size == estimated-debt, score = in-progress-bytes, in = num-in-progress)
-  ctype         0       0       0       0       0       0       0  (default, delete, elision, move, read, rewrite, multi-level)
+  ctype         0       0       0       0       0       0       0       0  (default, delete, elision, move, read, rewrite, tombstone, multi-level)
  memtbl         0     0 B
 zmemtbl         0     0 B
    ztbl         0     0 B
@@ -322,3 +325,123 @@ func TestMetricsWAmpDisableWAL(t *testing.T) {
 	require.Greater(t, tot.WriteAmp(), 1.0)
 	require.NoError(t, d.Close())
 }
+
+func TestMetricsDelta(t *testing.T) {
+	var prev, cur Metrics
+	prev.Flush.Count = 3
+	prev.Compact.Count = 5
+	prev.Levels[0].BytesIn = 100
+	prev.Levels[0].NumFiles = 4
+	prev.WAL.BytesIn = 200
+	cur.Flush.Count = 7
+	cur.Compact.Count = 2 // reset, e.g. the DB was reopened
+	cur.Levels[0].BytesIn = 150
+	cur.Levels[0].NumFiles = 2
+	cur.WAL.BytesIn = 200
+	cur.MemTable.Size = 1 << 20
+
+	d := cur.Delta(&prev)
+	require.EqualValues(t, 4, d.Flush.Count)
+	require.EqualValues(t, 2, d.Compact.Count)
+	require.EqualValues(t, 50, d.Levels[0].BytesIn)
+	require.EqualValues(t, 0, d.WAL.BytesIn)
+	// Gauges keep their current values.
+	require.EqualValues(t, 2, d.Levels[0].NumFiles)
+	require.EqualValues(t, 1<<20, d.MemTable.Size)
+	// The receiver is left unmodified.
+	require.EqualValues(t, 7, cur.Flush.Count)
+}
+
+func TestMetricsReported(t *testing.T) {
+	var mu sync.Mutex
+	var reports []MetricsReportInfo
+	lastReport := func() (MetricsReportInfo, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(reports) == 0 {
+			return MetricsReportInfo{}, 0
+		}
+		return reports[len(reports)-1], len(reports)
+	}
+	d, err := Open("", &Options{
+		FS:              vfs.NewMem(),
+		MetricsInterval: time.Millisecond,
+		EventListener: &EventListener{
+			MetricsReported: func(info MetricsReportInfo) {
+				mu.Lock()
+				defer mu.Unlock()
+				reports = append(reports, info)
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, n := lastReport()
+		return n > 0
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, d.Set([]byte("a"), nil, nil))
+	require.NoError(t, d.Flush())
+	require.Eventually(t, func() bool {
+		info, _ := lastReport()
+		return info.Metrics.Flush.Count == 1
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, d.Close())
+
+	// The flush is accounted for in the delta of exactly one report.
+	var flushes int64
+	for _, info := range reports {
+		require.NotNil(t, info.Delta)
+		flushes += info.Delta.Flush.Count
+	}
+	require.EqualValues(t, 1, flushes)
+}
+
+func TestMetricsCompressionRatio(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+		Levels:                      make([]LevelOptions, numLevels),
+	}
+	for i := range opts.Levels {
+		opts.Levels[i].Compression = SnappyCompression
+	}
+	opts.Levels[0].Compression = NoCompression
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write the keys twice, so that the compaction below is not a move.
+	value := []byte(strings.Repeat("compressible", 100))
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%03d", i)), value, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+
+	// The L0 sstables are not compressed.
+	m := d.Metrics()
+	require.EqualValues(t, m.Levels[0].Size, m.Levels[0].Additional.CompressedSize)
+	require.Greater(t, m.Levels[0].CompressionRatio(), 0.9)
+	require.Less(t, m.Levels[0].CompressionRatio(), 1.1)
+
+	require.NoError(t, d.Compact([]byte("key"), []byte("key999"), false /* parallelize */))
+	d.mu.Lock()
+	d.waitTableStats()
+	d.mu.Unlock()
+	m = d.Metrics()
+	require.Zero(t, m.Levels[0].CompressionRatio())
+	require.EqualValues(t, m.Levels[6].Size, m.Levels[6].Additional.CompressedSize)
+	require.Greater(t, m.Levels[6].CompressionRatio(), 5.0)
+	total := m.Total()
+	require.Equal(t, m.Levels[6].CompressionRatio(), total.CompressionRatio())
+
+	tables, err := d.SSTables(WithProperties())
+	require.NoError(t, err)
+	require.Len(t, tables[6], 1)
+	require.Equal(t, m.Levels[6].CompressionRatio(), tables[6][0].CompressionRatio())
+}
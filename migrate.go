@@ -0,0 +1,212 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// defaultMigrationChunkSize is the default size of the chunks of keys sorted
+// in memory by MigrateComparer.
+const defaultMigrationChunkSize = 64 << 20 // 64 MB
+
+// migrateOptions hold the optional parameters of MigrateComparer.
+type migrateOptions struct {
+	transform func(dst, key []byte) ([]byte, error)
+	chunkSize int
+}
+
+// MigrateOption sets optional parameters used by MigrateComparer.
+type MigrateOption func(*migrateOptions)
+
+// WithKeyTransform sets the function which converts the user keys of the
+// source DB to the encoding of the destination DB. The function appends the
+// new key to dst and returns the result; key must not be retained. The
+// function must map distinct keys to distinct keys. By default, the keys are
+// copied unchanged, which is useful to only change the Comparer, e.g. to
+// change the Split function.
+func WithKeyTransform(fn func(dst, key []byte) ([]byte, error)) MigrateOption {
+	return func(opt *migrateOptions) {
+		opt.transform = fn
+	}
+}
+
+// WithMigrationChunkSize sets the number of bytes of keys and values that
+// MigrateComparer sorts in memory at a time, and writes to each sstable. The
+// default is 64MB.
+func WithMigrationChunkSize(bytes int) MigrateOption {
+	return func(opt *migrateOptions) {
+		opt.chunkSize = bytes
+	}
+}
+
+// MigrationStats describes the result of MigrateComparer.
+type MigrationStats struct {
+	// Keys is the number of keys migrated.
+	Keys uint64
+	// Bytes is the number of bytes of keys and values migrated, after the
+	// keys were transformed.
+	Bytes uint64
+	// Tables is the number of sstables ingested into the destination DB.
+	Tables int
+}
+
+// MigrateComparer rewrites the DB in srcDir, opened with srcOpts, into a new
+// DB in dstDir, created with dstOpts, typically with a different Comparer. The
+// keys of the source DB can be converted to a new encoding with
+// WithKeyTransform. The source DB is opened read-only, and must not be in use.
+// The destination DB must not exist.
+//
+// Only the live point keys of the source DB are migrated: deleted keys and
+// range deletions are dropped, and the operands of merges are merged with the
+// source's Merger. Migrating a DB containing range keys is not supported,
+// since their bounds cannot be transformed in general.
+//
+// The keys are read in chunks (see WithMigrationChunkSize), each of which is
+// sorted under the new Comparer, written to an sstable and ingested into the
+// destination DB; the resulting sstables are merged by compactions over time.
+// If an error is returned, the contents of dstDir are undefined and should be
+// removed.
+func MigrateComparer(
+	srcDir string, srcOpts *Options, dstDir string, dstOpts *Options, opts ...MigrateOption,
+) (stats MigrationStats, err error) {
+	opt := &migrateOptions{chunkSize: defaultMigrationChunkSize}
+	for _, fn := range opts {
+		fn(opt)
+	}
+	if opt.transform == nil {
+		opt.transform = func(dst, key []byte) ([]byte, error) {
+			return append(dst, key...), nil
+		}
+	}
+
+	srcOpts = srcOpts.Clone()
+	srcOpts.ReadOnly = true
+	srcOpts.ErrorIfNotExists = true
+	src, err := Open(srcDir, srcOpts)
+	if err != nil {
+		return MigrationStats{}, err
+	}
+	defer func() { err = firstError(err, src.Close()) }()
+
+	dstOpts = dstOpts.Clone()
+	dstOpts.ErrorIfExists = true
+	dst, err := Open(dstDir, dstOpts)
+	if err != nil {
+		return MigrationStats{}, err
+	}
+	defer func() { err = firstError(err, dst.Close()) }()
+
+	m := &migration{
+		dst:    dst,
+		dstDir: dstDir,
+		stats:  &stats,
+	}
+	iterOpts := &IterOptions{}
+	if src.FormatMajorVersion() >= FormatRangeKeys {
+		iterOpts.KeyTypes = IterKeyTypePointsAndRanges
+	}
+	iter := src.NewIter(iterOpts)
+	defer func() { err = firstError(err, iter.Close()) }()
+	for valid := iter.First(); valid; valid = iter.Next() {
+		if _, hasRange := iter.HasPointAndRange(); hasRange {
+			return stats, errors.Errorf("pebble: cannot migrate range key at %s",
+				src.opts.Comparer.FormatKey(iter.Key()))
+		}
+		start := len(m.buf)
+		m.buf, err = opt.transform(m.buf, iter.Key())
+		if err != nil {
+			return stats, err
+		}
+		keyEnd := len(m.buf)
+		m.buf = append(m.buf, iter.Value()...)
+		m.entries = append(m.entries, migrationEntry{start: start, keyEnd: keyEnd, end: len(m.buf)})
+		if len(m.buf) >= opt.chunkSize {
+			if err := m.flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return stats, err
+	}
+	return stats, m.flush()
+}
+
+// migration holds the state of MigrateComparer.
+type migration struct {
+	dst    *DB
+	dstDir string
+	stats  *MigrationStats
+	// buf holds the keys and values of the current chunk, which are
+	// referenced by entries.
+	buf     []byte
+	entries []migrationEntry
+}
+
+// migrationEntry is a key and value in migration.buf, at [start, keyEnd) and
+// [keyEnd, end) respectively.
+type migrationEntry struct {
+	start, keyEnd, end int
+}
+
+// flush sorts the current chunk under the destination's Comparer, writes it to
+// an sstable and ingests it into the destination DB.
+func (m *migration) flush() (err error) {
+	if len(m.entries) == 0 {
+		return nil
+	}
+	cmp := m.dst.cmp
+	key := func(e migrationEntry) []byte { return m.buf[e.start:e.keyEnd] }
+	sort.Slice(m.entries, func(i, j int) bool {
+		return cmp(key(m.entries[i]), key(m.entries[j])) < 0
+	})
+
+	fs := m.dst.opts.FS
+	path := fs.PathJoin(m.dstDir, fmt.Sprintf("migrate-%06d.sst", m.stats.Tables))
+	f, err := fs.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// On success, Ingest removes the sstable.
+		if err != nil {
+			_ = fs.Remove(path)
+		}
+	}()
+	w := sstable.NewWriter(
+		objstorage.NewFileWritable(f),
+		m.dst.MakeWriterOptions(0),
+	)
+	for i, e := range m.entries {
+		if i > 0 && cmp(key(m.entries[i-1]), key(e)) == 0 {
+			_ = w.Close()
+			return errors.Errorf("pebble: several keys were transformed into %s",
+				m.dst.opts.Comparer.FormatKey(key(e)))
+		}
+		if err := w.Set(key(e), m.buf[e.keyEnd:e.end]); err != nil {
+			_ = w.Close()
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := m.dst.Ingest([]string{path}); err != nil {
+		return err
+	}
+
+	m.stats.Keys += uint64(len(m.entries))
+	m.stats.Bytes += uint64(len(m.buf))
+	m.stats.Tables++
+	m.buf = m.buf[:0]
+	m.entries = m.entries[:0]
+	return nil
+}
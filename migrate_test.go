@@ -0,0 +1,86 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateComparer(t *testing.T) {
+	mem := vfs.NewMem()
+	src, err := Open("src", &Options{FS: mem})
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, src.Set([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprint(i)), nil))
+		if i%300 == 0 {
+			require.NoError(t, src.Flush())
+		}
+	}
+	require.NoError(t, src.Delete([]byte("0005"), nil))
+	require.NoError(t, src.DeleteRange([]byte("0100"), []byte("0200"), nil))
+	require.NoError(t, src.Close())
+
+	// The destination orders the keys in reverse, and prefixes them with "k".
+	reverse := *DefaultComparer
+	reverse.Name = "reverse"
+	reverse.Compare = func(a, b []byte) int { return bytes.Compare(b, a) }
+	dstOpts := &Options{FS: mem, Comparer: &reverse}
+	transform := WithKeyTransform(func(dst, key []byte) ([]byte, error) {
+		return append(append(dst, 'k'), key...), nil
+	})
+	stats, err := MigrateComparer("src", &Options{FS: mem}, "dst", dstOpts,
+		transform, WithMigrationChunkSize(1000))
+	require.NoError(t, err)
+	require.EqualValues(t, 899, stats.Keys)
+	require.Greater(t, stats.Tables, 1)
+
+	dst, err := Open("dst", dstOpts)
+	require.NoError(t, err)
+	iter := dst.NewIter(nil)
+	var keys []string
+	for iter.First(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+		var i int
+		_, err := fmt.Sscanf(string(iter.Key()), "k%04d", &i)
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprint(i), string(iter.Value()))
+	}
+	require.NoError(t, iter.Close())
+	require.Len(t, keys, 899)
+	require.Equal(t, "k0999", keys[0])
+	require.Equal(t, "k0000", keys[len(keys)-1])
+	require.NotContains(t, keys, "k0005")
+	require.NotContains(t, keys, "k0150")
+	require.NoError(t, dst.Close())
+
+	// The destination must not exist.
+	_, err = MigrateComparer("src", &Options{FS: mem}, "dst", dstOpts)
+	require.ErrorIs(t, err, ErrDBAlreadyExists)
+
+	// Distinct keys must be transformed into distinct keys.
+	_, err = MigrateComparer("src", &Options{FS: mem}, "dst2", dstOpts,
+		WithKeyTransform(func(dst, key []byte) ([]byte, error) {
+			return append(dst, key[:3]...), nil
+		}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "several keys were transformed into")
+
+	// Range keys cannot be migrated.
+	split := *DefaultComparer
+	split.Split = func(a []byte) int { return len(a) }
+	srcOpts := &Options{FS: mem, Comparer: &split, FormatMajorVersion: FormatNewest}
+	src, err = Open("src", srcOpts)
+	require.NoError(t, err)
+	require.NoError(t, src.RangeKeySet([]byte("0001"), []byte("0002"), nil, []byte("v"), nil))
+	require.NoError(t, src.Close())
+	_, err = MigrateComparer("src", srcOpts, "dst3", dstOpts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot migrate range key")
+}
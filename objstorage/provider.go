@@ -2,13 +2,24 @@
 // of this source code is governed by a BSD-style license that can be found in
 // the LICENSE file.
 
+// Package objstorage implements the storage of the objects of a DB (currently
+// its sstables) on the local filesystem and, optionally, on shared storage
+// (e.g. a blob storage service such as S3, or a directory of a network
+// filesystem; see shared.Storage). The Provider is the single entry point
+// used to create, open, link and remove objects: the code that writes,
+// ingests and reads sstables addresses objects by file number, regardless of
+// where they are stored.
 package objstorage
 
 import (
 	"context"
+	"io"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/errors/oserror"
@@ -28,7 +39,14 @@ import (
 // created by the provider, or existing objects the Provider was informed about
 // via AddObjects.
 //
-// Objects are currently backed by a vfs.File.
+// Objects are backed either by a vfs.File in Settings.FSDirName (local
+// objects) or by an object of Settings.Shared.Storage (shared objects), which
+// may be a cloud blob storage service; see shared.Storage for the interface
+// which storage backends implement, and shared.NewLocalFS for a backend
+// storing objects in a directory of a vfs.FS. Shared objects are created by
+// Create with CreateOptions.PreferSharedStorage, or by LinkOrCopyFromLocal
+// with LinkOrCopyOptions.PreferSharedStorage, and are read through the same
+// Readable interface as local objects.
 type Provider struct {
 	st Settings
 
@@ -36,6 +54,10 @@ type Provider struct {
 
 	shared sharedSubsystem
 
+	// replicasDeduplicated is the number of replicas uploaded whose contents
+	// were already on shared storage.
+	replicasDeduplicated atomic.Int64
+
 	mu struct {
 		sync.RWMutex
 
@@ -43,6 +65,9 @@ type Provider struct {
 			// catalogBatch accumulates shared object creations and deletions until
 			// Sync is called.
 			catalogBatch sharedobjcat.Batch
+			// replicaRemovals accumulates the local objects removed whose
+			// replica was not removed yet (see FlushReplicaRemovals).
+			replicaRemovals []ObjectMetadata
 		}
 
 		// localObjectsChanged is set if non-shared objects were created or deleted
@@ -52,6 +77,15 @@ type Provider struct {
 		// knownObjects maintains information about objects that are known to the provider.
 		// It is initialized with the list of files in the manifest when we open a DB.
 		knownObjects map[base.FileNum]ObjectMetadata
+
+		// orphanedReplicas maintains the local objects which were removed but
+		// whose replica could not be deleted from shared storage (see
+		// DeleteOrphanedReplicas).
+		orphanedReplicas map[base.FileNum]ObjectMetadata
+
+		// bytesPerSync is initialized from Settings.BytesPerSync and can be
+		// changed at runtime through SetBytesPerSync.
+		bytesPerSync int
 	}
 }
 
@@ -172,10 +206,92 @@ type Settings struct {
 	// out a large chunk of dirty filesystem buffers.
 	BytesPerSync int
 
+	// DirectIOWrites enables direct (unbuffered) I/O for writing local
+	// sstables, when supported by the FS (see vfs.SetDirectIO). This avoids
+	// polluting the OS page cache with the output of flushes and compactions.
+	DirectIOWrites bool
+
+	// DirectIOReads enables direct (unbuffered) I/O for reading local sstables,
+	// when supported by the FS (see vfs.SetDirectIO). Reads are not cached by
+	// the OS and do not use read-ahead.
+	DirectIOReads bool
+
 	// Fields here are set only if the provider is to support shared objects
 	// (experimental).
 	Shared struct {
 		Storage shared.Storage
+
+		// UploadPartSize is the size of the parts in which objects are uploaded,
+		// if Storage implements shared.MultipartStorage. The default is 8MB.
+		UploadPartSize int
+
+		// MaxUploadAttempts is the number of times the upload of a part (or the
+		// completion of an upload) is attempted before giving up, if Storage
+		// implements shared.MultipartStorage. The default is 3.
+		MaxUploadAttempts int
+
+		// UploadRetryBackoff is the delay before the second attempt of the
+		// upload of a part; the delay doubles with each subsequent attempt, up
+		// to 10s. The default is 100ms.
+		UploadRetryBackoff time.Duration
+
+		// MaxConcurrentReads is the maximum number of reads from Storage in
+		// progress at any time; other reads wait for one of them to complete.
+		// The default is 0, which does not limit the reads.
+		MaxConcurrentReads int
+
+		// HedgedReadPercentile, if set (e.g. to 0.95), enables hedged reads
+		// from Storage: a read which takes longer than this percentile of the
+		// latencies of recent reads is issued again, and the first of the two
+		// reads to complete is used. This bounds the tail latency of the reads
+		// at the cost of a few extra requests. Hedged reads count towards
+		// MaxConcurrentReads, and are not issued if the limit is reached.
+		HedgedReadPercentile float64
+
+		// HedgedReadMinDelay is the minimum time after which a read is hedged
+		// (see HedgedReadPercentile). The default is 10ms.
+		HedgedReadMinDelay time.Duration
+
+		// CacheDirName, if set, enables a persistent cache of the contents of
+		// shared objects, stored in this directory of FS (which is created if
+		// necessary). The cache contents survive restarts, so that data is
+		// rarely read from Storage more than once.
+		CacheDirName string
+
+		// CacheSize is the maximum size of the cache in CacheDirName. The
+		// default is 1GB.
+		CacheSize int64
+
+		// CacheChunkSize is the size of the chunks in which objects are read
+		// from Storage and cached in CacheDirName. The default is 1MB.
+		CacheChunkSize int
+
+		// CacheMaxOpenFiles is the maximum number of chunk files of the cache
+		// in CacheDirName which are kept open (see
+		// Provider.SetSharedCacheMaxOpenFiles). The default is 0, which keeps
+		// the files of all the chunks read open.
+		CacheMaxOpenFiles int
+
+		// ReplicateLocalObjects indicates that local objects may have replicas
+		// on Storage (see UploadReplica). The replica of a local object is
+		// removed along with the object, and is read instead of the object if
+		// it is missing or corrupt (see ReplicaReadable).
+		ReplicateLocalObjects bool
+
+		// DeduplicateReplicas indicates that the contents of the replicas
+		// uploaded by UploadReplica are stored once per distinct contents:
+		// uploading the replica of an object whose contents were already
+		// uploaded (e.g. an sstable moved by a compaction under a new file
+		// number) only writes a small reference to the existing contents. The
+		// contents are never deleted from Storage, even when no replica
+		// references them anymore.
+		DeduplicateReplicas bool
+
+		// OnReplicaFallback, if set, is called when data of a local object is
+		// read from its replica, because the local object is missing or the
+		// data read from it is corrupt (see ReplicaReadable). err is the error
+		// encountered with the local object.
+		OnReplicaFallback func(meta ObjectMetadata, err error)
 	}
 }
 
@@ -235,6 +351,7 @@ func Open(settings Settings) (p *Provider, _ error) {
 		fsDir: fsDir,
 	}
 	p.mu.knownObjects = make(map[base.FileNum]ObjectMetadata)
+	p.mu.bytesPerSync = settings.BytesPerSync
 
 	// Add local FS objects.
 	if err := p.vfsInit(); err != nil {
@@ -251,11 +368,16 @@ func Open(settings Settings) (p *Provider, _ error) {
 
 // Close the provider.
 func (p *Provider) Close() error {
+	p.FlushReplicaRemovals()
 	var err error
 	if p.fsDir != nil {
 		err = p.fsDir.Close()
 		p.fsDir = nil
 	}
+	if p.shared.cache != nil {
+		err = firstError(err, p.shared.cache.Close())
+		p.shared.cache = nil
+	}
 	return err
 }
 
@@ -264,6 +386,11 @@ type OpenOptions struct {
 	// MustExist triggers a fatal error if the file does not exist. The fatal
 	// error message contains extra information helpful for debugging.
 	MustExist bool
+
+	// NoReplicaFallback disables the fallback to the replica of a local object
+	// which is missing or corrupt (see ReplicaReadable), e.g. to validate the
+	// local object itself.
+	NoReplicaFallback bool
 }
 
 // OpenForReading opens an existing object.
@@ -279,6 +406,9 @@ func (p *Provider) OpenForReading(
 	}
 
 	if !meta.IsShared() {
+		if !opts.NoReplicaFallback && p.st.Shared.ReplicateLocalObjects && p.CanReplicate() {
+			return p.openWithReplicaFallback(ctx, meta, opts)
+		}
 		return p.vfsOpenForReading(ctx, fileType, fileNum, opts)
 	}
 	return p.sharedOpenForReading(ctx, meta)
@@ -287,7 +417,8 @@ func (p *Provider) OpenForReading(
 // CreateOptions contains optional arguments for Create.
 type CreateOptions struct {
 	// PreferSharedStorage causes the object to be created on shared storage if
-	// the provider has shared storage configured.
+	// the provider has shared storage configured and the shared creator ID has
+	// been set.
 	PreferSharedStorage bool
 }
 
@@ -298,7 +429,7 @@ type CreateOptions struct {
 func (p *Provider) Create(
 	ctx context.Context, fileType base.FileType, fileNum base.FileNum, opts CreateOptions,
 ) (w Writable, meta ObjectMetadata, err error) {
-	if opts.PreferSharedStorage && p.st.Shared.Storage != nil {
+	if opts.PreferSharedStorage && p.st.Shared.Storage != nil && p.shared.initialized.Load() {
 		w, meta, err = p.sharedCreate(ctx, fileType, fileNum)
 	} else {
 		w, meta, err = p.vfsCreate(ctx, fileType, fileNum)
@@ -313,7 +444,8 @@ func (p *Provider) Create(
 
 // Remove removes an object.
 //
-// The object is not guaranteed to be durably removed until Sync is called.
+// The object is not guaranteed to be durably removed until Sync is called. The
+// replica of a local object is removed by FlushReplicaRemovals.
 func (p *Provider) Remove(fileType base.FileType, fileNum base.FileNum) error {
 	meta, err := p.Lookup(fileType, fileNum)
 	if err != nil {
@@ -322,6 +454,16 @@ func (p *Provider) Remove(fileType base.FileType, fileNum base.FileNum) error {
 
 	if !meta.IsShared() {
 		err = p.vfsRemove(fileType, fileNum)
+		// The removal of the local object is never blocked by shared storage:
+		// the replica is deleted once the local object is gone, along with the
+		// replicas of the objects removed with it (see FlushReplicaRemovals),
+		// and recorded as orphaned if it cannot be deleted.
+		if (err == nil || IsNotExistError(err)) && p.st.Shared.ReplicateLocalObjects {
+			p.queueReplicaRemoval(meta)
+		}
+	} else if p.shared.cache != nil {
+		p.shared.cache.removeObject(sharedObjectName(meta))
+		p.shared.cache.removeObject(legacySharedObjectName(meta))
 	}
 	// TODO(radu): implement shared object removal (i.e. deref).
 
@@ -341,6 +483,13 @@ func IsNotExistError(err error) bool {
 	return oserror.IsNotExist(err)
 }
 
+// IsTooManyOpenFilesError indicates whether the error reports that a file
+// could not be opened because the process or the system has too many open
+// files.
+func IsTooManyOpenFilesError(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
 // Sync flushes the metadata from creation or removal of objects since the last Sync.
 func (p *Provider) Sync() error {
 	if err := p.vfsSync(); err != nil {
@@ -352,38 +501,108 @@ func (p *Provider) Sync() error {
 	return nil
 }
 
+// LinkOrCopyOptions contains optional arguments for LinkOrCopyFromLocal.
+type LinkOrCopyOptions struct {
+	// ForceCopy causes the file to be copied even if it could be hard linked.
+	ForceCopy bool
+	// PreferSharedStorage causes the file to be copied to a new object on
+	// shared storage if the provider has shared storage configured and the
+	// shared creator ID has been set.
+	PreferSharedStorage bool
+}
+
 // LinkOrCopyFromLocal creates a new object that is either a copy of a given
-// local file or a hard link (if the new object is created on the same FS, and
-// if the FS supports it).
+// local file or a hard link (if the new object is created on the same FS, if
+// the FS supports it, and unless a copy is required by the options). It
+// returns whether the object is a hard link.
 //
 // The object is not guaranteed to be durable (accessible in case of crashes)
 // until Sync is called.
 func (p *Provider) LinkOrCopyFromLocal(
-	srcFS vfs.FS, srcFilePath string, dstFileType base.FileType, dstFileNum base.FileNum,
-) (ObjectMetadata, error) {
-	if srcFS == p.st.FS {
-		// Wrap the normal filesystem with one which wraps newly created files with
-		// vfs.NewSyncingFile.
-		fs := vfs.NewSyncingFS(p.st.FS, vfs.SyncingFileOptions{
-			NoSyncOnClose: p.st.NoSyncOnClose,
-			BytesPerSync:  p.st.BytesPerSync,
+	ctx context.Context,
+	srcFS vfs.FS,
+	srcFilePath string,
+	dstFileType base.FileType,
+	dstFileNum base.FileNum,
+	opts LinkOrCopyOptions,
+) (_ ObjectMetadata, linked bool, _ error) {
+	toShared := opts.PreferSharedStorage && p.st.Shared.Storage != nil && p.shared.initialized.Load()
+	if srcFS != p.st.FS || toShared {
+		meta, err := p.copyFromLocal(ctx, srcFS, srcFilePath, dstFileType, dstFileNum, CreateOptions{
+			PreferSharedStorage: toShared,
 		})
-		dstPath := p.vfsPath(dstFileType, dstFileNum)
-		if err := vfs.LinkOrCopy(fs, srcFilePath, dstPath); err != nil {
-			return ObjectMetadata{}, err
-		}
+		return meta, false, err
+	}
+	// Wrap the normal filesystem with one which wraps newly created files with
+	// vfs.NewSyncingFile.
+	fs := vfs.NewSyncingFS(p.st.FS, p.syncingFileOptions())
+	dstPath := p.vfsPath(dstFileType, dstFileNum)
+	var err error
+	if opts.ForceCopy {
+		err = vfs.Copy(fs, srcFilePath, dstPath)
+	} else {
+		linked, err = vfs.LinkOrCopyLinked(fs, srcFilePath, dstPath)
+	}
+	if err != nil {
+		return ObjectMetadata{}, false, err
+	}
 
-		meta := ObjectMetadata{
-			FileNum:  dstFileNum,
-			FileType: dstFileType,
+	meta := ObjectMetadata{
+		FileNum:  dstFileNum,
+		FileType: dstFileType,
+	}
+	p.addMetadata(meta)
+	return meta, linked, nil
+}
+
+// copyFromLocal creates a new object with the contents of a local file.
+func (p *Provider) copyFromLocal(
+	ctx context.Context,
+	srcFS vfs.FS,
+	srcFilePath string,
+	dstFileType base.FileType,
+	dstFileNum base.FileNum,
+	opts CreateOptions,
+) (ObjectMetadata, error) {
+	f, err := srcFS.Open(srcFilePath)
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+	defer f.Close()
+	w, meta, err := p.Create(ctx, dstFileType, dstFileNum, opts)
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := w.Write(buf[:n]); err != nil {
+				w.Abort()
+				_ = p.Remove(dstFileType, dstFileNum)
+				return ObjectMetadata{}, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Abort()
+			_ = p.Remove(dstFileType, dstFileNum)
+			return ObjectMetadata{}, err
 		}
-		p.addMetadata(meta)
-		return meta, nil
 	}
-	// TODO(radu): for the copy case, we should use `p.Create` and do the copy ourselves.
-	panic("unimplemented")
+	if err := w.Finish(); err != nil {
+		_ = p.Remove(dstFileType, dstFileNum)
+		return ObjectMetadata{}, err
+	}
+	return meta, nil
 }
 
+// copyBufferSize is the size of the buffer used to copy local files to new
+// objects.
+const copyBufferSize = 256 << 10 // 256 KB
+
 // Lookup returns the metadata of an object that is already known to the Provider.
 // Does not perform any I/O.
 func (p *Provider) Lookup(fileType base.FileType, fileNum base.FileNum) (ObjectMetadata, error) {
@@ -437,6 +656,52 @@ func (p *Provider) List() []ObjectMetadata {
 	return res
 }
 
+// AddObjects informs the provider of existing local objects which it did not
+// create, e.g. objects written to Settings.FSDirName by another process. The
+// objects which are already known are ignored. Shared objects are not
+// supported.
+func (p *Provider) AddObjects(objs []ObjectMetadata) error {
+	for _, meta := range objs {
+		if meta.IsShared() {
+			return errors.AssertionFailedf("pebble: cannot add shared object %s", errors.Safe(meta.FileNum))
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, meta := range objs {
+		if _, ok := p.mu.knownObjects[meta.FileNum]; !ok {
+			p.mu.knownObjects[meta.FileNum] = meta
+		}
+	}
+	return nil
+}
+
+// SetSharedCacheMaxOpenFiles changes the maximum number of open chunk files of
+// the cache of shared objects (see Settings.Shared.CacheMaxOpenFiles). It is a
+// no-op if there is no such cache.
+func (p *Provider) SetSharedCacheMaxOpenFiles(maxOpenFiles int) {
+	if p.shared.cache != nil {
+		p.shared.cache.setMaxOpenFiles(maxOpenFiles)
+	}
+}
+
+// SetBytesPerSync changes the BytesPerSync setting. The new value applies to
+// objects created after the call returns.
+func (p *Provider) SetBytesPerSync(bytesPerSync int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.bytesPerSync = bytesPerSync
+}
+
+func (p *Provider) syncingFileOptions() vfs.SyncingFileOptions {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return vfs.SyncingFileOptions{
+		NoSyncOnClose: p.st.NoSyncOnClose,
+		BytesPerSync:  p.mu.bytesPerSync,
+	}
+}
+
 func (p *Provider) addMetadata(meta ObjectMetadata) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
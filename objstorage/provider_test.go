@@ -7,10 +7,13 @@ package objstorage
 import (
 	"context"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/objstorage/shared"
 	"github.com/cockroachdb/pebble/vfs"
@@ -103,6 +106,7 @@ func TestProvider(t *testing.T) {
 				if err != nil {
 					return err.Error()
 				}
+				defer r.Close()
 				data := make([]byte, int(r.Size()))
 				n, err := r.ReadAt(ctx, data, 0)
 				require.NoError(t, err)
@@ -184,3 +188,368 @@ func TestNotExistError(t *testing.T) {
 	require.NoError(t, fs.Remove(base.MakeFilename(base.FileTypeTable, 1)))
 	require.True(t, IsNotExistError(provider.Remove(base.FileTypeTable, 1)))
 }
+
+func TestRecoverSharedObjects(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	st := DefaultSettings(fs, "")
+	st.Shared.Storage = shared.NewInMem()
+	open := func() *Provider {
+		p, err := Open(st)
+		require.NoError(t, err)
+		require.NoError(t, p.SetCreatorID(1))
+		return p
+	}
+
+	p := open()
+	for _, fileNum := range []base.FileNum{1, 2} {
+		w, meta, err := p.Create(ctx, base.FileTypeTable, fileNum, CreateOptions{PreferSharedStorage: true})
+		require.NoError(t, err)
+		require.True(t, meta.IsShared())
+		require.NoError(t, w.Write([]byte("foo")))
+		require.NoError(t, w.Finish())
+	}
+	require.NoError(t, p.Sync())
+	recovered, err := p.RecoverSharedObjects()
+	require.NoError(t, err)
+	require.Empty(t, recovered)
+	require.NoError(t, p.Close())
+
+	// Lose the catalog and its marker, and set the creator ID again.
+	ls, err := fs.List("")
+	require.NoError(t, err)
+	for _, name := range ls {
+		if strings.Contains(strings.ToLower(name), "shared-catalog") {
+			require.NoError(t, fs.Remove(name))
+		}
+	}
+	p = open()
+	require.Empty(t, p.List())
+	recovered, err = p.RecoverSharedObjects()
+	require.NoError(t, err)
+	require.Len(t, recovered, 2)
+	require.NoError(t, p.Sync())
+	require.NoError(t, p.Close())
+
+	// The recovered objects were persisted in the catalog.
+	p = open()
+	defer p.Close()
+	require.Len(t, p.List(), 2)
+	r, err := p.OpenForReading(ctx, base.FileTypeTable, 2, OpenOptions{})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, r.Size())
+	require.NoError(t, r.Close())
+}
+
+func TestLinkOrCopyFromLocal(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	st := DefaultSettings(fs, "")
+	st.Shared.Storage = shared.NewLocalFS(fs, "shared")
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NoError(t, p.SetCreatorID(1))
+
+	writeFile := func(fs vfs.FS, path, data string) {
+		f, err := fs.Create(path)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	readObject := func(fileNum base.FileNum) string {
+		r, err := p.OpenForReading(ctx, base.FileTypeTable, fileNum, OpenOptions{})
+		require.NoError(t, err)
+		defer r.Close()
+		buf := make([]byte, r.Size())
+		_, err = r.ReadAt(ctx, buf, 0)
+		require.NoError(t, err)
+		return string(buf)
+	}
+
+	writeFile(fs, "ext1", "foo")
+	meta, linked, err := p.LinkOrCopyFromLocal(ctx, fs, "ext1", base.FileTypeTable, 1, LinkOrCopyOptions{})
+	require.NoError(t, err)
+	require.True(t, linked)
+	require.False(t, meta.IsShared())
+	require.Equal(t, "foo", readObject(1))
+
+	// A file of another FS is copied.
+	otherFS := vfs.NewMem()
+	writeFile(otherFS, "ext2", "bar")
+	meta, linked, err = p.LinkOrCopyFromLocal(ctx, otherFS, "ext2", base.FileTypeTable, 2, LinkOrCopyOptions{})
+	require.NoError(t, err)
+	require.False(t, linked)
+	require.False(t, meta.IsShared())
+	require.Equal(t, "bar", readObject(2))
+
+	// A file is copied to shared storage.
+	writeFile(fs, "ext3", "baz")
+	meta, linked, err = p.LinkOrCopyFromLocal(ctx, fs, "ext3", base.FileTypeTable, 3, LinkOrCopyOptions{
+		PreferSharedStorage: true,
+	})
+	require.NoError(t, err)
+	require.False(t, linked)
+	require.True(t, meta.IsShared())
+	require.Equal(t, "baz", readObject(3))
+	objs, err := st.Shared.Storage.List("", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{sharedObjectName(meta)}, objs)
+	require.NoError(t, p.Sync())
+}
+
+func TestDeduplicateReplicas(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	st := DefaultSettings(fs, "")
+	st.Shared.Storage = shared.NewLocalFS(fs, "shared")
+	st.Shared.DeduplicateReplicas = true
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NoError(t, p.SetCreatorID(1))
+
+	// Objects 1 and 2 have the same contents, object 3 differs.
+	var metas []ObjectMetadata
+	for i, data := range []string{"foo", "foo", "bar"} {
+		w, meta, err := p.Create(ctx, base.FileTypeTable, base.FileNum(i+1), CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, w.Write([]byte(data)))
+		require.NoError(t, w.Finish())
+		require.NoError(t, p.UploadReplica(ctx, meta))
+		metas = append(metas, meta)
+	}
+	require.Equal(t, int64(1), p.ReplicasDeduplicated())
+	contents, err := st.Shared.Storage.List(replicaContentPrefix, "")
+	require.NoError(t, err)
+	require.Len(t, contents, 2)
+	replicas, err := p.ListReplicas(base.FileTypeTable)
+	require.NoError(t, err)
+	require.Len(t, replicas, 3)
+	// The shared contents are only counted once.
+	usage, err := p.SharedUsage()
+	require.NoError(t, err)
+	require.Equal(t, SharedUsage{Objects: 3, Bytes: int64(len("foo") + len("bar"))}, usage)
+
+	readReplica := func(meta ObjectMetadata) string {
+		r, err := p.OpenReplicaForReading(ctx, meta)
+		require.NoError(t, err)
+		defer r.Close()
+		buf := make([]byte, r.Size())
+		_, err = r.ReadAt(ctx, buf, 0)
+		require.NoError(t, err)
+		return string(buf)
+	}
+	require.Equal(t, "foo", readReplica(metas[0]))
+	require.Equal(t, "foo", readReplica(metas[1]))
+	require.Equal(t, "bar", readReplica(metas[2]))
+
+	// Restoring follows the reference to the contents.
+	require.NoError(t, fs.Remove(p.vfsPath(base.FileTypeTable, 2)))
+	require.NoError(t, p.RestoreFromReplica(ctx, metas[1]))
+	f, err := fs.Open(p.vfsPath(base.FileTypeTable, 2))
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, "foo", string(data))
+}
+
+func TestLegacySharedObjectNames(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	st := DefaultSettings(fs, "")
+	st.Shared.Storage = shared.NewInMem()
+	st.Shared.ReplicateLocalObjects = true
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NoError(t, p.SetCreatorID(1))
+
+	// moveToLegacyName renames an object of the storage after the legacy
+	// layout.
+	moveToLegacyName := func(meta ObjectMetadata) {
+		objName := sharedObjectName(meta)
+		rc, _, err := st.Shared.Storage.ReadObjectAt(objName, 0)
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		w, err := st.Shared.Storage.CreateObject(legacySharedObjectName(meta))
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, st.Shared.Storage.Delete(objName))
+	}
+	readAll := func(r Readable) string {
+		buf := make([]byte, r.Size())
+		_, err := r.ReadAt(ctx, buf, 0)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		return string(buf)
+	}
+
+	// A shared object named in the legacy layout can be read.
+	w, meta, err := p.Create(ctx, base.FileTypeTable, 1, CreateOptions{PreferSharedStorage: true})
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]byte("shared")))
+	require.NoError(t, w.Finish())
+	require.Equal(t, "00000000000000000001/sst/000001.sst", sharedObjectName(meta))
+	moveToLegacyName(meta)
+	r, err := p.OpenForReading(ctx, base.FileTypeTable, 1, OpenOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "shared", readAll(r))
+
+	// So can a replica named in the legacy layout, which is replaced when the
+	// replica is uploaded again.
+	w, meta, err = p.Create(ctx, base.FileTypeTable, 2, CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]byte("local")))
+	require.NoError(t, w.Finish())
+	require.NoError(t, p.UploadReplica(ctx, meta))
+	replica, err := p.replicaMetadata(meta)
+	require.NoError(t, err)
+	moveToLegacyName(replica)
+	replicas, err := p.ListReplicas(base.FileTypeTable)
+	require.NoError(t, err)
+	require.Equal(t, map[base.FileNum]struct{}{1: {}, 2: {}}, replicas)
+	r, err = p.OpenReplicaForReading(ctx, meta)
+	require.NoError(t, err)
+	require.Equal(t, "local", readAll(r))
+	require.NoError(t, p.UploadReplica(ctx, meta))
+	objs, err := st.Shared.Storage.List("", "")
+	require.NoError(t, err)
+	sort.Strings(objs)
+	require.Equal(t, []string{
+		"00000000000000000001-000001.sst",
+		"00000000000000000001/sst/000002.sst",
+	}, objs)
+}
+
+// failingDeleteStorage is a shared.Storage whose deletions fail while
+// failDeletes is set.
+type failingDeleteStorage struct {
+	shared.Storage
+	failDeletes bool
+}
+
+func (s *failingDeleteStorage) Delete(objName string) error {
+	if s.failDeletes {
+		return errors.New("delete failed")
+	}
+	return s.Storage.Delete(objName)
+}
+
+func TestOrphanedReplicas(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	storage := &failingDeleteStorage{Storage: shared.NewInMem()}
+	st := DefaultSettings(fs, "")
+	st.Shared.Storage = storage
+	st.Shared.ReplicateLocalObjects = true
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NoError(t, p.SetCreatorID(1))
+
+	w, meta, err := p.Create(ctx, base.FileTypeTable, 1, CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, w.Write([]byte("foo")))
+	require.NoError(t, w.Finish())
+	require.NoError(t, p.UploadReplica(ctx, meta))
+
+	// The local object is removed even though its replica cannot be deleted.
+	storage.failDeletes = true
+	require.NoError(t, p.Remove(base.FileTypeTable, 1))
+	_, err = fs.Stat(p.vfsPath(base.FileTypeTable, 1))
+	require.True(t, IsNotExistError(err))
+	p.FlushReplicaRemovals()
+	require.Equal(t, 1, p.OrphanedReplicas())
+	deleted, remaining := p.DeleteOrphanedReplicas()
+	require.Equal(t, 0, deleted)
+	require.Equal(t, 1, remaining)
+
+	storage.failDeletes = false
+	deleted, remaining = p.DeleteOrphanedReplicas()
+	require.Equal(t, 1, deleted)
+	require.Equal(t, 0, remaining)
+	require.Equal(t, 0, p.OrphanedReplicas())
+	replicas, err := p.ListReplicas(base.FileTypeTable)
+	require.NoError(t, err)
+	require.Empty(t, replicas)
+}
+
+// batchDeleteStorage is a shared.BatchDeleteStorage which records the batch
+// deletions, and fails the first deletion of the objects in failOnce.
+type batchDeleteStorage struct {
+	shared.BatchDeleteStorage
+	batches  [][]string
+	failOnce map[string]bool
+}
+
+func (s *batchDeleteStorage) DeleteObjects(objNames []string) []error {
+	s.batches = append(s.batches, append([]string(nil), objNames...))
+	var errs []error
+	for i, name := range objNames {
+		if s.failOnce[name] {
+			delete(s.failOnce, name)
+			if errs == nil {
+				errs = make([]error, len(objNames))
+			}
+			errs[i] = errors.New("delete failed")
+			continue
+		}
+		_ = s.BatchDeleteStorage.DeleteObjects([]string{name})
+	}
+	return errs
+}
+
+func TestBatchReplicaRemoval(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	storage := &batchDeleteStorage{
+		BatchDeleteStorage: shared.NewInMem().(shared.BatchDeleteStorage),
+		failOnce:           map[string]bool{"00000000000000000001/sst/000002.sst": true},
+	}
+	st := DefaultSettings(fs, "")
+	st.Shared.Storage = storage
+	st.Shared.ReplicateLocalObjects = true
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NoError(t, p.SetCreatorID(1))
+
+	for fileNum := base.FileNum(1); fileNum <= 3; fileNum++ {
+		w, meta, err := p.Create(ctx, base.FileTypeTable, fileNum, CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, w.Write([]byte("foo")))
+		require.NoError(t, w.Finish())
+		require.NoError(t, p.UploadReplica(ctx, meta))
+	}
+	for fileNum := base.FileNum(1); fileNum <= 3; fileNum++ {
+		require.NoError(t, p.Remove(base.FileTypeTable, fileNum))
+	}
+	require.Empty(t, storage.batches)
+
+	// Syncing does not depend on shared storage.
+	require.NoError(t, p.Sync())
+	require.Empty(t, storage.batches)
+
+	// The replicas are removed with a single request, and the deletion which
+	// failed is retried.
+	p.FlushReplicaRemovals()
+	require.Equal(t, [][]string{
+		{
+			"00000000000000000001/sst/000001.sst", "00000000000000000001-000001.sst",
+			"00000000000000000001/sst/000002.sst", "00000000000000000001-000002.sst",
+			"00000000000000000001/sst/000003.sst", "00000000000000000001-000003.sst",
+		},
+		{"00000000000000000001/sst/000002.sst"},
+	}, storage.batches)
+	require.Zero(t, p.OrphanedReplicas())
+	replicas, err := p.ListReplicas(base.FileTypeTable)
+	require.NoError(t, err)
+	require.Empty(t, replicas)
+}
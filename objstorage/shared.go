@@ -7,11 +7,15 @@ package objstorage
 import (
 	"context"
 	"fmt"
+	"io"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
 	"github.com/cockroachdb/pebble/objstorage/sharedobjcat"
 )
 
@@ -23,6 +27,19 @@ type sharedSubsystem struct {
 	initialized atomic.Bool
 	creatorID   CreatorID
 	initOnce    sync.Once
+
+	// cache is the persistent cache of shared objects; it is nil if
+	// Settings.Shared.CacheDirName is not set.
+	cache *sharedCache
+
+	// readLimiter limits and hedges the reads from shared storage; it is nil
+	// if neither Settings.Shared.MaxConcurrentReads nor
+	// Settings.Shared.HedgedReadPercentile is set.
+	readLimiter *sharedReadLimiter
+
+	// objMetadata is the metadata attached to the objects created on shared
+	// storage (see Provider.SetObjectMetadata).
+	objMetadata atomic.Pointer[map[string]string]
 }
 
 func (ss *sharedSubsystem) init(creatorID CreatorID) {
@@ -44,6 +61,23 @@ func (p *Provider) sharedInit() error {
 	}
 	p.shared.catalog = catalog
 
+	if dirName := p.st.Shared.CacheDirName; dirName != "" {
+		size := p.st.Shared.CacheSize
+		if size == 0 {
+			size = defaultSharedCacheSize
+		}
+		p.shared.cache, err = openSharedCache(
+			p.st.FS, p.st.Logger, dirName, size, p.st.Shared.CacheChunkSize, p.st.Shared.CacheMaxOpenFiles)
+		if err != nil {
+			return err
+		}
+	}
+	p.shared.readLimiter = newSharedReadLimiter(
+		p.st.Shared.MaxConcurrentReads, p.st.Shared.HedgedReadPercentile, p.st.Shared.HedgedReadMinDelay)
+	if p.shared.cache != nil {
+		p.shared.cache.limiter = p.shared.readLimiter
+	}
+
 	// The creator ID may or may not be initialized yet.
 	if contents.CreatorID.IsSet() {
 		p.shared.init(contents.CreatorID)
@@ -85,6 +119,144 @@ func (p *Provider) SetCreatorID(creatorID CreatorID) error {
 	return nil
 }
 
+// SetObjectMetadata sets the metadata attached to the objects subsequently
+// created on shared storage, if the storage supports object metadata (see
+// shared.MetadataStorage). It is typically used to identify the DB which
+// created the objects.
+func (p *Provider) SetObjectMetadata(metadata map[string]string) {
+	p.shared.objMetadata.Store(&metadata)
+}
+
+// RecoverSharedObjects registers the objects on shared storage that were
+// created with the provider's creator ID but are unknown to the provider, e.g.
+// because the shared object catalog was lost, and returns their metadata. The
+// objects are persisted in the catalog on the next call to Sync.
+//
+// Shared objects are not deleted from shared storage when they are removed, so
+// the recovered objects may include obsolete ones. Objects that were created
+// by other instances and attached to this one cannot be recovered.
+func (p *Provider) RecoverSharedObjects() ([]ObjectMetadata, error) {
+	if err := p.sharedCheckInitialized(); err != nil {
+		return nil, err
+	}
+	objs, err := p.sharedListCreatorObjects()
+	if err != nil {
+		return nil, err
+	}
+	var recovered []ObjectMetadata
+	for _, obj := range objs {
+		if _, err := p.Lookup(obj.fileType, obj.fileNum); err == nil {
+			continue
+		}
+		fileNum := obj.fileNum
+		meta := ObjectMetadata{
+			FileNum:  fileNum,
+			FileType: obj.fileType,
+		}
+		meta.Shared.CreatorID = p.shared.creatorID
+		meta.Shared.CreatorFileNum = fileNum
+		p.addMetadata(meta)
+		recovered = append(recovered, meta)
+	}
+	sort.Slice(recovered, func(i, j int) bool {
+		return recovered[i].FileNum < recovered[j].FileNum
+	})
+	return recovered, nil
+}
+
+type sharedCreatorObject struct {
+	fileType base.FileType
+	fileNum  base.FileNum
+}
+
+// sharedListCreatorObjects lists the objects on shared storage named after the
+// provider's creator ID, in either layout (see sharedObjectName and
+// legacySharedObjectName). An object named in both layouts is listed once.
+func (p *Provider) sharedListCreatorObjects() ([]sharedCreatorObject, error) {
+	var res []sharedCreatorObject
+	seen := make(map[sharedCreatorObject]struct{})
+	for _, prefix := range []string{
+		p.shared.creatorID.String() + "/",
+		p.shared.creatorID.String() + "-",
+	} {
+		names, err := p.st.Shared.Storage.List(prefix, "" /* delimiter */)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			// Some implementations return the names with the prefix.
+			name = strings.TrimPrefix(name, prefix)
+			var dir string
+			if i := strings.LastIndexByte(name, '/'); i >= 0 {
+				dir, name = name[:i], name[i+1:]
+			}
+			fileType, fileNum, ok := base.ParseFilename(p.st.FS, name)
+			if !ok || (prefix[len(prefix)-1] == '/') != (dir == sharedObjectDir(fileType)) {
+				continue
+			}
+			obj := sharedCreatorObject{fileType: fileType, fileNum: fileNum}
+			if _, ok := seen[obj]; !ok {
+				seen[obj] = struct{}{}
+				res = append(res, obj)
+			}
+		}
+	}
+	return res, nil
+}
+
+// SharedUsage describes the objects stored on shared storage by the provider
+// (see Provider.SharedUsage).
+type SharedUsage struct {
+	// Objects is the number of shared objects and replicas named after the
+	// provider's creator ID.
+	Objects int
+	// Bytes is the total size of the objects. The contents of deduplicated
+	// replicas (see Settings.Shared.DeduplicateReplicas) are counted once,
+	// even though they may also be referenced by the replicas of other
+	// providers.
+	Bytes int64
+}
+
+// SharedUsage lists the objects stored on shared storage by the provider and
+// returns their total size. It issues a request to shared storage for each
+// object, so it should not be called frequently.
+func (p *Provider) SharedUsage() (SharedUsage, error) {
+	if err := p.sharedCheckInitialized(); err != nil {
+		return SharedUsage{}, err
+	}
+	objs, err := p.sharedListCreatorObjects()
+	if err != nil {
+		return SharedUsage{}, err
+	}
+	var usage SharedUsage
+	contents := make(map[string]struct{})
+	for _, obj := range objs {
+		meta := ObjectMetadata{FileType: obj.fileType, FileNum: obj.fileNum}
+		meta.Shared.CreatorID = p.shared.creatorID
+		meta.Shared.CreatorFileNum = obj.fileNum
+		objName, size, err := sharedResolveName(p.st.Shared.Storage, meta)
+		if err == nil && size <= maxReplicaRefSize {
+			var contentName string
+			if contentName, size, err = p.resolveReplica(objName); err == nil && contentName != objName {
+				if _, ok := contents[contentName]; ok {
+					size = 0
+				}
+				contents[contentName] = struct{}{}
+			}
+		}
+		if err != nil {
+			if IsNotExistError(err) {
+				// The object was deleted since it was listed.
+				continue
+			}
+			return SharedUsage{}, err
+		}
+		usage.Objects++
+		usage.Bytes += size
+	}
+	return usage, nil
+}
+
 func (p *Provider) sharedCheckInitialized() error {
 	if p.st.Shared.Storage == nil {
 		return errors.Errorf("shared object support not configured")
@@ -125,14 +297,61 @@ func (p *Provider) sharedPath(meta ObjectMetadata) string {
 	return "shared://" + sharedObjectName(meta)
 }
 
+// sharedObjectName returns the name of a shared object on shared storage. The
+// objects are grouped by the ID of their creator, and then by file type, e.g.
+// "00000000000000000001/sst/000002.sst", so that a bucket shared by many
+// stores remains navigable, and the objects of a store are easy to find.
 func sharedObjectName(meta ObjectMetadata) string {
 	// TODO(radu): prepend a "shard" value for better distribution within the bucket?
+	return fmt.Sprintf(
+		"%s/%s/%s",
+		meta.Shared.CreatorID, sharedObjectDir(meta.FileType),
+		base.MakeFilename(meta.FileType, meta.Shared.CreatorFileNum),
+	)
+}
+
+// sharedObjectDir returns the directory of the objects of the given type,
+// within the directory of their creator.
+func sharedObjectDir(fileType base.FileType) string {
+	switch fileType {
+	case base.FileTypeTable:
+		return "sst"
+	case base.FileTypeLog:
+		return "wal"
+	case base.FileTypeManifest:
+		return "manifest"
+	default:
+		return "misc"
+	}
+}
+
+// legacySharedObjectName returns the name of a shared object in the flat
+// layout used before sharedObjectName, e.g.
+// "00000000000000000001-000002.sst". The objects named this way are still
+// found (see sharedResolveName), but no longer created.
+func legacySharedObjectName(meta ObjectMetadata) string {
 	return fmt.Sprintf(
 		"%s-%s",
 		meta.Shared.CreatorID, base.MakeFilename(meta.FileType, meta.Shared.CreatorFileNum),
 	)
 }
 
+// sharedResolveName returns the name of a shared object on storage, along with
+// its size: the object is looked up under its name, and under its legacy name
+// if it does not exist.
+func sharedResolveName(storage shared.Storage, meta ObjectMetadata) (string, int64, error) {
+	objName := sharedObjectName(meta)
+	size, err := storage.Size(objName)
+	if err == nil || !IsNotExistError(err) {
+		return objName, size, err
+	}
+	legacyName := legacySharedObjectName(meta)
+	if legacySize, legacyErr := storage.Size(legacyName); legacyErr == nil {
+		return legacyName, legacySize, nil
+	}
+	return objName, 0, err
+}
+
 func (p *Provider) sharedCreate(
 	_ context.Context, fileType base.FileType, fileNum base.FileNum,
 ) (Writable, ObjectMetadata, error) {
@@ -146,14 +365,49 @@ func (p *Provider) sharedCreate(
 	meta.Shared.CreatorID = p.shared.creatorID
 	meta.Shared.CreatorFileNum = fileNum
 
-	objName := sharedObjectName(meta)
-	writer, err := p.st.Shared.Storage.CreateObject(objName)
+	w, err := p.sharedCreateObject(sharedObjectName(meta))
 	if err != nil {
 		return nil, ObjectMetadata{}, err
 	}
+	return w, meta, nil
+}
+
+// sharedCreateObject creates the named object on shared storage and opens it
+// for writing, using a multipart upload if the storage supports it.
+func (p *Provider) sharedCreateObject(objName string) (Writable, error) {
+	var metadata map[string]string
+	if m := p.shared.objMetadata.Load(); m != nil {
+		metadata = *m
+	}
+	if ms, ok := p.st.Shared.Storage.(shared.MultipartStorage); ok {
+		var upload shared.MultipartUpload
+		var err error
+		if mms, ok := ms.(shared.MultipartMetadataStorage); ok && len(metadata) > 0 {
+			upload, err = mms.CreateMultipartUploadWithMetadata(objName, metadata)
+		} else {
+			upload, err = ms.CreateMultipartUpload(objName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newSharedMultipartWritable(
+			upload, objName, p.st.Shared.UploadPartSize, p.st.Shared.MaxUploadAttempts,
+			p.st.Shared.UploadRetryBackoff,
+		), nil
+	}
+	var writer io.WriteCloser
+	var err error
+	if ms, ok := p.st.Shared.Storage.(shared.MetadataStorage); ok && len(metadata) > 0 {
+		writer, err = ms.CreateObjectWithMetadata(objName, metadata)
+	} else {
+		writer, err = p.st.Shared.Storage.CreateObject(objName)
+	}
+	if err != nil {
+		return nil, err
+	}
 	return &sharedWritable{
 		storageWriter: writer,
-	}, meta, nil
+	}, nil
 }
 
 func (p *Provider) sharedOpenForReading(
@@ -162,18 +416,38 @@ func (p *Provider) sharedOpenForReading(
 	if err := p.sharedCheckInitialized(); err != nil {
 		return nil, err
 	}
-	objName := sharedObjectName(meta)
-	size, err := p.st.Shared.Storage.Size(objName)
+	objName, size, err := sharedResolveName(p.st.Shared.Storage, meta)
 	if err != nil {
 		return nil, err
 	}
-	return newSharedReadable(p.st.Shared.Storage, objName, size), nil
+	if p.shared.cache != nil {
+		return newSharedCachedReadable(p.shared.cache, p.st.Shared.Storage, objName, size), nil
+	}
+	return newSharedReadable(p.st.Shared.Storage, objName, size, p.shared.readLimiter), nil
+}
+
+// SharedCacheMetrics returns the metrics of the persistent cache of shared
+// objects, if configured (see Settings.Shared.CacheDirName).
+func (p *Provider) SharedCacheMetrics() SharedCacheMetrics {
+	if p.shared.cache == nil {
+		return SharedCacheMetrics{}
+	}
+	return p.shared.cache.Metrics()
 }
 
 func (p *Provider) sharedSize(meta ObjectMetadata) (int64, error) {
 	if err := p.sharedCheckInitialized(); err != nil {
 		return 0, err
 	}
-	objName := sharedObjectName(meta)
-	return p.st.Shared.Storage.Size(objName)
+	_, size, err := sharedResolveName(p.st.Shared.Storage, meta)
+	return size, err
+}
+
+// HedgedReads returns the number of reads from shared storage which were
+// hedged because they were slow (see Settings.Shared.HedgedReadPercentile).
+func (p *Provider) HedgedReads() int64 {
+	if p.shared.readLimiter == nil {
+		return 0
+	}
+	return p.shared.readLimiter.hedged.Load()
 }
@@ -0,0 +1,185 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package shared
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+)
+
+// ErrDryRun is returned when reading the data of an object of a DryRunStorage,
+// which does not retain the data written.
+var ErrDryRun = errors.New("shared: object data is not retained by a dry-run storage")
+
+// DryRunStats are the operations a DryRunStorage would have performed.
+type DryRunStats struct {
+	// Uploads is the number of objects written, and UploadedBytes their
+	// total size.
+	Uploads       int64
+	UploadedBytes int64
+	// Deletes is the number of objects deleted, and DeletedBytes their total
+	// size.
+	Deletes      int64
+	DeletedBytes int64
+	// Objects is the number of objects that would be stored, and ObjectBytes
+	// their total size.
+	Objects     int64
+	ObjectBytes int64
+}
+
+// NewDryRun returns a Storage which contacts no storage service: it logs the
+// objects it would upload and delete with logf (if not nil), and records
+// their names and sizes, but discards their data. It allows estimating the
+// bandwidth and the number and size of objects that replicating the local
+// sstables (see pebble.Options.Experimental.ReplicateLocalTables) would
+// require, before enabling it against real storage. The data of the objects
+// cannot be read back: reading an object fails with ErrDryRun.
+func NewDryRun(logf func(fmt string, args ...interface{})) *DryRunStorage {
+	s := &DryRunStorage{logf: logf}
+	s.mu.objects = make(map[string]int64)
+	return s
+}
+
+// DryRunStorage is the Storage returned by NewDryRun.
+type DryRunStorage struct {
+	logf func(fmt string, args ...interface{})
+
+	mu struct {
+		sync.Mutex
+		// objects maps the names of the objects to their size.
+		objects map[string]int64
+		stats   DryRunStats
+	}
+}
+
+var _ Storage = (*DryRunStorage)(nil)
+
+// Stats returns the operations the storage would have performed so far.
+func (s *DryRunStorage) Stats() DryRunStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mu.stats
+}
+
+func (s *DryRunStorage) log(format string, args ...interface{}) {
+	if s.logf != nil {
+		s.logf("dry run: "+format, args...)
+	}
+}
+
+// Close is part of the Storage interface.
+func (s *DryRunStorage) Close() error {
+	return nil
+}
+
+// ReadObjectAt is part of the Storage interface.
+func (s *DryRunStorage) ReadObjectAt(basename string, offset int64) (io.ReadCloser, int64, error) {
+	if _, err := s.Size(basename); err != nil {
+		return nil, 0, err
+	}
+	return nil, 0, errors.Wrapf(ErrDryRun, "reading %q", basename)
+}
+
+// CreateObject is part of the Storage interface.
+func (s *DryRunStorage) CreateObject(basename string) (io.WriteCloser, error) {
+	return &dryRunWriter{s: s, name: basename}, nil
+}
+
+// dryRunWriter counts the bytes of an object, and records the object when it
+// is closed.
+type dryRunWriter struct {
+	s      *DryRunStorage
+	name   string
+	size   int64
+	closed bool
+}
+
+func (w *dryRunWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		panic("Write after Close")
+	}
+	w.size += int64(len(p))
+	return len(p), nil
+}
+
+func (w *dryRunWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.mu.objects[w.name]; ok {
+		s.mu.stats.Objects--
+		s.mu.stats.ObjectBytes -= prev
+	}
+	s.mu.objects[w.name] = w.size
+	s.mu.stats.Uploads++
+	s.mu.stats.UploadedBytes += w.size
+	s.mu.stats.Objects++
+	s.mu.stats.ObjectBytes += w.size
+	s.log("upload %q (%d bytes)", w.name, w.size)
+	return nil
+}
+
+// List is part of the Storage interface.
+func (s *DryRunStorage) List(prefix, delimiter string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []string
+	seen := make(map[string]struct{})
+	for name := range s.mu.objects {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		name = name[len(prefix):]
+		if delimiter != "" {
+			if i := strings.Index(name, delimiter); i >= 0 {
+				name = name[:i]
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+		}
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// Delete is part of the Storage interface.
+func (s *DryRunStorage) Delete(basename string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size, ok := s.mu.objects[basename]
+	if !ok {
+		return errors.Wrapf(oserror.ErrNotExist, "deleting %q", basename)
+	}
+	delete(s.mu.objects, basename)
+	s.mu.stats.Deletes++
+	s.mu.stats.DeletedBytes += size
+	s.mu.stats.Objects--
+	s.mu.stats.ObjectBytes -= size
+	s.log("delete %q (%d bytes)", basename, size)
+	return nil
+}
+
+// Size is part of the Storage interface.
+func (s *DryRunStorage) Size(basename string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size, ok := s.mu.objects[basename]
+	if !ok {
+		return 0, errors.Wrapf(oserror.ErrNotExist, "%q", basename)
+	}
+	return size, nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package shared
+
+import (
+	"io"
+	"strings"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// NewLocalFS returns an implementation of the shared.Storage interface which
+// stores the objects as files in the given directory of a vfs.FS (which is
+// created if necessary), e.g. a directory on a network filesystem mounted by
+// all the DB instances sharing the objects. The "/" separators of the object
+// names map to subdirectories.
+//
+// An object only becomes visible once its writer is closed, as with blob
+// storage services.
+func NewLocalFS(fs vfs.FS, dirname string) Storage {
+	return &localFSStore{fs: fs, dirname: dirname}
+}
+
+// localFSStore is an implementation of the shared.Storage interface on top of
+// a directory of a vfs.FS.
+type localFSStore struct {
+	fs      vfs.FS
+	dirname string
+}
+
+var _ Storage = (*localFSStore)(nil)
+
+// tmpSuffix is the suffix of the files holding the objects being written.
+const tmpSuffix = ".tmp"
+
+func (s *localFSStore) path(basename string) string {
+	return s.fs.PathJoin(append([]string{s.dirname}, strings.Split(basename, "/")...)...)
+}
+
+func (s *localFSStore) Close() error {
+	return nil
+}
+
+func (s *localFSStore) ReadObjectAt(basename string, offset int64) (io.ReadCloser, int64, error) {
+	f, err := s.fs.Open(s.path(basename))
+	if err != nil {
+		return nil, 0, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	remaining := stat.Size() - offset
+	if remaining < 0 {
+		_ = f.Close()
+		return nil, 0, io.EOF
+	}
+	return &localFSReader{f: f, off: offset}, remaining, nil
+}
+
+// localFSReader reads a file from an offset.
+type localFSReader struct {
+	f   vfs.File
+	off int64
+}
+
+func (r *localFSReader) Read(p []byte) (int, error) {
+	n, err := r.f.ReadAt(p, r.off)
+	r.off += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *localFSReader) Close() error {
+	return r.f.Close()
+}
+
+func (s *localFSStore) CreateObject(basename string) (io.WriteCloser, error) {
+	p := s.path(basename)
+	if err := s.fs.MkdirAll(s.fs.PathDir(p), 0755); err != nil {
+		return nil, err
+	}
+	f, err := s.fs.Create(p + tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &localFSWriter{fs: s.fs, f: f, path: p}, nil
+}
+
+// localFSWriter writes an object to a temporary file, which is renamed to
+// the file of the object when the writer is closed.
+type localFSWriter struct {
+	fs   vfs.FS
+	f    vfs.File
+	path string
+}
+
+func (w *localFSWriter) Write(p []byte) (int, error) {
+	if w.f == nil {
+		panic("Write after Close")
+	}
+	return w.f.Write(p)
+}
+
+func (w *localFSWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	f := w.f
+	w.f = nil
+	err := f.Sync()
+	err = firstError(err, f.Close())
+	if err == nil {
+		err = w.fs.Rename(w.path+tmpSuffix, w.path)
+	}
+	if err != nil {
+		_ = w.fs.Remove(w.path + tmpSuffix)
+	}
+	return err
+}
+
+func (s *localFSStore) List(prefix, delimiter string) ([]string, error) {
+	var names []string
+	if err := s.walk("", &names); err != nil {
+		return nil, err
+	}
+	var res []string
+	seen := make(map[string]struct{})
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		name = name[len(prefix):]
+		if delimiter != "" {
+			if i := strings.Index(name, delimiter); i >= 0 {
+				name = name[:i]
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+		}
+		res = append(res, name)
+	}
+	return res, nil
+}
+
+// walk appends the names of the objects in the given subdirectory (an object
+// name prefix ending with "/", or "") to names.
+func (s *localFSStore) walk(subdir string, names *[]string) error {
+	ls, err := s.fs.List(s.path(strings.TrimSuffix(subdir, "/")))
+	if err != nil {
+		if oserror.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, filename := range ls {
+		if strings.HasSuffix(filename, tmpSuffix) {
+			continue
+		}
+		name := subdir + filename
+		stat, err := s.fs.Stat(s.path(name))
+		if err != nil {
+			if oserror.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if stat.IsDir() {
+			if err := s.walk(name+"/", names); err != nil {
+				return err
+			}
+			continue
+		}
+		*names = append(*names, name)
+	}
+	return nil
+}
+
+func (s *localFSStore) Delete(basename string) error {
+	return s.fs.Remove(s.path(basename))
+}
+
+func (s *localFSStore) Size(basename string) (int64, error) {
+	stat, err := s.fs.Stat(s.path(basename))
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+func firstError(err0, err1 error) error {
+	if err0 != nil {
+		return err0
+	}
+	return err1
+}
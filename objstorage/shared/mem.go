@@ -10,6 +10,8 @@ import (
 	"os"
 	"strings"
 	"sync"
+
+	"github.com/cockroachdb/errors"
 )
 
 // NewInMem returns an in-memory implementation of the shared.Storage
@@ -29,11 +31,13 @@ type inMemStore struct {
 	}
 }
 
-var _ Storage = (*inMemStore)(nil)
+var _ MultipartMetadataStorage = (*inMemStore)(nil)
+var _ BatchDeleteStorage = (*inMemStore)(nil)
 
 type inMemObj struct {
-	name string
-	data []byte
+	name     string
+	data     []byte
+	metadata map[string]string
 }
 
 func (s *inMemStore) Close() error {
@@ -71,16 +75,43 @@ func (r *inMemReader) Close() error {
 }
 
 func (s *inMemStore) CreateObject(basename string) (io.WriteCloser, error) {
+	return s.CreateObjectWithMetadata(basename, nil /* metadata */)
+}
+
+func (s *inMemStore) CreateObjectWithMetadata(
+	basename string, metadata map[string]string,
+) (io.WriteCloser, error) {
 	return &inMemWriter{
-		store: s,
-		name:  basename,
+		store:    s,
+		name:     basename,
+		metadata: copyMetadata(metadata),
 	}, nil
 }
 
+func (s *inMemStore) ObjectMetadata(basename string) (map[string]string, error) {
+	obj, err := s.getObj(basename)
+	if err != nil {
+		return nil, err
+	}
+	return copyMetadata(obj.metadata), nil
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
+
 type inMemWriter struct {
-	store *inMemStore
-	name  string
-	buf   bytes.Buffer
+	store    *inMemStore
+	name     string
+	metadata map[string]string
+	buf      bytes.Buffer
 }
 
 var _ io.WriteCloser = (*inMemWriter)(nil)
@@ -95,14 +126,78 @@ func (o *inMemWriter) Write(p []byte) (n int, err error) {
 func (o *inMemWriter) Close() error {
 	if o.store != nil {
 		o.store.addObj(&inMemObj{
-			name: o.name,
-			data: o.buf.Bytes(),
+			name:     o.name,
+			data:     o.buf.Bytes(),
+			metadata: o.metadata,
 		})
 		o.store = nil
 	}
 	return nil
 }
 
+func (s *inMemStore) CreateMultipartUpload(basename string) (MultipartUpload, error) {
+	return s.CreateMultipartUploadWithMetadata(basename, nil /* metadata */)
+}
+
+func (s *inMemStore) CreateMultipartUploadWithMetadata(
+	basename string, metadata map[string]string,
+) (MultipartUpload, error) {
+	return &inMemUpload{
+		store:    s,
+		name:     basename,
+		metadata: copyMetadata(metadata),
+		parts:    make(map[int][]byte),
+	}, nil
+}
+
+type inMemUpload struct {
+	store    *inMemStore
+	name     string
+	metadata map[string]string
+	parts    map[int][]byte
+}
+
+var _ MultipartUpload = (*inMemUpload)(nil)
+
+func (u *inMemUpload) UploadPart(partNum int, data []byte) error {
+	if u.store == nil {
+		panic("UploadPart after Complete or Abort")
+	}
+	if partNum < 1 {
+		return errors.Errorf("invalid part number %d", partNum)
+	}
+	u.parts[partNum] = append([]byte(nil), data...)
+	return nil
+}
+
+func (u *inMemUpload) Complete(numParts int) error {
+	if u.store == nil {
+		panic("Complete after Complete or Abort")
+	}
+	var data []byte
+	for i := 1; i <= numParts; i++ {
+		part, ok := u.parts[i]
+		if !ok {
+			return errors.Errorf("part %d of %q was not uploaded", i, u.name)
+		}
+		data = append(data, part...)
+	}
+	u.store.addObj(&inMemObj{
+		name:     u.name,
+		data:     data,
+		metadata: u.metadata,
+	})
+	u.store = nil
+	u.parts = nil
+	return nil
+}
+
+func (u *inMemUpload) Abort() error {
+	u.store = nil
+	u.parts = nil
+	return nil
+}
+
 func (s *inMemStore) List(prefix, delimiter string) ([]string, error) {
 	if delimiter != "" {
 		panic("delimiter unimplemented")
@@ -124,6 +219,17 @@ func (s *inMemStore) Delete(basename string) error {
 	return nil
 }
 
+// DeleteObjects is part of the BatchDeleteStorage interface.
+func (s *inMemStore) DeleteObjects(basenames []string) []error {
+	if len(basenames) > MaxDeleteBatchSize {
+		panic("too many objects in a batch deletion")
+	}
+	for _, name := range basenames {
+		s.rmObj(name)
+	}
+	return nil
+}
+
 // Size returns the length of the named object in bytesWritten.
 func (s *inMemStore) Size(basename string) (int64, error) {
 	obj, err := s.getObj(basename)
@@ -59,3 +59,115 @@ type Storage interface {
 	// TODO(radu): same as above - how can we tell if it's a "no such object" error?
 	Size(basename string) (int64, error)
 }
+
+// MaxDeleteBatchSize is the maximum number of objects deleted by a single call
+// to BatchDeleteStorage.DeleteObjects, which is the limit of the S3
+// DeleteObjects API.
+const MaxDeleteBatchSize = 1000
+
+// BatchDeleteStorage is an optional interface which can be implemented by a
+// Storage that supports deleting several objects with a single request (e.g.
+// the S3 DeleteObjects API). When available, it is used to delete together the
+// objects removed together, e.g. the replicas of the inputs of a compaction.
+type BatchDeleteStorage interface {
+	Storage
+
+	// DeleteObjects removes at most MaxDeleteBatchSize named objects from the
+	// store. The deletion of each object can fail independently: it returns
+	// the errors of the deletions, indexed like basenames, or nil if all of
+	// them succeeded.
+	DeleteObjects(basenames []string) []error
+}
+
+// DeleteObjects removes the named objects from the store, in batches of at
+// most MaxDeleteBatchSize objects if it is a BatchDeleteStorage, or one at a
+// time otherwise. It returns the errors of the deletions, indexed like
+// basenames, or nil if all of them succeeded.
+func DeleteObjects(s Storage, basenames []string) []error {
+	var errs []error
+	setErr := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(basenames))
+		}
+		errs[i] = err
+	}
+	bs, ok := s.(BatchDeleteStorage)
+	if !ok {
+		for i, name := range basenames {
+			if err := s.Delete(name); err != nil {
+				setErr(i, err)
+			}
+		}
+		return errs
+	}
+	for start := 0; start < len(basenames); start += MaxDeleteBatchSize {
+		end := start + MaxDeleteBatchSize
+		if end > len(basenames) {
+			end = len(basenames)
+		}
+		for i, err := range bs.DeleteObjects(basenames[start:end]) {
+			if err != nil {
+				setErr(start+i, err)
+			}
+		}
+	}
+	return errs
+}
+
+// MultipartStorage is an optional interface which can be implemented by a
+// Storage that supports uploading an object as a sequence of parts, each of
+// which can be retried independently (e.g. S3 multipart uploads). When
+// available, it is used to write objects so that a transient error only
+// requires re-uploading a single part.
+type MultipartStorage interface {
+	Storage
+
+	// CreateMultipartUpload starts an upload for the object at the requested
+	// name. The object is created (or replaced) only when the upload is
+	// completed.
+	CreateMultipartUpload(basename string) (MultipartUpload, error)
+}
+
+// MultipartUpload is an in-progress upload of an object, in parts.
+type MultipartUpload interface {
+	// UploadPart uploads the part with the given number; parts are numbered
+	// consecutively, starting at 1. A part can be uploaded again (e.g. after an
+	// error), in which case the new data replaces the previous data for the
+	// part. The implementation must not retain data after returning.
+	UploadPart(partNum int, data []byte) error
+
+	// Complete creates the object by concatenating parts 1 through numParts.
+	// Complete can be retried if it returns an error.
+	Complete(numParts int) error
+
+	// Abort cancels the upload and discards any uploaded parts.
+	Abort() error
+}
+
+// MetadataStorage is an optional interface which can be implemented by a
+// Storage that supports attaching user-defined metadata to objects (e.g. S3
+// object metadata). When available, objects are created with the metadata set
+// with objstorage.Provider.SetObjectMetadata, which identifies the DB that
+// created them.
+type MetadataStorage interface {
+	Storage
+
+	// CreateObjectWithMetadata is like CreateObject, and attaches the given
+	// metadata to the object.
+	CreateObjectWithMetadata(basename string, metadata map[string]string) (io.WriteCloser, error)
+
+	// ObjectMetadata returns the metadata attached to the named object.
+	ObjectMetadata(basename string) (map[string]string, error)
+}
+
+// MultipartMetadataStorage is an optional interface which can be implemented
+// by a Storage that implements both MultipartStorage and MetadataStorage, to
+// attach metadata to the objects it uploads in parts.
+type MultipartMetadataStorage interface {
+	MultipartStorage
+	MetadataStorage
+
+	// CreateMultipartUploadWithMetadata is like CreateMultipartUpload, and
+	// attaches the given metadata to the object once the upload completes.
+	CreateMultipartUploadWithMetadata(basename string, metadata map[string]string) (MultipartUpload, error)
+}
@@ -0,0 +1,627 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+const (
+	sharedCacheChunkSuffix = ".chunk"
+	sharedCacheTempSuffix  = ".tmp"
+
+	defaultSharedCacheSize      = 1 << 30 // 1GB
+	defaultSharedCacheChunkSize = 1 << 20 // 1MB
+)
+
+// SharedCacheMetrics holds metrics for the cache of shared objects on the local
+// filesystem.
+type SharedCacheMetrics struct {
+	// The number of bytes used by the cache.
+	Size int64
+	// The number of chunks in the cache.
+	Count int64
+	// The number of open chunk files.
+	OpenFiles int64
+	// The number of reads (of up to a chunk) served by the cache.
+	Hits int64
+	// The number of reads (of a chunk) served by shared storage.
+	Misses int64
+}
+
+// sharedCache is a persistent cache of the contents of shared objects, stored
+// in a directory of the local filesystem. It sits between the block cache and
+// shared storage, so that blocks evicted from the block cache, or blocks read
+// before a restart, can be read again without going to shared storage.
+//
+// Objects are cached in chunks of a fixed size, each of which is stored in its
+// own file named after the object, the offset of the chunk and a sequence
+// number which makes the name unique. Because shared objects are immutable, a
+// chunk file is valid as long as it has the expected length, even if it was
+// written with a different chunk size. Chunk files are synced before being
+// renamed into place, so that only complete chunks are found when the cache is
+// opened again after a crash. The file of a chunk is opened when the chunk is
+// first read, and kept open until the chunk is removed from the cache.
+//
+// The filesystem is never accessed while holding sharedCache.mu: chunks are
+// removed from the cache under the mutex, and their files are closed and
+// deleted afterwards (see sharedCacheCleanup). Since file names are never
+// reused, deleting a file cannot race with the creation of a file for the same
+// chunk.
+//
+// The cache is bounded by evicting the least recently used chunks. The
+// recency of chunks is not persisted: after a restart, the chunks found in the
+// directory are considered in an arbitrary order. The number of open chunk
+// files can also be bounded (see Settings.Shared.CacheMaxOpenFiles), in which
+// case the files of the least recently used chunks which are not being read
+// are closed, to be opened again by their next read.
+type sharedCache struct {
+	fs        vfs.FS
+	dirName   string
+	logger    base.Logger
+	chunkSize int64
+	maxSize   int64
+	// limiter limits and hedges the reads of the chunks from storage; it can
+	// be nil.
+	limiter *sharedReadLimiter
+
+	// maxOpenFiles is the maximum number of open chunk files, or 0 if it is
+	// unbounded. Accessed atomically.
+	maxOpenFiles atomic.Int64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	mu struct {
+		sync.Mutex
+		// objects maps an object name to its cached chunks, indexed by offset.
+		objects map[string]map[int64]*sharedCacheChunk
+		// lru is the sentinel of the circular list of chunks, in order of most
+		// to least recently used.
+		lru   sharedCacheChunk
+		size  int64
+		count int64
+		// seq is the largest sequence number used in the name of a chunk file.
+		seq uint64
+		// openFiles is the number of chunks whose file is open.
+		openFiles int64
+	}
+}
+
+type sharedCacheChunk struct {
+	objName string
+	offset  int64
+	seq     uint64
+	size    int64
+
+	// The fields below are protected by sharedCache.mu.
+
+	// refs is the number of references to the chunk: one held by the cache
+	// while the chunk is in it, and one for each ongoing read of the chunk.
+	refs int32
+	// file is the open chunk file, or nil if the chunk has not been read since
+	// it was added to the cache. It is closed once refs drops to zero, or
+	// earlier if the chunk is not being read and too many files are open.
+	file       vfs.File
+	prev, next *sharedCacheChunk
+}
+
+// sharedCacheCleanup accumulates the chunk files to close and delete as chunks
+// are removed from the cache while holding sharedCache.mu. run must be called
+// once the mutex is released.
+type sharedCacheCleanup struct {
+	close  []vfs.File
+	remove []string
+}
+
+func (cl *sharedCacheCleanup) run(c *sharedCache) {
+	for _, f := range cl.close {
+		if err := f.Close(); err != nil {
+			c.logger.Infof("shared cache: could not close chunk: %v", err)
+		}
+	}
+	for _, path := range cl.remove {
+		if err := c.fs.Remove(path); err != nil && !IsNotExistError(err) {
+			c.logger.Infof("shared cache: could not remove chunk %s: %v", path, err)
+		}
+	}
+	*cl = sharedCacheCleanup{}
+}
+
+func openSharedCache(
+	fs vfs.FS, logger base.Logger, dirName string, maxSize int64, chunkSize int, maxOpenFiles int,
+) (*sharedCache, error) {
+	if chunkSize <= 0 {
+		chunkSize = defaultSharedCacheChunkSize
+	}
+	c := &sharedCache{
+		fs:        fs,
+		dirName:   dirName,
+		logger:    logger,
+		chunkSize: int64(chunkSize),
+		maxSize:   maxSize,
+	}
+	c.maxOpenFiles.Store(int64(maxOpenFiles))
+	c.mu.objects = make(map[string]map[int64]*sharedCacheChunk)
+	c.mu.lru.next = &c.mu.lru
+	c.mu.lru.prev = &c.mu.lru
+
+	if err := fs.MkdirAll(dirName, 0755); err != nil {
+		return nil, errors.Wrapf(err, "pebble: could not create shared cache directory")
+	}
+	ls, err := fs.List(dirName)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []*sharedCacheChunk
+	for _, filename := range ls {
+		path := fs.PathJoin(dirName, filename)
+		if strings.HasSuffix(filename, sharedCacheTempSuffix) {
+			// Leftover from a chunk that was being written when the process
+			// exited.
+			if err := fs.Remove(path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		objName, offset, seq, ok := parseSharedCacheChunkFilename(filename)
+		if !ok {
+			continue
+		}
+		info, err := fs.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &sharedCacheChunk{
+			objName: objName, offset: offset, seq: seq, size: info.Size(),
+		})
+	}
+
+	var cleanup sharedCacheCleanup
+	c.mu.Lock()
+	for _, chunk := range chunks {
+		if c.mu.seq < chunk.seq {
+			c.mu.seq = chunk.seq
+		}
+		if existing := c.mu.objects[chunk.objName][chunk.offset]; existing != nil {
+			// Two files for the same chunk can only be left over by a crash
+			// in between the creation of a file and the deletion of the one
+			// it replaces; keep the most recent one.
+			if existing.seq > chunk.seq {
+				cleanup.remove = append(cleanup.remove, c.chunkPath(chunk))
+				continue
+			}
+			c.deleteLocked(existing, &cleanup)
+		}
+		c.addLocked(chunk)
+	}
+	c.evictLocked(&cleanup)
+	c.mu.Unlock()
+	cleanup.run(c)
+	return c, nil
+}
+
+// sharedCacheChunkSeparator replaces the "/" separators of the object names in
+// the names of the chunk files.
+const sharedCacheChunkSeparator = "~"
+
+func (c *sharedCache) chunkPath(chunk *sharedCacheChunk) string {
+	objName := strings.ReplaceAll(chunk.objName, "/", sharedCacheChunkSeparator)
+	return c.fs.PathJoin(c.dirName, fmt.Sprintf("%s.%d.%d%s",
+		objName, chunk.offset, chunk.seq, sharedCacheChunkSuffix))
+}
+
+func parseSharedCacheChunkFilename(
+	filename string,
+) (objName string, offset int64, seq uint64, ok bool) {
+	s := strings.TrimSuffix(filename, sharedCacheChunkSuffix)
+	if len(s) == len(filename) {
+		return "", 0, 0, false
+	}
+	i := strings.LastIndexByte(s, '.')
+	if i < 0 {
+		return "", 0, 0, false
+	}
+	seq, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, 0, false
+	}
+	s = s[:i]
+	i = strings.LastIndexByte(s, '.')
+	if i < 0 {
+		return "", 0, 0, false
+	}
+	offset, err = strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil || offset < 0 {
+		return "", 0, 0, false
+	}
+	return strings.ReplaceAll(s[:i], sharedCacheChunkSeparator, "/"), offset, seq, true
+}
+
+// ReadAt reads from the object with the given name and size into p, starting
+// at off. Chunks that are not in the cache are read from storage and added to
+// the cache.
+func (c *sharedCache) ReadAt(
+	ctx context.Context, storage shared.Storage, objName string, objSize int64, p []byte, off int64,
+) (n int, err error) {
+	for n < len(p) {
+		if off >= objSize {
+			return n, io.EOF
+		}
+		chunkOffset := off - off%c.chunkSize
+		chunkSize := c.chunkSize
+		if chunkOffset+chunkSize > objSize {
+			chunkSize = objSize - chunkOffset
+		}
+		buf := p[n:]
+		if rem := chunkOffset + chunkSize - off; int64(len(buf)) > rem {
+			buf = buf[:rem]
+		}
+		if err := c.readChunk(ctx, storage, objName, chunkOffset, chunkSize, buf, off-chunkOffset); err != nil {
+			return n, err
+		}
+		n += len(buf)
+		off += int64(len(buf))
+	}
+	return n, nil
+}
+
+// readChunk fills p with the data of the given chunk, starting at off within
+// the chunk.
+func (c *sharedCache) readChunk(
+	ctx context.Context,
+	storage shared.Storage, objName string, chunkOffset, chunkSize int64, p []byte, off int64,
+) error {
+	if chunk, f := c.get(objName, chunkOffset, chunkSize); chunk != nil {
+		err := c.readCached(chunk, f, p, off)
+		if err == nil {
+			c.release(chunk)
+			c.hits.Add(1)
+			return nil
+		}
+		if IsTooManyOpenFilesError(err) {
+			// The chunk is fine: it is read from storage this time.
+			c.logger.Infof("shared cache: could not open chunk %s: %v", c.chunkPath(chunk), err)
+		} else {
+			c.logger.Infof("shared cache: dropping chunk %s: %v", c.chunkPath(chunk), err)
+			c.remove(chunk)
+		}
+		c.release(chunk)
+	}
+
+	c.misses.Add(1)
+	data := make([]byte, chunkSize)
+	r, _, err := c.limiter.readAt(ctx, storage, objName, nil /* r */, data, chunkOffset)
+	if err != nil {
+		return err
+	}
+	if err := r.Close(); err != nil {
+		return err
+	}
+	copy(p, data[off:])
+
+	// Caching is best-effort: a failure to write the chunk does not fail the
+	// read.
+	if err := c.insert(objName, chunkOffset, data); err != nil {
+		c.logger.Infof("shared cache: could not write chunk of %s at %d: %v", objName, chunkOffset, err)
+	}
+	return nil
+}
+
+// readCached reads from the file of a chunk on which the caller holds a
+// reference, opening the file if f is nil.
+func (c *sharedCache) readCached(chunk *sharedCacheChunk, f vfs.File, p []byte, off int64) error {
+	if f == nil {
+		var err error
+		f, err = c.fs.Open(c.chunkPath(chunk))
+		if IsTooManyOpenFilesError(err) {
+			// Close the files of the chunks which are not being read, and
+			// try again.
+			c.logger.Infof("shared cache: closing idle chunk files: %v", err)
+			var cleanup sharedCacheCleanup
+			c.mu.Lock()
+			c.closeIdleFilesLocked(0, &cleanup)
+			c.mu.Unlock()
+			cleanup.run(c)
+			f, err = c.fs.Open(c.chunkPath(chunk))
+		}
+		if err != nil {
+			return err
+		}
+		var cleanup sharedCacheCleanup
+		c.mu.Lock()
+		if chunk.file == nil {
+			chunk.file = f
+			f = nil
+			c.mu.openFiles++
+			if max := c.maxOpenFiles.Load(); max > 0 {
+				c.closeIdleFilesLocked(max, &cleanup)
+			}
+		}
+		opened := chunk.file
+		c.mu.Unlock()
+		cleanup.run(c)
+		if f != nil {
+			// The file was opened concurrently.
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+		f = opened
+	}
+	_, err := f.ReadAt(p, off)
+	return err
+}
+
+// insert writes a chunk to the cache.
+func (c *sharedCache) insert(objName string, chunkOffset int64, data []byte) (err error) {
+	if int64(len(data)) > c.maxSize {
+		return nil
+	}
+	chunk := &sharedCacheChunk{objName: objName, offset: chunkOffset, size: int64(len(data))}
+	c.mu.Lock()
+	c.mu.seq++
+	chunk.seq = c.mu.seq
+	c.mu.Unlock()
+
+	path := c.chunkPath(chunk)
+	tempPath := path + sharedCacheTempSuffix
+	f, err := c.fs.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = c.fs.Remove(tempPath)
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := c.fs.Rename(tempPath, path); err != nil {
+		return err
+	}
+
+	var cleanup sharedCacheCleanup
+	c.mu.Lock()
+	if existing := c.mu.objects[objName][chunkOffset]; existing != nil {
+		// The chunk was already in the cache (e.g. it was inserted
+		// concurrently, or had an unexpected size); it is replaced.
+		c.deleteLocked(existing, &cleanup)
+	}
+	c.addLocked(chunk)
+	c.evictLocked(&cleanup)
+	c.mu.Unlock()
+	cleanup.run(c)
+	return nil
+}
+
+// get looks up the given chunk, returning it if it is in the cache with the
+// expected size, along with its file if it is open. The chunk is marked as
+// recently used, and the caller must release the returned chunk.
+func (c *sharedCache) get(
+	objName string, chunkOffset, chunkSize int64,
+) (*sharedCacheChunk, vfs.File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chunk := c.mu.objects[objName][chunkOffset]
+	if chunk == nil || chunk.size != chunkSize {
+		return nil, nil
+	}
+	c.unlinkLocked(chunk)
+	c.linkLocked(chunk)
+	chunk.refs++
+	return chunk, chunk.file
+}
+
+// release releases a reference to a chunk returned by get.
+func (c *sharedCache) release(chunk *sharedCacheChunk) {
+	var cleanup sharedCacheCleanup
+	c.mu.Lock()
+	c.unrefLocked(chunk, &cleanup)
+	c.mu.Unlock()
+	cleanup.run(c)
+}
+
+// remove removes a chunk from the cache, if it is still in the cache.
+func (c *sharedCache) remove(chunk *sharedCacheChunk) {
+	var cleanup sharedCacheCleanup
+	c.mu.Lock()
+	if c.mu.objects[chunk.objName][chunk.offset] == chunk {
+		c.deleteLocked(chunk, &cleanup)
+	}
+	c.mu.Unlock()
+	cleanup.run(c)
+}
+
+// removeObject removes all the chunks of an object from the cache.
+func (c *sharedCache) removeObject(objName string) {
+	var cleanup sharedCacheCleanup
+	c.mu.Lock()
+	for _, chunk := range c.mu.objects[objName] {
+		c.deleteLocked(chunk, &cleanup)
+	}
+	c.mu.Unlock()
+	cleanup.run(c)
+}
+
+// Close closes the open chunk files. The chunks remain in the cache directory.
+func (c *sharedCache) Close() error {
+	var err error
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for chunk := c.mu.lru.next; chunk != &c.mu.lru; chunk = chunk.next {
+		if chunk.file != nil {
+			err = firstError(err, chunk.file.Close())
+			chunk.file = nil
+		}
+	}
+	c.mu.openFiles = 0
+	return err
+}
+
+func (c *sharedCache) addLocked(chunk *sharedCacheChunk) {
+	chunks := c.mu.objects[chunk.objName]
+	if chunks == nil {
+		chunks = make(map[int64]*sharedCacheChunk)
+		c.mu.objects[chunk.objName] = chunks
+	}
+	chunks[chunk.offset] = chunk
+	chunk.refs++
+	c.linkLocked(chunk)
+	c.mu.size += chunk.size
+	c.mu.count++
+}
+
+func (c *sharedCache) linkLocked(chunk *sharedCacheChunk) {
+	chunk.prev = &c.mu.lru
+	chunk.next = c.mu.lru.next
+	chunk.next.prev = chunk
+	c.mu.lru.next = chunk
+}
+
+func (c *sharedCache) unlinkLocked(chunk *sharedCacheChunk) {
+	chunk.prev.next = chunk.next
+	chunk.next.prev = chunk.prev
+	chunk.prev, chunk.next = nil, nil
+}
+
+func (c *sharedCache) unrefLocked(chunk *sharedCacheChunk, cleanup *sharedCacheCleanup) {
+	chunk.refs--
+	if chunk.refs == 0 && chunk.file != nil {
+		cleanup.close = append(cleanup.close, chunk.file)
+		chunk.file = nil
+		c.mu.openFiles--
+	}
+}
+
+// closeIdleFilesLocked closes the files of the least recently used chunks
+// which are not being read, until at most max chunk files are open.
+func (c *sharedCache) closeIdleFilesLocked(max int64, cleanup *sharedCacheCleanup) {
+	for chunk := c.mu.lru.prev; chunk != &c.mu.lru && c.mu.openFiles > max; chunk = chunk.prev {
+		// A reference is held by the cache; any other one is held by a read.
+		if chunk.file != nil && chunk.refs == 1 {
+			cleanup.close = append(cleanup.close, chunk.file)
+			chunk.file = nil
+			c.mu.openFiles--
+		}
+	}
+}
+
+// setMaxOpenFiles changes the maximum number of open chunk files, closing the
+// files of the least recently used chunks if it is lowered.
+func (c *sharedCache) setMaxOpenFiles(max int) {
+	c.maxOpenFiles.Store(int64(max))
+	if max <= 0 {
+		return
+	}
+	var cleanup sharedCacheCleanup
+	c.mu.Lock()
+	c.closeIdleFilesLocked(int64(max), &cleanup)
+	c.mu.Unlock()
+	cleanup.run(c)
+}
+
+// deleteLocked removes a chunk from the cache and schedules the deletion of
+// its file. The file is closed once ongoing reads of the chunk are done.
+func (c *sharedCache) deleteLocked(chunk *sharedCacheChunk, cleanup *sharedCacheCleanup) {
+	c.unlinkLocked(chunk)
+	chunks := c.mu.objects[chunk.objName]
+	delete(chunks, chunk.offset)
+	if len(chunks) == 0 {
+		delete(c.mu.objects, chunk.objName)
+	}
+	c.mu.size -= chunk.size
+	c.mu.count--
+	c.unrefLocked(chunk, cleanup)
+	cleanup.remove = append(cleanup.remove, c.chunkPath(chunk))
+}
+
+// evictLocked evicts the least recently used chunks until the cache fits
+// within its maximum size.
+func (c *sharedCache) evictLocked(cleanup *sharedCacheCleanup) {
+	for c.mu.size > c.maxSize && c.mu.lru.prev != &c.mu.lru {
+		c.deleteLocked(c.mu.lru.prev, cleanup)
+	}
+}
+
+// Metrics returns the metrics for the cache.
+func (c *sharedCache) Metrics() SharedCacheMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SharedCacheMetrics{
+		Size:      c.mu.size,
+		Count:     c.mu.count,
+		OpenFiles: c.mu.openFiles,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+	}
+}
+
+// sharedCachedReadable implements Readable for a shared object that is read
+// through the shared cache.
+type sharedCachedReadable struct {
+	cache   *sharedCache
+	storage shared.Storage
+	objName string
+	size    int64
+
+	rh NoopReadHandle
+}
+
+var _ Readable = (*sharedCachedReadable)(nil)
+
+func newSharedCachedReadable(
+	cache *sharedCache, storage shared.Storage, objName string, size int64,
+) *sharedCachedReadable {
+	r := &sharedCachedReadable{
+		cache:   cache,
+		storage: storage,
+		objName: objName,
+		size:    size,
+	}
+	r.rh = MakeNoopReadHandle(r)
+	return r
+}
+
+// ReadAt is part of the objstorage.Readable interface.
+func (r *sharedCachedReadable) ReadAt(ctx context.Context, p []byte, off int64) (n int, err error) {
+	return r.cache.ReadAt(ctx, r.storage, r.objName, r.size, p, off)
+}
+
+// Close is part of the objstorage.Readable interface.
+func (r *sharedCachedReadable) Close() error {
+	r.storage = nil
+	return nil
+}
+
+// Size is part of the objstorage.Readable interface.
+func (r *sharedCachedReadable) Size() int64 {
+	return r.size
+}
+
+// NewReadHandle is part of the objstorage.Readable interface.
+func (r *sharedCachedReadable) NewReadHandle(_ context.Context) ReadHandle {
+	return &r.rh
+}
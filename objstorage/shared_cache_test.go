@@ -0,0 +1,243 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage wraps a shared.Storage, counting the calls to ReadObjectAt.
+type countingStorage struct {
+	shared.Storage
+	reads int
+}
+
+func (s *countingStorage) ReadObjectAt(basename string, offset int64) (io.ReadCloser, int64, error) {
+	s.reads++
+	return s.Storage.ReadObjectAt(basename, offset)
+}
+
+func TestSharedCache(t *testing.T) {
+	ctx := context.Background()
+	fs := vfs.NewMem()
+	store := &countingStorage{Storage: shared.NewInMem()}
+	open := func(cacheSize int64) *Provider {
+		st := DefaultSettings(fs, "")
+		st.Shared.Storage = store
+		st.Shared.CacheDirName = "cache"
+		st.Shared.CacheSize = cacheSize
+		st.Shared.CacheChunkSize = 100
+		p, err := Open(st)
+		require.NoError(t, err)
+		require.NoError(t, p.SetCreatorID(1))
+		return p
+	}
+	// listCache returns the chunks in the cache directory, without the
+	// sequence numbers of their files.
+	listCache := func() []string {
+		ls, err := fs.List("cache")
+		require.NoError(t, err)
+		var chunks []string
+		for _, filename := range ls {
+			objName, offset, _, ok := parseSharedCacheChunkFilename(filename)
+			require.True(t, ok, filename)
+			chunks = append(chunks, fmt.Sprintf("%s.%d", objName, offset))
+		}
+		sort.Strings(chunks)
+		return chunks
+	}
+
+	p := open(1000)
+	data := make([]byte, 250)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	w, meta, err := p.Create(ctx, base.FileTypeTable, 1, CreateOptions{PreferSharedStorage: true})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(append([]byte(nil), data...)))
+	require.NoError(t, w.Finish())
+	require.NoError(t, p.Sync())
+	objName := sharedObjectName(meta)
+	// The chunk files are named after the object, without "/" separators.
+	chunkPrefix := "cache/" + strings.ReplaceAll(objName, "/", sharedCacheChunkSeparator)
+
+	read := func(p *Provider, off, n int) {
+		t.Helper()
+		r, err := p.OpenForReading(ctx, base.FileTypeTable, 1, OpenOptions{})
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), r.Size())
+		buf := make([]byte, n)
+		_, err = r.ReadAt(ctx, buf, int64(off))
+		require.NoError(t, err)
+		require.Equal(t, data[off:off+n], buf)
+		require.NoError(t, r.Close())
+	}
+
+	// A read spanning two chunks reads both from storage, after which they
+	// are served from the cache.
+	read(p, 50, 100)
+	require.Equal(t, 2, store.reads)
+	read(p, 0, 200)
+	read(p, 120, 10)
+	require.Equal(t, 2, store.reads)
+	read(p, 0, 250)
+	require.Equal(t, 3, store.reads)
+	require.Equal(t, SharedCacheMetrics{Size: 250, Count: 3, OpenFiles: 2, Hits: 5, Misses: 3}, p.SharedCacheMetrics())
+	require.Equal(t, []string{objName + ".0", objName + ".100", objName + ".200"}, listCache())
+
+	// Chunk files are kept open once read.
+	require.NoError(t, fs.Remove(chunkPrefix+".0.1.chunk"))
+	read(p, 0, 100)
+	require.Equal(t, 3, store.reads)
+
+	// Reads past the end of the object are short.
+	r, err := p.OpenForReading(ctx, base.FileTypeTable, 1, OpenOptions{})
+	require.NoError(t, err)
+	n, err := r.ReadAt(ctx, make([]byte, 10), 245)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 5, n)
+	require.NoError(t, r.Close())
+	require.NoError(t, p.Close())
+
+	// The cache contents survive a restart; leftover temporary files are
+	// removed.
+	f, err := fs.Create(chunkPrefix + ".0.4.chunk.tmp")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	p = open(1000)
+	require.Equal(t, SharedCacheMetrics{Size: 150, Count: 2}, p.SharedCacheMetrics())
+	read(p, 0, 250)
+	require.Equal(t, 4, store.reads)
+	require.NoError(t, p.Close())
+
+	// A chunk with an unexpected size is replaced.
+	require.NoError(t, fs.Remove(chunkPrefix+".200.3.chunk"))
+	f, err = fs.Create(chunkPrefix + ".200.3.chunk")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("foo"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	p = open(1000)
+	read(p, 200, 50)
+	require.Equal(t, 5, store.reads)
+	require.Equal(t, SharedCacheMetrics{Size: 250, Count: 3, OpenFiles: 0, Hits: 0, Misses: 1}, p.SharedCacheMetrics())
+	require.Equal(t, []string{objName + ".0", objName + ".100", objName + ".200"}, listCache())
+	require.NoError(t, p.Close())
+
+	// A smaller cache evicts the least recently used chunks.
+	p = open(200)
+	require.EqualValues(t, 2, p.SharedCacheMetrics().Count)
+	read(p, 0, 250)
+	require.EqualValues(t, 150, p.SharedCacheMetrics().Size)
+	read(p, 200, 50)
+	read(p, 100, 10)
+	require.Equal(t, []string{objName + ".100", objName + ".200"}, listCache())
+
+	// Removing the object removes its chunks.
+	require.NoError(t, p.Remove(base.FileTypeTable, 1))
+	m := p.SharedCacheMetrics()
+	require.EqualValues(t, 0, m.Size)
+	require.EqualValues(t, 0, m.Count)
+	require.Empty(t, listCache())
+	require.NoError(t, p.Close())
+}
+
+// tooManyFilesFS fails opening files with EMFILE while more than max files
+// opened through it are open.
+type tooManyFilesFS struct {
+	vfs.FS
+	max  int
+	open int
+}
+
+type tooManyFilesFile struct {
+	vfs.File
+	fs *tooManyFilesFS
+}
+
+func (fs *tooManyFilesFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	if fs.open >= fs.max {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+	}
+	f, err := fs.FS.Open(name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	fs.open++
+	return &tooManyFilesFile{File: f, fs: fs}, nil
+}
+
+func (f *tooManyFilesFile) Close() error {
+	f.fs.open--
+	return f.File.Close()
+}
+
+func TestSharedCacheMaxOpenFiles(t *testing.T) {
+	ctx := context.Background()
+	store := &countingStorage{Storage: shared.NewInMem()}
+	fs := &tooManyFilesFS{FS: vfs.NewMem(), max: 1 << 10}
+	st := DefaultSettings(fs, "")
+	st.Shared.Storage = store
+	st.Shared.CacheDirName = "cache"
+	st.Shared.CacheSize = 1000
+	st.Shared.CacheChunkSize = 100
+	st.Shared.CacheMaxOpenFiles = 2
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NoError(t, p.SetCreatorID(1))
+
+	data := make([]byte, 500)
+	w, _, err := p.Create(ctx, base.FileTypeTable, 1, CreateOptions{PreferSharedStorage: true})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(data))
+	require.NoError(t, w.Finish())
+	r, err := p.OpenForReading(ctx, base.FileTypeTable, 1, OpenOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+	read := func(off int64) {
+		t.Helper()
+		_, err := r.ReadAt(ctx, make([]byte, 10), off)
+		require.NoError(t, err)
+	}
+
+	// Reading all the chunks twice only opens two chunk files at a time, and
+	// the second pass is served from the cache.
+	for i := 0; i < 2; i++ {
+		for off := int64(0); off < 500; off += 100 {
+			read(off)
+			require.LessOrEqual(t, p.SharedCacheMetrics().OpenFiles, int64(2))
+		}
+	}
+	require.Equal(t, 5, store.reads)
+
+	// Lowering the limit closes files.
+	p.SetSharedCacheMaxOpenFiles(1)
+	require.EqualValues(t, 1, p.SharedCacheMetrics().OpenFiles)
+
+	// Without a limit, running out of file descriptors closes the idle chunk
+	// files instead of failing the reads or dropping chunks.
+	p.SetSharedCacheMaxOpenFiles(0)
+	fs.max = fs.open + 2
+	for off := int64(0); off < 500; off += 100 {
+		read(off)
+	}
+	m := p.SharedCacheMetrics()
+	require.Equal(t, 5, store.reads)
+	require.EqualValues(t, 5, m.Count)
+	require.Less(t, m.OpenFiles, int64(5))
+}
@@ -0,0 +1,255 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/shared"
+)
+
+const (
+	// readLatencySamples is the number of latencies of recent reads from
+	// shared storage from which the hedging delay is computed.
+	readLatencySamples = 256
+	// minReadLatencySamples is the number of reads from shared storage before
+	// reads are hedged.
+	minReadLatencySamples = 32
+	// hedgeDelayRefresh is the number of reads after which the hedging delay
+	// is computed again.
+	hedgeDelayRefresh = 32
+	// defaultHedgedReadMinDelay is the default value of
+	// Settings.Shared.HedgedReadMinDelay.
+	defaultHedgedReadMinDelay = 10 * time.Millisecond
+)
+
+// sharedReadLimiter limits the number of concurrent reads from shared storage
+// (see Settings.Shared.MaxConcurrentReads) and hedges the slow reads (see
+// Settings.Shared.HedgedReadPercentile).
+type sharedReadLimiter struct {
+	// sem holds a token for each read in progress; it is nil if the number of
+	// concurrent reads is not limited.
+	sem chan struct{}
+	// percentile is the percentile of the latencies of recent reads after
+	// which a read is hedged, or 0 if reads are not hedged.
+	percentile float64
+	minDelay   time.Duration
+	hedged     atomic.Int64
+
+	mu struct {
+		sync.Mutex
+		// latencies is a ring buffer of the latencies of recent reads.
+		latencies []time.Duration
+		next      int
+		// sinceRefresh is the number of reads since delay was computed.
+		sinceRefresh int
+		delay        time.Duration
+	}
+}
+
+// newSharedReadLimiter returns a limiter for the given settings, or nil if
+// neither the concurrency of the reads is limited nor reads are hedged.
+func newSharedReadLimiter(
+	maxConcurrentReads int, hedgePercentile float64, hedgeMinDelay time.Duration,
+) *sharedReadLimiter {
+	if maxConcurrentReads <= 0 && hedgePercentile <= 0 {
+		return nil
+	}
+	if hedgeMinDelay <= 0 {
+		hedgeMinDelay = defaultHedgedReadMinDelay
+	}
+	l := &sharedReadLimiter{percentile: hedgePercentile, minDelay: hedgeMinDelay}
+	if maxConcurrentReads > 0 {
+		l.sem = make(chan struct{}, maxConcurrentReads)
+	}
+	return l
+}
+
+func (l *sharedReadLimiter) acquire(ctx context.Context) error {
+	if l.sem == nil {
+		return nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *sharedReadLimiter) tryAcquire() bool {
+	if l.sem == nil {
+		return true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *sharedReadLimiter) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// record records the latency of a completed read, whether it was hedged or
+// not, and whether its data was used or not.
+func (l *sharedReadLimiter) record(latency time.Duration) {
+	if l.percentile <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.mu.latencies) < readLatencySamples {
+		l.mu.latencies = append(l.mu.latencies, latency)
+	} else {
+		l.mu.latencies[l.mu.next] = latency
+		l.mu.next = (l.mu.next + 1) % readLatencySamples
+	}
+	l.mu.sinceRefresh++
+}
+
+// hedgeDelay returns the time after which a read is hedged, or false if reads
+// are not hedged.
+func (l *sharedReadLimiter) hedgeDelay() (time.Duration, bool) {
+	if l.percentile <= 0 {
+		return 0, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.mu.latencies) < minReadLatencySamples {
+		return 0, false
+	}
+	if l.mu.delay == 0 || l.mu.sinceRefresh >= hedgeDelayRefresh {
+		sorted := append([]time.Duration(nil), l.mu.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		i := int(l.percentile * float64(len(sorted)))
+		if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		l.mu.delay = sorted[i]
+		l.mu.sinceRefresh = 0
+	}
+	if l.mu.delay < l.minDelay {
+		return l.minDelay, true
+	}
+	return l.mu.delay, true
+}
+
+// readAt fills p with the data of the named object at offset off. The data is
+// read from r if it is not nil, which must be positioned at off; otherwise a
+// new reader is opened. It returns the reader positioned after the data read,
+// or nil if the read failed. If the read takes longer than the hedging delay,
+// the data is also read with a new reader, and the first read to complete is
+// used. A nil limiter reads the data without limits.
+func (l *sharedReadLimiter) readAt(
+	ctx context.Context, storage shared.Storage, objName string, r io.ReadCloser, p []byte, off int64,
+) (io.ReadCloser, int, error) {
+	if l == nil {
+		return readSharedObjectAt(storage, objName, r, p, off)
+	}
+	if err := l.acquire(ctx); err != nil {
+		return r, 0, err
+	}
+	delay, hedge := l.hedgeDelay()
+	if !hedge {
+		defer l.release()
+		start := time.Now()
+		r, n, err := readSharedObjectAt(storage, objName, r, p, off)
+		if err == nil {
+			l.record(time.Since(start))
+		}
+		return r, n, err
+	}
+
+	// Both reads use their own buffer, since the slower one keeps running
+	// after readAt returns; it then closes its reader and releases its token.
+	// The latency of each read is recorded once it completes, so that the
+	// reads which lose to a hedged read, which are the slowest, still count
+	// towards the hedging delay.
+	type result struct {
+		r   io.ReadCloser
+		buf []byte
+		n   int
+		err error
+	}
+	results := make(chan result, 2)
+	read := func(r io.ReadCloser) {
+		start := time.Now()
+		buf := make([]byte, len(p))
+		r, n, err := readSharedObjectAt(storage, objName, r, buf, off)
+		if err == nil {
+			l.record(time.Since(start))
+		}
+		results <- result{r: r, buf: buf, n: n, err: err}
+	}
+	discard := func(n int) {
+		for i := 0; i < n; i++ {
+			res := <-results
+			if res.r != nil {
+				_ = res.r.Close()
+			}
+			l.release()
+		}
+	}
+	go read(r)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var res result
+	select {
+	case res = <-results:
+		pending--
+	case <-timer.C:
+		if l.tryAcquire() {
+			l.hedged.Add(1)
+			go read(nil /* r */)
+			pending++
+		}
+		res = <-results
+		pending--
+		if res.err != nil && pending > 0 {
+			// The other read may succeed.
+			if res.r != nil {
+				_ = res.r.Close()
+			}
+			l.release()
+			res = <-results
+			pending--
+		}
+	}
+	l.release()
+	go discard(pending)
+	copy(p, res.buf[:res.n])
+	return res.r, res.n, res.err
+}
+
+// readSharedObjectAt fills p with the data of the named object at offset off,
+// read from r if it is not nil or from a new reader otherwise. It returns the
+// reader positioned after the data read, or nil if the read failed.
+func readSharedObjectAt(
+	storage shared.Storage, objName string, r io.ReadCloser, p []byte, off int64,
+) (io.ReadCloser, int, error) {
+	if r == nil {
+		var err error
+		if r, _, err = storage.ReadObjectAt(objName, off); err != nil {
+			return nil, 0, err
+		}
+	}
+	n, err := io.ReadFull(r, p)
+	if err != nil {
+		_ = r.Close()
+		return nil, n, err
+	}
+	return r, n, nil
+}
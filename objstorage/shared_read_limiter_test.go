@@ -0,0 +1,126 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStorage is a shared.Storage whose readers wait for delay(n) before
+// returning data, n being the number of readers opened before.
+type slowStorage struct {
+	shared.Storage
+	delay func(n int64) time.Duration
+
+	opened  atomic.Int64
+	active  atomic.Int64
+	maxSeen atomic.Int64
+}
+
+func (s *slowStorage) ReadObjectAt(objName string, offset int64) (io.ReadCloser, int64, error) {
+	rc, size, err := s.Storage.ReadObjectAt(objName, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n := s.active.Add(1); n > s.maxSeen.Load() {
+		s.maxSeen.Store(n)
+	}
+	return &slowReader{ReadCloser: rc, s: s, delay: s.delay(s.opened.Add(1) - 1)}, size, nil
+}
+
+type slowReader struct {
+	io.ReadCloser
+	s     *slowStorage
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.ReadCloser.Read(p)
+}
+
+func (r *slowReader) Close() error {
+	r.s.active.Add(-1)
+	return r.ReadCloser.Close()
+}
+
+func TestSharedReadLimiter(t *testing.T) {
+	ctx := context.Background()
+	newStorage := func(delay func(n int64) time.Duration) *slowStorage {
+		st := &slowStorage{Storage: shared.NewInMem(), delay: delay}
+		w, err := st.CreateObject("obj")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("0123456789"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return st
+	}
+	read := func(l *sharedReadLimiter, st shared.Storage, off int64) string {
+		buf := make([]byte, 4)
+		r, n, err := l.readAt(ctx, st, "obj", nil /* r */, buf, off)
+		require.NoError(t, err)
+		require.Equal(t, 4, n)
+		require.NoError(t, r.Close())
+		return string(buf)
+	}
+
+	require.Nil(t, newSharedReadLimiter(0, 0, 0))
+
+	t.Run("concurrency", func(t *testing.T) {
+		st := newStorage(func(int64) time.Duration { return time.Millisecond })
+		l := newSharedReadLimiter(2, 0, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				read(l, st, 2)
+			}()
+		}
+		wg.Wait()
+		require.Equal(t, int64(10), st.opened.Load())
+		require.LessOrEqual(t, st.maxSeen.Load(), int64(2))
+		require.Zero(t, st.active.Load())
+	})
+
+	t.Run("hedged", func(t *testing.T) {
+		// The reader opened after the first reads is very slow.
+		const slow = 2 * time.Second
+		st := newStorage(func(n int64) time.Duration {
+			if n == minReadLatencySamples {
+				return slow
+			}
+			return 0
+		})
+		l := newSharedReadLimiter(0, 0.9, time.Millisecond)
+		for i := 0; i < minReadLatencySamples; i++ {
+			require.Equal(t, "3456", read(l, st, 3))
+		}
+		require.Zero(t, l.hedged.Load())
+		start := time.Now()
+		require.Equal(t, "5678", read(l, st, 5))
+		require.Less(t, time.Since(start), slow)
+		require.Equal(t, int64(1), l.hedged.Load())
+
+		// The latencies of both reads are recorded, including the slow read
+		// which completes after the hedged one.
+		require.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.mu.latencies) == minReadLatencySamples+2
+		}, 10*time.Second, time.Millisecond)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		require.GreaterOrEqual(t, l.mu.latencies[minReadLatencySamples+1], slow)
+	})
+}
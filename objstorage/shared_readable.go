@@ -7,6 +7,7 @@ package objstorage
 import (
 	"context"
 	"io"
+	"sync"
 
 	"github.com/cockroachdb/pebble/objstorage/shared"
 )
@@ -17,28 +18,48 @@ type sharedReadable struct {
 	storage shared.Storage
 	objName string
 	size    int64
-
-	// rh is used for direct ReadAt calls without a read handle.
-	rh sharedReadHandle
+	// limiter limits and hedges the reads; it can be nil.
+	limiter *sharedReadLimiter
+
+	// rh is used for direct ReadAt calls without a read handle, so that
+	// sequential calls reuse the same reader; it is protected by rhMu.
+	// Concurrent calls (e.g. from the Gets of sstables which are only on
+	// shared storage) each open a new reader.
+	rhMu sync.Mutex
+	rh   sharedReadHandle
 }
 
 var _ Readable = (*sharedReadable)(nil)
 
-func newSharedReadable(storage shared.Storage, objName string, size int64) *sharedReadable {
+func newSharedReadable(
+	storage shared.Storage, objName string, size int64, limiter *sharedReadLimiter,
+) *sharedReadable {
 	r := &sharedReadable{
 		storage: storage,
 		objName: objName,
 		size:    size,
+		limiter: limiter,
 	}
 	r.rh.readable = r
 	return r
 }
 
 func (r *sharedReadable) ReadAt(ctx context.Context, p []byte, offset int64) (n int, err error) {
-	return r.rh.ReadAt(ctx, p, offset)
+	if r.rhMu.TryLock() {
+		defer r.rhMu.Unlock()
+		return r.rh.ReadAt(ctx, p, offset)
+	}
+	var rc io.ReadCloser
+	rc, n, err = r.limiter.readAt(ctx, r.storage, r.objName, nil /* r */, p, offset)
+	if rc != nil {
+		err = firstError(err, rc.Close())
+	}
+	return n, err
 }
 
 func (r *sharedReadable) Close() error {
+	r.rhMu.Lock()
+	defer r.rhMu.Unlock()
 	err := r.rh.Close()
 	r.storage = nil
 	return err
@@ -61,25 +82,18 @@ type sharedReadHandle struct {
 
 var _ ReadHandle = (*sharedReadHandle)(nil)
 
-func (r *sharedReadHandle) ReadAt(_ context.Context, p []byte, offset int64) (n int, err error) {
+func (r *sharedReadHandle) ReadAt(ctx context.Context, p []byte, offset int64) (n int, err error) {
 	// See if this continues the previous read so that we can reuse the last reader.
-	if r.lastReader == nil || r.lastOffset != offset {
+	if r.lastReader != nil && r.lastOffset != offset {
 		// We need to create a new reader.
-		if r.lastReader != nil {
-			if err := r.lastReader.Close(); err != nil {
-				return 0, err
-			}
-			r.lastReader = nil
-		}
-		reader, _, err := r.readable.storage.ReadObjectAt(r.readable.objName, offset)
-		if err != nil {
+		if err := r.lastReader.Close(); err != nil {
 			return 0, err
 		}
-		r.lastReader = reader
-		r.lastOffset = offset
+		r.lastReader = nil
 	}
-	n, err = io.ReadFull(r.lastReader, p)
-	r.lastOffset += int64(n)
+	rd := r.readable
+	r.lastReader, n, err = rd.limiter.readAt(ctx, rd.storage, rd.objName, r.lastReader, p, offset)
+	r.lastOffset = offset + int64(n)
 	return n, err
 }
 
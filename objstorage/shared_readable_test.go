@@ -0,0 +1,52 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedReadableConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	st := shared.NewInMem()
+	var data []byte
+	for i := 0; i < 1000; i++ {
+		data = append(data, fmt.Sprintf("%04d", i)...)
+	}
+	w, err := st.CreateObject("obj")
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r := newSharedReadable(st, "obj", int64(len(data)), nil /* limiter */)
+	defer r.Close()
+	require.Equal(t, int64(len(data)), r.Size())
+
+	// Sequential and concurrent reads without a read handle return the right
+	// data.
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			buf := make([]byte, 4)
+			for i := g; i < 1000; i += 8 {
+				n, err := r.ReadAt(ctx, buf, int64(4*i))
+				if !(n == 4 && err == nil && string(buf) == fmt.Sprintf("%04d", i)) {
+					t.Errorf("read %d: %q, %v", i, buf[:n], err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
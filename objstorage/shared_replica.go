@@ -0,0 +1,538 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// A local object can have a replica on shared storage, which is a copy of the
+// object that can be used to restore the local object if it gets corrupted.
+// Replicas are named like the shared objects created by the provider (see
+// sharedObjectName), so they require the creator ID to be set; the file
+// numbers of local and shared objects never collide, so neither do their
+// names. The replicas named in the legacy layout (see legacySharedObjectName)
+// are still found.
+
+// replicaCopyBufSize is the size of the buffer used to copy objects to and
+// from their replicas.
+const replicaCopyBufSize = 1 << 20 // 1 MB
+
+// With Settings.Shared.DeduplicateReplicas, the contents of a replica are
+// stored in a content object named by the SHA-256 hash of the contents under
+// replicaContentPrefix, and the replica itself is a small reference object
+// holding replicaRefMagic followed by the name of the content object. The
+// contents of a replica are not uploaded if the content object already
+// exists, e.g. if an identical object was already replicated. References are
+// resolved when replicas are read, whether or not DeduplicateReplicas is set.
+const (
+	replicaContentPrefix = "content/"
+	replicaRefMagic      = "pebble-replica-ref:"
+	// maxReplicaRefSize bounds the size of the reference objects; objects
+	// which are larger are never references (sstables are always larger).
+	maxReplicaRefSize = 256
+)
+
+// replicaMetadata returns the metadata of the replica of a local object, as if
+// it were a shared object created by the provider.
+func (p *Provider) replicaMetadata(meta ObjectMetadata) (ObjectMetadata, error) {
+	if meta.IsShared() {
+		return ObjectMetadata{}, errors.AssertionFailedf("shared object %s cannot have a replica", errors.Safe(meta.FileNum))
+	}
+	if err := p.sharedCheckInitialized(); err != nil {
+		return ObjectMetadata{}, err
+	}
+	replica := meta
+	replica.Shared.CreatorID = p.shared.creatorID
+	replica.Shared.CreatorFileNum = meta.FileNum
+	return replica, nil
+}
+
+// replicaName returns the name of the object holding the contents of the
+// existing replica of a local object (see resolveReplica), and their size.
+func (p *Provider) replicaName(meta ObjectMetadata) (contentName string, size int64, err error) {
+	replica, err := p.replicaMetadata(meta)
+	if err != nil {
+		return "", 0, err
+	}
+	objName, _, err := sharedResolveName(p.st.Shared.Storage, replica)
+	if err != nil {
+		return "", 0, err
+	}
+	return p.resolveReplica(objName)
+}
+
+// CanReplicate returns true if local objects can have replicas, i.e. if shared
+// storage is configured and the creator ID has been set.
+func (p *Provider) CanReplicate() bool {
+	return p.sharedCheckInitialized() == nil
+}
+
+// ListReplicas returns the file numbers of the objects of the given type that
+// have a replica on shared storage. The result can include objects that are
+// not known to the provider, as well as the shared objects created by the
+// provider.
+func (p *Provider) ListReplicas(fileType base.FileType) (map[base.FileNum]struct{}, error) {
+	if err := p.sharedCheckInitialized(); err != nil {
+		return nil, err
+	}
+	objs, err := p.sharedListCreatorObjects()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[base.FileNum]struct{}, len(objs))
+	for _, obj := range objs {
+		if obj.fileType == fileType {
+			res[obj.fileNum] = struct{}{}
+		}
+	}
+	return res, nil
+}
+
+// OpenReplicaForReading opens the replica of a local object.
+func (p *Provider) OpenReplicaForReading(ctx context.Context, meta ObjectMetadata) (Readable, error) {
+	objName, size, err := p.replicaName(meta)
+	if err != nil {
+		return nil, err
+	}
+	return newSharedReadable(p.st.Shared.Storage, objName, size, p.shared.readLimiter), nil
+}
+
+// resolveReplica returns the name and the size of the object holding the
+// contents of the replica with the given name, which is the replica itself
+// unless it is a reference to a content object.
+func (p *Provider) resolveReplica(objName string) (string, int64, error) {
+	st := p.st.Shared.Storage
+	size, err := st.Size(objName)
+	if err != nil || size > maxReplicaRefSize {
+		return objName, size, err
+	}
+	rc, _, err := st.ReadObjectAt(objName, 0)
+	if err != nil {
+		return "", 0, err
+	}
+	data, err := io.ReadAll(rc)
+	err = firstError(err, rc.Close())
+	if err != nil {
+		return "", 0, err
+	}
+	if !strings.HasPrefix(string(data), replicaRefMagic) {
+		return objName, size, nil
+	}
+	contentName := strings.TrimPrefix(string(data), replicaRefMagic)
+	size, err = st.Size(contentName)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "replica %q references %q", errors.Safe(objName), errors.Safe(contentName))
+	}
+	return contentName, size, nil
+}
+
+// UploadReplica copies a local object to its replica on shared storage,
+// replacing any existing replica. With Settings.Shared.DeduplicateReplicas,
+// the contents of the object are only uploaded if no replica with the same
+// contents was uploaded before.
+func (p *Provider) UploadReplica(ctx context.Context, meta ObjectMetadata) error {
+	replica, err := p.replicaMetadata(meta)
+	if err != nil {
+		return err
+	}
+	objName := sharedObjectName(replica)
+	path := p.vfsPath(meta.FileType, meta.FileNum)
+	if p.st.Shared.DeduplicateReplicas {
+		err = p.uploadDedupReplica(path, objName)
+	} else {
+		err = p.uploadFile(path, objName, -1 /* size */)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "uploading replica of object %s", errors.Safe(meta.FileNum))
+	}
+	// The replica replaces any replica named in the legacy layout.
+	legacyName := legacySharedObjectName(replica)
+	if _, err := p.st.Shared.Storage.Size(legacyName); err == nil {
+		_ = p.st.Shared.Storage.Delete(legacyName)
+	}
+	return nil
+}
+
+// uploadDedupReplica uploads the local file at the given path to the content
+// object named by the hash of its contents, unless the content object
+// exists, and stores a reference to the content object in the named replica.
+func (p *Provider) uploadDedupReplica(path, objName string) error {
+	f, err := p.st.FS.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.CopyBuffer(h, f, make([]byte, replicaCopyBufSize))
+	err = firstError(err, f.Close())
+	if err != nil {
+		return err
+	}
+	contentName := replicaContentPrefix + hex.EncodeToString(h.Sum(nil))
+	if existing, err := p.st.Shared.Storage.Size(contentName); err != nil || existing != size {
+		if err := p.uploadFile(path, contentName, -1 /* size */); err != nil {
+			return err
+		}
+	} else {
+		p.replicasDeduplicated.Add(1)
+	}
+	w, err := p.sharedCreateObject(objName)
+	if err != nil {
+		return err
+	}
+	if err := w.Write([]byte(replicaRefMagic + contentName)); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Finish()
+}
+
+// uploadFile copies the first size bytes of the local file at the given path,
+// or the whole file if size is negative, to the named object on shared
+// storage.
+func (p *Provider) uploadFile(path, objName string, size int64) error {
+	f, err := p.st.FS.Open(path, vfs.SequentialReadsOption)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if size >= 0 {
+		r = io.LimitReader(f, size)
+	}
+	w, err := p.sharedCreateObject(objName)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, replicaCopyBufSize)
+	var copied int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := w.Write(buf[:n]); err != nil {
+				w.Abort()
+				return err
+			}
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if size >= 0 && copied < size {
+		w.Abort()
+		return errors.Errorf("file %q is shorter than %d bytes", errors.Safe(path), errors.Safe(size))
+	}
+	return w.Finish()
+}
+
+// ReplicasDeduplicated returns the number of replicas uploaded by the provider
+// whose contents were already on shared storage (see
+// Settings.Shared.DeduplicateReplicas).
+func (p *Provider) ReplicasDeduplicated() int64 {
+	return p.replicasDeduplicated.Load()
+}
+
+// RestoreFromReplica replaces a local object with a copy of its replica on
+// shared storage. The object is written to a temporary file which is then
+// renamed, so that the object is never partially written; readers which have
+// the object open keep reading the previous contents. An object which is not
+// known to the provider, e.g. because its local file was lost, becomes known
+// once restored.
+func (p *Provider) RestoreFromReplica(ctx context.Context, meta ObjectMetadata) (err error) {
+	objName, _, err := p.replicaName(meta)
+	if err != nil {
+		return err
+	}
+	rc, _, err := p.st.Shared.Storage.ReadObjectAt(objName, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	path := p.vfsPath(meta.FileType, meta.FileNum)
+	tmpPath := path + ".restore"
+	f, err := p.st.FS.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = p.st.FS.Remove(tmpPath)
+			err = errors.Wrapf(err, "restoring object %s from replica", errors.Safe(meta.FileNum))
+		}
+	}()
+	_, err = io.CopyBuffer(f, rc, make([]byte, replicaCopyBufSize))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = p.st.FS.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err = p.fsDir.Sync(); err != nil {
+		return err
+	}
+	if _, lookupErr := p.Lookup(meta.FileType, meta.FileNum); lookupErr != nil {
+		p.addMetadata(meta)
+	}
+	return nil
+}
+
+// UploadReplicaPrefix is like UploadReplica, but only copies the first size
+// bytes of the local object, e.g. the part of a file being appended to which
+// is known to be synced. The object need not be known to the provider, and
+// its contents are never deduplicated.
+func (p *Provider) UploadReplicaPrefix(ctx context.Context, meta ObjectMetadata, size int64) error {
+	replica, err := p.replicaMetadata(meta)
+	if err != nil {
+		return err
+	}
+	path := p.vfsPath(meta.FileType, meta.FileNum)
+	err = p.uploadFile(path, sharedObjectName(replica), size)
+	return errors.Wrapf(err, "uploading replica of object %s", errors.Safe(meta.FileNum))
+}
+
+// RemoveReplica removes the replica of a local object, if it has one. The
+// replicas of the objects known to the provider are removed along with them
+// (see Remove). A replica which cannot be deleted from shared storage is
+// recorded as orphaned, and its deletion is retried by DeleteOrphanedReplicas.
+func (p *Provider) RemoveReplica(meta ObjectMetadata) {
+	p.removeReplicas([]ObjectMetadata{meta})
+}
+
+// queueReplicaRemoval queues the removal of the replica of a removed local
+// object, which is performed by FlushReplicaRemovals. The removal is not
+// performed by Remove or Sync, so that the callers which remove or sync
+// objects, e.g. ingestions and compactions, do not depend on shared storage.
+func (p *Provider) queueReplicaRemoval(meta ObjectMetadata) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mu.shared.replicaRemovals = append(p.mu.shared.replicaRemovals, meta)
+}
+
+// FlushReplicaRemovals removes the replicas of the local objects removed since
+// the last call, with as few requests to shared storage as possible (see
+// shared.BatchDeleteStorage). It is also called by Close.
+func (p *Provider) FlushReplicaRemovals() {
+	p.mu.Lock()
+	metas := p.mu.shared.replicaRemovals
+	p.mu.shared.replicaRemovals = nil
+	p.mu.Unlock()
+	if len(metas) > 0 {
+		p.removeReplicas(metas)
+	}
+}
+
+// removeReplicas removes the replicas of local objects, if they have one, and
+// records them as orphaned if they cannot be deleted.
+func (p *Provider) removeReplicas(metas []ObjectMetadata) {
+	// Each replica is deleted under both its current and its legacy name;
+	// owners maps the names to the index of their object in metas.
+	names := make([]string, 0, 2*len(metas))
+	owners := make([]int, 0, 2*len(metas))
+	for i, meta := range metas {
+		replica, err := p.replicaMetadata(meta)
+		if err != nil {
+			continue
+		}
+		names = append(names, sharedObjectName(replica), legacySharedObjectName(replica))
+		owners = append(owners, i, i)
+	}
+	if len(names) == 0 {
+		return
+	}
+	errs := make([]error, len(metas))
+	for i, err := range p.deleteSharedObjects(names) {
+		errs[owners[i]] = firstError(errs[owners[i]], err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, meta := range metas {
+		if errs[i] == nil {
+			delete(p.mu.orphanedReplicas, meta.FileNum)
+			continue
+		}
+		if _, ok := p.mu.orphanedReplicas[meta.FileNum]; !ok {
+			p.st.Logger.Infof("deleting replica of object %s: %v", errors.Safe(meta.FileNum), errs[i])
+			if p.mu.orphanedReplicas == nil {
+				p.mu.orphanedReplicas = make(map[base.FileNum]ObjectMetadata)
+			}
+			p.mu.orphanedReplicas[meta.FileNum] = meta
+		}
+	}
+}
+
+// deleteSharedObjects deletes objects from shared storage, and retries once
+// the deletions which fail. Deleting an object which does not exist is not an
+// error. It returns the errors like shared.DeleteObjects.
+func (p *Provider) deleteSharedObjects(names []string) []error {
+	storage := p.st.Shared.Storage
+	var retry []string
+	var retryIdx []int
+	for i, err := range shared.DeleteObjects(storage, names) {
+		if err != nil && !IsNotExistError(err) {
+			retry = append(retry, names[i])
+			retryIdx = append(retryIdx, i)
+		}
+	}
+	if len(retry) == 0 {
+		return nil
+	}
+	var errs []error
+	for i, err := range shared.DeleteObjects(storage, retry) {
+		if err != nil && !IsNotExistError(err) {
+			if errs == nil {
+				errs = make([]error, len(names))
+			}
+			errs[retryIdx[i]] = err
+		}
+	}
+	return errs
+}
+
+// OrphanedReplicas returns the number of replicas of removed objects which
+// could not be deleted from shared storage yet.
+func (p *Provider) OrphanedReplicas() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.mu.orphanedReplicas)
+}
+
+// DeleteOrphanedReplicas retries the deletion of the replicas of removed
+// objects which could not be deleted from shared storage (see Remove and
+// RemoveReplica). It returns the number of replicas that were deleted, and
+// the number that are still orphaned. Orphaned replicas are only tracked in
+// memory: the replicas orphaned before the provider was opened are not
+// deleted.
+func (p *Provider) DeleteOrphanedReplicas() (deleted, remaining int) {
+	p.mu.RLock()
+	orphans := make([]ObjectMetadata, 0, len(p.mu.orphanedReplicas))
+	for _, meta := range p.mu.orphanedReplicas {
+		orphans = append(orphans, meta)
+	}
+	p.mu.RUnlock()
+
+	if len(orphans) > 0 {
+		p.removeReplicas(orphans)
+	}
+	remaining = p.OrphanedReplicas()
+	return len(orphans) - remaining, remaining
+}
+
+// ReplicaReadable is implemented by the Readables of local objects which can
+// fall back to reading their replica on shared storage (see
+// Settings.Shared.ReplicateLocalObjects and OpenOptions.NoReplicaFallback).
+type ReplicaReadable interface {
+	Readable
+
+	// ReadReplicaAt reads from the replica of the object, like ReadAt. It is
+	// used when the data read from the local object is corrupt.
+	ReadReplicaAt(ctx context.Context, p []byte, off int64) (n int, err error)
+
+	// RecordReplicaFallback reports to Settings.Shared.OnReplicaFallback that
+	// corrupt data of the local object, which localErr describes, was
+	// successfully read from the replica instead.
+	RecordReplicaFallback(localErr error)
+}
+
+// replicaFallbackReadable is a Readable of a local object which has a replica,
+// opened lazily the first time the object is found to be corrupt.
+type replicaFallbackReadable struct {
+	Readable
+	p    *Provider
+	meta ObjectMetadata
+
+	mu struct {
+		sync.Mutex
+		replica Readable
+	}
+}
+
+var _ ReplicaReadable = (*replicaFallbackReadable)(nil)
+
+// openWithReplicaFallback opens a local object which has a replica. If the
+// local object does not exist, its replica is opened instead.
+func (p *Provider) openWithReplicaFallback(
+	ctx context.Context, meta ObjectMetadata, opts OpenOptions,
+) (Readable, error) {
+	localOpts := opts
+	localOpts.MustExist = false
+	r, err := p.vfsOpenForReading(ctx, meta.FileType, meta.FileNum, localOpts)
+	if err == nil {
+		return &replicaFallbackReadable{Readable: r, p: p, meta: meta}, nil
+	}
+	if IsNotExistError(err) {
+		if replica, replicaErr := p.OpenReplicaForReading(ctx, meta); replicaErr == nil {
+			p.replicaFallback(meta, err)
+			return replica, nil
+		}
+	}
+	if opts.MustExist {
+		base.MustExist(p.st.FS, p.vfsPath(meta.FileType, meta.FileNum), p.st.Logger, err)
+	}
+	return nil, err
+}
+
+func (p *Provider) replicaFallback(meta ObjectMetadata, err error) {
+	p.st.Logger.Infof("reading object %s from its replica: %v", errors.Safe(meta.FileNum), err)
+	if p.st.Shared.OnReplicaFallback != nil {
+		p.st.Shared.OnReplicaFallback(meta, err)
+	}
+}
+
+// ReadReplicaAt is part of the ReplicaReadable interface.
+func (r *replicaFallbackReadable) ReadReplicaAt(
+	ctx context.Context, p []byte, off int64,
+) (int, error) {
+	r.mu.Lock()
+	if r.mu.replica == nil {
+		replica, err := r.p.OpenReplicaForReading(ctx, r.meta)
+		if err != nil {
+			r.mu.Unlock()
+			return 0, err
+		}
+		r.mu.replica = replica
+	}
+	replica := r.mu.replica
+	r.mu.Unlock()
+	return replica.ReadAt(ctx, p, off)
+}
+
+// RecordReplicaFallback is part of the ReplicaReadable interface.
+func (r *replicaFallbackReadable) RecordReplicaFallback(localErr error) {
+	r.p.replicaFallback(r.meta, localErr)
+}
+
+// Close is part of the Readable interface.
+func (r *replicaFallbackReadable) Close() error {
+	err := r.Readable.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mu.replica != nil {
+		err = firstError(err, r.mu.replica.Close())
+		r.mu.replica = nil
+	}
+	return err
+}
@@ -4,7 +4,13 @@
 
 package objstorage
 
-import "io"
+import (
+	"io"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+)
 
 // sharedWritable is a very simple implementation of Writable on top of the
 // WriteCloser returned by shared.Storage.CreateObject.
@@ -32,3 +38,143 @@ func (w *sharedWritable) Abort() {
 	_ = w.storageWriter.Close()
 	w.storageWriter = nil
 }
+
+const (
+	// defaultUploadPartSize is the default value of
+	// Settings.Shared.UploadPartSize.
+	defaultUploadPartSize = 8 << 20 // 8 MB
+	// defaultMaxUploadAttempts is the default value of
+	// Settings.Shared.MaxUploadAttempts.
+	defaultMaxUploadAttempts = 3
+	// defaultUploadRetryBackoff is the default value of
+	// Settings.Shared.UploadRetryBackoff.
+	defaultUploadRetryBackoff = 100 * time.Millisecond
+	// maxUploadRetryBackoff bounds the delay between two attempts of the
+	// upload of a part.
+	maxUploadRetryBackoff = 10 * time.Second
+)
+
+// sharedMultipartWritable is an implementation of Writable on top of a
+// shared.MultipartUpload. Written data is buffered and uploaded in parts of a
+// fixed size; a part that fails to upload is retried on its own, with
+// exponential backoff, without restarting the upload of the object.
+type sharedMultipartWritable struct {
+	upload      shared.MultipartUpload
+	objName     string
+	partSize    int
+	maxAttempts int
+	// backoff is the delay before the first retry; it doubles with each
+	// subsequent retry, up to maxUploadRetryBackoff.
+	backoff time.Duration
+	// buf accumulates the data for the next part.
+	buf      []byte
+	numParts int
+}
+
+var _ Writable = (*sharedMultipartWritable)(nil)
+
+func newSharedMultipartWritable(
+	upload shared.MultipartUpload,
+	objName string,
+	partSize, maxAttempts int,
+	backoff time.Duration,
+) *sharedMultipartWritable {
+	if partSize <= 0 {
+		partSize = defaultUploadPartSize
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxUploadAttempts
+	}
+	if backoff <= 0 {
+		backoff = defaultUploadRetryBackoff
+	}
+	return &sharedMultipartWritable{
+		upload:      upload,
+		objName:     objName,
+		partSize:    partSize,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+	}
+}
+
+// Write is part of the Writable interface.
+func (w *sharedMultipartWritable) Write(p []byte) error {
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.partSize)
+		}
+		n := copy(w.buf[len(w.buf):w.partSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		if len(w.buf) == w.partSize {
+			if err := w.uploadPart(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// uploadPart uploads the buffered data as the next part, making up to
+// maxAttempts attempts.
+func (w *sharedMultipartWritable) uploadPart() error {
+	partNum := w.numParts + 1
+	err := w.retry(func() error {
+		return w.upload.UploadPart(partNum, w.buf)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "uploading part %d of %q", partNum, errors.Safe(w.objName))
+	}
+	w.numParts = partNum
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// retry calls fn until it succeeds, up to maxAttempts times, backing off
+// exponentially between attempts.
+func (w *sharedMultipartWritable) retry(fn func() error) error {
+	backoff := w.backoff
+	var err error
+	for attempt := 0; attempt < w.maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxUploadRetryBackoff {
+				backoff = maxUploadRetryBackoff
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Finish is part of the Writable interface.
+func (w *sharedMultipartWritable) Finish() error {
+	// An object always has at least one (possibly empty) part.
+	var err error
+	if len(w.buf) > 0 || w.numParts == 0 {
+		err = w.uploadPart()
+	}
+	if err == nil {
+		err = w.retry(func() error {
+			return w.upload.Complete(w.numParts)
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "completing upload of %q", errors.Safe(w.objName))
+		}
+	}
+	if err != nil {
+		_ = w.upload.Abort()
+	}
+	w.upload = nil
+	w.buf = nil
+	return err
+}
+
+// Abort is part of the Writable interface.
+func (w *sharedMultipartWritable) Abort() {
+	_ = w.upload.Abort()
+	w.upload = nil
+	w.buf = nil
+}
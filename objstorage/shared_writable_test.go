@@ -0,0 +1,122 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyStorage wraps a shared.MultipartStorage, failing the first attempt of
+// each part upload and of each upload completion.
+type flakyStorage struct {
+	shared.MultipartStorage
+	attempts int
+}
+
+func (s *flakyStorage) CreateMultipartUpload(basename string) (shared.MultipartUpload, error) {
+	u, err := s.MultipartStorage.CreateMultipartUpload(basename)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyUpload{MultipartUpload: u, s: s, failed: make(map[int]bool)}, nil
+}
+
+type flakyUpload struct {
+	shared.MultipartUpload
+	s *flakyStorage
+	// failed records the parts that already failed once; part 0 is used for
+	// Complete.
+	failed map[int]bool
+}
+
+func (u *flakyUpload) UploadPart(partNum int, data []byte) error {
+	return u.maybeFail(partNum, func() error { return u.MultipartUpload.UploadPart(partNum, data) })
+}
+
+func (u *flakyUpload) Complete(numParts int) error {
+	return u.maybeFail(0, func() error { return u.MultipartUpload.Complete(numParts) })
+}
+
+func (u *flakyUpload) maybeFail(key int, fn func() error) error {
+	u.s.attempts++
+	if !u.failed[key] {
+		u.failed[key] = true
+		return errors.New("injected error")
+	}
+	return fn()
+}
+
+func TestSharedMultipartUpload(t *testing.T) {
+	store := &flakyStorage{MultipartStorage: shared.NewInMem().(shared.MultipartStorage)}
+	st := DefaultSettings(vfs.NewMem(), "")
+	st.Shared.Storage = store
+	st.Shared.UploadPartSize = 100
+	st.Shared.UploadRetryBackoff = time.Microsecond
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NoError(t, p.SetCreatorID(1))
+
+	ctx := context.Background()
+	for i, size := range []int{0, 99, 100, 250} {
+		fileNum := base.FileNum(i + 1)
+		data := make([]byte, size)
+		for j := range data {
+			data[j] = byte(j)
+		}
+		store.attempts = 0
+		w, meta, err := p.Create(ctx, base.FileTypeTable, fileNum, CreateOptions{PreferSharedStorage: true})
+		require.NoError(t, err)
+		require.True(t, meta.IsShared())
+		require.IsType(t, (*sharedMultipartWritable)(nil), w)
+		require.NoError(t, w.Write(append([]byte(nil), data...)))
+		require.NoError(t, w.Finish())
+
+		// Each part and the completion failed once, and were retried.
+		numParts := (size + 99) / 100
+		if numParts == 0 {
+			numParts = 1
+		}
+		require.Equal(t, 2*(numParts+1), store.attempts)
+
+		r, err := p.OpenForReading(ctx, base.FileTypeTable, fileNum, OpenOptions{})
+		require.NoError(t, err)
+		require.Equal(t, int64(size), r.Size())
+		buf := make([]byte, size)
+		if size > 0 {
+			_, err = r.ReadAt(ctx, buf, 0)
+			require.NoError(t, err)
+		}
+		require.Equal(t, data, buf)
+		require.NoError(t, r.Close())
+	}
+
+	// When all attempts fail, the error is returned and the upload aborted.
+	w := newSharedMultipartWritable(&flakyUpload{
+		MultipartUpload: mustCreateUpload(t, store.MultipartStorage, "foo"),
+		s:               store,
+		failed:          make(map[int]bool),
+	}, "foo", 100, 1, time.Microsecond)
+	require.NoError(t, w.Write([]byte("hello")))
+	require.Error(t, w.Finish())
+	_, err = store.Size("foo")
+	require.Error(t, err)
+}
+
+func mustCreateUpload(
+	t *testing.T, s shared.MultipartStorage, basename string,
+) shared.MultipartUpload {
+	u, err := s.CreateMultipartUpload(basename)
+	require.NoError(t, err)
+	return u
+}
@@ -27,6 +27,11 @@ func (p *Provider) vfsOpenForReading(
 		}
 		return nil, err
 	}
+	if p.st.DirectIOReads && fileType == base.FileTypeTable {
+		if err := vfs.SetDirectIO(file, true); err == nil {
+			return newDirectIOReadable(file)
+		}
+	}
 	// TODO(radu): we use the existence of the file descriptor as an indication
 	// that the File might support Prefetch and SequentialReadsOption. We should
 	// replace this with a cleaner way to obtain the capabilities of the FS / File.
@@ -37,21 +42,26 @@ func (p *Provider) vfsOpenForReading(
 }
 
 func (p *Provider) vfsCreate(
-	_ context.Context, fileType base.FileType, fileNum base.FileNum,
+	ctx context.Context, fileType base.FileType, fileNum base.FileNum,
 ) (Writable, ObjectMetadata, error) {
 	filename := p.vfsPath(fileType, fileNum)
 	file, err := p.st.FS.Create(filename)
 	if err != nil {
 		return nil, ObjectMetadata{}, err
 	}
-	file = vfs.NewSyncingFile(file, vfs.SyncingFileOptions{
-		NoSyncOnClose: p.st.NoSyncOnClose,
-		BytesPerSync:  p.st.BytesPerSync,
-	})
+	if class, ok := vfs.IOClassFromContext(ctx); ok {
+		vfs.SetIOClass(file, class)
+	}
+	directIO := p.st.DirectIOWrites && fileType == base.FileTypeTable &&
+		vfs.SetDirectIO(file, true) == nil
+	file = vfs.NewSyncingFile(file, p.syncingFileOptions())
 	meta := ObjectMetadata{
 		FileNum:  fileNum,
 		FileType: fileType,
 	}
+	if directIO {
+		return newDirectIOWritable(file), meta, nil
+	}
 	return newFileBufferedWritable(file), meta, nil
 }
 
@@ -0,0 +1,195 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/cockroachdb/pebble/internal/invariants"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// directIOBufferSize is the size of the aligned buffer used by a
+// directIOWritable. It must be a multiple of vfs.DirectIOAlignment.
+const directIOBufferSize = 256 << 10 // 256 KB
+
+const directIOAlignMask = vfs.DirectIOAlignment - 1
+
+var directIOWriteBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := vfs.AlignedBuffer(directIOBufferSize)
+		return &buf
+	},
+}
+
+// directIOWritable implements objstorage.Writable on top of a vfs.File that
+// has direct I/O enabled. Data is accumulated in an aligned buffer and only
+// written out in multiples of vfs.DirectIOAlignment; the unaligned tail of
+// the object is written with direct I/O disabled when the object is
+// finished.
+type directIOWritable struct {
+	file vfs.File
+	// buf is aligned to vfs.DirectIOAlignment and has capacity
+	// directIOBufferSize.
+	buf  []byte
+	bufp *[]byte
+}
+
+var _ Writable = (*directIOWritable)(nil)
+
+func newDirectIOWritable(file vfs.File) *directIOWritable {
+	bufp := directIOWriteBufPool.Get().(*[]byte)
+	return &directIOWritable{
+		file: file,
+		buf:  (*bufp)[:0],
+		bufp: bufp,
+	}
+}
+
+// Write is part of the objstorage.Writable interface.
+func (w *directIOWritable) Write(p []byte) error {
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(len(w.buf)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush writes out the first n bytes of the buffer, where n is a multiple of
+// vfs.DirectIOAlignment, and moves the remainder to the front of the buffer.
+func (w *directIOWritable) flush(n int) error {
+	if _, err := w.file.Write(w.buf[:n]); err != nil {
+		return err
+	}
+	w.buf = w.buf[:copy(w.buf[:cap(w.buf)], w.buf[n:])]
+	return nil
+}
+
+// Finish is part of the objstorage.Writable interface.
+func (w *directIOWritable) Finish() error {
+	var err error
+	if aligned := len(w.buf) &^ directIOAlignMask; aligned > 0 {
+		err = w.flush(aligned)
+	}
+	if err == nil && len(w.buf) > 0 {
+		// The tail of the object cannot be written with direct I/O, since its
+		// length is not aligned.
+		err = vfs.SetDirectIO(w.file, false)
+		if err == nil {
+			_, err = w.file.Write(w.buf)
+		}
+	}
+	if err == nil {
+		err = w.file.Sync()
+	}
+	err = firstError(err, w.file.Close())
+	w.release()
+	return err
+}
+
+// Abort is part of the objstorage.Writable interface.
+func (w *directIOWritable) Abort() {
+	_ = w.file.Close()
+	w.release()
+}
+
+func (w *directIOWritable) release() {
+	directIOWriteBufPool.Put(w.bufp)
+	w.file = nil
+	w.buf = nil
+	w.bufp = nil
+}
+
+var directIOReadBufPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+// directIOReadable implements objstorage.Readable on top of a vfs.File that
+// has direct I/O enabled. Each read is expanded to the enclosing aligned range
+// and performed into an aligned scratch buffer. Since direct I/O bypasses the
+// OS page cache, read-ahead is not supported.
+type directIOReadable struct {
+	file vfs.File
+	size int64
+
+	rh NoopReadHandle
+}
+
+var _ Readable = (*directIOReadable)(nil)
+
+func newDirectIOReadable(file vfs.File) (*directIOReadable, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := &directIOReadable{
+		file: file,
+		size: info.Size(),
+	}
+	r.rh = MakeNoopReadHandle(r)
+	invariants.SetFinalizer(r, func(obj interface{}) {
+		if obj.(*directIOReadable).file != nil {
+			fmt.Fprintf(os.Stderr, "Readable was not closed")
+			os.Exit(1)
+		}
+	})
+	return r, nil
+}
+
+// ReadAt is part of the objstorage.Readable interface.
+func (r *directIOReadable) ReadAt(ctx context.Context, p []byte, off int64) (n int, err error) {
+	start := off &^ directIOAlignMask
+	end := (off + int64(len(p)) + directIOAlignMask) &^ directIOAlignMask
+	bufp := directIOReadBufPool.Get().(*[]byte)
+	defer directIOReadBufPool.Put(bufp)
+	if int64(cap(*bufp)) < end-start {
+		*bufp = vfs.AlignedBuffer(int(end - start))
+	}
+	buf := (*bufp)[:end-start]
+
+	n, err = readAtWithContext(ctx, r.file, buf, start)
+	// Discard the bytes read before off.
+	n -= int(off - start)
+	if n < 0 {
+		n = 0
+	}
+	if n >= len(p) {
+		// We may have hit EOF past the end of the requested range.
+		return copy(p, buf[off-start:]), nil
+	}
+	n = copy(p, buf[off-start:int(off-start)+n])
+	if err == nil {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close is part of the objstorage.Readable interface.
+func (r *directIOReadable) Close() error {
+	defer func() { r.file = nil }()
+	return r.file.Close()
+}
+
+// Size is part of the objstorage.Readable interface.
+func (r *directIOReadable) Size() int64 {
+	return r.size
+}
+
+// NewReadHandle is part of the objstorage.Readable interface.
+func (r *directIOReadable) NewReadHandle(_ context.Context) ReadHandle {
+	return &r.rh
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package objstorage
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectIO(t *testing.T) {
+	dir := t.TempDir()
+	f, err := vfs.Default.Create(vfs.Default.PathJoin(dir, "probe"))
+	require.NoError(t, err)
+	err = vfs.SetDirectIO(f, true)
+	require.NoError(t, f.Close())
+	if err != nil {
+		t.Skipf("direct I/O not supported: %v", err)
+	}
+
+	st := DefaultSettings(vfs.Default, dir)
+	st.DirectIOWrites = true
+	st.DirectIOReads = true
+	p, err := Open(st)
+	require.NoError(t, err)
+	defer p.Close()
+
+	ctx := context.Background()
+	rng := rand.New(rand.NewSource(1))
+	for i, size := range []int{0, 1, 4095, 4096, 4097, directIOBufferSize, 3*directIOBufferSize/2 + 17} {
+		fileNum := base.FileNum(i + 1)
+		data := make([]byte, size)
+		rng.Read(data)
+
+		w, _, err := p.Create(ctx, base.FileTypeTable, fileNum, CreateOptions{})
+		require.NoError(t, err)
+		require.IsType(t, (*directIOWritable)(nil), w)
+		// Write in uneven pieces, with a copy since Write may modify its input.
+		for rest := data; len(rest) > 0; {
+			n := 1 + rng.Intn(10000)
+			if n > len(rest) {
+				n = len(rest)
+			}
+			require.NoError(t, w.Write(append([]byte(nil), rest[:n]...)))
+			rest = rest[n:]
+		}
+		require.NoError(t, w.Finish())
+
+		r, err := p.OpenForReading(ctx, base.FileTypeTable, fileNum, OpenOptions{})
+		require.NoError(t, err)
+		require.IsType(t, (*directIOReadable)(nil), r)
+		require.Equal(t, int64(size), r.Size())
+
+		buf := make([]byte, size)
+		n, err := r.ReadAt(ctx, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, size, n)
+		require.Equal(t, data, buf)
+
+		for j := 0; j < 20 && size > 0; j++ {
+			off := rng.Intn(size)
+			buf := make([]byte, rng.Intn(size-off)+1)
+			n, err := r.ReadAt(ctx, buf, int64(off))
+			require.NoError(t, err)
+			require.Equal(t, len(buf), n)
+			require.Equal(t, data[off:off+n], buf)
+		}
+
+		// Reads past the end of the object are short.
+		off := size - 5
+		if off < 0 {
+			off = 0
+		}
+		n, err = r.ReadAt(ctx, make([]byte, 10), int64(off))
+		require.Equal(t, io.EOF, err)
+		require.Equal(t, size-off, n)
+		require.NoError(t, r.Close())
+	}
+}
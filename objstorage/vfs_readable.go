@@ -48,8 +48,8 @@ func newFileReadable(file vfs.File, fs vfs.FS, filename string) (*fileReadable,
 }
 
 // ReadAt is part of the objstorage.Readable interface.
-func (r *fileReadable) ReadAt(_ context.Context, p []byte, off int64) (n int, err error) {
-	return r.file.ReadAt(p, off)
+func (r *fileReadable) ReadAt(ctx context.Context, p []byte, off int64) (n int, err error) {
+	return readAtWithContext(ctx, r.file, p, off)
 }
 
 // Close is part of the objstorage.Readable interface.
@@ -67,12 +67,16 @@ func (r *fileReadable) Size() int64 {
 func (r *fileReadable) NewReadHandle(_ context.Context) ReadHandle {
 	rh := readHandlePool.Get().(*vfsReadHandle)
 	rh.r = r
+	rh.ioClass = vfs.IOClassIterator
 	return rh
 }
 
 type vfsReadHandle struct {
 	r  *fileReadable
 	rs readaheadState
+	// ioClass is the class the reads performed through the handle are
+	// attributed to, unless the context of the read carries one.
+	ioClass vfs.IOClass
 
 	// sequentialFile holds a file descriptor to the same underlying File,
 	// except with fadvise(FADV_SEQUENTIAL) called on it to take advantage of
@@ -109,10 +113,10 @@ func (rh *vfsReadHandle) Close() error {
 }
 
 // ReadAt is part of the objstorage.ReadHandle interface.
-func (rh *vfsReadHandle) ReadAt(_ context.Context, p []byte, offset int64) (n int, err error) {
+func (rh *vfsReadHandle) ReadAt(ctx context.Context, p []byte, offset int64) (n int, err error) {
 	if rh.sequentialFile != nil {
 		// Use OS-level read-ahead.
-		return rh.sequentialFile.ReadAt(p, offset)
+		return readAtWithIOClass(ctx, rh.sequentialFile, p, offset, rh.ioClass)
 	}
 	if readaheadSize := rh.rs.maybeReadahead(offset, int64(len(p))); readaheadSize > 0 {
 		if readaheadSize >= maxReadaheadSize {
@@ -123,7 +127,12 @@ func (rh *vfsReadHandle) ReadAt(_ context.Context, p []byte, offset int64) (n in
 			_ = rh.r.file.Prefetch(offset, readaheadSize)
 		}
 	}
-	return rh.r.file.ReadAt(p, offset)
+	return readAtWithIOClass(ctx, rh.r.file, p, offset, rh.ioClass)
+}
+
+// setIOClass implements the ioClassSetter interface (see SetIOClass).
+func (rh *vfsReadHandle) setIOClass(class vfs.IOClass) {
+	rh.ioClass = class
 }
 
 // MaxReadahead is part of the objstorage.ReadHandle interface.
@@ -154,8 +163,6 @@ func (rh *vfsReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {
 type genericFileReadable struct {
 	file vfs.File
 	size int64
-
-	rh NoopReadHandle
 }
 
 var _ Readable = (*genericFileReadable)(nil)
@@ -169,7 +176,6 @@ func newGenericFileReadable(file vfs.File) (*genericFileReadable, error) {
 		file: file,
 		size: info.Size(),
 	}
-	r.rh = MakeNoopReadHandle(r)
 	invariants.SetFinalizer(r, func(obj interface{}) {
 		if obj.(*genericFileReadable).file != nil {
 			fmt.Fprintf(os.Stderr, "Readable was not closed")
@@ -180,8 +186,8 @@ func newGenericFileReadable(file vfs.File) (*genericFileReadable, error) {
 }
 
 // ReadAt is part of the objstorage.Readable interface.
-func (r *genericFileReadable) ReadAt(_ context.Context, p []byte, off int64) (n int, err error) {
-	return r.file.ReadAt(p, off)
+func (r *genericFileReadable) ReadAt(ctx context.Context, p []byte, off int64) (n int, err error) {
+	return readAtWithContext(ctx, r.file, p, off)
 }
 
 // Close is part of the objstorage.Readable interface.
@@ -197,7 +203,48 @@ func (r *genericFileReadable) Size() int64 {
 
 // NewReadHandle is part of the objstorage.Readable interface.
 func (r *genericFileReadable) NewReadHandle(_ context.Context) ReadHandle {
-	return &r.rh
+	rh := genericReadHandlePool.Get().(*genericReadHandle)
+	rh.r = r
+	rh.ioClass = vfs.IOClassIterator
+	return rh
+}
+
+// genericReadHandle is the ReadHandle of a genericFileReadable. It doesn't
+// support read-ahead, and only keeps track of the I/O class of its reads.
+type genericReadHandle struct {
+	r       *genericFileReadable
+	ioClass vfs.IOClass
+}
+
+var _ ReadHandle = (*genericReadHandle)(nil)
+
+var genericReadHandlePool = sync.Pool{
+	New: func() interface{} {
+		return &genericReadHandle{}
+	},
+}
+
+// ReadAt is part of the objstorage.ReadHandle interface.
+func (rh *genericReadHandle) ReadAt(ctx context.Context, p []byte, off int64) (n int, err error) {
+	return readAtWithIOClass(ctx, rh.r.file, p, off, rh.ioClass)
+}
+
+// Close is part of the objstorage.ReadHandle interface.
+func (rh *genericReadHandle) Close() error {
+	*rh = genericReadHandle{}
+	genericReadHandlePool.Put(rh)
+	return nil
+}
+
+// MaxReadahead is part of the objstorage.ReadHandle interface.
+func (*genericReadHandle) MaxReadahead() {}
+
+// RecordCacheHit is part of the objstorage.ReadHandle interface.
+func (*genericReadHandle) RecordCacheHit(_ context.Context, offset, size int64) {}
+
+// setIOClass implements the ioClassSetter interface (see SetIOClass).
+func (rh *genericReadHandle) setIOClass(class vfs.IOClass) {
+	rh.ioClass = class
 }
 
 // TestingCheckMaxReadahead returns true if the ReadHandle has switched to
@@ -205,3 +252,39 @@ func (r *genericFileReadable) NewReadHandle(_ context.Context) ReadHandle {
 func TestingCheckMaxReadahead(rh ReadHandle) bool {
 	return rh.(*vfsReadHandle).sequentialFile != nil
 }
+
+// ioClassSetter is implemented by the ReadHandles that attribute their reads
+// to an I/O class.
+type ioClassSetter interface {
+	setIOClass(class vfs.IOClass)
+}
+
+// SetIOClass attributes the reads performed through the read handle to the
+// given class (see vfs.WithRateLimiting). Read handles attribute their reads
+// to vfs.IOClassIterator by default. It is a no-op if the read handle does not
+// support I/O classes.
+func SetIOClass(rh ReadHandle, class vfs.IOClass) {
+	if s, ok := rh.(ioClassSetter); ok {
+		s.setIOClass(class)
+	}
+}
+
+// readAtWithContext reads from the file, attributing the read to the I/O
+// class attached to the context, if any (see vfs.ContextWithIOClass).
+func readAtWithContext(ctx context.Context, file vfs.File, p []byte, off int64) (int, error) {
+	if class, ok := vfs.IOClassFromContext(ctx); ok {
+		return vfs.ReadAtWithIOClass(file, p, off, class)
+	}
+	return file.ReadAt(p, off)
+}
+
+// readAtWithIOClass is like readAtWithContext, except that the read is
+// attributed to the given class if the context doesn't carry one.
+func readAtWithIOClass(
+	ctx context.Context, file vfs.File, p []byte, off int64, class vfs.IOClass,
+) (int, error) {
+	if c, ok := vfs.IOClassFromContext(ctx); ok {
+		class = c
+	}
+	return vfs.ReadAtWithIOClass(file, p, off, class)
+}
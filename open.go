@@ -6,7 +6,6 @@ package pebble
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
@@ -28,6 +27,7 @@ import (
 	"github.com/cockroachdb/pebble/record"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -54,6 +54,24 @@ func TableCacheSize(maxOpenFiles int) int {
 	return tableCacheSize
 }
 
+// openFilesBudget splits Options.MaxOpenFiles between the table cache and the
+// files of the secondary cache, returning the size of the table cache and the
+// maximum number of open secondary cache files.
+func (o *Options) openFilesBudget() (tableCacheSize, secondaryCacheFiles int) {
+	maxOpenFiles := o.MaxOpenFiles
+	if o.Experimental.SecondaryCacheDir != "" {
+		secondaryCacheFiles = o.Experimental.SecondaryCacheMaxOpenFiles
+		if secondaryCacheFiles <= 0 {
+			secondaryCacheFiles = maxOpenFiles / 4
+		}
+		if secondaryCacheFiles < 1 {
+			secondaryCacheFiles = 1
+		}
+		maxOpenFiles -= secondaryCacheFiles
+	}
+	return TableCacheSize(maxOpenFiles), secondaryCacheFiles
+}
+
 // Open opens a DB whose files live in the given directory.
 func Open(dirname string, opts *Options) (db *DB, _ error) {
 	// Make a copy of the options so that we don't mutate the passed in options.
@@ -62,6 +80,10 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
+	if opts.StrictReadOnly {
+		opts.ReadOnly = true
+		opts.FS = vfs.NewReadOnly(opts.FS)
+	}
 	if opts.LoggerAndTracer == nil {
 		opts.LoggerAndTracer = &base.LoggerWithNoopTracer{Logger: opts.Logger}
 	} else {
@@ -96,6 +118,12 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	}()
 
+	// Migrate the files of a store written by another fork or version of
+	// Pebble before reading any of them.
+	if err := opts.migrateFiles(dirname); err != nil {
+		return nil, errors.Wrapf(err, "pebble: migrating database %q", dirname)
+	}
+
 	// Establish the format major version.
 	formatVersion, formatVersionMarker, err := lookupFormatMajorVersion(opts.FS, dirname)
 	if err != nil {
@@ -149,10 +177,13 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		fileLock:            fileLock,
 		dataDir:             dataDir,
 		walDir:              walDir,
-		logRecycler:         logRecycler{limit: opts.MemTableStopWritesThreshold + 1},
+		logRecycler:         logRecycler{limit: walRecycleLimit(opts)},
 		closed:              new(atomic.Value),
 		closedCh:            make(chan struct{}),
 	}
+	if opts.CacheQuota > 0 {
+		opts.Cache.SetQuota(d.cacheID, opts.CacheQuota)
+	}
 	d.mu.versions = &versionSet{}
 	d.atomic.diskAvailBytes = math.MaxUint64
 	d.mu.versions.diskAvailBytes = d.getDiskAvailableBytesCached
@@ -167,6 +198,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 			// the tableCache, and if there are no other references to
 			// the tableCache, then the tableCache will also release its
 			// reference to the cache.
+			opts.Cache.ReleaseID(d.cacheID)
 			opts.Cache.Unref()
 
 			if d.tableCache != nil {
@@ -192,12 +224,16 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	d.commit = newCommitPipeline(commitEnv{
 		logSeqNum:     &d.mu.versions.atomic.logSeqNum,
 		visibleSeqNum: &d.mu.versions.atomic.visibleSeqNum,
+		published:     opts.SeqNumPublished,
 		apply:         d.commitApply,
 		write:         d.commitWrite,
 	})
 	d.deletionLimiter = rate.NewLimiter(
 		rate.Limit(d.opts.Experimental.MinDeletionRate),
 		d.opts.Experimental.MinDeletionRate)
+	d.deletionFileLimiter = rate.NewLimiter(
+		rate.Limit(d.opts.Experimental.MinDeletionFileRate),
+		d.opts.Experimental.MinDeletionFileRate)
 	d.mu.nextJobID = 1
 	d.mu.mem.nextSize = opts.MemTableSize
 	if d.mu.mem.nextSize > initialMemTableSize {
@@ -281,22 +317,81 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		FSCleaner:           opts.Cleaner,
 		NoSyncOnClose:       opts.NoSyncOnClose,
 		BytesPerSync:        opts.BytesPerSync,
+		DirectIOWrites:      opts.Experimental.DirectIOWrites,
+		DirectIOReads:       opts.Experimental.DirectIOReads,
 	}
 	providerSettings.Shared.Storage = opts.Experimental.SharedStorage
+	providerSettings.Shared.UploadPartSize = opts.Experimental.SharedUploadPartSize
+	providerSettings.Shared.MaxUploadAttempts = opts.Experimental.SharedMaxUploadAttempts
+	providerSettings.Shared.MaxConcurrentReads = opts.Experimental.SharedMaxConcurrentReads
+	providerSettings.Shared.HedgedReadPercentile = opts.Experimental.SharedHedgedReadPercentile
+	providerSettings.Shared.HedgedReadMinDelay = opts.Experimental.SharedHedgedReadMinDelay
+	providerSettings.Shared.CacheDirName = opts.Experimental.SecondaryCacheDir
+	providerSettings.Shared.CacheSize = opts.Experimental.SecondaryCacheSize
+	providerSettings.Shared.CacheChunkSize = opts.Experimental.SecondaryCacheChunkSize
+	tableCacheSize, secondaryCacheFiles := opts.openFilesBudget()
+	providerSettings.Shared.CacheMaxOpenFiles = secondaryCacheFiles
+	providerSettings.Shared.ReplicateLocalObjects = opts.Experimental.ReplicateLocalTables
+	providerSettings.Shared.DeduplicateReplicas = opts.Experimental.DeduplicateReplicas
+	providerSettings.Shared.OnReplicaFallback = func(meta objstorage.ObjectMetadata, err error) {
+		if meta.FileType == fileTypeTable {
+			opts.EventListener.TableRepaired(TableRepairInfo{FileNum: meta.FileNum, Err: err})
+		}
+	}
 
 	d.objProvider, err = objstorage.Open(providerSettings)
 	if err != nil {
 		return nil, err
 	}
+	d.setObjectMetadataLocked()
 
+	// missingTables holds the sstables to remove from the LSM because they do
+	// not exist, with RecoverySkipMissingTables.
+	var missingTables map[deletedFileEntry]*fileMetadata
 	if manifestExists {
 		curVersion := d.mu.versions.currentVersion()
-		if err := checkConsistency(curVersion, dirname, d.objProvider); err != nil {
+		var onMissing func(level int, f *fileMetadata)
+		if opts.RecoveryMode >= RecoverySkipMissingTables {
+			onMissing = func(level int, f *fileMetadata) {
+				if missingTables == nil {
+					missingTables = make(map[deletedFileEntry]*fileMetadata)
+				}
+				missingTables[deletedFileEntry{Level: level, FileNum: f.FileNum}] = f
+				d.recoveryReport.MissingTables = append(d.recoveryReport.MissingTables, MissingTableInfo{
+					Level:    level,
+					FileNum:  f.FileNum,
+					Size:     f.Size,
+					Smallest: f.Smallest,
+					Largest:  f.Largest,
+				})
+			}
+		}
+		var missing *MissingTablesError
+		if opts.Experimental.VerifyTablesOnOpen && onMissing == nil {
+			if d.objProvider.CanReplicate() && !opts.ReadOnly {
+				if err := restoreMissingTables(curVersion, d.objProvider, opts.Logger); err != nil {
+					return nil, err
+				}
+			}
+			missing = &MissingTablesError{}
+			onMissing = func(level int, f *fileMetadata) {
+				missing.Tables = append(missing.Tables, MissingTableInfo{
+					Level:    level,
+					FileNum:  f.FileNum,
+					Size:     f.Size,
+					Smallest: f.Smallest,
+					Largest:  f.Largest,
+				})
+			}
+		}
+		if err := checkConsistency(curVersion, dirname, d.objProvider, onMissing); err != nil {
 			return nil, err
 		}
+		if missing != nil && len(missing.Tables) > 0 {
+			return nil, missing
+		}
 	}
 
-	tableCacheSize := TableCacheSize(opts.MaxOpenFiles)
 	d.tableCache = newTableCacheContainer(opts.TableCache, d.cacheID, d.objProvider, d.opts, tableCacheSize)
 	d.newIters = d.tableCache.newIters
 	d.tableNewRangeKeyIter = d.tableCache.newRangeKeyIter
@@ -362,13 +457,53 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		return logFiles[i].num < logFiles[j].num
 	})
 
-	var ve versionEdit
+	// Remove the missing sstables from the LSM, along with their contribution
+	// to the level metrics.
+	ve := versionEdit{DeletedFiles: missingTables}
+	removedMetrics := make(map[int]*LevelMetrics)
+	for e, f := range missingTables {
+		m := removedMetrics[e.Level]
+		if m == nil {
+			m = &LevelMetrics{}
+			removedMetrics[e.Level] = m
+		}
+		m.NumFiles--
+		m.Size -= int64(f.Size)
+	}
+
+	progress := walReplayProgress{
+		listener: d.opts.EventListener.WALReplayProgress,
+		info:     WALReplayInfo{JobID: jobID},
+	}
+	for _, lf := range logFiles {
+		if info, err := opts.FS.Stat(opts.FS.PathJoin(d.walDirname, lf.name)); err == nil {
+			progress.info.TotalBytes += info.Size()
+		}
+	}
 	var toFlush flushableList
 	for i, lf := range logFiles {
 		lastWAL := i == len(logFiles)-1
+		walPath := opts.FS.PathJoin(d.walDirname, lf.name)
+		if len(d.recoveryReport.WALs) > 0 {
+			// A previous WAL was corrupt. Replaying this WAL would leave a gap
+			// in the recovered writes, so drop it.
+			var size int64
+			if info, err := opts.FS.Stat(walPath); err == nil {
+				size = info.Size()
+			}
+			d.recoveryReport.WALs = append(d.recoveryReport.WALs, DroppedWALInfo{
+				FileNum: lf.num,
+				Size:    size,
+			})
+			d.mu.versions.markFileNumUsed(lf.num)
+			continue
+		}
 		flush, maxSeqNum, err := d.replayWAL(jobID, &ve, opts.FS,
-			opts.FS.PathJoin(d.walDirname, lf.name), lf.num, strictWALTail && !lastWAL)
+			walPath, lf.num, strictWALTail && !lastWAL, &progress)
 		if err != nil {
+			for _, entry := range toFlush {
+				entry.readerUnref(false /* deleteFiles */)
+			}
 			return nil, err
 		}
 		toFlush = append(toFlush, flush...)
@@ -378,6 +513,10 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		}
 	}
 	d.mu.versions.atomic.visibleSeqNum = d.mu.versions.atomic.logSeqNum
+	if !d.recoveryReport.Empty() {
+		d.opts.Logger.Infof("pebble: recovery with mode %s dropped data:\n%s",
+			d.opts.RecoveryMode, d.recoveryReport)
+	}
 
 	if !d.opts.ReadOnly {
 		// Create an empty .log file.
@@ -393,7 +532,14 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 		// crash before the manifest is synced could leave two WALs with
 		// unclean tails.
 		d.mu.versions.logLock()
-		if err := d.mu.versions.logAndApply(jobID, &ve, newFileMetrics(ve.NewFiles), false /* forceRotation */, func() []compactionInfo {
+		metrics := newFileMetrics(ve.NewFiles)
+		for level, m := range removedMetrics {
+			if metrics[level] == nil {
+				metrics[level] = &LevelMetrics{}
+			}
+			metrics[level].Add(m)
+		}
+		if err := d.mu.versions.logAndApply(jobID, &ve, metrics, false /* forceRotation */, func() []compactionInfo {
 			return nil
 		}); err != nil {
 			return nil, err
@@ -455,35 +601,7 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 
 	if !d.opts.ReadOnly {
 		// Write the current options to disk.
-		d.optionsFileNum = d.mu.versions.getNextFileNum()
-		tmpPath := base.MakeFilepath(opts.FS, dirname, fileTypeTemp, d.optionsFileNum)
-		optionsPath := base.MakeFilepath(opts.FS, dirname, fileTypeOptions, d.optionsFileNum)
-
-		// Write them to a temporary file first, in case we crash before
-		// we're done. A corrupt options file prevents opening the
-		// database.
-		optionsFile, err := opts.FS.Create(tmpPath)
-		if err != nil {
-			return nil, err
-		}
-		serializedOpts := []byte(opts.String())
-		if _, err := optionsFile.Write(serializedOpts); err != nil {
-			return nil, errors.CombineErrors(err, optionsFile.Close())
-		}
-		d.optionsFileSize = uint64(len(serializedOpts))
-		if err := optionsFile.Sync(); err != nil {
-			return nil, errors.CombineErrors(err, optionsFile.Close())
-		}
-		if err := optionsFile.Close(); err != nil {
-			return nil, err
-		}
-		// Atomically rename to the OPTIONS-XXXXXX path. This rename is
-		// guaranteed to be atomic because the destination path does not
-		// exist.
-		if err := opts.FS.Rename(tmpPath, optionsPath); err != nil {
-			return nil, err
-		}
-		if err := d.dataDir.Sync(); err != nil {
+		if err := d.writeOptionsFileLocked(); err != nil {
 			return nil, err
 		}
 	}
@@ -497,9 +615,40 @@ func Open(dirname string, opts *Options) (db *DB, _ error) {
 	}
 	d.mu.tableStats.cond.L = &d.mu.Mutex
 	d.mu.tableValidation.cond.L = &d.mu.Mutex
+	d.mu.ingest.cond.L = &d.mu.Mutex
+	d.mu.cacheWarmup.cond.L = &d.mu.Mutex
+	d.mu.scrub.cond.L = &d.mu.Mutex
+	d.mu.remote.cond.L = &d.mu.Mutex
+	d.mu.metricsReport.cond.L = &d.mu.Mutex
 	if !d.opts.ReadOnly && !d.opts.private.disableTableStats {
 		d.maybeCollectTableStatsLocked()
 	}
+	if d.opts.CacheWarmupFile != "" {
+		d.mu.cacheWarmup.warming = true
+		go d.warmCache()
+	}
+	if !d.opts.ReadOnly && d.opts.Experimental.ReplicateManifest && d.opts.Experimental.SharedStorage != nil {
+		d.startManifestReplicatorLocked()
+	}
+	if !d.opts.ReadOnly && d.opts.Experimental.ReplicateLocalTables &&
+		d.opts.Experimental.ReplicaUploadConcurrency > 0 && d.opts.Experimental.SharedStorage != nil {
+		d.startReplicaUploaderLocked()
+	}
+	if !d.opts.ReadOnly && d.opts.Experimental.ScrubInterval > 0 {
+		d.mu.scrub.background = true
+		go d.scrubBackground()
+	}
+	if d.opts.private.remoteFS != nil {
+		d.mu.remote.lastRefresh = d.timeNow()
+		if d.opts.Experimental.RemoteMaxStaleness > 0 {
+			d.mu.remote.background = true
+			go d.refreshRemoteBackground()
+		}
+	}
+	if d.opts.MetricsInterval > 0 {
+		d.mu.metricsReport.running = true
+		go d.reportMetrics()
+	}
 	d.calculateDiskAvailableBytes()
 
 	d.maybeScheduleFlush()
@@ -645,10 +794,20 @@ func GetVersion(dir string, fs vfs.FS) (string, error) {
 // to the manifest, it is up to the caller of replayWAL to unreference the
 // toFlush flushables returned by replayWAL.
 //
+// If Options.RecoveryMode tolerates corrupt WALs, the replay stops at the
+// first corruption, which is recorded in d.recoveryReport. The progress of the
+// replay is reported through progress.
+//
 // d.mu must be held when calling this, but the mutex may be dropped and
 // re-acquired during the course of this method.
 func (d *DB) replayWAL(
-	jobID int, ve *versionEdit, fs vfs.FS, filename string, logNum FileNum, strictWALTail bool,
+	jobID int,
+	ve *versionEdit,
+	fs vfs.FS,
+	filename string,
+	logNum FileNum,
+	strictWALTail bool,
+	progress *walReplayProgress,
 ) (toFlush flushableList, maxSeqNum uint64, err error) {
 	file, err := fs.Open(filename)
 	if err != nil {
@@ -663,7 +822,16 @@ func (d *DB) replayWAL(
 		rr              = record.NewReader(file, logNum)
 		offset          int64 // byte offset in rr
 		lastFlushOffset int64
+		// memEntries holds the memtables created by the replay, unless in
+		// read-only mode.
+		memEntries []*flushableEntry
 	)
+	progress.startWAL(logNum)
+	defer func() {
+		if err == nil {
+			progress.finishWAL(rr.Offset())
+		}
+	}()
 
 	if d.opts.ReadOnly {
 		// In read-only mode, we replay directly into the mutable memtable which will
@@ -701,8 +869,35 @@ func (d *DB) replayWAL(
 		if d.opts.ReadOnly {
 			d.mu.mem.mutable = mem
 			d.mu.mem.queue = append(d.mu.mem.queue, entry)
+		} else {
+			memEntries = append(memEntries, entry)
 		}
 	}
+	// Release the memtables if the replay fails, since they are not in the
+	// memtable queue, which Open releases.
+	defer func() {
+		if err != nil {
+			for _, e := range memEntries {
+				e.readerUnref(false /* deleteFiles */)
+			}
+		}
+	}()
+
+	// With Experimental.WALReplayConcurrency, the batches are applied to the
+	// memtables concurrently, like the commit pipeline does. Space in the
+	// memtables is still reserved in WAL order, so that the memtables have
+	// the same contents as when applying the batches serially. All the
+	// batches must be applied before the memtables are flushed or released.
+	var applyGroup *errgroup.Group
+	if n := d.opts.Experimental.WALReplayConcurrency; n > 1 {
+		applyGroup = &errgroup.Group{}
+		applyGroup.SetLimit(n)
+		defer func() {
+			if applyErr := applyGroup.Wait(); err == nil && applyErr != nil {
+				toFlush, maxSeqNum, err = nil, 0, applyErr
+			}
+		}()
+	}
 
 	// updateVE is used to update ve with information about new files created
 	// during the flush of any flushable not of type ingestedFlushable. For the
@@ -737,12 +932,20 @@ func (d *DB) replayWAL(
 			} else if record.IsInvalidRecord(err) && !strictWALTail {
 				break
 			}
-			return nil, 0, errors.Wrap(err, "pebble: error when replaying WAL")
+			err = errors.Wrap(err, "pebble: error when replaying WAL")
+			if d.maybeDropWALTail(file, logNum, offset, err) {
+				break
+			}
+			return nil, 0, err
 		}
 
 		if buf.Len() < batchHeaderLen {
-			return nil, 0, base.CorruptionErrorf("pebble: corrupt log file %q (num %s)",
+			err := base.CorruptionErrorf("pebble: corrupt log file %q (num %s)",
 				filename, errors.Safe(logNum))
+			if d.maybeDropWALTail(file, logNum, offset, err) {
+				break
+			}
+			return nil, 0, err
 		}
 
 		if d.opts.ErrorIfNotPristine {
@@ -761,11 +964,11 @@ func (d *DB) replayWAL(
 			if kind, encodedFileNum, _, _ := br.Next(); kind == InternalKeyKindIngestSST {
 				fileNums := make([]FileNum, 0, b.Count())
 				addFileNum := func(encodedFileNum []byte) {
-					fileNum, n := binary.Uvarint(encodedFileNum)
-					if n <= 0 {
+					fileNum, err := DecodeIngestSST(encodedFileNum)
+					if err != nil {
 						panic("pebble: ingest sstable file num is invalid.")
 					}
-					fileNums = append(fileNums, base.FileNum(fileNum))
+					fileNums = append(fileNums, fileNum)
 				}
 				addFileNum(encodedFileNum)
 
@@ -791,7 +994,7 @@ func (d *DB) replayWAL(
 
 				var meta []*manifest.FileMetadata
 				meta, _, err = ingestLoad(
-					d.opts, d.mu.formatVers.vers, paths, d.cacheID, fileNums,
+					d.opts, d.mu.formatVers.vers, paths, nil /* bounds */, d.cacheID, fileNums,
 				)
 				if err != nil {
 					return nil, 0, err
@@ -845,6 +1048,7 @@ func (d *DB) replayWAL(
 						ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: 0, Meta: file})
 					}
 				}
+				progress.recordReplayed(rr.Offset())
 				return toFlush, maxSeqNum, nil
 			}
 		}
@@ -889,15 +1093,37 @@ func (d *DB) replayWAL(
 					return nil, 0, err
 				}
 			}
-			if err = mem.apply(&b, seqNum); err != nil {
-				return nil, 0, err
+			if applyGroup != nil {
+				// Copy the batch, since buf is reused by the next record.
+				pb := &Batch{db: d}
+				if err = pb.SetRepr(append([]byte(nil), b.data...)); err != nil {
+					return nil, 0, err
+				}
+				mem := mem
+				applyGroup.Go(func() error {
+					if err := mem.apply(pb, seqNum); err != nil {
+						return err
+					}
+					mem.writerUnref()
+					return nil
+				})
+			} else {
+				if err = mem.apply(&b, seqNum); err != nil {
+					return nil, 0, err
+				}
+				mem.writerUnref()
 			}
-			mem.writerUnref()
 		}
+		progress.recordReplayed(rr.Offset())
 		buf.Reset()
 	}
 	flushMem()
 	// mem is nil here.
+	if applyGroup != nil {
+		if err = applyGroup.Wait(); err != nil {
+			return nil, 0, err
+		}
+	}
 	if !d.opts.ReadOnly {
 		err = updateVE()
 		if err != nil {
@@ -907,6 +1133,66 @@ func (d *DB) replayWAL(
 	return toFlush, maxSeqNum, err
 }
 
+// walReplayProgressInterval is the number of bytes of WALs replayed between
+// two periodic WAL replay progress events.
+const walReplayProgressInterval = 64 << 20
+
+// walReplayProgress tracks the progress of the replay of the WALs during
+// Open, and reports it to the EventListener.
+type walReplayProgress struct {
+	listener func(WALReplayInfo)
+	info     WALReplayInfo
+	// walStart is the value of info.BytesReplayed when the replay of the
+	// current WAL started.
+	walStart     int64
+	lastReported int64
+}
+
+func (p *walReplayProgress) startWAL(fileNum FileNum) {
+	p.info.FileNum = fileNum
+	p.info.Done = false
+	p.walStart = p.info.BytesReplayed
+}
+
+// recordReplayed is called after a record is replayed, with the offset in the
+// WAL that follows the record.
+func (p *walReplayProgress) recordReplayed(offset int64) {
+	p.info.RecordsReplayed++
+	p.info.BytesReplayed = p.walStart + offset
+	if p.info.BytesReplayed-p.lastReported >= walReplayProgressInterval {
+		p.report()
+	}
+}
+
+// finishWAL is called once the current WAL is replayed, with the offset in
+// the WAL at which the replay stopped.
+func (p *walReplayProgress) finishWAL(offset int64) {
+	p.info.BytesReplayed = p.walStart + offset
+	p.info.Done = true
+	p.report()
+}
+
+func (p *walReplayProgress) report() {
+	p.lastReported = p.info.BytesReplayed
+	p.listener(p.info)
+}
+
+// maybeDropWALTail records that the WAL is dropped from the given offset
+// because of the corruption err, if Options.RecoveryMode tolerates it, and
+// returns whether it does.
+func (d *DB) maybeDropWALTail(file vfs.File, logNum FileNum, offset int64, err error) bool {
+	if d.opts.RecoveryMode < RecoveryTolerateCorruptWALTail ||
+		!(errors.Is(err, ErrCorruption) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return false
+	}
+	info := DroppedWALInfo{FileNum: logNum, Offset: offset, Size: offset, Err: err}
+	if stat, err := file.Stat(); err == nil {
+		info.Size = stat.Size()
+	}
+	d.recoveryReport.WALs = append(d.recoveryReport.WALs, info)
+	return true
+}
+
 func checkOptions(opts *Options, path string) (strictWALTail bool, err error) {
 	f, err := opts.FS.Open(path)
 	if err != nil {
@@ -918,9 +1204,85 @@ func checkOptions(opts *Options, path string) (strictWALTail bool, err error) {
 	if err != nil {
 		return false, err
 	}
+	if m := opts.Experimental.OpenMigration; m != nil && m.RewriteOptions != nil {
+		rewritten, err := m.RewriteOptions(string(data))
+		if err != nil {
+			return false, errors.Wrapf(err, "pebble: rewriting %q", path)
+		}
+		return opts.checkOptions(rewritten)
+	}
 	return opts.checkOptions(string(data))
 }
 
+// writeOptionsFileLocked writes the effective options of the DB to a new
+// OPTIONS file. The format major version recorded in the file is the DB's
+// current format major version, which may be higher than the one specified in
+// the Options. The previous OPTIONS file, if any, becomes obsolete. d.mu must
+// be held when calling this method, and is released while the file is
+// written.
+func (d *DB) writeOptionsFileLocked() error {
+	// Writes are serialized, so that the options snapshotted last are written
+	// last. optionsWriteMu must be acquired before d.mu.
+	d.mu.Unlock()
+	d.optionsWriteMu.Lock()
+	defer d.optionsWriteMu.Unlock()
+	d.mu.Lock()
+
+	opts := d.opts.Clone()
+	opts.FormatMajorVersion = d.mu.formatVers.vers
+	fileNum := d.mu.versions.getNextFileNum()
+
+	d.mu.Unlock()
+	err := d.writeOptionsFile(opts, fileNum)
+	d.mu.Lock()
+	return err
+}
+
+// writeOptionsFile writes the given options to the OPTIONS file with the given
+// file number, and makes it the current OPTIONS file. d.optionsWriteMu must be
+// held, and d.mu must not be held.
+func (d *DB) writeOptionsFile(opts *Options, fileNum FileNum) error {
+	tmpPath := base.MakeFilepath(opts.FS, d.dirname, fileTypeTemp, fileNum)
+	optionsPath := base.MakeFilepath(opts.FS, d.dirname, fileTypeOptions, fileNum)
+
+	// Write them to a temporary file first, in case we crash before
+	// we're done. A corrupt options file prevents opening the
+	// database.
+	optionsFile, err := opts.FS.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	serializedOpts := []byte(opts.String())
+	if _, err := optionsFile.Write(serializedOpts); err != nil {
+		return errors.CombineErrors(err, optionsFile.Close())
+	}
+	if err := optionsFile.Sync(); err != nil {
+		return errors.CombineErrors(err, optionsFile.Close())
+	}
+	if err := optionsFile.Close(); err != nil {
+		return err
+	}
+	// Atomically rename to the OPTIONS-XXXXXX path. This rename is
+	// guaranteed to be atomic because the destination path does not
+	// exist.
+	if err := opts.FS.Rename(tmpPath, optionsPath); err != nil {
+		return err
+	}
+	if err := d.dataDir.Sync(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prevFileNum, prevFileSize := d.optionsFileNum, d.optionsFileSize
+	d.optionsFileNum = fileNum
+	d.optionsFileSize = uint64(len(serializedOpts))
+	if prevFileNum != 0 {
+		d.mu.versions.obsoleteOptions = append(d.mu.versions.obsoleteOptions,
+			fileInfo{fileNum: prevFileNum, fileSize: prevFileSize})
+	}
+	return nil
+}
+
 // DBDesc briefly describes high-level state about a database.
 type DBDesc struct {
 	// Exists is true if an existing database was found.
@@ -986,29 +1348,29 @@ var ErrDBAlreadyExists = errors.New("pebble: database already exists")
 // Note that errors can be wrapped with more details; use errors.Is().
 var ErrDBNotPristine = errors.New("pebble: database already exists and is not pristine")
 
-func checkConsistency(v *manifest.Version, dirname string, objProvider *objstorage.Provider) error {
+// checkConsistency checks that the sstables of the version exist with the
+// expected sizes. If onMissing is non-nil, it is called for the sstables which
+// do not exist, instead of reporting them as errors.
+func checkConsistency(
+	v *manifest.Version,
+	dirname string,
+	objProvider *objstorage.Provider,
+	onMissing func(level int, f *fileMetadata),
+) error {
 	var buf bytes.Buffer
 	var args []interface{}
 
 	for level, files := range v.Levels {
 		iter := files.Iter()
 		for f := iter.First(); f != nil; f = iter.Next() {
-			meta, err := objProvider.Lookup(base.FileTypeTable, f.FileNum)
-			var size int64
-			if err == nil {
-				size, err = objProvider.Size(meta)
+			err := checkTableObject(objProvider, f)
+			if err != nil && onMissing != nil && objstorage.IsNotExistError(err) {
+				onMissing(level, f)
+				continue
 			}
 			if err != nil {
 				buf.WriteString("L%d: %s: %v\n")
 				args = append(args, errors.Safe(level), errors.Safe(f.FileNum), err)
-				continue
-			}
-
-			if size != int64(f.Size) {
-				buf.WriteString("L%d: %s: object size mismatch (%s): %d (disk) != %d (MANIFEST)\n")
-				args = append(args, errors.Safe(level), errors.Safe(f.FileNum), objProvider.Path(meta),
-					errors.Safe(size), errors.Safe(f.Size))
-				continue
 			}
 		}
 	}
@@ -0,0 +1,50 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// OpenMigration adapts the artifacts of a store written by another fork or
+// version of Pebble, so that the store can be opened without manual surgery
+// (see Options.Experimental.OpenMigration). Each of the hooks is optional.
+//
+// The hooks must be idempotent: they are run every time the store is opened,
+// and must leave unchanged the artifacts they already migrated, or which were
+// written by this version of Pebble.
+type OpenMigration struct {
+	// MigrateFiles is called by Open once the directory of the store is
+	// locked, before any of its files is read. It may rewrite, rename or
+	// remove the files of the store, e.g. the files specific to another fork.
+	// It is not called when the store is opened read-only.
+	MigrateFiles func(fs vfs.FS, dirname string) error
+
+	// RewriteOptions is called with the contents of the most recent OPTIONS
+	// file of the store, and returns the contents to check against the
+	// Options instead, e.g. with the keys or values written by another fork
+	// renamed. Note that Open then writes a new OPTIONS file reflecting the
+	// Options.
+	RewriteOptions func(data string) (string, error)
+
+	// RewriteTableProperties is called with the properties of each sstable
+	// read by the store, before they are checked against the Comparer and
+	// Merger, and may modify them, e.g. to rename the merger or the user
+	// properties recorded by another fork. The sstables are not rewritten, so
+	// the hook applies to their properties as long as they are part of the
+	// store.
+	RewriteTableProperties func(props *sstable.Properties) error
+}
+
+// migrateFiles runs the MigrateFiles hook of the OpenMigration of the
+// options, if any.
+func (o *Options) migrateFiles(dirname string) error {
+	m := o.Experimental.OpenMigration
+	if m == nil || m.MigrateFiles == nil || o.ReadOnly {
+		return nil
+	}
+	return m.MigrateFiles(o.FS, dirname)
+}
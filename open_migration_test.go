@@ -0,0 +1,79 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMigration(t *testing.T) {
+	// Write a store with a merger which another fork named differently, and a
+	// file specific to that fork.
+	mem := vfs.NewMem()
+	forkMerger := *DefaultMerger
+	forkMerger.Name = "fork.merge"
+	d, err := Open("", &Options{FS: mem, Merger: &forkMerger})
+	require.NoError(t, err)
+	require.NoError(t, d.Merge([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Close())
+	f, err := mem.Create("FORK-STATE")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The store can't be opened with the default merger.
+	_, err = Open("", &Options{FS: mem})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "merger name from file \"fork.merge\"")
+
+	migration := &OpenMigration{
+		MigrateFiles: func(fs vfs.FS, dirname string) error {
+			if _, err := fs.Stat(fs.PathJoin(dirname, "FORK-STATE")); err != nil {
+				return nil
+			}
+			return fs.Remove(fs.PathJoin(dirname, "FORK-STATE"))
+		},
+		RewriteOptions: func(data string) (string, error) {
+			return strings.ReplaceAll(data, "merger=fork.merge", "merger="+DefaultMerger.Name), nil
+		},
+	}
+	opts := &Options{FS: mem}
+	opts.Experimental.OpenMigration = migration
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	_, err = mem.Stat("FORK-STATE")
+	require.Error(t, err)
+	// The sstable still records the merger of the fork.
+	_, _, err = d.Get([]byte("a"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown merger fork.merge")
+	require.NoError(t, d.Close())
+
+	migration.RewriteTableProperties = func(props *sstable.Properties) error {
+		if props.MergerName == "fork.merge" {
+			props.MergerName = DefaultMerger.Name
+		}
+		return nil
+	}
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+	require.NoError(t, d.Close())
+
+	// Open wrote a new OPTIONS file, so the store can now be opened without
+	// migrating its OPTIONS.
+	migration.RewriteOptions = nil
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+}
@@ -0,0 +1,590 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// OpenRemoteReadOnly opens a DB in read-only mode directly from shared
+// storage, without a local directory. The files of the DB (CURRENT or the
+// manifest marker, MANIFEST, OPTIONS, WALs and sstables) must be stored as
+// objects named after the files with the given prefix, e.g. "backup/" for
+// the objects "backup/MANIFEST-000001", "backup/000004.sst", etc., as when a
+// checkpoint (see DB.Checkpoint) is uploaded. Objects under the prefix whose
+// names contain a further "/" are ignored.
+//
+// The files are read from storage on demand, and the data read is cached in
+// the block cache (see Options.Cache). The storage is not closed when the DB
+// is closed.
+//
+// The DB is opened as if with Options.StrictReadOnly, and initially reads
+// the state of the remote DB at the time it is opened. Its view follows the
+// remote DB as it is refreshed, either explicitly (see DB.RefreshRemote) or
+// in the background (see Options.Experimental.RemoteMaxStaleness). The
+// objects of the sstables must not be modified while the DB is open, nor
+// removed while they may still be read.
+func OpenRemoteReadOnly(storage shared.Storage, prefix string, opts *Options) (*DB, error) {
+	opts = opts.Clone()
+	fs, err := newRemoteFS(storage, prefix)
+	if err != nil {
+		return nil, err
+	}
+	opts.FS = fs
+	opts.WALDir = ""
+	opts.StrictReadOnly = true
+	opts.private.remoteFS = fs
+	return Open("", opts)
+}
+
+// RefreshRemote refreshes the view of the remote DB of a DB opened with
+// OpenRemoteReadOnly: the sstables flushed, ingested or compacted by the
+// remote DB since the last refresh are added to or removed from the LSM, as
+// recorded by its current manifest. The WALs of the remote DB are only
+// replayed when the DB is opened, and the memtables replayed from them are
+// dropped by the first refresh which changes the LSM, since the newly flushed
+// writes may overwrite or delete their keys: the writes which are only in the
+// WALs are then not visible until they are flushed. Refreshes also run in the
+// background if Options.Experimental.RemoteMaxStaleness is set; only one
+// refresh runs at a time.
+func (d *DB) RefreshRemote() error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.private.remoteFS == nil {
+		return errors.New("pebble: DB not opened with OpenRemoteReadOnly")
+	}
+	return d.refreshRemote()
+}
+
+func (d *DB) refreshRemote() error {
+	d.mu.Lock()
+	for d.mu.remote.refreshing {
+		d.mu.remote.cond.Wait()
+	}
+	d.mu.remote.refreshing = true
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.mu.remote.refreshing = false
+		d.mu.remote.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+
+	start := d.timeNow()
+	if err := d.opts.private.remoteFS.refresh(); err != nil {
+		return err
+	}
+	marker, manifestFileNum, exists, err := findCurrentManifest(d.FormatMajorVersion(), d.opts.FS, d.dirname)
+	if err != nil {
+		return err
+	}
+	if err := marker.Close(); err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("pebble: remote DB %q has no current manifest", errors.Safe(d.opts.private.remoteFS.prefix))
+	}
+
+	// Read the live sstables of each level and the last sequence number from
+	// the manifest.
+	var bve bulkVersionEdit
+	bve.AddedByFileNum = make(map[base.FileNum]*fileMetadata)
+	var lastSeqNum uint64
+	manifestPath := base.MakeFilepath(d.opts.FS, d.dirname, fileTypeManifest, manifestFileNum)
+	if err := replayManifest(d.opts.FS, d.dirname, manifestPath, d.opts.Comparer.Name, func(ve *versionEdit) error {
+		if ve.LastSeqNum != 0 {
+			lastSeqNum = ve.LastSeqNum
+		}
+		return bve.Accumulate(ve)
+	}); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.applyRemoteLocked(&bve, manifestFileNum); err != nil {
+		return err
+	}
+	// The sequence numbers of the new sstables become visible once they are
+	// part of the current version.
+	if seqNum := lastSeqNum + 1; seqNum > atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum) {
+		atomic.StoreUint64(&d.mu.versions.atomic.logSeqNum, seqNum)
+		atomic.StoreUint64(&d.mu.versions.atomic.visibleSeqNum, seqNum)
+	}
+	d.mu.remote.lastRefresh = start
+	d.mu.remote.refreshes++
+	return nil
+}
+
+// applyRemoteLocked installs a new version with the live sstables of the
+// remote DB accumulated in bve. The sstables of the current version which are
+// still live keep their metadata. d.mu must be held.
+func (d *DB) applyRemoteLocked(bve *bulkVersionEdit, manifestFileNum FileNum) error {
+	vs := d.mu.versions
+	current := vs.currentVersion()
+	ve := &versionEdit{DeletedFiles: make(map[deletedFileEntry]*fileMetadata)}
+	var added []objstorage.ObjectMetadata
+	for level := range current.Levels {
+		live := bve.Added[level]
+		existing := make(map[FileNum]struct{})
+		iter := current.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			existing[f.FileNum] = struct{}{}
+			if _, ok := live[f.FileNum]; !ok {
+				ve.DeletedFiles[deletedFileEntry{Level: level, FileNum: f.FileNum}] = f
+			}
+		}
+		for fileNum, f := range live {
+			if _, ok := existing[fileNum]; ok {
+				continue
+			}
+			ve.NewFiles = append(ve.NewFiles, newFileEntry{Level: level, Meta: f})
+			added = append(added, objstorage.ObjectMetadata{FileType: fileTypeTable, FileNum: fileNum})
+		}
+	}
+	vs.manifestFileNum = manifestFileNum
+	if len(ve.NewFiles) == 0 && len(ve.DeletedFiles) == 0 {
+		return nil
+	}
+	if err := d.objProvider.AddObjects(added); err != nil {
+		return err
+	}
+
+	newVersion, zombies, err := manifest.AccumulateAndApplySingleVE(
+		ve, current, d.cmp, d.opts.Comparer.FormatKey,
+		d.opts.FlushSplitBytes, d.opts.Experimental.ReadCompactionRate,
+	)
+	if err != nil {
+		return err
+	}
+	newVersion.L0Sublevels.InitCompactingFileInfo(nil /* in-progress compactions */)
+	// The removed sstables are zombies until they are no longer read, and are
+	// then closed, but not deleted (see doDeleteObsoleteFiles).
+	for fileNum, size := range zombies {
+		vs.zombieTables[fileNum] = size
+	}
+	vs.append(newVersion)
+	for i := range vs.metrics.Levels {
+		l := &vs.metrics.Levels[i]
+		l.NumFiles = int64(newVersion.Levels[i].Len())
+		files := newVersion.Levels[i].Slice()
+		l.Size = int64(files.SizeSum())
+		l.Sublevels = 0
+		if l.NumFiles > 0 {
+			l.Sublevels = 1
+		}
+	}
+	vs.metrics.Levels[0].Sublevels = int32(len(newVersion.L0SublevelFiles))
+	vs.picker = newCompactionPicker(newVersion, vs.opts, nil, vs.metrics.levelSizes(), vs.diskAvailBytes)
+	d.dropRemoteMemTablesLocked()
+
+	if len(vs.editSubscribers) > 0 {
+		info := makeVersionEditInfo(0 /* jobID */, ve)
+		for _, s := range vs.editSubscribers {
+			s.fn(info)
+		}
+	}
+	d.updateReadStateLocked(d.opts.DebugCheck)
+	return nil
+}
+
+// dropRemoteMemTablesLocked replaces the memtables replayed from the WALs of
+// the remote DB when it was opened with an empty memtable. The memtables
+// shadow the sstables, so they must not outlive the version they were
+// replayed on top of. d.mu must be held.
+func (d *DB) dropRemoteMemTablesLocked() {
+	if len(d.mu.mem.queue) == 1 && d.mu.mem.mutable.empty() {
+		return
+	}
+	for _, mem := range d.mu.mem.queue {
+		// The memtables are released once the iterators reading them are
+		// closed.
+		mem.readerUnrefLocked(false /* deleteFiles */)
+	}
+	var entry *flushableEntry
+	d.mu.mem.mutable, entry = d.newMemTable(0 /* logNum */, atomic.LoadUint64(&d.mu.versions.atomic.logSeqNum))
+	d.mu.mem.queue = flushableList{entry}
+}
+
+// refreshRemoteBackground refreshes the view of the remote DB every
+// Options.Experimental.RemoteMaxStaleness, until the DB is closed.
+func (d *DB) refreshRemoteBackground() {
+	defer func() {
+		d.mu.Lock()
+		d.mu.remote.background = false
+		d.mu.remote.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+	interval := d.opts.Experimental.RemoteMaxStaleness
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-timer.C:
+		}
+		start := d.timeNow()
+		if err := d.refreshRemote(); err != nil {
+			d.opts.EventListener.BackgroundError(err)
+		}
+		// Start the next refresh one interval after the start of this one, so
+		// that slow refreshes do not add to the staleness.
+		timer.Reset(interval - d.timeNow().Sub(start))
+	}
+}
+
+// remoteFS is a read-only vfs.FS whose files are the objects of a shared
+// storage with a given prefix, in a single flat directory. The names of the
+// objects are listed when the FS is created, and again on each refresh of the
+// DB (see DB.RefreshRemote).
+type remoteFS struct {
+	storage shared.Storage
+	prefix  string
+	mu      struct {
+		sync.RWMutex
+		names map[string]struct{}
+	}
+}
+
+var _ vfs.FS = (*remoteFS)(nil)
+
+func newRemoteFS(storage shared.Storage, prefix string) (*remoteFS, error) {
+	fs := &remoteFS{
+		storage: storage,
+		prefix:  prefix,
+	}
+	if err := fs.refresh(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// refresh lists the objects again, so that the files which were added to or
+// removed from the storage since the last listing are visible.
+func (fs *remoteFS) refresh() error {
+	ls, err := fs.storage.List(fs.prefix, "" /* delimiter */)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: listing objects with prefix %q", fs.prefix)
+	}
+	names := make(map[string]struct{}, len(ls))
+	for _, name := range ls {
+		// Some implementations return the names with the prefix.
+		name = strings.TrimPrefix(name, fs.prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		names[name] = struct{}{}
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.mu.names = names
+	return nil
+}
+
+func remoteReadOnlyError(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: vfs.ErrReadOnly}
+}
+
+// isDir returns true if name designates the directory of the files.
+func (fs *remoteFS) isDir(name string) bool {
+	name = path.Clean(name)
+	return name == "." || name == "/"
+}
+
+// lookup returns the name of the file within the directory, or an error if
+// there is no such file.
+func (fs *remoteFS) lookup(op, name string) (string, error) {
+	base := path.Clean(name)
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if _, ok := fs.mu.names[base]; !ok {
+		return "", &os.PathError{Op: op, Path: name, Err: oserror.ErrNotExist}
+	}
+	return base, nil
+}
+
+func (fs *remoteFS) Create(name string) (vfs.File, error) {
+	return nil, remoteReadOnlyError("create", name)
+}
+
+func (fs *remoteFS) Link(oldname, newname string) error {
+	return remoteReadOnlyError("link", newname)
+}
+
+func (fs *remoteFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	base, err := fs.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	objName := fs.prefix + base
+	size, err := fs.storage.Size(objName)
+	if err != nil {
+		return nil, err
+	}
+	f := &remoteFile{fs: fs, name: base, objName: objName, size: size}
+	for _, opt := range opts {
+		opt.Apply(f)
+	}
+	return f, nil
+}
+
+func (fs *remoteFS) OpenDir(name string) (vfs.File, error) {
+	if !fs.isDir(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: oserror.ErrNotExist}
+	}
+	return &remoteFile{fs: fs, name: name, dir: true}, nil
+}
+
+func (fs *remoteFS) Remove(name string) error {
+	return remoteReadOnlyError("remove", name)
+}
+
+func (fs *remoteFS) RemoveAll(name string) error {
+	return remoteReadOnlyError("remove", name)
+}
+
+func (fs *remoteFS) Rename(oldname, newname string) error {
+	return remoteReadOnlyError("rename", oldname)
+}
+
+func (fs *remoteFS) ReuseForWrite(oldname, newname string) (vfs.File, error) {
+	return nil, remoteReadOnlyError("reuse-for-write", oldname)
+}
+
+func (fs *remoteFS) MkdirAll(dir string, perm os.FileMode) error {
+	return remoteReadOnlyError("mkdir", dir)
+}
+
+// Lock implements vfs.FS. It does not lock anything, since the objects are
+// not expected to be modified.
+func (fs *remoteFS) Lock(name string) (io.Closer, error) {
+	return remoteFSLock{}, nil
+}
+
+type remoteFSLock struct{}
+
+func (remoteFSLock) Close() error { return nil }
+
+func (fs *remoteFS) List(dir string) ([]string, error) {
+	if !fs.isDir(dir) {
+		return nil, &os.PathError{Op: "list", Path: dir, Err: oserror.ErrNotExist}
+	}
+	fs.mu.RLock()
+	names := make([]string, 0, len(fs.mu.names))
+	for name := range fs.mu.names {
+		names = append(names, name)
+	}
+	fs.mu.RUnlock()
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *remoteFS) Stat(name string) (os.FileInfo, error) {
+	if fs.isDir(name) {
+		return remoteFileInfo{name: path.Base(name), dir: true}, nil
+	}
+	base, err := fs.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	size, err := fs.storage.Size(fs.prefix + base)
+	if err != nil {
+		return nil, err
+	}
+	return remoteFileInfo{name: base, size: size}, nil
+}
+
+func (fs *remoteFS) PathBase(p string) string {
+	return path.Base(p)
+}
+
+func (fs *remoteFS) PathJoin(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (fs *remoteFS) PathDir(p string) string {
+	return path.Dir(p)
+}
+
+func (fs *remoteFS) GetDiskUsage(p string) (vfs.DiskUsage, error) {
+	return vfs.DiskUsage{}, vfs.ErrUnsupported
+}
+
+// remoteFile is a file of a remoteFS, or its directory. Reads are served by
+// streams of the object starting at the requested offset. The last stream is
+// kept open, so that sequential reads are served by a single stream.
+type remoteFile struct {
+	fs      *remoteFS
+	name    string
+	objName string
+	size    int64
+	dir     bool
+
+	// pos is the offset of the next Read.
+	pos int64
+	mu  struct {
+		sync.Mutex
+		stream    io.ReadCloser
+		streamOff int64
+	}
+}
+
+var _ vfs.File = (*remoteFile)(nil)
+
+func (f *remoteFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closeStreamLocked()
+}
+
+func (f *remoteFile) closeStreamLocked() error {
+	if f.mu.stream == nil {
+		return nil
+	}
+	err := f.mu.stream.Close()
+	f.mu.stream = nil
+	return err
+}
+
+func (f *remoteFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *remoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.dir {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if !f.mu.TryLock() {
+		// The stream is in use by a concurrent read: use a new stream, which
+		// is not kept.
+		rc, _, err := f.fs.storage.ReadObjectAt(f.objName, off)
+		if err != nil {
+			return 0, err
+		}
+		n, err := readFull(rc, p)
+		return n, firstError(err, rc.Close())
+	}
+	defer f.mu.Unlock()
+	if f.mu.stream == nil || f.mu.streamOff != off {
+		if err := f.closeStreamLocked(); err != nil {
+			return 0, err
+		}
+		rc, _, err := f.fs.storage.ReadObjectAt(f.objName, off)
+		if err != nil {
+			return 0, err
+		}
+		f.mu.stream = rc
+		f.mu.streamOff = off
+	}
+	n, err := readFull(f.mu.stream, p)
+	f.mu.streamOff += int64(n)
+	if err != nil {
+		err = firstError(err, f.closeStreamLocked())
+	}
+	return n, err
+}
+
+// readFull reads len(p) bytes from r, returning io.EOF if fewer bytes are
+// available.
+func readFull(r io.Reader, p []byte) (int, error) {
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *remoteFile) Write(p []byte) (int, error) {
+	return 0, remoteReadOnlyError("write", f.name)
+}
+
+func (f *remoteFile) Preallocate(offset, length int64) error {
+	return remoteReadOnlyError("preallocate", f.name)
+}
+
+func (f *remoteFile) Stat() (os.FileInfo, error) {
+	return remoteFileInfo{name: path.Base(f.name), size: f.size, dir: f.dir}, nil
+}
+
+func (f *remoteFile) Sync() error {
+	return remoteReadOnlyError("sync", f.name)
+}
+
+func (f *remoteFile) SyncTo(length int64) (fullSync bool, err error) {
+	return false, remoteReadOnlyError("sync", f.name)
+}
+
+func (f *remoteFile) SyncData() error {
+	return remoteReadOnlyError("sync", f.name)
+}
+
+func (f *remoteFile) Prefetch(offset int64, length int64) error {
+	return nil
+}
+
+func (f *remoteFile) Fd() uintptr {
+	return vfs.InvalidFd
+}
+
+// remoteFileInfo implements os.FileInfo for the files of a remoteFS.
+type remoteFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+var _ os.FileInfo = remoteFileInfo{}
+
+func (i remoteFileInfo) Name() string {
+	return i.name
+}
+
+func (i remoteFileInfo) Size() int64 {
+	return i.size
+}
+
+func (i remoteFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i remoteFileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (i remoteFileInfo) IsDir() bool {
+	return i.dir
+}
+
+func (i remoteFileInfo) Sys() interface{} {
+	return nil
+}
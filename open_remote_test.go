@@ -0,0 +1,228 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRemoteReadOnly(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprint(i)), nil))
+		if i%30 == 0 {
+			require.NoError(t, d.Flush())
+		}
+	}
+	require.NoError(t, d.DeleteRange([]byte("010"), []byte("020"), nil))
+	// Leave the last writes in the WAL.
+	require.NoError(t, d.Close())
+
+	// Upload the files of the DB, along with an object in a "subdirectory"
+	// which must be ignored.
+	storage := shared.NewInMem()
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	upload := func(name string, data []byte) {
+		w, err := storage.CreateObject(name)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+	for _, name := range ls {
+		if name == "LOCK" {
+			continue
+		}
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		upload("backup/"+name, data)
+	}
+	upload("backup/nested/000001.sst", []byte("garbage"))
+
+	d, err = OpenRemoteReadOnly(storage, "backup/", &Options{})
+	require.NoError(t, err)
+	iter := d.NewIter(nil)
+	var n int
+	for iter.First(); iter.Valid(); iter.Next() {
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 90, n)
+	v, closer, err := d.Get([]byte("099"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("99"), v)
+	require.NoError(t, closer.Close())
+	_, _, err = d.Get([]byte("015"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, d.Set([]byte("a"), nil, nil), ErrReadOnly)
+	require.NoError(t, d.Close())
+
+	// There is no DB under another prefix.
+	_, err = OpenRemoteReadOnly(storage, "other/", &Options{})
+	require.Error(t, err)
+}
+
+func TestRemoteFS(t *testing.T) {
+	storage := shared.NewInMem()
+	w, err := storage.CreateObject("p/a")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	fs, err := newRemoteFS(storage, "p/")
+	require.NoError(t, err)
+	ls, err := fs.List("")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, ls)
+	_, err = fs.Open("b")
+	require.True(t, oserror.IsNotExist(err))
+	_, err = fs.Create("b")
+	require.True(t, errors.Is(err, vfs.ErrReadOnly))
+
+	f, err := fs.Open("a")
+	require.NoError(t, err)
+	info, err := f.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, 10, info.Size())
+	// Sequential and random reads.
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, "0123", string(buf))
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, "4567", string(buf))
+	n, err := f.ReadAt(buf, 1)
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(buf[:n]))
+	n, err = f.ReadAt(buf, 8)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, "89", string(buf[:n]))
+	_, err = f.ReadAt(buf, 10)
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, f.Close())
+}
+
+func TestRefreshRemote(t *testing.T) {
+	mem := vfs.NewMem()
+	leader, err := Open("", &Options{FS: mem, DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, leader.Close()) }()
+
+	// upload uploads the files of the leader which are not uploaded yet, and
+	// the manifest, which grows.
+	storage := shared.NewInMem()
+	uploaded := make(map[string]struct{})
+	upload := func() {
+		ls, err := mem.List("")
+		require.NoError(t, err)
+		for _, name := range ls {
+			if _, ok := uploaded[name]; ok || name == "LOCK" {
+				continue
+			}
+			f, err := mem.Open(name)
+			require.NoError(t, err)
+			data, err := io.ReadAll(f)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			w, err := storage.CreateObject("backup/" + name)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			if fileType, _, ok := base.ParseFilename(mem, name); ok && fileType == fileTypeTable {
+				uploaded[name] = struct{}{}
+			}
+		}
+	}
+	get := func(d *DB, key string) error {
+		v, closer, err := d.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		require.Equal(t, []byte(key), v)
+		return closer.Close()
+	}
+
+	require.NoError(t, leader.Set([]byte("a"), []byte("a"), nil))
+	require.NoError(t, leader.Flush())
+	upload()
+	d, err := OpenRemoteReadOnly(storage, "backup/", &Options{})
+	require.NoError(t, err)
+	now := time.Now().Add(time.Hour)
+	d.timeNow = func() time.Time { return now }
+	require.NoError(t, get(d, "a"))
+	m := d.Metrics()
+	require.GreaterOrEqual(t, m.Remote.Lag, time.Hour)
+	require.Equal(t, int64(0), m.Remote.Refreshes)
+
+	// Flush and compact on the leader: the follower only sees the new data
+	// once refreshed, while its open iterators keep reading the sstables
+	// which were compacted.
+	require.NoError(t, leader.Set([]byte("b"), []byte("b"), nil))
+	require.NoError(t, leader.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	upload()
+	iter := d.NewIter(nil)
+	require.ErrorIs(t, get(d, "b"), ErrNotFound)
+	require.NoError(t, d.RefreshRemote())
+	require.NoError(t, get(d, "a"))
+	require.NoError(t, get(d, "b"))
+	m = d.Metrics()
+	require.Equal(t, time.Duration(0), m.Remote.Lag)
+	require.Equal(t, int64(1), m.Remote.Refreshes)
+	require.Equal(t, leader.Metrics().Levels[numLevels-1].NumFiles, m.Levels[numLevels-1].NumFiles)
+	require.True(t, iter.First())
+	require.False(t, iter.Next())
+	require.NoError(t, iter.Close())
+	require.NoError(t, d.Close())
+
+	// Background refreshes bound the staleness.
+	opts := &Options{}
+	opts.Experimental.RemoteMaxStaleness = time.Millisecond
+	d, err = OpenRemoteReadOnly(storage, "backup/", opts)
+	require.NoError(t, err)
+	require.NoError(t, leader.Set([]byte("c"), []byte("c"), nil))
+	require.NoError(t, leader.Flush())
+	upload()
+	require.Eventually(t, func() bool {
+		return get(d, "c") == nil
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, d.Close())
+
+	// The writes replayed from the WALs when the follower is opened do not
+	// shadow the writes flushed since.
+	require.NoError(t, leader.Set([]byte("d"), []byte("d"), nil))
+	upload()
+	d, err = OpenRemoteReadOnly(storage, "backup/", &Options{})
+	require.NoError(t, err)
+	require.NoError(t, get(d, "d"))
+	require.NoError(t, leader.Delete([]byte("d"), nil))
+	require.NoError(t, leader.Flush())
+	upload()
+	require.NoError(t, d.RefreshRemote())
+	require.ErrorIs(t, get(d, "d"), ErrNotFound)
+	require.Equal(t, int64(1), d.Metrics().MemTable.Count)
+	require.NoError(t, d.Close())
+
+	// Other DBs cannot be refreshed.
+	require.Error(t, leader.RefreshRemote())
+}
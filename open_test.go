@@ -15,6 +15,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"testing"
@@ -26,6 +27,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/errorfs"
 	"github.com/cockroachdb/pebble/internal/manifest"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/cockroachdb/pebble/vfs/atomicfs"
 	"github.com/cockroachdb/redact"
@@ -161,7 +163,7 @@ func TestNewDBFilenames(t *testing.T) {
 			"LOCK",
 			"MANIFEST-000001",
 			"OPTIONS-000003",
-			"marker.format-version.000013.014",
+			"marker.format-version.000017.018",
 			"marker.manifest.000001.MANIFEST-000001",
 		},
 	}
@@ -329,6 +331,322 @@ func TestOpenOptionsCheck(t *testing.T) {
 	}
 	_, err = Open("", opts)
 	require.Regexp(t, `merger name from file.*!=.*`, err)
+
+	// AllowOptionsMismatch permits the mismatched merger, and the rewritten
+	// OPTIONS file records the new merger.
+	opts.AllowOptionsMismatch = true
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+	opts.AllowOptionsMismatch = false
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	// A mismatched comparer is refused even with AllowOptionsMismatch.
+	opts = &Options{
+		AllowOptionsMismatch: true,
+		Comparer:             &Comparer{Name: "foo"},
+		FS:                   mem,
+	}
+	_, err = Open("", opts)
+	require.Regexp(t, `comparer name from file.*!=.*`, err)
+}
+
+func TestOpenOptionsFileFormatMajorVersion(t *testing.T) {
+	mem := vfs.NewMem()
+	readOptionsFile := func(d *DB) string {
+		d.mu.Lock()
+		optionsFileNum := d.optionsFileNum
+		d.mu.Unlock()
+		f, err := mem.Open(base.MakeFilepath(mem, "", fileTypeOptions, optionsFileNum))
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		return string(data)
+	}
+
+	// Ratcheting the format major version rewrites the OPTIONS file.
+	d, err := Open("", &Options{FS: mem, FormatMajorVersion: FormatMostCompatible})
+	require.NoError(t, err)
+	require.Contains(t, readOptionsFile(d), fmt.Sprintf("format_major_version=%d\n", FormatMostCompatible))
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatNewest))
+	require.Contains(t, readOptionsFile(d), fmt.Sprintf("format_major_version=%d\n", FormatNewest))
+	require.NoError(t, d.Close())
+
+	// Reopening with an older format major version keeps the DB at its
+	// current version, which is what the OPTIONS file should record.
+	d, err = Open("", &Options{FS: mem, FormatMajorVersion: FormatMostCompatible})
+	require.NoError(t, err)
+	require.Contains(t, readOptionsFile(d), fmt.Sprintf("format_major_version=%d\n", FormatNewest))
+	require.NoError(t, d.Close())
+}
+
+func TestOpenChecksumChange(t *testing.T) {
+	mem := vfs.NewMem()
+	checksums := []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXH3, ChecksumTypeXXHash64}
+	for i, checksum := range checksums {
+		opts := &Options{FS: mem, FormatMajorVersion: FormatXXH3Checksums}
+		opts.Experimental.Checksum = checksum
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), nil))
+		require.NoError(t, d.Flush())
+
+		// The newly flushed table uses the configured checksum, which is
+		// recorded in the first byte of the footer of the table.
+		tables, err := d.SSTables()
+		require.NoError(t, err)
+		var newest SSTableInfo
+		for _, info := range tables[0] {
+			if info.FileNum > newest.FileNum {
+				newest = info
+			}
+		}
+		f, err := mem.Open(base.MakeFilepath(mem, "", fileTypeTable, newest.FileNum))
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		const footerLen = 53
+		require.Equal(t, byte(checksum), data[len(data)-footerLen])
+
+		// Tables written with all of the previous checksums remain readable.
+		for j := 0; j <= i; j++ {
+			v, closer, err := d.Get([]byte(fmt.Sprintf("key%d", j)))
+			require.NoError(t, err)
+			require.Equal(t, []byte("value"), v)
+			require.NoError(t, closer.Close())
+		}
+		require.NoError(t, d.Close())
+	}
+}
+
+func TestXXH3ChecksumsFormatMajorVersion(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, FormatMajorVersion: FormatXXH3Checksums - 1}
+	opts.Experimental.Checksum = ChecksumTypeXXH3
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// checksum returns the checksum type of the table with the given file
+	// number.
+	checksum := func(fileNum FileNum) ChecksumType {
+		f, err := mem.Open(base.MakeFilepath(mem, "", fileTypeTable, fileNum))
+		require.NoError(t, err)
+		readable, err := sstable.NewSimpleReadable(f)
+		require.NoError(t, err)
+		r, err := sstable.NewReader(readable, sstable.ReaderOptions{})
+		require.NoError(t, err)
+		defer r.Close()
+		return r.ChecksumType()
+	}
+	flush := func() ChecksumType {
+		require.NoError(t, d.Set([]byte("a"), []byte("a"), nil))
+		require.NoError(t, d.Flush())
+		tables, err := d.SSTables()
+		require.NoError(t, err)
+		return checksum(tables[0][0].FileNum)
+	}
+
+	// The sstables are written with CRC32c checksums, and the sstables
+	// written with XXH3 checksums cannot be ingested, until the DB is
+	// ratcheted to FormatXXH3Checksums.
+	require.Equal(t, ChecksumTypeCRC32c, flush())
+	f, err := mem.Create("ext")
+	require.NoError(t, err)
+	w := sstable.NewWriter(objstorage.NewFileWritable(f), sstable.WriterOptions{
+		TableFormat: sstable.TableFormatPebblev2,
+		Checksum:    ChecksumTypeXXH3,
+	})
+	require.NoError(t, w.Set([]byte("b"), []byte("b")))
+	require.NoError(t, w.Close())
+	err = d.Ingest([]string{"ext"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "XXH3 checksums are not supported")
+
+	require.NoError(t, d.RatchetFormatMajorVersion(FormatXXH3Checksums))
+	require.NoError(t, d.Ingest([]string{"ext"}))
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.Equal(t, ChecksumTypeXXH3, flush())
+}
+
+func TestOpenWithEncryption(t *testing.T) {
+	mem := vfs.NewMem()
+	k1 := vfs.EncryptionKey{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)}
+	k2 := vfs.EncryptionKey{ID: "k2", Key: bytes.Repeat([]byte{2}, 32)}
+
+	// Write keys with the first key active, leaving some of them in the WAL.
+	d, err := Open("", &Options{FS: vfs.WithEncryption(mem, vfs.StaticKeyManager{k1})})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Close())
+
+	// None of the files contain the plaintext.
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	for _, name := range ls {
+		if name == "LOCK" {
+			continue
+		}
+		f, err := mem.Open(name)
+		require.NoError(t, err)
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.False(t, bytes.Contains(data, []byte("leveldb.BytewiseComparator")), name)
+	}
+
+	// Rotate the key. The existing files remain readable, and the files
+	// created after the rotation use the new key.
+	fs := vfs.WithEncryption(mem, vfs.StaticKeyManager{k1, k2})
+	d, err = Open("", &Options{FS: fs})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.NoError(t, d.Flush())
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}} {
+		v, closer, err := d.Get([]byte(kv[0]))
+		require.NoError(t, err)
+		require.Equal(t, kv[1], string(v))
+		require.NoError(t, closer.Close())
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	var newest SSTableInfo
+	for _, info := range tables[0] {
+		if info.FileNum > newest.FileNum {
+			newest = info
+		}
+	}
+	id, err := vfs.EncryptionKeyID(mem, base.MakeFilepath(mem, "", fileTypeTable, newest.FileNum))
+	require.NoError(t, err)
+	require.Equal(t, "k2", id)
+	require.NoError(t, d.Close())
+
+	// Without the right keys, the store cannot be opened.
+	_, err = Open("", &Options{FS: vfs.WithEncryption(mem, vfs.StaticKeyManager{
+		{ID: "k2", Key: bytes.Repeat([]byte{3}, 32)},
+	})})
+	require.Error(t, err)
+}
+
+func TestOpenCrashStates(t *testing.T) {
+	fs := vfs.NewCrashFS()
+	d, err := Open("", &Options{FS: fs})
+	require.NoError(t, err)
+
+	// Write keys with Sync, noting the number of filesystem operations that
+	// had completed when each of them was acknowledged.
+	const numKeys = 10
+	var ackedAt [numKeys]int
+	for i := 0; i < numKeys; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), Sync))
+		ackedAt[i] = fs.NumOps()
+		if i == numKeys/2 {
+			require.NoError(t, d.Flush())
+		}
+	}
+	require.NoError(t, d.Close())
+
+	// Every crash state must recover, and must contain all of the keys that
+	// were acknowledged before the crash.
+	require.NoError(t, fs.ForEachCrashState(func(numOps int, crashFS *vfs.MemFS) error {
+		d, err := Open("", &Options{FS: crashFS})
+		if err != nil {
+			return errors.Wrapf(err, "crash after %d ops", numOps)
+		}
+		for i := 0; i < numKeys && ackedAt[i] <= numOps; i++ {
+			v, closer, err := d.Get([]byte(fmt.Sprintf("key%d", i)))
+			if err != nil {
+				return errors.Wrapf(err, "crash after %d ops: key%d", numOps, i)
+			}
+			require.Equal(t, []byte("value"), v)
+			require.NoError(t, closer.Close())
+		}
+		return d.Close()
+	}))
+}
+
+type recordingIOLimiter struct {
+	mu      sync.Mutex
+	classes map[vfs.IOClass]int
+}
+
+func (l *recordingIOLimiter) Wait(class vfs.IOClass, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.classes[class] += n
+}
+
+func TestOpenWithRateLimiting(t *testing.T) {
+	limiter := &recordingIOLimiter{classes: map[vfs.IOClass]int{}}
+	d, err := Open("", &Options{
+		FS:    vfs.WithRateLimiting(vfs.NewMem(), limiter),
+		Cache: NewCache(0),
+	})
+	require.NoError(t, err)
+	defer d.opts.Cache.Unref()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), nil))
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("key0"), []byte("key2"), false /* parallelize */))
+	v, closer, err := d.Get([]byte("key1"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), v)
+	require.NoError(t, closer.Close())
+	iter := d.NewIter(nil)
+	for iter.First(); iter.Valid(); iter.Next() {
+	}
+	require.NoError(t, iter.Close())
+	require.NoError(t, d.Close())
+
+	for _, class := range []vfs.IOClass{
+		vfs.IOClassOther, vfs.IOClassWAL, vfs.IOClassFlush, vfs.IOClassCompaction, vfs.IOClassIterator,
+	} {
+		require.Greater(t, limiter.classes[class], 0, "%s", class)
+	}
+}
+
+func TestOpenWithDirectIO(t *testing.T) {
+	dir := t.TempDir()
+	opts := &Options{
+		FS:    vfs.WithLogging(vfs.Default, t.Logf),
+		Cache: NewCache(0),
+	}
+	opts.Experimental.DirectIOWrites = true
+	opts.Experimental.DirectIOReads = true
+	defer opts.Cache.Unref()
+	d, err := Open(dir, opts)
+	require.NoError(t, err)
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 500; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("key%04d", j)), value, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("key"), []byte("key9999"), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	d, err = Open(dir, opts)
+	require.NoError(t, err)
+	iter := d.NewIter(nil)
+	n := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		require.Equal(t, fmt.Sprintf("key%04d", n), string(iter.Key()))
+		require.Equal(t, value, iter.Value())
+		n++
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, 500, n)
+	require.NoError(t, d.Close())
 }
 
 func TestOpenCrashWritingOptions(t *testing.T) {
@@ -497,6 +815,43 @@ func TestOpenReadOnly(t *testing.T) {
 	}
 }
 
+func TestOpenStrictReadOnly(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem, WALDir: "wal"})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	// Leave a key in the WAL so that it is replayed.
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Close())
+	require.NoError(t, mem.Remove("LOCK"))
+	before := mem.String()
+
+	var memLog base.InMemLogger
+	d, err = Open("", &Options{
+		FS:             vfs.WithLogging(mem, memLog.Infof),
+		StrictReadOnly: true,
+		WALDir:         "wal",
+	})
+	require.NoError(t, err)
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}} {
+		v, closer, err := d.Get([]byte(kv[0]))
+		require.NoError(t, err)
+		require.Equal(t, kv[1], string(v))
+		require.NoError(t, closer.Close())
+	}
+	require.EqualValues(t, ErrReadOnly, d.Set([]byte("c"), nil, nil))
+	require.True(t, errors.Is(d.Checkpoint("checkpoint"), vfs.ErrReadOnly))
+	require.NoError(t, d.Close())
+
+	// The only operations that reached the filesystem were directory opens
+	// and closes; in particular the LOCK file was not created.
+	for _, line := range strings.Split(strings.TrimSpace(memLog.String()), "\n") {
+		require.True(t, strings.HasPrefix(line, "open-dir:") || strings.HasPrefix(line, "close:"), line)
+	}
+	require.Equal(t, before, mem.String())
+}
+
 func TestOpenWALReplay(t *testing.T) {
 	largeValue := []byte(strings.Repeat("a", 100<<10))
 	hugeValue := []byte(strings.Repeat("b", 10<<20))
@@ -1268,7 +1623,7 @@ func TestCheckConsistency(t *testing.T) {
 				}
 
 				v := manifest.NewVersion(cmp, fmtKey, 0, filesByLevel)
-				err := checkConsistency(v, dir, provider)
+				err := checkConsistency(v, dir, provider, nil /* onMissing */)
 				if err != nil {
 					if redactErr {
 						redacted := redact.Sprint(err).Redact()
@@ -1303,3 +1658,66 @@ func TestCheckConsistency(t *testing.T) {
 			}
 		})
 }
+
+func TestOpenWALReplayProgressAndConcurrency(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	const numBatches = 500
+	for i := 0; i < numBatches; i++ {
+		b := d.NewBatch()
+		require.NoError(t, b.Set([]byte(fmt.Sprintf("k%04d", i)), bytes.Repeat([]byte("v"), 200), nil))
+		require.NoError(t, b.Merge([]byte(fmt.Sprintf("m%02d", i%10)), []byte("x"), nil))
+		if i%50 == 0 {
+			require.NoError(t, b.DeleteRange([]byte(fmt.Sprintf("k%04d", i)), []byte(fmt.Sprintf("k%04d", i+5)), nil))
+		}
+		require.NoError(t, b.Commit(nil))
+	}
+	require.NoError(t, d.Close())
+
+	replay := func(concurrency int) (map[string]string, []WALReplayInfo) {
+		// Replay a copy of the WALs, so that they can be replayed again.
+		fs := vfs.NewMem()
+		_, err := vfs.Clone(mem, fs, "", "")
+		require.NoError(t, err)
+		var events []WALReplayInfo
+		opts := &Options{
+			FS: fs,
+			// Use small memtables, so that the replay fills several.
+			MemTableSize: 64 << 10,
+			EventListener: &EventListener{
+				WALReplayProgress: func(info WALReplayInfo) {
+					events = append(events, info)
+				},
+			},
+		}
+		opts.Experimental.WALReplayConcurrency = concurrency
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, d.Close()) }()
+		contents := make(map[string]string)
+		iter := d.NewIter(nil)
+		for iter.First(); iter.Valid(); iter.Next() {
+			contents[string(iter.Key())] = string(iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return contents, events
+	}
+
+	serial, events := replay(1)
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	require.True(t, last.Done)
+	require.EqualValues(t, numBatches, last.RecordsReplayed)
+	require.Greater(t, last.BytesReplayed, int64(0))
+	require.LessOrEqual(t, last.BytesReplayed, last.TotalBytes)
+	require.Contains(t, last.String(), fmt.Sprintf("replayed: %d records", numBatches))
+	require.Equal(t, "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx", serial["m00"])
+	require.NotContains(t, serial, "k0000")
+
+	for _, concurrency := range []int{2, 8} {
+		contents, events := replay(concurrency)
+		require.Equal(t, serial, contents, "concurrency=%d", concurrency)
+		require.Equal(t, last, events[len(events)-1])
+	}
+}
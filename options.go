@@ -24,8 +24,9 @@ import (
 )
 
 const (
-	cacheDefaultSize       = 8 << 20 // 8 MB
-	defaultLevelMultiplier = 10
+	cacheDefaultSize                = 8 << 20 // 8 MB
+	defaultLevelMultiplier          = 10
+	defaultTargetFileSizeMultiplier = 2
 )
 
 // Compression exports the base.Compression type.
@@ -39,6 +40,16 @@ const (
 	ZstdCompression    = sstable.ZstdCompression
 )
 
+// ChecksumType exports the sstable.ChecksumType type.
+type ChecksumType = sstable.ChecksumType
+
+// Exported ChecksumType constants.
+const (
+	ChecksumTypeCRC32c   = sstable.ChecksumTypeCRC32c
+	ChecksumTypeXXHash64 = sstable.ChecksumTypeXXHash64
+	ChecksumTypeXXH3     = sstable.ChecksumTypeXXH3
+)
+
 // FilterType exports the base.FilterType type.
 type FilterType = base.FilterType
 
@@ -101,6 +112,12 @@ func (t IterKeyType) String() string {
 //
 // Like Options, a nil *IterOptions is valid and means to use the default
 // values.
+//
+// There is no option to verify block checksums: iterators always verify the
+// checksum of every block they read from an sstable, whether the read is
+// served from disk or from the OS page cache, and surface a mismatch as a
+// corruption error. Only the blocks held in the block cache, which were
+// verified when they were read, are not verified again.
 type IterOptions struct {
 	// LowerBound specifies the smallest key (inclusive) that the iterator will
 	// return during iteration. If the iterator is seeked or iterated past this
@@ -123,6 +140,13 @@ type IterOptions struct {
 	// an intersection across all filters, i.e., all filters must indicate that the
 	// block is relevant.
 	PointKeyFilters []BlockPropertyFilter
+	// TimeRange, if set, skips the tables and blocks in tables whose point
+	// keys all have timestamps outside of the range, when the DB was written
+	// with Options.KeyTimestamp set. Like PointKeyFilters, it only prunes:
+	// keys outside of the range may still be returned, from memtables, from
+	// tables written without Options.KeyTimestamp and from blocks with
+	// timestamps both inside and outside of the range.
+	TimeRange TimeRange
 	// RangeKeyFilters can be usefd to avoid scanning tables and blocks in tables
 	// when iterating over range keys. The same requirements that apply to
 	// PointKeyFilters apply here too.
@@ -351,11 +375,34 @@ type LevelOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// CompressionDictSize is the maximum size in bytes of the Zstandard
+	// compression dictionary used for the tables of the level. When positive
+	// and Compression is ZstdCompression, flushes and compactions into the
+	// level sample the keys and values they write to train a dictionary (see
+	// sstable.TrainCompressionDict), and the most recently trained dictionary
+	// is used to compress the tables subsequently written to the level. The
+	// dictionary is stored in each table. Dictionaries significantly improve
+	// the compression ratio of small blocks of similar records. They require
+	// FormatCompressionDictionaries and the cgo Zstandard implementation, and
+	// are ignored otherwise.
+	//
+	// The default value (0) disables compression dictionaries.
+	CompressionDictSize int
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
-	// package.
+	// package. ribbon.FilterPolicy(7) from the pebble/ribbon package provides a
+	// lower false positive rate using ~30% less space, at the cost of more CPU
+	// when building the filter.
+	//
+	// Filter policies are set per level, so that levels which are mostly read
+	// with point lookups, such as L6, may use more bits per key, and levels
+	// whose filters are rarely useful may use fewer bits or no filter. Tables
+	// are written with the filter policy of the level they are written to, and
+	// keep their filter when the policy of their level changes until they are
+	// rewritten (see DB.MarkFiltersForRewrite).
 	//
 	// The default value means to use no filter.
 	FilterPolicy FilterPolicy
@@ -378,6 +425,22 @@ type LevelOptions struct {
 	// The default value is the value of BlockSize.
 	IndexBlockSize int
 
+	// DisableTwoLevelIndex disables two-level indexes for the tables of the
+	// level: each table's index is written as a single block, regardless of
+	// IndexBlockSize.
+	DisableTwoLevelIndex bool
+
+	// TopLevelIndexBlockSize is the approximate target uncompressed size in
+	// bytes of the top-level index block of the tables of the level. The
+	// top-level index block is always read in its entirety, and it can grow
+	// very large for tables with large keys. Each time a table's top-level
+	// index block grows by another TopLevelIndexBlockSize bytes, the target
+	// size of its remaining index blocks (initially IndexBlockSize) is doubled.
+	//
+	// The default value (0) places no limit on the size of the top-level index
+	// block.
+	TopLevelIndexBlockSize int
+
 	// The target file size for the level.
 	TargetFileSize int64
 }
@@ -430,6 +493,28 @@ type Options struct {
 	// The default cache size is 8 MB.
 	Cache *cache.Cache
 
+	// CacheQuota bounds the space of Cache that the DB may use, for both its
+	// blocks and the reservations accounting for its memtables. It is useful
+	// when Cache is shared between multiple DBs, to prevent one of them from
+	// evicting the blocks of the others: the blocks of a DB which is over its
+	// quota are not added to the cache. Like the size of the cache, the quota
+	// is split evenly between the cache's shards. The usage of the cache by the
+	// DB is reported by Metrics.BlockCacheDB.
+	//
+	// The default value (0) means no quota.
+	CacheQuota int64
+
+	// CacheWarmupFile is the path, on FS, of a file recording the sstable
+	// blocks that were cached by a previous instance of the DB, as written by
+	// DB.SaveCacheState. If the file exists when the DB is opened, the blocks
+	// it records are read into Cache in the background, which avoids the
+	// latency of a cold cache after a restart. Warm-up stops once Cache is
+	// full. Blocks of tables which no longer exist are ignored.
+	//
+	// Typically, DB.SaveCacheState(opts.CacheWarmupFile) is called before the
+	// DB is closed.
+	CacheWarmupFile string
+
 	// Cleaner cleans obsolete files.
 	//
 	// The default cleaner uses the DeleteCleaner.
@@ -447,6 +532,16 @@ type Options struct {
 	// or tools only, to check invariants over all the data in the database.
 	DebugCheck func(*DB) error
 
+	// DebugCheckKeyOrder, if true, checks that the keys returned by the
+	// iterators of the DB and read by compactions are ordered
+	// consistently by the Comparer, failing the iteration or compaction with
+	// an error reporting the offending keys otherwise. It detects Comparer
+	// bugs, e.g. comparisons which are not antisymmetric or transitive, and
+	// keys written with another Comparer, which would otherwise surface much
+	// later, e.g. as overlapping sstables. It is expensive, and is intended
+	// for tests and the validation of custom Comparers.
+	DebugCheckKeyOrder bool
+
 	// Disable the write-ahead log (WAL). Disabling the write-ahead log prohibits
 	// crash recovery, but can improve performance if crash recovery is not
 	// needed (e.g. when only temporary state is being stored in the database).
@@ -476,10 +571,28 @@ type Options struct {
 	// SSTs or log records to replay.
 	ErrorIfNotPristine bool
 
+	// AllowOptionsMismatch relaxes the compatibility check performed by Open
+	// against the most recent OPTIONS file of an existing database. By default,
+	// Open refuses to open a database whose OPTIONS file records a different
+	// merger or a format major version unknown to this version of Pebble.
+	// Setting AllowOptionsMismatch permits opening such a database, after which
+	// the OPTIONS file is rewritten with the new options.
+	//
+	// A mismatched comparer is never permitted, since it would silently corrupt
+	// the ordering of keys.
+	//
+	// The default value is false.
+	AllowOptionsMismatch bool
+
 	// EventListener provides hooks to listening to significant DB events such as
 	// flushes, compactions, and table deletion.
 	EventListener *EventListener
 
+	// MetricsInterval, if positive, is the interval at which the metrics of
+	// the DB are reported to EventListener.MetricsReported, along with their
+	// changes since the previous report.
+	MetricsInterval time.Duration
+
 	// Experimental contains experimental options which are off by default.
 	// These options are temporary and will eventually either be deleted, moved
 	// out of the experimental group, or made the non-adjustable default. These
@@ -499,6 +612,29 @@ type Options struct {
 		// concurrency slots as determined by the two options is chosen.
 		CompactionDebtConcurrency int
 
+		// IntraL0CompactionMinDepth is the minimum number of L0 sublevels, not
+		// already compacting, that an intra-L0 compaction must span. Intra-L0
+		// compactions are picked when no L0 -> Lbase compaction can be, and
+		// reduce L0 read-amplification by merging sublevels. Lowering this
+		// value makes intra-L0 compactions more eager. Defaults to 4, and must
+		// be >= 2, since merging a single sublevel is counterproductive.
+		IntraL0CompactionMinDepth int
+
+		// IntraL0CompactionMinFiles is the minimum number of files an intra-L0
+		// compaction must compact. Defaults to 2, and must be >= 2.
+		IntraL0CompactionMinFiles int
+
+		// ReserveL0BaseCompactionSlot reserves one of the MaxConcurrentCompactions
+		// compaction slots for L0 -> Lbase compactions. While no L0 -> Lbase
+		// compaction is running, other compactions cannot take the last free
+		// slot, and an L0 -> Lbase compaction is started in the reserved slot as
+		// soon as L0 needs compacting, regardless of L0CompactionConcurrency and
+		// CompactionDebtConcurrency. This keeps L0 from building up many
+		// sublevels under ingest-heavy workloads while the other slots are busy
+		// with compactions of lower levels. The reservation only applies when
+		// MaxConcurrentCompactions is greater than 1.
+		ReserveL0BaseCompactionSlot bool
+
 		// MinDeletionRate is the minimum number of bytes per second that would
 		// be deleted. Deletion pacing is used to slow down deletions when
 		// compactions finish up or readers close, and newly-obsolete files need
@@ -510,6 +646,15 @@ type Options struct {
 		// deletion pacing, which is also the default.
 		MinDeletionRate int
 
+		// MinDeletionFileRate is the minimum number of obsolete sstables per
+		// second that would be deleted. It paces deletions like MinDeletionRate,
+		// for filesystems and object stores whose deletion cost depends on the
+		// number of files rather than their size, such as shared storage which
+		// issues a request per deleted object. When both are set, deletions are
+		// paced by both. Setting this to 0 disables pacing by number of files,
+		// which is also the default.
+		MinDeletionFileRate int
+
 		// ReadCompactionRate controls the frequency of read triggered
 		// compactions by adjusting `AllowedSeeks` in manifest.FileMetadata:
 		//
@@ -569,6 +714,23 @@ type Options struct {
 		// By default, this value is false.
 		ValidateOnIngest bool
 
+		// IngestValidators are run on the properties of each sstable passed
+		// to Ingest, before any of them is linked into the DB, and reject the
+		// sstables for which one of them returns an error (see
+		// IngestRejectedError). See RequireUserProperties, MaxIngestEntries
+		// and RequireComparer for common validations.
+		IngestValidators []IngestValidator
+
+		// IngestCopy forces ingested sstables to be copied into the DB
+		// directory instead of hard linked, so that the DB does not share the
+		// files with the caller, for example with a backup tool which assumes
+		// that each DB owns its files. Whether each sstable was copied is
+		// reported by the TableIngested event.
+		//
+		// By default, this value is false: sstables are hard linked if the
+		// filesystem supports it, and copied otherwise.
+		IngestCopy bool
+
 		// LevelMultiplier configures the size multiplier used to determine the
 		// desired size of each level of the LSM. Defaults to 10.
 		LevelMultiplier int
@@ -578,6 +740,18 @@ type Options struct {
 		// compaction will never get triggered.
 		MultiLevelCompactionHueristic MultiLevelHeuristic
 
+		// Checksum specifies the checksum used for the blocks of newly written
+		// sstables. Existing sstables are always verified using the checksum
+		// they were written with, so this option may be changed at any time.
+		// ChecksumTypeXXH3 is significantly faster than ChecksumTypeCRC32c on
+		// platforms where CRC32c is not hardware accelerated, but sstables
+		// written with it cannot be read by older versions of Pebble: the DB
+		// only uses it once its format major version is at least
+		// FormatXXH3Checksums, and uses ChecksumTypeCRC32c until then.
+		//
+		// The default value (ChecksumTypeNone) uses CRC32c.
+		Checksum ChecksumType
+
 		// MaxWriterConcurrency is used to indicate the maximum number of
 		// compression workers the compression queue is allowed to use. If
 		// MaxWriterConcurrency > 0, then the Writer will use parallelism, to
@@ -602,6 +776,22 @@ type Options struct {
 		// The default value is 1, which results in no scaling of point tombstones.
 		PointTombstoneWeight float64
 
+		// TombstoneDenseCompactionThreshold enables compactions of the tables
+		// whose point tombstones make up at least this fraction of their
+		// entries into the level beneath, when they overlap tables of that
+		// level. Such tombstone-dense tables result from write-then-delete
+		// workloads, such as queues, and slow down seeks through the deleted
+		// keys even when the level sizes do not call for compactions.
+		// Tombstone-dense compactions have a lower priority than score-based
+		// compactions and do not apply to L0. Zero, the default, disables
+		// them.
+		TombstoneDenseCompactionThreshold float64
+
+		// TombstoneDenseCompactionMinCount is the minimum number of point
+		// tombstones a table must contain to be considered tombstone-dense
+		// (see TombstoneDenseCompactionThreshold). Defaults to 100.
+		TombstoneDenseCompactionMinCount uint64
+
 		// EnableValueBlocks is used to decide whether to enable writing
 		// TableFormatPebblev3 sstables. WARNING: do not return true yet, since
 		// support for TableFormatPebblev3 is incomplete and not production ready.
@@ -632,6 +822,19 @@ type Options struct {
 		// major version is at least `FormatFlushableIngest`.
 		DisableIngestAsFlushable func() bool
 
+		// DirectIOWrites enables direct (O_DIRECT) I/O for the sstables written
+		// by flushes and compactions, so that they do not pollute the OS page
+		// cache. Writes are staged in an aligned buffer. It has no effect if the
+		// FS does not support direct I/O (see vfs.SetDirectIO); currently only
+		// the default FS on Linux does.
+		DirectIOWrites bool
+
+		// DirectIOReads enables direct (O_DIRECT) I/O for sstable reads, which
+		// then bypass the OS page cache and OS-level read-ahead. This is only
+		// advisable with a block cache that is large enough to hold the working
+		// set. It has no effect if the FS does not support direct I/O.
+		DirectIOReads bool
+
 		// SharedStorage is a second FS-like storage medium that can be shared
 		// between multiple Pebble instances. It is used to store sstables only, and
 		// is managed by objstorage.Provider. Each sstable might only be written to
@@ -641,6 +844,232 @@ type Options struct {
 		// be reading this file. This FS is expected to have slower read/write
 		// performance than the default FS above.
 		SharedStorage shared.Storage
+
+		// CreateOnShared causes the sstables output by compactions (but not by
+		// flushes) to be written directly to SharedStorage, without being
+		// staged on the local filesystem, and the ingested sstables to be
+		// copied to SharedStorage instead of being linked into the local
+		// filesystem. It has no effect until the shared creator ID has been set
+		// (see DB.SetCreatorID).
+		CreateOnShared bool
+
+		// SharedUploadPartSize is the size of the parts in which sstables are
+		// uploaded to SharedStorage, if it supports multipart uploads (see
+		// shared.MultipartStorage). The default is 8MB.
+		SharedUploadPartSize int
+
+		// SharedMaxUploadAttempts is the number of times the upload of a part
+		// of an sstable to SharedStorage is attempted, with exponential
+		// backoff, before the write of the sstable fails. The default is 3.
+		SharedMaxUploadAttempts int
+
+		// SharedMaxConcurrentReads is the maximum number of reads from
+		// SharedStorage in progress at any time, across all the sstables; other
+		// reads wait. The default is 0, which does not limit the reads.
+		SharedMaxConcurrentReads int
+
+		// SharedHedgedReadPercentile, if set (e.g. to 0.95), makes a read from
+		// SharedStorage which takes longer than this percentile of the
+		// latencies of recent reads be issued again, the first of the two
+		// reads to complete being used. This bounds the stalls of iterators on
+		// the tail latencies of SharedStorage. Hedged reads count towards
+		// SharedMaxConcurrentReads.
+		SharedHedgedReadPercentile float64
+
+		// SharedHedgedReadMinDelay is the minimum time after which a read from
+		// SharedStorage is issued again (see SharedHedgedReadPercentile). The
+		// default is 10ms.
+		SharedHedgedReadMinDelay time.Duration
+
+		// SecondaryCacheDir, if set, enables a persistent cache of the contents
+		// of the sstables on SharedStorage, stored in this directory of FS. It
+		// sits between the block cache and SharedStorage: blocks that miss in
+		// the block cache are read from the secondary cache when possible, and
+		// the contents of the secondary cache survive restarts. It is intended
+		// to be placed on fast local storage (e.g. NVMe).
+		SecondaryCacheDir string
+
+		// SecondaryCacheSize is the maximum size of the secondary cache (see
+		// SecondaryCacheDir). The default is 1GB.
+		SecondaryCacheSize int64
+
+		// SecondaryCacheChunkSize is the size of the chunks in which sstables
+		// are read from SharedStorage and stored in the secondary cache (see
+		// SecondaryCacheDir). Larger chunks reduce the number of reads from
+		// SharedStorage and of files in the secondary cache, at the cost of
+		// reading more data than needed. The default is 1MB.
+		SecondaryCacheChunkSize int
+
+		// SecondaryCacheMaxOpenFiles is the number of files of the secondary
+		// cache (see SecondaryCacheDir) which are kept open. It is taken out
+		// of MaxOpenFiles, the rest of which is used by the table cache. The
+		// default is a quarter of MaxOpenFiles.
+		SecondaryCacheMaxOpenFiles int
+
+		// BufferAllocator, if set, is used to allocate the buffers that
+		// iterators and compactions use to hold copies of keys and values,
+		// and receives them back once they are no longer used. This allows
+		// applications to reduce allocations and GC pressure, e.g. by
+		// carving the buffers out of an arena. When nil, a process-wide
+		// pool of buffers is used.
+		BufferAllocator BufferAllocator
+
+		// LowPriorityFormatMigrations makes the format major version
+		// migrations that rewrite sstables yield to other compactions: a
+		// rewrite compaction is only scheduled when no other compaction is
+		// running, and only one runs at a time. Upgrades then take longer, but
+		// interfere less with the workload.
+		LowPriorityFormatMigrations bool
+
+		// WALReplayConcurrency is the number of goroutines that insert the
+		// batches replayed from the WALs into memtables when the DB is
+		// opened. The batches are still read, and their memtable space
+		// reserved, in WAL order. Values less than 2 insert the batches
+		// serially.
+		WALReplayConcurrency int
+
+		// ReplicateLocalTables makes the scrubber (see DB.Scrub) maintain a
+		// replica of every local sstable on SharedStorage, turning it into a
+		// redundancy tier: a local sstable that fails checksum validation is
+		// restored from its replica, and a corrupt or missing replica is
+		// uploaded again from the local sstable. Replicas are removed along
+		// with their sstables. Reads of a local sstable which is missing, or
+		// of a block which fails checksum validation, are served from the
+		// replica instead of failing (see EventListener.TableRepaired).
+		// Requires SharedStorage, and has no effect until the shared creator
+		// ID has been set (see DB.SetCreatorID). The uploads and deletions
+		// of replicas can be estimated without contacting any storage service
+		// by setting SharedStorage to a shared.NewDryRun storage.
+		ReplicateLocalTables bool
+
+		// ReplicaUploadConcurrency, if positive, makes the DB upload the
+		// replicas of the local sstables (see ReplicateLocalTables) in the
+		// background as soon as the sstables are added to the LSM, with at
+		// most ReplicaUploadConcurrency uploads in progress, instead of
+		// waiting for the next scrub. Failed uploads are retried with an
+		// exponential backoff. The sstables whose replica cannot be uploaded
+		// this way, e.g. because too many uploads are queued, are replicated
+		// by the next scrub. See also DB.WaitForReplicas and
+		// Metrics.ReplicaUploads.
+		ReplicaUploadConcurrency int
+
+		// ReplicateManifest makes the DB maintain a replica of its current
+		// MANIFEST on SharedStorage, named like the replicas of the local
+		// sstables (see ReplicateLocalTables). The replica is uploaded again
+		// as the MANIFEST changes, at most once every ManifestReplicaInterval,
+		// as well as when the MANIFEST is rotated and when the DB is closed;
+		// the replica of the previous MANIFEST is removed once the replica of
+		// the new one is uploaded. Failed uploads are retried. Requires
+		// SharedStorage, and has no effect until the shared creator ID has
+		// been set (see DB.SetCreatorID).
+		ReplicateManifest bool
+
+		// ManifestReplicaInterval is the minimum interval between two uploads
+		// of the replica of the MANIFEST (see ReplicateManifest). Longer
+		// intervals reduce the number of uploads under heavy compaction
+		// activity, at the cost of a replica lagging further behind the
+		// MANIFEST. The default, 0, uploads the replica after every change to
+		// the MANIFEST.
+		ManifestReplicaInterval time.Duration
+
+		// DeduplicateReplicas makes the replicas of local sstables (see
+		// ReplicateLocalTables) share their contents on SharedStorage: the
+		// contents of a replica are stored under a name derived from their
+		// SHA-256 hash, and are not uploaded again for sstables with identical
+		// contents, e.g. an sstable moved by a compaction under a new file
+		// number. The contents are never deleted from SharedStorage.
+		DeduplicateReplicas bool
+
+		// VerifyTablesOnOpen makes Open verify that every sstable referenced by
+		// the MANIFEST exists, either locally or on SharedStorage, before
+		// completing. The local sstables which are missing but have a replica
+		// on SharedStorage (see ReplicateLocalTables) are restored from their
+		// replica. If sstables are still missing, Open fails with a
+		// *MissingTablesError listing all of them, rather than with the first
+		// inconsistency encountered, or with errors on the first access to the
+		// sstables. It has no effect with RecoverySkipMissingTables, which
+		// removes the missing sstables from the LSM instead.
+		VerifyTablesOnOpen bool
+
+		// ScrubInterval, if positive, runs a scrub of the sstables (see
+		// DB.Scrub) in the background, with this interval between the end of
+		// a scrub and the start of the next one.
+		ScrubInterval time.Duration
+
+		// ScrubBytesPerSec limits the rate at which the scrubber reads
+		// sstables and their replicas. Zero means no limit.
+		ScrubBytesPerSec int64
+
+		// TableStatsLoadRate limits the number of sstables per second whose
+		// table stats are loaded by the background scan of the sstables which
+		// existed at Open, to limit its impact on foreground traffic. The
+		// stats of the sstables added by flushes, compactions and ingestions
+		// are loaded without pacing. Zero means no limit.
+		TableStatsLoadRate int
+
+		// RemoteMaxStaleness, if positive, bounds the staleness of a DB opened
+		// with OpenRemoteReadOnly: its view of the remote DB is refreshed (see
+		// DB.RefreshRemote) in the background with this interval between the
+		// start of a refresh and the start of the next one. The current
+		// replication lag is reported by Metrics.Remote.Lag. It has no effect
+		// on other DBs.
+		RemoteMaxStaleness time.Duration
+
+		// OpenMigration, if set, adapts the OPTIONS file, the sstable
+		// properties and other files of a store written by another fork or
+		// version of Pebble when the store is opened.
+		OpenMigration *OpenMigration
+
+		// TenantQuotas, if set, tracks the estimated disk space used by the
+		// keys of each tenant (see DB.TenantUsage), and rejects the writes of
+		// the tenants which exceed their quota.
+		TenantQuotas *TenantQuotas
+
+		// KeyspaceEnd, if set, partitions the keys into keyspaces, e.g. one per
+		// tenant of a multi-tenant store identified by a key prefix (see
+		// FixedPrefixKeyspaces). It returns the smallest key greater than the
+		// keys of the keyspace of the given user key, or nil if the keyspace
+		// has no upper bound; the returned key must not be modified.
+		//
+		// Flushes and compactions never output an sstable holding the keys of
+		// several keyspaces, so compactions remain confined to a keyspace and
+		// a keyspace can be dropped (see DB.DropKeyspace) by deleting its
+		// sstables, without rewriting the sstables of other keyspaces. The
+		// memtables and the WAL are shared by all the keyspaces.
+		KeyspaceEnd func(userKey []byte) []byte
+
+		// ObsoleteFileDeletionFilter, if set, is invoked before an obsolete
+		// file (sstable, WAL, manifest or OPTIONS file) is deleted, and may
+		// veto the deletion by returning false: the file is then kept, and its
+		// deletion is retried the next time obsolete files are deleted (e.g.
+		// after a flush or compaction), or when the DB is next opened. This
+		// allows deletions to be delayed until e.g. a backup of the file is
+		// confirmed. Since recycling a WAL overwrites it, an obsolete WAL is
+		// only recycled (see WALRecycleLimit) if the filter allows its
+		// deletion. It is invoked without DB.mu held, possibly from a
+		// background goroutine.
+		ObsoleteFileDeletionFilter func(ObsoleteFileInfo) bool
+
+		// WALArchiveRetention configures the pruning of the WALs archived
+		// when Cleaner is the ArchiveCleaner. The archived WALs are pruned
+		// when WALs are archived, and when a backup is reported with
+		// DB.SetLastBackupTime. By default, they are retained forever.
+		WALArchiveRetention WALArchiveRetention
+
+		// IsGarbageSuffix, if set, is invoked by compactions with the suffix
+		// (see Comparer.Split) of the user keys they read, and returns true if
+		// the version of the key identified by the suffix (e.g. an encoded
+		// MVCC timestamp) is garbage, e.g. because it is older than a GC
+		// threshold. The garbage keys are dropped by the compactions which can
+		// drop all of their entries, i.e. the compactions with no data below
+		// them in the LSM, without requiring deletions to be written. Keys
+		// without a suffix are never considered garbage.
+		//
+		// The function must be deterministic for a given suffix until the key
+		// is dropped: a threshold may only move forward. Open snapshots do not
+		// protect garbage keys, and reads of a garbage key may observe it until
+		// it is dropped.
+		IsGarbageSuffix func(suffix []byte) bool
 	}
 
 	// Filters is a map from filter policy name to filter policy. It is used for
@@ -712,9 +1141,20 @@ type Options struct {
 	LBaseMaxBytes int64
 
 	// Per-level options. Options for at least one level must be specified. The
-	// options for the last level are used for all subsequent levels.
+	// options for the last level are used for all subsequent levels, with the
+	// TargetFileSize multiplied by TargetFileSizeMultiplier for each level.
+	//
+	// The TargetFileSize of each level can be changed at runtime with the
+	// level<N>.target_file_size keys of DB.SetOptions.
 	Levels []LevelOptions
 
+	// TargetFileSizeMultiplier is the factor by which the TargetFileSize grows
+	// from one level to the next, for the levels beyond those specified in
+	// Levels. Larger values reduce the number of files in the lower levels,
+	// and thus the size of the MANIFEST, at the cost of larger compactions.
+	// The default value is 2.
+	TargetFileSizeMultiplier int
+
 	// LoggerAndTracer will be used, if non-nil, else Logger will be used and
 	// tracing will be a noop.
 
@@ -727,11 +1167,27 @@ type Options struct {
 
 	// MaxManifestFileSize is the maximum size the MANIFEST file is allowed to
 	// become. When the MANIFEST exceeds this size it is rolled over and a new
-	// MANIFEST is created.
+	// MANIFEST is created. Note that the rollover is delayed until the edits
+	// written to the MANIFEST since its creation reference as many files as
+	// the current version (see HardMaxManifestFileSize).
 	MaxManifestFileSize int64
 
+	// HardMaxManifestFileSize is the size beyond which the MANIFEST is rolled
+	// over regardless of the number of files referenced by its edits. The
+	// default value of 0 disables the limit.
+	HardMaxManifestFileSize int64
+
+	// MaxManifestEdits is the maximum number of version edits written to a
+	// MANIFEST. When a MANIFEST holds this many edits it is rolled over and a
+	// new MANIFEST is created, bounding the number of edits replayed by Open.
+	// The default value of 0 disables the limit.
+	MaxManifestEdits int64
+
 	// MaxOpenFiles is a soft limit on the number of open files that can be
-	// used by the DB.
+	// used by the DB. It is shared by the table cache and the secondary cache
+	// (see Experimental.SecondaryCacheMaxOpenFiles). If the process runs out
+	// of file descriptors before reaching this limit, the table cache is
+	// shrunk rather than failing reads.
 	//
 	// The default value is 1000.
 	MaxOpenFiles int
@@ -746,6 +1202,20 @@ type Options struct {
 	// the queued MemTables.
 	MemTableSize int
 
+	// MemTableHashBuckets, if positive, selects a memtable optimized for
+	// workloads whose writes and reads are local to key prefixes (see
+	// Comparer.Split, which is then required): the point keys are hashed by
+	// prefix into this many buckets, each of which is a separate skiplist.
+	// Writes and prefix seeks (including Gets) then search a skiplist holding
+	// only the keys of the buckets' prefixes, which incurs fewer cache misses
+	// than searching a skiplist of the whole memtable. Iterating the memtable
+	// in total order, as non-prefix iterators do, requires merging the
+	// skiplists of all the buckets, and is thus slower. Each bucket used takes
+	// a few hundred bytes of the memtable.
+	//
+	// The default value is 0, which selects a single skiplist.
+	MemTableHashBuckets int
+
 	// Hard limit on the size of queued of MemTables. Writes are stopped when the
 	// sum of the queued memtable sizes exceeds
 	// MemTableStopWritesThreshold*MemTableSize. This value should be at least 2
@@ -788,6 +1258,40 @@ type Options struct {
 	// disabled.
 	ReadOnly bool
 
+	// RecoveryMode configures whether Open refuses to open a DB which cannot be
+	// recovered in full, because of corrupt WALs or missing sstables, or opens
+	// it after dropping the unrecoverable data. The dropped data is logged and
+	// reported by DB.RecoveryReport. The default is RecoveryStrict.
+	RecoveryMode RecoveryMode
+
+	// SeqNumPublished, if set, is invoked whenever committed batches become
+	// visible, with the new visible sequence number (see DB.VisibleSeqNum). It
+	// allows external systems to learn when writes can be read, for example to
+	// provide read-your-writes across processes.
+	//
+	// SeqNumPublished is invoked on the commit path of the writers and must
+	// return quickly. It may be invoked concurrently, and the calls may be
+	// observed out of order, so the visible sequence number is the largest one
+	// received.
+	SeqNumPublished func(seqNum uint64)
+
+	// SnapshotPinnedBytesBudget is the number of bytes an open snapshot may pin
+	// before the EventListener.SnapshotPinnedBudgetExceeded event is invoked
+	// for it. The bytes a snapshot pins are the bytes of the keys that flushes
+	// and compactions retained only because the snapshot can read them (see
+	// DB.Snapshots). The event is invoked at most once per snapshot. Zero, the
+	// default, disables the event.
+	SnapshotPinnedBytesBudget uint64
+
+	// StrictReadOnly opens the DB in read-only mode (see ReadOnly) with the
+	// additional guarantee that nothing is ever written to the data or WAL
+	// directories: the LOCK file is neither created nor locked, and any code
+	// path that would modify the filesystem fails with vfs.ErrReadOnly instead
+	// (see vfs.NewReadOnly). This is intended for the forensic inspection of a
+	// possibly corrupt store. Since no lock is held, the store must not be
+	// concurrently opened for writing.
+	StrictReadOnly bool
+
 	// TableCache is an initialized TableCache which should be set as an
 	// option if the DB needs to be initialized with a pre-existing table cache.
 	// If TableCache is nil, then a table cache which is unique to the DB instance
@@ -806,6 +1310,14 @@ type Options struct {
 	// built and lives for the lifetime of writing that table.
 	BlockPropertyCollectors []func() BlockPropertyCollector
 
+	// KeyTimestamp, if set, returns the timestamp of a user key, or false if
+	// the key has no timestamp. The minimum and maximum timestamps of the
+	// point keys of each sstable, and of each of its blocks, are then
+	// recorded by a built-in block property collector, which allows
+	// iterators to skip the sstables and blocks outside of
+	// IterOptions.TimeRange.
+	KeyTimestamp func(userKey []byte) (ts uint64, ok bool)
+
 	// WALBytesPerSync sets the number of bytes to write to a WAL before calling
 	// Sync on it in the background. Just like with BytesPerSync above, this
 	// helps smooth out disk write latencies, and avoids cases where the OS
@@ -822,6 +1334,19 @@ type Options struct {
 	// (i.e. the directory passed to pebble.Open).
 	WALDir string
 
+	// WALPreallocateSize is the size of the blocks in which space is
+	// preallocated for WAL files, on filesystems which support it. The default
+	// value of 0 preallocates blocks of 110% of MemTableSize. A value of -1
+	// disables preallocation.
+	WALPreallocateSize int
+
+	// WALRecycleLimit is the maximum number of obsolete WAL files kept to be
+	// reused for new WALs (see vfs.FS.ReuseForWrite). Writing to a recycled
+	// file avoids syncing the file metadata when it grows. The default value of
+	// 0 keeps MemTableStopWritesThreshold+1 files. A value of -1 disables WAL
+	// recycling, in which case obsolete WAL files are deleted.
+	WALRecycleLimit int
+
 	// WALMinSyncInterval is the minimum duration between syncs of the WAL. If
 	// WAL syncs are requested faster than this interval, they will be
 	// artificially delayed. Introducing a small artificial delay (500us) between
@@ -876,6 +1401,10 @@ type Options struct {
 		// against the FS are made after the DB is closed, the FS may leak a
 		// goroutine indefinitely.
 		fsCloser io.Closer
+
+		// remoteFS is set by OpenRemoteReadOnly to the FS of the files of the
+		// remote DB, which is refreshed by DB.RefreshRemote.
+		remoteFS *remoteFS
 	}
 }
 
@@ -910,6 +1439,12 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Experimental.CompactionDebtConcurrency <= 0 {
 		o.Experimental.CompactionDebtConcurrency = 1 << 30 // 1 GB
 	}
+	if o.Experimental.IntraL0CompactionMinDepth <= 0 {
+		o.Experimental.IntraL0CompactionMinDepth = minIntraL0Count
+	}
+	if o.Experimental.IntraL0CompactionMinFiles <= 0 {
+		o.Experimental.IntraL0CompactionMinFiles = 2
+	}
 	if o.Experimental.KeyValidationFunc == nil {
 		o.Experimental.KeyValidationFunc = func([]byte) error { return nil }
 	}
@@ -948,13 +1483,16 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.LBaseMaxBytes <= 0 {
 		o.LBaseMaxBytes = 64 << 20 // 64 MB
 	}
+	if o.TargetFileSizeMultiplier <= 0 {
+		o.TargetFileSizeMultiplier = defaultTargetFileSizeMultiplier
+	}
 	if o.Levels == nil {
 		o.Levels = make([]LevelOptions, 1)
 		for i := range o.Levels {
 			if i > 0 {
 				l := &o.Levels[i]
 				if l.TargetFileSize <= 0 {
-					l.TargetFileSize = o.Levels[i-1].TargetFileSize * 2
+					l.TargetFileSize = o.Levels[i-1].TargetFileSize * int64(o.TargetFileSizeMultiplier)
 				}
 			}
 			o.Levels[i].EnsureDefaults()
@@ -1022,6 +1560,9 @@ func (o *Options) EnsureDefaults() *Options {
 	if o.Experimental.PointTombstoneWeight == 0 {
 		o.Experimental.PointTombstoneWeight = 1
 	}
+	if o.Experimental.TombstoneDenseCompactionMinCount == 0 {
+		o.Experimental.TombstoneDenseCompactionMinCount = 100
+	}
 
 	if o.Experimental.MultiLevelCompactionHueristic == nil {
 		o.Experimental.MultiLevelCompactionHueristic = NoMultiLevel{}
@@ -1081,10 +1622,14 @@ func (o *Options) Level(level int) LevelOptions {
 	if level < len(o.Levels) {
 		return o.Levels[level]
 	}
+	multiplier := int64(o.TargetFileSizeMultiplier)
+	if multiplier <= 0 {
+		multiplier = defaultTargetFileSizeMultiplier
+	}
 	n := len(o.Levels) - 1
 	l := o.Levels[n]
 	for i := n; i < level; i++ {
-		l.TargetFileSize *= 2
+		l.TargetFileSize *= multiplier
 	}
 	return l
 }
@@ -1118,11 +1663,26 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "\n")
 	fmt.Fprintf(&buf, "[Options]\n")
 	fmt.Fprintf(&buf, "  bytes_per_sync=%d\n", o.BytesPerSync)
+	if o.CacheQuota > 0 {
+		fmt.Fprintf(&buf, "  cache_quota=%d\n", o.CacheQuota)
+	}
 	fmt.Fprintf(&buf, "  cache_size=%d\n", cacheSize)
+	if o.CacheWarmupFile != "" {
+		fmt.Fprintf(&buf, "  cache_warmup_file=%s\n", o.CacheWarmupFile)
+	}
 	fmt.Fprintf(&buf, "  cleaner=%s\n", o.Cleaner)
 	fmt.Fprintf(&buf, "  compaction_debt_concurrency=%d\n", o.Experimental.CompactionDebtConcurrency)
+	if o.Experimental.Checksum != sstable.ChecksumTypeNone {
+		fmt.Fprintf(&buf, "  checksum=%s\n", o.Experimental.Checksum)
+	}
 	fmt.Fprintf(&buf, "  comparer=%s\n", o.Comparer.Name)
 	fmt.Fprintf(&buf, "  disable_wal=%t\n", o.DisableWAL)
+	if o.Experimental.DirectIOReads {
+		fmt.Fprintf(&buf, "  direct_io_reads=%t\n", true)
+	}
+	if o.Experimental.DirectIOWrites {
+		fmt.Fprintf(&buf, "  direct_io_writes=%t\n", true)
+	}
 	if o.Experimental.DisableIngestAsFlushable != nil && o.Experimental.DisableIngestAsFlushable() {
 		fmt.Fprintf(&buf, "  disable_ingest_as_flushable=%t\n", true)
 	}
@@ -1130,6 +1690,10 @@ func (o *Options) String() string {
 	fmt.Fprintf(&buf, "  flush_delay_range_key=%s\n", o.FlushDelayRangeKey)
 	fmt.Fprintf(&buf, "  flush_split_bytes=%d\n", o.FlushSplitBytes)
 	fmt.Fprintf(&buf, "  format_major_version=%d\n", o.FormatMajorVersion)
+	fmt.Fprintf(&buf, "  hard_max_manifest_file_size=%d\n", o.HardMaxManifestFileSize)
+	fmt.Fprintf(&buf, "  ingest_copy=%t\n", o.Experimental.IngestCopy)
+	fmt.Fprintf(&buf, "  intra_l0_compaction_min_depth=%d\n", o.Experimental.IntraL0CompactionMinDepth)
+	fmt.Fprintf(&buf, "  intra_l0_compaction_min_files=%d\n", o.Experimental.IntraL0CompactionMinFiles)
 	fmt.Fprintf(&buf, "  l0_compaction_concurrency=%d\n", o.Experimental.L0CompactionConcurrency)
 	fmt.Fprintf(&buf, "  l0_compaction_file_threshold=%d\n", o.L0CompactionFileThreshold)
 	fmt.Fprintf(&buf, "  l0_compaction_threshold=%d\n", o.L0CompactionThreshold)
@@ -1139,15 +1703,22 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "  level_multiplier=%d\n", o.Experimental.LevelMultiplier)
 	}
 	fmt.Fprintf(&buf, "  max_concurrent_compactions=%d\n", o.MaxConcurrentCompactions())
+	fmt.Fprintf(&buf, "  max_manifest_edits=%d\n", o.MaxManifestEdits)
 	fmt.Fprintf(&buf, "  max_manifest_file_size=%d\n", o.MaxManifestFileSize)
 	fmt.Fprintf(&buf, "  max_open_files=%d\n", o.MaxOpenFiles)
+	if o.MemTableHashBuckets > 0 {
+		fmt.Fprintf(&buf, "  mem_table_hash_buckets=%d\n", o.MemTableHashBuckets)
+	}
 	fmt.Fprintf(&buf, "  mem_table_size=%d\n", o.MemTableSize)
 	fmt.Fprintf(&buf, "  mem_table_stop_writes_threshold=%d\n", o.MemTableStopWritesThreshold)
+	fmt.Fprintf(&buf, "  min_deletion_file_rate=%d\n", o.Experimental.MinDeletionFileRate)
 	fmt.Fprintf(&buf, "  min_deletion_rate=%d\n", o.Experimental.MinDeletionRate)
 	fmt.Fprintf(&buf, "  merger=%s\n", o.Merger.Name)
 	fmt.Fprintf(&buf, "  point_tombstone_weight=%f\n", o.Experimental.PointTombstoneWeight)
 	fmt.Fprintf(&buf, "  read_compaction_rate=%d\n", o.Experimental.ReadCompactionRate)
 	fmt.Fprintf(&buf, "  read_sampling_multiplier=%d\n", o.Experimental.ReadSamplingMultiplier)
+	fmt.Fprintf(&buf, "  reserve_l0_base_compaction_slot=%t\n", o.Experimental.ReserveL0BaseCompactionSlot)
+	fmt.Fprintf(&buf, "  snapshot_pinned_bytes_budget=%d\n", o.SnapshotPinnedBytesBudget)
 	fmt.Fprintf(&buf, "  strict_wal_tail=%t\n", o.private.strictWALTail)
 	fmt.Fprintf(&buf, "  table_cache_shards=%d\n", o.Experimental.TableCacheShards)
 	fmt.Fprintf(&buf, "  table_property_collectors=[")
@@ -1160,9 +1731,16 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "%s", o.TablePropertyCollectors[i]().Name())
 	}
 	fmt.Fprintf(&buf, "]\n")
+	if m := o.TargetFileSizeMultiplier; m > 0 && m != defaultTargetFileSizeMultiplier {
+		fmt.Fprintf(&buf, "  target_file_size_multiplier=%d\n", m)
+	}
+	fmt.Fprintf(&buf, "  tombstone_dense_compaction_min_count=%d\n", o.Experimental.TombstoneDenseCompactionMinCount)
+	fmt.Fprintf(&buf, "  tombstone_dense_compaction_threshold=%f\n", o.Experimental.TombstoneDenseCompactionThreshold)
 	fmt.Fprintf(&buf, "  validate_on_ingest=%t\n", o.Experimental.ValidateOnIngest)
 	fmt.Fprintf(&buf, "  wal_dir=%s\n", o.WALDir)
 	fmt.Fprintf(&buf, "  wal_bytes_per_sync=%d\n", o.WALBytesPerSync)
+	fmt.Fprintf(&buf, "  wal_preallocate_size=%d\n", o.WALPreallocateSize)
+	fmt.Fprintf(&buf, "  wal_recycle_limit=%d\n", o.WALRecycleLimit)
 	fmt.Fprintf(&buf, "  max_writer_concurrency=%d\n", o.Experimental.MaxWriterConcurrency)
 	fmt.Fprintf(&buf, "  force_writer_parallelism=%t\n", o.Experimental.ForceWriterParallelism)
 
@@ -1190,9 +1768,18 @@ func (o *Options) String() string {
 		fmt.Fprintf(&buf, "  block_size=%d\n", l.BlockSize)
 		fmt.Fprintf(&buf, "  block_size_threshold=%d\n", l.BlockSizeThreshold)
 		fmt.Fprintf(&buf, "  compression=%s\n", l.Compression)
+		if l.CompressionDictSize > 0 {
+			fmt.Fprintf(&buf, "  compression_dict_size=%d\n", l.CompressionDictSize)
+		}
 		fmt.Fprintf(&buf, "  filter_policy=%s\n", filterPolicyName(l.FilterPolicy))
 		fmt.Fprintf(&buf, "  filter_type=%s\n", l.FilterType)
+		if l.DisableTwoLevelIndex {
+			fmt.Fprintf(&buf, "  disable_two_level_index=%t\n", true)
+		}
 		fmt.Fprintf(&buf, "  index_block_size=%d\n", l.IndexBlockSize)
+		if l.TopLevelIndexBlockSize > 0 {
+			fmt.Fprintf(&buf, "  top_level_index_block_size=%d\n", l.TopLevelIndexBlockSize)
+		}
 		fmt.Fprintf(&buf, "  target_file_size=%d\n", l.TargetFileSize)
 	}
 
@@ -1286,6 +1873,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 			switch key {
 			case "bytes_per_sync":
 				o.BytesPerSync, err = strconv.Atoi(value)
+			case "cache_quota":
+				o.CacheQuota, err = strconv.ParseInt(value, 10, 64)
 			case "cache_size":
 				var n int64
 				n, err = strconv.ParseInt(value, 10, 64)
@@ -1297,6 +1886,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 				// We avoid calling cache.New in parsing because it makes it
 				// too easy to leak a cache.
+			case "cache_warmup_file":
+				o.CacheWarmupFile = value
 			case "cleaner":
 				switch value {
 				case "archive":
@@ -1308,6 +1899,17 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 						o.Cleaner, err = hooks.NewCleaner(value)
 					}
 				}
+			case "checksum":
+				switch value {
+				case "crc32c":
+					o.Experimental.Checksum = ChecksumTypeCRC32c
+				case "xxhash64":
+					o.Experimental.Checksum = ChecksumTypeXXHash64
+				case "xxh3":
+					o.Experimental.Checksum = ChecksumTypeXXH3
+				default:
+					return errors.Errorf("pebble: unknown checksum type: %q", errors.Safe(value))
+				}
 			case "comparer":
 				switch value {
 				case "leveldb.BytewiseComparator":
@@ -1323,6 +1925,10 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				// NB: This is a deprecated serialization of the
 				// `flush_delay_delete_range`.
 				o.FlushDelayDeleteRange, err = time.ParseDuration(value)
+			case "direct_io_reads":
+				o.Experimental.DirectIOReads, err = strconv.ParseBool(value)
+			case "direct_io_writes":
+				o.Experimental.DirectIOWrites, err = strconv.ParseBool(value)
 			case "disable_delete_only_compactions":
 				o.private.disableDeleteOnlyCompactions, err = strconv.ParseBool(value)
 			case "disable_elision_only_compactions":
@@ -1356,6 +1962,14 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				if err == nil {
 					o.FormatMajorVersion = FormatMajorVersion(v)
 				}
+			case "hard_max_manifest_file_size":
+				o.HardMaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
+			case "ingest_copy":
+				o.Experimental.IngestCopy, err = strconv.ParseBool(value)
+			case "intra_l0_compaction_min_depth":
+				o.Experimental.IntraL0CompactionMinDepth, err = strconv.Atoi(value)
+			case "intra_l0_compaction_min_files":
+				o.Experimental.IntraL0CompactionMinFiles, err = strconv.Atoi(value)
 			case "l0_compaction_concurrency":
 				o.Experimental.L0CompactionConcurrency, err = strconv.Atoi(value)
 			case "l0_compaction_file_threshold":
@@ -1378,10 +1992,14 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				} else {
 					o.MaxConcurrentCompactions = func() int { return concurrentCompactions }
 				}
+			case "max_manifest_edits":
+				o.MaxManifestEdits, err = strconv.ParseInt(value, 10, 64)
 			case "max_manifest_file_size":
 				o.MaxManifestFileSize, err = strconv.ParseInt(value, 10, 64)
 			case "max_open_files":
 				o.MaxOpenFiles, err = strconv.Atoi(value)
+			case "mem_table_hash_buckets":
+				o.MemTableHashBuckets, err = strconv.Atoi(value)
 			case "mem_table_size":
 				o.MemTableSize, err = strconv.Atoi(value)
 			case "mem_table_stop_writes_threshold":
@@ -1389,6 +2007,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 			case "min_compaction_rate":
 				// Do nothing; option existed in older versions of pebble, and
 				// may be meaningful again eventually.
+			case "min_deletion_file_rate":
+				o.Experimental.MinDeletionFileRate, err = strconv.Atoi(value)
 			case "min_deletion_rate":
 				o.Experimental.MinDeletionRate, err = strconv.Atoi(value)
 			case "min_flush_rate":
@@ -1413,6 +2033,10 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				o.Experimental.ReadCompactionRate, err = strconv.ParseInt(value, 10, 64)
 			case "read_sampling_multiplier":
 				o.Experimental.ReadSamplingMultiplier, err = strconv.ParseInt(value, 10, 64)
+			case "reserve_l0_base_compaction_slot":
+				o.Experimental.ReserveL0BaseCompactionSlot, err = strconv.ParseBool(value)
+			case "snapshot_pinned_bytes_budget":
+				o.SnapshotPinnedBytesBudget, err = strconv.ParseUint(value, 10, 64)
 			case "table_cache_shards":
 				o.Experimental.TableCacheShards, err = strconv.Atoi(value)
 			case "table_format":
@@ -1424,12 +2048,22 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "table_property_collectors":
 				// TODO(peter): set o.TablePropertyCollectors
+			case "target_file_size_multiplier":
+				o.TargetFileSizeMultiplier, err = strconv.Atoi(value)
+			case "tombstone_dense_compaction_min_count":
+				o.Experimental.TombstoneDenseCompactionMinCount, err = strconv.ParseUint(value, 10, 64)
+			case "tombstone_dense_compaction_threshold":
+				o.Experimental.TombstoneDenseCompactionThreshold, err = strconv.ParseFloat(value, 64)
 			case "validate_on_ingest":
 				o.Experimental.ValidateOnIngest, err = strconv.ParseBool(value)
 			case "wal_dir":
 				o.WALDir = value
 			case "wal_bytes_per_sync":
 				o.WALBytesPerSync, err = strconv.Atoi(value)
+			case "wal_preallocate_size":
+				o.WALPreallocateSize, err = strconv.Atoi(value)
+			case "wal_recycle_limit":
+				o.WALRecycleLimit, err = strconv.Atoi(value)
 			case "max_writer_concurrency":
 				o.Experimental.MaxWriterConcurrency, err = strconv.Atoi(value)
 			case "force_writer_parallelism":
@@ -1482,6 +2116,10 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				default:
 					return errors.Errorf("pebble: unknown compression: %q", errors.Safe(value))
 				}
+			case "compression_dict_size":
+				l.CompressionDictSize, err = strconv.Atoi(value)
+			case "disable_two_level_index":
+				l.DisableTwoLevelIndex, err = strconv.ParseBool(value)
 			case "filter_policy":
 				if hooks != nil && hooks.NewFilterPolicy != nil {
 					l.FilterPolicy, err = hooks.NewFilterPolicy(value)
@@ -1495,6 +2133,8 @@ func (o *Options) Parse(s string, hooks *ParseHooks) error {
 				}
 			case "index_block_size":
 				l.IndexBlockSize, err = strconv.Atoi(value)
+			case "top_level_index_block_size":
+				l.TopLevelIndexBlockSize, err = strconv.Atoi(value)
 			case "target_file_size":
 				l.TargetFileSize, err = strconv.ParseInt(value, 10, 64)
 			default:
@@ -1524,10 +2164,19 @@ func (o *Options) checkOptions(s string) (strictWALTail bool, err error) {
 		case "Options.merger":
 			// RocksDB allows the merge operator to be unspecified, in which case it
 			// shows up as "nullptr".
-			if value != "nullptr" && value != o.Merger.Name {
+			if value != "nullptr" && value != o.Merger.Name && !o.AllowOptionsMismatch {
 				return errors.Errorf("pebble: merger name from file %q != merger name from options %q",
 					errors.Safe(value), errors.Safe(o.Merger.Name))
 			}
+		case "Options.format_major_version":
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return errors.Errorf("pebble: error parsing format_major_version value %q: %w", value, err)
+			}
+			if vers := FormatMajorVersion(v); vers > FormatNewest && !o.AllowOptionsMismatch {
+				return errors.Errorf("pebble: format major version from file %d is newer than the newest supported %d",
+					errors.Safe(vers), errors.Safe(FormatNewest))
+			}
 		case "Options.strict_wal_tail":
 			strictWALTail, err = strconv.ParseBool(value)
 			if err != nil {
@@ -1540,7 +2189,8 @@ func (o *Options) checkOptions(s string) (strictWALTail bool, err error) {
 
 // Check verifies the options are compatible with the previous options
 // serialized by Options.String(). For example, the Comparer and Merger must be
-// the same, or data will not be able to be properly read from the DB.
+// the same, or data will not be able to be properly read from the DB. See
+// AllowOptionsMismatch for the checks that may be relaxed.
 func (o *Options) Check(s string) error {
 	_, err := o.checkOptions(s)
 	return err
@@ -1558,6 +2208,14 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "L0CompactionConcurrency (%d) must be >= 1\n",
 			o.Experimental.L0CompactionConcurrency)
 	}
+	if o.Experimental.IntraL0CompactionMinDepth < 2 {
+		fmt.Fprintf(&buf, "IntraL0CompactionMinDepth (%d) must be >= 2\n",
+			o.Experimental.IntraL0CompactionMinDepth)
+	}
+	if o.Experimental.IntraL0CompactionMinFiles < 2 {
+		fmt.Fprintf(&buf, "IntraL0CompactionMinFiles (%d) must be >= 2\n",
+			o.Experimental.IntraL0CompactionMinFiles)
+	}
 	if o.L0StopWritesThreshold < o.L0CompactionThreshold {
 		fmt.Fprintf(&buf, "L0StopWritesThreshold (%d) must be >= L0CompactionThreshold (%d)\n",
 			o.L0StopWritesThreshold, o.L0CompactionThreshold)
@@ -1566,6 +2224,10 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "MemTableSize (%s) must be < %s\n",
 			humanize.Uint64(uint64(o.MemTableSize)), humanize.Uint64(maxMemTableSize))
 	}
+	if o.MemTableHashBuckets > 0 && o.Comparer.Split == nil {
+		fmt.Fprintf(&buf, "MemTableHashBuckets (%d) requires Comparer.Split\n",
+			o.MemTableHashBuckets)
+	}
 	if o.MemTableStopWritesThreshold < 2 {
 		fmt.Fprintf(&buf, "MemTableStopWritesThreshold (%d) must be >= 2\n",
 			o.MemTableStopWritesThreshold)
@@ -1574,6 +2236,17 @@ func (o *Options) Validate() error {
 		fmt.Fprintf(&buf, "FormatMajorVersion (%d) must be <= %d\n",
 			o.FormatMajorVersion, FormatNewest)
 	}
+	if o.CacheQuota < 0 {
+		fmt.Fprintf(&buf, "CacheQuota (%d) must be >= 0\n", o.CacheQuota)
+	}
+	if o.RecoveryMode < RecoveryStrict || o.RecoveryMode > RecoverySkipMissingTables {
+		fmt.Fprintf(&buf, "unknown RecoveryMode %s\n", o.RecoveryMode)
+	} else if o.RecoveryMode == RecoverySkipMissingTables && o.ReadOnly {
+		fmt.Fprintf(&buf, "RecoveryMode %s is not supported with ReadOnly\n", o.RecoveryMode)
+	}
+	if q := o.Experimental.TenantQuotas; q != nil && (q.KeyTenant == nil || q.Quota == nil) {
+		fmt.Fprintf(&buf, "TenantQuotas requires KeyTenant and Quota\n")
+	}
 	if o.TableCache != nil && o.Cache != o.TableCache.cache {
 		fmt.Fprintf(&buf, "underlying cache in the TableCache and the Cache dont match\n")
 	}
@@ -1595,6 +2268,9 @@ func (o *Options) MakeReaderOptions() sstable.ReaderOptions {
 			readerOpts.MergerName = o.Merger.Name
 		}
 		readerOpts.LoggerAndTracer = o.LoggerAndTracer
+		if m := o.Experimental.OpenMigration; m != nil {
+			readerOpts.RewriteProperties = m.RewriteTableProperties
+		}
 	}
 	return readerOpts
 }
@@ -1612,6 +2288,19 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 		}
 		writerOpts.TablePropertyCollectors = o.TablePropertyCollectors
 		writerOpts.BlockPropertyCollectors = o.BlockPropertyCollectors
+		if o.KeyTimestamp != nil {
+			keyTimestamp := o.KeyTimestamp
+			writerOpts.BlockPropertyCollectors = append(
+				o.BlockPropertyCollectors[:len(o.BlockPropertyCollectors):len(o.BlockPropertyCollectors)],
+				func() BlockPropertyCollector { return newTimestampsCollector(keyTimestamp) })
+		}
+		if q := o.Experimental.TenantQuotas; q != nil {
+			keyTenant := q.KeyTenant
+			writerOpts.TablePropertyCollectors = append(
+				o.TablePropertyCollectors[:len(o.TablePropertyCollectors):len(o.TablePropertyCollectors)],
+				func() TablePropertyCollector { return newTenantUsageCollector(keyTenant) })
+		}
+		writerOpts.Checksum = o.Experimental.Checksum
 	}
 	if format >= sstable.TableFormatPebblev3 {
 		writerOpts.ShortAttributeExtractor = o.Experimental.ShortAttributeExtractor
@@ -1625,5 +2314,7 @@ func (o *Options) MakeWriterOptions(level int, format sstable.TableFormat) sstab
 	writerOpts.FilterPolicy = levelOpts.FilterPolicy
 	writerOpts.FilterType = levelOpts.FilterType
 	writerOpts.IndexBlockSize = levelOpts.IndexBlockSize
+	writerOpts.DisableTwoLevelIndex = levelOpts.DisableTwoLevelIndex
+	writerOpts.TopLevelIndexBlockSize = levelOpts.TopLevelIndexBlockSize
 	return writerOpts
 }
@@ -0,0 +1,238 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/rate"
+)
+
+// SetOptions changes the values of a curated set of options on a running DB,
+// without requiring the DB to be reopened. Options are specified as key/value
+// pairs, using the same keys and value syntax as the [Options] section of the
+// OPTIONS file (see Options.String). The supported keys are:
+//
+//	bytes_per_sync
+//	cache_quota
+//	cache_size
+//	l0_stop_writes_threshold
+//	max_concurrent_compactions
+//	max_open_files
+//	min_deletion_file_rate
+//	min_deletion_rate
+//	target_file_size_multiplier
+//	validate_on_ingest
+//
+// as well as the target_file_size of the [Level "N"] sections, with the keys
+// level<N>.target_file_size (e.g. level6.target_file_size). Setting the
+// target file size of a level beyond those in Options.Levels adds the
+// intermediate levels to Options.Levels, with the target file sizes they had.
+// Changes to the target file sizes only apply to the compactions started
+// after the call returns.
+//
+// All values are parsed and validated before any of them is applied: if any
+// key is unsupported or any value is invalid, an error is returned and no
+// option is changed.
+//
+// Unless the DB is read-only, the effective options are written to a new
+// OPTIONS file once they have been applied.
+//
+// Note that the block cache may be shared with other DB instances, in which
+// case changing cache_size affects all of them, while cache_quota only limits
+// the usage of the cache by this DB. A new bytes_per_sync value
+// only applies to files created after the call returns. Changing
+// max_open_files resizes the table cache and the number of open files of the
+// secondary cache, and is not supported if the table cache was provided
+// through Options.TableCache (see TableCache.SetSize).
+//
+// Of the rate limits, only min_deletion_file_rate and min_deletion_rate can be
+// changed. The following limits are not supported:
+//
+//   - The I/O bandwidth limits of an FS wrapped by vfs.WithRateLimiting belong
+//     to its vfs.IOLimiter rather than to the options. To change them at
+//     runtime, provide an IOLimiter implementation whose limits can be
+//     adjusted.
+//   - Options.Experimental.ScrubBytesPerSec is not part of the OPTIONS file. It
+//     is read at the start of each scrub (see DB.Scrub).
+//   - Uploads to Options.Experimental.SharedStorage are not rate limited, so
+//     there is no upload rate to change.
+//   - read_compaction_rate is not a limit on I/O, and cannot be changed.
+func (d *DB) SetOptions(opts map[string]string) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	o := d.opts.Clone()
+	cacheSize := int64(-1)
+	targetFileSizes := make(map[int]int64)
+	for _, key := range keys {
+		value := opts[key]
+		var err error
+		switch key {
+		case "bytes_per_sync":
+			o.BytesPerSync, err = strconv.Atoi(value)
+			if err == nil && o.BytesPerSync < 0 {
+				err = errors.New("bytes_per_sync cannot be < 0")
+			}
+		case "cache_quota":
+			o.CacheQuota, err = strconv.ParseInt(value, 10, 64)
+		case "cache_size":
+			cacheSize, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && cacheSize < 0 {
+				err = errors.New("cache_size cannot be < 0")
+			}
+		case "l0_stop_writes_threshold":
+			o.L0StopWritesThreshold, err = strconv.Atoi(value)
+		case "max_concurrent_compactions":
+			var concurrentCompactions int
+			concurrentCompactions, err = strconv.Atoi(value)
+			if err == nil && concurrentCompactions <= 0 {
+				err = errors.New("max_concurrent_compactions cannot be <= 0")
+			}
+			o.MaxConcurrentCompactions = func() int { return concurrentCompactions }
+		case "max_open_files":
+			o.MaxOpenFiles, err = strconv.Atoi(value)
+			if err == nil && o.MaxOpenFiles <= 0 {
+				err = errors.New("max_open_files cannot be <= 0")
+			}
+			if err == nil && d.tableCache.shared {
+				return errors.New("pebble: option max_open_files cannot be changed when the table cache is shared")
+			}
+		case "min_deletion_file_rate":
+			o.Experimental.MinDeletionFileRate, err = strconv.Atoi(value)
+			if err == nil && o.Experimental.MinDeletionFileRate < 0 {
+				err = errors.New("min_deletion_file_rate cannot be < 0")
+			}
+		case "min_deletion_rate":
+			o.Experimental.MinDeletionRate, err = strconv.Atoi(value)
+			if err == nil && o.Experimental.MinDeletionRate < 0 {
+				err = errors.New("min_deletion_rate cannot be < 0")
+			}
+		case "target_file_size_multiplier":
+			o.TargetFileSizeMultiplier, err = strconv.Atoi(value)
+			if err == nil && o.TargetFileSizeMultiplier <= 0 {
+				err = errors.New("target_file_size_multiplier cannot be <= 0")
+			}
+		case "validate_on_ingest":
+			o.Experimental.ValidateOnIngest, err = strconv.ParseBool(value)
+		default:
+			level, ok := parseLevelTargetFileSizeKey(key)
+			if !ok {
+				return errors.Errorf("pebble: option %s cannot be changed at runtime", errors.Safe(key))
+			}
+			var size int64
+			size, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && size <= 0 {
+				err = errors.New("target_file_size cannot be <= 0")
+			}
+			targetFileSizes[level] = size
+		}
+		if err != nil {
+			return errors.Wrapf(err, "pebble: invalid value %q for option %s", value, errors.Safe(key))
+		}
+	}
+	if len(targetFileSizes) > 0 {
+		// The levels are copied, since Options.Levels may be shared with the
+		// Options passed to Open.
+		n := len(o.Levels)
+		for level := range targetFileSizes {
+			if level >= n {
+				n = level + 1
+			}
+		}
+		levels := make([]LevelOptions, n)
+		for i := range levels {
+			levels[i] = o.Level(i)
+		}
+		for level, size := range targetFileSizes {
+			levels[level].TargetFileSize = size
+		}
+		o.Levels = levels
+	}
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	// All of the new values are valid. Apply them. The fields of d.opts that
+	// are changed here are only read with DB.mu held, with the exception of
+	// those that are propagated to other components below.
+	if o.BytesPerSync != d.opts.BytesPerSync {
+		d.opts.BytesPerSync = o.BytesPerSync
+		d.objProvider.SetBytesPerSync(o.BytesPerSync)
+	}
+	if cacheSize >= 0 {
+		d.opts.Cache.SetMaxSize(cacheSize)
+	}
+	if o.MaxOpenFiles != d.opts.MaxOpenFiles {
+		d.opts.MaxOpenFiles = o.MaxOpenFiles
+		tableCacheSize, secondaryCacheFiles := d.opts.openFilesBudget()
+		d.tableCache.tableCache.SetSize(tableCacheSize)
+		d.objProvider.SetSharedCacheMaxOpenFiles(secondaryCacheFiles)
+	}
+	if o.CacheQuota != d.opts.CacheQuota {
+		d.opts.CacheQuota = o.CacheQuota
+		d.opts.Cache.SetQuota(d.cacheID, o.CacheQuota)
+	}
+	if r := o.Experimental.MinDeletionRate; r != d.opts.Experimental.MinDeletionRate {
+		d.opts.Experimental.MinDeletionRate = r
+		d.deletionLimiter.SetLimit(rate.Limit(r))
+		d.deletionLimiter.SetBurst(r)
+	}
+	if r := o.Experimental.MinDeletionFileRate; r != d.opts.Experimental.MinDeletionFileRate {
+		d.opts.Experimental.MinDeletionFileRate = r
+		d.deletionFileLimiter.SetLimit(rate.Limit(r))
+		d.deletionFileLimiter.SetBurst(r)
+	}
+	d.opts.Experimental.ValidateOnIngest = o.Experimental.ValidateOnIngest
+	d.opts.Levels = o.Levels
+	d.opts.TargetFileSizeMultiplier = o.TargetFileSizeMultiplier
+	d.opts.L0StopWritesThreshold = o.L0StopWritesThreshold
+	d.opts.MaxConcurrentCompactions = o.MaxConcurrentCompactions
+
+	// A higher compaction concurrency may allow additional compactions to be
+	// scheduled, and a higher L0 stop-writes threshold may unblock stalled
+	// writers waiting on the compaction condition variable.
+	d.maybeScheduleCompaction()
+	d.mu.compact.cond.Broadcast()
+
+	if d.opts.ReadOnly {
+		return nil
+	}
+	if err := d.writeOptionsFileLocked(); err != nil {
+		return errors.Wrap(err, "pebble: options were changed but could not be persisted")
+	}
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	d.deleteObsoleteFiles(jobID, false /* waitForOngoing */)
+	return nil
+}
+
+// parseLevelTargetFileSizeKey parses a level<N>.target_file_size key of
+// SetOptions, returning the level.
+func parseLevelTargetFileSizeKey(key string) (level int, ok bool) {
+	name, attr, ok := strings.Cut(key, ".")
+	if !ok || attr != "target_file_size" {
+		return 0, false
+	}
+	if _, err := fmt.Sscanf(name, "level%d", &level); err != nil ||
+		name != fmt.Sprintf("level%d", level) || level < 0 || level >= numLevels {
+		return 0, false
+	}
+	return level, true
+}
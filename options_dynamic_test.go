@@ -0,0 +1,191 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetOptions(t *testing.T) {
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{
+		Cache: c,
+		FS:    mem,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	require.NoError(t, d.SetOptions(map[string]string{
+		"bytes_per_sync":             "1024",
+		"cache_quota":                "2048",
+		"cache_size":                 "4096",
+		"l0_stop_writes_threshold":   "100",
+		"max_concurrent_compactions": "3",
+		"max_open_files":             "200",
+		"min_deletion_file_rate":     "100",
+		"min_deletion_rate":          "1048576",
+		"validate_on_ingest":         "true",
+	}))
+	d.mu.Lock()
+	require.Equal(t, 1024, d.opts.BytesPerSync)
+	require.EqualValues(t, 2048, d.opts.CacheQuota)
+	require.Equal(t, 100, d.opts.L0StopWritesThreshold)
+	require.Equal(t, 3, d.opts.MaxConcurrentCompactions())
+	require.Equal(t, 200, d.opts.MaxOpenFiles)
+	require.Equal(t, 100, d.opts.Experimental.MinDeletionFileRate)
+	require.Equal(t, 1<<20, d.opts.Experimental.MinDeletionRate)
+	require.True(t, d.opts.Experimental.ValidateOnIngest)
+	d.mu.Unlock()
+	require.EqualValues(t, 4096, c.MaxSize())
+	require.Equal(t, 1<<20, d.deletionLimiter.Burst())
+	require.Equal(t, 100, d.deletionFileLimiter.Burst())
+	// The table cache was resized to TableCacheSize(200), divided between
+	// its shards.
+	shards := int64(d.opts.Experimental.TableCacheShards)
+	require.Equal(t, shards*(int64(TableCacheSize(200))/shards), d.Metrics().TableCache.Capacity)
+
+	// The new options are persisted in a new OPTIONS file, and the previous
+	// OPTIONS file is deleted. Deletions are asynchronous since
+	// min_deletion_rate is non-zero.
+	d.TestOnlyWaitForCleaning()
+	d.deleters.Wait()
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	var optionsFiles []string
+	for _, filename := range ls {
+		if ft, _, ok := base.ParseFilename(mem, filename); ok && ft == fileTypeOptions {
+			optionsFiles = append(optionsFiles, filename)
+		}
+	}
+	require.Len(t, optionsFiles, 1)
+	f, err := mem.Open(optionsFiles[0])
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Contains(t, string(data), "bytes_per_sync=1024\n")
+	require.Contains(t, string(data), "cache_quota=2048\n")
+	require.Contains(t, string(data), "cache_size=4096\n")
+	require.Contains(t, string(data), "max_open_files=200\n")
+	require.NoError(t, d.opts.Check(string(data)))
+
+	// Invalid values must not change any option, even when other values in
+	// the same call are valid.
+	for _, opts := range []map[string]string{
+		{"bytes_per_sync": "1", "comparer": "foo"},
+		{"bytes_per_sync": "1", "max_concurrent_compactions": "0"},
+		{"bytes_per_sync": "1", "validate_on_ingest": "maybe"},
+		{"bytes_per_sync": "1", "cache_size": "-1"},
+		{"bytes_per_sync": "1", "cache_quota": "-1"},
+		{"bytes_per_sync": "1", "max_open_files": "0"},
+		// L0StopWritesThreshold must be >= L0CompactionThreshold.
+		{"bytes_per_sync": "1", "l0_stop_writes_threshold": "1"},
+	} {
+		require.Error(t, d.SetOptions(opts))
+	}
+	d.mu.Lock()
+	require.Equal(t, 1024, d.opts.BytesPerSync)
+	require.EqualValues(t, 2048, d.opts.CacheQuota)
+	require.Equal(t, 100, d.opts.L0StopWritesThreshold)
+	require.Equal(t, 3, d.opts.MaxConcurrentCompactions())
+	require.Equal(t, 200, d.opts.MaxOpenFiles)
+	d.mu.Unlock()
+	require.EqualValues(t, 4096, c.MaxSize())
+
+	// The DB remains usable after the options have been changed.
+	require.NoError(t, d.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+}
+
+func TestSetOptionsTargetFileSize(t *testing.T) {
+	levels := []LevelOptions{{TargetFileSize: 1 << 20}}
+	d, err := Open("", &Options{
+		FS:     vfs.NewMem(),
+		Levels: levels,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	require.EqualValues(t, 64<<20, d.opts.Level(6).TargetFileSize)
+
+	// The multiplier applies to the levels beyond Options.Levels.
+	require.NoError(t, d.SetOptions(map[string]string{
+		"target_file_size_multiplier": "4",
+	}))
+	d.mu.Lock()
+	opts := d.opts.Clone()
+	d.mu.Unlock()
+	require.EqualValues(t, 4<<20, opts.Level(1).TargetFileSize)
+	require.EqualValues(t, 4096<<20, opts.Level(6).TargetFileSize)
+
+	// Setting the target file size of a level adds the intermediate levels,
+	// without modifying the levels passed to Open.
+	require.NoError(t, d.SetOptions(map[string]string{
+		"level3.target_file_size": "1000000",
+		"level6.target_file_size": "256000000",
+	}))
+	d.mu.Lock()
+	opts = d.opts.Clone()
+	d.mu.Unlock()
+	require.Len(t, opts.Levels, 7)
+	require.EqualValues(t, 16<<20, opts.Level(2).TargetFileSize)
+	require.EqualValues(t, 1000000, opts.Level(3).TargetFileSize)
+	require.EqualValues(t, 1024<<20, opts.Level(5).TargetFileSize)
+	require.EqualValues(t, 256000000, opts.Level(6).TargetFileSize)
+	require.Contains(t, opts.String(), "target_file_size_multiplier=4\n")
+	require.Len(t, levels, 1)
+	require.EqualValues(t, 1<<20, levels[0].TargetFileSize)
+
+	for _, opts := range []map[string]string{
+		{"target_file_size_multiplier": "0"},
+		{"level7.target_file_size": "1000"},
+		{"level1.target_file_size": "0"},
+		{"level01.target_file_size": "1000"},
+		{"level1.block_size": "1000"},
+	} {
+		require.Error(t, d.SetOptions(opts))
+	}
+
+	// Compactions use the new target file sizes.
+	require.NoError(t, d.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("b"), false))
+}
+
+func TestSetOptionsSharedTableCache(t *testing.T) {
+	c := cache.New(1 << 20)
+	defer c.Unref()
+	tc := NewTableCache(c, 2, 100)
+	defer func() {
+		require.NoError(t, tc.Unref())
+	}()
+	d, err := Open("", &Options{
+		Cache:      c,
+		TableCache: tc,
+		FS:         vfs.NewMem(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+
+	// A shared table cache can only be resized through TableCache.SetSize.
+	require.Error(t, d.SetOptions(map[string]string{"max_open_files": "200"}))
+	require.EqualValues(t, 100, d.Metrics().TableCache.Capacity)
+	tc.SetSize(50)
+	require.EqualValues(t, 50, d.Metrics().TableCache.Capacity)
+}
@@ -79,27 +79,39 @@ func TestOptionsString(t *testing.T) {
   flush_delay_range_key=0s
   flush_split_bytes=4194304
   format_major_version=1
+  hard_max_manifest_file_size=0
+  ingest_copy=false
+  intra_l0_compaction_min_depth=4
+  intra_l0_compaction_min_files=2
   l0_compaction_concurrency=10
   l0_compaction_file_threshold=500
   l0_compaction_threshold=4
   l0_stop_writes_threshold=12
   lbase_max_bytes=67108864
   max_concurrent_compactions=1
+  max_manifest_edits=0
   max_manifest_file_size=134217728
   max_open_files=1000
   mem_table_size=4194304
   mem_table_stop_writes_threshold=2
+  min_deletion_file_rate=0
   min_deletion_rate=0
   merger=pebble.concatenate
   point_tombstone_weight=1.000000
   read_compaction_rate=16000
   read_sampling_multiplier=16
+  reserve_l0_base_compaction_slot=false
+  snapshot_pinned_bytes_budget=0
   strict_wal_tail=true
   table_cache_shards=8
   table_property_collectors=[]
+  tombstone_dense_compaction_min_count=100
+  tombstone_dense_compaction_threshold=0.000000
   validate_on_ingest=false
   wal_dir=
   wal_bytes_per_sync=0
+  wal_preallocate_size=0
+  wal_recycle_limit=0
   max_writer_concurrency=0
   force_writer_parallelism=false
 
@@ -136,6 +148,19 @@ func TestOptionsCheck(t *testing.T) {
 	tmp.Merger = &Merger{Name: "foo"}
 	require.Regexp(t, `merger name from file.*!=.*`, tmp.Check(s))
 
+	// AllowOptionsMismatch relaxes the merger check, but never the comparer
+	// check.
+	tmp.AllowOptionsMismatch = true
+	require.NoError(t, tmp.Check(s))
+	tmp.Comparer = &Comparer{Name: "foo"}
+	require.Regexp(t, `comparer name from file.*!=.*`, tmp.Check(s))
+
+	tmp = *opts
+	newer := fmt.Sprintf("[Options]\n  format_major_version=%d\n", FormatNewest+1)
+	require.Regexp(t, `format major version from file \d+ is newer`, tmp.Check(newer))
+	tmp.AllowOptionsMismatch = true
+	require.NoError(t, tmp.Check(newer))
+
 	// RocksDB uses a similar (INI-style) syntax for the OPTIONS file, but
 	// different section names and keys.
 	s = `
@@ -221,20 +246,28 @@ func TestOptionsParse(t *testing.T) {
 			opts.Comparer = c.comparer
 			opts.Merger = c.merger
 			opts.WALDir = "wal"
+			opts.CacheQuota = 1 << 20
+			opts.CacheWarmupFile = "cache-state"
 			opts.Levels = make([]LevelOptions, 3)
 			opts.Levels[0].BlockSize = 1024
 			opts.Levels[1].BlockSize = 2048
 			opts.Levels[2].BlockSize = 4096
+			opts.Levels[2].Compression = ZstdCompression
+			opts.Levels[2].CompressionDictSize = 16 << 10
+			opts.Levels[1].DisableTwoLevelIndex = true
+			opts.Levels[2].TopLevelIndexBlockSize = 64 << 10
 			opts.Experimental.CompactionDebtConcurrency = 100
 			opts.FlushDelayDeleteRange = 10 * time.Second
 			opts.FlushDelayRangeKey = 11 * time.Second
 			opts.Experimental.LevelMultiplier = 5
+			opts.TargetFileSizeMultiplier = 3
 			opts.Experimental.MinDeletionRate = 200
 			opts.Experimental.ReadCompactionRate = 300
 			opts.Experimental.ReadSamplingMultiplier = 400
 			opts.Experimental.TableCacheShards = 500
 			opts.Experimental.MaxWriterConcurrency = 1
 			opts.Experimental.ForceWriterParallelism = true
+			opts.Experimental.Checksum = ChecksumTypeXXH3
 			opts.EnsureDefaults()
 			str := opts.String()
 
@@ -264,6 +297,18 @@ func TestOptionsValidate(t *testing.T) {
 			`L0CompactionConcurrency \(0\) must be >= 1`,
 		},
 		{`
+[Options]
+  intra_l0_compaction_min_depth=1
+`,
+			`IntraL0CompactionMinDepth \(1\) must be >= 2`,
+		},
+		{`
+[Options]
+  intra_l0_compaction_min_files=1
+`,
+			`IntraL0CompactionMinFiles \(1\) must be >= 2`,
+		},
+		{`
 [Options]
   l0_compaction_threshold=2
   l0_stop_writes_threshold=1
@@ -38,9 +38,11 @@ type deletionPacerInfo struct {
 // prevent overloading the disk with too many deletions too quickly after a
 // large compaction, or an iterator close. On some SSDs, disk performance can be
 // negatively impacted if too many blocks are deleted very quickly, so this
-// mechanism helps mitigate that.
+// mechanism helps mitigate that. Deletions may be limited both by the number
+// of bytes and by the number of files deleted.
 type deletionPacer struct {
-	limiter               limiter
+	bytesLimiter          limiter
+	fileLimiter           limiter
 	freeSpaceThreshold    uint64
 	obsoleteBytesMaxRatio float64
 
@@ -48,11 +50,14 @@ type deletionPacer struct {
 }
 
 // newDeletionPacer instantiates a new deletionPacer for use when deleting
-// obsolete files. The limiter passed in must be a singleton shared across this
-// pebble instance.
-func newDeletionPacer(limiter limiter, getInfo func() deletionPacerInfo) *deletionPacer {
+// obsolete files. The limiters passed in must be singletons shared across this
+// pebble instance. A nil limiter does not limit deletions.
+func newDeletionPacer(
+	bytesLimiter, fileLimiter limiter, getInfo func() deletionPacerInfo,
+) *deletionPacer {
 	return &deletionPacer{
-		limiter: limiter,
+		bytesLimiter: bytesLimiter,
+		fileLimiter:  fileLimiter,
 		// If there are less than freeSpaceThreshold bytes of free space on
 		// disk, do not pace deletions at all.
 		freeSpaceThreshold: 16 << 30, // 16 GB
@@ -64,9 +69,9 @@ func newDeletionPacer(limiter limiter, getInfo func() deletionPacerInfo) *deleti
 	}
 }
 
-// limit applies rate limiting if the current free disk space is more than
-// freeSpaceThreshold, and the ratio of obsolete to live bytes is less than
-// obsoleteBytesMaxRatio.
+// limit applies rate limiting to the deletion of a file of the given size if
+// the current free disk space is more than freeSpaceThreshold, and the ratio of
+// obsolete to live bytes is less than obsoleteBytesMaxRatio.
 func (p *deletionPacer) limit(amount uint64, info deletionPacerInfo) error {
 	obsoleteBytesRatio := float64(1.0)
 	if info.liveBytes > 0 {
@@ -74,39 +79,54 @@ func (p *deletionPacer) limit(amount uint64, info deletionPacerInfo) error {
 	}
 	paceDeletions := info.freeBytes > p.freeSpaceThreshold &&
 		obsoleteBytesRatio < p.obsoleteBytesMaxRatio
-	if paceDeletions {
-		burst := p.limiter.Burst()
+	if p.bytesLimiter != nil {
+		if err := limitN(p.bytesLimiter, amount, paceDeletions); err != nil {
+			return err
+		}
+	}
+	if p.fileLimiter != nil {
+		if err := limitN(p.fileLimiter, 1, paceDeletions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitN waits for the limiter to allow amount tokens if pace is true, and
+// otherwise accounts for them in the limiter without waiting.
+func limitN(l limiter, amount uint64, pace bool) error {
+	burst := l.Burst()
+	if pace {
 		for amount > uint64(burst) {
-			d := p.limiter.DelayN(time.Now(), burst)
+			d := l.DelayN(time.Now(), burst)
 			if d == rate.InfDuration {
 				return errors.Errorf("pacing failed")
 			}
 			time.Sleep(d)
 			amount -= uint64(burst)
 		}
-		d := p.limiter.DelayN(time.Now(), int(amount))
+		d := l.DelayN(time.Now(), int(amount))
 		if d == rate.InfDuration {
 			return errors.Errorf("pacing failed")
 		}
 		time.Sleep(d)
 	} else {
-		burst := p.limiter.Burst()
 		for amount > uint64(burst) {
 			// AllowN will subtract burst if there are enough tokens available,
 			// else leave the tokens untouched. That is, we are making a
 			// best-effort to account for this activity in the limiter, but by
 			// ignoring the return value, we do the activity instantaneously
 			// anyway.
-			p.limiter.AllowN(time.Now(), burst)
+			l.AllowN(time.Now(), burst)
 			amount -= uint64(burst)
 		}
-		p.limiter.AllowN(time.Now(), int(amount))
+		l.AllowN(time.Now(), int(amount))
 	}
 	return nil
 }
 
 // maybeThrottle slows down a deletion of this file if it's faster than
-// opts.Experimental.MinDeletionRate.
+// opts.Experimental.MinDeletionRate or opts.Experimental.MinDeletionFileRate.
 func (p *deletionPacer) maybeThrottle(bytesToDelete uint64) error {
 	return p.limit(bytesToDelete, p.getInfo())
 }
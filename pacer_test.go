@@ -16,17 +16,18 @@ import (
 )
 
 type mockPrintLimiter struct {
-	buf   bytes.Buffer
-	burst int
+	buf    *bytes.Buffer
+	prefix string
+	burst  int
 }
 
 func (m *mockPrintLimiter) DelayN(now time.Time, n int) time.Duration {
-	fmt.Fprintf(&m.buf, "wait: %d\n", n)
+	fmt.Fprintf(m.buf, "%swait: %d\n", m.prefix, n)
 	return 0
 }
 
 func (m *mockPrintLimiter) AllowN(now time.Time, n int) bool {
-	fmt.Fprintf(&m.buf, "allow: %d\n", n)
+	fmt.Fprintf(m.buf, "%sallow: %d\n", m.prefix, n)
 	return true
 }
 
@@ -44,6 +45,8 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 				}
 
 				burst := uint64(1)
+				bytesLimiter := uint64(1)
+				var fileBurst uint64
 				var bytesIterated uint64
 				var slowdownThreshold uint64
 				var freeBytes, liveBytes, obsoleteBytes uint64
@@ -62,6 +65,10 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 						switch varKey {
 						case "burst":
 							burst = varValue
+						case "bytesLimiter":
+							bytesLimiter = varValue
+						case "fileBurst":
+							fileBurst = varValue
 						case "bytesIterated":
 							bytesIterated = varValue
 						case "slowdownThreshold":
@@ -78,7 +85,8 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 					}
 				}
 
-				mockLimiter := mockPrintLimiter{burst: int(burst)}
+				var buf bytes.Buffer
+				mockLimiter := mockPrintLimiter{buf: &buf, burst: int(burst)}
 				switch d.CmdArgs[0].Key {
 				case "deletion":
 					getInfo := func() deletionPacerInfo {
@@ -88,14 +96,23 @@ func TestCompactionPacerMaybeThrottle(t *testing.T) {
 							obsoleteBytes: obsoleteBytes,
 						}
 					}
-					deletionPacer := newDeletionPacer(&mockLimiter, getInfo)
+					// The limiters are only set when enabled, as a nil
+					// *mockPrintLimiter would not be a nil limiter.
+					var byteLimiter, fileLimiter limiter
+					if bytesLimiter != 0 {
+						byteLimiter = &mockLimiter
+					}
+					if fileBurst != 0 {
+						fileLimiter = &mockPrintLimiter{buf: &buf, prefix: "file ", burst: int(fileBurst)}
+					}
+					deletionPacer := newDeletionPacer(byteLimiter, fileLimiter, getInfo)
 					deletionPacer.freeSpaceThreshold = slowdownThreshold
 					err := deletionPacer.maybeThrottle(bytesIterated)
 					if err != nil {
 						return err.Error()
 					}
 
-					return mockLimiter.buf.String()
+					return buf.String()
 				default:
 					return fmt.Sprintf("unknown command: %s", d.Cmd)
 				}
@@ -95,6 +95,9 @@ func (d *DB) updateReadStateLocked(checker func(*DB) error) {
 	old := d.readState.val
 	d.readState.val = s
 	d.readState.Unlock()
+	if d.opts.Experimental.TenantQuotas != nil {
+		d.updateTenantUsageLocked(false /* force */)
+	}
 	if checker != nil {
 		if err := checker(d); err != nil {
 			d.opts.Logger.Fatalf("checker failed with error: %s", err)
@@ -0,0 +1,105 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import "github.com/cockroachdb/errors"
+
+// ReclaimableBytes is the estimated disk space which compactions would
+// reclaim in a key range, as returned by DB.EstimateReclaimableBytes.
+type ReclaimableBytes struct {
+	// DeleteOnly is the size of the sstables in the range whose keys are all
+	// deleted by newer range deletions (or range key deletions). These
+	// sstables are dropped by delete-only compactions, without rewriting any
+	// data, as soon as no open snapshot needs them.
+	DeleteOnly uint64
+	// Compaction is the estimated size of the data in the range which is
+	// deleted by point or range deletions but is stored in sstables which
+	// also hold live data, and is only reclaimed once the sstables are
+	// rewritten by compactions. The estimate is at data block granularity;
+	// see TableStats.RangeDeletionsBytesEstimate. Like DeleteOnly, it only
+	// accounts for the sstables contained in the range.
+	Compaction uint64
+	// PendingStats is the number of sstables contained in the range whose
+	// table stats have not been loaded yet, and are thus not accounted for in
+	// the estimates. It is usually non-zero shortly after the DB is opened or
+	// after sstables are written.
+	PendingStats int
+}
+
+// Total returns the total estimated reclaimable space.
+func (r ReclaimableBytes) Total() uint64 {
+	return r.DeleteOnly + r.Compaction
+}
+
+// EstimateReclaimableBytes estimates the disk space which compactions would
+// reclaim in the key range [start, end), e.g. after a large DeleteRange, so
+// that operators can predict the space recovery. The estimate is derived from
+// the deletion hints which drive delete-only compactions and from the table
+// stats, which are loaded asynchronously: it accounts for the deletions
+// written to sstables, but not for those still in the memtables.
+func (d *DB) EstimateReclaimableBytes(start, end []byte) (ReclaimableBytes, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.cmp(start, end) > 0 {
+		return ReclaimableBytes{}, errors.New("invalid key-range specified (start > end)")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v := d.mu.versions.currentVersion()
+
+	var r ReclaimableBytes
+	// The sstables are only counted, in both estimates, if they are contained
+	// in the range, so that the estimates of adjacent ranges add up without
+	// counting the sstables which straddle them twice. Open snapshots are
+	// ignored: they only delay the reclamation.
+	contained := func(m *fileMetadata) bool {
+		return d.cmp(start, m.Smallest.UserKey) <= 0 &&
+			(d.cmp(m.Largest.UserKey, end) < 0 ||
+				(d.cmp(m.Largest.UserKey, end) == 0 && m.Largest.IsExclusiveSentinel()))
+	}
+	deletable := make(map[*fileMetadata]struct{})
+	for i := range d.mu.compact.deletionHints {
+		h := &d.mu.compact.deletionHints[i]
+		if d.cmp(h.end, start) <= 0 || d.cmp(end, h.start) <= 0 {
+			continue
+		}
+		for l := h.tombstoneLevel + 1; l < numLevels; l++ {
+			overlaps := v.Overlaps(l, d.cmp, h.start, h.end, true /* exclusiveEnd */)
+			iter := overlaps.Iter()
+			for m := iter.First(); m != nil; m = iter.Next() {
+				if _, ok := deletable[m]; ok || !contained(m) || !h.canDelete(d.cmp, m, nil /* snapshots */) {
+					continue
+				}
+				deletable[m] = struct{}{}
+				r.DeleteOnly += m.Size
+			}
+		}
+	}
+
+	var rangeDeletions uint64
+	for l := range v.Levels {
+		overlaps := v.Overlaps(l, d.cmp, start, end, true /* exclusiveEnd */)
+		iter := overlaps.Iter()
+		for m := iter.First(); m != nil; m = iter.Next() {
+			if _, ok := deletable[m]; ok || !contained(m) {
+				continue
+			}
+			if !m.StatsValidLocked() {
+				r.PendingStats++
+				continue
+			}
+			r.Compaction += m.Stats.PointDeletionsBytesEstimate
+			rangeDeletions += m.Stats.RangeDeletionsBytesEstimate
+		}
+	}
+	// The range deletion estimates of the sstables holding the tombstones
+	// include the sstables which delete-only compactions drop.
+	if rangeDeletions > r.DeleteOnly {
+		r.Compaction += rangeDeletions - r.DeleteOnly
+	}
+	return r, nil
+}
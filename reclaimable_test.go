@@ -0,0 +1,71 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateReclaimableBytes(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write the keyspaces "a" and "b" to separate sstables in L6.
+	value := []byte(strings.Repeat("v", 100))
+	for _, ks := range []string{"a", "b"} {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%s%03d", ks, i)), value, nil))
+		}
+		require.NoError(t, d.Compact([]byte(ks), []byte(ks+"\xff"), false /* parallelize */))
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[numLevels-1], 2)
+	bSize := tables[numLevels-1][1].Size
+
+	estimate := func(start, end string) ReclaimableBytes {
+		d.mu.Lock()
+		d.waitTableStats()
+		d.mu.Unlock()
+		r, err := d.EstimateReclaimableBytes([]byte(start), []byte(end))
+		require.NoError(t, err)
+		return r
+	}
+	require.Equal(t, ReclaimableBytes{}, estimate("a", "c"))
+
+	// Deleting the keyspace "b" makes its sstable droppable by a delete-only
+	// compaction, while deleting half of the keyspace "a" requires rewriting
+	// its sstable.
+	require.NoError(t, d.DeleteRange([]byte("b"), []byte("c"), nil))
+	require.NoError(t, d.DeleteRange([]byte("a050"), []byte("a999"), nil))
+	require.NoError(t, d.Flush())
+	r := estimate("a", "c")
+	require.Equal(t, 0, r.PendingStats)
+	require.Equal(t, bSize, r.DeleteOnly)
+	require.Greater(t, r.Compaction, uint64(0))
+	require.Equal(t, r.DeleteOnly+r.Compaction, r.Total())
+
+	// The sstable of the keyspace "b" is not contained in the keyspace "a".
+	// The sstable holding the range deletions straddles both keyspaces, so
+	// it is not counted in either of them: the estimates of adjacent ranges
+	// add up.
+	ra, rb := estimate("a", "b"), estimate("b", "c")
+	require.Equal(t, uint64(0), ra.DeleteOnly)
+	require.Equal(t, bSize, rb.DeleteOnly)
+	require.LessOrEqual(t, ra.Total()+rb.Total(), r.Total())
+
+	// Once compacted, nothing is left to reclaim.
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.Equal(t, ReclaimableBytes{}, estimate("a", "c"))
+
+	_, err = d.EstimateReclaimableBytes([]byte("c"), []byte("a"))
+	require.Error(t, err)
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/pebble/internal/humanize"
+)
+
+// RecoveryMode configures how Open handles a DB which cannot be recovered in
+// full, because of a corrupt WAL or missing sstables. See
+// Options.RecoveryMode.
+type RecoveryMode int
+
+const (
+	// RecoveryStrict refuses to open a DB which cannot be recovered in full.
+	// Only an invalid tail of the most recent WAL, which is common after a
+	// crash due to WAL preallocation and recycling, is tolerated. This is the
+	// default.
+	RecoveryStrict RecoveryMode = iota
+	// RecoveryTolerateCorruptWALTail opens a DB whose WALs are corrupt,
+	// recovering the writes up to the first corruption. The rest of the
+	// corrupt WAL and any subsequent WALs are dropped, so that the recovered
+	// DB reflects a consistent point in time.
+	RecoveryTolerateCorruptWALTail
+	// RecoverySkipMissingTables additionally opens a DB whose MANIFEST
+	// references sstables which do not exist, by removing them from the LSM.
+	// It is not supported for read-only DBs.
+	RecoverySkipMissingTables
+)
+
+// String implements fmt.Stringer.
+func (m RecoveryMode) String() string {
+	switch m {
+	case RecoveryStrict:
+		return "strict"
+	case RecoveryTolerateCorruptWALTail:
+		return "tolerate-corrupt-wal-tail"
+	case RecoverySkipMissingTables:
+		return "skip-missing-tables"
+	default:
+		return fmt.Sprintf("RecoveryMode(%d)", int(m))
+	}
+}
+
+// RecoveryReport describes the data that was dropped while opening a DB with
+// a RecoveryMode other than RecoveryStrict. See DB.RecoveryReport.
+type RecoveryReport struct {
+	// WALs lists the WALs which were not replayed in full, in order.
+	WALs []DroppedWALInfo
+	// MissingTables lists the sstables which did not exist and were removed
+	// from the LSM.
+	MissingTables []MissingTableInfo
+}
+
+// DroppedWALInfo describes a WAL which was not replayed in full.
+type DroppedWALInfo struct {
+	FileNum FileNum
+	// Offset is the offset from which the WAL was dropped. It is zero if the
+	// WAL was dropped in full because a previous WAL was corrupt.
+	Offset int64
+	// Size is the size of the WAL file.
+	Size int64
+	// Err is the corruption which stopped the replay of the WAL, or nil if a
+	// previous WAL was corrupt.
+	Err error
+}
+
+// MissingTableInfo describes an sstable which was referenced by the MANIFEST
+// but did not exist, and was removed from the LSM.
+type MissingTableInfo struct {
+	Level   int
+	FileNum FileNum
+	Size    uint64
+	// Smallest and Largest bound the keys of the sstable, whose writes may
+	// have been lost.
+	Smallest InternalKey
+	Largest  InternalKey
+}
+
+// Empty returns true if no data was dropped.
+func (r RecoveryReport) Empty() bool {
+	return len(r.WALs) == 0 && len(r.MissingTables) == 0
+}
+
+// String implements fmt.Stringer.
+func (r RecoveryReport) String() string {
+	var buf strings.Builder
+	for _, w := range r.WALs {
+		if w.Err != nil {
+			fmt.Fprintf(&buf, "WAL %s: dropped %s from offset %d: %v\n", w.FileNum,
+				humanize.IEC.Int64(w.Size-w.Offset), w.Offset, w.Err)
+		} else {
+			fmt.Fprintf(&buf, "WAL %s: dropped %s after a previous corrupt WAL\n", w.FileNum,
+				humanize.IEC.Int64(w.Size))
+		}
+	}
+	for _, t := range r.MissingTables {
+		fmt.Fprintf(&buf, "L%d: table %s: missing, dropped %s of keys in [%s, %s]\n",
+			t.Level, t.FileNum, humanize.IEC.Uint64(t.Size), t.Smallest, t.Largest)
+	}
+	return buf.String()
+}
+
+// RecoveryReport returns the data which was dropped while opening the DB, when
+// Options.RecoveryMode is not RecoveryStrict. The report is empty if the DB
+// was recovered in full.
+func (d *DB) RecoveryReport() RecoveryReport {
+	return d.recoveryReport
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryCorruptWAL(t *testing.T) {
+	// Use the real filesystem so that we can overwrite WAL data easily.
+	dir := t.TempDir()
+
+	d, err := Open(dir, &Options{
+		MemTableStopWritesThreshold: 4,
+		MemTableSize:                2048,
+	})
+	require.NoError(t, err)
+	d.mu.Lock()
+	d.mu.compact.flushing = true
+	d.mu.Unlock()
+	require.NoError(t, d.Set([]byte("0"), []byte("foo"), nil))
+	require.NoError(t, d.Set([]byte("1"), []byte(strings.Repeat("a", 1024)), nil))
+	require.NoError(t, d.Set([]byte("2"), []byte(strings.Repeat("b", 1024)), nil))
+	d.mu.Lock()
+	d.mu.compact.flushing = false
+	d.mu.Unlock()
+	require.NoError(t, d.Close())
+
+	var logs []string
+	ls, err := vfs.Default.List(dir)
+	require.NoError(t, err)
+	for _, name := range ls {
+		if filepath.Ext(name) == ".log" {
+			logs = append(logs, name)
+		}
+	}
+	sort.Strings(logs)
+	require.GreaterOrEqual(t, len(logs), 2)
+
+	// Corrupt the (n-1)th WAL, which holds key "2", 100 bytes from the end of
+	// the file.
+	f, err := os.OpenFile(filepath.Join(dir, logs[len(logs)-2]), os.O_RDWR, os.ModePerm)
+	require.NoError(t, err)
+	_, err = f.Seek(-100, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 0})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = Open(dir, &Options{})
+	require.True(t, errors.Is(err, base.ErrCorruption), "%+v", err)
+
+	var log base.InMemLogger
+	d, err = Open(dir, &Options{
+		Logger:       &log,
+		RecoveryMode: RecoveryTolerateCorruptWALTail,
+	})
+	require.NoError(t, err)
+	report := d.RecoveryReport()
+	require.Len(t, report.WALs, 2)
+	require.Error(t, report.WALs[0].Err)
+	require.Greater(t, report.WALs[0].Size, report.WALs[0].Offset)
+	require.NoError(t, report.WALs[1].Err)
+	require.Zero(t, report.WALs[1].Offset)
+	require.Empty(t, report.MissingTables)
+	require.Contains(t, log.String(), "dropped data")
+
+	// The writes before the corruption are recovered.
+	for _, k := range []string{"0", "1"} {
+		_, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+	_, _, err = d.Get([]byte("2"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, d.Close())
+
+	// The dropped WALs have been removed, so the DB can now be opened in
+	// strict mode.
+	d, err = Open(dir, &Options{})
+	require.NoError(t, err)
+	require.True(t, d.RecoveryReport().Empty())
+	require.NoError(t, d.Close())
+}
+
+func TestRecoverySkipMissingTables(t *testing.T) {
+	mem := vfs.NewMem()
+	d, err := Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+
+	var missing *TableInfo
+	for level := range tables {
+		for i := range tables[level] {
+			if missing == nil || tables[level][i].FileNum > missing.FileNum {
+				missing = &tables[level][i].TableInfo
+			}
+		}
+	}
+	require.NotNil(t, missing)
+	require.NoError(t, mem.Remove(base.MakeFilename(fileTypeTable, missing.FileNum)))
+
+	_, err = Open("", &Options{FS: mem})
+	require.Error(t, err)
+	_, err = Open("", &Options{
+		FS:           mem,
+		ReadOnly:     true,
+		RecoveryMode: RecoverySkipMissingTables,
+	})
+	require.Error(t, err)
+
+	d, err = Open("", &Options{FS: mem, RecoveryMode: RecoverySkipMissingTables})
+	require.NoError(t, err)
+	report := d.RecoveryReport()
+	require.Empty(t, report.WALs)
+	require.Len(t, report.MissingTables, 1)
+	require.Equal(t, missing.FileNum, report.MissingTables[0].FileNum)
+	require.Equal(t, []byte("b"), report.MissingTables[0].Smallest.UserKey)
+	require.Equal(t, []byte("b"), report.MissingTables[0].Largest.UserKey)
+	require.Contains(t, report.String(), "missing")
+
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), v)
+	require.NoError(t, closer.Close())
+	_, _, err = d.Get([]byte("b"))
+	require.ErrorIs(t, err, ErrNotFound)
+	require.EqualValues(t, 1, d.Metrics().Levels[0].NumFiles)
+	require.NoError(t, d.Close())
+
+	d, err = Open("", &Options{FS: mem})
+	require.NoError(t, err)
+	require.True(t, d.RecoveryReport().Empty())
+	require.NoError(t, d.Close())
+}
@@ -0,0 +1,457 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/cache"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/record"
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/cockroachdb/pebble/vfs/atomicfs"
+)
+
+// repairOptions hold the optional parameters of Repair.
+type repairOptions struct {
+	// scanSharedStorage is set to recover the sstables on shared storage which
+	// are missing from the shared object catalog.
+	scanSharedStorage bool
+	creatorID         uint64
+}
+
+// RepairOption sets optional parameters used by Repair.
+type RepairOption func(*repairOptions)
+
+// WithSharedStorageScan makes Repair list the objects that the DB created on
+// Options.Experimental.SharedStorage, with the given creator ID (see
+// DB.SetCreatorID), and recover the sstables which are missing from the shared
+// object catalog, e.g. because the catalog was lost along with the MANIFEST.
+//
+// Since shared objects are currently never deleted from shared storage, this
+// may resurrect obsolete sstables, whose data is also present in the sstables
+// that were produced by compacting them. It should only be used when the
+// catalog is known to be lost.
+func WithSharedStorageScan(creatorID uint64) RepairOption {
+	return func(opt *repairOptions) {
+		opt.scanSharedStorage = true
+		opt.creatorID = creatorID
+	}
+}
+
+// RepairReport describes the LSM reconstructed by Repair.
+type RepairReport struct {
+	// ManifestFileNum is the file number of the new MANIFEST.
+	ManifestFileNum FileNum
+	// Levels lists the sstables placed in each level of the LSM.
+	Levels [numLevels][]TableInfo
+	// IgnoredTables lists the sstables which could not be read, and were left
+	// out of the LSM.
+	IgnoredTables []IgnoredTableInfo
+	// MinUnflushedLogNum is the file number of the first WAL that will be
+	// replayed when the DB is opened. The WALs with smaller file numbers only
+	// hold writes which are already present in the sstables.
+	MinUnflushedLogNum FileNum
+}
+
+// IgnoredTableInfo describes an sstable which Repair left out of the LSM.
+type IgnoredTableInfo struct {
+	FileNum FileNum
+	Err     error
+}
+
+// Repair reconstructs the LSM of the DB in dirname from its sstables, for when
+// the MANIFEST is lost or corrupt but the sstables survive. It reads the bounds
+// and sequence numbers of every sstable in the data directory (and on shared
+// storage, if configured) and writes a new MANIFEST which places them in the
+// LSM, making it the current MANIFEST. The DB must not be open.
+//
+// Each sstable is placed in the lowest level where it does not overlap any
+// older sstable, where sstables are ordered by their largest sequence number,
+// as in L0. This ensures that overlapping data is read from the newer sstables
+// first, unless the sequence numbers of overlapping sstables are interleaved,
+// which can happen when a compaction moves newer data below an older sstable
+// whose keys it does not contain. Compactions then restore the shape of the
+// LSM. Writes that were only in the WALs are recovered when the DB is next
+// opened.
+func Repair(dirname string, opts *Options, repairOpts ...RepairOption) (RepairReport, error) {
+	var ropts repairOptions
+	for _, o := range repairOpts {
+		o(&ropts)
+	}
+	opts = opts.Clone()
+	opts = opts.EnsureDefaults()
+	if err := opts.Validate(); err != nil {
+		return RepairReport{}, err
+	}
+	if opts.ReadOnly {
+		return RepairReport{}, errors.New("pebble: cannot repair a DB in read-only mode")
+	}
+	if opts.Cache == nil {
+		opts.Cache = cache.New(cacheDefaultSize)
+	} else {
+		opts.Cache.Ref()
+	}
+	defer opts.Cache.Unref()
+	fs := opts.FS
+
+	fileLock, err := fs.Lock(base.MakeFilepath(fs, dirname, fileTypeLock, 0))
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer fileLock.Close()
+
+	dataDir, err := fs.OpenDir(dirname)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer dataDir.Close()
+
+	formatVersion, formatVersionMarker, err := lookupFormatMajorVersion(fs, dirname)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer formatVersionMarker.Close()
+	manifestMarker, _, _, err := findCurrentManifest(formatVersion, fs, dirname)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer manifestMarker.Close()
+
+	providerSettings := objstorage.DefaultSettings(fs, dirname)
+	providerSettings.Logger = opts.Logger
+	providerSettings.Shared.Storage = opts.Experimental.SharedStorage
+	provider, err := objstorage.Open(providerSettings)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer provider.Close()
+	if ropts.scanSharedStorage {
+		if opts.Experimental.SharedStorage == nil {
+			return RepairReport{}, errors.New("pebble: cannot scan shared storage: not configured")
+		}
+		if err := provider.SetCreatorID(objstorage.CreatorID(ropts.creatorID)); err != nil {
+			return RepairReport{}, err
+		}
+		if _, err := provider.RecoverSharedObjects(); err != nil {
+			return RepairReport{}, err
+		}
+		if err := provider.Sync(); err != nil {
+			return RepairReport{}, err
+		}
+	}
+
+	// Find the next file number, past any file in the data and WAL
+	// directories, and the WALs.
+	var nextFileNum FileNum = 1
+	markFileNumUsed := func(fileNum FileNum) {
+		if nextFileNum <= fileNum {
+			nextFileNum = fileNum + 1
+		}
+	}
+	walDirname := dirname
+	if opts.WALDir != "" {
+		walDirname = opts.WALDir
+	}
+	var logs []FileNum
+	for _, dir := range []string{dirname, walDirname} {
+		ls, err := fs.List(dir)
+		if err != nil {
+			return RepairReport{}, err
+		}
+		for _, filename := range ls {
+			ft, fn, ok := base.ParseFilename(fs, filename)
+			if !ok {
+				continue
+			}
+			markFileNumUsed(fn)
+			if ft == fileTypeLog && dir == walDirname {
+				logs = append(logs, fn)
+			}
+		}
+		if walDirname == dirname {
+			break
+		}
+	}
+
+	var report RepairReport
+	var tables []*fileMetadata
+	for _, meta := range provider.List() {
+		if meta.FileType != fileTypeTable {
+			continue
+		}
+		markFileNumUsed(meta.FileNum)
+		m, err := repairLoadTable(opts, provider, meta.FileNum)
+		if err != nil {
+			opts.Logger.Infof("pebble: repair: ignoring table %s: %v", meta.FileNum, err)
+			report.IgnoredTables = append(report.IgnoredTables, IgnoredTableInfo{
+				FileNum: meta.FileNum,
+				Err:     err,
+			})
+			continue
+		}
+		tables = append(tables, m)
+	}
+
+	ve := versionEdit{ComparerName: opts.Comparer.Name}
+	for _, nf := range repairPlaceTables(opts.Comparer.Compare, tables) {
+		ve.NewFiles = append(ve.NewFiles, nf)
+		report.Levels[nf.Level] = append(report.Levels[nf.Level], nf.Meta.TableInfo())
+		if ve.LastSeqNum < nf.Meta.LargestSeqNum {
+			ve.LastSeqNum = nf.Meta.LargestSeqNum
+		}
+	}
+
+	report.ManifestFileNum = nextFileNum
+	nextFileNum++
+	// Replay the WALs from the first one that holds writes which are not in
+	// the sstables.
+	report.MinUnflushedLogNum = nextFileNum
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	for _, logNum := range logs {
+		maxSeqNum, err := repairMaxLogSeqNum(opts, walDirname, logNum)
+		if err != nil {
+			return RepairReport{}, err
+		}
+		if maxSeqNum > ve.LastSeqNum {
+			report.MinUnflushedLogNum = logNum
+			break
+		}
+	}
+	ve.MinUnflushedLogNum = report.MinUnflushedLogNum
+	ve.NextFileNum = nextFileNum
+
+	if err := repairWriteManifest(opts, dirname, dataDir, formatVersion, manifestMarker,
+		report.ManifestFileNum, &ve); err != nil {
+		return RepairReport{}, err
+	}
+	return report, nil
+}
+
+// repairLoadTable reads the bounds and the sequence numbers of the given
+// table, which requires a scan of the table.
+func repairLoadTable(
+	opts *Options, provider *objstorage.Provider, fileNum FileNum,
+) (_ *fileMetadata, err error) {
+	readable, err := provider.OpenForReading(context.Background(), fileTypeTable, fileNum, objstorage.OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+	cacheOpts := private.SSTableCacheOpts(opts.Cache.NewID(), fileNum).(sstable.ReaderOption)
+	r, err := sstable.NewReader(readable, opts.MakeReaderOptions(), cacheOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if name := r.Properties.ComparerName; name != "" && name != opts.Comparer.Name {
+		return nil, errors.Errorf("pebble: table comparer %q does not match %q", name, opts.Comparer.Name)
+	}
+
+	cmp := opts.Comparer.Compare
+	meta := &fileMetadata{
+		FileNum:        fileNum,
+		Size:           uint64(readable.Size()),
+		CreationTime:   time.Now().Unix(),
+		SmallestSeqNum: InternalKeySeqNumMax,
+	}
+	addSeqNum := func(seqNum uint64) {
+		if meta.SmallestSeqNum > seqNum {
+			meta.SmallestSeqNum = seqNum
+		}
+		if meta.LargestSeqNum < seqNum {
+			meta.LargestSeqNum = seqNum
+		}
+	}
+	maybeSetStatsFromProperties(meta, &r.Properties)
+
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return nil, err
+	}
+	var smallest, largest InternalKey
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+		if smallest.UserKey == nil {
+			smallest = key.Clone()
+		}
+		largest.UserKey = append(largest.UserKey[:0], key.UserKey...)
+		largest.Trailer = key.Trailer
+		addSeqNum(key.SeqNum())
+	}
+	if err := firstError(iter.Error(), iter.Close()); err != nil {
+		return nil, err
+	}
+	if smallest.UserKey != nil {
+		meta.ExtendPointKeyBounds(cmp, smallest, largest)
+	}
+
+	scanSpans := func(iter keyspan.FragmentIterator, extend func(smallest, largest InternalKey)) error {
+		if iter == nil {
+			return nil
+		}
+		var smallest, largest InternalKey
+		for s := iter.First(); s != nil; s = iter.Next() {
+			if smallest.UserKey == nil {
+				smallest = s.SmallestKey().Clone()
+			}
+			largest = s.LargestKey().Clone()
+			for _, k := range s.Keys {
+				addSeqNum(k.SeqNum())
+			}
+		}
+		if err := firstError(iter.Error(), iter.Close()); err != nil {
+			return err
+		}
+		if smallest.UserKey != nil {
+			extend(smallest, largest)
+		}
+		return nil
+	}
+	rangeDelIter, err := r.NewRawRangeDelIter()
+	if err != nil {
+		return nil, err
+	}
+	if err := scanSpans(rangeDelIter, func(smallest, largest InternalKey) {
+		meta.ExtendPointKeyBounds(cmp, smallest, largest)
+	}); err != nil {
+		return nil, err
+	}
+	rangeKeyIter, err := r.NewRawRangeKeyIter()
+	if err != nil {
+		return nil, err
+	}
+	if err := scanSpans(rangeKeyIter, func(smallest, largest InternalKey) {
+		meta.ExtendRangeKeyBounds(cmp, smallest, largest)
+	}); err != nil {
+		return nil, err
+	}
+
+	if !meta.HasPointKeys && !meta.HasRangeKeys {
+		return nil, errors.New("pebble: empty table")
+	}
+	return meta, nil
+}
+
+// repairPlaceTables assigns the tables to levels. The tables are processed in
+// the order of their sequence numbers, as in L0, and each is placed in the
+// level above the highest level of the previous tables it overlaps, or in the
+// bottommost level if it overlaps none, falling back to L0.
+func repairPlaceTables(cmp Compare, tables []*fileMetadata) []newFileEntry {
+	sort.Slice(tables, func(i, j int) bool {
+		a, b := tables[i], tables[j]
+		if a.LargestSeqNum != b.LargestSeqNum {
+			return a.LargestSeqNum < b.LargestSeqNum
+		}
+		if a.SmallestSeqNum != b.SmallestSeqNum {
+			return a.SmallestSeqNum < b.SmallestSeqNum
+		}
+		return a.FileNum < b.FileNum
+	})
+	overlaps := func(a, b *fileMetadata) bool {
+		return base.InternalCompare(cmp, a.Largest, b.Smallest) >= 0 &&
+			base.InternalCompare(cmp, b.Largest, a.Smallest) >= 0
+	}
+	placed := make([]newFileEntry, 0, len(tables))
+	for _, t := range tables {
+		level := numLevels - 1
+		for _, p := range placed {
+			if p.Level <= level && overlaps(t, p.Meta) {
+				level = p.Level - 1
+			}
+		}
+		if level < 0 {
+			level = 0
+		}
+		placed = append(placed, newFileEntry{Level: level, Meta: t})
+	}
+	return placed
+}
+
+// repairMaxLogSeqNum returns the largest sequence number of the writes in the
+// given WAL, stopping at the first invalid record.
+func repairMaxLogSeqNum(opts *Options, walDirname string, logNum FileNum) (uint64, error) {
+	f, err := opts.FS.Open(base.MakeFilepath(opts.FS, walDirname, fileTypeLog, logNum))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var maxSeqNum uint64
+	var buf bytes.Buffer
+	rr := record.NewReader(f, logNum)
+	for {
+		r, err := rr.Next()
+		if err == nil {
+			buf.Reset()
+			_, err = io.Copy(&buf, r)
+		}
+		if err != nil {
+			// The WAL may have an invalid tail, or be corrupt. The records
+			// that follow are not replayed anyway.
+			return maxSeqNum, nil
+		}
+		var b Batch
+		if err := b.SetRepr(buf.Bytes()); err != nil {
+			return maxSeqNum, nil
+		}
+		if count := uint64(b.Count()); count > 0 && maxSeqNum < b.SeqNum()+count-1 {
+			maxSeqNum = b.SeqNum() + count - 1
+		}
+	}
+}
+
+// repairWriteManifest writes the version edit to a new MANIFEST, and makes it
+// the current MANIFEST.
+func repairWriteManifest(
+	opts *Options,
+	dirname string,
+	dataDir interface{ Sync() error },
+	formatVersion FormatMajorVersion,
+	manifestMarker *atomicfs.Marker,
+	manifestFileNum FileNum,
+	ve *versionEdit,
+) (err error) {
+	fs := opts.FS
+	filename := base.MakeFilepath(fs, dirname, fileTypeManifest, manifestFileNum)
+	f, err := fs.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = fs.Remove(filename)
+		}
+	}()
+	rw := record.NewWriter(f)
+	w, err := rw.Next()
+	if err == nil {
+		err = ve.Encode(w)
+	}
+	if err == nil {
+		err = rw.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err = firstError(err, f.Close()); err != nil {
+		return err
+	}
+	if err := dataDir.Sync(); err != nil {
+		return err
+	}
+	if formatVersion < formatVersionedManifestMarker {
+		if err := setCurrentFile(dirname, fs, manifestFileNum); err != nil {
+			return err
+		}
+		return dataDir.Sync()
+	}
+	return manifestMarker.Move(base.MakeFilename(fileTypeManifest, manifestFileNum))
+}
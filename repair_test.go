@@ -0,0 +1,115 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%04d", i)) }
+	// Write overlapping generations of the keys, some of which are compacted
+	// into L6, some flushed into L0, and the last of which is only in the WAL.
+	for gen := 0; gen < 4; gen++ {
+		for i := gen; i < 100; i += 2 {
+			require.NoError(t, d.Set(key(i), []byte(fmt.Sprint(gen)), nil))
+		}
+		require.NoError(t, d.DeleteRange(key(90+gen), key(91+gen), nil))
+		if gen < 3 {
+			require.NoError(t, d.Flush())
+		}
+		if gen == 1 {
+			require.NoError(t, d.Compact(key(0), key(100), false /* parallelize */))
+		}
+	}
+	expected := func(d *DB) map[string]string {
+		m := make(map[string]string)
+		iter := d.NewIter(nil)
+		for iter.First(); iter.Valid(); iter.Next() {
+			m[string(iter.Key())] = string(iter.Value())
+		}
+		require.NoError(t, iter.Close())
+		return m
+	}
+	want := expected(d)
+	require.NoError(t, d.Close())
+
+	// Lose the MANIFEST, and corrupt a table.
+	ls, err := mem.List("")
+	require.NoError(t, err)
+	for _, name := range ls {
+		if strings.HasPrefix(name, "MANIFEST") || strings.HasPrefix(name, "marker.manifest") {
+			require.NoError(t, mem.Remove(name))
+		}
+	}
+	f, err := mem.Create("999999.sst")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("not a table"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = Open("", &Options{FS: mem, ErrorIfNotExists: true})
+	require.Error(t, err)
+
+	report, err := Repair("", opts)
+	require.NoError(t, err)
+	require.Len(t, report.IgnoredTables, 1)
+	require.EqualValues(t, 999999, report.IgnoredTables[0].FileNum)
+	require.Greater(t, report.ManifestFileNum, FileNum(999999))
+	var numTables int
+	for level := range report.Levels {
+		numTables += len(report.Levels[level])
+	}
+	require.Greater(t, numTables, 1)
+	require.NotEmpty(t, report.Levels[numLevels-1])
+	require.NoError(t, mem.Remove("999999.sst"))
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.Equal(t, want, expected(d))
+	require.Equal(t, "3", want[string(key(99))])
+	require.NoError(t, d.Set(key(1000), []byte("new"), nil))
+	require.NoError(t, d.Compact(key(0), key(1001), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	want[string(key(1000))] = "new"
+	require.Equal(t, want, expected(d))
+	require.NoError(t, d.Close())
+}
+
+func TestRepairPlaceTables(t *testing.T) {
+	cmp := DefaultComparer.Compare
+	table := func(fileNum FileNum, smallest, largest string, seqNum uint64) *fileMetadata {
+		m := &fileMetadata{FileNum: fileNum, SmallestSeqNum: seqNum, LargestSeqNum: seqNum}
+		m.ExtendPointKeyBounds(cmp,
+			base.MakeInternalKey([]byte(smallest), seqNum, InternalKeyKindSet),
+			base.MakeInternalKey([]byte(largest), seqNum, InternalKeyKindSet))
+		return m
+	}
+	placed := repairPlaceTables(cmp, []*fileMetadata{
+		table(4, "a", "z", 40),
+		table(1, "a", "c", 10),
+		table(2, "d", "f", 20),
+		table(3, "b", "e", 30),
+		table(5, "x", "y", 50),
+	})
+	levels := make(map[FileNum]int)
+	for _, nf := range placed {
+		levels[nf.Meta.FileNum] = nf.Level
+	}
+	require.Equal(t, map[FileNum]int{1: 6, 2: 6, 3: 5, 4: 4, 5: 3}, levels)
+}
@@ -0,0 +1,221 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble/objstorage"
+)
+
+const (
+	// replicaUploadQueueSize bounds the number of tables waiting for the
+	// upload of their replica. The tables which do not fit are replicated by
+	// the next scrub.
+	replicaUploadQueueSize = 1000
+	// replicaUploadAttempts is the number of attempts to upload a replica
+	// before leaving it to the next scrub.
+	replicaUploadAttempts = 5
+	// replicaUploadRetryDelay is the delay before the first retry of a failed
+	// upload; it doubles with each attempt.
+	replicaUploadRetryDelay = 100 * time.Millisecond
+)
+
+// replicaUploader uploads the replicas of the local sstables as soon as they
+// are added to the LSM (see Options.Experimental.ReplicaUploadConcurrency),
+// instead of waiting for the next scrub. It is best effort: the tables whose
+// replica cannot be uploaded, e.g. because the queue is full or shared
+// storage keeps failing, are replicated by the next scrub.
+type replicaUploader struct {
+	provider *objstorage.Provider
+	logger   Logger
+	queue    chan FileNum
+	closing  chan struct{}
+	wg       sync.WaitGroup
+
+	mu struct {
+		sync.Mutex
+		cond sync.Cond
+		// pending holds the tables which are queued or being uploaded.
+		pending  map[FileNum]struct{}
+		uploads  int64
+		failures int64
+		dropped  int64
+	}
+}
+
+// startReplicaUploaderLocked starts the upload of the replicas of the new
+// sstables. d.mu must be held.
+func (d *DB) startReplicaUploaderLocked() {
+	u := &replicaUploader{
+		provider: d.objProvider,
+		logger:   d.opts.Logger,
+		queue:    make(chan FileNum, replicaUploadQueueSize),
+		closing:  make(chan struct{}),
+	}
+	u.mu.cond.L = &u.mu.Mutex
+	u.mu.pending = make(map[FileNum]struct{})
+	// The subscribers are invoked once the MANIFEST is synced, so the tables
+	// added to the LSM are complete.
+	d.mu.versions.editSubscribers = append(d.mu.versions.editSubscribers, &versionEditSubscriber{
+		fn: u.enqueue,
+	})
+	for i := 0; i < d.opts.Experimental.ReplicaUploadConcurrency; i++ {
+		u.wg.Add(1)
+		go u.run()
+	}
+	d.replicaUploader = u
+}
+
+// WaitForReplicas blocks until no background upload of the replica of a new
+// sstable (see Options.Experimental.ReplicaUploadConcurrency) is queued or in
+// progress, the uploads having completed successfully or not, or until the
+// context is done, returning its error. It returns immediately if replicas are
+// not uploaded in the background.
+func (d *DB) WaitForReplicas(ctx context.Context) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.replicaUploader == nil {
+		return nil
+	}
+	return d.replicaUploader.wait(ctx)
+}
+
+// enqueue queues the upload of the replicas of the tables added by a version
+// edit. The tables moved to another level are not uploaded again. It must not
+// block, since it is invoked with d.mu held.
+func (u *replicaUploader) enqueue(info VersionEditInfo) {
+	moved := make(map[FileNum]struct{}, len(info.Deleted))
+	for _, t := range info.Deleted {
+		moved[t.FileNum] = struct{}{}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, t := range info.Added {
+		if _, ok := moved[t.FileNum]; ok {
+			continue
+		}
+		if _, ok := u.mu.pending[t.FileNum]; ok {
+			continue
+		}
+		select {
+		case u.queue <- t.FileNum:
+			u.mu.pending[t.FileNum] = struct{}{}
+		default:
+			u.mu.dropped++
+		}
+	}
+}
+
+// isPending returns true if the replica of the table is queued or being
+// uploaded.
+func (u *replicaUploader) isPending(fileNum FileNum) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	_, ok := u.mu.pending[fileNum]
+	return ok
+}
+
+// wait blocks until no upload is queued or in progress, or until the context
+// is done.
+func (u *replicaUploader) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			u.mu.Lock()
+			u.mu.cond.Broadcast()
+			u.mu.Unlock()
+		case <-done:
+		}
+	}()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for len(u.mu.pending) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		u.mu.cond.Wait()
+	}
+	return nil
+}
+
+// close stops the uploader once the uploads in progress complete; the queued
+// uploads are abandoned.
+func (u *replicaUploader) close() {
+	close(u.closing)
+	u.wg.Wait()
+}
+
+// metrics fills the metrics of the uploads of replicas.
+func (u *replicaUploader) metrics(m *Metrics) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	m.ReplicaUploads.Pending = int64(len(u.mu.pending))
+	m.ReplicaUploads.Uploads = u.mu.uploads
+	m.ReplicaUploads.Failures = u.mu.failures
+	m.ReplicaUploads.Dropped = u.mu.dropped
+}
+
+func (u *replicaUploader) run() {
+	defer u.wg.Done()
+	for {
+		select {
+		case <-u.closing:
+			return
+		case fileNum := <-u.queue:
+			uploaded, err := u.upload(fileNum)
+			u.mu.Lock()
+			delete(u.mu.pending, fileNum)
+			if uploaded {
+				u.mu.uploads++
+			} else if err != nil && err != errNoCreatorID {
+				u.mu.failures++
+				u.logger.Infof("pebble: unable to upload replica of table %s: %v", fileNum, err)
+			}
+			u.mu.cond.Broadcast()
+			u.mu.Unlock()
+		}
+	}
+}
+
+// upload uploads the replica of a table, retrying with an exponential backoff
+// if it fails. It returns false without an error if the table does not need a
+// replica anymore.
+func (u *replicaUploader) upload(fileNum FileNum) (uploaded bool, err error) {
+	if !u.provider.CanReplicate() {
+		return false, errNoCreatorID
+	}
+	delay := replicaUploadRetryDelay
+	for attempt := 0; attempt < replicaUploadAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-u.closing:
+				return false, err
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		meta, lookupErr := u.provider.Lookup(fileTypeTable, fileNum)
+		if lookupErr != nil || meta.IsShared() {
+			// The table was deleted, or does not need a replica.
+			return false, nil
+		}
+		if err = u.provider.UploadReplica(context.Background(), meta); err == nil {
+			if _, lookupErr := u.provider.Lookup(fileTypeTable, fileNum); lookupErr != nil {
+				// The table was deleted during the upload, possibly before
+				// its replica could be removed with it.
+				u.provider.RemoveReplica(meta)
+				return false, nil
+			}
+			return true, nil
+		}
+	}
+	return false, err
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyCreateStorage is a shared.Storage whose first failures object
+// creations fail.
+type flakyCreateStorage struct {
+	shared.Storage
+	failures atomic.Int32
+}
+
+func (s *flakyCreateStorage) CreateObject(objName string) (io.WriteCloser, error) {
+	if s.failures.Add(-1) >= 0 {
+		return nil, errors.New("injected error")
+	}
+	return s.Storage.CreateObject(objName)
+}
+
+func TestReplicaUploader(t *testing.T) {
+	ctx := context.Background()
+	storage := &flakyCreateStorage{Storage: shared.NewInMem()}
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.Experimental.SharedStorage = storage
+	opts.Experimental.ReplicateLocalTables = true
+	opts.Experimental.ReplicaUploadConcurrency = 2
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	flush := func(k string) {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	replicated := func() int {
+		replicas, err := d.objProvider.ListReplicas(fileTypeTable)
+		require.NoError(t, err)
+		return len(replicas)
+	}
+
+	// Without the creator ID, the tables are left to the scrubs.
+	flush("a")
+	require.NoError(t, d.WaitForReplicas(ctx))
+	require.Zero(t, d.Metrics().ReplicaUploads.Failures)
+	require.NoError(t, d.SetCreatorID(1))
+	require.Zero(t, replicated())
+
+	// The replicas of the new tables are uploaded without a scrub.
+	flush("b")
+	flush("c")
+	require.NoError(t, d.WaitForReplicas(ctx))
+	require.Equal(t, 2, replicated())
+	m := d.Metrics().ReplicaUploads
+	require.Equal(t, int64(2), m.Uploads)
+	require.Zero(t, m.Pending)
+
+	// Transient failures are retried.
+	storage.failures.Store(2)
+	flush("d")
+	require.NoError(t, d.WaitForReplicas(ctx))
+	require.Equal(t, 3, replicated())
+	m = d.Metrics().ReplicaUploads
+	require.Equal(t, int64(3), m.Uploads)
+	require.Zero(t, m.Failures)
+
+	// A table moved by a compaction keeps its replica. The scrub only
+	// uploads the replica of the table flushed before the creator ID was
+	// set.
+	require.NoError(t, d.Compact([]byte("d"), []byte("d\x00"), false /* parallelize */))
+	report, err := d.Scrub()
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ReplicasCreated)
+	require.Equal(t, int64(3), d.Metrics().ReplicaUploads.Uploads)
+	for i := 0; i < 3; i++ {
+		flush(fmt.Sprint(i))
+	}
+	require.NoError(t, d.WaitForReplicas(ctx))
+	require.Equal(t, 7, replicated())
+}
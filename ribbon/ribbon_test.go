@@ -0,0 +1,144 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package ribbon
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/stretchr/testify/require"
+)
+
+func newTableFilter(resultBits int, keys ...[]byte) tableFilter {
+	w := FilterPolicy(resultBits).NewWriter(base.TableFilter)
+	for _, key := range keys {
+		w.AddKey(key)
+	}
+	return tableFilter(w.Finish(nil))
+}
+
+func le32(i int) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(i))
+	return b
+}
+
+func falsePositiveRate(f base.FilterPolicy, filter []byte) float64 {
+	const n = 100000
+	var nFalsePositive int
+	for i := 0; i < n; i++ {
+		if f.MayContain(base.TableFilter, filter, le32(1e9+i)) {
+			nFalsePositive++
+		}
+	}
+	return float64(nFalsePositive) / n
+}
+
+func TestSmallRibbonFilter(t *testing.T) {
+	f := newTableFilter(7, []byte("hello"), []byte("world"))
+	// One block of 7 words and the trailer.
+	require.Equal(t, 2*7*8+trailerLen, len(f))
+
+	m := map[string]bool{
+		"hello": true,
+		"world": true,
+		"x":     false,
+		"foo":   false,
+	}
+	for k, want := range m {
+		require.EqualValues(t, want, f.MayContain([]byte(k)), k)
+	}
+
+	require.False(t, newTableFilter(7).MayContain([]byte("hello")))
+	require.False(t, tableFilter(nil).MayContain([]byte("hello")))
+}
+
+func TestRibbonFilter(t *testing.T) {
+	for _, length := range []int{1, 2, 5, 10, 50, 100, 500, 1000, 5000, 10000, 50000} {
+		for _, resultBits := range []int{1, 4, 7, 10, 16} {
+			t.Run(fmt.Sprintf("length=%d/bits=%d", length, resultBits), func(t *testing.T) {
+				keys := make([][]byte, 0, length)
+				for i := 0; i < length; i++ {
+					keys = append(keys, le32(i))
+				}
+				f := newTableFilter(resultBits, keys...)
+
+				// All added keys must match.
+				for _, key := range keys {
+					require.True(t, f.MayContain(key), "did not contain key %q", key)
+				}
+
+				// The filter must be within ~15% of the ideal space, plus a
+				// couple of blocks of rounding.
+				maxLen := trailerLen + (length*resultBits*115/100)/8 + 2*ribbonWidth*resultBits/8
+				require.LessOrEqual(t, len(f), maxLen)
+
+				// Check the false positive rate.
+				if length >= 1000 {
+					expected := 1 / float64(uint(1)<<resultBits)
+					fpr := falsePositiveRate(FilterPolicy(resultBits), f)
+					require.Less(t, fpr, 1.5*expected+0.0005)
+				}
+			})
+		}
+	}
+}
+
+func TestRibbonFilterDuplicateKeys(t *testing.T) {
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, le32(i%100), le32(i%100))
+	}
+	f := newTableFilter(7, keys...)
+	for i := 0; i < 100; i++ {
+		require.True(t, f.MayContain(le32(i)))
+	}
+}
+
+// TestRibbonFilterSpace checks that a ribbon filter uses substantially less
+// space than a Bloom filter with a similar false positive rate.
+func TestRibbonFilterSpace(t *testing.T) {
+	const n = 100000
+	bw := bloom.FilterPolicy(10).NewWriter(base.TableFilter)
+	rw := FilterPolicy(7).NewWriter(base.TableFilter)
+	for i := 0; i < n; i++ {
+		bw.AddKey(le32(i))
+		rw.AddKey(le32(i))
+	}
+	bf, rf := bw.Finish(nil), rw.Finish(nil)
+	bloomFPR := falsePositiveRate(bloom.FilterPolicy(10), bf)
+	ribbonFPR := falsePositiveRate(FilterPolicy(7), rf)
+	t.Logf("bloom: %.2f bits/key, fpr %.4f; ribbon: %.2f bits/key, fpr %.4f",
+		float64(8*len(bf))/n, bloomFPR, float64(8*len(rf))/n, ribbonFPR)
+	require.Less(t, ribbonFPR, bloomFPR)
+	require.Less(t, float64(len(rf)), 0.8*float64(len(bf)))
+}
+
+func BenchmarkRibbonFilter(b *testing.B) {
+	const n = 100000
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = le32(i)
+	}
+	b.Run("build", func(b *testing.B) {
+		w := FilterPolicy(7).NewWriter(base.TableFilter)
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				w.AddKey(key)
+			}
+			w.Finish(nil)
+		}
+	})
+	b.Run("query", func(b *testing.B) {
+		f := newTableFilter(7, keys...)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			f.MayContain(keys[i%n])
+		}
+	})
+}
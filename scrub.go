@@ -0,0 +1,286 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/private"
+	"github.com/cockroachdb/pebble/internal/rate"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// ScrubReport describes the result of a scrub (see DB.Scrub).
+type ScrubReport struct {
+	// NumTables is the number of sstables that were scrubbed.
+	NumTables int
+	// ScrubbedBytes is the number of bytes of sstables and replicas whose
+	// block checksums were validated.
+	ScrubbedBytes uint64
+	// ReplicasCreated is the number of local sstables without a replica whose
+	// replica was uploaded.
+	ReplicasCreated int
+	// OrphanedReplicasDeleted is the number of replicas of removed objects
+	// whose deletion from shared storage had failed, and was retried
+	// successfully.
+	OrphanedReplicasDeleted int
+	// Tables lists the corrupt sstables and replicas that were found, as
+	// reported to EventListener.TableScrubbed.
+	Tables []TableScrubbedInfo
+}
+
+// Scrub validates the block checksums of every sstable of the current version,
+// reading at most Options.Experimental.ScrubBytesPerSec bytes per second. With
+// Options.Experimental.ReplicateLocalTables, it also validates the replicas of
+// the local sstables on shared storage, and:
+//   - uploads a replica of the local sstables which have none;
+//   - restores a corrupt local sstable from its healthy replica;
+//   - uploads again the corrupt replica of a healthy local sstable.
+//
+// The sstables without a replica are scrubbed first, in increasing size order.
+// Every corrupt sstable or replica is reported to EventListener.TableScrubbed.
+// Scrubs also run in the background if Options.Experimental.ScrubInterval is
+// set; only one scrub runs at a time. Scrubs also retry deleting the replicas
+// of removed objects which could not be deleted from shared storage (see
+// Metrics.OrphanedReplicas). The returned error is only set if the scrub
+// could not be performed.
+func (d *DB) Scrub() (ScrubReport, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly {
+		return ScrubReport{}, ErrReadOnly
+	}
+	return d.scrub()
+}
+
+func (d *DB) scrub() (ScrubReport, error) {
+	d.mu.Lock()
+	for d.mu.scrub.scrubbing {
+		d.mu.scrub.cond.Wait()
+	}
+	d.mu.scrub.scrubbing = true
+	jobID := d.mu.nextJobID
+	d.mu.nextJobID++
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.mu.scrub.scrubbing = false
+		d.mu.scrub.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+
+	s := &scrubber{d: d, jobID: jobID}
+	if d.objProvider.CanReplicate() {
+		s.report.OrphanedReplicasDeleted, _ = d.objProvider.DeleteOrphanedReplicas()
+	}
+	if d.opts.Experimental.ReplicateLocalTables && d.objProvider.CanReplicate() {
+		var err error
+		if s.replicas, err = d.objProvider.ListReplicas(fileTypeTable); err != nil {
+			return ScrubReport{}, err
+		}
+	}
+	if n := d.opts.Experimental.ScrubBytesPerSec; n > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(n), int(n))
+	}
+
+	// List the tables up front, and only reference the current version while
+	// scrubbing each of them, so that a long scrub does not prevent the
+	// deletion of obsolete tables.
+	type levelFile struct {
+		level int
+		meta  *fileMetadata
+	}
+	var files []levelFile
+	rs := d.loadReadState()
+	for level := range rs.current.Levels {
+		iter := rs.current.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			files = append(files, levelFile{level: level, meta: f})
+		}
+	}
+	rs.unref()
+	if s.replicas != nil {
+		// Scrub the tables without a replica first, smallest first, so that
+		// the tables which are cheapest to replicate (e.g. recently flushed
+		// tables) get a replica before the largest compaction outputs when the
+		// bandwidth to shared storage is limited.
+		sort.SliceStable(files, func(i, j int) bool {
+			_, iReplicated := s.replicas[files[i].meta.FileNum]
+			_, jReplicated := s.replicas[files[j].meta.FileNum]
+			if iReplicated || jReplicated {
+				return !iReplicated && jReplicated
+			}
+			return files[i].meta.Size < files[j].meta.Size
+		})
+	}
+
+	for _, lf := range files {
+		if d.closed.Load() != nil {
+			return s.report, ErrClosed
+		}
+		rs := d.loadReadState()
+		// The table may have been moved to a lower level or deleted since it
+		// was listed.
+		for level := lf.level; level < numLevels; level++ {
+			if rs.current.Contains(level, d.cmp, lf.meta) {
+				s.scrubTable(level, lf.meta)
+				break
+			}
+		}
+		rs.unref()
+	}
+	return s.report, nil
+}
+
+// scrubBackground runs scrubs every Options.Experimental.ScrubInterval, until
+// the DB is closed.
+func (d *DB) scrubBackground() {
+	defer func() {
+		d.mu.Lock()
+		d.mu.scrub.background = false
+		d.mu.scrub.cond.Broadcast()
+		d.mu.Unlock()
+	}()
+	timer := time.NewTimer(d.opts.Experimental.ScrubInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.closedCh:
+			return
+		case <-timer.C:
+		}
+		if _, err := d.scrub(); err != nil && !errors.Is(err, ErrClosed) {
+			d.opts.EventListener.BackgroundError(err)
+		}
+		timer.Reset(d.opts.Experimental.ScrubInterval)
+	}
+}
+
+// scrubber holds the state of a scrub.
+type scrubber struct {
+	d     *DB
+	jobID int
+	// limiter paces the reads of the sstables and replicas; nil if unlimited.
+	limiter *rate.Limiter
+	// replicas holds the file numbers of the sstables with a replica; nil if
+	// local sstables are not replicated.
+	replicas map[FileNum]struct{}
+	report   ScrubReport
+}
+
+func (s *scrubber) scrubTable(level int, f *fileMetadata) {
+	d := s.d
+	ctx := context.Background()
+	s.report.NumTables++
+	info := TableScrubbedInfo{
+		JobID:   s.jobID,
+		Level:   level,
+		FileNum: f.FileNum,
+		Action:  ScrubUnrecoverable,
+	}
+	meta, err := d.objProvider.Lookup(fileTypeTable, f.FileNum)
+	if err != nil {
+		info.LocalErr = err
+		s.reportTable(info)
+		return
+	}
+	info.LocalErr = s.validate(f, func() (objstorage.Readable, error) {
+		return d.objProvider.OpenForReading(ctx, fileTypeTable, f.FileNum, objstorage.OpenOptions{
+			NoReplicaFallback: true,
+		})
+	})
+	if meta.IsShared() || s.replicas == nil {
+		if info.LocalErr != nil {
+			s.reportTable(info)
+		}
+		return
+	}
+
+	if _, ok := s.replicas[f.FileNum]; !ok {
+		if info.LocalErr != nil {
+			info.ReplicaErr = errors.New("pebble: table has no replica")
+			s.reportTable(info)
+			return
+		}
+		if d.replicaUploader != nil && d.replicaUploader.isPending(f.FileNum) {
+			// The replica is being uploaded in the background.
+			return
+		}
+		if err := d.objProvider.UploadReplica(ctx, meta); err != nil {
+			d.opts.Logger.Infof("pebble: unable to upload replica of table %s: %v", f.FileNum, err)
+			return
+		}
+		s.report.ReplicasCreated++
+		return
+	}
+	info.ReplicaErr = s.validate(f, func() (objstorage.Readable, error) {
+		return d.objProvider.OpenReplicaForReading(ctx, meta)
+	})
+	if errors.Is(info.ReplicaErr, shared.ErrDryRun) {
+		// The replicas uploaded to a dry-run storage cannot be validated.
+		info.ReplicaErr = nil
+	}
+	switch {
+	case info.LocalErr == nil && info.ReplicaErr == nil:
+		return
+	case info.LocalErr == nil:
+		info.Action = ScrubReplicaUploaded
+		info.Err = d.objProvider.UploadReplica(ctx, meta)
+	case info.ReplicaErr == nil:
+		info.Action = ScrubLocalRestored
+		info.Err = d.objProvider.RestoreFromReplica(ctx, meta)
+		if info.Err == nil {
+			// The cached reader of the table reads the corrupt file, which
+			// remains open until the reader is closed.
+			d.tableCache.reopen(f.FileNum)
+		}
+	}
+	s.reportTable(info)
+}
+
+func (s *scrubber) reportTable(info TableScrubbedInfo) {
+	s.report.Tables = append(s.report.Tables, info)
+	s.d.opts.EventListener.TableScrubbed(info)
+}
+
+// validate checks the size and the block checksums of an object holding the
+// contents of an sstable, i.e. the sstable itself or its replica.
+func (s *scrubber) validate(f *fileMetadata, open func() (objstorage.Readable, error)) error {
+	readable, err := open()
+	if err != nil {
+		return err
+	}
+	if size := readable.Size(); size != int64(f.BackingSize()) {
+		_ = readable.Close()
+		return errors.Errorf("pebble: object size mismatch: %d != %d (MANIFEST)",
+			errors.Safe(size), errors.Safe(f.BackingSize()))
+	}
+	paceChecksums(s.limiter, f.BackingSize())
+
+	// Use a new cache ID, so that the blocks are read from the object rather
+	// than from the block cache.
+	c := s.d.opts.Cache
+	cacheID := c.NewID()
+	defer func() {
+		c.EvictFile(cacheID, f.FileNum)
+		c.ReleaseID(cacheID)
+	}()
+	cacheOpts := private.SSTableCacheOpts(cacheID, f.FileNum).(sstable.ReaderOption)
+	r, err := sstable.NewReader(readable, s.d.opts.MakeReaderOptions(), cacheOpts)
+	if err != nil {
+		return err
+	}
+	err = r.ValidateBlockChecksums()
+	if err == nil {
+		s.report.ScrubbedBytes += f.BackingSize()
+	}
+	return firstError(err, r.Close())
+}
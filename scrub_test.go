@@ -0,0 +1,412 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrub(t *testing.T) {
+	mem := vfs.NewMem()
+	storage := shared.NewInMem()
+	var events []TableScrubbedInfo
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		EventListener: &EventListener{
+			TableScrubbed: func(info TableScrubbedInfo) {
+				events = append(events, info)
+			},
+		},
+	}
+	opts.Experimental.SharedStorage = storage
+	opts.Experimental.ReplicateLocalTables = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	for gen := 0; gen < 2; gen++ {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprint(gen)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 2)
+	fileNum := tables[0][0].FileNum
+
+	// The first scrub uploads the missing replicas.
+	report, err := d.Scrub()
+	require.NoError(t, err)
+	require.Equal(t, 2, report.NumTables)
+	require.Equal(t, 2, report.ReplicasCreated)
+	require.Empty(t, report.Tables)
+	report, err = d.Scrub()
+	require.NoError(t, err)
+	require.Zero(t, report.ReplicasCreated)
+	require.Empty(t, report.Tables)
+	require.Equal(t, 2*(tables[0][0].Size+tables[0][1].Size), report.ScrubbedBytes)
+
+	localName := base.MakeFilename(fileTypeTable, fileNum)
+	replicaName := objstorage.CreatorID(1).String() + "/sst/" + localName
+	readLocal := func() []byte {
+		f, err := mem.Open(localName)
+		require.NoError(t, err)
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		require.NoError(t, err)
+		return data
+	}
+	writeLocal := func(data []byte) {
+		require.NoError(t, mem.Remove(localName))
+		f, err := mem.Create(localName)
+		require.NoError(t, err)
+		_, err = f.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	readReplica := func() []byte {
+		rc, _, err := storage.ReadObjectAt(replicaName, 0)
+		require.NoError(t, err)
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		return data
+	}
+	writeReplica := func(data []byte) {
+		w, err := storage.CreateObject(replicaName)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+	corrupt := func(data []byte) []byte {
+		data = append([]byte(nil), data...)
+		data[10] ^= 0xff
+		return data
+	}
+	checkData := func() {
+		iter := d.NewIter(nil)
+		var n int
+		for iter.First(); iter.Valid(); iter.Next() {
+			require.Equal(t, []byte("1"), iter.Value())
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 100, n)
+	}
+	scrubOne := func(action ScrubAction) TableScrubbedInfo {
+		events = nil
+		report, err := d.Scrub()
+		require.NoError(t, err)
+		require.Len(t, report.Tables, 1)
+		require.Equal(t, report.Tables, events)
+		info := report.Tables[0]
+		require.Equal(t, fileNum, info.FileNum)
+		require.Equal(t, action, info.Action, "%s", info)
+		return info
+	}
+	healthy := readLocal()
+	require.Equal(t, healthy, readReplica())
+
+	// A corrupt local table is restored from its replica.
+	writeLocal(corrupt(healthy))
+	info := scrubOne(ScrubLocalRestored)
+	require.Error(t, info.LocalErr)
+	require.NoError(t, info.ReplicaErr)
+	require.NoError(t, info.Err)
+	require.Equal(t, healthy, readLocal())
+	checkData()
+
+	// A corrupt replica is uploaded again.
+	writeReplica(corrupt(healthy))
+	info = scrubOne(ScrubReplicaUploaded)
+	require.NoError(t, info.LocalErr)
+	require.Error(t, info.ReplicaErr)
+	require.NoError(t, info.Err)
+	require.Equal(t, healthy, readReplica())
+
+	// A table whose replica is also corrupt cannot be repaired.
+	writeLocal(corrupt(healthy))
+	writeReplica(healthy[:len(healthy)-1])
+	info = scrubOne(ScrubUnrecoverable)
+	require.Error(t, info.LocalErr)
+	require.Contains(t, info.ReplicaErr.Error(), "size mismatch")
+	require.Contains(t, info.String(), "unrecoverable")
+	writeLocal(healthy)
+	writeReplica(healthy)
+
+	// The replicas are removed along with their tables.
+	require.NoError(t, d.Compact([]byte("0000"), []byte("0100"), false /* parallelize */))
+	ls, err := storage.List("", "")
+	require.NoError(t, err)
+	require.Empty(t, ls)
+	checkData()
+}
+
+func TestScrubBackground(t *testing.T) {
+	storage := shared.NewInMem()
+	opts := &Options{FS: vfs.NewMem()}
+	opts.Experimental.SharedStorage = storage
+	opts.Experimental.ReplicateLocalTables = true
+	opts.Experimental.ScrubInterval = time.Millisecond
+	opts.EventListener = &EventListener{
+		BackgroundError: func(err error) { t.Error(err) },
+	}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.Set([]byte("a"), []byte("b"), nil))
+	require.NoError(t, d.Flush())
+	// Tables are only replicated once the creator ID is set.
+	time.Sleep(10 * time.Millisecond)
+	ls, err := storage.List("", "")
+	require.NoError(t, err)
+	require.Empty(t, ls)
+	require.NoError(t, d.SetCreatorID(1))
+
+	require.Eventually(t, func() bool {
+		ls, err := storage.List("", "")
+		require.NoError(t, err)
+		return len(ls) == 1
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, d.Close())
+}
+
+// recordingStorage records the names of the objects created on a
+// shared.Storage.
+type recordingStorage struct {
+	shared.Storage
+	created []string
+}
+
+func (s *recordingStorage) CreateObject(objName string) (io.WriteCloser, error) {
+	s.created = append(s.created, objName)
+	return s.Storage.CreateObject(objName)
+}
+
+func TestScrubReplicatesSmallTablesFirst(t *testing.T) {
+	storage := &recordingStorage{Storage: shared.NewInMem()}
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.Experimental.SharedStorage = storage
+	opts.Experimental.ReplicateLocalTables = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	// A large table in L6, followed by two L0 tables, the last one smaller.
+	for _, n := range []int{1000, 100, 10} {
+		for i := 0; i < n; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%d-%04d", n, i)), []byte("value"), nil))
+		}
+		require.NoError(t, d.Flush())
+		if n == 1000 {
+			require.NoError(t, d.Compact([]byte("0"), []byte("9"), false /* parallelize */))
+		}
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 2)
+	require.Len(t, tables[6], 1)
+	var want []string
+	for _, info := range []SSTableInfo{tables[0][0], tables[0][1], tables[6][0]} {
+		want = append(want, objstorage.CreatorID(1).String()+"/sst/"+base.MakeFilename(fileTypeTable, info.FileNum))
+	}
+	if tables[0][0].Size > tables[0][1].Size {
+		want[0], want[1] = want[1], want[0]
+	}
+
+	report, err := d.Scrub()
+	require.NoError(t, err)
+	require.Equal(t, 3, report.ReplicasCreated)
+	require.Equal(t, want, storage.created)
+}
+
+func TestScrubDryRun(t *testing.T) {
+	storage := shared.NewDryRun(nil /* logf */)
+	opts := &Options{FS: vfs.NewMem(), DisableAutomaticCompactions: true}
+	opts.Experimental.SharedStorage = storage
+	opts.Experimental.ReplicateLocalTables = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.SetCreatorID(1))
+
+	for _, k := range []string{"a", "b"} {
+		require.NoError(t, d.Set([]byte(k), []byte(k), nil))
+		require.NoError(t, d.Flush())
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	size := int64(tables[0][0].Size + tables[0][1].Size)
+
+	report, err := d.Scrub()
+	require.NoError(t, err)
+	require.Equal(t, 2, report.ReplicasCreated)
+	require.Equal(t, shared.DryRunStats{
+		Uploads:       2,
+		UploadedBytes: size,
+		Objects:       2,
+		ObjectBytes:   size,
+	}, storage.Stats())
+
+	// The replicas are not uploaded again, even though they cannot be read.
+	report, err = d.Scrub()
+	require.NoError(t, err)
+	require.Zero(t, report.ReplicasCreated)
+	require.Empty(t, report.Tables)
+	require.Equal(t, int64(2), storage.Stats().Uploads)
+
+	// The replicas are deleted along with their tables.
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.Equal(t, shared.DryRunStats{
+		Uploads:       2,
+		UploadedBytes: size,
+		Deletes:       2,
+		DeletedBytes:  size,
+	}, storage.Stats())
+}
+
+func TestVerifyTablesOnOpen(t *testing.T) {
+	mem := vfs.NewMem()
+	storage := shared.NewInMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+	opts.Experimental.SharedStorage = storage
+	opts.Experimental.ReplicateLocalTables = true
+	opts.Experimental.VerifyTablesOnOpen = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.SetCreatorID(1))
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Set([]byte("b"), []byte("2"), nil))
+	require.NoError(t, d.Flush())
+	report, err := d.Scrub()
+	require.NoError(t, err)
+	require.Equal(t, 2, report.ReplicasCreated)
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 2)
+	require.NoError(t, d.Close())
+
+	// A missing local table is restored from its replica.
+	fileNum := tables[0][0].FileNum
+	localName := base.MakeFilename(fileTypeTable, fileNum)
+	require.NoError(t, mem.Remove(localName))
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "b"} {
+		_, closer, err := d.Get([]byte(k))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	}
+	require.NoError(t, d.Close())
+
+	// Tables missing both locally and on shared storage are all reported.
+	for _, f := range tables[0] {
+		localName := base.MakeFilename(fileTypeTable, f.FileNum)
+		require.NoError(t, mem.Remove(localName))
+		require.NoError(t, storage.Delete(objstorage.CreatorID(1).String()+"/sst/"+localName))
+	}
+	_, err = Open("", opts)
+	var missingErr *MissingTablesError
+	require.ErrorAs(t, err, &missingErr)
+	require.Len(t, missingErr.Tables, 2)
+	for i, f := range missingErr.Tables {
+		require.Equal(t, 0, f.Level)
+		require.Equal(t, tables[0][i].FileNum, f.FileNum)
+	}
+}
+
+func TestTableRepairedFromReplica(t *testing.T) {
+	mem := vfs.NewMem()
+	var events []TableRepairInfo
+	opts := &Options{
+		FS:                          mem,
+		DisableAutomaticCompactions: true,
+		EventListener: &EventListener{
+			TableRepaired: func(info TableRepairInfo) {
+				events = append(events, info)
+			},
+		},
+	}
+	opts.Experimental.SharedStorage = shared.NewInMem()
+	opts.Experimental.ReplicateLocalTables = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.SetCreatorID(1))
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), []byte("v"), nil))
+	}
+	require.NoError(t, d.Flush())
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 1)
+	fileNum := tables[0][0].FileNum
+	// Upload the replica.
+	report, err := d.Scrub()
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ReplicasCreated)
+	require.NoError(t, d.Close())
+
+	checkData := func() {
+		d, err := Open("", opts)
+		require.NoError(t, err)
+		iter := d.NewIter(nil)
+		var n int
+		for iter.First(); iter.Valid(); iter.Next() {
+			n++
+		}
+		require.NoError(t, iter.Close())
+		require.Equal(t, 100, n)
+		require.NoError(t, d.Close())
+	}
+
+	// A block of the local table which fails checksum validation is read
+	// from the replica.
+	localName := base.MakeFilename(fileTypeTable, fileNum)
+	f, err := mem.Open(localName)
+	require.NoError(t, err)
+	data, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	data[10] ^= 0xff
+	require.NoError(t, mem.Remove(localName))
+	f, err = mem.Create(localName)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	checkData()
+	require.NotEmpty(t, events)
+	require.Equal(t, fileNum, events[0].FileNum)
+	require.Contains(t, events[0].Err.Error(), "checksum mismatch")
+
+	// A local table which goes missing while the DB is open is read from its
+	// replica.
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	events = nil
+	require.NoError(t, mem.Remove(localName))
+	d.tableCache.reopen(fileNum)
+	_, closer, err := d.Get([]byte("0050"))
+	require.NoError(t, err)
+	require.NoError(t, closer.Close())
+	require.NotEmpty(t, events)
+	require.Equal(t, fileNum, events[0].FileNum)
+	require.True(t, objstorage.IsNotExistError(events[0].Err), "%v", events[0].Err)
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"io"
 	"math"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/keyspan"
 )
@@ -17,6 +18,11 @@ type Snapshot struct {
 	// The db the snapshot was created from.
 	db     *DB
 	seqNum uint64
+	// createdAt is the time the snapshot was created.
+	createdAt time.Time
+	// pinnedBytes is the number of bytes of the keys retained by flushes and
+	// compactions only because of the snapshot. It is protected by DB.mu.
+	pinnedBytes uint64
 
 	// The list the snapshot is linked into.
 	list *snapshotList
@@ -103,6 +109,106 @@ func (s *Snapshot) Close() error {
 	return nil
 }
 
+// SnapshotInfo describes an open snapshot.
+type SnapshotInfo struct {
+	// SeqNum is the sequence number of the snapshot.
+	SeqNum uint64
+	// CreatedAt is the time the snapshot was created.
+	CreatedAt time.Time
+	// PinnedBytes is the number of bytes of the keys that flushes and
+	// compactions retained only because the snapshot can read them, since the
+	// snapshot was created: each of these keys is shadowed by a newer key
+	// which the snapshot cannot read. Keys are counted each time a flush or
+	// compaction rewrites them, so PinnedBytes keeps growing while the snapshot
+	// stays open and the keys it pins are compacted, and measures the work
+	// and space the snapshot costs rather than the bytes the DB would reclaim
+	// if it were closed.
+	PinnedBytes uint64
+}
+
+// Snapshots returns the open snapshots of the DB, from the oldest to the
+// newest.
+func (d *DB) Snapshots() []SnapshotInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var infos []SnapshotInfo
+	for s := d.mu.snapshots.root.next; s != &d.mu.snapshots.root; s = s.next {
+		infos = append(infos, s.info())
+	}
+	return infos
+}
+
+func (s *Snapshot) info() SnapshotInfo {
+	return SnapshotInfo{SeqNum: s.seqNum, CreatedAt: s.createdAt, PinnedBytes: s.pinnedBytes}
+}
+
+// snapshotPinnedTracker accumulates the bytes of the point keys written by a
+// flush or compaction which are only retained because of open snapshots. A
+// key is pinned when the previous key written has the same user key: the
+// newer key shadows it, so it is only retained because it is the newest key
+// of a snapshot stripe older than the stripe of the newer key. The key is
+// attributed to the oldest snapshot which can read it.
+type snapshotPinnedTracker struct {
+	equal     Equal
+	snapshots []uint64
+	// bytes holds the pinned bytes of each of the snapshots.
+	bytes   []uint64
+	prevKey []byte
+}
+
+func makeSnapshotPinnedTracker(equal Equal, snapshots []uint64) snapshotPinnedTracker {
+	return snapshotPinnedTracker{equal: equal, snapshots: snapshots}
+}
+
+// add records a point key written by the flush or compaction.
+func (t *snapshotPinnedTracker) add(key *InternalKey, value []byte) {
+	if len(t.snapshots) == 0 {
+		return
+	}
+	if t.prevKey != nil && t.equal(t.prevKey, key.UserKey) {
+		if i, _ := snapshotIndex(key.SeqNum(), t.snapshots); i < len(t.snapshots) {
+			if t.bytes == nil {
+				t.bytes = make([]uint64, len(t.snapshots))
+			}
+			t.bytes[i] += uint64(key.Size() + len(value))
+		}
+		return
+	}
+	t.prevKey = append(t.prevKey[:0], key.UserKey...)
+}
+
+// addSnapshotPinnedBytes credits the snapshots of the list which are still open with
+// the bytes the given tracker recorded, and invokes the
+// SnapshotPinnedBudgetExceeded event for the snapshots which exceed the
+// budget as a result. d.mu must be held.
+func (d *DB) addSnapshotPinnedBytes(jobID int, t *snapshotPinnedTracker) {
+	if t.bytes == nil {
+		return
+	}
+	budget := d.opts.SnapshotPinnedBytesBudget
+	i := 0
+	for s := d.mu.snapshots.root.next; s != &d.mu.snapshots.root; s = s.next {
+		for i < len(t.snapshots) && t.snapshots[i] < s.seqNum {
+			i++
+		}
+		if i == len(t.snapshots) {
+			break
+		}
+		if t.snapshots[i] != s.seqNum || t.bytes[i] == 0 {
+			continue
+		}
+		prev := s.pinnedBytes
+		s.pinnedBytes += t.bytes[i]
+		if budget > 0 && prev <= budget && s.pinnedBytes > budget {
+			d.opts.EventListener.SnapshotPinnedBudgetExceeded(SnapshotPinnedInfo{
+				JobID:    jobID,
+				Snapshot: s.info(),
+				Budget:   budget,
+			})
+		}
+	}
+}
+
 type snapshotList struct {
 	root Snapshot
 }
@@ -348,3 +348,56 @@ func TestNewSnapshotRace(t *testing.T) {
 	wg.Wait()
 	require.NoError(t, d.Close())
 }
+
+func TestSnapshotPinnedBytes(t *testing.T) {
+	var exceeded []SnapshotPinnedInfo
+	d, err := Open("", &Options{
+		FS:                        vfs.NewMem(),
+		SnapshotPinnedBytesBudget: 100,
+		EventListener: &EventListener{
+			SnapshotPinnedBudgetExceeded: func(info SnapshotPinnedInfo) {
+				exceeded = append(exceeded, info)
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Write b to L6, so that compacting the table flushed below into it is
+	// not a move.
+	value := bytes.Repeat([]byte("v"), 64)
+	require.NoError(t, d.Set([]byte("b"), value, nil))
+	require.NoError(t, d.Flush())
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+
+	require.NoError(t, d.Set([]byte("a"), value, nil))
+	require.NoError(t, d.Set([]byte("b"), value, nil))
+	s1 := d.NewSnapshot()
+	defer s1.Close()
+	require.NoError(t, d.Set([]byte("a"), value, nil))
+	s2 := d.NewSnapshot()
+	defer s2.Close()
+	require.NoError(t, d.Flush())
+
+	// The older value of a is only retained because of s1, which is the only
+	// snapshot that can read it. b is not shadowed.
+	snapshots := d.Snapshots()
+	require.Len(t, snapshots, 2)
+	require.Equal(t, s1.seqNum, snapshots[0].SeqNum)
+	require.Equal(t, uint64(1+8+64), snapshots[0].PinnedBytes)
+	require.Equal(t, uint64(0), snapshots[1].PinnedBytes)
+	require.False(t, snapshots[0].CreatedAt.After(snapshots[1].CreatedAt))
+	require.Empty(t, exceeded)
+
+	// Compacting the flushed table counts the pinned key again, which exceeds
+	// the budget.
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	snapshots = d.Snapshots()
+	require.Equal(t, uint64(2*(1+8+64)), snapshots[0].PinnedBytes)
+	require.Len(t, exceeded, 1)
+	require.Equal(t, s1.seqNum, exceeded[0].Snapshot.SeqNum)
+	require.Equal(t, uint64(100), exceeded[0].Budget)
+
+	require.NoError(t, d.Compact([]byte("a"), []byte("c"), false /* parallelize */))
+	require.Len(t, exceeded, 1)
+}
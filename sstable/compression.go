@@ -33,14 +33,16 @@ func decompressedLen(blockType blockType, b []byte) (int, int, error) {
 	}
 }
 
-func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte, error) {
+// decompressInto decompresses compressed into buf. The dict is the table's
+// compression dictionary, if any, and is only used by Zstandard blocks.
+func decompressInto(blockType blockType, compressed []byte, buf []byte, dict []byte) ([]byte, error) {
 	var result []byte
 	var err error
 	switch blockType {
 	case snappyCompressionBlockType:
 		result, err = snappy.Decode(buf, compressed)
 	case zstdCompressionBlockType:
-		result, err = decodeZstd(buf, compressed)
+		result, err = decodeZstd(buf, compressed, dict)
 	}
 	if err != nil {
 		return nil, base.MarkCorruptionError(err)
@@ -53,7 +55,9 @@ func decompressInto(blockType blockType, compressed []byte, buf []byte) ([]byte,
 }
 
 // decompressBlock decompresses an SST block, with space allocated from a cache.
-func decompressBlock(cache *cache.Cache, blockType blockType, b []byte) (*cache.Value, error) {
+func decompressBlock(
+	cache *cache.Cache, blockType blockType, b []byte, dict []byte,
+) (*cache.Value, error) {
 	if blockType == noCompressionBlockType {
 		return nil, nil
 	}
@@ -68,15 +72,17 @@ func decompressBlock(cache *cache.Cache, blockType blockType, b []byte) (*cache.
 	// Allocate sufficient space from the cache.
 	decoded := cache.Alloc(decodedLen)
 	decodedBuf := decoded.Buf()
-	if _, err := decompressInto(blockType, b, decodedBuf); err != nil {
+	if _, err := decompressInto(blockType, b, decodedBuf, dict); err != nil {
 		cache.Free(decoded)
+		return nil, err
 	}
 	return decoded, nil
 }
 
-// compressBlock compresses an SST block, using compressBuf as the desired destination.
+// compressBlock compresses an SST block, using compressBuf as the desired
+// destination. The dict is only used by ZstdCompression, and may be nil.
 func compressBlock(
-	compression Compression, b []byte, compressedBuf []byte,
+	compression Compression, b []byte, compressedBuf []byte, dict []byte,
 ) (blockType blockType, compressed []byte) {
 	switch compression {
 	case SnappyCompression:
@@ -91,7 +97,7 @@ func compressBlock(
 	varIntLen := binary.PutUvarint(compressedBuf, uint64(len(b)))
 	switch compression {
 	case ZstdCompression:
-		return zstdCompressionBlockType, encodeZstd(compressedBuf, varIntLen, b)
+		return zstdCompressionBlockType, encodeZstd(compressedBuf, varIntLen, b, dict)
 	default:
 		return noCompressionBlockType, b
 	}
@@ -9,25 +9,53 @@ package sstable
 
 import (
 	"bytes"
+	"io"
 
 	"github.com/DataDog/zstd"
+	"github.com/cockroachdb/errors"
 )
 
-// decodeZstd decompresses b with the Zstandard algorithm.
+// zstdDictSupported is true if the Zstandard implementation supports
+// compression dictionaries.
+const zstdDictSupported = true
+
+// decodeZstd decompresses b with the Zstandard algorithm, using dict as the
+// compression dictionary if it is non-empty.
 // It reuses the preallocated capacity of decodedBuf if it is sufficient.
 // On success, it returns the decoded byte slice.
-func decodeZstd(decodedBuf, b []byte) ([]byte, error) {
-	return zstd.Decompress(decodedBuf, b)
+func decodeZstd(decodedBuf, b, dict []byte) ([]byte, error) {
+	if len(dict) == 0 {
+		return zstd.Decompress(decodedBuf, b)
+	}
+	// The caller always sizes decodedBuf to the exact decompressed length, so
+	// the stream must fill it completely and then end.
+	reader := zstd.NewReaderDict(bytes.NewReader(b), dict)
+	defer reader.Close()
+	n, err := io.ReadFull(reader, decodedBuf)
+	if err != nil {
+		return nil, err
+	}
+	var extra [1]byte
+	if m, _ := reader.Read(extra[:]); m != 0 {
+		return nil, errors.New("zstd: decompressed data exceeds the expected length")
+	}
+	return decodedBuf[:n], nil
 }
 
 // encodeZstd compresses b with the Zstandard algorithm at default compression
-// level (level 3). It reuses the preallocated capacity of compressedBuf if it
-// is sufficient. The subslice `compressedBuf[:varIntLen]` should already encode
+// level (level 3), using dict as the compression dictionary if it is
+// non-empty. It reuses the preallocated capacity of compressedBuf if it is
+// sufficient. The subslice `compressedBuf[:varIntLen]` should already encode
 // the length of `b` before calling encodeZstd. It returns the encoded byte
 // slice, including the `compressedBuf[:varIntLen]` prefix.
-func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
+func encodeZstd(compressedBuf []byte, varIntLen int, b, dict []byte) []byte {
 	buf := bytes.NewBuffer(compressedBuf[:varIntLen])
-	writer := zstd.NewWriterLevel(buf, 3)
+	var writer *zstd.Writer
+	if len(dict) == 0 {
+		writer = zstd.NewWriterLevel(buf, 3)
+	} else {
+		writer = zstd.NewWriterLevelDict(buf, 3, dict)
+	}
 	writer.Write(b)
 	writer.Close()
 	return buf.Bytes()
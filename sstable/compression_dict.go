@@ -0,0 +1,134 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// dictGramLen is the length of the substrings whose frequency is measured
+	// by TrainCompressionDict. It matches the minimum match length Zstandard
+	// looks for in the dictionary.
+	dictGramLen = 8
+	// dictSegmentLen is the length of the segments TrainCompressionDict
+	// assembles the dictionary from.
+	dictSegmentLen = 256
+)
+
+// TrainCompressionDict builds a raw content Zstandard compression dictionary
+// of at most maxSize bytes from the given samples, typically the keys and
+// values of a set of representative records. Samples should amount to many
+// times maxSize bytes for the dictionary to be effective.
+//
+// The dictionary is assembled from the segments of the samples that contain
+// the most substrings common to many samples, following the cover algorithm of
+// the Zstandard dictionary builder: the concatenated samples are divided into
+// as many epochs as the dictionary has segments, and the best segment of each
+// epoch is selected, after which its substrings no longer contribute to the
+// score of other segments. The segments are ordered by increasing score, as
+// content at the end of the dictionary is cheaper to reference. If the
+// samples do not exceed maxSize bytes, they are used as the dictionary.
+func TrainCompressionDict(samples [][]byte, maxSize int) []byte {
+	var total int
+	for _, s := range samples {
+		total += len(s)
+	}
+	if total <= maxSize {
+		dict := make([]byte, 0, total)
+		for _, s := range samples {
+			dict = append(dict, s...)
+		}
+		return dict
+	}
+	if maxSize <= 0 {
+		return nil
+	}
+
+	// Measure the number of samples each substring appears in. Substrings
+	// spanning samples are not taken into account.
+	data := make([]byte, 0, total)
+	freqs := make(map[uint64]uint32)
+	lastSample := make(map[uint64]int)
+	for i, s := range samples {
+		for j := 0; j+dictGramLen <= len(s); j++ {
+			g := binary.LittleEndian.Uint64(s[j:])
+			if last, ok := lastSample[g]; !ok || last != i {
+				lastSample[g] = i
+				freqs[g]++
+			}
+		}
+		data = append(data, s...)
+	}
+	lastSample = nil
+
+	segmentLen := dictSegmentLen
+	if segmentLen > maxSize {
+		segmentLen = maxSize
+	}
+	type segment struct {
+		start int
+		score uint64
+	}
+	numSegments := (maxSize + segmentLen - 1) / segmentLen
+	epochLen := len(data) / numSegments
+	if epochLen < segmentLen {
+		epochLen = segmentLen
+	}
+	segments := make([]segment, 0, numSegments)
+	window := make(map[uint64]int)
+	for epoch := 0; epoch+segmentLen <= len(data); epoch += epochLen {
+		end := epoch + epochLen
+		if end > len(data) {
+			end = len(data)
+		}
+		// Slide a window of segmentLen bytes over the epoch, keeping track of
+		// the sum of the frequencies of the distinct substrings it contains.
+		for g := range window {
+			delete(window, g)
+		}
+		best := segment{start: -1}
+		var score uint64
+		for i := epoch; i+dictGramLen <= end; i++ {
+			g := binary.LittleEndian.Uint64(data[i:])
+			if window[g]++; window[g] == 1 {
+				score += uint64(freqs[g])
+			}
+			start := i + dictGramLen - segmentLen
+			if start < epoch {
+				continue
+			}
+			if score > best.score {
+				best = segment{start: start, score: score}
+			}
+			// Remove the first substring of the window.
+			first := binary.LittleEndian.Uint64(data[start:])
+			if window[first]--; window[first] == 0 {
+				delete(window, first)
+				score -= uint64(freqs[first])
+			}
+		}
+		if best.start < 0 {
+			continue
+		}
+		segments = append(segments, best)
+		for i := best.start; i+dictGramLen <= best.start+segmentLen; i++ {
+			freqs[binary.LittleEndian.Uint64(data[i:])] = 0
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score < segments[j].score
+	})
+	if len(segments)*segmentLen > maxSize {
+		segments = segments[len(segments)-maxSize/segmentLen:]
+	}
+	dict := make([]byte, 0, len(segments)*segmentLen)
+	for _, s := range segments {
+		dict = append(dict, data[s.start:s.start+segmentLen]...)
+	}
+	return dict
+}
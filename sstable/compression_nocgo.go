@@ -7,12 +7,25 @@
 
 package sstable
 
-import "github.com/klauspost/compress/zstd"
+import (
+	"github.com/cockroachdb/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDictSupported is true if the Zstandard implementation supports
+// compression dictionaries. The pure Go implementation only supports
+// dictionaries in the zstd dictionary format, not the raw content
+// dictionaries stored in sstables, so they are ignored when writing and
+// rejected when reading.
+const zstdDictSupported = false
 
 // decodeZstd decompresses b with the Zstandard algorithm.
 // It reuses the preallocated capacity of decodedBuf if it is sufficient.
 // On success, it returns the decoded byte slice.
-func decodeZstd(decodedBuf, b []byte) ([]byte, error) {
+func decodeZstd(decodedBuf, b, dict []byte) ([]byte, error) {
+	if len(dict) != 0 {
+		return nil, errors.New("pebble/table: reading tables with compression dictionaries requires cgo")
+	}
 	decoder, _ := zstd.NewReader(nil)
 	defer decoder.Close()
 	return decoder.DecodeAll(b, decodedBuf[:0])
@@ -22,8 +35,9 @@ func decodeZstd(decodedBuf, b []byte) ([]byte, error) {
 // level (level 3). It reuses the preallocated capacity of compressedBuf if it
 // is sufficient. The subslice `compressedBuf[:varIntLen]` should already encode
 // the length of `b` before calling encodeZstd. It returns the encoded byte
-// slice, including the `compressedBuf[:varIntLen]` prefix.
-func encodeZstd(compressedBuf []byte, varIntLen int, b []byte) []byte {
+// slice, including the `compressedBuf[:varIntLen]` prefix. The dict is
+// ignored, see zstdDictSupported.
+func encodeZstd(compressedBuf []byte, varIntLen int, b, dict []byte) []byte {
 	encoder, _ := zstd.NewWriter(nil)
 	defer encoder.Close()
 	return encoder.EncodeAll(b, compressedBuf[:varIntLen])
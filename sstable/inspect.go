@@ -0,0 +1,242 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/pebble/internal/keyspan"
+)
+
+// BlockKind identifies the role of a block within an sstable.
+type BlockKind uint8
+
+// The kinds of blocks in an sstable Layout.
+const (
+	BlockKindData BlockKind = iota
+	BlockKindIndex
+	BlockKindTopIndex
+	BlockKindFilter
+	BlockKindRangeDel
+	BlockKindRangeKey
+	BlockKindValue
+	BlockKindValueIndex
+	BlockKindCompressionDict
+	BlockKindProperties
+	BlockKindMetaIndex
+	BlockKindFooter
+	BlockKindLevelDBFooter
+	numBlockKinds
+)
+
+var blockKindNames = [numBlockKinds]string{
+	BlockKindData:            "data",
+	BlockKindIndex:           "index",
+	BlockKindTopIndex:        "top-index",
+	BlockKindFilter:          "filter",
+	BlockKindRangeDel:        "range-del",
+	BlockKindRangeKey:        "range-key",
+	BlockKindValue:           "value-block",
+	BlockKindValueIndex:      "value-index",
+	BlockKindCompressionDict: "compression-dict",
+	BlockKindProperties:      "properties",
+	BlockKindMetaIndex:       "meta-index",
+	BlockKindFooter:          "footer",
+	BlockKindLevelDBFooter:   "leveldb-footer",
+}
+
+// String implements fmt.Stringer.
+func (k BlockKind) String() string {
+	if k < numBlockKinds {
+		return blockKindNames[k]
+	}
+	return fmt.Sprintf("unknown(%d)", k)
+}
+
+// LayoutBlock is a block of an sstable, as described by its Layout.
+type LayoutBlock struct {
+	Kind BlockKind
+	BlockHandle
+}
+
+// Blocks returns all the blocks of the layout, ordered by offset.
+func (l *Layout) Blocks() []LayoutBlock {
+	var blocks []LayoutBlock
+	add := func(kind BlockKind, bh BlockHandle) {
+		if bh.Length != 0 {
+			blocks = append(blocks, LayoutBlock{Kind: kind, BlockHandle: bh})
+		}
+	}
+	for i := range l.Data {
+		add(BlockKindData, l.Data[i].BlockHandle)
+	}
+	for i := range l.Index {
+		add(BlockKindIndex, l.Index[i])
+	}
+	add(BlockKindTopIndex, l.TopIndex)
+	add(BlockKindFilter, l.Filter)
+	add(BlockKindRangeDel, l.RangeDel)
+	add(BlockKindRangeKey, l.RangeKey)
+	for i := range l.ValueBlock {
+		add(BlockKindValue, l.ValueBlock[i])
+	}
+	add(BlockKindValueIndex, l.ValueIndex)
+	add(BlockKindCompressionDict, l.CompressionDict)
+	add(BlockKindProperties, l.Properties)
+	add(BlockKindMetaIndex, l.MetaIndex)
+	if l.Footer.Length == levelDBFooterLen {
+		add(BlockKindLevelDBFooter, l.Footer)
+	} else {
+		add(BlockKindFooter, l.Footer)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Offset < blocks[j].Offset
+	})
+	return blocks
+}
+
+// RangeDel is a range deletion tombstone, which deletes the point keys within
+// [Start, End) with sequence numbers lower than SeqNum.
+type RangeDel struct {
+	Start, End []byte
+	SeqNum     uint64
+}
+
+// RangeKey is a range key over [Start, End). Kind is one of
+// InternalKeyKindRangeKeySet, InternalKeyKindRangeKeyUnset or
+// InternalKeyKindRangeKeyDelete. Suffix is only set for RangeKeySet and
+// RangeKeyUnset keys and Value is only set for RangeKeySet keys.
+type RangeKey struct {
+	Start, End []byte
+	SeqNum     uint64
+	Kind       InternalKeyKind
+	Suffix     []byte
+	Value      []byte
+}
+
+// ForEachPoint calls fn for each point key in the table, in order, along with
+// its value. Values stored in value blocks are retrieved. The key and value
+// are only valid for the duration of the call. Iteration stops at the first
+// error returned by fn, which is returned.
+func (r *Reader) ForEachPoint(fn func(key InternalKey, value []byte) error) (err error) {
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = firstError(err, iter.Close())
+	}()
+	for key, lv := iter.First(); key != nil; key, lv = iter.Next() {
+		value, _, err := lv.Value(nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(*key, value); err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
+
+// ForEachRangeDel calls fn for each range deletion in the table, in order of
+// start key and then by descending sequence number. The keys are only valid
+// for the duration of the call. Iteration stops at the first error returned by
+// fn, which is returned.
+func (r *Reader) ForEachRangeDel(fn func(RangeDel) error) error {
+	iter, err := r.NewRawRangeDelIter()
+	if err != nil || iter == nil {
+		return err
+	}
+	return forEachKey(iter, func(s *keyspan.Span, k *keyspan.Key) error {
+		return fn(RangeDel{Start: s.Start, End: s.End, SeqNum: k.SeqNum()})
+	})
+}
+
+// ForEachRangeKey calls fn for each range key in the table, in order of start
+// key and then by descending sequence number. The keys are only valid for the
+// duration of the call. Iteration stops at the first error returned by fn,
+// which is returned.
+func (r *Reader) ForEachRangeKey(fn func(RangeKey) error) error {
+	iter, err := r.NewRawRangeKeyIter()
+	if err != nil || iter == nil {
+		return err
+	}
+	return forEachKey(iter, func(s *keyspan.Span, k *keyspan.Key) error {
+		return fn(RangeKey{
+			Start:  s.Start,
+			End:    s.End,
+			SeqNum: k.SeqNum(),
+			Kind:   k.Kind(),
+			Suffix: k.Suffix,
+			Value:  k.Value,
+		})
+	})
+}
+
+func forEachKey(
+	iter keyspan.FragmentIterator, fn func(*keyspan.Span, *keyspan.Key) error,
+) (err error) {
+	defer func() {
+		err = firstError(err, iter.Close())
+	}()
+	for s := iter.First(); s != nil; s = iter.Next() {
+		for i := range s.Keys {
+			if err := fn(s, &s.Keys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Error()
+}
+
+// TableInfo summarizes an sstable. See Inspect.
+type TableInfo struct {
+	// Format is the table format of the sstable.
+	Format TableFormat
+	// Size is the size of the sstable, in bytes.
+	Size int64
+	// Properties are the properties of the sstable.
+	Properties *Properties
+	// Layout is the block organization of the sstable.
+	Layout *Layout
+	// SmallestPoint and LargestPoint are the smallest and largest point keys
+	// in the sstable; they are nil if there are no point keys.
+	SmallestPoint, LargestPoint *InternalKey
+}
+
+// Inspect returns a summary of the sstable opened by the Reader. The returned
+// TableInfo does not reference memory owned by the Reader, except for
+// Properties.
+func Inspect(r *Reader) (*TableInfo, error) {
+	t := &TableInfo{
+		Size:       r.readable.Size(),
+		Properties: &r.Properties,
+	}
+	var err error
+	if t.Format, err = r.TableFormat(); err != nil {
+		return nil, err
+	}
+	if t.Layout, err = r.Layout(); err != nil {
+		return nil, err
+	}
+
+	iter, err := r.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return nil, err
+	}
+	if key, _ := iter.First(); key != nil {
+		k := key.Clone()
+		t.SmallestPoint = &k
+	}
+	if key, _ := iter.Last(); key != nil {
+		k := key.Clone()
+		t.LargestPoint = &k
+	}
+	if err := firstError(iter.Error(), iter.Close()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(objstorage.NewFileWritable(f), WriterOptions{
+		Comparer:    testkeys.Comparer,
+		BlockSize:   64,
+		TableFormat: TableFormatPebblev3,
+	})
+	for i := 0; i < 20; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%02d", i))))
+	}
+	require.NoError(t, w.DeleteRange([]byte("a"), []byte("c")))
+	require.NoError(t, w.RangeKeySet([]byte("d"), []byte("f"), []byte("@5"), []byte("foo")))
+	require.NoError(t, w.RangeKeyDelete([]byte("m"), []byte("n")))
+	require.NoError(t, w.Close())
+
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	r, err := newReader(f, ReaderOptions{Comparer: testkeys.Comparer})
+	require.NoError(t, err)
+	defer r.Close()
+
+	info, err := Inspect(r)
+	require.NoError(t, err)
+	require.Equal(t, TableFormatPebblev3, info.Format)
+	require.Equal(t, uint64(1), info.Properties.NumRangeDeletions)
+	require.Equal(t, uint64(2), info.Properties.NumRangeKeys())
+	require.Equal(t, "key00#0,SET", fmt.Sprint(info.SmallestPoint.Pretty(testkeys.Comparer.FormatKey)))
+	require.Equal(t, "key19#0,SET", fmt.Sprint(info.LargestPoint.Pretty(testkeys.Comparer.FormatKey)))
+	stat, err := mem.Stat("test")
+	require.NoError(t, err)
+	require.Equal(t, stat.Size(), info.Size)
+
+	// The blocks are ordered, contiguous (separated only by block trailers)
+	// and match the output of Describe.
+	blocks := info.Layout.Blocks()
+	require.Equal(t, BlockKindFooter, blocks[len(blocks)-1].Kind)
+	require.Equal(t, uint64(info.Size), blocks[len(blocks)-1].Offset+blocks[len(blocks)-1].Length)
+	var numData int
+	var buf bytes.Buffer
+	info.Layout.Describe(&buf, false /* verbose */, r, nil)
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	require.Equal(t, len(blocks)+1, len(lines))
+	require.Equal(t, fmt.Sprintf("%10d  EOF", info.Size), lines[len(lines)-1])
+	for i, b := range blocks {
+		if i > 0 {
+			prev := blocks[i-1]
+			require.Equal(t, prev.Offset+prev.Length+blockTrailerLen, b.Offset)
+		}
+		if b.Kind == BlockKindData {
+			numData++
+		}
+		require.Equal(t, fmt.Sprintf("%10d  %s (%d)", b.Offset, b.Kind, b.Length), lines[i])
+	}
+	require.Equal(t, len(info.Layout.Data), numData)
+	require.Greater(t, numData, 1)
+
+	var points []string
+	require.NoError(t, r.ForEachPoint(func(key InternalKey, value []byte) error {
+		points = append(points, fmt.Sprintf("%s=%s", key.UserKey, value))
+		return nil
+	}))
+	require.Equal(t, 20, len(points))
+	require.Equal(t, "key07=value07", points[7])
+
+	var rangeDels []RangeDel
+	require.NoError(t, r.ForEachRangeDel(func(rd RangeDel) error {
+		rd.Start = append([]byte(nil), rd.Start...)
+		rd.End = append([]byte(nil), rd.End...)
+		rangeDels = append(rangeDels, rd)
+		return nil
+	}))
+	require.Equal(t, []RangeDel{{Start: []byte("a"), End: []byte("c")}}, rangeDels)
+
+	var rangeKeys []string
+	require.NoError(t, r.ForEachRangeKey(func(rk RangeKey) error {
+		rangeKeys = append(rangeKeys, fmt.Sprintf("%s-%s:%s:%s:%s", rk.Start, rk.End, rk.Kind, rk.Suffix, rk.Value))
+		return nil
+	}))
+	require.Equal(t, []string{"d-f:RANGEKEYSET:@5:foo", "m-n:RANGEKEYDEL::"}, rangeKeys)
+
+	// Errors returned by the callback stop the iteration.
+	errStop := errors.New("stop")
+	n := 0
+	require.Equal(t, errStop, r.ForEachPoint(func(InternalKey, []byte) error {
+		n++
+		return errStop
+	}))
+	require.Equal(t, 1, n)
+}
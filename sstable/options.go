@@ -120,6 +120,12 @@ type ReaderOptions struct {
 
 	// Logger is an optional logger and tracer.
 	LoggerAndTracer base.LoggerAndTracer
+
+	// RewriteProperties, if set, is called with the properties of the sstable
+	// once they are read, before they are checked against the Comparer and
+	// MergerName, and may modify them, e.g. to adopt sstables written by
+	// another fork or version recording different names.
+	RewriteProperties func(props *Properties) error
 }
 
 func (o ReaderOptions) ensureDefaults() ReaderOptions {
@@ -172,11 +178,33 @@ type WriterOptions struct {
 	// The default value (DefaultCompression) uses snappy compression.
 	Compression Compression
 
+	// CompressionDict is a raw content dictionary used to prime the compressor
+	// of every block compressed with ZstdCompression. Small blocks of similar
+	// records compress significantly better when a dictionary sampled from
+	// representative data is provided. The dictionary is stored in the sstable
+	// so that the table can be read without any additional configuration.
+	//
+	// CompressionDict requires TableFormatPebblev4, and is ignored for older
+	// table formats, for other compression algorithms, and when Zstandard is
+	// not backed by the cgo implementation. Reading tables written with a
+	// dictionary also requires the cgo implementation.
+	//
+	// The default value is no dictionary.
+	CompressionDict []byte
+
+	// DBID is the unique ID of the DB writing the table, which is recorded in
+	// the table properties so that the table can be attributed to the DB.
+	//
+	// The default value is no ID.
+	DBID string
+
 	// FilterPolicy defines a filter algorithm (such as a Bloom filter) that can
 	// reduce disk reads for Get calls.
 	//
 	// One such implementation is bloom.FilterPolicy(10) from the pebble/bloom
-	// package.
+	// package. ribbon.FilterPolicy(7) from the pebble/ribbon package provides a
+	// lower false positive rate using ~30% less space, at the cost of more CPU
+	// when building the filter.
 	//
 	// The default value means to use no filter.
 	FilterPolicy FilterPolicy
@@ -199,6 +227,22 @@ type WriterOptions struct {
 	// The default value is the value of BlockSize.
 	IndexBlockSize int
 
+	// DisableTwoLevelIndex disables two-level indexes: the table's index is
+	// written as a single block, regardless of IndexBlockSize.
+	DisableTwoLevelIndex bool
+
+	// TopLevelIndexBlockSize is the approximate target uncompressed size in
+	// bytes of the top-level index block of tables with two-level indexes. The
+	// top-level index block contains an entry per index partition and is always
+	// read in its entirety, so it can grow very large for tables with large
+	// keys. Each time the top-level index block grows by another
+	// TopLevelIndexBlockSize bytes, the target size of the remaining index
+	// partitions (initially IndexBlockSize) is doubled.
+	//
+	// The default value (0) places no limit on the size of the top-level index
+	// block.
+	TopLevelIndexBlockSize int
+
 	// Merger defines the associative merge operation to use for merging values
 	// written with {Batch,DB}.Merge. The MergerName is checked for consistency
 	// with the value stored in the sstable when it was written.
@@ -220,7 +264,15 @@ type WriterOptions struct {
 	// built and lives for the lifetime of writing that table.
 	BlockPropertyCollectors []func() BlockPropertyCollector
 
-	// Checksum specifies which checksum to use.
+	// Checksum specifies which checksum to use. Blocks are always verified
+	// using the checksum recorded in the footer of the table they belong to,
+	// so tables written with different checksums can be read side by side.
+	// ChecksumTypeXXH3 is significantly faster than ChecksumTypeCRC32c on
+	// platforms where CRC32c is not hardware accelerated. Tables written with
+	// ChecksumTypeXXH3 cannot be read by older versions of Pebble.
+	//
+	// The default value (ChecksumTypeNone) uses CRC32c. The LevelDB table format
+	// always uses CRC32c.
 	Checksum ChecksumType
 
 	// Parallelism is used to indicate that the sstable Writer is allowed to
@@ -235,6 +287,11 @@ type WriterOptions struct {
 	// RequiredInPlaceValueBound mirrors
 	// Options.Experimental.RequiredInPlaceValueBound.
 	RequiredInPlaceValueBound UserKeyPrefixBound
+
+	// DisableValueBlocks keeps all the values in the data blocks when writing
+	// TableFormatPebblev3 or later, so that the features of these formats can
+	// be used without storing values in value blocks.
+	DisableValueBlocks bool
 }
 
 func (o WriterOptions) ensureDefaults() WriterOptions {
@@ -267,5 +324,10 @@ func (o WriterOptions) ensureDefaults() WriterOptions {
 	if o.TableFormat == TableFormatUnspecified {
 		o.TableFormat = TableFormatRocksDBv2
 	}
+	// The LevelDB footer does not record the checksum type, and LevelDB tables
+	// are always read using CRC32c.
+	if o.TableFormat == TableFormatLevelDB {
+		o.Checksum = ChecksumTypeCRC32c
+	}
 	return o
 }
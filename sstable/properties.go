@@ -71,6 +71,8 @@ type Properties struct {
 	// The time when the SST file was created. Since SST files are immutable,
 	// this is equivalent to last modified time.
 	CreationTime uint64 `prop:"rocksdb.creation.time"`
+	// The unique ID of the DB which created this table. Empty if unknown.
+	DBID string `prop:"rocksdb.creating.db.identity"`
 	// The total size of all data blocks.
 	DataSize uint64 `prop:"rocksdb.data.size"`
 	// The external sstable version format. Version 2 is the one RocksDB has been
@@ -309,6 +311,9 @@ func (p *Properties) save(w *rawBlockWriter) {
 		p.saveString(m, unsafe.Offsetof(p.CompressionOptions), p.CompressionOptions)
 	}
 	p.saveUvarint(m, unsafe.Offsetof(p.CreationTime), p.CreationTime)
+	if p.DBID != "" {
+		p.saveString(m, unsafe.Offsetof(p.DBID), p.DBID)
+	}
 	p.saveUvarint(m, unsafe.Offsetof(p.DataSize), p.DataSize)
 	if p.ExternalFormatVersion != 0 {
 		p.saveUint32(m, unsafe.Offsetof(p.ExternalFormatVersion), p.ExternalFormatVersion)
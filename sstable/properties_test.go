@@ -67,6 +67,7 @@ func TestPropertiesSave(t *testing.T) {
 		CompressionName:          "compression name",
 		CompressionOptions:       "compression option",
 		CreationTime:             2,
+		DBID:                     "db id",
 		DataSize:                 3,
 		ExternalFormatVersion:    4,
 		FilterPolicyName:         "filter policy name",
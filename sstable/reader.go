@@ -26,6 +26,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/keyspan"
 	"github.com/cockroachdb/pebble/internal/private"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/vfs"
 )
 
 var errCorruptIndexEntry = base.CorruptionErrorf("pebble/table: corrupt index entry")
@@ -414,7 +415,7 @@ func (i *singleLevelIterator) init(
 		return err
 	}
 	i.dataRH = r.readable.NewReadHandle(ctx)
-	if r.tableFormat == TableFormatPebblev3 {
+	if r.tableFormat >= TableFormatPebblev3 {
 		if r.Properties.NumValueBlocks > 0 {
 			// NB: we cannot avoid this ~248 byte allocation, since valueBlockReader
 			// can outlive the singleLevelIterator due to be being embedded in a
@@ -444,8 +445,10 @@ func (i *singleLevelIterator) init(
 // Currently, it skips readahead ramp-up. It should be called after init is called.
 func (i *singleLevelIterator) setupForCompaction() {
 	i.dataRH.MaxReadahead()
+	objstorage.SetIOClass(i.dataRH, vfs.IOClassCompaction)
 	if i.vbRH != nil {
 		i.vbRH.MaxReadahead()
+		objstorage.SetIOClass(i.vbRH, vfs.IOClassCompaction)
 	}
 }
 
@@ -1703,7 +1706,7 @@ func (i *twoLevelIterator) init(
 		return err
 	}
 	i.dataRH = r.readable.NewReadHandle(ctx)
-	if r.tableFormat == TableFormatPebblev3 {
+	if r.tableFormat >= TableFormatPebblev3 {
 		if r.Properties.NumValueBlocks > 0 {
 			i.vbReader = &valueBlockReader{
 				ctx:    ctx,
@@ -2581,6 +2584,7 @@ type Reader struct {
 	rangeKeyBH        BlockHandle
 	rangeDelTransform blockTransform
 	valueBIH          valueBlocksIndexHandle
+	compressionDictBH BlockHandle
 	propertiesBH      BlockHandle
 	metaIndexBH       BlockHandle
 	footerBH          BlockHandle
@@ -2589,6 +2593,9 @@ type Reader struct {
 	FormatKey         base.FormatKey
 	Split             Split
 	tableFilter       *tableFilterReader
+	// compressionDict is the dictionary used to compress the Zstandard blocks
+	// of the table, or nil if the table was written without one.
+	compressionDict []byte
 	// Keep types that are not multiples of 8 bytes at the end and with
 	// decreasing size.
 	Properties    Properties
@@ -2786,6 +2793,8 @@ func checkChecksum(
 		computedChecksum = crc.New(b[:bh.Length+1]).Value()
 	case ChecksumTypeXXHash64:
 		computedChecksum = uint32(xxhash.Sum64(b[:bh.Length+1]))
+	case ChecksumTypeXXH3:
+		computedChecksum = xxh3Checksum(b[:bh.Length], b[bh.Length])
 	default:
 		return errors.Errorf("unsupported checksum type: %d", checksumType)
 	}
@@ -2848,15 +2857,18 @@ func (r *Reader) readBlock(
 	}
 
 	if err := checkChecksum(r.checksumType, b, bh, r.fileNum); err != nil {
-		r.opts.Cache.Free(v)
-		return cache.Handle{}, err
+		// The block may be read from the replica of a local sstable instead.
+		if rr, ok := r.readable.(objstorage.ReplicaReadable); !ok || !r.readReplicaBlock(ctx, rr, b, bh, err) {
+			r.opts.Cache.Free(v)
+			return cache.Handle{}, err
+		}
 	}
 
 	typ := blockType(b[bh.Length])
 	b = b[:bh.Length]
 	v.Truncate(len(b))
 
-	decoded, err := decompressBlock(r.opts.Cache, typ, b)
+	decoded, err := decompressBlock(r.opts.Cache, typ, b, r.compressionDict)
 	if decoded != nil {
 		r.opts.Cache.Free(v)
 		v = decoded
@@ -2889,6 +2901,22 @@ func (r *Reader) readBlock(
 	return h, nil
 }
 
+// readReplicaBlock reads the block from the replica of the sstable into b,
+// after the block read from the sstable failed checksum validation with the
+// given error. It returns true if the block read from the replica is valid.
+func (r *Reader) readReplicaBlock(
+	ctx context.Context, rr objstorage.ReplicaReadable, b []byte, bh BlockHandle, localErr error,
+) bool {
+	if _, err := rr.ReadReplicaAt(ctx, b, int64(bh.Offset)); err != nil {
+		return false
+	}
+	if checkChecksum(r.checksumType, b, bh, r.fileNum) != nil {
+		return false
+	}
+	rr.RecordReplicaFallback(localErr)
+	return true
+}
+
 func (r *Reader) transformRangeDelV1(b []byte) ([]byte, error) {
 	// Convert v1 (RocksDB format) range-del blocks to v2 blocks on the fly. The
 	// v1 format range-del blocks have unfragmented and unsorted range
@@ -2975,6 +3003,23 @@ func (r *Reader) readMetaindex(metaindexBH BlockHandle) error {
 		return err
 	}
 
+	// The compression dictionary must be loaded before any compressed block is
+	// read. It is stored uncompressed.
+	if bh, ok := meta[metaCompressionDictName]; ok {
+		if r.tableFormat < TableFormatPebblev4 {
+			return base.CorruptionErrorf(
+				"pebble/table: compression dictionary in table format %s", errors.Safe(r.tableFormat))
+		}
+		b, err = r.readBlock(
+			context.Background(), bh, nil /* transform */, nil /* readHandle */, nil /* stats */)
+		if err != nil {
+			return err
+		}
+		r.compressionDictBH = bh
+		r.compressionDict = append([]byte(nil), b.Get()...)
+		b.Release()
+	}
+
 	if bh, ok := meta[metaPropertiesName]; ok {
 		b, err = r.readBlock(
 			context.Background(), bh, nil /* transform */, nil /* readHandle */, nil /* stats */)
@@ -3039,15 +3084,16 @@ func (r *Reader) Layout() (*Layout, error) {
 	}
 
 	l := &Layout{
-		Data:       make([]BlockHandleWithProperties, 0, r.Properties.NumDataBlocks),
-		Filter:     r.filterBH,
-		RangeDel:   r.rangeDelBH,
-		RangeKey:   r.rangeKeyBH,
-		ValueIndex: r.valueBIH.h,
-		Properties: r.propertiesBH,
-		MetaIndex:  r.metaIndexBH,
-		Footer:     r.footerBH,
-		Format:     r.tableFormat,
+		Data:            make([]BlockHandleWithProperties, 0, r.Properties.NumDataBlocks),
+		Filter:          r.filterBH,
+		RangeDel:        r.rangeDelBH,
+		RangeKey:        r.rangeKeyBH,
+		ValueIndex:      r.valueBIH.h,
+		CompressionDict: r.compressionDictBH,
+		Properties:      r.propertiesBH,
+		MetaIndex:       r.metaIndexBH,
+		Footer:          r.footerBH,
+		Format:          r.tableFormat,
 	}
 
 	indexH, err := r.readIndex(context.Background(), nil)
@@ -3156,7 +3202,8 @@ func (r *Reader) ValidateBlockChecksums() error {
 		blocks[i] = l.Data[i].BlockHandle
 	}
 	blocks = append(blocks, l.Index...)
-	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.Properties, l.MetaIndex)
+	blocks = append(blocks, l.TopIndex, l.Filter, l.RangeDel, l.RangeKey, l.CompressionDict,
+		l.Properties, l.MetaIndex)
 
 	// Sorting by offset ensures we are performing a sequential scan of the
 	// file.
@@ -3186,6 +3233,139 @@ func (r *Reader) ValidateBlockChecksums() error {
 	return nil
 }
 
+// WarmBlocks reads the blocks of the table starting at the given offsets into
+// the block cache, if they are not already cached. Offsets which do not
+// correspond to a block that is read through the block cache are ignored. It
+// returns the number of blocks that were read.
+func (r *Reader) WarmBlocks(ctx context.Context, offsets []uint64) (int, error) {
+	l, err := r.Layout()
+	if err != nil {
+		return 0, err
+	}
+	type cachedBlock struct {
+		bh        BlockHandle
+		transform blockTransform
+	}
+	blocks := make(map[uint64]cachedBlock, len(l.Data)+len(l.Index)+len(l.ValueBlock)+5)
+	add := func(bh BlockHandle, transform blockTransform) {
+		if bh.Length != 0 {
+			blocks[bh.Offset] = cachedBlock{bh: bh, transform: transform}
+		}
+	}
+	for i := range l.Data {
+		add(l.Data[i].BlockHandle, nil)
+	}
+	for _, bh := range l.Index {
+		add(bh, nil)
+	}
+	for _, bh := range l.ValueBlock {
+		add(bh, nil)
+	}
+	add(l.TopIndex, nil)
+	add(l.Filter, nil)
+	add(l.RangeDel, r.rangeDelTransform)
+	add(l.RangeKey, nil)
+	add(l.ValueIndex, nil)
+
+	var n int
+	for _, off := range offsets {
+		b, ok := blocks[off]
+		if !ok {
+			continue
+		}
+		if h := r.opts.Cache.Get(r.cacheID, r.fileNum, off); h.Get() != nil {
+			h.Release()
+			continue
+		}
+		h, err := r.readBlock(ctx, b.bh, b.transform, nil /* readHandle */, nil /* stats */)
+		if err != nil {
+			return n, err
+		}
+		h.Release()
+		n++
+	}
+	return n, nil
+}
+
+// PrefetchRange reads the data blocks of the table which may contain keys in
+// the range [start, end) into the block cache, if they are not already cached.
+// A nil end means the range is unbounded. It returns the number of blocks that
+// were read.
+func (r *Reader) PrefetchRange(ctx context.Context, start, end []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	indexH, err := r.readIndex(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer indexH.Release()
+	indexIter, err := newBlockIter(r.Compare, indexH.Get())
+	if err != nil {
+		return 0, err
+	}
+
+	// pastEnd returns true if the blocks following the block with the given
+	// index separator lie beyond the range.
+	pastEnd := func(sep *InternalKey) bool {
+		return end != nil && r.Compare(sep.UserKey, end) >= 0
+	}
+	var n int
+	// prefetchBlocks reads the data blocks referenced by the given index block
+	// which overlap the range. It returns true if the end of the range was
+	// reached.
+	prefetchBlocks := func(iter *blockIter) (bool, error) {
+		key, val := iter.SeekGE(start, base.SeekGEFlagsNone)
+		for ; key != nil; key, val = iter.Next() {
+			bh, err := decodeBlockHandleWithProperties(val.InPlaceValue())
+			if err != nil {
+				return false, errCorruptIndexEntry
+			}
+			if h := r.opts.Cache.Get(r.cacheID, r.fileNum, bh.Offset); h.Get() != nil {
+				h.Release()
+			} else {
+				h, err := r.readBlock(ctx, bh.BlockHandle, nil /* transform */, nil /* readHandle */, nil /* stats */)
+				if err != nil {
+					return false, err
+				}
+				h.Release()
+				n++
+			}
+			if pastEnd(key) {
+				return true, nil
+			}
+		}
+		return false, iter.Error()
+	}
+
+	if r.Properties.IndexPartitions == 0 {
+		_, err := prefetchBlocks(indexIter)
+		return n, err
+	}
+	key, val := indexIter.SeekGE(start, base.SeekGEFlagsNone)
+	for ; key != nil; key, val = indexIter.Next() {
+		bh, err := decodeBlockHandleWithProperties(val.InPlaceValue())
+		if err != nil {
+			return n, errCorruptIndexEntry
+		}
+		h, err := r.readBlock(ctx, bh.BlockHandle, nil /* transform */, nil /* readHandle */, nil /* stats */)
+		if err != nil {
+			return n, err
+		}
+		iter, err := newBlockIter(r.Compare, h.Get())
+		var done bool
+		if err == nil {
+			done, err = prefetchBlocks(iter)
+		}
+		h.Release()
+		if err != nil || done || pastEnd(key) {
+			return n, err
+		}
+	}
+	return n, indexIter.Error()
+}
+
 // EstimateDiskUsage returns the total size of data blocks overlapping the range
 // `[start, end]`. Even if a data block partially overlaps, or we cannot
 // determine overlap due to abbreviated index keys, the full data block size is
@@ -3326,6 +3506,11 @@ func (r *Reader) TableFormat() (TableFormat, error) {
 	return r.tableFormat, nil
 }
 
+// ChecksumType returns the checksum type of the blocks of the table.
+func (r *Reader) ChecksumType() ChecksumType {
+	return r.checksumType
+}
+
 // NewReader returns a new table reader for the file. Closing the reader will
 // close the file.
 func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption) (*Reader, error) {
@@ -3374,6 +3559,13 @@ func NewReader(f objstorage.Readable, o ReaderOptions, extraOpts ...ReaderOption
 	r.metaIndexBH = footer.metaindexBH
 	r.footerBH = footer.footerBH
 
+	if o.RewriteProperties != nil {
+		if err := o.RewriteProperties(&r.Properties); err != nil {
+			r.err = errors.Wrapf(err, "pebble/table: %d: rewriting properties", errors.Safe(r.fileNum))
+			return nil, r.Close()
+		}
+	}
+
 	if r.Properties.ComparerName == "" || o.Comparer.Name == r.Properties.ComparerName {
 		r.Compare = o.Comparer.Compare
 		r.FormatKey = o.Comparer.FormatKey
@@ -3414,18 +3606,19 @@ type Layout struct {
 	// ValidateBlockChecksums, which validates a static list of BlockHandles
 	// referenced in this struct.
 
-	Data       []BlockHandleWithProperties
-	Index      []BlockHandle
-	TopIndex   BlockHandle
-	Filter     BlockHandle
-	RangeDel   BlockHandle
-	RangeKey   BlockHandle
-	ValueBlock []BlockHandle
-	ValueIndex BlockHandle
-	Properties BlockHandle
-	MetaIndex  BlockHandle
-	Footer     BlockHandle
-	Format     TableFormat
+	Data            []BlockHandleWithProperties
+	Index           []BlockHandle
+	TopIndex        BlockHandle
+	Filter          BlockHandle
+	RangeDel        BlockHandle
+	RangeKey        BlockHandle
+	ValueBlock      []BlockHandle
+	ValueIndex      BlockHandle
+	CompressionDict BlockHandle
+	Properties      BlockHandle
+	MetaIndex       BlockHandle
+	Footer          BlockHandle
+	Format          TableFormat
 }
 
 // Describe returns a description of the layout. If the verbose parameter is
@@ -3439,48 +3632,9 @@ func (l *Layout) Describe(
 		name string
 	}
 	var blocks []block
-
-	for i := range l.Data {
-		blocks = append(blocks, block{l.Data[i].BlockHandle, "data"})
-	}
-	for i := range l.Index {
-		blocks = append(blocks, block{l.Index[i], "index"})
-	}
-	if l.TopIndex.Length != 0 {
-		blocks = append(blocks, block{l.TopIndex, "top-index"})
-	}
-	if l.Filter.Length != 0 {
-		blocks = append(blocks, block{l.Filter, "filter"})
-	}
-	if l.RangeDel.Length != 0 {
-		blocks = append(blocks, block{l.RangeDel, "range-del"})
+	for _, lb := range l.Blocks() {
+		blocks = append(blocks, block{lb.BlockHandle, lb.Kind.String()})
 	}
-	if l.RangeKey.Length != 0 {
-		blocks = append(blocks, block{l.RangeKey, "range-key"})
-	}
-	for i := range l.ValueBlock {
-		blocks = append(blocks, block{l.ValueBlock[i], "value-block"})
-	}
-	if l.ValueIndex.Length != 0 {
-		blocks = append(blocks, block{l.ValueIndex, "value-index"})
-	}
-	if l.Properties.Length != 0 {
-		blocks = append(blocks, block{l.Properties, "properties"})
-	}
-	if l.MetaIndex.Length != 0 {
-		blocks = append(blocks, block{l.MetaIndex, "meta-index"})
-	}
-	if l.Footer.Length != 0 {
-		if l.Footer.Length == levelDBFooterLen {
-			blocks = append(blocks, block{l.Footer, "leveldb-footer"})
-		} else {
-			blocks = append(blocks, block{l.Footer, "footer"})
-		}
-	}
-
-	sort.Slice(blocks, func(i, j int) bool {
-		return blocks[i].Offset < blocks[j].Offset
-	})
 
 	for i := range blocks {
 		b := &blocks[i]
@@ -3489,7 +3643,7 @@ func (l *Layout) Describe(
 		if !verbose {
 			continue
 		}
-		if b.name == "filter" {
+		if b.name == "filter" || b.name == "compression-dict" {
 			continue
 		}
 
@@ -3601,7 +3755,7 @@ func (l *Layout) Describe(
 				formatIsRestart(iter.data, iter.restarts, iter.numRestarts, iter.offset)
 				if fmtRecord != nil {
 					fmt.Fprintf(w, "              ")
-					if l.Format != TableFormatPebblev3 {
+					if l.Format < TableFormatPebblev3 {
 						fmtRecord(key, value.InPlaceValue())
 					} else {
 						// InPlaceValue() will succeed even for data blocks where the
@@ -739,7 +739,7 @@ func TestReadaheadSetupForV3TablesWithMultipleVersions(t *testing.T) {
 }
 
 func TestReaderChecksumErrors(t *testing.T) {
-	for _, checksumType := range []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64} {
+	for _, checksumType := range []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64, ChecksumTypeXXH3} {
 		t.Run(fmt.Sprintf("checksum-type=%d", checksumType), func(t *testing.T) {
 			for _, twoLevelIndex := range []bool{false, true} {
 				t.Run(fmt.Sprintf("two-level-index=%t", twoLevelIndex), func(t *testing.T) {
@@ -829,6 +829,115 @@ func TestReaderChecksumErrors(t *testing.T) {
 	}
 }
 
+func TestReaderWarmBlocks(t *testing.T) {
+	mem := vfs.NewMem()
+	f, err := mem.Create("test")
+	require.NoError(t, err)
+	w := NewWriter(objstorage.NewFileWritable(f), WriterOptions{
+		BlockSize:    64,
+		FilterPolicy: bloom.FilterPolicy(10),
+		TableFormat:  TableFormatPebblev2,
+	})
+	for i := 0; i < 50; i++ {
+		require.NoError(t, w.Set([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%02d", i))))
+	}
+	require.NoError(t, w.DeleteRange([]byte("a"), []byte("b")))
+	require.NoError(t, w.Close())
+
+	c := cache.New(128 << 20)
+	defer c.Unref()
+	f, err = mem.Open("test")
+	require.NoError(t, err)
+	r, err := newReader(f, ReaderOptions{
+		Cache:   c,
+		Filters: map[string]FilterPolicy{"rocksdb.BuiltinBloomFilter": bloom.FilterPolicy(10)},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	l, err := r.Layout()
+	require.NoError(t, err)
+	require.Greater(t, len(l.Data), 1)
+	offsets := []uint64{l.Filter.Offset, l.RangeDel.Offset, l.Properties.Offset, l.Data[0].Offset + 1}
+	for i := range l.Data {
+		offsets = append(offsets, l.Data[i].Offset)
+	}
+
+	// The properties block and the offset in the middle of a data block are
+	// ignored.
+	c.EvictFile(r.cacheID, r.fileNum)
+	n, err := r.WarmBlocks(context.Background(), offsets)
+	require.NoError(t, err)
+	require.Equal(t, len(l.Data)+2, n)
+	for i := range l.Data {
+		h := c.Get(r.cacheID, r.fileNum, l.Data[i].Offset)
+		require.NotNil(t, h.Get())
+		h.Release()
+	}
+
+	// Cached blocks are not read again.
+	n, err = r.WarmBlocks(context.Background(), offsets)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestReaderPrefetchRange(t *testing.T) {
+	// An IndexBlockSize equal to the BlockSize yields a partitioned index.
+	for _, indexBlockSize := range []int{64 << 10, 64} {
+		t.Run(fmt.Sprintf("index-block-size=%d", indexBlockSize), func(t *testing.T) {
+			mem := vfs.NewMem()
+			f, err := mem.Create("test")
+			require.NoError(t, err)
+			w := NewWriter(objstorage.NewFileWritable(f), WriterOptions{
+				BlockSize:      64,
+				IndexBlockSize: indexBlockSize,
+				TableFormat:    TableFormatPebblev2,
+			})
+			for i := 0; i < 50; i++ {
+				require.NoError(t, w.Set([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%02d", i))))
+			}
+			require.NoError(t, w.Close())
+
+			c := cache.New(128 << 20)
+			defer c.Unref()
+			f, err = mem.Open("test")
+			require.NoError(t, err)
+			r, err := newReader(f, ReaderOptions{Cache: c})
+			require.NoError(t, err)
+			defer r.Close()
+			require.Equal(t, indexBlockSize == 64, r.Properties.IndexPartitions > 0)
+
+			l, err := r.Layout()
+			require.NoError(t, err)
+			require.Greater(t, len(l.Data), 4)
+			cached := func(bh BlockHandleWithProperties) bool {
+				h := c.Get(r.cacheID, r.fileNum, bh.Offset)
+				defer h.Release()
+				return h.Get() != nil
+			}
+
+			c.EvictFile(r.cacheID, r.fileNum)
+			n, err := r.PrefetchRange(context.Background(), []byte("key10"), []byte("key20"))
+			require.NoError(t, err)
+			require.Greater(t, n, 0)
+			require.Less(t, n, len(l.Data))
+			// The blocks outside of the range are not read.
+			require.False(t, cached(l.Data[0]))
+			require.False(t, cached(l.Data[len(l.Data)-1]))
+
+			// Cached blocks are not read again.
+			n, err = r.PrefetchRange(context.Background(), []byte("key10"), []byte("key20"))
+			require.NoError(t, err)
+			require.Equal(t, 0, n)
+
+			// A nil end reads the blocks through the end of the table.
+			_, err = r.PrefetchRange(context.Background(), []byte("key40"), nil)
+			require.NoError(t, err)
+			require.True(t, cached(l.Data[len(l.Data)-1]))
+		})
+	}
+}
+
 func TestValidateBlockChecksums(t *testing.T) {
 	seed := uint64(time.Now().UnixNano())
 	rng := rand.New(rand.NewSource(seed))
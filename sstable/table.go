@@ -191,11 +191,12 @@ const (
 	levelDBFormatVersion  = 0
 	rocksDBFormatVersion2 = 2
 
-	metaRangeKeyName   = "pebble.range_key"
-	metaValueIndexName = "pebble.value_index"
-	metaPropertiesName = "rocksdb.properties"
-	metaRangeDelName   = "rocksdb.range_del"
-	metaRangeDelV2Name = "rocksdb.range_del2"
+	metaRangeKeyName        = "pebble.range_key"
+	metaValueIndexName      = "pebble.value_index"
+	metaCompressionDictName = "rocksdb.compression_dict"
+	metaPropertiesName      = "rocksdb.properties"
+	metaRangeDelName        = "rocksdb.range_del"
+	metaRangeDelV2Name      = "rocksdb.range_del2"
 
 	// Index Types.
 	// A space efficient index block that is optimized for binary-search-based
@@ -222,6 +223,7 @@ const (
 	ChecksumTypeCRC32c   ChecksumType = 1
 	ChecksumTypeXXHash   ChecksumType = 2
 	ChecksumTypeXXHash64 ChecksumType = 3
+	ChecksumTypeXXH3     ChecksumType = 4
 )
 
 // String implements fmt.Stringer.
@@ -235,6 +237,8 @@ func (t ChecksumType) String() string {
 		return "xxhash"
 	case ChecksumTypeXXHash64:
 		return "xxhash64"
+	case ChecksumTypeXXH3:
+		return "xxh3"
 	default:
 		panic(errors.Newf("sstable: unknown checksum type: %d", t))
 	}
@@ -358,8 +362,10 @@ func readFooter(f objstorage.Readable) (footer, error) {
 			footer.checksum = ChecksumTypeCRC32c
 		case ChecksumTypeXXHash64:
 			footer.checksum = ChecksumTypeXXHash64
+		case ChecksumTypeXXH3:
+			footer.checksum = ChecksumTypeXXH3
 		default:
-			return footer, base.CorruptionErrorf("pebble/table: unsupported checksum type %d", errors.Safe(footer.checksum))
+			return footer, base.CorruptionErrorf("pebble/table: unsupported checksum type %d", errors.Safe(buf[0]))
 		}
 		buf = buf[1:]
 
@@ -410,6 +416,8 @@ func (f footer) encode(buf []byte) []byte {
 			buf[0] = byte(ChecksumTypeXXHash)
 		case ChecksumTypeXXHash64:
 			buf[0] = byte(ChecksumTypeXXHash64)
+		case ChecksumTypeXXH3:
+			buf[0] = byte(ChecksumTypeXXH3)
 		default:
 			panic("unknown checksum type")
 		}
@@ -430,7 +438,8 @@ func supportsTwoLevelIndex(format TableFormat) bool {
 	switch format {
 	case TableFormatLevelDB:
 		return false
-	case TableFormatRocksDBv2, TableFormatPebblev1, TableFormatPebblev2, TableFormatPebblev3:
+	case TableFormatRocksDBv2, TableFormatPebblev1, TableFormatPebblev2, TableFormatPebblev3,
+		TableFormatPebblev4:
 		return true
 	default:
 		panic("sstable: unspecified table format version")
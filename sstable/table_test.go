@@ -23,6 +23,7 @@ import (
 	"github.com/cockroachdb/pebble/bloom"
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/cockroachdb/pebble/ribbon"
 	"github.com/cockroachdb/pebble/vfs"
 	"github.com/kr/pretty"
 	"github.com/stretchr/testify/require"
@@ -494,6 +495,32 @@ func TestReaderBloomUsed(t *testing.T) {
 	}
 }
 
+func TestReaderRibbonUsed(t *testing.T) {
+	f, err := build(DefaultCompression, ribbon.FilterPolicy(7), TableFilter, nil, nil, 4096, 4096)
+	require.NoError(t, err)
+	r, err := newReader(f, ReaderOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "pebble.RibbonFilter", r.Properties.FilterPolicyName)
+	require.NoError(t, r.Close())
+
+	// The filter is used when a policy with the same name is configured,
+	// regardless of the number of bits it was configured with.
+	f, err = build(DefaultCompression, ribbon.FilterPolicy(7), TableFilter, nil, nil, 4096, 4096)
+	require.NoError(t, err)
+	c := &countingFilterPolicy{FilterPolicy: ribbon.FilterPolicy(1)}
+	require.NoError(t, check(f, nil, c))
+	require.Equal(t, len(wordCount), c.truePositives)
+	require.Equal(t, 0, c.falseNegatives)
+	require.Greater(t, c.trueNegatives, 10*c.falsePositives)
+
+	// A filter policy with a different name ignores the filter.
+	f, err = build(DefaultCompression, ribbon.FilterPolicy(7), TableFilter, nil, nil, 4096, 4096)
+	require.NoError(t, err)
+	c = &countingFilterPolicy{FilterPolicy: bloom.FilterPolicy(10)}
+	require.NoError(t, check(f, nil, c))
+	require.Equal(t, 0, c.truePositives+c.falsePositives+c.trueNegatives)
+}
+
 func TestBloomFilterFalsePositiveRate(t *testing.T) {
 	f, err := os.Open(filepath.FromSlash("testdata/h.table-bloom.no-compression.sst"))
 	require.NoError(t, err)
@@ -585,6 +612,7 @@ func TestWriterRoundTrip(t *testing.T) {
 			for name, fp := range map[string]FilterPolicy{
 				"none":       nil,
 				"bloom10bit": bloom.FilterPolicy(10),
+				"ribbon7bit": ribbon.FilterPolicy(7),
 			} {
 				t.Run(fmt.Sprintf("bloom=%s", name), func(t *testing.T) {
 					f, err := build(DefaultCompression, fp, TableFilter,
@@ -719,7 +747,7 @@ func TestFooterRoundTrip(t *testing.T) {
 		t.Run(fmt.Sprintf("format=%s", format), func(t *testing.T) {
 			checksums := []ChecksumType{ChecksumTypeCRC32c}
 			if format != TableFormatLevelDB {
-				checksums = []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64}
+				checksums = []ChecksumType{ChecksumTypeCRC32c, ChecksumTypeXXHash64, ChecksumTypeXXH3}
 			}
 			for _, checksum := range checksums {
 				t.Run(fmt.Sprintf("checksum=%d", checksum), func(t *testing.T) {
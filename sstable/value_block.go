@@ -322,8 +322,9 @@ type valueBlocksAndIndexStats struct {
 type valueBlockWriter struct {
 	// The configured uncompressed block size and size threshold
 	blockSize, blockSizeThreshold int
-	// Configured compression.
-	compression Compression
+	// Configured compression, and the table's compression dictionary.
+	compression     Compression
+	compressionDict []byte
 	// checksummer with configured checksum type.
 	checksummer checksummer
 	// Block finished callback.
@@ -394,6 +395,7 @@ func newValueBlockWriter(
 	blockSize int,
 	blockSizeThreshold int,
 	compression Compression,
+	compressionDict []byte,
 	checksumType ChecksumType,
 	// compressedSize should exclude the block trailer.
 	blockFinishedFunc func(compressedSize int),
@@ -403,6 +405,7 @@ func newValueBlockWriter(
 		blockSize:          blockSize,
 		blockSizeThreshold: blockSizeThreshold,
 		compression:        compression,
+		compressionDict:    compressionDict,
 		checksummer: checksummer{
 			checksumType: checksumType,
 		},
@@ -489,7 +492,7 @@ func (w *valueBlockWriter) compressAndFlush() {
 	b := w.buf
 	if w.compression != NoCompression {
 		blockType, w.compressedBuf.b =
-			compressBlock(w.compression, w.buf.b, w.compressedBuf.b[:cap(w.compressedBuf.b)])
+			compressBlock(w.compression, w.buf.b, w.compressedBuf.b[:cap(w.compressedBuf.b)], w.compressionDict)
 		if len(w.compressedBuf.b) < len(w.buf.b)-len(w.buf.b)/8 {
 			b = w.compressedBuf
 		} else {
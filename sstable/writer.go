@@ -24,6 +24,7 @@ import (
 	"github.com/cockroachdb/pebble/internal/private"
 	"github.com/cockroachdb/pebble/internal/rangekey"
 	"github.com/cockroachdb/pebble/objstorage"
+	"github.com/zeebo/xxh3"
 )
 
 // encodedBHPEstimatedSize estimates the size of the encoded BlockHandleWithProperties.
@@ -125,10 +126,15 @@ type Writer struct {
 	blockSizeThreshold      int
 	indexBlockSize          int
 	indexBlockSizeThreshold int
+	blockSizeThresholdPct   int
+	disableTwoLevelIndex    bool
+	topLevelIndexBlockSize  int
 	compare                 Compare
 	split                   Split
 	formatKey               base.FormatKey
 	compression             Compression
+	compressionDict         []byte
+	disableValueBlocks      bool
 	separator               Separator
 	successor               Successor
 	tableFormat             TableFormat
@@ -155,6 +161,12 @@ type Writer struct {
 	// smaller memory footprint, can be used to prevent the entire index block from
 	// being loaded into the block cache.
 	twoLevelIndex bool
+	// topLevelIndexSizeEstimate is an estimate of the size of the top-level
+	// index, maintained when topLevelIndexBlockSize is set. When it exceeds
+	// topLevelIndexSizeLimit, the index partition size is doubled and the limit
+	// is raised by topLevelIndexBlockSize.
+	topLevelIndexSizeEstimate int
+	topLevelIndexSizeLimit    int
 	// Internal flag to allow creation of range-del-v1 format blocks. Only used
 	// for testing. Note that v2 format blocks are backwards compatible with v1
 	// format blocks.
@@ -558,12 +570,22 @@ func (c *checksummer) checksum(block []byte, blockType []byte) (checksum uint32)
 		c.xxHasher.Write(block)
 		c.xxHasher.Write(blockType)
 		checksum = uint32(c.xxHasher.Sum64())
+	case ChecksumTypeXXH3:
+		checksum = xxh3Checksum(block, blockType[0])
 	default:
 		panic(errors.Newf("unsupported checksum type: %d", c.checksumType))
 	}
 	return checksum
 }
 
+// xxh3Checksum computes the ChecksumTypeXXH3 checksum of a block and its block
+// type byte. For compatibility with RocksDB, the block type is mixed into the
+// lower 32 bits of the XXH3 hash of the block, rather than hashed with it.
+func xxh3Checksum(block []byte, blockType byte) uint32 {
+	const randomPrime = 0x6b9083d9
+	return uint32(xxh3.Hash(block)) ^ uint32(blockType)*randomPrime
+}
+
 type blockBuf struct {
 	// tmp is a scratch buffer, large enough to hold either footerLen bytes,
 	// blockTrailerLen bytes, (5 * binary.MaxVarintLen64) bytes, and most
@@ -642,8 +664,8 @@ func (d *dataBlockBuf) finish() {
 	d.uncompressed = d.dataBlock.finish()
 }
 
-func (d *dataBlockBuf) compressAndChecksum(c Compression) {
-	d.compressed = compressAndChecksum(d.uncompressed, c, &d.blockBuf)
+func (d *dataBlockBuf) compressAndChecksum(c Compression, dict []byte) {
+	d.compressed = compressAndChecksum(d.uncompressed, c, dict, &d.blockBuf)
 }
 
 func (d *dataBlockBuf) shouldFlush(
@@ -845,6 +867,7 @@ func (w *Writer) addPoint(key InternalKey, value []byte) error {
 		// ignore this maxSharedKeyLen.
 		maxSharedKeyLen = w.lastPointKeyInfo.prefixLen
 		setHasSameKeyPrefix, writeToValueBlock, err = w.makeAddPointDecisionV3(key, len(value))
+		writeToValueBlock = writeToValueBlock && !w.disableValueBlocks
 		addPrefixToValueStoredWithKey = base.TrailerKind(key.Trailer) == InternalKeyKindSet
 	} else {
 		err = w.makeAddPointDecisionV2(key)
@@ -1283,7 +1306,7 @@ func (w *Writer) flush(key InternalKey) error {
 		return err
 	}
 	w.dataBlockBuf.finish()
-	w.dataBlockBuf.compressAndChecksum(w.compression)
+	w.dataBlockBuf.compressAndChecksum(w.compression, w.compressionDict)
 	// Since dataBlockEstimates.addInflightDataBlock was never called, the
 	// inflightSize is set to 0.
 	w.coordination.sizeEstimate.dataBlockCompressed(len(w.dataBlockBuf.compressed), 0)
@@ -1303,9 +1326,7 @@ func (w *Writer) flush(key InternalKey) error {
 	// calls must happen sequentially from the Writer client. Therefore, we need
 	// to determine that we are going to flush the index block from the Writer
 	// client.
-	shouldFlushIndexBlock := supportsTwoLevelIndex(w.tableFormat) && w.indexBlock.shouldFlush(
-		sep, encodedBHPEstimatedSize, w.indexBlockSize, w.indexBlockSizeThreshold,
-	)
+	shouldFlushIndexBlock := w.shouldFlushIndexBlock(sep)
 
 	var indexProps []byte
 	var flushableIndexBlock *indexBlockBuf
@@ -1462,6 +1483,35 @@ func (w *Writer) addIndexEntry(
 	return nil
 }
 
+// shouldFlushIndexBlock returns true if the current index block should be
+// finished, starting a new index partition, before adding an index entry with
+// the given separator. It must be called from the Writer client goroutine.
+func (w *Writer) shouldFlushIndexBlock(sep InternalKey) bool {
+	if w.disableTwoLevelIndex || !supportsTwoLevelIndex(w.tableFormat) {
+		return false
+	}
+	if !w.indexBlock.shouldFlush(
+		sep, encodedBHPEstimatedSize, w.indexBlockSize, w.indexBlockSizeThreshold,
+	) {
+		return false
+	}
+	if w.topLevelIndexBlockSize > 0 {
+		// The flushed partition adds an entry to the top-level index. Its key is
+		// the partition's last separator, which we approximate with sep.
+		w.topLevelIndexSizeEstimate += sep.Size() + encodedBHPEstimatedSize + 2*binary.MaxVarintLen32
+		if w.topLevelIndexSizeEstimate > w.topLevelIndexSizeLimit {
+			// Double the size of the remaining partitions, to slow the growth of
+			// the top-level index.
+			w.topLevelIndexSizeLimit += w.topLevelIndexBlockSize
+			if w.indexBlockSize < math.MaxInt32/2 {
+				w.indexBlockSize *= 2
+				w.indexBlockSizeThreshold = (w.indexBlockSize*w.blockSizeThresholdPct + 99) / 100
+			}
+		}
+	}
+	return true
+}
+
 func (w *Writer) addPrevDataBlockToIndexBlockProps() {
 	for i := range w.blockPropCollectors {
 		w.blockPropCollectors[i].AddPrevDataBlockToIndexBlock()
@@ -1480,10 +1530,7 @@ func (w *Writer) addIndexEntrySync(
 	prevKey, key InternalKey, bhp BlockHandleWithProperties, tmp []byte,
 ) error {
 	sep := w.indexEntrySep(prevKey, key, w.dataBlockBuf)
-	shouldFlush := supportsTwoLevelIndex(
-		w.tableFormat) && w.indexBlock.shouldFlush(
-		sep, encodedBHPEstimatedSize, w.indexBlockSize, w.indexBlockSizeThreshold,
-	)
+	shouldFlush := w.shouldFlushIndexBlock(sep)
 	var flushableIndexBlock *indexBlockBuf
 	var props []byte
 	var err error
@@ -1636,10 +1683,12 @@ func (w *Writer) writeTwoLevelIndex() (BlockHandle, error) {
 	return w.writeBlock(w.topLevelIndexBlock.finish(), w.compression, &w.blockBuf)
 }
 
-func compressAndChecksum(b []byte, compression Compression, blockBuf *blockBuf) []byte {
+func compressAndChecksum(
+	b []byte, compression Compression, dict []byte, blockBuf *blockBuf,
+) []byte {
 	// Compress the buffer, discarding the result if the improvement isn't at
 	// least 12.5%.
-	blockType, compressed := compressBlock(compression, b, blockBuf.compressedBuf)
+	blockType, compressed := compressBlock(compression, b, blockBuf.compressedBuf, dict)
 	if blockType != noCompressionBlockType && cap(compressed) > cap(blockBuf.compressedBuf) {
 		blockBuf.compressedBuf = compressed[:cap(compressed)]
 	}
@@ -1705,7 +1754,7 @@ func (w *Writer) Write(blockWithTrailer []byte) (n int, err error) {
 func (w *Writer) writeBlock(
 	b []byte, compression Compression, blockBuf *blockBuf,
 ) (BlockHandle, error) {
-	b = compressAndChecksum(b, compression, blockBuf)
+	b = compressAndChecksum(b, compression, w.compressionDict, blockBuf)
 	return w.writeCompressedBlock(b, blockBuf.tmp[:])
 }
 
@@ -1913,6 +1962,23 @@ func (w *Writer) Close() (err error) {
 		metaindex.add(InternalKey{UserKey: []byte(metaRangeKeyName)}, w.blockBuf.tmp[:n])
 	}
 
+	// Write the compression dictionary block. It is never compressed, since it
+	// must be read before any other block can be decompressed. The block
+	// handle sorts before the properties block handle in the metaindex.
+	if len(w.compressionDict) > 0 {
+		// The dictionary is shared with other writers, and the Writable may
+		// use the buffer passed to Write as scratch space, so it is written
+		// from a copy.
+		dict := append(w.blockBuf.compressedBuf[:0], w.compressionDict...)
+		w.blockBuf.compressedBuf = dict[:cap(dict)]
+		bh, err := w.writeBlock(dict, NoCompression, &w.blockBuf)
+		if err != nil {
+			return err
+		}
+		n := encodeBlockHandle(w.blockBuf.tmp[:], bh)
+		metaindex.add(InternalKey{UserKey: []byte(metaCompressionDictName)}, w.blockBuf.tmp[:n])
+	}
+
 	{
 		userProps := make(map[string]string)
 		for i := range w.propCollectors {
@@ -2095,6 +2161,10 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 		blockSizeThreshold:      (o.BlockSize*o.BlockSizeThreshold + 99) / 100,
 		indexBlockSize:          o.IndexBlockSize,
 		indexBlockSizeThreshold: (o.IndexBlockSize*o.BlockSizeThreshold + 99) / 100,
+		blockSizeThresholdPct:   o.BlockSizeThreshold,
+		disableTwoLevelIndex:    o.DisableTwoLevelIndex,
+		topLevelIndexBlockSize:  o.TopLevelIndexBlockSize,
+		topLevelIndexSizeLimit:  o.TopLevelIndexBlockSize,
 		compare:                 o.Comparer.Compare,
 		split:                   o.Comparer.Split,
 		formatKey:               o.Comparer.FormatKey,
@@ -2120,11 +2190,16 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 			Format: o.Comparer.FormatKey,
 		},
 	}
-	if w.tableFormat == TableFormatPebblev3 {
+	if w.compression == ZstdCompression && zstdDictSupported && len(o.CompressionDict) > 0 &&
+		w.tableFormat >= TableFormatPebblev4 {
+		w.compressionDict = o.CompressionDict
+	}
+	if w.tableFormat >= TableFormatPebblev3 {
+		w.disableValueBlocks = o.DisableValueBlocks
 		w.shortAttributeExtractor = o.ShortAttributeExtractor
 		w.requiredInPlaceValueBound = o.RequiredInPlaceValueBound
 		w.valueBlockWriter = newValueBlockWriter(
-			w.blockSize, w.blockSizeThreshold, w.compression, w.checksumType, func(compressedSize int) {
+			w.blockSize, w.blockSizeThreshold, w.compression, w.compressionDict, w.checksumType, func(compressedSize int) {
 				w.coordination.sizeEstimate.dataBlockCompressed(compressedSize, 0)
 			})
 	}
@@ -2170,6 +2245,7 @@ func NewWriter(writable objstorage.Writable, o WriterOptions, extraOpts ...Write
 
 	w.props.ColumnFamilyID = math.MaxInt32
 	w.props.ComparerName = o.Comparer.Name
+	w.props.DBID = o.DBID
 	w.props.CompressionName = o.Compression.String()
 	w.props.MergerName = o.MergerName
 	w.props.PropertyCollectorNames = "[]"
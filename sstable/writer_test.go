@@ -885,6 +885,141 @@ func TestWriterRace(t *testing.T) {
 	wg.Wait()
 }
 
+func TestWriterCompressionDict(t *testing.T) {
+	// Small records with a lot of content in common with each other, which
+	// compress poorly in small blocks without a dictionary.
+	record := func(i int) (key, value []byte) {
+		key = []byte(fmt.Sprintf("user/%08d", i))
+		value = []byte(fmt.Sprintf(
+			`{"id":%d,"status":"active","region":"us-east-1","plan":"enterprise"}`, i))
+		return key, value
+	}
+	var dict []byte
+	for i := 0; i < 100; i++ {
+		k, v := record(i * 7)
+		dict = append(dict, k...)
+		dict = append(dict, v...)
+	}
+
+	build := func(dict []byte) []byte {
+		f := &memFile{}
+		w := NewWriter(f, WriterOptions{
+			BlockSize:       256,
+			Compression:     ZstdCompression,
+			CompressionDict: dict,
+			TableFormat:     TableFormatPebblev4,
+		})
+		for i := 0; i < 1000; i++ {
+			k, v := record(i)
+			require.NoError(t, w.Set(k, v))
+		}
+		require.NoError(t, w.Close())
+		return f.Data()
+	}
+	withoutDict := build(nil)
+	withDict := build(dict)
+
+	r, err := NewMemReader(withDict, ReaderOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	layout, err := r.Layout()
+	require.NoError(t, err)
+	if !zstdDictSupported {
+		// The dictionary is ignored.
+		require.Zero(t, layout.CompressionDict.Length)
+		require.Equal(t, len(withoutDict), len(withDict))
+		return
+	}
+	require.Equal(t, uint64(len(dict)), layout.CompressionDict.Length)
+	require.Equal(t, dict, r.compressionDict)
+	require.NoError(t, r.ValidateBlockChecksums())
+	// The dictionary is stored in the table, yet the table is smaller.
+	require.Less(t, len(withDict), len(withoutDict))
+
+	it, err := r.NewIter(nil, nil)
+	require.NoError(t, err)
+	i := 0
+	for k, v := it.First(); k != nil; k, v = it.Next() {
+		key, value := record(i)
+		require.Equal(t, key, k.UserKey)
+		got, _, err := v.Value(nil)
+		require.NoError(t, err)
+		require.Equal(t, value, got)
+		i++
+	}
+	require.NoError(t, it.Close())
+	require.Equal(t, 1000, i)
+}
+
+func TestWriterIndexPartitioning(t *testing.T) {
+	// Large keys with long common prefixes produce large index entries.
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%s%08d", bytes.Repeat([]byte("k"), 1000), i))
+	}
+	build := func(o WriterOptions) *Reader {
+		f := &memFile{}
+		o.BlockSize = 4096
+		o.IndexBlockSize = 4096
+		o.BlockRestartInterval = 1
+		o.TableFormat = TableFormatPebblev2
+		w := NewWriter(f, o)
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, w.Set(key(i), []byte("value")))
+		}
+		require.NoError(t, w.Close())
+		r, err := NewMemReader(f.Data(), ReaderOptions{})
+		require.NoError(t, err)
+
+		it, err := r.NewIter(nil, nil)
+		require.NoError(t, err)
+		i := 0
+		for k, _ := it.First(); k != nil; k, _ = it.Next() {
+			require.Equal(t, key(i), k.UserKey)
+			i++
+		}
+		require.NoError(t, it.Close())
+		require.Equal(t, 2000, i)
+		return r
+	}
+
+	r := build(WriterOptions{})
+	defer r.Close()
+	require.Equal(t, uint32(twoLevelIndex), r.Properties.IndexType)
+	require.Greater(t, r.Properties.IndexPartitions, uint64(100))
+	require.Greater(t, r.Properties.TopLevelIndexSize, uint64(100<<10))
+
+	r2 := build(WriterOptions{DisableTwoLevelIndex: true})
+	defer r2.Close()
+	require.Equal(t, uint32(binarySearchIndex), r2.Properties.IndexType)
+	require.Zero(t, r2.Properties.IndexPartitions)
+
+	r3 := build(WriterOptions{TopLevelIndexBlockSize: 16 << 10})
+	defer r3.Close()
+	require.Equal(t, uint32(twoLevelIndex), r3.Properties.IndexType)
+	require.Less(t, r3.Properties.IndexPartitions, r.Properties.IndexPartitions/2)
+	require.Less(t, r3.Properties.TopLevelIndexSize, r.Properties.TopLevelIndexSize/2)
+}
+
+func TestTrainCompressionDict(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 1000; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(
+			`user/%08d{"id":%d,"status":"active","region":"us-east-1"}`, i, i)))
+	}
+
+	// Samples that fit in the dictionary are used as is.
+	require.Equal(t, bytes.Join(samples[:3], nil), TrainCompressionDict(samples[:3], 1024))
+
+	dict := TrainCompressionDict(samples, 1024)
+	require.LessOrEqual(t, len(dict), 1024)
+	require.Greater(t, len(dict), 512)
+	require.True(t, bytes.Contains(dict, []byte(`,"status":"active","region":"us-east-1"}`)))
+
+	// The dictionary is assembled from whole segments.
+	require.Len(t, TrainCompressionDict(samples, 1000), 3*dictSegmentLen)
+}
+
 func BenchmarkWriter(b *testing.B) {
 	keys := make([][]byte, 1e6)
 	const keyLen = 24
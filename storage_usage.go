@@ -0,0 +1,74 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// StorageUsage describes the space used by the DB on the local filesystem and
+// on shared storage (see DB.StorageUsage).
+type StorageUsage struct {
+	// LocalBytes is the disk space used by the DB on the local filesystem,
+	// i.e. Metrics.DiskSpaceUsage without the sstables stored on shared
+	// storage.
+	LocalBytes uint64
+	// SharedObjects is the number of objects stored by the DB on shared
+	// storage: shared sstables and the replicas of local files (see
+	// Options.Experimental.ReplicateLocalTables and ReplicateManifest).
+	// SharedBytes is their total size.
+	SharedObjects int
+	SharedBytes   int64
+	// PendingReplicaTables is the number of local sstables which have no
+	// replica on shared storage yet, and PendingReplicaBytes their total
+	// size; they are uploaded by the next scrub (see DB.Scrub). They are
+	// zero unless Options.Experimental.ReplicateLocalTables is set.
+	PendingReplicaTables int
+	PendingReplicaBytes  uint64
+}
+
+// StorageUsage returns the space used by the DB on the local filesystem and
+// on shared storage. Unlike Metrics, it lists the objects on shared storage
+// and issues a request for each of them, so it should not be called
+// frequently. The shared storage usage is zero if shared storage is not
+// configured or the creator ID is not set.
+func (d *DB) StorageUsage() (StorageUsage, error) {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	var usage StorageUsage
+	usage.LocalBytes = d.Metrics().DiskSpaceUsage()
+	if !d.objProvider.CanReplicate() {
+		return usage, nil
+	}
+	shared, err := d.objProvider.SharedUsage()
+	if err != nil {
+		return StorageUsage{}, err
+	}
+	usage.SharedObjects, usage.SharedBytes = shared.Objects, shared.Bytes
+
+	var replicas map[FileNum]struct{}
+	if d.opts.Experimental.ReplicateLocalTables {
+		if replicas, err = d.objProvider.ListReplicas(fileTypeTable); err != nil {
+			return StorageUsage{}, err
+		}
+	}
+	rs := d.loadReadState()
+	defer rs.unref()
+	for level := range rs.current.Levels {
+		iter := rs.current.Levels[level].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			meta, err := d.objProvider.Lookup(fileTypeTable, f.FileNum)
+			if err != nil {
+				return StorageUsage{}, err
+			}
+			if meta.IsShared() {
+				usage.LocalBytes -= f.Size
+				continue
+			}
+			if _, ok := replicas[meta.FileNum]; replicas != nil && !ok {
+				usage.PendingReplicaTables++
+				usage.PendingReplicaBytes += f.Size
+			}
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/pebble/objstorage/shared"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageUsage(t *testing.T) {
+	opts := &Options{
+		FS:                          vfs.NewMem(),
+		DisableAutomaticCompactions: true,
+	}
+	opts.Experimental.SharedStorage = shared.NewInMem()
+	opts.Experimental.ReplicateLocalTables = true
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Without the creator ID, only the local usage is reported.
+	usage, err := d.StorageUsage()
+	require.NoError(t, err)
+	require.Equal(t, StorageUsage{LocalBytes: d.Metrics().DiskSpaceUsage()}, usage)
+
+	require.NoError(t, d.SetCreatorID(1))
+	for gen := 0; gen < 2; gen++ {
+		for i := 0; i < 100; i++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprint(gen)), nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	tables, err := d.SSTables()
+	require.NoError(t, err)
+	require.Len(t, tables[0], 2)
+	tablesSize := tables[0][0].Size + tables[0][1].Size
+
+	// The tables are pending replication until they are scrubbed.
+	usage, err = d.StorageUsage()
+	require.NoError(t, err)
+	require.Zero(t, usage.SharedObjects)
+	require.Equal(t, 2, usage.PendingReplicaTables)
+	require.Equal(t, tablesSize, usage.PendingReplicaBytes)
+
+	_, err = d.Scrub()
+	require.NoError(t, err)
+	usage, err = d.StorageUsage()
+	require.NoError(t, err)
+	require.Equal(t, StorageUsage{
+		LocalBytes:    d.Metrics().DiskSpaceUsage(),
+		SharedObjects: 2,
+		SharedBytes:   int64(tablesSize),
+	}, usage)
+}
@@ -13,6 +13,7 @@ import (
 	"runtime/pprof"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/cockroachdb/errors"
@@ -65,17 +66,35 @@ type tableCacheOpts struct {
 		iterCount *int32
 	}
 
+	// stats holds the table cache counters of the DB.
+	stats *tableCacheStats
+
 	loggerAndTracer LoggerAndTracer
 	cacheID         uint64
 	objProvider     *objstorage.Provider
 	opts            sstable.ReaderOptions
 	filterMetrics   *FilterMetrics
+	tableCache      *TableCache
+}
+
+// tableCacheStats holds the counters of a DB's use of the table cache. Its
+// fields are accessed atomically.
+type tableCacheStats struct {
+	hits      int64
+	misses    int64
+	evictions int64
+	// count is the number of tables of the DB in the cache. It is incremented
+	// when a table is added to the cache and decremented when it is removed.
+	count int64
 }
 
 // tableCacheContainer contains the table cache and
 // fields which are unique to the DB.
 type tableCacheContainer struct {
 	tableCache *TableCache
+	// shared is true if the table cache was provided through
+	// Options.TableCache, in which case it may be used by other DBs.
+	shared bool
 
 	// dbOpts contains fields relevant to the table cache
 	// which are unique to each DB.
@@ -101,12 +120,15 @@ func newTableCacheContainer(
 
 	t := &tableCacheContainer{}
 	t.tableCache = tc
+	t.shared = opts.TableCache != nil
 	t.dbOpts.loggerAndTracer = opts.LoggerAndTracer
 	t.dbOpts.cacheID = cacheID
 	t.dbOpts.objProvider = objProvider
 	t.dbOpts.opts = opts.MakeReaderOptions()
 	t.dbOpts.filterMetrics = &FilterMetrics{}
 	t.dbOpts.atomic.iterCount = new(int32)
+	t.dbOpts.stats = &tableCacheStats{}
+	t.dbOpts.tableCache = tc
 	return t
 }
 
@@ -153,17 +175,29 @@ func (c *tableCacheContainer) evict(fileNum FileNum) {
 	c.tableCache.getShard(fileNum).evict(fileNum, &c.dbOpts, false)
 }
 
-func (c *tableCacheContainer) metrics() (CacheMetrics, FilterMetrics) {
-	var m CacheMetrics
-	for i := range c.tableCache.shards {
-		s := c.tableCache.shards[i]
-		s.mu.RLock()
-		m.Count += int64(len(s.mu.nodes))
-		s.mu.RUnlock()
-		m.Hits += atomic.LoadInt64(&s.atomic.hits)
-		m.Misses += atomic.LoadInt64(&s.atomic.misses)
+// reopen drops the cached reader of the table, if any, so that the table is
+// opened again by its next user. Unlike evict, it can be called while the
+// reader is in use: the reader is closed once it is released by its users.
+func (c *tableCacheContainer) reopen(fileNum FileNum) {
+	s := c.tableCache.getShard(fileNum)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := s.mu.nodes[tableCacheKey{c.dbOpts.cacheID, fileNum}]; n != nil {
+		s.releaseNode(n)
 	}
+}
+
+// metrics returns the table cache and filter metrics of the DB. The table
+// cache metrics only account for the tables of this DB, with the exception of
+// Capacity which is the capacity of the (possibly shared) table cache.
+func (c *tableCacheContainer) metrics() (TableCacheMetrics, FilterMetrics) {
+	var m TableCacheMetrics
+	m.Count = atomic.LoadInt64(&c.dbOpts.stats.count)
+	m.Capacity = atomic.LoadInt64(&c.tableCache.atomic.capacity)
 	m.Size = m.Count * int64(unsafe.Sizeof(sstable.Reader{}))
+	m.Hits = atomic.LoadInt64(&c.dbOpts.stats.hits)
+	m.Misses = atomic.LoadInt64(&c.dbOpts.stats.misses)
+	m.Evictions = atomic.LoadInt64(&c.dbOpts.stats.evictions)
 	f := FilterMetrics{
 		Hits:   atomic.LoadInt64(&c.dbOpts.filterMetrics.Hits),
 		Misses: atomic.LoadInt64(&c.dbOpts.filterMetrics.Misses),
@@ -194,10 +228,17 @@ type TableCache struct {
 	// https://golang.org/pkg/sync/atomic/#pkg-note-BUG.
 	atomic struct {
 		refs int64
+		// capacity is the maximum number of open tables in the cache, summed
+		// over its shards.
+		capacity int64
 	}
 
 	cache  *Cache
 	shards []*tableCacheShard
+
+	// shrinkMu serializes the shrinking of the cache when the process runs
+	// out of file descriptors (see TableCache.shrink).
+	shrinkMu sync.Mutex
 }
 
 // Ref adds a reference to the table cache. Once tableCache.init returns,
@@ -254,6 +295,7 @@ func NewTableCache(cache *Cache, numShards int, size int) *TableCache {
 		c.shards[i] = &tableCacheShard{}
 		c.shards[i].init(size / len(c.shards))
 	}
+	c.atomic.capacity = int64(size / len(c.shards) * len(c.shards))
 
 	// Hold a ref to the cache here.
 	c.atomic.refs = 1
@@ -261,6 +303,46 @@ func NewTableCache(cache *Cache, numShards int, size int) *TableCache {
 	return c
 }
 
+// SetSize changes the maximum number of open tables in the table cache,
+// closing the least recently used tables if the cache is shrunk. The size is
+// divided evenly between the shards of the cache.
+func (c *TableCache) SetSize(size int) {
+	if size == 0 {
+		panic("pebble: cannot set the size of a table cache to 0")
+	}
+	for i := range c.shards {
+		c.shards[i].setSize(size / len(c.shards))
+	}
+	atomic.StoreInt64(&c.atomic.capacity, int64(size/len(c.shards)*len(c.shards)))
+}
+
+// shrink reduces the size of the cache to three quarters of the number of
+// tables it holds open, closing the least recently used tables. It returns
+// false if the cache cannot be shrunk further.
+func (c *TableCache) shrink() (from, to int, ok bool) {
+	c.shrinkMu.Lock()
+	defer c.shrinkMu.Unlock()
+	from = int(atomic.LoadInt64(&c.atomic.capacity))
+	var open int
+	for _, s := range c.shards {
+		s.mu.RLock()
+		open += s.mu.sizeHot + s.mu.sizeCold
+		s.mu.RUnlock()
+	}
+	if open > from {
+		open = from
+	}
+	to = open * 3 / 4
+	if to < len(c.shards) {
+		to = len(c.shards)
+	}
+	if to >= from {
+		return from, from, false
+	}
+	c.SetSize(to)
+	return from, to, true
+}
+
 func (c *TableCache) getShard(fileNum FileNum) *tableCacheShard {
 	return c.shards[uint64(fileNum)%uint64(len(c.shards))]
 }
@@ -282,10 +364,10 @@ type tableCacheShard struct {
 		iterCount int32
 	}
 
-	size int
-
 	mu struct {
 		sync.RWMutex
+		// size is the maximum number of open tables in the shard.
+		size  int
 		nodes map[tableCacheKey]*tableCacheNode
 		// The iters map is only created and populated in race builds.
 		iters map[io.Closer][]byte
@@ -305,7 +387,7 @@ type tableCacheShard struct {
 }
 
 func (c *tableCacheShard) init(size int) {
-	c.size = size
+	c.mu.size = size
 
 	c.mu.nodes = make(map[tableCacheKey]*tableCacheNode)
 	c.mu.coldTarget = size
@@ -390,11 +472,14 @@ func (c *tableCacheShard) newIters(
 
 	// NB: range-del iterator does not maintain a reference to the table, nor
 	// does it need to read from it after creation.
-	rangeDelIter, err := v.reader.NewRawRangeDelIter()
+	rangeDelIter, err := v.newRangeDelIter(dbOpts.opts.Comparer.Compare)
 	if err != nil {
 		c.unrefValue(v)
 		return nil, nil, err
 	}
+	if rangeDelIter != nil && file.Virtual != nil {
+		rangeDelIter = truncateToVirtualTable(dbOpts.opts.Comparer.Compare, file, rangeDelIter)
+	}
 
 	if !ok {
 		c.unrefValue(v)
@@ -422,14 +507,27 @@ func (c *tableCacheShard) newIters(
 		return nil, nil, err
 	}
 	var rp sstable.ReaderProvider
-	if tableFormat == sstable.TableFormatPebblev3 && v.reader.Properties.NumValueBlocks > 0 {
+	if tableFormat >= sstable.TableFormatPebblev3 && v.reader.Properties.NumValueBlocks > 0 {
 		rp = &tableCacheShardReaderProvider{c: c, file: file, dbOpts: dbOpts}
 	}
-	if internalOpts.bytesIterated != nil {
+	lower, upper := opts.GetLowerBound(), opts.GetUpperBound()
+	switch {
+	case file.Virtual != nil:
+		// The iterator of the sstable backing a virtual table is confined to the
+		// table bounds, including in compactions, which don't use the
+		// compaction iterator for virtual tables as it doesn't support bounds.
+		// Their progress is instead accounted for upfront.
+		if internalOpts.bytesIterated != nil {
+			*internalOpts.bytesIterated += file.Size
+		}
+		lower, upper = virtualTableBounds(dbOpts.opts.Comparer.Compare, file, lower, upper)
+		iter, err = v.reader.NewIterWithBlockPropertyFiltersAndContext(
+			ctx, lower, upper, filterer, useFilter, internalOpts.stats, rp)
+	case internalOpts.bytesIterated != nil:
 		iter, err = v.reader.NewCompactionIter(internalOpts.bytesIterated, rp)
-	} else {
+	default:
 		iter, err = v.reader.NewIterWithBlockPropertyFiltersAndContext(
-			ctx, opts.GetLowerBound(), opts.GetUpperBound(), filterer, useFilter, internalOpts.stats, rp)
+			ctx, lower, upper, filterer, useFilter, internalOpts.stats, rp)
 	}
 	if err != nil {
 		if rangeDelIter != nil {
@@ -449,6 +547,9 @@ func (c *tableCacheShard) newIters(
 		c.mu.iters[iter] = debug.Stack()
 		c.mu.Unlock()
 	}
+	if file.Virtual != nil {
+		iter = newVirtualPointIter(iter, dbOpts.opts.Comparer.Compare, file, lower, upper)
+	}
 	return iter, rangeDelIter, nil
 }
 
@@ -502,6 +603,9 @@ func (c *tableCacheShard) newRangeKeyIter(
 		return emptyKeyspanIter, nil
 	}
 
+	if file.Virtual != nil {
+		iter = truncateToVirtualTable(dbOpts.opts.Comparer.Compare, file, iter)
+	}
 	return iter, nil
 }
 
@@ -602,6 +706,7 @@ func (c *tableCacheShard) unlinkNode(n *tableCacheNode) {
 func (c *tableCacheShard) clearNode(n *tableCacheNode) {
 	if v := n.value; v != nil {
 		n.value = nil
+		atomic.AddInt64(&n.stats.count, -1)
 		c.unrefValue(v)
 	}
 }
@@ -633,6 +738,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 		c.mu.RUnlock()
 		atomic.StoreInt32(&n.referenced, 1)
 		atomic.AddInt64(&c.atomic.hits, 1)
+		atomic.AddInt64(&dbOpts.stats.hits, 1)
 		<-v.loaded
 		return v
 	}
@@ -659,6 +765,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 		atomic.AddInt32(&v.refCount, 1)
 		atomic.StoreInt32(&n.referenced, 1)
 		atomic.AddInt64(&c.atomic.hits, 1)
+		atomic.AddInt64(&dbOpts.stats.hits, 1)
 		c.mu.Unlock()
 		<-v.loaded
 		return v
@@ -667,8 +774,8 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 		// Slow-path miss of a test node.
 		c.unlinkNode(n)
 		c.mu.coldTarget++
-		if c.mu.coldTarget > c.size {
-			c.mu.coldTarget = c.size
+		if c.mu.coldTarget > c.mu.size {
+			c.mu.coldTarget = c.mu.size
 		}
 
 		atomic.StoreInt32(&n.referenced, 0)
@@ -678,6 +785,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 	}
 
 	atomic.AddInt64(&c.atomic.misses, 1)
+	atomic.AddInt64(&dbOpts.stats.misses, 1)
 
 	v := &tableCacheValue{
 		loaded:   make(chan struct{}),
@@ -697,6 +805,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 		return nil
 	}
 	n.value = v
+	atomic.AddInt64(&dbOpts.stats.count, 1)
 
 	c.mu.Unlock()
 
@@ -711,6 +820,7 @@ func (c *tableCacheShard) findNode(meta *fileMetadata, dbOpts *tableCacheOpts) *
 func (c *tableCacheShard) addNode(n *tableCacheNode, dbOpts *tableCacheOpts) {
 	c.evictNodes()
 	n.cacheID = dbOpts.cacheID
+	n.stats = dbOpts.stats
 	key := tableCacheKey{n.cacheID, n.meta.FileNum}
 	c.mu.nodes[key] = n
 
@@ -731,11 +841,28 @@ func (c *tableCacheShard) addNode(n *tableCacheNode, dbOpts *tableCacheOpts) {
 }
 
 func (c *tableCacheShard) evictNodes() {
-	for c.size <= c.mu.sizeHot+c.mu.sizeCold && c.mu.handCold != nil {
+	for c.mu.size <= c.mu.sizeHot+c.mu.sizeCold && c.mu.handCold != nil {
 		c.runHandCold()
 	}
 }
 
+// setSize changes the maximum number of open tables in the shard, evicting
+// tables until the shard fits within the new size.
+func (c *tableCacheShard) setSize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.size = size
+	if c.mu.coldTarget > size {
+		c.mu.coldTarget = size
+	}
+	for size < c.mu.sizeHot+c.mu.sizeCold && c.mu.handCold != nil {
+		c.runHandCold()
+	}
+	for size < c.mu.sizeTest && c.mu.handTest != nil {
+		c.runHandTest()
+	}
+}
+
 func (c *tableCacheShard) runHandCold() {
 	n := c.mu.handCold
 	if n.ptype == tableCacheNodeCold {
@@ -745,11 +872,14 @@ func (c *tableCacheShard) runHandCold() {
 			c.mu.sizeCold--
 			c.mu.sizeHot++
 		} else {
+			if n.value != nil {
+				atomic.AddInt64(&n.stats.evictions, 1)
+			}
 			c.clearNode(n)
 			n.ptype = tableCacheNodeTest
 			c.mu.sizeCold--
 			c.mu.sizeTest++
-			for c.size < c.mu.sizeTest && c.mu.handTest != nil {
+			for c.mu.size < c.mu.sizeTest && c.mu.handTest != nil {
 				c.runHandTest()
 			}
 		}
@@ -757,7 +887,7 @@ func (c *tableCacheShard) runHandCold() {
 
 	c.mu.handCold = c.mu.handCold.next()
 
-	for c.size-c.mu.coldTarget <= c.mu.sizeHot && c.mu.handHot != nil {
+	for c.mu.size-c.mu.coldTarget <= c.mu.sizeHot && c.mu.handHot != nil {
 		c.runHandHot()
 	}
 }
@@ -819,6 +949,7 @@ func (c *tableCacheShard) evict(fileNum FileNum, dbOpts *tableCacheOpts, allowLe
 		c.unlinkNode(n)
 		v = n.value
 		if v != nil {
+			atomic.AddInt64(&dbOpts.stats.count, -1)
 			if !allowLeak {
 				if t := atomic.AddInt32(&v.refCount, -1); t != 0 {
 					dbOpts.loggerAndTracer.Fatalf("sstable %s: refcount is not zero: %d\n%s", fileNum, t, debug.Stack())
@@ -889,6 +1020,7 @@ func (c *tableCacheShard) Close() error {
 	for c.mu.handHot != nil {
 		n := c.mu.handHot
 		if n.value != nil {
+			atomic.AddInt64(&n.stats.count, -1)
 			if atomic.AddInt32(&n.value.refCount, -1) == 0 {
 				c.releasing.Add(1)
 				c.releasingCh <- n.value
@@ -926,6 +1058,41 @@ type tableCacheValue struct {
 	// Reference count for the value. The reader is closed when the reference
 	// count drops to zero.
 	refCount int32
+	// rangeDels caches the fragmented range deletions of the table, which are
+	// read from the range deletion block on first use. This avoids reading and
+	// decoding the block on every iterator construction, which dominates the
+	// cost of opening iterators over tables with many range deletions.
+	rangeDels struct {
+		once  sync.Once
+		spans []keyspan.Span
+		err   error
+	}
+}
+
+// newRangeDelIter returns an iterator over the range deletions of the table,
+// or nil if the table has none. The iterator is backed by the range
+// deletions cached in the value, and does not read from the table.
+func (v *tableCacheValue) newRangeDelIter(cmp Compare) (keyspan.FragmentIterator, error) {
+	v.rangeDels.once.Do(func() {
+		iter, err := v.reader.NewRawRangeDelIter()
+		if err != nil || iter == nil {
+			v.rangeDels.err = err
+			return
+		}
+		// The spans surfaced by the iterator alias the range deletion block,
+		// which is released when the iterator is closed.
+		for s := iter.First(); s != nil; s = iter.Next() {
+			v.rangeDels.spans = append(v.rangeDels.spans, s.DeepClone())
+		}
+		v.rangeDels.err = firstError(iter.Error(), iter.Close())
+	})
+	if v.rangeDels.err != nil {
+		return nil, v.rangeDels.err
+	}
+	if len(v.rangeDels.spans) == 0 {
+		return nil, nil
+	}
+	return keyspan.NewIter(cmp, v.rangeDels.spans), nil
 }
 
 func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard, dbOpts *tableCacheOpts) {
@@ -934,6 +1101,9 @@ func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard, dbOpts *t
 	f, v.err = dbOpts.objProvider.OpenForReading(
 		context.TODO(), fileTypeTable, meta.FileNum, objstorage.OpenOptions{MustExist: true},
 	)
+	if objstorage.IsTooManyOpenFilesError(v.err) {
+		f, v.err = dbOpts.openAfterShrinking(meta, v.err)
+	}
 	if v.err == nil {
 		cacheOpts := private.SSTableCacheOpts(dbOpts.cacheID, meta.FileNum).(sstable.ReaderOption)
 		v.reader, v.err = sstable.NewReader(f, dbOpts.opts, cacheOpts, dbOpts.filterMetrics)
@@ -957,6 +1127,35 @@ func (v *tableCacheValue) load(meta *fileMetadata, c *tableCacheShard, dbOpts *t
 	close(v.loaded)
 }
 
+// tableCacheOpenRetries is the number of times the opening of a table is
+// retried after shrinking the table cache.
+const tableCacheOpenRetries = 5
+
+// openAfterShrinking handles the failure to open a table because the process
+// ran out of file descriptors: rather than failing the read, the table cache
+// is shrunk and the table is opened again.
+func (o *tableCacheOpts) openAfterShrinking(
+	meta *fileMetadata, err error,
+) (objstorage.Readable, error) {
+	from, to, ok := o.tableCache.shrink()
+	if !ok {
+		return nil, err
+	}
+	o.loggerAndTracer.Infof("table cache: %v; shrinking from %d to %d tables", err, from, to)
+	// The evicted tables are closed asynchronously (see
+	// tableCacheShard.releaseLoop), so the file descriptors may not be
+	// available yet.
+	for i := 0; ; i++ {
+		f, err := o.objProvider.OpenForReading(
+			context.TODO(), fileTypeTable, meta.FileNum, objstorage.OpenOptions{MustExist: true},
+		)
+		if !objstorage.IsTooManyOpenFilesError(err) || i == tableCacheOpenRetries {
+			return f, err
+		}
+		time.Sleep(time.Millisecond << i)
+	}
+}
+
 func (v *tableCacheValue) release(c *tableCacheShard) {
 	<-v.loaded
 	// Nothing to be done about an error at this point. Close the reader if it is
@@ -1003,6 +1202,9 @@ type tableCacheNode struct {
 	// Storing the cache id associated with the DB instance here
 	// avoids the need to thread the dbOpts struct through many functions.
 	cacheID uint64
+	// stats are the table cache counters of the DB instance, for the same
+	// reason.
+	stats *tableCacheStats
 }
 
 func (n *tableCacheNode) next() *tableCacheNode {
@@ -16,6 +16,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -39,6 +40,7 @@ func (f *tableCacheTestFile) Close() error {
 	if f.fs.closeCounts != nil {
 		f.fs.closeCounts[f.name]++
 	}
+	f.fs.open--
 	f.fs.mu.Unlock()
 	return f.File.Close()
 }
@@ -50,6 +52,10 @@ type tableCacheTestFS struct {
 	openCounts       map[string]int
 	closeCounts      map[string]int
 	openErrorEnabled bool
+	// open is the number of files currently open. Once it reaches maxOpen,
+	// if set, opening files fails with EMFILE.
+	open    int
+	maxOpen int
 }
 
 func (fs *tableCacheTestFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
@@ -58,6 +64,10 @@ func (fs *tableCacheTestFS) Open(name string, opts ...vfs.OpenOption) (vfs.File,
 		fs.mu.Unlock()
 		return nil, errors.New("injected error")
 	}
+	if fs.maxOpen > 0 && fs.open >= fs.maxOpen {
+		fs.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+	}
 	if fs.openCounts != nil {
 		fs.openCounts[name]++
 	}
@@ -69,6 +79,9 @@ func (fs *tableCacheTestFS) Open(name string, opts ...vfs.OpenOption) (vfs.File,
 	if err != nil {
 		return nil, err
 	}
+	fs.mu.Lock()
+	fs.open++
+	fs.mu.Unlock()
 	return &tableCacheTestFile{f, fs, name}, nil
 }
 
@@ -193,6 +206,7 @@ func newTableCacheContainerTest(
 
 	opts := &Options{}
 	opts.EnsureDefaults()
+	opts.LoggerAndTracer = &base.LoggerWithNoopTracer{Logger: opts.Logger}
 	if tc == nil {
 		opts.Cache = NewCache(8 << 20) // 8 MB
 		defer opts.Cache.Unref()
@@ -733,6 +747,84 @@ func TestSharedTableCacheEvictions(t *testing.T) {
 	}
 }
 
+func TestSharedTableCacheMetricsAndResize(t *testing.T) {
+	tc := newTableCacheTest(8<<20, tableCacheTestCacheSize, 1)
+	c1, fs1, err := newTableCacheContainerTest(tc, "")
+	require.NoError(t, err)
+	c2, fs2, err := newTableCacheContainerTest(tc, "")
+	require.NoError(t, err)
+	tc.Unref()
+
+	open := func(c *tableCacheContainer, fileNum int) {
+		iter, _, err := c.newIters(context.Background(), &fileMetadata{FileNum: FileNum(fileNum)}, nil, internalIterOpts{})
+		require.NoError(t, err)
+		require.NoError(t, iter.Close())
+	}
+	numOpen := func(fs *tableCacheTestFS) int {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		var n int
+		for name, gotO := range fs.openCounts {
+			n += gotO - fs.closeCounts[name]
+		}
+		return n
+	}
+
+	for i := 0; i < 10; i++ {
+		open(c1, i)
+	}
+	for i := 0; i < 10; i++ {
+		open(c2, i%5)
+	}
+
+	// The metrics of each container only account for its own tables.
+	m1, _ := c1.metrics()
+	m2, _ := c2.metrics()
+	require.Equal(t, int64(10), m1.Count)
+	require.Equal(t, int64(0), m1.Hits)
+	require.Equal(t, int64(10), m1.Misses)
+	require.Equal(t, int64(5), m2.Count)
+	require.Equal(t, int64(5), m2.Hits)
+	require.Equal(t, int64(5), m2.Misses)
+	require.Equal(t, int64(tableCacheTestCacheSize), m1.Capacity)
+	require.Equal(t, int64(0), m1.Evictions+m2.Evictions)
+
+	// Shrinking the table cache closes tables until it fits.
+	tc.SetSize(4)
+	m1, _ = c1.metrics()
+	m2, _ = c2.metrics()
+	require.Equal(t, int64(4), m1.Capacity)
+	require.LessOrEqual(t, m1.Count+m2.Count, int64(4))
+	require.Equal(t, 15-m1.Count-m2.Count, m1.Evictions+m2.Evictions)
+	require.NoError(t, try(100*time.Microsecond, 20*time.Second, func() error {
+		if n := numOpen(fs1) + numOpen(fs2); n > 4 {
+			return errors.Errorf("%d tables still open", n)
+		}
+		return nil
+	}))
+
+	// The cache remains usable at its new size, and can be grown again.
+	for i := 0; i < 20; i++ {
+		open(c1, i)
+	}
+	m1, _ = c1.metrics()
+	require.LessOrEqual(t, m1.Count, int64(4))
+	tc.SetSize(tableCacheTestCacheSize)
+	for i := 0; i < 20; i++ {
+		open(c1, i)
+	}
+	m1, _ = c1.metrics()
+	require.Equal(t, int64(20), m1.Count)
+
+	// Removing a table from the cache updates the count of its DB.
+	c1.evict(FileNum(0))
+	m1, _ = c1.metrics()
+	require.Equal(t, int64(19), m1.Count)
+
+	require.NoError(t, c1.close())
+	require.NoError(t, c2.close())
+}
+
 func TestTableCacheIterLeak(t *testing.T) {
 	c, _, err := newTableCacheContainerTest(nil, "")
 	require.NoError(t, err)
@@ -805,6 +897,33 @@ func TestTableCacheRetryAfterFailure(t *testing.T) {
 	fs.validate(t, c, nil)
 }
 
+func TestTableCacheShrinkOnTooManyOpenFiles(t *testing.T) {
+	c, fs, err := newTableCacheContainerTest(nil, "")
+	require.NoError(t, err)
+	openTable := func(fileNum FileNum) {
+		iter, _, err := c.newIters(context.Background(), &fileMetadata{FileNum: fileNum}, nil, internalIterOpts{})
+		require.NoError(t, err)
+		require.NoError(t, iter.Close())
+	}
+	for i := 0; i < 40; i++ {
+		openTable(FileNum(i))
+	}
+
+	// Running out of file descriptors shrinks the cache instead of failing
+	// the open.
+	fs.mu.Lock()
+	fs.maxOpen = fs.open
+	fs.mu.Unlock()
+	openTable(40)
+	capacity := atomic.LoadInt64(&c.tableCache.atomic.capacity)
+	require.Less(t, capacity, int64(tableCacheTestCacheSize))
+	require.LessOrEqual(t, capacity, int64(30))
+	for i := 41; i < 60; i++ {
+		openTable(FileNum(i))
+	}
+	fs.validate(t, c, nil)
+}
+
 func TestTableCacheEvictClose(t *testing.T) {
 	errs := make(chan error, 10)
 	db, err := Open("test",
@@ -866,6 +985,7 @@ func TestTableCacheClockPro(t *testing.T) {
 	dbOpts.cacheID = 0
 	dbOpts.objProvider = objProvider
 	dbOpts.opts = opts.MakeReaderOptions()
+	dbOpts.stats = &tableCacheStats{}
 
 	scanner := bufio.NewScanner(f)
 	tables := make(map[int]bool)
@@ -948,3 +1068,45 @@ func (tl *catchFatalLogger) Infof(format string, args ...interface{}) {}
 func (tl *catchFatalLogger) Fatalf(format string, args ...interface{}) {
 	tl.fatalMsgs = append(tl.fatalMsgs, fmt.Sprintf(format, args...))
 }
+
+func TestTableCacheRangeDelCache(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	require.NoError(t, d.DeleteRange([]byte("a"), []byte("c"), nil))
+	require.NoError(t, d.DeleteRange([]byte("b"), []byte("e"), nil))
+	require.NoError(t, d.Set([]byte("x"), nil, nil))
+	require.NoError(t, d.Flush())
+
+	d.mu.Lock()
+	files := d.mu.versions.currentVersion().Levels[0].Slice()
+	d.mu.Unlock()
+	require.Equal(t, 1, files.Len())
+	iter := files.Iter()
+	file := iter.First()
+
+	scan := func() string {
+		iter, rangeDelIter, err := d.newIters(context.Background(), file, nil, internalIterOpts{})
+		require.NoError(t, err)
+		require.NotNil(t, rangeDelIter)
+		var buf strings.Builder
+		for s := rangeDelIter.First(); s != nil; s = rangeDelIter.Next() {
+			fmt.Fprintf(&buf, "%s\n", s)
+		}
+		require.NoError(t, rangeDelIter.Close())
+		require.NoError(t, iter.Close())
+		return buf.String()
+	}
+	want := "a-b:{(#1,RANGEDEL)}\nb-c:{(#2,RANGEDEL)}\nc-e:{(#2,RANGEDEL)}\n"
+	require.Equal(t, want, scan())
+
+	// The range deletions are cached by the table cache, so they are not read
+	// from the table again.
+	shard := d.tableCache.tableCache.getShard(file.FileNum)
+	v := shard.findNode(file, &d.tableCache.dbOpts)
+	defer shard.unrefValue(v)
+	require.Len(t, v.rangeDels.spans, 3)
+	v.rangeDels.spans[0].End = []byte("bb")
+	require.Equal(t, "a-bb:{(#1,RANGEDEL)}\n"+want[len("a-b:{(#1,RANGEDEL)}\n"):], scan())
+}
@@ -5,8 +5,11 @@
 package pebble
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"sort"
+	"time"
 
 	"github.com/cockroachdb/pebble/internal/base"
 	"github.com/cockroachdb/pebble/internal/keyspan"
@@ -35,11 +38,14 @@ import (
 //
 // When an existing database is opened, all files lack in-memory statistics.
 // These files' stats are loaded incrementally whenever the pending list is
-// empty by scanning a current readState for files missing statistics. Once a
-// job completes a scan without finding any remaining files without
+// empty by scanning a current readState for files missing statistics, which
+// are queued by priority (see tableStatsQueue). The jobs loading the stats of
+// the queued files may be paced by Options.Experimental.TableStatsLoadRate.
+// Once a job completes a scan without finding any remaining files without
 // statistics, it flips a `loadedInitial` flag. From then on, the stats
 // collection job only needs to load statistics for new files appended to the
-// pending list.
+// pending list. DB.WaitForTableStats waits for the stats of all files to be
+// loaded.
 
 func (d *DB) maybeCollectTableStatsLocked() {
 	if d.shouldCollectTableStatsLocked() {
@@ -70,7 +76,68 @@ func (d *DB) shouldCollectTableStatsLocked() bool {
 	return !d.mu.tableStats.loading &&
 		d.closed.Load() == nil &&
 		!d.opts.private.disableTableStats &&
-		(len(d.mu.tableStats.pending) > 0 || !d.mu.tableStats.loadedInitial)
+		(len(d.mu.tableStats.pending) > 0 ||
+			(!d.mu.tableStats.loadedInitial && !d.mu.tableStats.paced))
+}
+
+// paceTableStatsScanLocked delays the next scan for files lacking stats after
+// a scan job loaded the stats of n files, according to
+// Options.Experimental.TableStatsLoadRate. The stats of pending new files are
+// loaded in the meantime.
+func (d *DB) paceTableStatsScanLocked(n int) {
+	rate := d.opts.Experimental.TableStatsLoadRate
+	if rate <= 0 || n == 0 || d.mu.tableStats.loadedInitial {
+		return
+	}
+	d.mu.tableStats.paced = true
+	time.AfterFunc(time.Duration(n)*time.Second/time.Duration(rate), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.mu.tableStats.paced = false
+		d.maybeCollectTableStatsLocked()
+	})
+}
+
+// WaitForTableStats blocks until the table stats of all the sstables of the
+// DB have been loaded, including those of the sstables which existed at Open,
+// or until the context is done, returning its error. Table stats inform the
+// compaction heuristics, e.g. the prioritization of the sstables whose
+// deletions reclaim the most disk space, and metrics such as
+// Metrics.Keys.TombstoneCount. It returns immediately if table stats are
+// not collected, i.e. if they are disabled or the DB is read-only.
+func (d *DB) WaitForTableStats(ctx context.Context) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.ReadOnly || d.opts.private.disableTableStats {
+		return nil
+	}
+	// Wake up the waiter below when the context is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			d.mu.Lock()
+			d.mu.tableStats.cond.Broadcast()
+			d.mu.Unlock()
+		case <-done:
+		}
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.mu.tableStats.loading || len(d.mu.tableStats.pending) > 0 ||
+		!d.mu.tableStats.loadedInitial {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.closed.Load(); err != nil {
+			return err.(error)
+		}
+		d.mu.tableStats.cond.Wait()
+	}
+	return nil
 }
 
 // collectTableStats runs a table stats collection job, returning true if the
@@ -102,7 +169,8 @@ func (d *DB) collectTableStats() bool {
 	rs := d.loadReadState()
 	var collected []collectedStats
 	var hints []deleteCompactionHint
-	if len(pending) > 0 {
+	scanned := len(pending) == 0
+	if !scanned {
 		collected, hints = d.loadNewFileStats(rs, pending)
 	} else {
 		var moreRemain bool
@@ -129,7 +197,13 @@ func (d *DB) collectTableStats() bool {
 		maybeCompact = maybeCompact || c.TableStats.RangeDeletionsBytesEstimate > 0
 		c.fileMetadata.StatsMarkValid()
 	}
+	if len(collected) > 0 && d.opts.Experimental.TenantQuotas != nil {
+		d.updateTenantUsageLocked(true /* force */)
+	}
 	d.mu.tableStats.cond.Broadcast()
+	if scanned {
+		d.paceTableStatsScanLocked(len(collected))
+	}
 	d.maybeCollectTableStatsLocked()
 	if len(hints) > 0 && !d.opts.private.disableDeleteOnlyCompactions {
 		// Verify that all of the hint tombstones' files still exist in the
@@ -205,49 +279,86 @@ func (d *DB) loadNewFileStats(
 
 // scanReadStateTableStats is run by an active stat collection job when there
 // are no pending new files, but there might be files that existed at Open for
-// which we haven't loaded table stats.
+// which we haven't loaded table stats. It loads the stats of the files of
+// d.mu.tableStats.queue, scanning the readState's version to fill the queue
+// again once it's exhausted. It returns false for the last return value if
+// no other files lack stats.
 func (d *DB) scanReadStateTableStats(
 	rs *readState, fill []collectedStats,
 ) ([]collectedStats, []deleteCompactionHint, bool) {
-	moreRemain := false
+	// NB: We're not holding d.mu which protects f.Stats and the queue, but
+	// only the active stats collection job updates f.Stats for active files
+	// and accesses the queue, and we ensure only one goroutine runs it at a
+	// time through d.mu.tableStats.loading. This makes it safe to read
+	// validity through f.Stats.ValidLocked despite not holding d.mu.
+	queue := d.mu.tableStats.queue
+	if len(queue) == 0 {
+		queue = tableStatsQueue(rs.current, nil /* exclude */)
+	}
 	var hints []deleteCompactionHint
-	for l, levelMetadata := range rs.current.Levels {
-		iter := levelMetadata.Iter()
-		for f := iter.First(); f != nil; f = iter.Next() {
-			// NB: We're not holding d.mu which protects f.Stats, but only the
-			// active stats collection job updates f.Stats for active files,
-			// and we ensure only one goroutine runs it at a time through
-			// d.mu.tableStats.loading. This makes it safe to read validity
-			// through f.Stats.ValidLocked despite not holding d.mu.
-			if f.StatsValidLocked() {
-				continue
-			}
+	// Limit how much work we do per read state. The older the read state is,
+	// the higher the likelihood files are no longer being used in the current
+	// version.
+	for len(queue) > 0 && len(fill) < cap(fill) {
+		nf := queue[0]
+		queue = queue[1:]
+		// The file may have been deleted or moved since the queue was filled,
+		// in which case its stats are loaded from the pending list if needed.
+		if nf.Meta.StatsValidLocked() || !rs.current.Contains(nf.Level, d.cmp, nf.Meta) {
+			continue
+		}
+		stats, newHints, err := d.loadTableStats(rs.current, nf.Level, nf.Meta)
+		if err != nil {
+			// The file remains without stats, so it will be queued again and
+			// we'll try again.
+			d.opts.EventListener.BackgroundError(err)
+			continue
+		}
+		fill = append(fill, collectedStats{
+			fileMetadata: nf.Meta,
+			TableStats:   stats,
+		})
+		hints = append(hints, newHints...)
+	}
+	if len(queue) == 0 {
+		// Scan the version again for the files which still lack stats, e.g.
+		// files which failed to load or were moved since the queue was filled.
+		queue = tableStatsQueue(rs.current, fill)
+	}
+	d.mu.tableStats.queue = queue
+	return fill, hints, len(queue) > 0
+}
 
-			// Limit how much work we do per read state. The older the read
-			// state is, the higher the likelihood files are no longer being
-			// used in the current version. If we've exhausted our allowance,
-			// return true for the last return value to signal there's more
-			// work to do.
-			if len(fill) == cap(fill) {
-				moreRemain = true
-				return fill, hints, moreRemain
+// tableStatsQueue returns the files of the version lacking stats, except the
+// files of exclude, in the order in which their stats are loaded: the files of
+// the lower levels first, which hold most of the data, and the largest files
+// first within a level, so that the compaction heuristics relying on table
+// stats (e.g. the prioritization of the files with range deletions) are
+// informed about most of the data as soon as possible.
+func tableStatsQueue(v *version, exclude []collectedStats) []manifest.NewFileEntry {
+	excluded := func(f *fileMetadata) bool {
+		for i := range exclude {
+			if exclude[i].fileMetadata == f {
+				return true
 			}
-
-			stats, newHints, err := d.loadTableStats(rs.current, l, f)
-			if err != nil {
-				// Set `moreRemain` so we'll try again.
-				moreRemain = true
-				d.opts.EventListener.BackgroundError(err)
-				continue
+		}
+		return false
+	}
+	var queue []manifest.NewFileEntry
+	for l := len(v.Levels) - 1; l >= 0; l-- {
+		n := len(queue)
+		iter := v.Levels[l].Iter()
+		for f := iter.First(); f != nil; f = iter.Next() {
+			if !f.StatsValidLocked() && !excluded(f) {
+				queue = append(queue, manifest.NewFileEntry{Level: l, Meta: f})
 			}
-			fill = append(fill, collectedStats{
-				fileMetadata: f,
-				TableStats:   stats,
-			})
-			hints = append(hints, newHints...)
 		}
+		level := queue[n:]
+		sort.SliceStable(level, func(i, j int) bool {
+			return level[i].Meta.Size > level[j].Meta.Size
+		})
 	}
-	return fill, hints, moreRemain
+	return queue
 }
 
 func (d *DB) loadTableStats(
@@ -256,14 +367,16 @@ func (d *DB) loadTableStats(
 	var stats manifest.TableStats
 	var compactionHints []deleteCompactionHint
 	err := d.tableCache.withReader(meta, func(r *sstable.Reader) (err error) {
-		stats.NumEntries = r.Properties.NumEntries
-		stats.NumDeletions = r.Properties.NumDeletions
-		if r.Properties.NumPointDeletions() > 0 {
-			if err = d.loadTablePointKeyStats(r, v, level, meta, &stats); err != nil {
+		props := tableProperties(meta, &r.Properties)
+		stats.NumEntries = props.NumEntries
+		stats.NumDeletions = props.NumDeletions
+		stats.NumPointDeletions = props.NumPointDeletions()
+		if props.NumPointDeletions() > 0 {
+			if err = d.loadTablePointKeyStats(props, v, level, meta, &stats); err != nil {
 				return
 			}
 		}
-		if r.Properties.NumRangeDeletions > 0 || r.Properties.NumRangeKeyDels > 0 {
+		if props.NumRangeDeletions > 0 || props.NumRangeKeyDels > 0 {
 			if compactionHints, err = d.loadTableRangeDelStats(r, v, level, meta, &stats); err != nil {
 				return
 			}
@@ -271,8 +384,10 @@ func (d *DB) loadTableStats(
 		// TODO(travers): Once we have real-world data, consider collecting
 		// additional stats that may provide improved heuristics for compaction
 		// picking.
-		stats.NumRangeKeySets = r.Properties.NumRangeKeySets
-		stats.ValueBlocksSize = r.Properties.ValueBlocksSize
+		stats.NumRangeKeySets = props.NumRangeKeySets
+		stats.ValueBlocksSize = props.ValueBlocksSize
+		stats.UncompressedSize = uncompressedSize(props)
+		stats.TenantBytes = decodeTenantBytes(r.Properties.UserProperties[tenantUsagePropertyName], meta.Size)
 		return
 	})
 	if err != nil {
@@ -284,7 +399,11 @@ func (d *DB) loadTableStats(
 // loadTablePointKeyStats calculates the point key statistics for the given
 // table. The provided manifest.TableStats are updated.
 func (d *DB) loadTablePointKeyStats(
-	r *sstable.Reader, v *version, level int, meta *fileMetadata, stats *manifest.TableStats,
+	props *sstable.Properties,
+	v *version,
+	level int,
+	meta *fileMetadata,
+	stats *manifest.TableStats,
 ) error {
 	// TODO(jackson): If the file has a wide keyspace, the average
 	// value size beneath the entire file might not be representative
@@ -297,7 +416,7 @@ func (d *DB) loadTablePointKeyStats(
 		return err
 	}
 	stats.PointDeletionsBytesEstimate =
-		pointDeletionsBytesEstimate(&r.Properties, avgKeySize, avgValSize)
+		pointDeletionsBytesEstimate(props, avgKeySize, avgValSize)
 	return nil
 }
 
@@ -339,7 +458,7 @@ func (d *DB) loadTableRangeDelStats(
 		// the size of the range key block relative to the overall size of the
 		// table is expected to be small.
 		if hasPoints && level == numLevels-1 {
-			size, err := r.EstimateDiskUsage(start, end)
+			size, err := estimateTableDiskUsage(d.cmp, r, meta, start, end)
 			if err != nil {
 				return nil, err
 			}
@@ -383,6 +502,50 @@ func (d *DB) loadTableRangeDelStats(
 	return compactionHints, err
 }
 
+// estimateShadowedBytes estimates the disk space used by the keys deleted by
+// the range deletions of an sstable within the range [start, end), as
+// loadTableRangeDelStats does for the whole sstable.
+func (d *DB) estimateShadowedBytes(
+	r *sstable.Reader, v *version, level int, meta *fileMetadata, start, end []byte,
+) (uint64, error) {
+	iter, err := newCombinedDeletionKeyspanIter(d.opts.Comparer, r, meta)
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	var estimate uint64
+	for s := iter.SeekGE(start); s != nil && d.cmp(s.Start, end) < 0; s = iter.Next() {
+		var hasPoints bool
+		for _, k := range s.Keys {
+			if k.Kind() == base.InternalKeyKindRangeDelete {
+				hasPoints = true
+				break
+			}
+		}
+		if !hasPoints {
+			continue
+		}
+		spanStart, spanEnd := s.Start, s.End
+		if d.cmp(spanStart, start) < 0 {
+			spanStart = start
+		}
+		if d.cmp(spanEnd, end) > 0 {
+			spanEnd = end
+		}
+		var size uint64
+		if level == numLevels-1 {
+			size, err = estimateTableDiskUsage(d.cmp, r, meta, spanStart, spanEnd)
+		} else {
+			size, _, err = d.estimateReclaimedSizeBeneath(v, level, spanStart, spanEnd, compactionHintFromKeys(s.Keys))
+		}
+		if err != nil {
+			return 0, err
+		}
+		estimate += size
+	}
+	return estimate, nil
+}
+
 func (d *DB) averageEntrySizeBeneath(
 	v *version, level int, meta *fileMetadata,
 ) (avgKeySize, avgValueSize uint64, err error) {
@@ -395,10 +558,11 @@ func (d *DB) averageEntrySizeBeneath(
 		iter := overlaps.Iter()
 		for file := iter.First(); file != nil; file = iter.Next() {
 			err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
+				props := tableProperties(file, &r.Properties)
 				fileSum += file.Size
-				entryCount += r.Properties.NumEntries
-				keySum += r.Properties.RawKeySize
-				valSum += r.Properties.RawValueSize
+				entryCount += props.NumEntries
+				keySum += props.RawKeySize
+				valSum += props.RawValueSize
 				return nil
 			})
 			if err != nil {
@@ -503,7 +667,7 @@ func (d *DB) estimateReclaimedSizeBeneath(
 				}
 				var size uint64
 				err := d.tableCache.withReader(file, func(r *sstable.Reader) (err error) {
-					size, err = r.EstimateDiskUsage(start, end)
+					size, err = estimateTableDiskUsage(d.cmp, r, file, start, end)
 					return err
 				})
 				if err != nil {
@@ -553,10 +717,13 @@ func maybeSetStatsFromProperties(meta *fileMetadata, props *sstable.Properties)
 
 	meta.Stats.NumEntries = props.NumEntries
 	meta.Stats.NumDeletions = props.NumDeletions
+	meta.Stats.NumPointDeletions = props.NumPointDeletions()
 	meta.Stats.NumRangeKeySets = props.NumRangeKeySets
 	meta.Stats.PointDeletionsBytesEstimate = pointEstimate
 	meta.Stats.RangeDeletionsBytesEstimate = 0
 	meta.Stats.ValueBlocksSize = props.ValueBlocksSize
+	meta.Stats.UncompressedSize = uncompressedSize(props)
+	meta.Stats.TenantBytes = decodeTenantBytes(props.UserProperties[tenantUsagePropertyName], meta.Size)
 	meta.StatsMarkValid()
 	return true
 }
@@ -740,6 +907,9 @@ func newCombinedDeletionKeyspanIter(
 		return nil, err
 	}
 	if iter != nil {
+		if m.Virtual != nil {
+			iter = truncateToVirtualTable(comparer.Compare, m, iter)
+		}
 		// Wrap the range key iterator in a filter that elides keys other than range
 		// key deletions.
 		iter = keyspan.Filter(iter, func(in *keyspan.Span, out *keyspan.Span) (keep bool) {
@@ -902,3 +1072,69 @@ func valueBlocksSizeForLevel(v *version, level int) (count uint64) {
 	}
 	return *v.Levels[level].Annotation(valueBlocksSizeAnnotator{}).(*uint64)
 }
+
+// uncompressedSize returns the size of the keys and values of an sstable
+// before their encoding and compression, from its properties.
+func uncompressedSize(props *sstable.Properties) uint64 {
+	return props.RawKeySize + props.RawValueSize +
+		props.RawRangeKeyKeySize + props.RawRangeKeyValueSize
+}
+
+// compressionSizes is the annotation of compressionAnnotator: the sums of the
+// uncompressed sizes and of the sizes of the files whose stats are loaded.
+type compressionSizes struct {
+	uncompressed uint64
+	size         uint64
+}
+
+// compressionAnnotator implements manifest.Annotator, annotating B-Tree nodes
+// with the sums of the files' TableStats.UncompressedSize and sizes, for the
+// files whose table stats are loaded. Its annotation type is a
+// *compressionSizes. Its values are marked as cacheable only if a file's stats
+// have been loaded.
+type compressionAnnotator struct{}
+
+var _ manifest.Annotator = compressionAnnotator{}
+
+func (a compressionAnnotator) Zero(dst interface{}) interface{} {
+	if dst == nil {
+		return new(compressionSizes)
+	}
+	v := dst.(*compressionSizes)
+	*v = compressionSizes{}
+	return v
+}
+
+func (a compressionAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (v interface{}, cacheOK bool) {
+	vptr := dst.(*compressionSizes)
+	if !f.StatsValidLocked() {
+		return vptr, false
+	}
+	vptr.uncompressed += f.Stats.UncompressedSize
+	vptr.size += f.Size
+	return vptr, true
+}
+
+func (a compressionAnnotator) Merge(src interface{}, dst interface{}) interface{} {
+	srcV := src.(*compressionSizes)
+	dstV := dst.(*compressionSizes)
+	dstV.uncompressed += srcV.uncompressed
+	dstV.size += srcV.size
+	return dstV
+}
+
+// compressionSizesForLevel returns the sums of TableStats.UncompressedSize and
+// of the sizes of the files of a level of the LSM whose table stats have been
+// loaded. It uses a b-tree annotator to cache intermediate values between
+// calculations when possible. It must not be called concurrently.
+//
+// REQUIRES: 0 <= level <= numLevels.
+func compressionSizesForLevel(v *version, level int) (uncompressed, size uint64) {
+	if v.Levels[level].Empty() {
+		return 0, 0
+	}
+	s := v.Levels[level].Annotation(compressionAnnotator{}).(*compressionSizes)
+	return s.uncompressed, s.size
+}
@@ -6,9 +6,11 @@ package pebble
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/datadriven"
 	"github.com/cockroachdb/pebble/internal/base"
@@ -143,6 +145,63 @@ func TestTableStats(t *testing.T) {
 	})
 }
 
+func TestWaitForTableStats(t *testing.T) {
+	mem := vfs.NewMem()
+	opts := &Options{FS: mem, DisableAutomaticCompactions: true}
+	d, err := Open("", opts)
+	require.NoError(t, err)
+	// Write 60 L0 sstables of increasing sizes, and move the first one to L6.
+	const numTables = 60
+	for i := 0; i < numTables; i++ {
+		for j := 0; j <= i; j++ {
+			require.NoError(t, d.Set([]byte(fmt.Sprintf("%03d-%03d", i, j)), nil, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	require.NoError(t, d.Compact([]byte("000"), []byte("000-999"), false /* parallelize */))
+	require.NoError(t, d.Close())
+
+	// Read-only DBs do not collect table stats, so there is nothing to wait
+	// for.
+	opts.ReadOnly = true
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	require.NoError(t, d.WaitForTableStats(context.Background()))
+	require.NoError(t, d.Close())
+	opts.ReadOnly = false
+
+	// Pace the loading of the stats of the tables existing at Open, so that
+	// the stats of the first 50 tables are loaded before a pause of 500ms.
+	opts.Experimental.TableStatsLoadRate = 100
+	d, err = Open("", opts)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// The tables of the lower levels, and then the largest tables, come first.
+	d.mu.Lock()
+	queue := tableStatsQueue(d.mu.versions.currentVersion(), nil /* exclude */)
+	d.mu.Unlock()
+	require.Len(t, queue, numTables)
+	require.Equal(t, 6, queue[0].Level)
+	require.Equal(t, "000-000", string(queue[0].Meta.Smallest.UserKey))
+	require.Equal(t, fmt.Sprintf("%03d-000", numTables-1), string(queue[1].Meta.Smallest.UserKey))
+	for i := 2; i < len(queue); i++ {
+		require.Equal(t, 0, queue[i].Level)
+		require.LessOrEqual(t, queue[i].Meta.Size, queue[i-1].Meta.Size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, d.WaitForTableStats(ctx))
+	require.NoError(t, d.WaitForTableStats(context.Background()))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	require.True(t, d.mu.tableStats.loadedInitial)
+	for _, nf := range queue {
+		require.True(t, nf.Meta.StatsValidLocked())
+	}
+}
+
 func TestTableRangeDeletionIter(t *testing.T) {
 	var m *fileMetadata
 	cmp := base.DefaultComparer.Compare
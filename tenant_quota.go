@@ -0,0 +1,324 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/pebble/internal/humanize"
+	"github.com/cockroachdb/pebble/internal/manifest"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// tenantUsagePropertyName is the name of the table property recording the
+// bytes of the keys and values of each tenant of an sstable, when
+// Options.Experimental.TenantQuotas is set.
+const tenantUsagePropertyName = "pebble.tenant.usage"
+
+// TenantQuotas configures the tracking of the disk space used by the keys of
+// each tenant of a multi-tenant store, and the enforcement of per-tenant
+// quotas (see Options.Experimental.TenantQuotas).
+//
+// The bytes of the keys and values of each tenant are recorded in a table
+// property of every sstable when it is written, and are loaded with the table
+// stats. The usage of a tenant is the sum over the sstables of the LSM of
+// their size apportioned by the bytes of the tenant, so it accounts for
+// compression and for the obsolete versions of the keys which compactions
+// have not yet dropped. The keys in the memtables and the sstables written
+// without TenantQuotas are not accounted for.
+type TenantQuotas struct {
+	// KeyTenant returns the ID of the tenant owning a user key, e.g. a
+	// prefix of the key, or false if the key belongs to no tenant. The
+	// returned ID must not be modified, and may alias the key.
+	KeyTenant func(userKey []byte) (tenant []byte, ok bool)
+	// Quota returns the maximum disk space the keys of a tenant may use, or
+	// zero if the tenant has no quota.
+	Quota func(tenant []byte) uint64
+	// FlagOnly makes the quotas advisory: the writes of the tenants over
+	// their quota are not rejected, and the tenants are only reported to
+	// EventListener.TenantQuotaExceeded.
+	FlagOnly bool
+}
+
+// TenantQuotaExceededError is returned by the writes which set keys of a
+// tenant whose usage reached its quota (see TenantQuotas). Deletions are not
+// rejected, so that the tenant can free space.
+type TenantQuotaExceededError struct {
+	// Tenant is the ID of the tenant.
+	Tenant string
+	// Usage is the estimated disk space used by the keys of the tenant.
+	Usage uint64
+	// Quota is the quota of the tenant.
+	Quota uint64
+}
+
+func (e *TenantQuotaExceededError) Error() string {
+	return fmt.Sprintf("pebble: tenant %q exceeds its quota: %s used, quota %s",
+		e.Tenant, humanize.Uint64(e.Usage), humanize.Uint64(e.Quota))
+}
+
+// TenantUsage returns the estimated disk space used by the keys of each
+// tenant, keyed by tenant ID, or nil if Options.Experimental.TenantQuotas is
+// not set. The usage only accounts for the sstables whose table stats are
+// loaded.
+func (d *DB) TenantUsage() map[string]uint64 {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	if d.opts.Experimental.TenantQuotas == nil {
+		return nil
+	}
+	d.reportTenantQuotasExceeded()
+	u := d.tenants.usage.Load()
+	usage := make(map[string]uint64, len(u.usage))
+	for tenant, bytes := range u.usage {
+		usage[tenant] = bytes
+	}
+	return usage
+}
+
+// tenantUsage is the usage of the tenants computed from a version and the
+// table stats loaded at the time. It is immutable once published.
+type tenantUsage struct {
+	version *version
+	usage   map[string]uint64
+	// over holds the tenants whose usage reached their quota, with their
+	// quota.
+	over map[string]uint64
+}
+
+// updateTenantUsageLocked computes the usage of the tenants for the current
+// version again, if it changed or force is set (e.g. because table stats
+// were loaded), and publishes it for the writes to check their quotas. The
+// tenants which reached their quota since the last computation are queued to
+// be reported to EventListener.TenantQuotaExceeded once d.mu is released (see
+// reportTenantQuotasExceeded). d.mu must be held.
+func (d *DB) updateTenantUsageLocked(force bool) {
+	current := d.mu.versions.currentVersion()
+	prev := d.tenants.usage.Load()
+	if prev != nil && prev.version == current && !force {
+		return
+	}
+	u := &tenantUsage{
+		version: current,
+		usage:   make(map[string]uint64),
+		over:    make(map[string]uint64),
+	}
+	for level := range current.Levels {
+		if current.Levels[level].Empty() {
+			continue
+		}
+		// The annotations of the B-Tree nodes shared with the previous
+		// versions are cached, so only the modified nodes are visited.
+		v := current.Levels[level].Annotation(tenantUsageAnnotator{}).(map[string]uint64)
+		for tenant, bytes := range v {
+			u.usage[tenant] += bytes
+		}
+	}
+	quota := d.opts.Experimental.TenantQuotas.Quota
+	var exceeded []TenantQuotaInfo
+	for tenant, usage := range u.usage {
+		if q := quota([]byte(tenant)); q > 0 && usage >= q {
+			u.over[tenant] = q
+			if _, ok := prev.overQuota(tenant); !ok {
+				exceeded = append(exceeded, TenantQuotaInfo{
+					Tenant:   tenant,
+					Usage:    usage,
+					Quota:    q,
+					Rejected: !d.opts.Experimental.TenantQuotas.FlagOnly,
+				})
+			}
+		}
+	}
+	d.tenants.usage.Store(u)
+	if len(exceeded) > 0 {
+		d.tenants.exceeded.Lock()
+		d.tenants.exceeded.pending = append(d.tenants.exceeded.pending, exceeded...)
+		d.tenants.exceeded.Unlock()
+		d.tenants.exceededPending.Store(true)
+	}
+}
+
+// reportTenantQuotasExceeded reports the tenants which reached their quota
+// since the last report to EventListener.TenantQuotaExceeded. d.mu must not
+// be held.
+func (d *DB) reportTenantQuotasExceeded() {
+	if !d.tenants.exceededPending.Load() {
+		return
+	}
+	d.tenants.exceeded.Lock()
+	pending := d.tenants.exceeded.pending
+	d.tenants.exceeded.pending = nil
+	d.tenants.exceededPending.Store(false)
+	d.tenants.exceeded.Unlock()
+	for _, info := range pending {
+		d.opts.EventListener.TenantQuotaExceeded(info)
+	}
+}
+
+func (u *tenantUsage) overQuota(tenant string) (quota uint64, ok bool) {
+	if u == nil {
+		return 0, false
+	}
+	quota, ok = u.over[tenant]
+	return quota, ok
+}
+
+// checkTenantQuotas returns a *TenantQuotaExceededError if the batch sets a
+// key of a tenant whose usage reached its quota.
+func (d *DB) checkTenantQuotas(b *Batch) error {
+	q := d.opts.Experimental.TenantQuotas
+	// The tenants which reached their quota are reported by the writes, even
+	// if they are not rejected.
+	d.reportTenantQuotasExceeded()
+	u := d.tenants.usage.Load()
+	if q.FlagOnly || u == nil || len(u.over) == 0 {
+		return nil
+	}
+	for r := b.Reader(); ; {
+		kind, ukey, _, ok := r.Next()
+		if !ok {
+			return nil
+		}
+		switch kind {
+		case InternalKeyKindDelete, InternalKeyKindSingleDelete, InternalKeyKindRangeDelete,
+			InternalKeyKindRangeKeyUnset, InternalKeyKindRangeKeyDelete, InternalKeyKindLogData:
+			continue
+		}
+		tenant, ok := q.KeyTenant(ukey)
+		if !ok {
+			continue
+		}
+		if quota, ok := u.overQuota(string(tenant)); ok {
+			return &TenantQuotaExceededError{
+				Tenant: string(tenant),
+				Usage:  u.usage[string(tenant)],
+				Quota:  quota,
+			}
+		}
+	}
+}
+
+// tenantUsageAnnotator implements manifest.Annotator, annotating B-Tree nodes
+// with the sums of the files' TableStats.TenantBytes. Its annotation type is
+// a map[string]uint64. The tenant bytes are only known once a table's stats
+// are loaded, so its values are marked as cacheable only if a file's stats
+// have been loaded.
+type tenantUsageAnnotator struct{}
+
+var _ manifest.Annotator = tenantUsageAnnotator{}
+
+func (a tenantUsageAnnotator) Zero(dst interface{}) interface{} {
+	if dst == nil {
+		return make(map[string]uint64)
+	}
+	v := dst.(map[string]uint64)
+	for tenant := range v {
+		delete(v, tenant)
+	}
+	return v
+}
+
+func (a tenantUsageAnnotator) Accumulate(
+	f *fileMetadata, dst interface{},
+) (v interface{}, cacheOK bool) {
+	vm := dst.(map[string]uint64)
+	if !f.StatsValidLocked() {
+		return vm, false
+	}
+	for tenant, bytes := range f.Stats.TenantBytes {
+		vm[tenant] += bytes
+	}
+	return vm, true
+}
+
+func (a tenantUsageAnnotator) Merge(src interface{}, dst interface{}) interface{} {
+	srcM := src.(map[string]uint64)
+	dstM := dst.(map[string]uint64)
+	for tenant, bytes := range srcM {
+		dstM[tenant] += bytes
+	}
+	return dstM
+}
+
+// newTenantUsageCollector returns a table property collector recording the
+// bytes of the keys and values of each tenant.
+func newTenantUsageCollector(keyTenant func(userKey []byte) ([]byte, bool)) TablePropertyCollector {
+	return &tenantUsageCollector{keyTenant: keyTenant, bytes: make(map[string]uint64)}
+}
+
+// tenantUsageCollector collects the bytes of the keys and values of each
+// tenant, and of all the entries.
+type tenantUsageCollector struct {
+	keyTenant func(userKey []byte) ([]byte, bool)
+	total     uint64
+	bytes     map[string]uint64
+}
+
+var _ sstable.TablePropertyCollector = (*tenantUsageCollector)(nil)
+
+func (c *tenantUsageCollector) Add(key InternalKey, value []byte) error {
+	n := uint64(key.Size() + len(value))
+	c.total += n
+	if tenant, ok := c.keyTenant(key.UserKey); ok {
+		c.bytes[string(tenant)] += n
+	}
+	return nil
+}
+
+// Finish encodes the total bytes of the entries, followed by the ID and the
+// bytes of each tenant in sorted order.
+func (c *tenantUsageCollector) Finish(userProps map[string]string) error {
+	if len(c.bytes) == 0 {
+		return nil
+	}
+	tenants := make([]string, 0, len(c.bytes))
+	for tenant := range c.bytes {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	buf := binary.AppendUvarint(nil, c.total)
+	for _, tenant := range tenants {
+		buf = binary.AppendUvarint(buf, uint64(len(tenant)))
+		buf = append(buf, tenant...)
+		buf = binary.AppendUvarint(buf, c.bytes[tenant])
+	}
+	userProps[tenantUsagePropertyName] = string(buf)
+	return nil
+}
+
+func (c *tenantUsageCollector) Name() string {
+	return tenantUsagePropertyName
+}
+
+// decodeTenantBytes decodes the tenant usage property of an sstable of the
+// given size, apportioning the size by the bytes of each tenant. It returns
+// nil if the property is empty (i.e. missing) or malformed.
+func decodeTenantBytes(prop string, size uint64) map[string]uint64 {
+	buf := []byte(prop)
+	total, n := binary.Uvarint(buf)
+	if n <= 0 || total == 0 {
+		return nil
+	}
+	buf = buf[n:]
+	res := make(map[string]uint64)
+	for len(buf) > 0 {
+		l, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < l {
+			return nil
+		}
+		tenant := string(buf[n : n+int(l)])
+		buf = buf[n+int(l):]
+		bytes, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil
+		}
+		buf = buf[n:]
+		res[tenant] = uint64(float64(size) * (float64(bytes) / float64(total)))
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantQuotas(t *testing.T) {
+	for _, flagOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("flag-only=%t", flagOnly), func(t *testing.T) {
+			var events []TenantQuotaInfo
+			opts := &Options{
+				FS:                          vfs.NewMem(),
+				DisableAutomaticCompactions: true,
+				EventListener: &EventListener{
+					TenantQuotaExceeded: func(info TenantQuotaInfo) {
+						events = append(events, info)
+					},
+				},
+			}
+			// The tenant of a key is its prefix up to a "/".
+			opts.Experimental.TenantQuotas = &TenantQuotas{
+				KeyTenant: func(userKey []byte) ([]byte, bool) {
+					if i := bytes.IndexByte(userKey, '/'); i >= 0 {
+						return userKey[:i], true
+					}
+					return nil, false
+				},
+				Quota: func(tenant []byte) uint64 {
+					if string(tenant) == "t1" {
+						return 10 << 10
+					}
+					return 0
+				},
+				FlagOnly: flagOnly,
+			}
+			d, err := Open("", opts)
+			require.NoError(t, err)
+			defer func() { require.NoError(t, d.Close()) }()
+
+			rng := rand.New(rand.NewSource(0))
+			value := make([]byte, 200)
+			for _, tenant := range []string{"t1", "t2"} {
+				for i := 0; i < 100; i++ {
+					rng.Read(value)
+					require.NoError(t, d.Set([]byte(fmt.Sprintf("%s/%03d", tenant, i)), value, nil))
+				}
+			}
+			require.NoError(t, d.Set([]byte("untenanted"), value, nil))
+			require.NoError(t, d.Flush())
+			d.mu.Lock()
+			d.waitTableStats()
+			d.mu.Unlock()
+
+			usage := d.TenantUsage()
+			require.Len(t, usage, 2)
+			require.Greater(t, usage["t1"], uint64(10<<10))
+			require.InDelta(t, usage["t1"], usage["t2"], float64(usage["t1"])/10)
+			tables, err := d.SSTables()
+			require.NoError(t, err)
+			require.LessOrEqual(t, usage["t1"]+usage["t2"], tables[0][0].Size)
+
+			// The tenant t1 exceeds its quota: its writes are rejected, but not
+			// its deletions.
+			err = d.Set([]byte("t1/new"), value, nil)
+			if flagOnly {
+				require.NoError(t, err)
+			} else {
+				var quotaErr *TenantQuotaExceededError
+				require.True(t, errors.As(err, &quotaErr))
+				require.Equal(t, "t1", quotaErr.Tenant)
+				require.Equal(t, usage["t1"], quotaErr.Usage)
+				require.Equal(t, uint64(10<<10), quotaErr.Quota)
+			}
+			require.NoError(t, d.Set([]byte("t2/new"), value, nil))
+			require.NoError(t, d.Delete([]byte("t1/000"), nil))
+			require.Equal(t, []TenantQuotaInfo{{
+				Tenant:   "t1",
+				Usage:    usage["t1"],
+				Quota:    10 << 10,
+				Rejected: !flagOnly,
+			}}, events)
+
+			// Once the keys of t1 are deleted and compacted, t1 can write again.
+			require.NoError(t, d.DeleteRange([]byte("t1/"), []byte("t10"), nil))
+			require.NoError(t, d.Compact([]byte("t"), []byte("u"), false /* parallelize */))
+			d.mu.Lock()
+			d.waitTableStats()
+			d.mu.Unlock()
+			require.Equal(t, uint64(0), d.TenantUsage()["t1"])
+			require.NoError(t, d.Set([]byte("t1/new"), value, nil))
+			require.Len(t, events, 1)
+		})
+	}
+}
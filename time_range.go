@@ -0,0 +1,70 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"math"
+
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// timestampsPropertyName is the name of the block property recording the
+// interval of the timestamps of the point keys of each block and table, when
+// Options.KeyTimestamp is set.
+const timestampsPropertyName = "pebble.timestamps"
+
+// TimeRange is a range [Start, End) of key timestamps (see
+// Options.KeyTimestamp). The zero value is an unset range.
+type TimeRange struct {
+	Start, End uint64
+}
+
+func (r TimeRange) isSet() bool {
+	return r != TimeRange{}
+}
+
+// newTimestampsCollector returns a block property collector recording the
+// interval of the timestamps of the point keys, for pruning with
+// IterOptions.TimeRange.
+func newTimestampsCollector(keyTimestamp func(userKey []byte) (uint64, bool)) BlockPropertyCollector {
+	return sstable.NewBlockIntervalCollector(
+		timestampsPropertyName, &timestampsCollector{keyTimestamp: keyTimestamp}, nil, /* range keys */
+	)
+}
+
+// timestampsCollector collects the interval of the timestamps of the point
+// keys of a data block. A key without a timestamp makes the interval
+// unbounded, so that the block is never pruned.
+type timestampsCollector struct {
+	keyTimestamp func(userKey []byte) (uint64, bool)
+	lower, upper uint64
+}
+
+var _ sstable.DataBlockIntervalCollector = (*timestampsCollector)(nil)
+
+func (c *timestampsCollector) Add(key InternalKey, value []byte) error {
+	ts, ok := c.keyTimestamp(key.UserKey)
+	if !ok || ts == math.MaxUint64 {
+		c.lower, c.upper = 0, math.MaxUint64
+		return nil
+	}
+	if c.lower == c.upper {
+		c.lower, c.upper = ts, ts+1
+		return nil
+	}
+	if ts < c.lower {
+		c.lower = ts
+	}
+	if ts >= c.upper {
+		c.upper = ts + 1
+	}
+	return nil
+}
+
+func (c *timestampsCollector) FinishDataBlock() (lower, upper uint64, err error) {
+	lower, upper = c.lower, c.upper
+	c.lower, c.upper = 0, 0
+	return lower, upper, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/pebble/internal/testkeys"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIterTimeRange(t *testing.T) {
+	keyTimestamp := func(key []byte) (uint64, bool) {
+		n := testkeys.Comparer.Split(key)
+		if n == len(key) {
+			return 0, false
+		}
+		ts, err := testkeys.ParseSuffix(key[n:])
+		if err != nil {
+			return 0, false
+		}
+		return uint64(ts), true
+	}
+	d, err := Open("", &Options{
+		FS:                          vfs.NewMem(),
+		Comparer:                    testkeys.Comparer,
+		FormatMajorVersion:          FormatNewest,
+		KeyTimestamp:                keyTimestamp,
+		DisableAutomaticCompactions: true,
+		Levels:                      []LevelOptions{{BlockSize: 1}},
+	})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+
+	// Each flush writes a table with keys at timestamps [10*i, 10*i+5).
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 5; j++ {
+			key := fmt.Sprintf("%c%d@%d", 'a'+i, j, 10*i+j)
+			require.NoError(t, d.Set([]byte(key), nil, nil))
+		}
+		require.NoError(t, d.Flush())
+	}
+	// An unflushed key is not pruned.
+	require.NoError(t, d.Set([]byte("z@1"), nil, nil))
+
+	scan := func(r TimeRange) string {
+		iter := d.NewIter(&IterOptions{TimeRange: r})
+		var keys []string
+		for valid := iter.First(); valid; valid = iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		require.NoError(t, iter.Close())
+		return strings.Join(keys, " ")
+	}
+	require.Equal(t, "a0@0 a1@1 a2@2 a3@3 a4@4 b0@10 b1@11 b2@12 b3@13 b4@14 "+
+		"c0@20 c1@21 c2@22 c3@23 c4@24 z@1", scan(TimeRange{}))
+	// With one key per block, the blocks outside of the range are skipped.
+	require.Equal(t, "b2@12 b3@13 b4@14 c0@20 z@1", scan(TimeRange{Start: 12, End: 21}))
+	require.Equal(t, "z@1", scan(TimeRange{Start: 100, End: 200}))
+
+	// Changing the time range of an iterator rebuilds its filters.
+	iter := d.NewIter(&IterOptions{TimeRange: TimeRange{Start: 0, End: 2}})
+	require.True(t, iter.First())
+	require.Equal(t, "a0@0", string(iter.Key()))
+	iter.SetOptions(&IterOptions{TimeRange: TimeRange{Start: 24, End: 25}})
+	require.True(t, iter.First())
+	require.Equal(t, "c4@24", string(iter.Key()))
+	require.NoError(t, iter.Close())
+}
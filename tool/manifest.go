@@ -182,6 +182,10 @@ func (m *manifestT) runDump(cmd *cobra.Command, args []string) {
 					fmt.Fprintf(stdout, "\n")
 					m.fmtKey.setForComparer(ve.ComparerName, m.comparers)
 				}
+				if ve.DBID != "" {
+					empty = false
+					fmt.Fprintf(stdout, "  db-id:         %s\n", ve.DBID)
+				}
 				if ve.MinUnflushedLogNum != 0 {
 					empty = false
 					fmt.Fprintf(stdout, "  log-num:       %d\n", ve.MinUnflushedLogNum)
@@ -0,0 +1,282 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrTxnConflict is returned by Txn.Commit when a key read by the transaction
+// was written by another transaction committed since the transaction started.
+var ErrTxnConflict = errors.New("pebble: transaction conflict")
+
+// ErrTxnDone is returned when using a transaction which was committed or
+// rolled back.
+var ErrTxnDone = errors.New("pebble: transaction already committed or rolled back")
+
+// Txn is an optimistic transaction. It reads from a snapshot of the DB taken
+// when the transaction starts, overlaid with its own writes, which are
+// buffered in an indexed batch until Commit. The keys and ranges read by the
+// transaction are tracked, and Commit fails with ErrTxnConflict if any of them
+// was written by another transaction committed since the transaction started,
+// which makes the transactions serializable.
+//
+// Conflicts are only detected between transactions: writes applied to the DB
+// directly, outside of a transaction, are not taken into account. A Txn is not
+// safe for concurrent use.
+type Txn struct {
+	db       *DB
+	snapshot *Snapshot
+	batch    *Batch
+	// reads and writes are the keys and ranges read and written by the
+	// transaction.
+	reads  []txnSpan
+	writes []txnSpan
+}
+
+// NewTxn starts a new transaction. The transaction must be committed or rolled
+// back.
+func (d *DB) NewTxn() *Txn {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	t := &Txn{
+		db:    d,
+		batch: d.NewIndexedBatch(),
+	}
+	d.txns.mu.Lock()
+	defer d.txns.mu.Unlock()
+	t.snapshot = d.NewSnapshot()
+	if d.txns.active == nil {
+		d.txns.active = make(map[uint64]int)
+	}
+	d.txns.active[t.snapshot.seqNum]++
+	return t
+}
+
+// Get gets the value for the given key, as of the start of the transaction
+// or as written by the transaction. It returns ErrNotFound if there is no
+// such key. The key is tracked as read by the transaction.
+//
+// The caller should not modify the contents of the returned slice, but it is
+// safe to modify the contents of the argument after Get returns. The returned
+// slice will remain valid until the returned Closer is closed. On success, the
+// caller MUST call closer.Close() or a memory leak will occur.
+func (t *Txn) Get(key []byte) ([]byte, io.Closer, error) {
+	if t.batch == nil {
+		return nil, nil, ErrTxnDone
+	}
+	t.reads = append(t.reads, t.pointSpan(key))
+	return t.db.getInternal(key, t.batch, t.snapshot)
+}
+
+// NewIter returns an iterator over the DB as of the start of the transaction,
+// overlaid with the writes of the transaction. The whole range between the
+// bounds of the iterator (see IterOptions.LowerBound and UpperBound) is
+// tracked as read by the transaction, so bounded iterators lead to fewer
+// conflicts; the bounds must not be widened with Iterator.SetBounds or
+// SetOptions. The iterator must be closed before the transaction is committed
+// or rolled back.
+func (t *Txn) NewIter(o *IterOptions) *Iterator {
+	if t.batch == nil {
+		panic(ErrTxnDone)
+	}
+	var span txnSpan
+	if o != nil {
+		span.start = append([]byte(nil), o.LowerBound...)
+		if o.UpperBound != nil {
+			span.end = append([]byte(nil), o.UpperBound...)
+		}
+	}
+	t.reads = append(t.reads, span)
+	return t.db.newIter(context.Background(), t.batch, t.snapshot, o)
+}
+
+// Set sets the value for the given key, when the transaction commits.
+func (t *Txn) Set(key, value []byte) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.writes = append(t.writes, t.pointSpan(key))
+	return t.batch.Set(key, value, nil)
+}
+
+// Merge merges the value into the given key, when the transaction commits.
+func (t *Txn) Merge(key, value []byte) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.writes = append(t.writes, t.pointSpan(key))
+	return t.batch.Merge(key, value, nil)
+}
+
+// Delete deletes the given key, when the transaction commits.
+func (t *Txn) Delete(key []byte) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.writes = append(t.writes, t.pointSpan(key))
+	return t.batch.Delete(key, nil)
+}
+
+// DeleteRange deletes all of the point keys in the range [start,end), when the
+// transaction commits.
+func (t *Txn) DeleteRange(start, end []byte) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.writes = append(t.writes, txnSpan{
+		start: append([]byte(nil), start...),
+		end:   append([]byte(nil), end...),
+	})
+	return t.batch.DeleteRange(start, end, nil)
+}
+
+// Commit applies the writes of the transaction to the DB, unless a key or
+// range read by the transaction was written by a transaction committed since
+// the transaction started, in which case it returns ErrTxnConflict and nothing
+// is written. In both cases, the transaction is done and must not be used
+// anymore.
+func (t *Txn) Commit(opts *WriteOptions) error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	d := t.db
+	d.txns.mu.Lock()
+	defer d.txns.mu.Unlock()
+	defer t.closeLocked()
+
+	if d.txns.conflictsLocked(d.cmp, t.snapshot.seqNum, t.reads) {
+		return ErrTxnConflict
+	}
+	if t.batch.Empty() {
+		return nil
+	}
+	if err := d.Apply(t.batch, opts); err != nil {
+		return err
+	}
+	if len(d.txns.active) > 1 || d.txns.active[t.snapshot.seqNum] > 1 {
+		// Other transactions in progress must check their reads against the
+		// writes of this transaction.
+		d.txns.committed = append(d.txns.committed, txnCommitted{
+			seqNum: t.batch.SeqNum(),
+			writes: t.writes,
+		})
+	}
+	return nil
+}
+
+// Rollback discards the writes of the transaction. The transaction is done and
+// must not be used anymore.
+func (t *Txn) Rollback() error {
+	if t.batch == nil {
+		return ErrTxnDone
+	}
+	t.db.txns.mu.Lock()
+	defer t.db.txns.mu.Unlock()
+	t.closeLocked()
+	return nil
+}
+
+// closeLocked releases the resources of the transaction, and of the committed
+// transactions it can no longer conflict with. txnOracle.mu must be held.
+func (t *Txn) closeLocked() {
+	d := t.db
+	seqNum := t.snapshot.seqNum
+	if d.txns.active[seqNum]--; d.txns.active[seqNum] == 0 {
+		delete(d.txns.active, seqNum)
+	}
+	d.txns.pruneLocked()
+	_ = t.snapshot.Close()
+	_ = t.batch.Close()
+	t.snapshot = nil
+	t.batch = nil
+	t.reads = nil
+	t.writes = nil
+}
+
+func (t *Txn) pointSpan(key []byte) txnSpan {
+	return txnSpan{start: append([]byte(nil), key...), point: true}
+}
+
+// txnSpan is a key read or written by a transaction if point is set, or else
+// the range [start, end), where a nil start or end means that the range is
+// unbounded.
+type txnSpan struct {
+	start, end []byte
+	point      bool
+}
+
+func (s txnSpan) overlaps(cmp Compare, o txnSpan) bool {
+	if s.point && o.point {
+		return cmp(s.start, o.start) == 0
+	}
+	if o.point {
+		s, o = o, s
+	}
+	if s.point {
+		return (o.start == nil || cmp(o.start, s.start) <= 0) &&
+			(o.end == nil || cmp(s.start, o.end) < 0)
+	}
+	return (s.start == nil || o.end == nil || cmp(s.start, o.end) < 0) &&
+		(o.start == nil || s.end == nil || cmp(o.start, s.end) < 0)
+}
+
+// txnOracle tracks the transactions of a DB, to detect conflicts.
+type txnOracle struct {
+	// mu serializes the start and the commit of transactions.
+	mu sync.Mutex
+	// active counts the transactions in progress, by the sequence number of
+	// their snapshot.
+	active map[uint64]int
+	// committed holds the transactions committed while other transactions
+	// were in progress, in commit order.
+	committed []txnCommitted
+}
+
+// txnCommitted describes the writes of a committed transaction.
+type txnCommitted struct {
+	seqNum uint64
+	writes []txnSpan
+}
+
+// conflictsLocked returns true if a transaction committed after the snapshot
+// with the given sequence number wrote any of the reads. mu must be held.
+func (o *txnOracle) conflictsLocked(cmp Compare, seqNum uint64, reads []txnSpan) bool {
+	for i := len(o.committed) - 1; i >= 0 && o.committed[i].seqNum >= seqNum; i-- {
+		for _, w := range o.committed[i].writes {
+			for _, r := range reads {
+				if w.overlaps(cmp, r) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// pruneLocked forgets the committed transactions that are visible to all the
+// transactions in progress. mu must be held.
+func (o *txnOracle) pruneLocked() {
+	if len(o.active) == 0 {
+		o.committed = nil
+		return
+	}
+	minSeqNum := uint64(InternalKeySeqNumMax)
+	for seqNum := range o.active {
+		if seqNum < minSeqNum {
+			minSeqNum = seqNum
+		}
+	}
+	i := 0
+	for i < len(o.committed) && o.committed[i].seqNum < minSeqNum {
+		i++
+	}
+	o.committed = o.committed[i:]
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxn(t *testing.T) {
+	d, err := Open("", &Options{FS: vfs.NewMem()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, d.Close()) }()
+	require.NoError(t, d.Set([]byte("a"), []byte("1"), nil))
+
+	get := func(txn *Txn, key string) string {
+		v, closer, err := txn.Get([]byte(key))
+		if errors.Is(err, ErrNotFound) {
+			return ""
+		}
+		require.NoError(t, err)
+		defer closer.Close()
+		return string(v)
+	}
+
+	// Reads see the snapshot overlaid with the writes of the transaction.
+	t1 := d.NewTxn()
+	t2 := d.NewTxn()
+	require.Equal(t, "1", get(t1, "a"))
+	require.NoError(t, t1.Set([]byte("b"), []byte("2")))
+	require.Equal(t, "2", get(t1, "b"))
+	require.Equal(t, "", get(t2, "b"))
+	require.NoError(t, d.Set([]byte("c"), []byte("3"), nil))
+	require.Equal(t, "", get(t2, "c"))
+
+	// t2 read b, which t1 writes.
+	require.NoError(t, t2.Set([]byte("a"), []byte("4")))
+	require.NoError(t, t1.Commit(nil))
+	require.ErrorIs(t, t2.Commit(nil), ErrTxnConflict)
+	require.ErrorIs(t, t2.Commit(nil), ErrTxnDone)
+	v, closer, err := d.Get([]byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(v))
+	require.NoError(t, closer.Close())
+
+	// Disjoint reads and writes don't conflict.
+	t3 := d.NewTxn()
+	t4 := d.NewTxn()
+	require.Equal(t, "2", get(t3, "b"))
+	require.NoError(t, t3.Set([]byte("x"), []byte("5")))
+	require.NoError(t, t4.Set([]byte("y"), []byte("6")))
+	require.NoError(t, t4.Commit(nil))
+	require.NoError(t, t3.Commit(nil))
+
+	// Iterators track their bounds as read.
+	t5 := d.NewTxn()
+	t6 := d.NewTxn()
+	iter := t5.NewIter(&IterOptions{LowerBound: []byte("p"), UpperBound: []byte("z")})
+	var keys []string
+	for iter.First(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	require.NoError(t, iter.Close())
+	require.Equal(t, []string{"x", "y"}, keys)
+	require.NoError(t, t6.DeleteRange([]byte("a"), []byte("q")))
+	require.NoError(t, t6.Commit(nil))
+	require.NoError(t, t5.Set([]byte("a"), []byte("7")))
+	require.ErrorIs(t, t5.Commit(nil), ErrTxnConflict)
+
+	// A rolled back transaction doesn't write anything.
+	t7 := d.NewTxn()
+	require.NoError(t, t7.Set([]byte("r"), []byte("8")))
+	require.NoError(t, t7.Rollback())
+	require.ErrorIs(t, t7.Set([]byte("r"), []byte("8")), ErrTxnDone)
+	_, _, err = d.Get([]byte("r"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	d.txns.mu.Lock()
+	require.Empty(t, d.txns.active)
+	require.Empty(t, d.txns.committed)
+	d.txns.mu.Unlock()
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 
@@ -100,6 +101,15 @@ type versionSet struct {
 	// for the WAL, MANIFEST, sstable, and OPTIONS files.
 	nextFileNum FileNum
 
+	// dbID is the unique ID of the DB, or the empty string if the DB has none
+	// (see FormatDBID). It is only modified while the manifest is locked, and
+	// read by createManifest without holding mu.
+	dbID string
+
+	// editSubscribers are invoked with each version edit applied by
+	// logAndApply (see DB.SubscribeVersionEdits).
+	editSubscribers []*versionEditSubscriber
+
 	// The current manifest file number.
 	manifestFileNum FileNum
 	manifestMarker  *atomicfs.Marker
@@ -112,6 +122,9 @@ type versionSet struct {
 	writerCond sync.Cond
 	// State for deciding when to write a snapshot. Protected by mu.
 	rotationHelper record.RotationHelper
+	// manifestEdits is the number of version edits written to the current
+	// manifest (see Options.MaxManifestEdits). Protected by mu.
+	manifestEdits int64
 }
 
 func (vs *versionSet) init(
@@ -207,41 +220,12 @@ func (vs *versionSet) load(
 	// Read the versionEdits in the manifest file.
 	var bve bulkVersionEdit
 	bve.AddedByFileNum = make(map[base.FileNum]*fileMetadata)
-	manifest, err := vs.fs.Open(manifestPath)
-	if err != nil {
-		return errors.Wrapf(err, "pebble: could not open manifest file %q for DB %q",
-			errors.Safe(manifestFilename), dirname)
-	}
-	defer manifest.Close()
-	rr := record.NewReader(manifest, 0 /* logNum */)
-	for {
-		r, err := rr.Next()
-		if err == io.EOF || record.IsInvalidRecord(err) {
-			break
-		}
-		if err != nil {
-			return errors.Wrapf(err, "pebble: error when loading manifest file %q",
-				errors.Safe(manifestFilename))
-		}
-		var ve versionEdit
-		err = ve.Decode(r)
-		if err != nil {
-			// Break instead of returning an error if the record is corrupted
-			// or invalid.
-			if err == io.EOF || record.IsInvalidRecord(err) {
-				break
-			}
+	if err := replayManifest(vs.fs, dirname, manifestPath, vs.cmpName, func(ve *versionEdit) error {
+		if err := bve.Accumulate(ve); err != nil {
 			return err
 		}
-		if ve.ComparerName != "" {
-			if ve.ComparerName != vs.cmpName {
-				return errors.Errorf("pebble: manifest file %q for DB %q: "+
-					"comparer name from file %q != comparer name from Options %q",
-					errors.Safe(manifestFilename), dirname, errors.Safe(ve.ComparerName), errors.Safe(vs.cmpName))
-			}
-		}
-		if err := bve.Accumulate(&ve); err != nil {
-			return err
+		if ve.DBID != "" {
+			vs.dbID = ve.DBID
 		}
 		if ve.MinUnflushedLogNum != 0 {
 			vs.minUnflushedLogNum = ve.MinUnflushedLogNum
@@ -259,6 +243,9 @@ func (vs *versionSet) load(
 			// next sequence number that will be assigned.
 			vs.atomic.logSeqNum = ve.LastSeqNum + 1
 		}
+		return nil
+	}); err != nil {
+		return err
 	}
 	// We have already set vs.nextFileNum = 2 at the beginning of the
 	// function and could have only updated it to some other non-zero value,
@@ -298,6 +285,52 @@ func (vs *versionSet) load(
 	return nil
 }
 
+// replayManifest calls fn with each of the versionEdits of the manifest file,
+// in order, checking that they were written with the comparer with the given
+// name. A corrupted or invalid record ends the manifest.
+func replayManifest(
+	fs vfs.FS, dirname, manifestPath, cmpName string, fn func(ve *versionEdit) error,
+) error {
+	manifestFilename := fs.PathBase(manifestPath)
+	manifest, err := fs.Open(manifestPath)
+	if err != nil {
+		return errors.Wrapf(err, "pebble: could not open manifest file %q for DB %q",
+			errors.Safe(manifestFilename), dirname)
+	}
+	defer manifest.Close()
+	rr := record.NewReader(manifest, 0 /* logNum */)
+	for {
+		r, err := rr.Next()
+		if err == io.EOF || record.IsInvalidRecord(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "pebble: error when loading manifest file %q",
+				errors.Safe(manifestFilename))
+		}
+		var ve versionEdit
+		err = ve.Decode(r)
+		if err != nil {
+			// Stop instead of returning an error if the record is corrupted
+			// or invalid.
+			if err == io.EOF || record.IsInvalidRecord(err) {
+				return nil
+			}
+			return err
+		}
+		if ve.ComparerName != "" {
+			if ve.ComparerName != cmpName {
+				return errors.Errorf("pebble: manifest file %q for DB %q: "+
+					"comparer name from file %q != comparer name from Options %q",
+					errors.Safe(manifestFilename), dirname, errors.Safe(ve.ComparerName), errors.Safe(cmpName))
+			}
+		}
+		if err := fn(&ve); err != nil {
+			return err
+		}
+	}
+}
+
 func (vs *versionSet) close() error {
 	if vs.manifestFile != nil {
 		if err := vs.manifestFile.Close(); err != nil {
@@ -446,6 +479,11 @@ func (vs *versionSet) logAndApply(
 	if sizeExceeded && !requireRotation {
 		requireRotation = vs.rotationHelper.ShouldRotate(nextSnapshotFilecount)
 	}
+	// The explicit limits on the manifest override the heuristic above.
+	if !requireRotation {
+		requireRotation = (vs.opts.MaxManifestEdits > 0 && vs.manifestEdits >= vs.opts.MaxManifestEdits) ||
+			(vs.opts.HardMaxManifestFileSize > 0 && vs.manifest.Size() >= vs.opts.HardMaxManifestFileSize)
+	}
 	var newManifestFileNum FileNum
 	var prevManifestFileSize uint64
 	if requireRotation {
@@ -528,7 +566,9 @@ func (vs *versionSet) logAndApply(
 	if requireRotation {
 		// Successfully rotated.
 		vs.rotationHelper.Rotate(nextSnapshotFilecount)
+		vs.manifestEdits = 0
 	}
+	vs.manifestEdits++
 	// Now that DB.mu is held again, initialize compacting file info in
 	// L0Sublevels.
 	inProgress := inProgressCompactions()
@@ -544,6 +584,9 @@ func (vs *versionSet) logAndApply(
 
 	// Install the new version.
 	vs.append(newVersion)
+	if ve.DBID != "" {
+		vs.dbID = ve.DBID
+	}
 	if ve.MinUnflushedLogNum != 0 {
 		vs.minUnflushedLogNum = ve.MinUnflushedLogNum
 	}
@@ -582,9 +625,51 @@ func (vs *versionSet) logAndApply(
 	if !vs.dynamicBaseLevel {
 		vs.picker.forceBaseLevel1()
 	}
+
+	if len(vs.editSubscribers) > 0 {
+		info := makeVersionEditInfo(jobID, ve)
+		for _, s := range vs.editSubscribers {
+			s.fn(info)
+		}
+	}
 	return nil
 }
 
+// versionEditSubscriber is a function registered with
+// DB.SubscribeVersionEdits.
+type versionEditSubscriber struct {
+	fn func(VersionEditInfo)
+}
+
+// makeVersionEditInfo returns the VersionEditInfo describing the given version
+// edit.
+func makeVersionEditInfo(jobID int, ve *versionEdit) VersionEditInfo {
+	info := VersionEditInfo{JobID: jobID}
+	for _, nf := range ve.NewFiles {
+		info.Added = append(info.Added, struct {
+			TableInfo
+			Level int
+		}{nf.Meta.TableInfo(), nf.Level})
+	}
+	for df, m := range ve.DeletedFiles {
+		t := TableInfo{FileNum: df.FileNum}
+		if m != nil {
+			t = m.TableInfo()
+		}
+		info.Deleted = append(info.Deleted, struct {
+			TableInfo
+			Level int
+		}{t, df.Level})
+	}
+	sort.Slice(info.Deleted, func(i, j int) bool {
+		if info.Deleted[i].Level != info.Deleted[j].Level {
+			return info.Deleted[i].Level < info.Deleted[j].Level
+		}
+		return info.Deleted[i].FileNum < info.Deleted[j].FileNum
+	})
+	return info
+}
+
 func (vs *versionSet) incrementCompactions(kind compactionKind, extraLevels []*compactionLevel) {
 	switch kind {
 	case compactionKindDefault:
@@ -613,6 +698,10 @@ func (vs *versionSet) incrementCompactions(kind compactionKind, extraLevels []*c
 	case compactionKindRewrite:
 		vs.metrics.Compact.Count++
 		vs.metrics.Compact.RewriteCount++
+
+	case compactionKindTombstoneDensity:
+		vs.metrics.Compact.Count++
+		vs.metrics.Compact.TombstoneDensityCount++
 	}
 	if len(extraLevels) > 0 {
 		vs.metrics.Compact.MultiLevelCount++
@@ -651,6 +740,7 @@ func (vs *versionSet) createManifest(
 
 	snapshot := versionEdit{
 		ComparerName: vs.cmpName,
+		DBID:         vs.dbID,
 	}
 	for level, levelMetadata := range vs.currentVersion().Levels {
 		iter := levelMetadata.Iter()
@@ -768,7 +858,7 @@ func (vs *versionSet) updateObsoleteTableMetricsLocked() {
 	vs.metrics.Table.ObsoleteCount = int64(len(vs.obsoleteTables))
 	vs.metrics.Table.ObsoleteSize = 0
 	for _, fileMeta := range vs.obsoleteTables {
-		vs.metrics.Table.ObsoleteSize += fileMeta.Size
+		vs.metrics.Table.ObsoleteSize += fileMeta.BackingSize()
 	}
 }
 
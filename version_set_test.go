@@ -113,3 +113,69 @@ func TestVersionSetSeqNums(t *testing.T) {
 	// logSeqNum is always one greater than the last assigned sequence number.
 	require.Equal(t, d.mu.versions.atomic.logSeqNum, lastSeqNum+1)
 }
+
+func TestVersionSetManifestRotationLimits(t *testing.T) {
+	manifestFileNum := func(d *DB) FileNum {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.mu.versions.manifestFileNum
+	}
+	flush := func(d *DB, k string) {
+		require.NoError(t, d.Set([]byte(k), nil, nil))
+		require.NoError(t, d.Flush())
+	}
+
+	t.Run("max-edits", func(t *testing.T) {
+		d, err := Open("", &Options{FS: vfs.NewMem(), MaxManifestEdits: 3})
+		require.NoError(t, err)
+		defer d.Close()
+		// Open writes an edit to the new manifest, so that the limit is reached
+		// after two flushes.
+		first := manifestFileNum(d)
+		flush(d, "a")
+		flush(d, "b")
+		require.Equal(t, first, manifestFileNum(d))
+		flush(d, "c")
+		second := manifestFileNum(d)
+		require.Greater(t, second, first)
+		flush(d, "d")
+		require.Equal(t, second, manifestFileNum(d))
+	})
+
+	t.Run("hard-max-size", func(t *testing.T) {
+		d, err := Open("", &Options{
+			FS:                  vfs.NewMem(),
+			MaxManifestFileSize: 1 << 30,
+			// Any edit takes the manifest beyond its hard limit.
+			HardMaxManifestFileSize: 1,
+		})
+		require.NoError(t, err)
+		defer d.Close()
+		prev := manifestFileNum(d)
+		for _, k := range []string{"a", "b", "c"} {
+			flush(d, k)
+			cur := manifestFileNum(d)
+			require.Greater(t, cur, prev)
+			prev = cur
+		}
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		mem := vfs.NewMem()
+		d, err := Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		flush(d, "a")
+		prev := manifestFileNum(d)
+		require.NoError(t, d.RewriteManifest())
+		require.Greater(t, manifestFileNum(d), prev)
+		require.NoError(t, d.Close())
+
+		// The new manifest holds the tables of the DB.
+		d, err = Open("", &Options{FS: mem})
+		require.NoError(t, err)
+		defer d.Close()
+		_, closer, err := d.Get([]byte("a"))
+		require.NoError(t, err)
+		require.NoError(t, closer.Close())
+	})
+}
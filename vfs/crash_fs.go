@@ -0,0 +1,421 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/cockroachdb/errors"
+)
+
+// CrashFS is a memory-backed FS that records every operation that modifies
+// the filesystem, so that the state the filesystem would be in after a power
+// failure at any point can be reconstructed. It is intended for testing the
+// recovery logic of Pebble and of the applications built on top of it.
+//
+// Reads and writes through a CrashFS behave like those of a MemFS. The crash
+// states are computed by replaying a prefix of the recorded operations on a
+// strict MemFS (see NewStrictMem), which only retains the data of a file that
+// was synced with File.Sync or File.SyncData, and only retains the entries of
+// a directory that was synced by opening it with OpenDir and calling Sync.
+// File.SyncTo provides no durability. Directories created with MkdirAll are
+// considered durable as soon as they are created.
+//
+// Expected usage:
+//
+//	fs := vfs.NewCrashFS()
+//	db := Open(..., &Options{FS: fs})
+//	// Perform various operations.
+//	...
+//	db.Close()
+//	err := fs.ForEachCrashState(func(numOps int, crashFS *vfs.MemFS) error {
+//		// Open the DB on crashFS and verify that it recovers to a state
+//		// consistent with the operations that were acknowledged as durable
+//		// before the first numOps filesystem operations completed.
+//		...
+//	})
+//
+// All the data written through a CrashFS is retained in memory for the
+// lifetime of the CrashFS.
+type CrashFS struct {
+	FS
+	mu struct {
+		sync.Mutex
+		ops        []crashOp
+		nextFileID int
+	}
+}
+
+var _ FS = (*CrashFS)(nil)
+
+// NewCrashFS returns a new, empty CrashFS.
+func NewCrashFS() *CrashFS {
+	return &CrashFS{FS: NewMem()}
+}
+
+type crashOpKind uint8
+
+const (
+	crashOpCreate crashOpKind = iota
+	crashOpReuseForWrite
+	crashOpOpenDir
+	crashOpWrite
+	crashOpSync
+	crashOpLink
+	crashOpRemove
+	crashOpRemoveAll
+	crashOpRename
+	crashOpMkdirAll
+)
+
+// crashOp is a recorded filesystem operation. Operations on files refer to
+// the file through the ID assigned when it was created or opened, since its
+// name may have changed since.
+type crashOp struct {
+	kind    crashOpKind
+	fileID  int
+	name    string
+	newName string
+	data    []byte
+}
+
+func (op crashOp) String() string {
+	switch op.kind {
+	case crashOpCreate:
+		return fmt.Sprintf("create(%s) = #%d", op.name, op.fileID)
+	case crashOpReuseForWrite:
+		return fmt.Sprintf("reuse-for-write(%s, %s) = #%d", op.name, op.newName, op.fileID)
+	case crashOpOpenDir:
+		return fmt.Sprintf("open-dir(%s) = #%d", op.name, op.fileID)
+	case crashOpWrite:
+		return fmt.Sprintf("write(#%d, %d bytes)", op.fileID, len(op.data))
+	case crashOpSync:
+		return fmt.Sprintf("sync(#%d)", op.fileID)
+	case crashOpLink:
+		return fmt.Sprintf("link(%s, %s)", op.name, op.newName)
+	case crashOpRemove:
+		return fmt.Sprintf("remove(%s)", op.name)
+	case crashOpRemoveAll:
+		return fmt.Sprintf("remove-all(%s)", op.name)
+	case crashOpRename:
+		return fmt.Sprintf("rename(%s, %s)", op.name, op.newName)
+	case crashOpMkdirAll:
+		return fmt.Sprintf("mkdir-all(%s)", op.name)
+	default:
+		return fmt.Sprintf("unknown(%d)", op.kind)
+	}
+}
+
+// apply performs an operation on the underlying FS through fn and, if it
+// succeeds, appends the operation to the log. Operations are applied with the
+// mutex held so that the order of the log matches the order in which the
+// operations took effect. If newFile is set, a new file ID is assigned to the
+// operation and returned.
+func (fs *CrashFS) apply(op crashOp, newFile bool, fn func() error) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fn(); err != nil {
+		return 0, err
+	}
+	if newFile {
+		op.fileID = fs.mu.nextFileID
+		fs.mu.nextFileID++
+	}
+	fs.mu.ops = append(fs.mu.ops, op)
+	return op.fileID, nil
+}
+
+// NumOps returns the number of operations recorded so far.
+func (fs *CrashFS) NumOps() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return len(fs.mu.ops)
+}
+
+// OpString returns a description of the i-th recorded operation, for use in
+// test failure messages.
+func (fs *CrashFS) OpString(i int) string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.mu.ops[i].String()
+}
+
+// CrashState returns the state of the filesystem after a crash that occurs
+// once the first numOps recorded operations have completed, in which all
+// un-synced writes are lost.
+func (fs *CrashFS) CrashState(numOps int) (*MemFS, error) {
+	return fs.replay(numOps, nil /* rng */)
+}
+
+// RandomCrashState is like CrashState, except that an arbitrary subset of
+// the un-synced writes survives the crash: every file retains a random prefix
+// of the data appended to it since it was last synced, and every un-synced
+// directory entry is retained with probability 1/2.
+func (fs *CrashFS) RandomCrashState(numOps int, rng *rand.Rand) (*MemFS, error) {
+	return fs.replay(numOps, rng)
+}
+
+// ForEachCrashState calls fn with every distinct crash state of the
+// filesystem, in which all un-synced writes are lost. Since the crash state
+// only changes when a file or directory is synced (or when a directory is
+// created), these are the states after zero operations, after each such
+// operation, and after all of the recorded operations. Iteration stops at the
+// first error returned by fn, which is returned.
+func (fs *CrashFS) ForEachCrashState(fn func(numOps int, crashFS *MemFS) error) error {
+	fs.mu.Lock()
+	ops := fs.mu.ops
+	fs.mu.Unlock()
+
+	points := []int{0}
+	for i := range ops {
+		if ops[i].kind == crashOpSync || ops[i].kind == crashOpMkdirAll || i == len(ops)-1 {
+			points = append(points, i+1)
+		}
+	}
+	for _, numOps := range points {
+		crashFS, err := fs.replay(numOps, nil /* rng */)
+		if err != nil {
+			return err
+		}
+		if err := fn(numOps, crashFS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *CrashFS) replay(numOps int, rng *rand.Rand) (*MemFS, error) {
+	fs.mu.Lock()
+	if numOps > len(fs.mu.ops) {
+		n := len(fs.mu.ops)
+		fs.mu.Unlock()
+		return nil, errors.Errorf("pebble/vfs: crash point %d is beyond the %d recorded operations", numOps, n)
+	}
+	// The log is append-only and the recorded operations are never mutated,
+	// so the prefix can be used without holding the mutex.
+	ops := fs.mu.ops[:numOps]
+	fs.mu.Unlock()
+
+	mem := NewStrictMem()
+	files := make(map[int]File)
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for i, op := range ops {
+		var err error
+		var f File
+		switch op.kind {
+		case crashOpCreate:
+			f, err = mem.Create(op.name)
+		case crashOpReuseForWrite:
+			f, err = mem.ReuseForWrite(op.name, op.newName)
+		case crashOpOpenDir:
+			f, err = mem.OpenDir(op.name)
+		case crashOpWrite:
+			_, err = files[op.fileID].Write(append([]byte(nil), op.data...))
+		case crashOpSync:
+			err = files[op.fileID].Sync()
+		case crashOpLink:
+			err = mem.Link(op.name, op.newName)
+		case crashOpRemove:
+			err = mem.Remove(op.name)
+		case crashOpRemoveAll:
+			err = mem.RemoveAll(op.name)
+		case crashOpRename:
+			err = mem.Rename(op.name, op.newName)
+		case crashOpMkdirAll:
+			if err = mem.MkdirAll(op.name, 0755); err == nil {
+				err = mem.markDirSynced(op.name)
+			}
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "pebble/vfs: replaying operation %d: %s", i, op)
+		}
+		if f != nil {
+			files[op.fileID] = f
+		}
+	}
+	if rng == nil {
+		mem.ResetToSyncedState()
+	} else {
+		mem.mu.Lock()
+		mem.root.resetToRandomState(rng)
+		mem.mu.Unlock()
+	}
+	return mem, nil
+}
+
+// markDirSynced marks the named directory, and all of its ancestors, as
+// durable entries of their parent directories.
+func (y *MemFS) markDirSynced(dirname string) error {
+	return y.walk(dirname, func(dir *memNode, frag string, final bool) error {
+		if child := dir.children[frag]; child != nil && child.isDir {
+			if dir.syncedChildren == nil {
+				dir.syncedChildren = make(map[string]*memNode)
+			}
+			dir.syncedChildren[frag] = child
+		}
+		return nil
+	})
+}
+
+// resetToRandomState is like resetToSyncedState, except that a random subset
+// of the un-synced state is retained.
+func (f *memNode) resetToRandomState(rng *rand.Rand) {
+	if f.isDir {
+		names := make([]string, 0, len(f.children))
+		for name := range f.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		children := make(map[string]*memNode)
+		for k, v := range f.syncedChildren {
+			children[k] = v
+		}
+		for _, name := range names {
+			if n := f.children[name]; f.syncedChildren[name] != n && rng.Intn(2) == 0 {
+				children[name] = n
+			}
+		}
+		f.children = children
+		names = names[:0]
+		for name := range f.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f.children[name].resetToRandomState(rng)
+		}
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data := f.mu.syncedData
+	if bytes.HasPrefix(f.mu.data, f.mu.syncedData) {
+		unsynced := len(f.mu.data) - len(f.mu.syncedData)
+		data = f.mu.data[:len(f.mu.syncedData)+rng.Intn(unsynced+1)]
+	}
+	f.mu.data = append([]byte(nil), data...)
+}
+
+// Create implements FS.Create.
+func (fs *CrashFS) Create(name string) (File, error) {
+	var f File
+	id, err := fs.apply(crashOp{kind: crashOpCreate, name: name}, true /* newFile */, func() (err error) {
+		f, err = fs.FS.Create(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &crashFile{File: f, fs: fs, id: id}, nil
+}
+
+// Link implements FS.Link.
+func (fs *CrashFS) Link(oldname, newname string) error {
+	_, err := fs.apply(crashOp{kind: crashOpLink, name: oldname, newName: newname}, false /* newFile */, func() error {
+		return fs.FS.Link(oldname, newname)
+	})
+	return err
+}
+
+// OpenDir implements FS.OpenDir.
+func (fs *CrashFS) OpenDir(name string) (File, error) {
+	var f File
+	id, err := fs.apply(crashOp{kind: crashOpOpenDir, name: name}, true /* newFile */, func() (err error) {
+		f, err = fs.FS.OpenDir(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &crashFile{File: f, fs: fs, id: id}, nil
+}
+
+// Remove implements FS.Remove.
+func (fs *CrashFS) Remove(name string) error {
+	_, err := fs.apply(crashOp{kind: crashOpRemove, name: name}, false /* newFile */, func() error {
+		return fs.FS.Remove(name)
+	})
+	return err
+}
+
+// RemoveAll implements FS.RemoveAll.
+func (fs *CrashFS) RemoveAll(name string) error {
+	_, err := fs.apply(crashOp{kind: crashOpRemoveAll, name: name}, false /* newFile */, func() error {
+		return fs.FS.RemoveAll(name)
+	})
+	return err
+}
+
+// Rename implements FS.Rename.
+func (fs *CrashFS) Rename(oldname, newname string) error {
+	_, err := fs.apply(crashOp{kind: crashOpRename, name: oldname, newName: newname}, false /* newFile */, func() error {
+		return fs.FS.Rename(oldname, newname)
+	})
+	return err
+}
+
+// ReuseForWrite implements FS.ReuseForWrite.
+func (fs *CrashFS) ReuseForWrite(oldname, newname string) (File, error) {
+	var f File
+	op := crashOp{kind: crashOpReuseForWrite, name: oldname, newName: newname}
+	id, err := fs.apply(op, true /* newFile */, func() (err error) {
+		f, err = fs.FS.ReuseForWrite(oldname, newname)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &crashFile{File: f, fs: fs, id: id}, nil
+}
+
+// MkdirAll implements FS.MkdirAll.
+func (fs *CrashFS) MkdirAll(dir string, perm os.FileMode) error {
+	_, err := fs.apply(crashOp{kind: crashOpMkdirAll, name: dir}, false /* newFile */, func() error {
+		return fs.FS.MkdirAll(dir, perm)
+	})
+	return err
+}
+
+// crashFile records the writes and syncs of a file created through a
+// CrashFS.
+type crashFile struct {
+	File
+	fs *CrashFS
+	id int
+}
+
+var _ File = (*crashFile)(nil)
+
+func (f *crashFile) Write(p []byte) (int, error) {
+	// Write is allowed to modify p, so copy it before writing.
+	data := append([]byte(nil), p...)
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	n, err := f.File.Write(p)
+	if n > 0 {
+		f.fs.mu.ops = append(f.fs.mu.ops, crashOp{kind: crashOpWrite, fileID: f.id, data: data[:n]})
+	}
+	return n, err
+}
+
+func (f *crashFile) Sync() error {
+	_, err := f.fs.apply(crashOp{kind: crashOpSync, fileID: f.id}, false /* newFile */, func() error {
+		return f.File.Sync()
+	})
+	return err
+}
+
+func (f *crashFile) SyncData() error {
+	return f.Sync()
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/stretchr/testify/require"
+)
+
+func readCrashFile(t *testing.T, fs FS, name string) string {
+	f, err := fs.Open(name)
+	if oserror.IsNotExist(err) {
+		return "<missing>"
+	}
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestCrashFS(t *testing.T) {
+	fs := NewCrashFS()
+	require.NoError(t, fs.MkdirAll("a", 0755))
+	dir, err := fs.OpenDir("a")
+	require.NoError(t, err)
+
+	f, err := fs.Create("a/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	afterFileSync := fs.NumOps()
+	require.NoError(t, dir.Sync())
+	afterDirSync := fs.NumOps()
+	_, err = f.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	g, err := fs.Create("a/bar")
+	require.NoError(t, err)
+	_, err = g.Write([]byte("bar"))
+	require.NoError(t, err)
+	require.NoError(t, g.Sync())
+	require.NoError(t, g.Close())
+	require.NoError(t, fs.Rename("a/foo", "a/baz"))
+	afterRename := fs.NumOps()
+	require.NoError(t, dir.Sync())
+	require.NoError(t, dir.Close())
+
+	// The live state reflects all of the operations.
+	require.Equal(t, "hello world", readCrashFile(t, fs, "a/baz"))
+	require.Equal(t, "bar", readCrashFile(t, fs, "a/bar"))
+
+	for _, tc := range []struct {
+		numOps        int
+		foo, baz, bar string
+	}{
+		// The file was synced, but not the directory entry.
+		{afterFileSync, "<missing>", "<missing>", "<missing>"},
+		// Only the synced prefix of the file survives.
+		{afterDirSync, "hello", "<missing>", "<missing>"},
+		// The rename and the creation of bar are not durable.
+		{afterRename, "hello", "<missing>", "<missing>"},
+		// Everything is durable except for the unsynced suffix of foo.
+		{fs.NumOps(), "<missing>", "hello", "bar"},
+	} {
+		crashFS, err := fs.CrashState(tc.numOps)
+		require.NoError(t, err)
+		require.Equal(t, tc.foo, readCrashFile(t, crashFS, "a/foo"), "%d", tc.numOps)
+		require.Equal(t, tc.baz, readCrashFile(t, crashFS, "a/baz"), "%d", tc.numOps)
+		require.Equal(t, tc.bar, readCrashFile(t, crashFS, "a/bar"), "%d", tc.numOps)
+	}
+
+	_, err = fs.CrashState(fs.NumOps() + 1)
+	require.Error(t, err)
+
+	var points []int
+	require.NoError(t, fs.ForEachCrashState(func(numOps int, crashFS *MemFS) error {
+		points = append(points, numOps)
+		// The directory is durable as soon as it is created.
+		if numOps > 0 {
+			_, err := crashFS.Stat("a")
+			require.NoError(t, err)
+		}
+		return nil
+	}))
+	require.True(t, sort.IntsAreSorted(points))
+	require.Contains(t, points, afterFileSync)
+	require.Contains(t, points, afterDirSync)
+	require.Equal(t, fs.NumOps(), points[len(points)-1])
+}
+
+func TestCrashFSRandomCrashState(t *testing.T) {
+	fs := NewCrashFS()
+	dir, err := fs.OpenDir("")
+	require.NoError(t, err)
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("synced"))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	require.NoError(t, dir.Sync())
+	_, err = f.Write([]byte("-unsynced"))
+	require.NoError(t, err)
+
+	// Every random crash state retains the synced data, followed by a prefix
+	// of the unsynced data.
+	seen := map[string]bool{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		crashFS, err := fs.RandomCrashState(fs.NumOps(), rng)
+		require.NoError(t, err)
+		data := readCrashFile(t, crashFS, "foo")
+		require.Equal(t, "synced-unsynced"[:len(data)], data)
+		require.GreaterOrEqual(t, len(data), len("synced"))
+		seen[data] = true
+	}
+	require.Greater(t, len(seen), 1)
+}
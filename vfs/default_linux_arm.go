@@ -59,6 +59,10 @@ func (f *linuxOnArmFile) Prefetch(offset int64, length int64) error {
 
 func (f *linuxOnArmFile) Preallocate(offset, length int64) error { return nil }
 
+func (f *linuxOnArmFile) setDirectIO(enabled bool) error {
+	return setDirectIOFlag(f.fd, enabled)
+}
+
 func (f *linuxOnArmFile) SyncData() error {
 	// TODO(radu): does arm support unix.Fdatasync?
 	return f.Sync()
@@ -61,6 +61,10 @@ func (f *linuxFile) Preallocate(offset, length int64) error {
 	return unix.Fallocate(int(f.fd), unix.FALLOC_FL_KEEP_SIZE, offset, length)
 }
 
+func (f *linuxFile) setDirectIO(enabled bool) error {
+	return setDirectIOFlag(f.fd, enabled)
+}
+
 func (f *linuxFile) SyncData() error {
 	return unix.Fdatasync(int(f.fd))
 }
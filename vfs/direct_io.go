@@ -0,0 +1,53 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"unsafe"
+
+	"github.com/cockroachdb/errors"
+)
+
+// DirectIOAlignment is the alignment required for direct I/O: while direct
+// I/O is enabled on a file, the memory buffers, file offsets and lengths of
+// all reads and writes must be multiples of DirectIOAlignment.
+const DirectIOAlignment = 4096
+
+// ErrDirectIOUnsupported is returned by SetDirectIO when the file does not
+// support direct I/O.
+var ErrDirectIOUnsupported = errors.New("pebble/vfs: direct I/O not supported")
+
+// directIOFile is implemented by files that support direct I/O, including
+// wrappers that forward it to the file they wrap.
+type directIOFile interface {
+	setDirectIO(enabled bool) error
+}
+
+// SetDirectIO enables or disables direct (unbuffered) I/O on the file, which
+// bypasses the OS page cache. It is currently only supported on Linux, for
+// files backed by the default FS (possibly through wrappers that forward it,
+// like the disk-health checking FS); other files return
+// ErrDirectIOUnsupported. The underlying filesystem may also reject direct
+// I/O.
+//
+// While direct I/O is enabled, all reads and writes must obey
+// DirectIOAlignment. See AlignedBuffer.
+func SetDirectIO(f File, enabled bool) error {
+	if d, ok := f.(directIOFile); ok {
+		return d.setDirectIO(enabled)
+	}
+	return ErrDirectIOUnsupported
+}
+
+// AlignedBuffer returns a zeroed buffer of the given size whose memory is
+// aligned to DirectIOAlignment.
+func AlignedBuffer(size int) []byte {
+	buf := make([]byte, size+DirectIOAlignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (DirectIOAlignment - 1)); rem != 0 {
+		off = DirectIOAlignment - rem
+	}
+	return buf[off : off+size : off+size]
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlignedBuffer(t *testing.T) {
+	for _, size := range []int{0, 1, 100, DirectIOAlignment, 3*DirectIOAlignment + 5} {
+		buf := AlignedBuffer(size)
+		require.Equal(t, size, len(buf))
+		require.Equal(t, size, cap(buf))
+		if size > 0 {
+			require.Zero(t, uintptr(unsafe.Pointer(&buf[0]))%DirectIOAlignment)
+		}
+	}
+}
+
+func TestSetDirectIO(t *testing.T) {
+	// Files that are not backed by the OS do not support direct I/O.
+	mem := NewMem()
+	f, err := mem.Create("foo")
+	require.NoError(t, err)
+	require.True(t, errors.Is(SetDirectIO(f, true), ErrDirectIOUnsupported))
+	require.NoError(t, f.Close())
+
+	fs, closer := WithDiskHealthChecks(Default, time.Second, func(DiskSlowInfo) {})
+	defer closer.Close()
+	name := Default.PathJoin(t.TempDir(), "foo")
+	f, err = fs.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+	if err := SetDirectIO(f, true); err != nil {
+		t.Skipf("direct I/O not supported: %v", err)
+	}
+	data := AlignedBuffer(2 * DirectIOAlignment)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	expected := append([]byte(nil), data...)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+
+	buf := AlignedBuffer(DirectIOAlignment)
+	_, err = f.ReadAt(buf, DirectIOAlignment)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(expected[DirectIOAlignment:], buf))
+
+	// Unaligned writes are possible once direct I/O is disabled.
+	require.NoError(t, SetDirectIO(f, false))
+	_, err = f.Write([]byte("tail"))
+	require.NoError(t, err)
+	stat, err := f.Stat()
+	require.NoError(t, err)
+	require.Equal(t, int64(2*DirectIOAlignment+4), stat.Size())
+}
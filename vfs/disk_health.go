@@ -210,6 +210,16 @@ func (d *diskHealthCheckingFile) ReadAt(p []byte, off int64) (int, error) {
 	return d.file.ReadAt(p, off)
 }
 
+// setIOClass forwards the I/O class to the wrapped file (see SetIOClass).
+func (d *diskHealthCheckingFile) setIOClass(class IOClass) {
+	SetIOClass(d.file, class)
+}
+
+// setDirectIO forwards to the wrapped file (see SetDirectIO).
+func (d *diskHealthCheckingFile) setDirectIO(enabled bool) error {
+	return SetDirectIO(d.file, enabled)
+}
+
 // Write implements the io.Writer interface.
 func (d *diskHealthCheckingFile) Write(p []byte) (n int, err error) {
 	d.timeDiskOp(OpTypeWrite, int64(len(p)), func() {
@@ -0,0 +1,446 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+)
+
+// EncryptionKey is a named AES key used by an encrypted FS to protect the
+// per-file data keys.
+type EncryptionKey struct {
+	// ID identifies the key. It is stored in the header of every file
+	// encrypted under the key, and must be non-empty and at most
+	// MaxEncryptionKeyIDLen bytes long.
+	ID string
+	// Key is the AES-128, AES-192 or AES-256 key material.
+	Key []byte
+}
+
+// MaxEncryptionKeyIDLen is the maximum length of an EncryptionKey.ID.
+const MaxEncryptionKeyIDLen = 64
+
+// KeyManager provides the keys used by an encrypted FS.
+//
+// Key rotation is performed by changing the key returned by ActiveKey: every
+// file created after the change is encrypted under the new key, while
+// existing files remain encrypted under the key they were created with until
+// they are rewritten (sstables by compactions, WAL and MANIFEST files by
+// rotation) or deleted. Old keys must therefore remain available through Key
+// for as long as files referencing them exist; EncryptionKeyID can be used to
+// determine which key a file references.
+type KeyManager interface {
+	// ActiveKey returns the key used to encrypt newly created files.
+	ActiveKey() (EncryptionKey, error)
+	// Key returns the key with the given ID. It is used to decrypt existing
+	// files.
+	Key(id string) (EncryptionKey, error)
+}
+
+// StaticKeyManager is a KeyManager backed by a fixed list of keys. The last
+// key in the list is the active key. Rotation is achieved by appending a new
+// key and reopening the store.
+type StaticKeyManager []EncryptionKey
+
+var _ KeyManager = StaticKeyManager(nil)
+
+// ActiveKey implements the KeyManager interface.
+func (m StaticKeyManager) ActiveKey() (EncryptionKey, error) {
+	if len(m) == 0 {
+		return EncryptionKey{}, errors.New("pebble/vfs: no encryption keys")
+	}
+	return m[len(m)-1], nil
+}
+
+// Key implements the KeyManager interface.
+func (m StaticKeyManager) Key(id string) (EncryptionKey, error) {
+	for i := range m {
+		if m[i].ID == id {
+			return m[i], nil
+		}
+	}
+	return EncryptionKey{}, errors.Errorf("pebble/vfs: unknown encryption key %q", id)
+}
+
+// The layout of the header that precedes the contents of every encrypted
+// file:
+//
+//	+-------+---------+-----------+---------+---------+-------+-----------+
+//	| magic | version | keyID len |  keyID  | CTR IV  | nonce | data key  |
+//	|  (8)  |   (1)   |    (1)    |  (64)   |  (16)   | (12)  | (32 + 16) |
+//	+-------+---------+-----------+---------+---------+-------+-----------+
+//
+// The file contents are encrypted with AES-256 in CTR mode using a random
+// data key that is generated when the file is created. The data key is
+// stored in the header, sealed with AES-GCM under the key identified by
+// keyID. The GCM additional data covers all of the header up to the nonce so
+// that the key ID and the IV cannot be tampered with.
+const (
+	encryptedFileMagic   = "\x89PBLENC\n"
+	encryptedFileVersion = 1
+
+	encHeaderVersionOffset = len(encryptedFileMagic)
+	encHeaderKeyIDLenOff   = encHeaderVersionOffset + 1
+	encHeaderKeyIDOffset   = encHeaderKeyIDLenOff + 1
+	encHeaderIVOffset      = encHeaderKeyIDOffset + MaxEncryptionKeyIDLen
+	encHeaderNonceOffset   = encHeaderIVOffset + aes.BlockSize
+	encHeaderDataKeyOffset = encHeaderNonceOffset + encNonceLen
+	encHeaderLen           = encHeaderDataKeyOffset + encDataKeyLen + encTagLen
+
+	encNonceLen   = 12
+	encTagLen     = 16
+	encDataKeyLen = 32
+)
+
+// WithEncryption wraps an FS so that the contents of all files created
+// through it are transparently encrypted, and the contents of all files
+// opened through it are decrypted. This covers every file Pebble writes
+// (sstables, WAL, MANIFEST, OPTIONS and temporary files). File and directory
+// names are not encrypted.
+//
+// Every file receives its own randomly generated data key, which is stored in
+// a fixed-size header at the start of the file, encrypted under the
+// KeyManager's active key. The sizes reported by Stat exclude the header. See
+// KeyManager for a description of key rotation.
+//
+// Files that are shorter than the header, such as a file that was created but
+// never written to before a crash, are treated as empty. Reading a file that
+// was not written through an encrypted FS returns an error.
+func WithEncryption(fs FS, keyManager KeyManager) FS {
+	return &encryptedFS{
+		FS:         fs,
+		keyManager: keyManager,
+	}
+}
+
+// EncryptionKeyID returns the ID of the key the data key of the named file is
+// encrypted under. The file must have been written by an encrypted FS; fs is
+// the underlying (unencrypted) FS. It returns an empty string if the file is
+// shorter than the encryption header.
+func EncryptionKeyID(fs FS, name string) (string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var hdr [encHeaderLen]byte
+	if n, err := io.ReadFull(f, hdr[:]); err != nil {
+		if n < encHeaderLen && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return "", nil
+		}
+		return "", err
+	}
+	keyID, err := decodeEncryptionHeaderKeyID(hdr[:])
+	if err != nil {
+		return "", errors.Wrapf(err, "pebble/vfs: %s", errors.Safe(name))
+	}
+	return keyID, nil
+}
+
+type encryptedFS struct {
+	FS
+	keyManager KeyManager
+}
+
+var _ FS = (*encryptedFS)(nil)
+
+func (fs *encryptedFS) Create(name string) (File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	ef, err := fs.initFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "pebble/vfs: %s", errors.Safe(name))
+	}
+	return ef, nil
+}
+
+func (fs *encryptedFS) ReuseForWrite(oldname, newname string) (File, error) {
+	f, err := fs.FS.ReuseForWrite(oldname, newname)
+	if err != nil {
+		return nil, err
+	}
+	// The reused file is overwritten from the start, so it receives a fresh
+	// header and data key. Any stale contents beyond what is subsequently
+	// written remain encrypted under the previous data key and decrypt to
+	// garbage, the same as if they had been left in place by a non-encrypted
+	// FS.
+	ef, err := fs.initFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "pebble/vfs: %s", errors.Safe(newname))
+	}
+	return ef, nil
+}
+
+func (fs *encryptedFS) Open(name string, opts ...OpenOption) (File, error) {
+	f, err := fs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	ef, err := fs.openFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "pebble/vfs: %s", errors.Safe(name))
+	}
+	for _, opt := range opts {
+		opt.Apply(ef)
+	}
+	return ef, nil
+}
+
+func (fs *encryptedFS) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.FS.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return encryptedFileInfo{info}, nil
+}
+
+// initFile generates a data key for a newly created file and writes the
+// header to it.
+func (fs *encryptedFS) initFile(f File) (*encryptedFile, error) {
+	key, err := fs.keyManager.ActiveKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(key.ID) == 0 || len(key.ID) > MaxEncryptionKeyIDLen {
+		return nil, errors.Errorf("pebble/vfs: invalid encryption key ID %q", key.ID)
+	}
+	aead, err := newKeyAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var hdr [encHeaderLen]byte
+	copy(hdr[:], encryptedFileMagic)
+	hdr[encHeaderVersionOffset] = encryptedFileVersion
+	hdr[encHeaderKeyIDLenOff] = byte(len(key.ID))
+	copy(hdr[encHeaderKeyIDOffset:], key.ID)
+	dataKey := make([]byte, encDataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(hdr[encHeaderIVOffset:encHeaderDataKeyOffset]); err != nil {
+		return nil, err
+	}
+	aead.Seal(hdr[encHeaderDataKeyOffset:encHeaderDataKeyOffset],
+		hdr[encHeaderNonceOffset:encHeaderDataKeyOffset], dataKey, hdr[:encHeaderNonceOffset])
+
+	ef, err := newEncryptedFile(f, dataKey, hdr[encHeaderIVOffset:encHeaderNonceOffset])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return ef, nil
+}
+
+// openFile reads the header of an existing file and recovers its data key.
+func (fs *encryptedFS) openFile(f File) (*encryptedFile, error) {
+	var hdr [encHeaderLen]byte
+	if n, err := io.ReadFull(f, hdr[:]); err != nil {
+		if n < encHeaderLen && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			// The file was never fully initialized; treat it as empty.
+			return &encryptedFile{File: f, empty: true}, nil
+		}
+		return nil, err
+	}
+	keyID, err := decodeEncryptionHeaderKeyID(hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	key, err := fs.keyManager.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newKeyAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, hdr[encHeaderNonceOffset:encHeaderDataKeyOffset],
+		hdr[encHeaderDataKeyOffset:], hdr[:encHeaderNonceOffset])
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt data key with key %q", keyID)
+	}
+	return newEncryptedFile(f, dataKey, hdr[encHeaderIVOffset:encHeaderNonceOffset])
+}
+
+func decodeEncryptionHeaderKeyID(hdr []byte) (string, error) {
+	if string(hdr[:len(encryptedFileMagic)]) != encryptedFileMagic {
+		return "", errors.New("not an encrypted file (bad magic number)")
+	}
+	if v := hdr[encHeaderVersionOffset]; v != encryptedFileVersion {
+		return "", errors.Errorf("unsupported encrypted file version %d", errors.Safe(v))
+	}
+	n := int(hdr[encHeaderKeyIDLenOff])
+	if n == 0 || n > MaxEncryptionKeyIDLen {
+		return "", errors.Errorf("invalid encryption key ID length %d", errors.Safe(n))
+	}
+	return string(hdr[encHeaderKeyIDOffset : encHeaderKeyIDOffset+n]), nil
+}
+
+func newKeyAEAD(key EncryptionKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid encryption key %q", key.ID)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedFileInfo adjusts the size of a file to exclude the header.
+type encryptedFileInfo struct {
+	os.FileInfo
+}
+
+func (info encryptedFileInfo) Size() int64 {
+	if info.IsDir() {
+		return info.FileInfo.Size()
+	}
+	if size := info.FileInfo.Size(); size > int64(encHeaderLen) {
+		return size - int64(encHeaderLen)
+	}
+	return 0
+}
+
+// encryptedFile encrypts and decrypts the contents of an underlying File.
+// Offsets passed to and returned from its methods are logical offsets, which
+// exclude the header.
+type encryptedFile struct {
+	File
+	block cipher.Block
+	iv    [aes.BlockSize]byte
+	// empty is set for files that are shorter than the header. Reads from
+	// such files return io.EOF and writes fail.
+	empty bool
+	// readOffset is the logical offset of the next sequential Read.
+	readOffset int64
+	// writeOffset is the logical offset of the next sequential Write, and
+	// writeStream the keystream positioned at writeOffset. The stream is
+	// reset after a failed write.
+	writeOffset int64
+	writeStream cipher.Stream
+}
+
+var _ File = (*encryptedFile)(nil)
+
+func newEncryptedFile(f File, dataKey, iv []byte) (*encryptedFile, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ef := &encryptedFile{File: f, block: block}
+	copy(ef.iv[:], iv)
+	return ef, nil
+}
+
+// streamAt returns a keystream positioned at the given logical offset.
+func (f *encryptedFile) streamAt(offset int64) cipher.Stream {
+	// Advance the 128-bit big-endian counter by the number of whole blocks
+	// preceding offset, then discard the keystream within the first block.
+	var iv [aes.BlockSize]byte
+	hi := binary.BigEndian.Uint64(f.iv[:8])
+	lo := binary.BigEndian.Uint64(f.iv[8:])
+	newLo := lo + uint64(offset/aes.BlockSize)
+	if newLo < lo {
+		hi++
+	}
+	binary.BigEndian.PutUint64(iv[:8], hi)
+	binary.BigEndian.PutUint64(iv[8:], newLo)
+	stream := cipher.NewCTR(f.block, iv[:])
+	if skip := offset % aes.BlockSize; skip > 0 {
+		var discard [aes.BlockSize]byte
+		stream.XORKeyStream(discard[:skip], discard[:skip])
+	}
+	return stream
+}
+
+func (f *encryptedFile) Read(p []byte) (int, error) {
+	if f.empty {
+		return 0, io.EOF
+	}
+	n, err := f.File.Read(p)
+	if n > 0 {
+		f.streamAt(f.readOffset).XORKeyStream(p[:n], p[:n])
+		f.readOffset += int64(n)
+	}
+	return n, err
+}
+
+func (f *encryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.empty {
+		return 0, io.EOF
+	}
+	n, err := f.File.ReadAt(p, off+int64(encHeaderLen))
+	if n > 0 {
+		f.streamAt(off).XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+// readAtWithIOClass forwards the I/O class of the read to the wrapped file
+// (see ReadAtWithIOClass).
+func (f *encryptedFile) readAtWithIOClass(p []byte, off int64, class IOClass) (int, error) {
+	if f.empty {
+		return 0, io.EOF
+	}
+	n, err := ReadAtWithIOClass(f.File, p, off+int64(encHeaderLen), class)
+	if n > 0 {
+		f.streamAt(off).XORKeyStream(p[:n], p[:n])
+	}
+	return n, err
+}
+
+// setIOClass forwards the I/O class to the wrapped file (see SetIOClass).
+func (f *encryptedFile) setIOClass(class IOClass) {
+	SetIOClass(f.File, class)
+}
+
+// Write encrypts p in place, which is permitted by the File interface.
+func (f *encryptedFile) Write(p []byte) (int, error) {
+	if f.empty {
+		return 0, errors.New("pebble/vfs: file was not created for writing")
+	}
+	if f.writeStream == nil {
+		f.writeStream = f.streamAt(f.writeOffset)
+	}
+	f.writeStream.XORKeyStream(p, p)
+	n, err := f.File.Write(p)
+	f.writeOffset += int64(n)
+	if n < len(p) {
+		f.writeStream = nil
+	}
+	return n, err
+}
+
+func (f *encryptedFile) Preallocate(offset, length int64) error {
+	return f.File.Preallocate(offset+int64(encHeaderLen), length)
+}
+
+func (f *encryptedFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return encryptedFileInfo{info}, nil
+}
+
+func (f *encryptedFile) SyncTo(length int64) (fullSync bool, err error) {
+	return f.File.SyncTo(length + int64(encHeaderLen))
+}
+
+func (f *encryptedFile) Prefetch(offset int64, length int64) error {
+	return f.File.Prefetch(offset+int64(encHeaderLen), length)
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEncryptionKey(id string) EncryptionKey {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(len(id) + i)
+	}
+	return EncryptionKey{ID: id, Key: key}
+}
+
+func TestEncryptedFS(t *testing.T) {
+	mem := NewMem()
+	fs := WithEncryption(mem, StaticKeyManager{testEncryptionKey("k1")})
+
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 10000)
+	rng.Read(data)
+
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	// Write the data in irregular pieces. Write may modify its argument, so
+	// pass it a copy.
+	for off := 0; off < len(data); {
+		n := 1 + rng.Intn(700)
+		if off+n > len(data) {
+			n = len(data) - off
+		}
+		_, err := f.Write(append([]byte(nil), data[off:off+n]...))
+		require.NoError(t, err)
+		off += n
+	}
+	info, err := f.Stat()
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), info.Size())
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+
+	info, err = fs.Stat("foo")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), info.Size())
+
+	// The underlying file must not contain the plaintext.
+	raw, err := mem.Open("foo")
+	require.NoError(t, err)
+	rawData, err := io.ReadAll(raw)
+	require.NoError(t, err)
+	require.NoError(t, raw.Close())
+	require.Equal(t, len(data)+encHeaderLen, len(rawData))
+	require.False(t, bytes.Contains(rawData, data[:64]))
+
+	f, err = fs.Open("foo")
+	require.NoError(t, err)
+	got, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, data, got)
+
+	for i := 0; i < 100; i++ {
+		off := rng.Intn(len(data))
+		n := rng.Intn(len(data) - off + 1)
+		buf := make([]byte, n)
+		_, err := f.ReadAt(buf, int64(off))
+		require.NoError(t, err)
+		require.Equal(t, data[off:off+n], buf)
+	}
+	require.NoError(t, f.Close())
+
+	keyID, err := EncryptionKeyID(mem, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "k1", keyID)
+}
+
+func TestEncryptedFSKeyRotation(t *testing.T) {
+	mem := NewMem()
+	k1, k2 := testEncryptionKey("k1"), testEncryptionKey("key-two")
+
+	writeFile := func(fs FS, name, contents string) {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(contents))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	readFile := func(fs FS, name string) (string, error) {
+		f, err := fs.Open(name)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		b, err := io.ReadAll(f)
+		return string(b), err
+	}
+
+	writeFile(WithEncryption(mem, StaticKeyManager{k1}), "a", "hello")
+
+	// After rotation, new files use the new key and old files remain readable.
+	fs := WithEncryption(mem, StaticKeyManager{k1, k2})
+	writeFile(fs, "b", "world")
+	for name, expected := range map[string]string{"a": "hello", "b": "world"} {
+		got, err := readFile(fs, name)
+		require.NoError(t, err)
+		require.Equal(t, expected, got)
+	}
+	id, err := EncryptionKeyID(mem, "b")
+	require.NoError(t, err)
+	require.Equal(t, "key-two", id)
+
+	// Once the old key is retired, files still referencing it cannot be read.
+	fs = WithEncryption(mem, StaticKeyManager{k2})
+	_, err = readFile(fs, "a")
+	require.Error(t, err)
+
+	// A key with the right ID but different key material is detected.
+	bad := testEncryptionKey("k1")
+	bad.Key[0]++
+	_, err = readFile(WithEncryption(mem, StaticKeyManager{bad}), "a")
+	require.Error(t, err)
+
+	// Files not written through an encrypted FS are rejected.
+	writeFile(mem, "plain", "this file is long enough to hold a header ........"+
+		"........................................................................"+
+		"........................................................................")
+	_, err = readFile(fs, "plain")
+	require.Error(t, err)
+}
+
+func TestEncryptedFSEmptyFile(t *testing.T) {
+	mem := NewMem()
+	fs := WithEncryption(mem, StaticKeyManager{testEncryptionKey("k1")})
+
+	// A file that was created but never had its header written (for example
+	// due to a crash) is treated as empty.
+	f, err := mem.Create("empty")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	info, err := fs.Stat("empty")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), info.Size())
+	f, err = fs.Open("empty")
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Empty(t, b)
+	require.NoError(t, f.Close())
+
+	// A file created through the encrypted FS without any writes is empty.
+	f, err = fs.Create("created")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	info, err = fs.Stat("created")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), info.Size())
+}
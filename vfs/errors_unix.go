@@ -13,6 +13,7 @@ import (
 )
 
 var errNotEmpty = unix.ENOTEMPTY
+var errNoSpace = unix.ENOSPC
 
 // IsNoSpaceError returns true if the given error indicates that the disk is
 // out of space.
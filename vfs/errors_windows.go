@@ -13,6 +13,7 @@ import (
 )
 
 var errNotEmpty = windows.ERROR_DIR_NOT_EMPTY
+var errNoSpace = windows.ERROR_DISK_FULL
 
 // IsNoSpaceError returns true if the given error indicates that the disk is
 // out of space.
@@ -108,6 +108,11 @@ func (f *loggingFile) Close() error {
 	return f.File.Close()
 }
 
+func (f *loggingFile) setDirectIO(enabled bool) error {
+	f.logFn("set-direct-io(%t): %s", enabled, f.name)
+	return SetDirectIO(f.File, enabled)
+}
+
 func (f *loggingFile) Sync() error {
 	f.logFn("sync: %s", f.name)
 	return f.File.Sync()
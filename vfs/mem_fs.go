@@ -83,6 +83,18 @@ type MemFS struct {
 	// open files. In tests meant to exercise this behavior, this flag can be
 	// set to error if removing an open file.
 	windowsSemantics bool
+
+	// faults holds the configuration of the simulated faults and latency. See
+	// SetCapacity, SetInjector and SetLatency.
+	faults struct {
+		sync.RWMutex
+		capacity int64
+		inject   func(op MemFSOp, path string) error
+		latency  [numMemFSOps]time.Duration
+		// growMu serializes the writes that grow files while a capacity is
+		// set, so that they cannot collectively exceed it.
+		growMu sync.Mutex
+	}
 }
 
 var _ FS = &MemFS{}
@@ -197,6 +209,9 @@ func (y *MemFS) walk(fullname string, f func(dir *memNode, frag string, final bo
 
 // Create implements FS.Create.
 func (y *MemFS) Create(fullname string) (File, error) {
+	if err := y.maybeInject(MemFSOpCreate, fullname); err != nil {
+		return nil, err
+	}
 	var ret *memFile
 	err := y.walk(fullname, func(dir *memNode, frag string, final bool) error {
 		if final {
@@ -208,6 +223,7 @@ func (y *MemFS) Create(fullname string) (File, error) {
 			ret = &memFile{
 				n:     n,
 				fs:    y,
+				path:  fullname,
 				write: true,
 			}
 		}
@@ -222,6 +238,9 @@ func (y *MemFS) Create(fullname string) (File, error) {
 
 // Link implements FS.Link.
 func (y *MemFS) Link(oldname, newname string) error {
+	if err := y.maybeInject(MemFSOpLink, oldname); err != nil {
+		return err
+	}
 	var n *memNode
 	err := y.walk(oldname, func(dir *memNode, frag string, final bool) error {
 		if final {
@@ -268,8 +287,9 @@ func (y *MemFS) open(fullname string) (File, error) {
 		if final {
 			if frag == "" {
 				ret = &memFile{
-					n:  dir,
-					fs: y,
+					n:    dir,
+					fs:   y,
+					path: fullname,
 				}
 				return nil
 			}
@@ -277,6 +297,7 @@ func (y *MemFS) open(fullname string) (File, error) {
 				ret = &memFile{
 					n:    n,
 					fs:   y,
+					path: fullname,
 					read: true,
 				}
 			}
@@ -299,16 +320,25 @@ func (y *MemFS) open(fullname string) (File, error) {
 
 // Open implements FS.Open.
 func (y *MemFS) Open(fullname string, opts ...OpenOption) (File, error) {
+	if err := y.maybeInject(MemFSOpOpen, fullname); err != nil {
+		return nil, err
+	}
 	return y.open(fullname)
 }
 
 // OpenDir implements FS.OpenDir.
 func (y *MemFS) OpenDir(fullname string) (File, error) {
+	if err := y.maybeInject(MemFSOpOpenDir, fullname); err != nil {
+		return nil, err
+	}
 	return y.open(fullname)
 }
 
 // Remove implements FS.Remove.
 func (y *MemFS) Remove(fullname string) error {
+	if err := y.maybeInject(MemFSOpRemove, fullname); err != nil {
+		return err
+	}
 	return y.walk(fullname, func(dir *memNode, frag string, final bool) error {
 		if final {
 			if frag == "" {
@@ -338,6 +368,9 @@ func (y *MemFS) Remove(fullname string) error {
 
 // RemoveAll implements FS.RemoveAll.
 func (y *MemFS) RemoveAll(fullname string) error {
+	if err := y.maybeInject(MemFSOpRemoveAll, fullname); err != nil {
+		return err
+	}
 	err := y.walk(fullname, func(dir *memNode, frag string, final bool) error {
 		if final {
 			if frag == "" {
@@ -361,6 +394,9 @@ func (y *MemFS) RemoveAll(fullname string) error {
 
 // Rename implements FS.Rename.
 func (y *MemFS) Rename(oldname, newname string) error {
+	if err := y.maybeInject(MemFSOpRename, oldname); err != nil {
+		return err
+	}
 	var n *memNode
 	err := y.walk(oldname, func(dir *memNode, frag string, final bool) error {
 		if final {
@@ -399,7 +435,7 @@ func (y *MemFS) ReuseForWrite(oldname, newname string) (File, error) {
 	if err := y.Rename(oldname, newname); err != nil {
 		return nil, err
 	}
-	f, err := y.Open(newname)
+	f, err := y.open(newname)
 	if err != nil {
 		return nil, err
 	}
@@ -414,6 +450,9 @@ func (y *MemFS) ReuseForWrite(oldname, newname string) (File, error) {
 
 // MkdirAll implements FS.MkdirAll.
 func (y *MemFS) MkdirAll(dirname string, perm os.FileMode) error {
+	if err := y.maybeInject(MemFSOpMkdirAll, dirname); err != nil {
+		return err
+	}
 	return y.walk(dirname, func(dir *memNode, frag string, final bool) error {
 		if frag == "" {
 			if final {
@@ -451,6 +490,9 @@ func (y *MemFS) Lock(fullname string) (io.Closer, error) {
 
 // List implements FS.List.
 func (y *MemFS) List(dirname string) ([]string, error) {
+	if err := y.maybeInject(MemFSOpList, dirname); err != nil {
+		return nil, err
+	}
 	if !strings.HasSuffix(dirname, sep) {
 		dirname += sep
 	}
@@ -472,7 +514,10 @@ func (y *MemFS) List(dirname string) ([]string, error) {
 
 // Stat implements FS.Stat.
 func (y *MemFS) Stat(name string) (os.FileInfo, error) {
-	f, err := y.Open(name)
+	if err := y.maybeInject(MemFSOpStat, name); err != nil {
+		return nil, err
+	}
+	f, err := y.open(name)
 	if err != nil {
 		if pe, ok := err.(*os.PathError); ok {
 			pe.Op = "stat"
@@ -504,9 +549,23 @@ func (*MemFS) PathDir(p string) string {
 	return path.Dir(p)
 }
 
-// GetDiskUsage implements FS.GetDiskUsage.
-func (*MemFS) GetDiskUsage(string) (DiskUsage, error) {
-	return DiskUsage{}, ErrUnsupported
+// GetDiskUsage implements FS.GetDiskUsage. It is only supported if the MemFS
+// has a capacity (see SetCapacity).
+func (y *MemFS) GetDiskUsage(string) (DiskUsage, error) {
+	capacity := y.capacity()
+	if capacity <= 0 {
+		return DiskUsage{}, ErrUnsupported
+	}
+	used := y.usedBytes()
+	var avail int64
+	if used < capacity {
+		avail = capacity - used
+	}
+	return DiskUsage{
+		AvailBytes: uint64(avail),
+		TotalBytes: uint64(capacity),
+		UsedBytes:  uint64(used),
+	}, nil
 }
 
 // memNode holds a file's data or a directory's children, and implements os.FileInfo.
@@ -621,6 +680,7 @@ func (f *memNode) resetToSyncedState() {
 type memFile struct {
 	n           *memNode
 	fs          *MemFS // nil for a standalone memFile
+	path        string
 	rpos        int
 	wpos        int
 	read, write bool
@@ -643,6 +703,11 @@ func (f *memFile) Read(p []byte) (int, error) {
 	if f.n.isDir {
 		return 0, errors.New("pebble/vfs: cannot read a directory")
 	}
+	if f.fs != nil {
+		if err := f.fs.maybeInject(MemFSOpFileRead, f.path); err != nil {
+			return 0, err
+		}
+	}
 	f.n.mu.Lock()
 	defer f.n.mu.Unlock()
 	if f.rpos >= len(f.n.mu.data) {
@@ -660,6 +725,11 @@ func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
 	if f.n.isDir {
 		return 0, errors.New("pebble/vfs: cannot read a directory")
 	}
+	if f.fs != nil {
+		if err := f.fs.maybeInject(MemFSOpFileRead, f.path); err != nil {
+			return 0, err
+		}
+	}
 	f.n.mu.Lock()
 	defer f.n.mu.Unlock()
 	if off >= int64(len(f.n.mu.data)) {
@@ -675,6 +745,21 @@ func (f *memFile) Write(p []byte) (int, error) {
 	if f.n.isDir {
 		return 0, errors.New("pebble/vfs: cannot write a directory")
 	}
+	if f.fs != nil {
+		if err := f.fs.maybeInject(MemFSOpFileWrite, f.path); err != nil {
+			return 0, err
+		}
+		if capacity := f.fs.capacity(); capacity > 0 {
+			f.fs.faults.growMu.Lock()
+			defer f.fs.faults.growMu.Unlock()
+			f.n.mu.Lock()
+			grow := f.wpos + len(p) - len(f.n.mu.data)
+			f.n.mu.Unlock()
+			if err := f.fs.checkCapacity(f.path, capacity, grow); err != nil {
+				return 0, err
+			}
+		}
+	}
 	f.n.mu.Lock()
 	defer f.n.mu.Unlock()
 	f.n.mu.modTime = time.Now()
@@ -706,6 +791,11 @@ func (f *memFile) Stat() (os.FileInfo, error) {
 }
 
 func (f *memFile) Sync() error {
+	if f.fs != nil {
+		if err := f.fs.maybeInject(MemFSOpFileSync, f.path); err != nil {
+			return err
+		}
+	}
 	if f.fs != nil && f.fs.strict {
 		f.fs.mu.Lock()
 		defer f.fs.mu.Unlock()
@@ -735,6 +825,11 @@ func (f *memFile) SyncTo(length int64) (fullSync bool, err error) {
 	// synced the data up to `length`. When fullSync=false, SyncTo provides no
 	// durability guarantees, so this can help surface bugs where we improperly
 	// rely on SyncTo providing durability.
+	if f.fs != nil {
+		if err := f.fs.maybeInject(MemFSOpFileSync, f.path); err != nil {
+			return false, err
+		}
+	}
 	return false, nil
 }
 
@@ -0,0 +1,167 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"os"
+	"time"
+)
+
+// MemFSOp is an enum describing an operation on a MemFS or one of its files,
+// used to inject faults and latency (see MemFS.SetInjector and
+// MemFS.SetLatency).
+type MemFSOp int
+
+const (
+	// MemFSOpCreate describes a create file operation, including Lock.
+	MemFSOpCreate MemFSOp = iota
+	// MemFSOpLink describes a hardlink operation.
+	MemFSOpLink
+	// MemFSOpOpen describes a file open operation.
+	MemFSOpOpen
+	// MemFSOpOpenDir describes a directory open operation.
+	MemFSOpOpenDir
+	// MemFSOpRemove describes a remove file operation.
+	MemFSOpRemove
+	// MemFSOpRemoveAll describes a recursive remove operation.
+	MemFSOpRemoveAll
+	// MemFSOpRename describes a rename operation, including ReuseForWrite.
+	MemFSOpRename
+	// MemFSOpMkdirAll describes a make directory including parents operation.
+	MemFSOpMkdirAll
+	// MemFSOpList describes a list directory operation.
+	MemFSOpList
+	// MemFSOpStat describes a path-based stat operation.
+	MemFSOpStat
+	// MemFSOpFileRead describes a file read operation, through Read or
+	// ReadAt.
+	MemFSOpFileRead
+	// MemFSOpFileWrite describes a file write operation.
+	MemFSOpFileWrite
+	// MemFSOpFileSync describes a file sync operation, through Sync, SyncData
+	// or SyncTo.
+	MemFSOpFileSync
+
+	numMemFSOps
+)
+
+var memFSOpNames = [numMemFSOps]string{
+	MemFSOpCreate:    "create",
+	MemFSOpLink:      "link",
+	MemFSOpOpen:      "open",
+	MemFSOpOpenDir:   "open-dir",
+	MemFSOpRemove:    "remove",
+	MemFSOpRemoveAll: "remove-all",
+	MemFSOpRename:    "rename",
+	MemFSOpMkdirAll:  "mkdir-all",
+	MemFSOpList:      "list",
+	MemFSOpStat:      "stat",
+	MemFSOpFileRead:  "read",
+	MemFSOpFileWrite: "write",
+	MemFSOpFileSync:  "sync",
+}
+
+// String implements fmt.Stringer.
+func (op MemFSOp) String() string {
+	if op < 0 || op >= numMemFSOps {
+		return "unknown"
+	}
+	return memFSOpNames[op]
+}
+
+// SetCapacity limits the total size of the files of the MemFS to the given
+// number of bytes. Writes that would grow the files beyond the capacity fail
+// with an error for which IsNoSpaceError returns true, which simulates a full
+// disk. The space used by a file is reclaimed as soon as its last link is
+// removed, even if the file is still open. When a capacity is set,
+// GetDiskUsage reports the usage of the MemFS relative to it. A capacity of
+// zero, the default, removes the limit.
+func (y *MemFS) SetCapacity(capacity int64) {
+	y.faults.Lock()
+	defer y.faults.Unlock()
+	y.faults.capacity = capacity
+}
+
+// SetInjector sets a function that is called before each operation on the
+// MemFS or one of its files, with the operation and the path of the file or
+// directory it applies to (the old path for Link and Rename). If the function
+// returns an error, the operation fails with that error without taking
+// effect. A nil function removes any existing injector.
+//
+// The injector is called without any of the MemFS's locks held, and may be
+// called concurrently.
+func (y *MemFS) SetInjector(inject func(op MemFSOp, path string) error) {
+	y.faults.Lock()
+	defer y.faults.Unlock()
+	y.faults.inject = inject
+}
+
+// SetLatency makes each subsequent operation of the given type on the MemFS
+// or one of its files sleep for the given duration before taking effect. A
+// zero duration removes the latency.
+func (y *MemFS) SetLatency(op MemFSOp, latency time.Duration) {
+	y.faults.Lock()
+	defer y.faults.Unlock()
+	y.faults.latency[op] = latency
+}
+
+// maybeInject applies the latency and the injector configured for the given
+// operation, returning the error of the injector if any.
+func (y *MemFS) maybeInject(op MemFSOp, path string) error {
+	y.faults.RLock()
+	latency, inject := y.faults.latency[op], y.faults.inject
+	y.faults.RUnlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if inject != nil {
+		return inject(op, path)
+	}
+	return nil
+}
+
+func (y *MemFS) capacity() int64 {
+	y.faults.RLock()
+	defer y.faults.RUnlock()
+	return y.faults.capacity
+}
+
+// checkCapacity returns an error if growing the file by the given number of
+// bytes would exceed the capacity of the MemFS. y.faults.growMu must be held.
+func (y *MemFS) checkCapacity(path string, capacity int64, grow int) error {
+	if grow <= 0 {
+		return nil
+	}
+	if y.usedBytes()+int64(grow) > capacity {
+		return &os.PathError{Op: "write", Path: path, Err: errNoSpace}
+	}
+	return nil
+}
+
+// usedBytes returns the total size of the files of the MemFS.
+func (y *MemFS) usedBytes() int64 {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	var used int64
+	seen := make(map[*memNode]struct{})
+	var visit func(n *memNode)
+	visit = func(n *memNode) {
+		if _, ok := seen[n]; ok {
+			return
+		}
+		seen[n] = struct{}{}
+		if !n.isDir {
+			n.mu.Lock()
+			used += int64(len(n.mu.data))
+			n.mu.Unlock()
+			return
+		}
+		for _, c := range n.children {
+			visit(c)
+		}
+	}
+	visit(y.root)
+	return used
+}
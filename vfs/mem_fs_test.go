@@ -5,13 +5,16 @@
 package vfs
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -393,3 +396,110 @@ func TestStrictFS(t *testing.T) {
 	}
 	runTestCases(t, testCases, fs)
 }
+
+func TestMemFSCapacity(t *testing.T) {
+	fs := NewMem()
+	_, err := fs.GetDiskUsage("")
+	require.Equal(t, ErrUnsupported, err)
+
+	fs.SetCapacity(100)
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 60))
+	require.NoError(t, err)
+	require.NoError(t, fs.Link("foo", "bar"))
+	g, err := fs.Create("baz")
+	require.NoError(t, err)
+	_, err = g.Write(make([]byte, 30))
+	require.NoError(t, err)
+
+	// Hard links don't use more space.
+	usage, err := fs.GetDiskUsage("")
+	require.NoError(t, err)
+	require.Equal(t, DiskUsage{AvailBytes: 10, TotalBytes: 100, UsedBytes: 90}, usage)
+
+	// A write beyond the capacity fails, leaving the file unchanged.
+	_, err = g.Write(make([]byte, 20))
+	require.True(t, IsNoSpaceError(err), "%v", err)
+	stat, err := g.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, 30, stat.Size())
+
+	// Removing the last link of a file reclaims its space.
+	require.NoError(t, fs.Remove("foo"))
+	_, err = g.Write(make([]byte, 20))
+	require.True(t, IsNoSpaceError(err), "%v", err)
+	require.NoError(t, fs.Remove("bar"))
+	_, err = g.Write(make([]byte, 20))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, g.Close())
+
+	// Without a capacity, writes always succeed.
+	fs.SetCapacity(0)
+	g, err = fs.Create("baz")
+	require.NoError(t, err)
+	_, err = g.Write(make([]byte, 200))
+	require.NoError(t, err)
+	require.NoError(t, g.Close())
+}
+
+func TestMemFSInjector(t *testing.T) {
+	fs := NewMem()
+	require.NoError(t, fs.MkdirAll("dir", 0755))
+	f, err := fs.Create("dir/foo")
+	require.NoError(t, err)
+
+	errInjected := errors.New("injected")
+	var ops []string
+	fs.SetInjector(func(op MemFSOp, path string) error {
+		ops = append(ops, fmt.Sprintf("%s %s", op, path))
+		if op == MemFSOpFileSync || op == MemFSOpRemove {
+			return errInjected
+		}
+		return nil
+	})
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, errInjected, f.Sync())
+	require.NoError(t, f.Close())
+	require.Equal(t, errInjected, fs.Remove("dir/foo"))
+	_, err = fs.Stat("dir/foo")
+	require.NoError(t, err)
+	f, err = fs.Open("dir/foo")
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 5), 0)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = fs.List("dir")
+	require.NoError(t, err)
+	require.NoError(t, fs.Rename("dir/foo", "dir/bar"))
+	require.Equal(t, []string{
+		"write dir/foo",
+		"sync dir/foo",
+		"remove dir/foo",
+		"stat dir/foo",
+		"open dir/foo",
+		"read dir/foo",
+		"list dir",
+		"rename dir/foo",
+	}, ops)
+
+	// Removing the injector stops the injection.
+	fs.SetInjector(nil)
+	require.NoError(t, fs.Remove("dir/bar"))
+}
+
+func TestMemFSLatency(t *testing.T) {
+	fs := NewMem()
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	defer f.Close()
+
+	const latency = 20 * time.Millisecond
+	fs.SetLatency(MemFSOpFileSync, latency)
+	start := time.Now()
+	require.NoError(t, f.Sync())
+	require.GreaterOrEqual(t, time.Since(start), latency)
+	fs.SetLatency(MemFSOpFileSync, 0)
+}
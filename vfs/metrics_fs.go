@@ -0,0 +1,457 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FileType classifies the files of a Pebble DB for the purpose of FS metrics
+// and rate limiting. It is derived from the name of the file (see
+// fileTypeFromName), and is coarser than base.FileType, which cannot be used
+// here as the base package depends on vfs.
+type FileType uint8
+
+const (
+	// FileTypeOther is the type of files not covered by the types below, as
+	// well as directories.
+	FileTypeOther FileType = iota
+	// FileTypeWAL is the type of write-ahead logs.
+	FileTypeWAL
+	// FileTypeTable is the type of sstables.
+	FileTypeTable
+	// FileTypeManifest is the type of MANIFEST files.
+	FileTypeManifest
+	// FileTypeOptions is the type of OPTIONS files.
+	FileTypeOptions
+	// FileTypeTemp is the type of temporary files.
+	FileTypeTemp
+
+	// NumFileTypes is the number of file types.
+	NumFileTypes
+)
+
+var fileTypeNames = [NumFileTypes]string{
+	FileTypeOther:    "other",
+	FileTypeWAL:      "wal",
+	FileTypeTable:    "table",
+	FileTypeManifest: "manifest",
+	FileTypeOptions:  "options",
+	FileTypeTemp:     "temp",
+}
+
+// String implements fmt.Stringer.
+func (t FileType) String() string {
+	if t < NumFileTypes {
+		return fileTypeNames[t]
+	}
+	return "unknown"
+}
+
+// fileTypeFromName classifies a file by its base name, following the naming
+// scheme of base.MakeFilename. It is the classifier shared by the FS wrappers
+// of this package; a test of the base package checks that it agrees with
+// base.ParseFilename.
+func fileTypeFromName(name string) FileType {
+	switch {
+	case strings.HasSuffix(name, ".log"):
+		return FileTypeWAL
+	case strings.HasSuffix(name, ".sst"):
+		return FileTypeTable
+	case strings.HasSuffix(name, ".dbtmp"):
+		return FileTypeTemp
+	case strings.HasPrefix(name, "MANIFEST-"):
+		return FileTypeManifest
+	case strings.HasPrefix(name, "OPTIONS-"):
+		return FileTypeOptions
+	}
+	return FileTypeOther
+}
+
+// MetricsOp is the type of operation tracked by a MetricsFS.
+type MetricsOp uint8
+
+const (
+	// MetricsOpCreate tracks Create and ReuseForWrite.
+	MetricsOpCreate MetricsOp = iota
+	// MetricsOpOpen tracks Open and OpenDir.
+	MetricsOpOpen
+	// MetricsOpRead tracks File.Read and File.ReadAt.
+	MetricsOpRead
+	// MetricsOpWrite tracks File.Write.
+	MetricsOpWrite
+	// MetricsOpSync tracks File.Sync, File.SyncData and File.SyncTo.
+	MetricsOpSync
+	// MetricsOpRemove tracks Remove and RemoveAll.
+	MetricsOpRemove
+	// MetricsOpRename tracks Rename.
+	MetricsOpRename
+	// MetricsOpLink tracks Link.
+	MetricsOpLink
+	// MetricsOpStat tracks Stat and File.Stat.
+	MetricsOpStat
+
+	// NumMetricsOps is the number of operation types.
+	NumMetricsOps
+)
+
+var metricsOpNames = [NumMetricsOps]string{
+	MetricsOpCreate: "create",
+	MetricsOpOpen:   "open",
+	MetricsOpRead:   "read",
+	MetricsOpWrite:  "write",
+	MetricsOpSync:   "sync",
+	MetricsOpRemove: "remove",
+	MetricsOpRename: "rename",
+	MetricsOpLink:   "link",
+	MetricsOpStat:   "stat",
+}
+
+// String implements fmt.Stringer.
+func (o MetricsOp) String() string {
+	if o < NumMetricsOps {
+		return metricsOpNames[o]
+	}
+	return "unknown"
+}
+
+// NumLatencyBuckets is the number of buckets of a LatencyHistogram.
+const NumLatencyBuckets = 26
+
+// LatencyHistogram is a histogram of operation latencies with exponentially
+// sized buckets. Bucket 0 counts operations that took less than 1µs, bucket i
+// counts operations that took [2^(i-1), 2^i) µs, and the last bucket counts
+// all operations that took 2^24µs (~16.8s) or longer. Quantiles falling in the
+// last bucket are reported as its nominal upper bound of 2^25µs (~33.5s).
+type LatencyHistogram struct {
+	Buckets [NumLatencyBuckets]uint64
+}
+
+func latencyBucket(d time.Duration) int {
+	us := uint64(d / time.Microsecond)
+	if b := bits.Len64(us); b < NumLatencyBuckets {
+		return b
+	}
+	return NumLatencyBuckets - 1
+}
+
+// latencyBucketUpperBound returns the exclusive upper bound of the latencies
+// counted by the given bucket. The last bucket is unbounded, for which the
+// upper bound of the preceding bucket doubled is returned.
+func latencyBucketUpperBound(bucket int) time.Duration {
+	return time.Duration(1<<bucket) * time.Microsecond
+}
+
+// Count returns the total number of operations recorded in the histogram.
+func (h *LatencyHistogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Buckets {
+		n += c
+	}
+	return n
+}
+
+// Quantile returns an upper bound on the latency below which the given
+// fraction (in [0, 1]) of the recorded operations fall, at the granularity of
+// the histogram buckets. It returns 0 if the histogram is empty.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q * float64(total)))
+	if target == 0 {
+		target = 1
+	}
+	var n uint64
+	for i, c := range h.Buckets {
+		n += c
+		if n >= target {
+			return latencyBucketUpperBound(i)
+		}
+	}
+	return latencyBucketUpperBound(NumLatencyBuckets - 1)
+}
+
+// OpMetrics holds the metrics of one type of operation on one type of file.
+type OpMetrics struct {
+	// Count is the number of operations, including failed ones.
+	Count uint64
+	// Errors is the number of operations that returned an error.
+	Errors uint64
+	// Bytes is the number of bytes read or written. It is only maintained for
+	// MetricsOpRead and MetricsOpWrite.
+	Bytes uint64
+	// Latency is the distribution of the latencies of the operations.
+	Latency LatencyHistogram
+}
+
+// FSMetrics holds the metrics collected by a MetricsFS, keyed by file type
+// and operation.
+type FSMetrics struct {
+	Ops [NumFileTypes][NumMetricsOps]OpMetrics
+}
+
+// String pretty-prints the metrics of the operations that occurred at least
+// once, as a table with one row per file type and operation.
+func (m *FSMetrics) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%-9s %-7s %10s %7s %12s %10s %10s\n",
+		"type", "op", "count", "errors", "bytes", "p50", "p99")
+	for t := FileType(0); t < NumFileTypes; t++ {
+		for op := MetricsOp(0); op < NumMetricsOps; op++ {
+			om := &m.Ops[t][op]
+			if om.Count == 0 {
+				continue
+			}
+			fmt.Fprintf(&buf, "%-9s %-7s %10d %7d %12d %10s %10s\n",
+				t, op, om.Count, om.Errors, om.Bytes,
+				om.Latency.Quantile(0.5), om.Latency.Quantile(0.99))
+		}
+	}
+	return buf.String()
+}
+
+type opCounters struct {
+	count   atomic.Uint64
+	errors  atomic.Uint64
+	bytes   atomic.Uint64
+	buckets [NumLatencyBuckets]atomic.Uint64
+}
+
+// MetricsFS wraps an FS and collects metrics about the operations performed
+// through it. See WithMetrics.
+type MetricsFS struct {
+	FS
+	ops [NumFileTypes][NumMetricsOps]opCounters
+}
+
+var _ FS = (*MetricsFS)(nil)
+
+// WithMetrics wraps an FS and collects per-operation counts, bytes and
+// latency histograms for the operations performed through it, keyed by file
+// type. The metrics are retrieved with MetricsFS.Metrics.
+func WithMetrics(fs FS) *MetricsFS {
+	return &MetricsFS{FS: fs}
+}
+
+// Metrics returns a snapshot of the metrics collected so far. The metrics
+// are cumulative since the MetricsFS was created.
+func (fs *MetricsFS) Metrics() FSMetrics {
+	var m FSMetrics
+	for t := range fs.ops {
+		for op := range fs.ops[t] {
+			c := &fs.ops[t][op]
+			om := &m.Ops[t][op]
+			om.Count = c.count.Load()
+			om.Errors = c.errors.Load()
+			om.Bytes = c.bytes.Load()
+			for i := range c.buckets {
+				om.Latency.Buckets[i] = c.buckets[i].Load()
+			}
+		}
+	}
+	return m
+}
+
+// Unwrap returns the wrapped FS.
+func (fs *MetricsFS) Unwrap() FS {
+	return fs.FS
+}
+
+func (fs *MetricsFS) record(t FileType, op MetricsOp, start time.Time, n int, err error) {
+	c := &fs.ops[t][op]
+	c.count.Add(1)
+	if err != nil {
+		c.errors.Add(1)
+	}
+	if n > 0 {
+		c.bytes.Add(uint64(n))
+	}
+	c.buckets[latencyBucket(time.Since(start))].Add(1)
+}
+
+func (fs *MetricsFS) fileType(name string) FileType {
+	return fileTypeFromName(fs.PathBase(name))
+}
+
+func (fs *MetricsFS) Create(name string) (File, error) {
+	start := time.Now()
+	t := fs.fileType(name)
+	f, err := fs.FS.Create(name)
+	fs.record(t, MetricsOpCreate, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsFile{File: f, fs: fs, fileType: t}, nil
+}
+
+func (fs *MetricsFS) Link(oldname, newname string) error {
+	start := time.Now()
+	err := fs.FS.Link(oldname, newname)
+	fs.record(fs.fileType(newname), MetricsOpLink, start, 0, err)
+	return err
+}
+
+func (fs *MetricsFS) Open(name string, opts ...OpenOption) (File, error) {
+	start := time.Now()
+	t := fs.fileType(name)
+	f, err := fs.FS.Open(name)
+	fs.record(t, MetricsOpOpen, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	mf := &metricsFile{File: f, fs: fs, fileType: t}
+	for _, opt := range opts {
+		opt.Apply(mf)
+	}
+	return mf, nil
+}
+
+func (fs *MetricsFS) OpenDir(name string) (File, error) {
+	start := time.Now()
+	f, err := fs.FS.OpenDir(name)
+	fs.record(FileTypeOther, MetricsOpOpen, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsFile{File: f, fs: fs, fileType: FileTypeOther}, nil
+}
+
+func (fs *MetricsFS) Remove(name string) error {
+	start := time.Now()
+	err := fs.FS.Remove(name)
+	fs.record(fs.fileType(name), MetricsOpRemove, start, 0, err)
+	return err
+}
+
+func (fs *MetricsFS) RemoveAll(name string) error {
+	start := time.Now()
+	err := fs.FS.RemoveAll(name)
+	fs.record(fs.fileType(name), MetricsOpRemove, start, 0, err)
+	return err
+}
+
+func (fs *MetricsFS) Rename(oldname, newname string) error {
+	start := time.Now()
+	err := fs.FS.Rename(oldname, newname)
+	fs.record(fs.fileType(newname), MetricsOpRename, start, 0, err)
+	return err
+}
+
+func (fs *MetricsFS) ReuseForWrite(oldname, newname string) (File, error) {
+	start := time.Now()
+	t := fs.fileType(newname)
+	f, err := fs.FS.ReuseForWrite(oldname, newname)
+	fs.record(t, MetricsOpCreate, start, 0, err)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsFile{File: f, fs: fs, fileType: t}, nil
+}
+
+func (fs *MetricsFS) Stat(name string) (os.FileInfo, error) {
+	start := time.Now()
+	info, err := fs.FS.Stat(name)
+	fs.record(fs.fileType(name), MetricsOpStat, start, 0, err)
+	return info, err
+}
+
+type metricsFile struct {
+	File
+	fs       *MetricsFS
+	fileType FileType
+}
+
+var _ File = (*metricsFile)(nil)
+
+func (f *metricsFile) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Read(p)
+	// Reaching the end of the file is not an error for the purpose of the
+	// metrics.
+	recErr := err
+	if recErr == io.EOF {
+		recErr = nil
+	}
+	f.fs.record(f.fileType, MetricsOpRead, start, n, recErr)
+	return n, err
+}
+
+func (f *metricsFile) ReadAt(p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.File.ReadAt(p, off)
+	recErr := err
+	if recErr == io.EOF {
+		recErr = nil
+	}
+	f.fs.record(f.fileType, MetricsOpRead, start, n, recErr)
+	return n, err
+}
+
+func (f *metricsFile) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := f.File.Write(p)
+	f.fs.record(f.fileType, MetricsOpWrite, start, n, err)
+	return n, err
+}
+
+func (f *metricsFile) Stat() (os.FileInfo, error) {
+	start := time.Now()
+	info, err := f.File.Stat()
+	f.fs.record(f.fileType, MetricsOpStat, start, 0, err)
+	return info, err
+}
+
+func (f *metricsFile) Sync() error {
+	start := time.Now()
+	err := f.File.Sync()
+	f.fs.record(f.fileType, MetricsOpSync, start, 0, err)
+	return err
+}
+
+func (f *metricsFile) SyncData() error {
+	start := time.Now()
+	err := f.File.SyncData()
+	f.fs.record(f.fileType, MetricsOpSync, start, 0, err)
+	return err
+}
+
+func (f *metricsFile) SyncTo(length int64) (fullSync bool, err error) {
+	start := time.Now()
+	fullSync, err = f.File.SyncTo(length)
+	f.fs.record(f.fileType, MetricsOpSync, start, 0, err)
+	return fullSync, err
+}
+
+// setIOClass forwards the I/O class to the wrapped file (see SetIOClass).
+func (f *metricsFile) setIOClass(class IOClass) {
+	SetIOClass(f.File, class)
+}
+
+// setDirectIO forwards to the wrapped file (see SetDirectIO).
+func (f *metricsFile) setDirectIO(enabled bool) error {
+	return SetDirectIO(f.File, enabled)
+}
+
+// readAtWithIOClass forwards the I/O class of the read to the wrapped file
+// (see ReadAtWithIOClass).
+func (f *metricsFile) readAtWithIOClass(p []byte, off int64, class IOClass) (int, error) {
+	start := time.Now()
+	n, err := ReadAtWithIOClass(f.File, p, off, class)
+	recErr := err
+	if recErr == io.EOF {
+		recErr = nil
+	}
+	f.fs.record(f.fileType, MetricsOpRead, start, n, recErr)
+	return n, err
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsFS(t *testing.T) {
+	fs := WithMetrics(NewMem())
+
+	f, err := fs.Create("000001.sst")
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 100))
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 50))
+	require.NoError(t, err)
+	require.NoError(t, f.Sync())
+	require.NoError(t, f.Close())
+
+	f, err = fs.Open("000001.sst")
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 10), 20)
+	require.NoError(t, err)
+	_, err = io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = fs.Create("MANIFEST-000002")
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, 7))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.Rename("MANIFEST-000002", "MANIFEST-000003"))
+
+	_, err = fs.Open("000004.log")
+	require.Error(t, err)
+	require.NoError(t, fs.Remove("000001.sst"))
+
+	m := fs.Metrics()
+	table := &m.Ops[FileTypeTable]
+	require.Equal(t, uint64(1), table[MetricsOpCreate].Count)
+	require.Equal(t, uint64(2), table[MetricsOpWrite].Count)
+	require.Equal(t, uint64(150), table[MetricsOpWrite].Bytes)
+	require.Equal(t, uint64(2), table[MetricsOpWrite].Latency.Count())
+	require.Equal(t, uint64(1), table[MetricsOpSync].Count)
+	require.Equal(t, uint64(1), table[MetricsOpOpen].Count)
+	require.Equal(t, uint64(160), table[MetricsOpRead].Bytes)
+	require.Equal(t, uint64(0), table[MetricsOpRead].Errors)
+	require.Equal(t, uint64(1), table[MetricsOpRemove].Count)
+
+	manifest := &m.Ops[FileTypeManifest]
+	require.Equal(t, uint64(7), manifest[MetricsOpWrite].Bytes)
+	require.Equal(t, uint64(1), manifest[MetricsOpRename].Count)
+
+	wal := &m.Ops[FileTypeWAL]
+	require.Equal(t, uint64(1), wal[MetricsOpOpen].Count)
+	require.Equal(t, uint64(1), wal[MetricsOpOpen].Errors)
+
+	require.Contains(t, m.String(), "table     write            2       0          150")
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h LatencyHistogram
+	require.Equal(t, time.Duration(0), h.Quantile(0.5))
+
+	for _, d := range []time.Duration{
+		0, 500 * time.Nanosecond, 3 * time.Microsecond, 3 * time.Microsecond, time.Hour,
+	} {
+		h.Buckets[latencyBucket(d)]++
+	}
+	require.Equal(t, uint64(5), h.Count())
+	require.Equal(t, uint64(2), h.Buckets[0])
+	require.Equal(t, uint64(2), h.Buckets[2])
+	require.Equal(t, uint64(1), h.Buckets[NumLatencyBuckets-1])
+	require.Equal(t, time.Microsecond, h.Quantile(0.2))
+	require.Equal(t, 4*time.Microsecond, h.Quantile(0.5))
+	require.Equal(t, latencyBucketUpperBound(NumLatencyBuckets-1), h.Quantile(1))
+}
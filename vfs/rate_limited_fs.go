@@ -0,0 +1,264 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/cockroachdb/pebble/internal/rate"
+)
+
+// IOClass identifies the source of an I/O operation for the purpose of rate
+// limiting.
+type IOClass uint8
+
+const (
+	// IOClassOther is the class of I/O that is not attributed to any of the
+	// classes below, such as reads and writes of the MANIFEST and OPTIONS
+	// files.
+	IOClassOther IOClass = iota
+	// IOClassWAL is the class of reads and writes of write-ahead logs.
+	IOClassWAL
+	// IOClassFlush is the class of writes of sstables produced by flushes.
+	IOClassFlush
+	// IOClassCompaction is the class of writes of sstables produced by
+	// compactions, and of sstable reads that are not attributed to an
+	// iterator.
+	IOClassCompaction
+	// IOClassIterator is the class of sstable reads performed by user
+	// iterators and Get operations.
+	IOClassIterator
+
+	numIOClasses
+)
+
+var ioClassNames = [numIOClasses]string{
+	IOClassOther:      "other",
+	IOClassWAL:        "wal",
+	IOClassFlush:      "flush",
+	IOClassCompaction: "compaction",
+	IOClassIterator:   "iterator",
+}
+
+// String implements fmt.Stringer.
+func (c IOClass) String() string {
+	if c < numIOClasses {
+		return ioClassNames[c]
+	}
+	return "unknown"
+}
+
+type ioClassKey struct{}
+
+// ContextWithIOClass returns a context that attributes the I/O performed on
+// its behalf to the given class.
+func ContextWithIOClass(ctx context.Context, class IOClass) context.Context {
+	return context.WithValue(ctx, ioClassKey{}, class)
+}
+
+// IOClassFromContext returns the class attached to the context by
+// ContextWithIOClass, if any.
+func IOClassFromContext(ctx context.Context) (IOClass, bool) {
+	if ctx == nil {
+		return IOClassOther, false
+	}
+	class, ok := ctx.Value(ioClassKey{}).(IOClass)
+	return class, ok
+}
+
+// ioClassSetter is implemented by Files that attribute their I/O to a class.
+type ioClassSetter interface {
+	setIOClass(class IOClass)
+}
+
+// ioClassReaderAt is implemented by Files that can attribute individual reads
+// to a class.
+type ioClassReaderAt interface {
+	readAtWithIOClass(p []byte, off int64, class IOClass) (int, error)
+}
+
+// SetIOClass attributes all subsequent I/O on the file to the given class,
+// overriding the class derived from its name. It is a no-op if the file does
+// not support I/O classes.
+func SetIOClass(f File, class IOClass) {
+	if s, ok := f.(ioClassSetter); ok {
+		s.setIOClass(class)
+	}
+}
+
+// ReadAtWithIOClass is like f.ReadAt, except that the read is attributed to
+// the given class if the file supports I/O classes.
+func ReadAtWithIOClass(f File, p []byte, off int64, class IOClass) (int, error) {
+	if r, ok := f.(ioClassReaderAt); ok {
+		return r.readAtWithIOClass(p, off, class)
+	}
+	return f.ReadAt(p, off)
+}
+
+// IOLimiter decides when I/O may proceed.
+type IOLimiter interface {
+	// Wait blocks until n bytes of I/O of the given class may proceed.
+	Wait(class IOClass, n int)
+}
+
+// NewIOLimiter returns an IOLimiter that divides bytesPerSec of bandwidth
+// between I/O classes in proportion to the given shares. Classes without a
+// share (or with a non-positive share) are not limited. For example:
+//
+//	vfs.NewIOLimiter(100<<20, map[vfs.IOClass]int{
+//		vfs.IOClassFlush:      2,
+//		vfs.IOClassCompaction: 1,
+//		vfs.IOClassIterator:   1,
+//	})
+//
+// allows flushes to write 50 MB/s, compactions 25 MB/s and iterators to read
+// 25 MB/s, while WAL and other I/O is unrestricted. Leaving the WAL unlimited
+// is usually desirable as throttling it directly stalls user writes.
+func NewIOLimiter(bytesPerSec int64, shares map[IOClass]int) IOLimiter {
+	var total int
+	for _, share := range shares {
+		if share > 0 {
+			total += share
+		}
+	}
+	l := &ioLimiter{}
+	for class, share := range shares {
+		if share <= 0 || class >= numIOClasses {
+			continue
+		}
+		limit := bytesPerSec * int64(share) / int64(total)
+		if limit < 1 {
+			limit = 1
+		}
+		l.limiters[class] = rate.NewLimiter(rate.Limit(limit), int(limit))
+	}
+	return l
+}
+
+type ioLimiter struct {
+	limiters [numIOClasses]*rate.Limiter
+}
+
+func (l *ioLimiter) Wait(class IOClass, n int) {
+	if class >= numIOClasses || l.limiters[class] == nil {
+		return
+	}
+	lim := l.limiters[class]
+	// A single reservation cannot exceed the burst, so large operations are
+	// admitted in burst-sized chunks.
+	burst := lim.Burst()
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		r := lim.ReserveN(time.Now(), chunk)
+		if d := r.Delay(); d > 0 {
+			time.Sleep(d)
+		}
+		n -= chunk
+	}
+}
+
+// WithRateLimiting wraps an FS so that reads and writes of file contents are
+// admitted by the given limiter, providing a single enforcement point for
+// I/O bandwidth.
+//
+// The class of a file's I/O is derived from its name: WAL files are
+// IOClassWAL, sstables are IOClassCompaction and all other files are
+// IOClassOther. The class of a file's writes can be overridden with
+// SetIOClass, which Pebble uses to distinguish flushes from compactions, and
+// the class of individual reads with ReadAtWithIOClass, which Pebble uses to
+// attribute reads performed by iterators. Syncs and metadata operations are
+// never limited.
+func WithRateLimiting(fs FS, limiter IOLimiter) FS {
+	return &rateLimitedFS{
+		FS:      fs,
+		limiter: limiter,
+	}
+}
+
+type rateLimitedFS struct {
+	FS
+	limiter IOLimiter
+}
+
+var _ FS = (*rateLimitedFS)(nil)
+
+func (fs *rateLimitedFS) Create(name string) (File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.newFile(f, name), nil
+}
+
+func (fs *rateLimitedFS) Open(name string, opts ...OpenOption) (File, error) {
+	f, err := fs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	rf := fs.newFile(f, name)
+	for _, opt := range opts {
+		opt.Apply(rf)
+	}
+	return rf, nil
+}
+
+func (fs *rateLimitedFS) ReuseForWrite(oldname, newname string) (File, error) {
+	f, err := fs.FS.ReuseForWrite(oldname, newname)
+	if err != nil {
+		return nil, err
+	}
+	return fs.newFile(f, newname), nil
+}
+
+func (fs *rateLimitedFS) newFile(f File, name string) *rateLimitedFile {
+	class := IOClassOther
+	switch fileTypeFromName(fs.PathBase(name)) {
+	case FileTypeWAL:
+		class = IOClassWAL
+	case FileTypeTable:
+		class = IOClassCompaction
+	}
+	return &rateLimitedFile{File: f, limiter: fs.limiter, class: class}
+}
+
+type rateLimitedFile struct {
+	File
+	limiter IOLimiter
+	class   IOClass
+}
+
+var _ File = (*rateLimitedFile)(nil)
+
+func (f *rateLimitedFile) setIOClass(class IOClass) {
+	f.class = class
+}
+
+// setDirectIO forwards to the wrapped file (see SetDirectIO).
+func (f *rateLimitedFile) setDirectIO(enabled bool) error {
+	return SetDirectIO(f.File, enabled)
+}
+
+func (f *rateLimitedFile) Read(p []byte) (int, error) {
+	f.limiter.Wait(f.class, len(p))
+	return f.File.Read(p)
+}
+
+func (f *rateLimitedFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.readAtWithIOClass(p, off, f.class)
+}
+
+func (f *rateLimitedFile) readAtWithIOClass(p []byte, off int64, class IOClass) (int, error) {
+	f.limiter.Wait(class, len(p))
+	return f.File.ReadAt(p, off)
+}
+
+func (f *rateLimitedFile) Write(p []byte) (int, error) {
+	f.limiter.Wait(f.class, len(p))
+	return f.File.Write(p)
+}
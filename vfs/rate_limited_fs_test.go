@@ -0,0 +1,88 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingIOLimiter struct {
+	mu    sync.Mutex
+	bytes [numIOClasses]int
+}
+
+func (l *recordingIOLimiter) Wait(class IOClass, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bytes[class] += n
+}
+
+func TestRateLimitedFSClasses(t *testing.T) {
+	limiter := &recordingIOLimiter{}
+	fs := WithRateLimiting(NewMem(), limiter)
+
+	write := func(name string, n int, class IOClass, setClass bool) {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		if setClass {
+			SetIOClass(f, class)
+		}
+		_, err = f.Write(make([]byte, n))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	write("000001.log", 1, IOClassWAL, false)
+	write("000002.sst", 10, IOClassCompaction, false)
+	write("000003.sst", 100, IOClassFlush, true)
+	write("MANIFEST-000001", 1000, IOClassOther, false)
+	require.Equal(t, [numIOClasses]int{
+		IOClassOther:      1000,
+		IOClassWAL:        1,
+		IOClassFlush:      100,
+		IOClassCompaction: 10,
+	}, limiter.bytes)
+
+	*limiter = recordingIOLimiter{}
+	f, err := fs.Open("000003.sst")
+	require.NoError(t, err)
+	_, err = f.ReadAt(make([]byte, 10), 0)
+	require.NoError(t, err)
+	_, err = ReadAtWithIOClass(f, make([]byte, 20), 0, IOClassIterator)
+	require.NoError(t, err)
+	_, err = io.ReadAll(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, 20, limiter.bytes[IOClassIterator])
+	require.LessOrEqual(t, 110, limiter.bytes[IOClassCompaction])
+}
+
+func TestIOLimiter(t *testing.T) {
+	l := NewIOLimiter(20000, map[IOClass]int{
+		IOClassCompaction: 1,
+		IOClassIterator:   1,
+	})
+
+	// Unlimited classes never wait.
+	start := time.Now()
+	l.Wait(IOClassWAL, 1<<30)
+	require.Less(t, time.Since(start), time.Second)
+
+	// Each limited class receives half of the bandwidth. The first 10000
+	// bytes are admitted immediately by the burst, and the following 5000
+	// bytes require an additional 0.5s.
+	start = time.Now()
+	l.Wait(IOClassCompaction, 15000)
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// The classes are limited independently.
+	start = time.Now()
+	l.Wait(IOClassIterator, 5000)
+	require.Less(t, time.Since(start), 400*time.Millisecond)
+}
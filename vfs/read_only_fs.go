@@ -0,0 +1,119 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"os"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ErrReadOnly is the error returned by a read-only FS (see NewReadOnly) for
+// any operation that would modify the filesystem.
+var ErrReadOnly = errors.New("pebble/vfs: read-only filesystem")
+
+// NewReadOnly wraps an FS so that it is guaranteed to never modify the
+// underlying filesystem: every operation that would create, modify, remove or
+// sync a file or directory fails with an *os.PathError wrapping ErrReadOnly.
+//
+// Lock does not create or lock the named file, since doing so would modify
+// it; it returns a no-op io.Closer instead. Callers are responsible for
+// ensuring that the filesystem is not concurrently modified through other
+// means.
+func NewReadOnly(fs FS) FS {
+	return &readOnlyFS{FS: fs}
+}
+
+type readOnlyFS struct {
+	FS
+}
+
+var _ FS = (*readOnlyFS)(nil)
+
+func readOnlyError(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: ErrReadOnly}
+}
+
+func (fs *readOnlyFS) Create(name string) (File, error) {
+	return nil, readOnlyError("create", name)
+}
+
+func (fs *readOnlyFS) Link(oldname, newname string) error {
+	return readOnlyError("link", newname)
+}
+
+func (fs *readOnlyFS) Open(name string, opts ...OpenOption) (File, error) {
+	f, err := fs.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	rf := &readOnlyFile{File: f, name: name}
+	for _, opt := range opts {
+		opt.Apply(rf)
+	}
+	return rf, nil
+}
+
+func (fs *readOnlyFS) OpenDir(name string) (File, error) {
+	f, err := fs.FS.OpenDir(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readOnlyFile{File: f, name: name}, nil
+}
+
+func (fs *readOnlyFS) Remove(name string) error {
+	return readOnlyError("remove", name)
+}
+
+func (fs *readOnlyFS) RemoveAll(name string) error {
+	return readOnlyError("remove", name)
+}
+
+func (fs *readOnlyFS) Rename(oldname, newname string) error {
+	return readOnlyError("rename", oldname)
+}
+
+func (fs *readOnlyFS) ReuseForWrite(oldname, newname string) (File, error) {
+	return nil, readOnlyError("reuse-for-write", oldname)
+}
+
+func (fs *readOnlyFS) MkdirAll(dir string, perm os.FileMode) error {
+	return readOnlyError("mkdir", dir)
+}
+
+func (fs *readOnlyFS) Lock(name string) (io.Closer, error) {
+	return noopCloser{}, nil
+}
+
+// readOnlyFile wraps a file opened through a read-only FS, rejecting writes
+// and syncs.
+type readOnlyFile struct {
+	File
+	name string
+}
+
+var _ File = (*readOnlyFile)(nil)
+
+func (f *readOnlyFile) Write(p []byte) (int, error) {
+	return 0, readOnlyError("write", f.name)
+}
+
+func (f *readOnlyFile) Preallocate(offset, length int64) error {
+	return readOnlyError("preallocate", f.name)
+}
+
+func (f *readOnlyFile) Sync() error {
+	return readOnlyError("sync", f.name)
+}
+
+func (f *readOnlyFile) SyncData() error {
+	return readOnlyError("sync", f.name)
+}
+
+func (f *readOnlyFile) SyncTo(length int64) (fullSync bool, err error) {
+	return false, readOnlyError("sync", f.name)
+}
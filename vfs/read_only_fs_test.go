@@ -0,0 +1,64 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package vfs
+
+import (
+	"io"
+	"testing"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyFS(t *testing.T) {
+	mem := NewMem()
+	require.NoError(t, mem.MkdirAll("a", 0755))
+	f, err := mem.Create("a/foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	before := mem.String()
+
+	fs := NewReadOnly(mem)
+
+	// Reads are passed through.
+	f, err = fs.Open("a/foo")
+	require.NoError(t, err)
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	_, err = f.Write([]byte("x"))
+	require.True(t, errors.Is(err, ErrReadOnly))
+	require.True(t, errors.Is(f.Sync(), ErrReadOnly))
+	require.NoError(t, f.Close())
+
+	dir, err := fs.OpenDir("a")
+	require.NoError(t, err)
+	require.True(t, errors.Is(dir.Sync(), ErrReadOnly))
+	require.NoError(t, dir.Close())
+
+	names, err := fs.List("a")
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, names)
+
+	// Mutations are rejected.
+	_, err = fs.Create("a/bar")
+	require.True(t, errors.Is(err, ErrReadOnly))
+	_, err = fs.ReuseForWrite("a/foo", "a/bar")
+	require.True(t, errors.Is(err, ErrReadOnly))
+	require.True(t, errors.Is(fs.Link("a/foo", "a/bar"), ErrReadOnly))
+	require.True(t, errors.Is(fs.Remove("a/foo"), ErrReadOnly))
+	require.True(t, errors.Is(fs.RemoveAll("a"), ErrReadOnly))
+	require.True(t, errors.Is(fs.Rename("a/foo", "a/bar"), ErrReadOnly))
+	require.True(t, errors.Is(fs.MkdirAll("b", 0755), ErrReadOnly))
+
+	// Locking does not create the lock file.
+	l, err := fs.Lock("a/LOCK")
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	require.Equal(t, before, mem.String())
+}
@@ -159,6 +159,11 @@ func (f *syncingFile) maybeSync() error {
 	return nil
 }
 
+// setDirectIO forwards to the wrapped file (see SetDirectIO).
+func (f *syncingFile) setDirectIO(enabled bool) error {
+	return SetDirectIO(f.File, enabled)
+}
+
 func (f *syncingFile) Close() error {
 	// Sync any data that has been written but not yet synced unless the file
 	// has noSyncOnClose option explicitly set.
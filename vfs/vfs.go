@@ -363,9 +363,16 @@ func LimitedCopy(fs FS, oldname, newname string, maxBytes int64) error {
 // the hard link fails, LinkOrCopy falls back to copying the file (which may
 // also fail if oldname doesn't exist or newname already exists).
 func LinkOrCopy(fs FS, oldname, newname string) error {
+	_, err := LinkOrCopyLinked(fs, oldname, newname)
+	return err
+}
+
+// LinkOrCopyLinked is like LinkOrCopy, and additionally returns whether newname
+// was created as a hard link rather than as a copy.
+func LinkOrCopyLinked(fs FS, oldname, newname string) (linked bool, _ error) {
 	err := fs.Link(oldname, newname)
 	if err == nil {
-		return nil
+		return true, nil
 	}
 	// Permit a handful of errors which we know won't be fixed by copying the
 	// file. Note that we don't check for the specifics of the error code as it
@@ -375,9 +382,9 @@ func LinkOrCopy(fs FS, oldname, newname string) error {
 	// ERROR_INVALID_PARAMETER. Rather that such OS specific checks, we fall back
 	// to always trying to copy if hard-linking failed.
 	if oserror.IsExist(err) || oserror.IsNotExist(err) || oserror.IsPermission(err) {
-		return err
+		return false, err
 	}
-	return Copy(fs, oldname, newname)
+	return false, Copy(fs, oldname, newname)
 }
 
 // Root returns the base FS implementation, unwrapping all nested FSs that
@@ -0,0 +1,158 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"github.com/cockroachdb/pebble/internal/base"
+	"github.com/cockroachdb/pebble/internal/keyspan"
+	"github.com/cockroachdb/pebble/sstable"
+)
+
+// This file contains the helpers to read virtual tables, which are backed by
+// the part of an sstable within the table bounds (see manifest.VirtualTable).
+// The iterators, properties and size estimates of the sstable backing a
+// virtual table are confined to the table bounds with these helpers.
+
+// virtualTableBounds returns the bounds [lower, upper) confined to the bounds of
+// the given virtual table. Nil bounds are unbounded.
+func virtualTableBounds(cmp Compare, file *fileMetadata, lower, upper []byte) ([]byte, []byte) {
+	if lower == nil || cmp(lower, file.Smallest.UserKey) < 0 {
+		lower = file.Smallest.UserKey
+	}
+	if upper == nil || cmp(upper, file.Virtual.UpperBound) > 0 {
+		upper = file.Virtual.UpperBound
+	}
+	return lower, upper
+}
+
+// virtualPointIter wraps the point iterator of the sstable backing a virtual
+// table, to confine it to the table bounds. The iterator of the sstable is
+// always bounded by the table bounds, and positioning operations are adjusted
+// accordingly, since sstable iterators leave it to their callers to respect
+// their lower bound in forward operations and their upper bound in backward
+// operations.
+type virtualPointIter struct {
+	sstable.Iterator
+	cmp  Compare
+	file *fileMetadata
+	// lower and upper are the bounds of the sstable iterator, which are the
+	// bounds of the iterator confined to the table bounds.
+	lower, upper []byte
+}
+
+var _ sstable.Iterator = (*virtualPointIter)(nil)
+
+func newVirtualPointIter(
+	iter sstable.Iterator, cmp Compare, file *fileMetadata, lower, upper []byte,
+) *virtualPointIter {
+	i := &virtualPointIter{Iterator: iter, cmp: cmp, file: file}
+	i.lower, i.upper = virtualTableBounds(cmp, file, lower, upper)
+	return i
+}
+
+// SeekGE implements internalIterator.SeekGE.
+func (i *virtualPointIter) SeekGE(key []byte, flags base.SeekGEFlags) (*InternalKey, base.LazyValue) {
+	if i.cmp(key, i.lower) < 0 {
+		key = i.lower
+	}
+	return i.Iterator.SeekGE(key, flags)
+}
+
+// SeekPrefixGE implements internalIterator.SeekPrefixGE.
+func (i *virtualPointIter) SeekPrefixGE(
+	prefix, key []byte, flags base.SeekGEFlags,
+) (*InternalKey, base.LazyValue) {
+	if i.cmp(key, i.lower) < 0 {
+		key = i.lower
+	}
+	return i.Iterator.SeekPrefixGE(prefix, key, flags)
+}
+
+// SeekLT implements internalIterator.SeekLT.
+func (i *virtualPointIter) SeekLT(key []byte, flags base.SeekLTFlags) (*InternalKey, base.LazyValue) {
+	if i.cmp(key, i.upper) > 0 {
+		key = i.upper
+	}
+	return i.Iterator.SeekLT(key, flags)
+}
+
+// First implements internalIterator.First.
+func (i *virtualPointIter) First() (*InternalKey, base.LazyValue) {
+	return i.Iterator.SeekGE(i.lower, base.SeekGEFlagsNone)
+}
+
+// Last implements internalIterator.Last.
+func (i *virtualPointIter) Last() (*InternalKey, base.LazyValue) {
+	return i.Iterator.SeekLT(i.upper, base.SeekLTFlagsNone)
+}
+
+// SetBounds implements internalIterator.SetBounds.
+func (i *virtualPointIter) SetBounds(lower, upper []byte) {
+	i.lower, i.upper = virtualTableBounds(i.cmp, i.file, lower, upper)
+	i.Iterator.SetBounds(i.lower, i.upper)
+}
+
+// truncateToVirtualTable truncates the spans of the given iterator over the
+// range deletions or range keys of the sstable backing a virtual table to the
+// table bounds.
+func truncateToVirtualTable(
+	cmp Compare, file *fileMetadata, iter keyspan.FragmentIterator,
+) keyspan.FragmentIterator {
+	return keyspan.Truncate(cmp, iter, file.Smallest.UserKey, file.Virtual.UpperBound, nil, nil)
+}
+
+// estimateTableDiskUsage returns the estimated disk usage of the keys of the
+// table within [start, end], using the reader of its sstable.
+func estimateTableDiskUsage(
+	cmp Compare, r *sstable.Reader, file *fileMetadata, start, end []byte,
+) (uint64, error) {
+	if file.Virtual != nil {
+		start, end = virtualTableBounds(cmp, file, start, end)
+		if cmp(start, end) >= 0 {
+			return 0, nil
+		}
+	}
+	return r.EstimateDiskUsage(start, end)
+}
+
+// tableProperties returns the properties of the table, given the properties
+// of its sstable. The properties of a virtual table are those of its sstable,
+// with the counts and sizes of its contents scaled by the fraction of the
+// sstable the table is estimated to span. Counts which are non-zero in the
+// sstable remain non-zero, as they are also used to tell whether the table
+// contains keys of a kind.
+func tableProperties(file *fileMetadata, props *sstable.Properties) *sstable.Properties {
+	if file.Virtual == nil || file.Virtual.BackingSize == 0 {
+		return props
+	}
+	ratio := float64(file.Size) / float64(file.Virtual.BackingSize)
+	scale := func(v uint64) uint64 {
+		if v == 0 {
+			return 0
+		}
+		if scaled := uint64(float64(v) * ratio); scaled > 0 {
+			return scaled
+		}
+		return 1
+	}
+	p := *props
+	p.DataSize = scale(p.DataSize)
+	p.NumDataBlocks = scale(p.NumDataBlocks)
+	p.NumDeletions = scale(p.NumDeletions)
+	p.NumEntries = scale(p.NumEntries)
+	p.NumMergeOperands = scale(p.NumMergeOperands)
+	p.NumRangeDeletions = scale(p.NumRangeDeletions)
+	p.NumRangeKeyDels = scale(p.NumRangeKeyDels)
+	p.NumRangeKeySets = scale(p.NumRangeKeySets)
+	p.NumRangeKeyUnsets = scale(p.NumRangeKeyUnsets)
+	p.NumValueBlocks = scale(p.NumValueBlocks)
+	p.NumValuesInValueBlocks = scale(p.NumValuesInValueBlocks)
+	p.RawKeySize = scale(p.RawKeySize)
+	p.RawRangeKeyKeySize = scale(p.RawRangeKeyKeySize)
+	p.RawRangeKeyValueSize = scale(p.RawRangeKeyValueSize)
+	p.RawValueSize = scale(p.RawValueSize)
+	p.ValueBlocksSize = scale(p.ValueBlocksSize)
+	return &p
+}
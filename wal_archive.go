@@ -0,0 +1,139 @@
+// Copyright 2023 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/errors/oserror"
+	"github.com/cockroachdb/pebble/internal/base"
+)
+
+// WALArchiveRetention configures the pruning of the WALs archived by the
+// ArchiveCleaner, so that the archive does not grow without bound. The zero
+// value retains the archived WALs forever.
+type WALArchiveRetention struct {
+	// MaxAge, if positive, prunes the archived WALs which were last written
+	// more than MaxAge ago.
+	MaxAge time.Duration
+	// MaxSize, if positive, prunes the oldest archived WALs until the total
+	// size of the archived WALs is at most MaxSize.
+	MaxSize int64
+	// SinceLastBackup anchors the retention to the last successful backup of
+	// the DB, reported with DB.SetLastBackupTime: the archived WALs which
+	// were last written before the backup started are pruned, since the
+	// backup contains their writes, while the later ones are retained
+	// regardless of MaxAge and MaxSize, since they are needed to replay the
+	// writes made since the backup. Until a backup is reported, no archived
+	// WAL is pruned.
+	SinceLastBackup bool
+}
+
+func (r WALArchiveRetention) enabled() bool {
+	return r.MaxAge > 0 || r.MaxSize > 0 || r.SinceLastBackup
+}
+
+// SetLastBackupTime reports that a backup of the DB (e.g. a checkpoint which
+// was then copied to remote storage) which started at the given time has
+// completed successfully. With Options.Experimental.WALArchiveRetention.
+// SinceLastBackup, the archived WALs which the backup makes redundant are
+// pruned. Reporting a backup time earlier than the one previously reported
+// has no effect.
+func (d *DB) SetLastBackupTime(t time.Time) error {
+	if err := d.closed.Load(); err != nil {
+		panic(err)
+	}
+	d.walArchive.Lock()
+	if t.After(d.walArchive.lastBackup) {
+		d.walArchive.lastBackup = t
+	}
+	d.walArchive.Unlock()
+	return d.pruneWALArchive()
+}
+
+// archivedWAL describes a WAL in the archive directory.
+type archivedWAL struct {
+	fileNum FileNum
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// pruneWALArchive removes the archived WALs which are not retained by
+// Options.Experimental.WALArchiveRetention. It is a no-op unless the
+// ArchiveCleaner is used. db.mu must NOT be held when calling this method.
+func (d *DB) pruneWALArchive() error {
+	retention := d.opts.Experimental.WALArchiveRetention
+	if _, ok := d.opts.Cleaner.(ArchiveCleaner); !ok || !retention.enabled() {
+		return nil
+	}
+	d.walArchive.Lock()
+	defer d.walArchive.Unlock()
+
+	fs := d.opts.FS
+	dir := fs.PathJoin(d.walDirname, "archive")
+	ls, err := fs.List(dir)
+	if err != nil {
+		if oserror.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var wals []archivedWAL
+	for _, filename := range ls {
+		fileType, fileNum, ok := base.ParseFilename(fs, filename)
+		if !ok || fileType != fileTypeLog {
+			continue
+		}
+		path := fs.PathJoin(dir, filename)
+		stat, err := fs.Stat(path)
+		if err != nil {
+			if oserror.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		wals = append(wals, archivedWAL{
+			fileNum: fileNum,
+			path:    path,
+			size:    stat.Size(),
+			modTime: stat.ModTime(),
+		})
+	}
+	sort.Slice(wals, func(i, j int) bool {
+		return wals[i].fileNum < wals[j].fileNum
+	})
+
+	// The archived WALs are pruned oldest first, so that the retained WALs
+	// are always contiguous.
+	var pruned int
+	if retention.SinceLastBackup {
+		for pruned < len(wals) && wals[pruned].modTime.Before(d.walArchive.lastBackup) {
+			pruned++
+		}
+	} else {
+		var totalSize int64
+		for _, w := range wals {
+			totalSize += w.size
+		}
+		now := d.timeNow()
+		for ; pruned < len(wals); pruned++ {
+			w := wals[pruned]
+			if (retention.MaxAge <= 0 || now.Sub(w.modTime) <= retention.MaxAge) &&
+				(retention.MaxSize <= 0 || totalSize <= retention.MaxSize) {
+				break
+			}
+			totalSize -= w.size
+		}
+	}
+	for _, w := range wals[:pruned] {
+		if err := fs.Remove(w.path); err != nil && !oserror.IsNotExist(err) {
+			return err
+		}
+		d.opts.Logger.Infof("pruned archived WAL %s", w.fileNum)
+	}
+	return nil
+}